@@ -70,6 +70,63 @@ var (
 	featureMultishareBackups        = flag.Bool("feature-multishare-backups", false, "if set to true, the multishare backups will be enabled. enable-multishare must be set to true as well")
 	featureNFSExportOptionsOnCreate = flag.Bool("feature-nfs-export-options", false, "if set to true, the driver will accpet nfs-export-options-on-create parameter and configure IP Access rules")
 
+	// Feature backup schedule specific parameters, kube-api-qps, kube-api-burst, kubeconfig and the leader election parameters are shared with the stateful driver.
+	featureBackupSchedule    = flag.Bool("feature-backup-schedule", false, "if set to true, the controller will take Filestore backups on the schedules of the BackupSchedule objects.")
+	backupScheduleSyncPeriod = flag.Duration("backup-schedule-sync-period", 1*time.Minute, "Duration, the interval the backup schedules are checked for backups to take. Defaults to 1 minute.")
+
+	// Feature pre-flight validation of the network, reserved IP range and KMS key of new instances.
+	featurePreflightValidation  = flag.Bool("feature-preflight-validation", false, "if set to true, the controller will check that the network, reserved IP range and KMS key of a new Filestore instance exist and are usable before creating it.")
+	preflightValidationCacheTTL = flag.Duration("preflight-validation-cache-ttl", 5*time.Minute, "Duration, how long the result of the lookup of a network, reserved IP range or KMS key is reused by the pre-flight validation. Defaults to 5 minutes.")
+
+	// Feature mount health monitor of the node driver.
+	featureMountHealthMonitor = flag.Bool("feature-mount-health-monitor", false, "if set to true, the node driver will probe the mounts of the staged volumes, and report unhealthy mounts as abnormal volume conditions.")
+	mountHealthProbeInterval  = flag.Duration("mount-health-probe-interval", 1*time.Minute, "Duration, the interval the mounts of the staged volumes are probed. Defaults to 1 minute.")
+	mountHealthProbeTimeout   = flag.Duration("mount-health-probe-timeout", 10*time.Second, "Duration, how long a probe waits for a mount to respond before reporting it as not responding. Defaults to 10 seconds.")
+	mountHealthAutoRemount    = flag.Bool("mount-health-auto-remount", false, "if set to true, the node driver will lazily unmount and mount again the staging path of an unhealthy volume, and bind mount its publish paths again. feature-mount-health-monitor must be set to true as well.")
+
+	featureVolumeMountGroup = flag.Bool("feature-volume-mount-group", false, "if set to true, the node driver will advertise the VOLUME_MOUNT_GROUP capability, and set the group of the root directory of the volumes to the fsGroup of the pods instead of the kubelet changing the ownership of every file of the volumes.")
+
+	featureEphemeralVolumes = flag.Bool("feature-ephemeral-volumes", false, "if set to true, the node driver will mount the CSI ephemeral inline volumes of the pods, the existing shares named by their ip and volume attributes. The CSIDriver object must list the Ephemeral volume lifecycle mode as well.")
+
+	featureNFSMountStatsMetrics = flag.Bool("feature-nfs-mountstats-metrics", false, "if set to true, the node driver will export the NFS client statistics of the staged volumes, read from /proc/self/mountstats, as metrics. http-endpoint must be set as well.")
+
+	// Feature mount policy of the node driver.
+	featureMountPolicy    = flag.Bool("feature-mount-policy", false, "if set to true, the node driver will reject the mount options of the volumes that are unsafe or conflict with the volume, pin the NFS version to the file protocol of the volume, and add the default mount options of mount-policy-config. Must be set on the controller driver as well to record the tier of the new volumes.")
+	mountPolicyConfigFile = flag.String("mount-policy-config", "", "path of the JSON file of the default mount options by tier and protocol, and of the denied mount options, of feature-mount-policy. Only the built-in policy is applied if empty.")
+
+	// Feature volume topology of the controller and node drivers.
+	featureVolumeTopology = flag.Bool("feature-volume-topology", false, "if set to true, the node driver will report the region of the node along with its zone, and the controller driver will constrain the volumes to the zone or region their instance is accessible from. Must be set on both the controller and node drivers.")
+
+	// Feature sub-directory provisioning of the controller driver.
+	featureSubDirectoryProvisioning = flag.Bool("feature-subdirectory-provisioning", false, "if set to true, the controller driver will provision volumes with the subdirectory=true StorageClass parameter as sub-directories of the share of basic instances. The controller needs to mount the shares, which requires a privileged container.")
+	subDirectoryWorkingDir          = flag.String("subdirectory-working-dir", "/tmp/filestore-csi-subdirectory", "directory the controller driver mounts the shares of the instances of sub-directory volumes under")
+
+	// Feature encryption in transit of the node driver.
+	featureEncryptionInTransit       = flag.Bool("feature-encryption-in-transit", false, "if set to true, the node driver will mount the volumes with the encryptionInTransit volume attribute through a TLS tunnel run by stunnel. The volumes must use the NFS_V4_1 protocol, feature-nfs-v4 must be set to true as well.")
+	encryptionInTransitStunnelPath   = flag.String("encryption-in-transit-stunnel-path", "/usr/bin/stunnel", "path of the stunnel binary running the TLS tunnels")
+	encryptionInTransitStateDir      = flag.String("encryption-in-transit-state-dir", "/csi/encryption-in-transit", "directory the TLS tunnels are saved in, to restore them when the node driver restarts. It must persist across restarts of the node driver.")
+	encryptionInTransitCAFile        = flag.String("encryption-in-transit-ca-file", "", "CA bundle the certificates of the NFS servers are verified with. The volumes encrypted in transit are not staged if empty, unless encryption-in-transit-insecure-skip-verify is set.")
+	encryptionInTransitServerName    = flag.String("encryption-in-transit-server-name", "", "name the certificates of the NFS servers are checked against. The IP of the server is checked if empty.")
+	encryptionInTransitSkipVerify    = flag.Bool("encryption-in-transit-insecure-skip-verify", false, "if set to true, the TLS tunnels are started without verifying the certificates of the NFS servers when encryption-in-transit-ca-file is empty. This is insecure.")
+	encryptionInTransitServerPort    = flag.Int("encryption-in-transit-server-port", 2049, "port of the TLS endpoint of the NFS servers")
+	encryptionInTransitProbeInterval = flag.Duration("encryption-in-transit-probe-interval", 30*time.Second, "Duration, the interval the TLS tunnels are probed, an unresponsive tunnel is restarted. Defaults to 30 seconds.")
+
+	// Feature Kerberos of the node driver.
+	featureKerberos            = flag.Bool("feature-kerberos", false, "if set to true, the node driver will mount the volumes with the securityFlavor volume attribute with the Kerberos credentials obtained from the keytab of their node stage secrets. The volumes must use the NFS_V4_1 protocol, feature-nfs-v4 must be set to true as well. rpc.gssd must run with the credential cache directory.")
+	kerberosKinitPath          = flag.String("kerberos-kinit-path", "/usr/bin/kinit", "path of the kinit binary obtaining the Kerberos tickets of the volumes")
+	kerberosStateDir           = flag.String("kerberos-state-dir", "/csi/kerberos", "directory the keytabs of the staged volumes are saved in, to refresh their tickets after the node driver restarts. It must persist across restarts of the node driver.")
+	kerberosCredentialCacheDir = flag.String("kerberos-credential-cache-dir", "/csi/kerberos-ccache", "directory the credential caches of the volumes are written to, which rpc.gssd must look up with its -d option")
+	kerberosRefreshInterval    = flag.Duration("kerberos-refresh-interval", time.Hour, "Duration, the interval the Kerberos tickets of the volumes are obtained again. It must be shorter than the ticket lifetime. Defaults to 1 hour.")
+
+	// Feature share migration of the stateful multishare controller and the node driver.
+	featureShareMigration          = flag.Bool("feature-share-migration", false, "if set to true, the controller will move the shares of multishare instances to the instances of the ShareMigration objects, and the node driver will mount the migrated shares from their new instance. feature-stateful-multishare and feature-multishare-backups must be set to true as well on the controller driver. feature-mount-health-monitor must be set to true as well on the node driver, which remounts the staged volumes from the new instance.")
+	shareMigrationRelocateInterval = flag.Duration("share-migration-relocate-interval", 1*time.Minute, "Duration, the interval the node driver checks whether the shares of its staged multishare volumes have been migrated to another instance. Defaults to 1 minute.")
+
+	// Feature defragmentation of the instance pools of the stateful multishare controller.
+	featureMultishareDefrag  = flag.Bool("feature-multishare-defrag", false, "if set to true, the controller will periodically compute how many instances of each multishare instance pool could be freed by repacking their shares, report the plan in the status of the InstanceInfo objects, and execute it in the maintenance window of the StorageClasses that enable it if multishare-defrag-execute-plans is set to true. feature-stateful-multishare must be set to true as well.")
+	multishareDefragInterval = flag.Duration("multishare-defrag-interval", 1*time.Hour, "Duration, the interval the consolidation plans of the multishare instance pools are computed. Defaults to 1 hour.")
+	multishareDefragExecute  = flag.Bool("multishare-defrag-execute-plans", false, "if set to true, the consolidation plans of the StorageClasses with defrag-mode execute are executed with share migrations, which export the migrated shares read only until their volumes are mounted from the new instance. feature-share-migration must be set to true as well. Defaults to false, the plans are only reported.")
+
 	// Feature stateful CSI driver specific parameters
 	featureStateful      = flag.Bool("feature-stateful-multishare", false, "if set to true, the controller will run stateful multishare controller, if set to true, enable-multishare must be set to true as well")
 	statefulResyncPeriod = flag.Duration("stateful-resync-period", 15*time.Minute, "Resync interval of the stateful driver.")
@@ -168,6 +225,28 @@ func main() {
 		}
 	}
 
+	if *runController && *featureShareMigration && (!*featureStateful || !*featureMultishareBackups) {
+		klog.Fatalf("feature-stateful-multishare and feature-multishare-backups have to be set when share migration feature is enabled")
+	}
+
+	if *runController && *featureMultishareDefrag && !*featureStateful {
+		klog.Fatalf("feature-stateful-multishare has to be set when multishare defragmentation feature is enabled")
+	}
+
+	if *runController && *featureMultishareDefrag && *multishareDefragExecute && !*featureShareMigration {
+		klog.Fatalf("feature-share-migration has to be set when the multishare consolidation plans are executed")
+	}
+
+	if *runNode && *featureNFSMountStatsMetrics {
+		if *httpEndpoint == "" {
+			klog.Fatalf("http-endpoint has to be set when NFS mountstats metrics feature is enabled")
+		}
+		if mm == nil {
+			mm = metrics.NewMetricsManager()
+			mm.InitializeHttpHandler(*httpEndpoint, *metricsPath)
+		}
+	}
+
 	featureOptions := &driver.GCFSDriverFeatureOptions{
 		FeatureLockRelease: &driver.FeatureLockRelease{
 			Enabled:    *featureLockRelease,
@@ -179,6 +258,7 @@ func main() {
 				SyncPeriod:     *lockReleaseSyncPeriod,
 				MetricEndpoint: *httpEndpoint,
 				MetricPath:     *metricsPath,
+				MetricsManager: mm,
 			},
 		},
 		FeatureMaxSharesPerInstance: &driver.FeatureMaxSharesPerInstance{
@@ -209,6 +289,75 @@ func main() {
 		FeatureNFSv4Support: &driver.FeatureNFSv4Support{
 			Enabled: *featureNFSv4Support,
 		},
+		FeatureBackupSchedule: &driver.FeatureBackupSchedule{
+			Enabled:                     *featureBackupSchedule,
+			KubeAPIQPS:                  *kubeAPIQPS,
+			KubeAPIBurst:                *kubeAPIBurst,
+			KubeConfig:                  *kubeconfig,
+			ResyncPeriod:                *coreInformerResyncPeriod,
+			SyncPeriod:                  *backupScheduleSyncPeriod,
+			LeaderElection:              *leaderElection,
+			LeaderElectionNamespace:     *leaderElectionNamespace,
+			LeaderElectionLeaseDuration: *leaderElectionLeaseDuration,
+			LeaderElectionRenewDeadline: *leaderElectionRenewDeadline,
+			LeaderElectionRetryPeriod:   *leaderElectionRetryPeriod,
+		},
+		FeatureMountHealthMonitor: &driver.FeatureMountHealthMonitor{
+			Enabled:       *featureMountHealthMonitor,
+			ProbeInterval: *mountHealthProbeInterval,
+			ProbeTimeout:  *mountHealthProbeTimeout,
+			Remount:       *mountHealthAutoRemount,
+		},
+		FeatureNFSMountStatsMetrics: &driver.FeatureNFSMountStatsMetrics{
+			Enabled: *featureNFSMountStatsMetrics,
+		},
+		FeatureVolumeMountGroup: &driver.FeatureVolumeMountGroup{
+			Enabled: *featureVolumeMountGroup,
+		},
+		FeatureEphemeralVolumes: &driver.FeatureEphemeralVolumes{
+			Enabled: *featureEphemeralVolumes,
+		},
+		FeatureVolumeTopology: &driver.FeatureVolumeTopology{
+			Enabled: *featureVolumeTopology,
+		},
+		FeatureMountPolicy: &driver.FeatureMountPolicy{
+			Enabled:    *featureMountPolicy,
+			ConfigFile: *mountPolicyConfigFile,
+		},
+		FeatureSubDirectoryProvisioning: &driver.FeatureSubDirectoryProvisioning{
+			Enabled:    *featureSubDirectoryProvisioning,
+			WorkingDir: *subDirectoryWorkingDir,
+		},
+		FeatureEncryptionInTransit: &driver.FeatureEncryptionInTransit{
+			Enabled:            *featureEncryptionInTransit,
+			StunnelPath:        *encryptionInTransitStunnelPath,
+			StateDir:           *encryptionInTransitStateDir,
+			CAFile:             *encryptionInTransitCAFile,
+			ServerName:         *encryptionInTransitServerName,
+			InsecureSkipVerify: *encryptionInTransitSkipVerify,
+			ServerPort:         *encryptionInTransitServerPort,
+			ProbeInterval:      *encryptionInTransitProbeInterval,
+		},
+		FeatureKerberos: &driver.FeatureKerberos{
+			Enabled:            *featureKerberos,
+			KinitPath:          *kerberosKinitPath,
+			StateDir:           *kerberosStateDir,
+			CredentialCacheDir: *kerberosCredentialCacheDir,
+			RefreshInterval:    *kerberosRefreshInterval,
+		},
+		FeaturePreflightValidation: &driver.FeaturePreflightValidation{
+			Enabled:  *featurePreflightValidation,
+			CacheTTL: *preflightValidationCacheTTL,
+		},
+		FeatureShareMigration: &driver.FeatureShareMigration{
+			Enabled:          *featureShareMigration,
+			RelocateInterval: *shareMigrationRelocateInterval,
+		},
+		FeatureMultishareDefrag: &driver.FeatureMultishareDefrag{
+			Enabled:      *featureMultishareDefrag,
+			Interval:     *multishareDefragInterval,
+			ExecutePlans: *multishareDefragExecute,
+		},
 	}
 
 	mounter := mount.New("")
@@ -20,4 +20,14 @@ fi
 
 service nfs-common start  
 
+# rpc.gssd establishes the security contexts of the volumes with Kerberos security flavors, with the
+# credential caches the node driver obtains in KERBEROS_CREDENTIAL_CACHE_DIR. -n makes it use the
+# credential caches for the mounts by root instead of the keytab of the machine. As all the volumes are
+# mounted by root, the node driver only stages volumes of a single principal on a node.
+if [ -n "$KERBEROS_CREDENTIAL_CACHE_DIR" ]; then
+  mkdir -p "$KERBEROS_CREDENTIAL_CACHE_DIR" /run/rpc_pipefs
+  mountpoint -q /run/rpc_pipefs || mount -t rpc_pipefs sunrpc /run/rpc_pipefs
+  rpc.gssd -n -d "$KERBEROS_CREDENTIAL_CACHE_DIR"
+fi
+
 sleep infinity
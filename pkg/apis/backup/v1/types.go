@@ -0,0 +1,86 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupSchedule takes Filestore backups of the volume of a PersistentVolumeClaim on a cron schedule.
+type BackupSchedule struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec BackupScheduleSpec `json:"spec"`
+	// +optional
+	Status *BackupScheduleStatus `json:"status"`
+}
+
+// BackupScheduleSpec is the spec for a BackupSchedule resource
+type BackupScheduleSpec struct {
+	// PersistentVolumeClaimName is the name of the PersistentVolumeClaim, in the namespace of
+	// the BackupSchedule, to back up.
+	PersistentVolumeClaimName string `json:"persistentVolumeClaimName"`
+	// Schedule is a cron expression, for example "0 2 * * *".
+	Schedule string `json:"schedule"`
+	// BackupLocation is the region the backups are stored in. Defaults to the region of the volume.
+	BackupLocation string `json:"backupLocation,omitempty"`
+	// Labels are added to the labels of the backups.
+	Labels map[string]string `json:"labels,omitempty"`
+	// Retention of the backups taken by the schedule. All the backups are kept if it is not set.
+	Retention *BackupRetention `json:"retention,omitempty"`
+	// Suspend stops the schedule from taking new backups. Retention is still applied.
+	Suspend bool `json:"suspend,omitempty"`
+}
+
+// BackupRetention is the retention of the backups of a BackupSchedule. A backup is kept as long
+// as one of the rules keeps it.
+type BackupRetention struct {
+	// KeepLast keeps the last N ready backups.
+	KeepLast int32 `json:"keepLast,omitempty"`
+	// KeepWithin keeps the backups taken within the duration, for example "168h".
+	KeepWithin *metav1.Duration `json:"keepWithin,omitempty"`
+}
+
+// BackupScheduleStatus is the status for a BackupSchedule resource
+type BackupScheduleStatus struct {
+	LastScheduleTime   *metav1.Time      `json:"lastScheduleTime,omitempty"`
+	LastSuccessfulTime *metav1.Time      `json:"lastSuccessfulTime,omitempty"`
+	Backups            []ScheduledBackup `json:"backups,omitempty"`
+	Error              string            `json:"error"`
+}
+
+// ScheduledBackup is a backup taken by a BackupSchedule.
+type ScheduledBackup struct {
+	// BackupHandle is the Filestore backup, projects/{project}/locations/{region}/backups/{name}.
+	BackupHandle string      `json:"backupHandle"`
+	CreationTime metav1.Time `json:"creationTime"`
+	State        string      `json:"state"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// BackupScheduleList is a list of BackupSchedule resources
+type BackupScheduleList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []BackupSchedule `json:"items"`
+}
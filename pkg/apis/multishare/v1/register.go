@@ -51,6 +51,8 @@ func addKnownTypes(scheme *runtime.Scheme) error {
 		&ShareInfoList{},
 		&InstanceInfo{},
 		&InstanceInfoList{},
+		&ShareMigration{},
+		&ShareMigrationList{},
 	)
 	metav1.AddToGroupVersion(scheme, SchemeGroupVersion)
 	return nil
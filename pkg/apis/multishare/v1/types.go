@@ -48,6 +48,18 @@ type ShareInfoStatus struct {
 	CapacityBytes  int64           `json:"capacityBytes,omitempty"`
 	ShareStatus    FilestoreStatus `json:"shareStatus,omitempty"`
 	Error          string          `json:"error"`
+	// InstanceIP is the IP address of the instance a share was migrated to by a ShareMigration. The
+	// volume attributes of the PV keep the IP address of the instance the share was created on.
+	InstanceIP string `json:"instanceIP,omitempty"`
+	// ObservedGeneration is the generation of the ShareInfo the status reflects.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Ready, Provisioning, Resizing and Degraded conditions of the share.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastOperation is the last Filestore operation of the share.
+	LastOperation *LastOperation `json:"lastOperation,omitempty"`
 }
 
 // FilestoreShareStatusType identifies a specific share status.
@@ -61,6 +73,29 @@ const (
 	DELETED  FilestoreStatus = "deleted"
 )
 
+// These are the condition types of the status of ShareInfo and InstanceInfo resources.
+const (
+	// ConditionReady is true when the share or instance is ready.
+	ConditionReady = "Ready"
+	// ConditionProvisioning is true while the share or instance is being created.
+	ConditionProvisioning = "Provisioning"
+	// ConditionResizing is true while the capacity of the share or instance is changed to the capacity of its spec.
+	ConditionResizing = "Resizing"
+	// ConditionDegraded is true when the last operation on the share or instance failed.
+	ConditionDegraded = "Degraded"
+)
+
+// LastOperation is the last Filestore operation started on a share or an instance.
+type LastOperation struct {
+	// Name is the name of the Filestore operation.
+	Name string `json:"name"`
+	// Type is the verb of the operation, create, update or delete.
+	Type      string      `json:"type"`
+	StartTime metav1.Time `json:"startTime"`
+	// CompletionTime is set once the share or instance is in the state the operation leads to.
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
 
 // ShareInfoList is a list of Foo resources
@@ -99,6 +134,43 @@ type InstanceInfoStatus struct {
 	CapacityStepSizeGb int64           `json:"capacityStepSizeGb,omitempty"`
 	Cidr               string          `json:"cidr"`
 	Error              string          `json:"error"`
+	// Spare is true if the instance is kept empty in the warm pool of its instance-storageclass-label.
+	Spare bool `json:"spare,omitempty"`
+	// EmptySince is the time the instance of a warm pool was found without shares.
+	EmptySince *metav1.Time `json:"emptySince,omitempty"`
+	// DefragPlan is the last consolidation plan of the pool of the instance.
+	DefragPlan *DefragPlan `json:"defragPlan,omitempty"`
+	// ObservedGeneration is the generation of the InstanceInfo the status reflects.
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Conditions are the Ready, Provisioning, Resizing and Degraded conditions of the instance.
+	// +listType=map
+	// +listMapKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+	// LastOperation is the last Filestore operation of the instance.
+	LastOperation *LastOperation `json:"lastOperation,omitempty"`
+}
+
+// DefragPlan is the consolidation plan of a pool of instances computed by the defragmentation planner, from
+// the point of view of one instance of the pool.
+type DefragPlan struct {
+	// PlanTime is the time the plan was computed. The plan isn't updated while it doesn't change.
+	PlanTime metav1.Time `json:"planTime"`
+	// FreeableInstances is the number of instances of the pool the plan frees by repacking their shares.
+	FreeableInstances int `json:"freeableInstances"`
+	// ReclaimableBytes is the capacity of the instances the plan frees, less the growth of the instances
+	// their shares are moved to.
+	ReclaimableBytes int64 `json:"reclaimableBytes"`
+	// Drain is true if the plan frees the instance.
+	Drain bool `json:"drain,omitempty"`
+	// Moves are the moves of the shares of the instance to free it.
+	Moves []ShareMove `json:"moves,omitempty"`
+}
+
+// ShareMove is the move of a share to another instance of its pool.
+type ShareMove struct {
+	ShareInfoName        string `json:"shareInfoName"`
+	TargetInstanceHandle string `json:"targetInstanceHandle"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -110,3 +182,90 @@ type InstanceInfoList struct {
 
 	Items []InstanceInfo `json:"items"`
 }
+
+// +genclient
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ShareMigration moves the share of a ShareInfo to another instance of its pool. A backup of the share
+// is restored into a new share on the target instance, the ShareInfo is switched to the target instance,
+// and the share on the source instance is deleted after the cutover grace period.
+type ShareMigration struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec ShareMigrationSpec `json:"spec"`
+	// +optional
+	Status *ShareMigrationStatus `json:"status,omitempty"`
+}
+
+// ShareMigrationSpec is the spec for a ShareMigration resource
+type ShareMigrationSpec struct {
+	// ShareInfoName is the name of the ShareInfo of the share to migrate.
+	ShareInfoName string `json:"shareInfoName"`
+	// TargetInstanceHandle is the instance the share is migrated to, in the form of
+	// projects/PROJECT/locations/LOCATION/instances/INSTANCE_NAME. If empty, the placement policy of the
+	// share picks one of the eligible instances of its pool.
+	// +optional
+	TargetInstanceHandle string `json:"targetInstanceHandle,omitempty"`
+	// CutoverGracePeriod is how long the share is kept on the source instance after the cutover, for the
+	// nodes to remount the volume from the target instance. Defaults to 5 minutes.
+	// +optional
+	CutoverGracePeriod *metav1.Duration `json:"cutoverGracePeriod,omitempty"`
+}
+
+// ShareMigrationStatus is the status for a ShareMigration resource
+type ShareMigrationStatus struct {
+	Phase                ShareMigrationPhase `json:"phase,omitempty"`
+	SourceInstanceHandle string              `json:"sourceInstanceHandle,omitempty"`
+	TargetInstanceHandle string              `json:"targetInstanceHandle,omitempty"`
+	// BackupHandle is the backup of the share taken while it is still written, in the form of
+	// projects/PROJECT/locations/LOCATION/backups/BACKUP_NAME.
+	BackupHandle string `json:"backupHandle,omitempty"`
+	// FinalBackupHandle is the backup of the fenced share restored on the target instance. It is
+	// incremental from BackupHandle.
+	FinalBackupHandle string `json:"finalBackupHandle,omitempty"`
+	// SourceNfsExportOptions is the JSON of the NFS export options of the source share before it is
+	// fenced, which are restored on rollback and set on the share of the target instance.
+	SourceNfsExportOptions string       `json:"sourceNfsExportOptions,omitempty"`
+	StartTime              *metav1.Time `json:"startTime,omitempty"`
+	CutoverTime            *metav1.Time `json:"cutoverTime,omitempty"`
+	CompletionTime         *metav1.Time `json:"completionTime,omitempty"`
+	Error                  string       `json:"error,omitempty"`
+}
+
+// ShareMigrationPhase is the step a ShareMigration is at.
+type ShareMigrationPhase string
+
+// These are valid phases of a ShareMigration.
+const (
+	// ShareMigrationPending waits for a target instance the share fits on.
+	ShareMigrationPending ShareMigrationPhase = "Pending"
+	// ShareMigrationBackingUp takes a first backup of the share on the source instance, while it is
+	// still written.
+	ShareMigrationBackingUp ShareMigrationPhase = "BackingUp"
+	// ShareMigrationFencing exports the share on the source instance read only, then takes the final
+	// backup of the share.
+	ShareMigrationFencing ShareMigrationPhase = "Fencing"
+	// ShareMigrationRestoring restores the final backup into a new share on the target instance.
+	ShareMigrationRestoring ShareMigrationPhase = "Restoring"
+	// ShareMigrationCuttingOver has switched the ShareInfo to the target instance, and waits for the
+	// cutover grace period and for no node to mount the share from the source instance.
+	ShareMigrationCuttingOver ShareMigrationPhase = "CuttingOver"
+	// ShareMigrationCleaningUp deletes the share on the source instance and the backup.
+	ShareMigrationCleaningUp ShareMigrationPhase = "CleaningUp"
+	ShareMigrationCompleted  ShareMigrationPhase = "Completed"
+	// ShareMigrationRollingBack deletes the share restored on the target instance and the backups of a
+	// migration that failed before the cutover, and restores the export options of the source share.
+	ShareMigrationRollingBack ShareMigrationPhase = "RollingBack"
+	ShareMigrationFailed      ShareMigrationPhase = "Failed"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ShareMigrationList is a list of ShareMigration resources
+type ShareMigrationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ShareMigration `json:"items"`
+}
@@ -25,11 +25,13 @@ import (
 	discovery "k8s.io/client-go/discovery"
 	rest "k8s.io/client-go/rest"
 	flowcontrol "k8s.io/client-go/util/flowcontrol"
+	backupv1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/backup/v1"
 	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/multishare/v1"
 )
 
 type Interface interface {
 	Discovery() discovery.DiscoveryInterface
+	BackupV1() backupv1.BackupV1Interface
 	MultishareV1() multisharev1.MultishareV1Interface
 }
 
@@ -37,9 +39,15 @@ type Interface interface {
 // version included in a Clientset.
 type Clientset struct {
 	*discovery.DiscoveryClient
+	backupV1     *backupv1.BackupV1Client
 	multishareV1 *multisharev1.MultishareV1Client
 }
 
+// BackupV1 retrieves the BackupV1Client
+func (c *Clientset) BackupV1() backupv1.BackupV1Interface {
+	return c.backupV1
+}
+
 // MultishareV1 retrieves the MultishareV1Client
 func (c *Clientset) MultishareV1() multisharev1.MultishareV1Interface {
 	return c.multishareV1
@@ -89,6 +97,10 @@ func NewForConfigAndClient(c *rest.Config, httpClient *http.Client) (*Clientset,
 
 	var cs Clientset
 	var err error
+	cs.backupV1, err = backupv1.NewForConfigAndClient(&configShallowCopy, httpClient)
+	if err != nil {
+		return nil, err
+	}
 	cs.multishareV1, err = multisharev1.NewForConfigAndClient(&configShallowCopy, httpClient)
 	if err != nil {
 		return nil, err
@@ -114,6 +126,7 @@ func NewForConfigOrDie(c *rest.Config) *Clientset {
 // New creates a new Clientset for the given RESTClient.
 func New(c rest.Interface) *Clientset {
 	var cs Clientset
+	cs.backupV1 = backupv1.New(c)
 	cs.multishareV1 = multisharev1.New(c)
 
 	cs.DiscoveryClient = discovery.NewDiscoveryClient(c)
@@ -25,6 +25,8 @@ import (
 	fakediscovery "k8s.io/client-go/discovery/fake"
 	"k8s.io/client-go/testing"
 	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+	backupv1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/backup/v1"
+	fakebackupv1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/backup/v1/fake"
 	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/multishare/v1"
 	fakemultisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/typed/multishare/v1/fake"
 )
@@ -79,6 +81,11 @@ var (
 	_ testing.FakeClient  = &Clientset{}
 )
 
+// BackupV1 retrieves the BackupV1Client
+func (c *Clientset) BackupV1() backupv1.BackupV1Interface {
+	return &fakebackupv1.FakeBackupV1{Fake: &c.Fake}
+}
+
 // MultishareV1 retrieves the MultishareV1Client
 func (c *Clientset) MultishareV1() multisharev1.MultishareV1Interface {
 	return &fakemultisharev1.FakeMultishareV1{Fake: &c.Fake}
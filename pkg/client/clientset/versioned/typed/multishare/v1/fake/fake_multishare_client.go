@@ -36,6 +36,10 @@ func (c *FakeMultishareV1) ShareInfos(namespace string) v1.ShareInfoInterface {
 	return &FakeShareInfos{c, namespace}
 }
 
+func (c *FakeMultishareV1) ShareMigrations(namespace string) v1.ShareMigrationInterface {
+	return &FakeShareMigrations{c, namespace}
+}
+
 // RESTClient returns a RESTClient that is used to communicate
 // with API server by this client implementation.
 func (c *FakeMultishareV1) RESTClient() rest.Interface {
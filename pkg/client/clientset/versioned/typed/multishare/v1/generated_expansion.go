@@ -21,3 +21,5 @@ package v1
 type InstanceInfoExpansion interface{}
 
 type ShareInfoExpansion interface{}
+
+type ShareMigrationExpansion interface{}
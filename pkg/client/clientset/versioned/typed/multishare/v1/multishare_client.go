@@ -30,6 +30,7 @@ type MultishareV1Interface interface {
 	RESTClient() rest.Interface
 	InstanceInfosGetter
 	ShareInfosGetter
+	ShareMigrationsGetter
 }
 
 // MultishareV1Client is used to interact with features provided by the multishare.filestore.csi.storage.gke.io group.
@@ -45,6 +46,10 @@ func (c *MultishareV1Client) ShareInfos(namespace string) ShareInfoInterface {
 	return newShareInfos(c, namespace)
 }
 
+func (c *MultishareV1Client) ShareMigrations(namespace string) ShareMigrationInterface {
+	return newShareMigrations(c, namespace)
+}
+
 // NewForConfig creates a new MultishareV1Client for the given config.
 // NewForConfig is equivalent to NewForConfigAndClient(c, httpClient),
 // where httpClient was generated with rest.HTTPClientFor(c).
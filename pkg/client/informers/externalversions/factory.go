@@ -28,6 +28,7 @@ import (
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	cache "k8s.io/client-go/tools/cache"
 	versioned "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+	backup "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions/backup"
 	internalinterfaces "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions/internalinterfaces"
 	multishare "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions/multishare"
 )
@@ -172,9 +173,14 @@ type SharedInformerFactory interface {
 	ForResource(resource schema.GroupVersionResource) (GenericInformer, error)
 	WaitForCacheSync(stopCh <-chan struct{}) map[reflect.Type]bool
 
+	Backup() backup.Interface
 	Multishare() multishare.Interface
 }
 
+func (f *sharedInformerFactory) Backup() backup.Interface {
+	return backup.New(f, f.namespace, f.tweakListOptions)
+}
+
 func (f *sharedInformerFactory) Multishare() multishare.Interface {
 	return multishare.New(f, f.namespace, f.tweakListOptions)
 }
@@ -23,7 +23,8 @@ import (
 
 	schema "k8s.io/apimachinery/pkg/runtime/schema"
 	cache "k8s.io/client-go/tools/cache"
-	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/backup/v1"
+	multisharev1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
 )
 
 // GenericInformer is type of SharedIndexInformer which will locate and delegate to other
@@ -52,11 +53,17 @@ func (f *genericInformer) Lister() cache.GenericLister {
 // TODO extend this to unknown resources with a client pool
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
+	// Group=backup.filestore.csi.storage.gke.io, Version=v1
+	case v1.SchemeGroupVersion.WithResource("backupschedules"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Backup().V1().BackupSchedules().Informer()}, nil
+
 	// Group=multishare.filestore.csi.storage.gke.io, Version=v1
-	case v1.SchemeGroupVersion.WithResource("instanceinfos"):
+	case multisharev1.SchemeGroupVersion.WithResource("instanceinfos"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Multishare().V1().InstanceInfos().Informer()}, nil
-	case v1.SchemeGroupVersion.WithResource("shareinfos"):
+	case multisharev1.SchemeGroupVersion.WithResource("shareinfos"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.Multishare().V1().ShareInfos().Informer()}, nil
+	case multisharev1.SchemeGroupVersion.WithResource("sharemigrations"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.Multishare().V1().ShareMigrations().Informer()}, nil
 
 	}
 
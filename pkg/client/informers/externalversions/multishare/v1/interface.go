@@ -28,6 +28,8 @@ type Interface interface {
 	InstanceInfos() InstanceInfoInformer
 	// ShareInfos returns a ShareInfoInformer.
 	ShareInfos() ShareInfoInformer
+	// ShareMigrations returns a ShareMigrationInformer.
+	ShareMigrations() ShareMigrationInformer
 }
 
 type version struct {
@@ -50,3 +52,8 @@ func (v *version) InstanceInfos() InstanceInfoInformer {
 func (v *version) ShareInfos() ShareInfoInformer {
 	return &shareInfoInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
 }
+
+// ShareMigrations returns a ShareMigrationInformer.
+func (v *version) ShareMigrations() ShareMigrationInformer {
+	return &shareMigrationInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
+}
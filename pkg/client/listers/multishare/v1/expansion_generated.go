@@ -33,3 +33,11 @@ type ShareInfoListerExpansion interface{}
 // ShareInfoNamespaceListerExpansion allows custom methods to be added to
 // ShareInfoNamespaceLister.
 type ShareInfoNamespaceListerExpansion interface{}
+
+// ShareMigrationListerExpansion allows custom methods to be added to
+// ShareMigrationLister.
+type ShareMigrationListerExpansion interface{}
+
+// ShareMigrationNamespaceListerExpansion allows custom methods to be added to
+// ShareMigrationNamespaceLister.
+type ShareMigrationNamespaceListerExpansion interface{}
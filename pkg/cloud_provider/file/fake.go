@@ -20,6 +20,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"sync"
 
 	"github.com/google/uuid"
 	filev1beta1 "google.golang.org/api/file/v1beta1"
@@ -36,14 +38,29 @@ const (
 	defaultTier       = "BASIC_HDD"
 	defaultCapacityGb = 1024
 	defaultNetwork    = "default"
+	// defaultCapacityQuotaBytes is the capacity quota of every tier in every region, 100Ti.
+	defaultCapacityQuotaBytes = 100 * 1024 * 1024 * 1024 * 1024
+)
+
+// Resources known by the fake pre-flight validation lookups. The default network of the default project
+// exists, with allocated ranges of a /24 and a /29 in it. The KMS keys of the default region are an
+// enabled and a disabled key.
+const (
+	FakeAllocatedIPRange      = "test-allocated-range"
+	FakeSmallAllocatedIPRange = "test-small-allocated-range"
+	FakeKmsKey                = "projects/test-project/locations/us-central1/keyRings/test-ring/cryptoKeys/test-key"
+	FakeDisabledKmsKey        = "projects/test-project/locations/us-central1/keyRings/test-ring/cryptoKeys/disabled-key"
 )
 
 type fakeServiceManager struct {
 	createdInstances          map[string]*ServiceInstance
 	backups                   map[string]*Backup
+	snapshots                 map[string]*Snapshot
 	createdMultishareInstance map[string]*MultishareInstance
 	createdMultishares        map[string]*Share
 	multishareops             []*filev1beta1multishare.Operation
+	// backupsMux serializes concurrent backup calls.
+	backupsMux sync.Mutex
 }
 
 var _ Service = &fakeServiceManager{}
@@ -52,6 +69,7 @@ func NewFakeService() (Service, error) {
 	return &fakeServiceManager{
 		createdInstances:          map[string]*ServiceInstance{},
 		backups:                   map[string]*Backup{},
+		snapshots:                 map[string]*Snapshot{},
 		createdMultishareInstance: make(map[string]*MultishareInstance),
 		createdMultishares:        make(map[string]*Share),
 	}, nil
@@ -61,6 +79,7 @@ func NewFakeServiceForMultishare(instances []*MultishareInstance, shares []*Shar
 	s := &fakeServiceManager{
 		createdInstances:          map[string]*ServiceInstance{},
 		backups:                   map[string]*Backup{},
+		snapshots:                 map[string]*Snapshot{},
 		createdMultishareInstance: make(map[string]*MultishareInstance),
 		createdMultishares:        make(map[string]*Share),
 		multishareops:             make([]*filev1beta1multishare.Operation, 0),
@@ -70,7 +89,7 @@ func NewFakeServiceForMultishare(instances []*MultishareInstance, shares []*Shar
 		s.createdMultishareInstance[instance.Name] = instance
 	}
 	for _, share := range shares {
-		s.createdMultishares[share.Name] = share
+		s.createdMultishares[multishareKey(share)] = share
 	}
 	s.multishareops = append(s.multishareops, ops...)
 	return s, nil
@@ -88,14 +107,16 @@ func (manager *fakeServiceManager) CreateInstance(ctx context.Context, obj *Serv
 		},
 		Network: Network{
 			Name:            obj.Network.Name,
+			ConnectMode:     obj.Network.ConnectMode,
 			Ip:              "1.1.1.1",
 			ReservedIpRange: obj.Network.ReservedIpRange,
 		},
-		Labels:           obj.Labels,
-		State:            "READY",
-		BackupSource:     obj.BackupSource,
-		NfsExportOptions: obj.NfsExportOptions,
-		Protocol:         obj.Protocol,
+		Labels:                 obj.Labels,
+		State:                  "READY",
+		BackupSource:           obj.BackupSource,
+		NfsExportOptions:       obj.NfsExportOptions,
+		Protocol:               obj.Protocol,
+		ManagedActiveDirectory: obj.ManagedActiveDirectory,
 	}
 
 	manager.createdInstances[obj.Name] = instance
@@ -103,6 +124,7 @@ func (manager *fakeServiceManager) CreateInstance(ctx context.Context, obj *Serv
 }
 
 func (manager *fakeServiceManager) DeleteInstance(ctx context.Context, obj *ServiceInstance) error {
+	delete(manager.createdInstances, obj.Name)
 	return nil
 }
 
@@ -145,6 +167,9 @@ func (manager *fakeServiceManager) ListInstances(ctx context.Context, obj *Servi
 			State: "READY",
 		},
 	}
+	for _, instance := range manager.createdInstances {
+		instances = append(instances, instance)
+	}
 	return instances, nil
 }
 
@@ -159,11 +184,36 @@ func (manager *fakeServiceManager) ResizeInstance(ctx context.Context, obj *Serv
 	return instance, nil
 }
 
+func (manager *fakeServiceManager) PatchInstance(ctx context.Context, obj *ServiceInstance, updateMask []string) (*ServiceInstance, error) {
+	instance, ok := manager.createdInstances[obj.Name]
+	if !ok {
+		return nil, notFoundError()
+	}
+
+	for _, field := range updateMask {
+		switch field {
+		case LabelsUpdateMask:
+			instance.Labels = obj.Labels
+		case FileShareUpdateMask:
+			instance.Volume.SizeBytes = obj.Volume.SizeBytes
+			instance.NfsExportOptions = obj.NfsExportOptions
+		case PerformanceConfigUpdateMask:
+			instance.PerformanceConfig = obj.PerformanceConfig
+		default:
+			return nil, fmt.Errorf("unknown update mask field %q", field)
+		}
+	}
+	return instance, nil
+}
+
 func (manager *fakeServiceManager) CreateBackup(ctx context.Context, backupInfo *BackupInfo) (*filev1beta1.Backup, error) {
 	if backupInfo.SourceInstanceName == "" || backupInfo.SourceShare == "" || backupInfo.SourceVolumeId == "" || backupInfo.BackupURI == "" {
 		return nil, fmt.Errorf("BackupInfo fields are not set %+v", backupInfo)
 	}
 
+	manager.backupsMux.Lock()
+	defer manager.backupsMux.Unlock()
+
 	backupUri := backupInfo.BackupURI
 
 	backupSource := backupInfo.BackupSource()
@@ -194,11 +244,15 @@ func (manager *fakeServiceManager) CreateBackup(ctx context.Context, backupInfo
 }
 
 func (manager *fakeServiceManager) DeleteBackup(ctx context.Context, backupName string) error {
+	manager.backupsMux.Lock()
+	defer manager.backupsMux.Unlock()
 	delete(manager.backups, backupName)
 	return nil
 }
 
 func (manager *fakeServiceManager) GetBackup(ctx context.Context, backupUri string) (*Backup, error) {
+	manager.backupsMux.Lock()
+	defer manager.backupsMux.Unlock()
 	backupInfo, ok := manager.backups[backupUri]
 	if !ok || backupInfo.Backup == nil {
 		return nil, notFoundError()
@@ -207,6 +261,121 @@ func (manager *fakeServiceManager) GetBackup(ctx context.Context, backupUri stri
 	return backupInfo, nil
 }
 
+func (manager *fakeServiceManager) ListBackups(ctx context.Context, filter *ListFilter) ([]*Backup, error) {
+	var backups []*Backup
+	for _, backup := range manager.backups {
+		if backup.Backup != nil {
+			backups = append(backups, backup)
+		}
+	}
+	return backups, nil
+}
+
+func (manager *fakeServiceManager) GetSnapshot(ctx context.Context, snapshotURI string) (*Snapshot, error) {
+	snapshot, ok := manager.snapshots[snapshotURI]
+	if !ok {
+		return nil, notFoundError()
+	}
+	return snapshot, nil
+}
+
+func (manager *fakeServiceManager) ListSnapshots(ctx context.Context, obj *ServiceInstance) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	for _, snapshot := range manager.snapshots {
+		if snapshot.SourceInstance == instanceURI(obj.Project, obj.Location, obj.Name) {
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+func (manager *fakeServiceManager) CreateSnapshot(ctx context.Context, obj *ServiceInstance, snapshotName string, labels map[string]string) (*filev1beta1.Snapshot, error) {
+	if _, ok := manager.createdInstances[obj.Name]; !ok {
+		return nil, notFoundError()
+	}
+
+	snapshotURI := SnapshotURI(obj.Project, obj.Location, obj.Name, snapshotName)
+	if snapshot, ok := manager.snapshots[snapshotURI]; ok {
+		return snapshot.Snapshot, nil
+	}
+
+	snapshotToCreate := &filev1beta1.Snapshot{
+		Name:       snapshotURI,
+		CreateTime: "2020-10-02T15:01:23Z",
+		State:      "READY",
+		Labels:     labels,
+	}
+	manager.snapshots[snapshotURI] = &Snapshot{
+		Snapshot:       snapshotToCreate,
+		SourceInstance: instanceURI(obj.Project, obj.Location, obj.Name),
+	}
+	return snapshotToCreate, nil
+}
+
+func (manager *fakeServiceManager) DeleteSnapshot(ctx context.Context, snapshotURI string) error {
+	delete(manager.snapshots, snapshotURI)
+	return nil
+}
+
+func (m *fakeServiceManager) GetCapacityQuota(ctx context.Context, project, region, tier string) (*CapacityQuota, error) {
+	metric, ok := tierToQuotaMetric[strings.ToLower(tier)]
+	if !ok {
+		return nil, fmt.Errorf("no capacity quota known for tier %q", tier)
+	}
+	var instances []*ServiceInstance
+	for _, instance := range m.createdInstances {
+		instances = append(instances, instance)
+	}
+	var multishareInstances []*MultishareInstance
+	for _, instance := range m.createdMultishareInstance {
+		multishareInstances = append(multishareInstances, instance)
+	}
+	return &CapacityQuota{
+		Tier:       tier,
+		Region:     region,
+		LimitBytes: defaultCapacityQuotaBytes,
+		UsageBytes: capacityQuotaUsage(instances, multishareInstances, region, metric),
+	}, nil
+}
+
+func (m *fakeServiceManager) GetNetwork(ctx context.Context, project, name string) (*VPCNetwork, error) {
+	if project != defaultProject || name != defaultNetwork {
+		return nil, notFoundError()
+	}
+	return &VPCNetwork{Project: project, Name: name}, nil
+}
+
+func (m *fakeServiceManager) GetAllocatedIPRange(ctx context.Context, project, name string) (*AllocatedIPRange, error) {
+	if project != defaultProject {
+		return nil, notFoundError()
+	}
+	ipRange := &AllocatedIPRange{
+		Project: project,
+		Name:    name,
+		Network: defaultNetwork,
+		Purpose: "VPC_PEERING",
+	}
+	switch name {
+	case FakeAllocatedIPRange:
+		ipRange.Address, ipRange.PrefixLength = "10.0.0.0", 24
+	case FakeSmallAllocatedIPRange:
+		ipRange.Address, ipRange.PrefixLength = "10.0.1.0", 29
+	default:
+		return nil, notFoundError()
+	}
+	return ipRange, nil
+}
+
+func (m *fakeServiceManager) GetKmsKey(ctx context.Context, keyName string) (*KmsKey, error) {
+	switch keyName {
+	case FakeKmsKey:
+		return &KmsKey{Name: keyName, Purpose: "ENCRYPT_DECRYPT", PrimaryState: "ENABLED"}, nil
+	case FakeDisabledKmsKey:
+		return &KmsKey{Name: keyName, Purpose: "ENCRYPT_DECRYPT", PrimaryState: "DISABLED"}, nil
+	}
+	return nil, notFoundError()
+}
+
 func (m *fakeServiceManager) HasOperations(ctx context.Context, obj *ServiceInstance, operationType string, done bool) (bool, error) {
 	return false, nil
 }
@@ -233,6 +402,7 @@ func NewFakeBlockingService(operationUnblocker chan chan struct{}) (Service, err
 		fakeServiceManager: &fakeServiceManager{
 			createdInstances: map[string]*ServiceInstance{},
 			backups:          map[string]*Backup{},
+			snapshots:        map[string]*Snapshot{},
 		},
 		OperationUnblocker: operationUnblocker,
 	}, nil
@@ -369,7 +539,7 @@ func (manager *fakeServiceManager) StartCreateShareOp(ctx context.Context, obj *
 		State:            "READY",
 		NfsExportOptions: obj.NfsExportOptions,
 	}
-	manager.createdMultishares[share.Name] = share
+	manager.createdMultishares[multishareKey(share)] = share
 
 	meta := &filev1beta1.OperationMetadata{
 		Target: fmt.Sprintf(shareURIFmt, share.Parent.Project, share.Parent.Location, share.Parent.Name, share.Name),
@@ -385,7 +555,9 @@ func (manager *fakeServiceManager) StartCreateShareOp(ctx context.Context, obj *
 }
 
 func (manager *fakeServiceManager) StartDeleteShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
-	delete(manager.createdMultishares, obj.Name)
+	if key, ok := manager.findMultishare(obj); ok {
+		delete(manager.createdMultishares, key)
+	}
 
 	meta := &filev1beta1multishare.OperationMetadata{
 		Target: fmt.Sprintf(shareURIFmt, obj.Parent.Project, obj.Parent.Location, obj.Parent.Name, obj.Name),
@@ -401,7 +573,40 @@ func (manager *fakeServiceManager) StartDeleteShareOp(ctx context.Context, obj *
 }
 
 func (manager *fakeServiceManager) StartResizeShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error) {
-	manager.createdMultishares[obj.Name].CapacityBytes = obj.CapacityBytes
+	key, ok := manager.findMultishare(obj)
+	if !ok {
+		return nil, notFoundError()
+	}
+	manager.createdMultishares[key].CapacityBytes = obj.CapacityBytes
+	meta := &filev1beta1multishare.OperationMetadata{
+		Target: fmt.Sprintf(shareURIFmt, obj.Parent.Project, obj.Parent.Location, obj.Parent.Name, obj.Name),
+		Verb:   "update",
+	}
+	metaBytes, _ := json.Marshal(meta)
+	op := &filev1beta1multishare.Operation{
+		Name:     "operation-" + uuid.New().String(),
+		Metadata: metaBytes,
+	}
+
+	return op, nil
+}
+
+func (manager *fakeServiceManager) StartPatchShareOp(ctx context.Context, obj *Share, updateMask []string) (*filev1beta1multishare.Operation, error) {
+	key, ok := manager.findMultishare(obj)
+	if !ok {
+		return nil, notFoundError()
+	}
+	share := manager.createdMultishares[key]
+	for _, field := range updateMask {
+		switch field {
+		case LabelsUpdateMask:
+			share.Labels = obj.Labels
+		case NfsExportOptionsUpdateMask:
+			share.NfsExportOptions = obj.NfsExportOptions
+		default:
+			return nil, fmt.Errorf("unknown update mask field %q", field)
+		}
+	}
 	meta := &filev1beta1multishare.OperationMetadata{
 		Target: fmt.Sprintf(shareURIFmt, obj.Parent.Project, obj.Parent.Location, obj.Parent.Name, obj.Name),
 		Verb:   "update",
@@ -432,16 +637,43 @@ func (manager *fakeServiceManager) IsOpDone(*filev1beta1multishare.Operation) (b
 }
 
 func (manager *fakeServiceManager) GetShare(ctx context.Context, obj *Share) (*Share, error) {
-	share, ok := manager.createdMultishares[obj.Name]
+	key, ok := manager.findMultishare(obj)
 	if !ok {
 		return nil, notFoundError()
 	}
+	share := manager.createdMultishares[key]
 	return share, nil
 }
 
+// multishareKey returns the key of a share in createdMultishares. Shares of different instances can have
+// the same name.
+func multishareKey(share *Share) string {
+	if share.Parent == nil {
+		return share.Name
+	}
+	return share.Parent.Name + "/" + share.Name
+}
+
+// findMultishare returns the key of the share matching obj. A share without parent matches a share of the
+// same name on any instance.
+func (manager *fakeServiceManager) findMultishare(obj *Share) (string, bool) {
+	if _, ok := manager.createdMultishares[multishareKey(obj)]; ok {
+		return multishareKey(obj), true
+	}
+	for key, share := range manager.createdMultishares {
+		if share.Name == obj.Name && (obj.Parent == nil || share.Parent == nil) {
+			return key, true
+		}
+	}
+	return "", false
+}
+
 func (manager *fakeServiceManager) ListShares(ctx context.Context, filter *ListFilter) ([]*Share, error) {
 	var slist []*Share
 	for _, v := range manager.createdMultishares {
+		if filter != nil && filter.InstanceName != "" && filter.InstanceName != "-" && v.Parent != nil && v.Parent.Name != filter.InstanceName {
+			continue
+		}
 		slist = append(slist, v)
 	}
 	return slist, nil
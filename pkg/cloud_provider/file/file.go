@@ -29,6 +29,7 @@ import (
 
 	"github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/googleapis/gax-go/v2/apierror"
+	compute "google.golang.org/api/compute/v1"
 	"google.golang.org/api/googleapi"
 	"google.golang.org/api/option"
 	"google.golang.org/grpc/codes"
@@ -53,11 +54,12 @@ type PollOpts struct {
 	Timeout  time.Duration
 }
 type NfsExportOptions struct {
-	AccessMode string   `json:"accessMode,omitempty"`
-	AnonGid    int64    `json:"anonGid,omitempty,string"`
-	AnonUid    int64    `json:"anonUid,omitempty,string"`
-	IpRanges   []string `json:"ipRanges,omitempty"`
-	SquashMode string   `json:"squashMode,omitempty"`
+	AccessMode      string   `json:"accessMode,omitempty"`
+	AnonGid         int64    `json:"anonGid,omitempty,string"`
+	AnonUid         int64    `json:"anonUid,omitempty,string"`
+	IpRanges        []string `json:"ipRanges,omitempty"`
+	SquashMode      string   `json:"squashMode,omitempty"`
+	SecurityFlavors []string `json:"securityFlavors,omitempty"`
 }
 
 type Share struct {
@@ -111,6 +113,25 @@ type ServiceInstance struct {
 	BackupSource     string
 	NfsExportOptions []*NfsExportOptions
 	Protocol         string
+	// PerformanceConfig is the provisioned IOPS of the instance, nil for the default performance of its capacity.
+	PerformanceConfig *PerformanceConfig
+	// ManagedActiveDirectory is the Managed Microsoft AD domain of the instance, which Kerberos security
+	// flavors require.
+	ManagedActiveDirectory *ManagedActiveDirectory
+}
+
+// ManagedActiveDirectory is the Managed Microsoft AD domain an instance joins, as the computer whose name
+// prefixes the Kerberos hostname of the instance.
+type ManagedActiveDirectory struct {
+	// Domain is the domain resource name, projects/{project}/locations/global/domains/{domain}.
+	Domain   string
+	Computer string
+}
+
+// PerformanceConfig is the provisioned IOPS of an instance, either a fixed IOPS or IOPS per TiB of capacity.
+type PerformanceConfig struct {
+	MaxIops      int64
+	MaxIopsPerTb int64
 }
 
 type Volume struct {
@@ -132,6 +153,13 @@ type Backup struct {
 	FileSystemProtocl string
 }
 
+// Snapshot is a Filestore snapshot, a point-in-time copy of the file system of an instance stored
+// in the instance itself.
+type Snapshot struct {
+	Snapshot       *filev1beta1.Snapshot
+	SourceInstance string
+}
+
 type BackupInfo struct {
 	Name               string
 	SourceVolumeId     string
@@ -166,9 +194,19 @@ type Service interface {
 	GetInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error)
 	ListInstances(ctx context.Context, obj *ServiceInstance) ([]*ServiceInstance, error)
 	ResizeInstance(ctx context.Context, obj *ServiceInstance) (*ServiceInstance, error)
+	PatchInstance(ctx context.Context, obj *ServiceInstance, updateMask []string) (*ServiceInstance, error)
 	GetBackup(ctx context.Context, backupUri string) (*Backup, error)
 	CreateBackup(ctx context.Context, backupInfo *BackupInfo) (*filev1beta1.Backup, error)
 	DeleteBackup(ctx context.Context, backupId string) error
+	ListBackups(ctx context.Context, filter *ListFilter) ([]*Backup, error)
+	GetSnapshot(ctx context.Context, snapshotURI string) (*Snapshot, error)
+	ListSnapshots(ctx context.Context, obj *ServiceInstance) ([]*Snapshot, error)
+	CreateSnapshot(ctx context.Context, obj *ServiceInstance, snapshotName string, labels map[string]string) (*filev1beta1.Snapshot, error)
+	DeleteSnapshot(ctx context.Context, snapshotURI string) error
+	GetCapacityQuota(ctx context.Context, project, region, tier string) (*CapacityQuota, error)
+	GetNetwork(ctx context.Context, project, name string) (*VPCNetwork, error)
+	GetAllocatedIPRange(ctx context.Context, project, name string) (*AllocatedIPRange, error)
+	GetKmsKey(ctx context.Context, keyName string) (*KmsKey, error)
 	HasOperations(ctx context.Context, obj *ServiceInstance, operationType string, done bool) (bool, error)
 	// Multishare ops
 	GetMultishareInstance(ctx context.Context, obj *MultishareInstance) (*MultishareInstance, error)
@@ -181,6 +219,7 @@ type Service interface {
 	StartCreateShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error)
 	StartDeleteShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error)
 	StartResizeShareOp(ctx context.Context, obj *Share) (*filev1beta1multishare.Operation, error)
+	StartPatchShareOp(ctx context.Context, obj *Share, updateMask []string) (*filev1beta1multishare.Operation, error)
 	WaitForOpWithOpts(ctx context.Context, op string, opts PollOpts) error
 	GetOp(ctx context.Context, op string) (*filev1beta1multishare.Operation, error)
 	IsOpDone(op *filev1beta1multishare.Operation) (bool, error)
@@ -192,12 +231,22 @@ type gcfsServiceManager struct {
 	instancesService  *filev1beta1.ProjectsLocationsInstancesService
 	operationsService *filev1beta1.ProjectsLocationsOperationsService
 	backupService     *filev1beta1.ProjectsLocationsBackupsService
+	snapshotService   *filev1beta1.ProjectsLocationsInstancesSnapshotsService
 
 	// multishare definitions
 	fileMultishareService            *filev1beta1multishare.Service
 	multishareInstancesService       *filev1beta1multishare.ProjectsLocationsInstancesService
 	multishareInstancesSharesService *filev1beta1multishare.ProjectsLocationsInstancesSharesService
 	multishareOperationsServices     *filev1beta1multishare.ProjectsLocationsOperationsService
+
+	// quota definitions
+	quotaClient   *http.Client
+	quotaBasePath string
+
+	// pre-flight validation definitions
+	computeService *compute.Service
+	kmsClient      *http.Client
+	kmsBasePath    string
 }
 
 const (
@@ -205,11 +254,15 @@ const (
 	instanceURIFmt  = locationURIFmt + "/instances/%s"
 	operationURIFmt = locationURIFmt + "/operations/%s"
 	backupURIFmt    = locationURIFmt + "/backups/%s"
+	snapshotURIFmt  = instanceURIFmt + "/snapshots/%s"
 	shareSuffixFmt  = "/shares/%s"
 	shareURIFmt     = instanceURIFmt + shareSuffixFmt
 	// Patch update masks
-	fileShareUpdateMask          = "file_shares"
+	FileShareUpdateMask          = "file_shares"
 	multishareCapacityUpdateMask = "capacity_gb"
+	LabelsUpdateMask             = "labels"
+	PerformanceConfigUpdateMask  = "performance_config"
+	NfsExportOptionsUpdateMask   = "nfs_export_options"
 	prodBasePath                 = "https://file.googleapis.com/"
 )
 
@@ -260,15 +313,26 @@ func NewGCFSService(version string, client *http.Client, primaryFilestoreService
 
 	klog.Infof("Using endpoint %q for multishare filestore", fileMultishareService.BasePath)
 
+	computeService, err := compute.NewService(ctx, option.WithHTTPClient(client))
+	if err != nil {
+		return nil, err
+	}
+
 	return &gcfsServiceManager{
 		fileService:                      fileService,
 		instancesService:                 filev1beta1.NewProjectsLocationsInstancesService(fileService),
 		operationsService:                filev1beta1.NewProjectsLocationsOperationsService(fileService),
 		backupService:                    filev1beta1.NewProjectsLocationsBackupsService(fileService),
+		snapshotService:                  filev1beta1.NewProjectsLocationsInstancesSnapshotsService(fileService),
 		fileMultishareService:            fileMultishareService,
 		multishareInstancesService:       filev1beta1multishare.NewProjectsLocationsInstancesService(fileMultishareService),
 		multishareInstancesSharesService: filev1beta1multishare.NewProjectsLocationsInstancesSharesService(fileMultishareService),
 		multishareOperationsServices:     filev1beta1multishare.NewProjectsLocationsOperationsService(fileMultishareService),
+		quotaClient:                      client,
+		quotaBasePath:                    cloudQuotasBasePath,
+		computeService:                   computeService,
+		kmsClient:                        client,
+		kmsBasePath:                      cloudKMSBasePath,
 	}, nil
 }
 
@@ -291,10 +355,11 @@ func (manager *gcfsServiceManager) CreateInstance(ctx context.Context, obj *Serv
 				ConnectMode:     obj.Network.ConnectMode,
 			},
 		},
-		KmsKeyName: obj.KmsKeyName,
-		Labels:     obj.Labels,
-		State:      obj.State,
-		Protocol:   obj.Protocol,
+		KmsKeyName:        obj.KmsKeyName,
+		Labels:            obj.Labels,
+		State:             obj.State,
+		Protocol:          obj.Protocol,
+		DirectoryServices: extractDirectoryServices(obj.ManagedActiveDirectory),
 	}
 
 	klog.V(4).Infof("Creating instance %q: location %v, tier %q, capacity %v, network %q, ipRange %q, connectMode %q, KmsKeyName %q, labels %v, backup source %q, protocol %v",
@@ -371,14 +436,78 @@ func cloudInstanceToServiceInstance(instance *filev1beta1.Instance) (*ServiceIns
 			ReservedIpRange: instance.Networks[0].ReservedIpRange,
 			ConnectMode:     instance.Networks[0].ConnectMode,
 		},
-		KmsKeyName:   instance.KmsKeyName,
-		Labels:       instance.Labels,
-		State:        instance.State,
-		BackupSource: instance.FileShares[0].SourceBackup,
-		Protocol:     instance.Protocol,
+		KmsKeyName:             instance.KmsKeyName,
+		Labels:                 instance.Labels,
+		State:                  instance.State,
+		BackupSource:           instance.FileShares[0].SourceBackup,
+		Protocol:               instance.Protocol,
+		PerformanceConfig:      cloudPerformanceConfigToPerformanceConfig(instance.PerformanceConfig),
+		NfsExportOptions:       cloudNfsExportOptionsToNfsExportOptions(instance.FileShares[0].NfsExportOptions),
+		ManagedActiveDirectory: cloudDirectoryServicesToManagedActiveDirectory(instance.DirectoryServices),
 	}, nil
 }
 
+func cloudNfsExportOptionsToNfsExportOptions(options []*filev1beta1.NfsExportOptions) []*NfsExportOptions {
+	var nfsExportOptions []*NfsExportOptions
+	for _, opt := range options {
+		nfsExportOptions = append(nfsExportOptions, &NfsExportOptions{
+			AccessMode:      opt.AccessMode,
+			AnonGid:         opt.AnonGid,
+			AnonUid:         opt.AnonUid,
+			IpRanges:        opt.IpRanges,
+			SquashMode:      opt.SquashMode,
+			SecurityFlavors: opt.SecurityFlavors,
+		})
+	}
+	return nfsExportOptions
+}
+
+func cloudDirectoryServicesToManagedActiveDirectory(config *filev1beta1.DirectoryServicesConfig) *ManagedActiveDirectory {
+	if config == nil || config.ManagedActiveDirectory == nil {
+		return nil
+	}
+	return &ManagedActiveDirectory{
+		Domain:   config.ManagedActiveDirectory.Domain,
+		Computer: config.ManagedActiveDirectory.Computer,
+	}
+}
+
+func extractDirectoryServices(ad *ManagedActiveDirectory) *filev1beta1.DirectoryServicesConfig {
+	if ad == nil {
+		return nil
+	}
+	return &filev1beta1.DirectoryServicesConfig{
+		ManagedActiveDirectory: &filev1beta1.ManagedActiveDirectoryConfig{
+			Domain:   ad.Domain,
+			Computer: ad.Computer,
+		},
+	}
+}
+
+func cloudPerformanceConfigToPerformanceConfig(config *filev1beta1.PerformanceConfig) *PerformanceConfig {
+	switch {
+	case config == nil:
+		return nil
+	case config.FixedIops != nil:
+		return &PerformanceConfig{MaxIops: config.FixedIops.MaxIops}
+	case config.IopsPerTb != nil:
+		return &PerformanceConfig{MaxIopsPerTb: config.IopsPerTb.MaxIopsPerTb}
+	}
+	return nil
+}
+
+func extractPerformanceConfig(config *PerformanceConfig) *filev1beta1.PerformanceConfig {
+	switch {
+	case config == nil:
+		return nil
+	case config.MaxIops != 0:
+		return &filev1beta1.PerformanceConfig{FixedIops: &filev1beta1.FixedIOPS{MaxIops: config.MaxIops}}
+	case config.MaxIopsPerTb != 0:
+		return &filev1beta1.PerformanceConfig{IopsPerTb: &filev1beta1.IOPSPerTB{MaxIopsPerTb: config.MaxIopsPerTb}}
+	}
+	return nil
+}
+
 func CompareInstances(a, b *ServiceInstance) error {
 	mismatches := []string{}
 	if !strings.EqualFold(a.Tier, b.Tier) {
@@ -471,6 +600,9 @@ func (manager *gcfsServiceManager) ResizeInstance(ctx context.Context, obj *Serv
 				Name: obj.Volume.Name,
 				// This is the updated instance size requested.
 				CapacityGb: util.BytesToGb(obj.Volume.SizeBytes),
+				// The file share is patched as a whole, so its export options, as the security flavors of
+				// the instance, are kept.
+				NfsExportOptions: extractNfsShareExportOptions(obj.NfsExportOptions),
 			},
 		},
 		Networks: []*filev1beta1.NetworkConfig{
@@ -494,7 +626,7 @@ func (manager *gcfsServiceManager) ResizeInstance(ctx context.Context, obj *Serv
 		betaObj.Networks[0].ConnectMode,
 		betaObj.KmsKeyName,
 	)
-	op, err := manager.instancesService.Patch(instanceuri, betaObj).UpdateMask(fileShareUpdateMask).Context(ctx).Do()
+	op, err := manager.instancesService.Patch(instanceuri, betaObj).UpdateMask(FileShareUpdateMask).Context(ctx).Do()
 	if err != nil {
 		return nil, fmt.Errorf("patch operation failed: %w", err)
 	}
@@ -513,6 +645,43 @@ func (manager *gcfsServiceManager) ResizeInstance(ctx context.Context, obj *Serv
 	return instance, nil
 }
 
+// PatchInstance updates the fields of the update mask of the instance, out of its labels, its file share
+// and its performance config, and waits for the update to complete.
+func (manager *gcfsServiceManager) PatchInstance(ctx context.Context, obj *ServiceInstance, updateMask []string) (*ServiceInstance, error) {
+	instanceuri := instanceURI(obj.Project, obj.Location, obj.Name)
+	betaObj := &filev1beta1.Instance{
+		Labels: obj.Labels,
+		FileShares: []*filev1beta1.FileShareConfig{
+			{
+				Name:             obj.Volume.Name,
+				CapacityGb:       util.BytesToGb(obj.Volume.SizeBytes),
+				NfsExportOptions: extractNfsShareExportOptions(obj.NfsExportOptions),
+			},
+		},
+		PerformanceConfig: extractPerformanceConfig(obj.PerformanceConfig),
+	}
+
+	mask := strings.Join(updateMask, ",")
+	klog.V(4).Infof("Patching instance %q: location %q, update mask %q", obj.Name, obj.Location, mask)
+	op, err := manager.instancesService.Patch(instanceuri, betaObj).UpdateMask(mask).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("patch operation failed: %w", err)
+	}
+
+	klog.V(4).Infof("For instance %s, waiting for patch op %v to complete", instanceuri, op.Name)
+	err = manager.waitForOp(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("WaitFor patch op %s failed: %w", op.Name, err)
+	}
+
+	instance, err := manager.GetInstance(ctx, obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get instance after patch: %w", err)
+	}
+	klog.V(4).Infof("After patch got instance %#v", instance)
+	return instance, nil
+}
+
 func (manager *gcfsServiceManager) GetBackup(ctx context.Context, backupUri string) (*Backup, error) {
 	backup, err := manager.backupService.Get(backupUri).Context(ctx).Do()
 	if err != nil {
@@ -574,6 +743,125 @@ func (manager *gcfsServiceManager) DeleteBackup(ctx context.Context, backupId st
 	return nil
 }
 
+// ListBackups lists the backups of a project in the location of the filter. An empty location lists the
+// backups in all the locations of the project.
+func (manager *gcfsServiceManager) ListBackups(ctx context.Context, filter *ListFilter) ([]*Backup, error) {
+	location := filter.Location
+	if location == "" {
+		location = "-"
+	}
+	lCall := manager.backupService.List(locationURI(filter.Project, location)).Context(ctx)
+	nextPageToken := "pageToken"
+	var backups []*Backup
+
+	for nextPageToken != "" {
+		resp, err := lCall.Do()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, backup := range resp.Backups {
+			backups = append(backups, &Backup{
+				Backup:            backup,
+				SourceInstance:    backup.SourceInstance,
+				SourceShare:       backup.SourceFileShare,
+				FileSystemProtocl: backup.FileSystemProtocol,
+			})
+		}
+
+		nextPageToken = resp.NextPageToken
+		lCall.PageToken(nextPageToken)
+	}
+	return backups, nil
+}
+
+func (manager *gcfsServiceManager) GetSnapshot(ctx context.Context, snapshotURI string) (*Snapshot, error) {
+	project, location, instanceName, _, err := util.ParseSnapshotURI(snapshotURI)
+	if err != nil {
+		return nil, err
+	}
+	snapshot, err := manager.snapshotService.Get(snapshotURI).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return &Snapshot{
+		Snapshot:       snapshot,
+		SourceInstance: instanceURI(project, location, instanceName),
+	}, nil
+}
+
+// ListSnapshots lists the Filestore snapshots of an instance.
+func (manager *gcfsServiceManager) ListSnapshots(ctx context.Context, obj *ServiceInstance) ([]*Snapshot, error) {
+	instanceURI := instanceURI(obj.Project, obj.Location, obj.Name)
+	lCall := manager.snapshotService.List(instanceURI).Context(ctx)
+	nextPageToken := "pageToken"
+	var snapshots []*Snapshot
+
+	for nextPageToken != "" {
+		resp, err := lCall.Do()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, snapshot := range resp.Snapshots {
+			snapshots = append(snapshots, &Snapshot{
+				Snapshot:       snapshot,
+				SourceInstance: instanceURI,
+			})
+		}
+
+		nextPageToken = resp.NextPageToken
+		lCall.PageToken(nextPageToken)
+	}
+	return snapshots, nil
+}
+
+func (manager *gcfsServiceManager) CreateSnapshot(ctx context.Context, obj *ServiceInstance, snapshotName string, labels map[string]string) (*filev1beta1.Snapshot, error) {
+	instanceURI := instanceURI(obj.Project, obj.Location, obj.Name)
+	snapshotURI := SnapshotURI(obj.Project, obj.Location, obj.Name, snapshotName)
+	snapshotobj := &filev1beta1.Snapshot{
+		Labels: labels,
+	}
+	klog.V(4).Infof("Creating snapshot object %+v for the URI %v", *snapshotobj, snapshotURI)
+	op, err := manager.snapshotService.Create(instanceURI, snapshotobj).SnapshotId(snapshotName).Context(ctx).Do()
+	if err != nil {
+		klog.Errorf("Create Snapshot operation failed: %v", err)
+		return nil, err
+	}
+
+	klog.V(4).Infof("For snapshot uri %s, waiting for snapshot op %v to complete", snapshotURI, op.Name)
+	err = manager.waitForOp(ctx, op)
+	if err != nil {
+		return nil, fmt.Errorf("WaitFor CreateSnapshot op %s for source instance %v, snapshot uri: %v, operation failed: %w", op.Name, instanceURI, snapshotURI, err)
+	}
+
+	snapshot, err := manager.snapshotService.Get(snapshotURI).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	if snapshot.State != "READY" {
+		return nil, fmt.Errorf("snapshot %v for source %v is not ready, current state: %v", snapshotURI, instanceURI, snapshot.State)
+	}
+	klog.Infof("Successfully created snapshot %+v for source instance %v", snapshot, instanceURI)
+	return snapshot, nil
+}
+
+func (manager *gcfsServiceManager) DeleteSnapshot(ctx context.Context, snapshotURI string) error {
+	op, err := manager.snapshotService.Delete(snapshotURI).Context(ctx).Do()
+	if err != nil {
+		return fmt.Errorf("for snapshot %s, delete snapshot operation failed: %w", snapshotURI, err)
+	}
+
+	klog.V(4).Infof("For snapshot %s, waiting for snapshot op %v to complete", snapshotURI, op.Name)
+	err = manager.waitForOp(ctx, op)
+	if err != nil {
+		return fmt.Errorf("delete snapshot: %v, op %s failed: %w", snapshotURI, op.Name, err)
+	}
+
+	klog.Infof("Snapshot %v successfully deleted", snapshotURI)
+	return nil
+}
+
 func (manager *gcfsServiceManager) waitForOp(ctx context.Context, op *filev1beta1.Operation) error {
 	return wait.Poll(5*time.Second, 5*time.Minute, func() (bool, error) {
 		pollOp, err := manager.operationsService.Get(op.Name).Context(ctx).Do()
@@ -618,6 +906,12 @@ func backupURI(project, location, name string) string {
 	return fmt.Sprintf(backupURIFmt, project, location, name)
 }
 
+// SnapshotURI returns the name of the Filestore snapshot of an instance, which is also its CSI
+// snapshot handle.
+func SnapshotURI(project, location, instanceName, snapshotName string) string {
+	return fmt.Sprintf(snapshotURIFmt, project, location, instanceName, snapshotName)
+}
+
 func GetInstanceNameFromURI(uri string) (project, location, name string, err error) {
 	var uriRegex = regexp.MustCompile(`^projects/([^/]+)/locations/([^/]+)/instances/([^/]+)$`)
 
@@ -844,6 +1138,29 @@ func ProcessExistingBackup(ctx context.Context, backup *Backup, volumeID string,
 	}, nil
 }
 
+// ProcessExistingSnapshot returns the CSI snapshot of an existing Filestore snapshot of the volume. The
+// size of the snapshot is the capacity of its source instance, the only volume it can be restored into.
+func ProcessExistingSnapshot(snapshot *Snapshot, volumeID string, sizeBytes int64) (*csi.Snapshot, error) {
+	if snapshot.Snapshot.State == "CREATING" {
+		return nil, status.Errorf(codes.DeadlineExceeded, "Snapshot %v not yet ready, current state %s", snapshot.Snapshot.Name, snapshot.Snapshot.State)
+	}
+	if snapshot.Snapshot.State != "READY" {
+		return nil, status.Errorf(codes.Internal, "Snapshot %v not yet ready, current state %s", snapshot.Snapshot.Name, snapshot.Snapshot.State)
+	}
+	tp, err := util.ParseTimestamp(snapshot.Snapshot.CreateTime)
+	if err != nil {
+		err = fmt.Errorf("failed to parse create timestamp for snapshot %v: %w", snapshot.Snapshot.Name, err)
+		return nil, StatusError(err)
+	}
+	return &csi.Snapshot{
+		SizeBytes:      sizeBytes,
+		SnapshotId:     snapshot.Snapshot.Name,
+		SourceVolumeId: volumeID,
+		CreationTime:   tp,
+		ReadyToUse:     true,
+	}, nil
+}
+
 func CheckBackupExists(backupInfo *Backup, err error) (bool, error) {
 	if err != nil {
 		if !IsNotFoundErr(err) {
@@ -1081,6 +1398,23 @@ func (manager *gcfsServiceManager) StartResizeShareOp(ctx context.Context, share
 	return op, nil
 }
 
+// StartPatchShareOp starts the update of the fields of the update mask of the share, out of its labels and
+// its NFS export options.
+func (manager *gcfsServiceManager) StartPatchShareOp(ctx context.Context, share *Share, updateMask []string) (*filev1beta1multishare.Operation, error) {
+	uri := shareURI(share.Parent.Project, share.Parent.Location, share.Parent.Name, share.Name)
+	targetShare := &filev1beta1multishare.Share{
+		Labels:           share.Labels,
+		NfsExportOptions: extractNfsShareExportOptions(share.NfsExportOptions),
+	}
+	mask := strings.Join(updateMask, ",")
+	op, err := manager.multishareInstancesSharesService.Patch(uri, targetShare).UpdateMask(mask).Context(ctx).Do()
+	if err != nil {
+		return nil, fmt.Errorf("PatchShare operation failed: %w", err)
+	}
+	klog.Infof("Started Patch Share op %s for share uri %q, update mask %q", op.Name, uri, mask)
+	return op, nil
+}
+
 func (manager *gcfsServiceManager) WaitForOpWithOpts(ctx context.Context, op string, opts PollOpts) error {
 	return wait.Poll(opts.Interval, opts.Timeout, func() (bool, error) {
 		pollOp, err := manager.multishareOperationsServices.Get(op).Context(ctx).Do()
@@ -1367,11 +1701,12 @@ func extractNfsShareExportOptions(options []*NfsExportOptions) []*filev1beta1mul
 	for _, opt := range options {
 		filerOpts = append(filerOpts,
 			&filev1beta1multishare.NfsExportOptions{
-				AccessMode: opt.AccessMode,
-				AnonGid:    opt.AnonGid,
-				AnonUid:    opt.AnonUid,
-				IpRanges:   opt.IpRanges,
-				SquashMode: opt.SquashMode,
+				AccessMode:      opt.AccessMode,
+				AnonGid:         opt.AnonGid,
+				AnonUid:         opt.AnonUid,
+				IpRanges:        opt.IpRanges,
+				SquashMode:      opt.SquashMode,
+				SecurityFlavors: opt.SecurityFlavors,
 			})
 	}
 	return filerOpts
@@ -0,0 +1,131 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"google.golang.org/api/googleapi"
+	"k8s.io/klog/v2"
+)
+
+const (
+	cloudKMSBasePath = "https://cloudkms.googleapis.com/"
+	kmsKeyURIFmt     = "v1/%s"
+)
+
+// VPCNetwork is a VPC network Filestore instances are connected to.
+type VPCNetwork struct {
+	Project string
+	Name    string
+}
+
+// AllocatedIPRange is an internal IP range of a VPC network, allocated for private services access.
+type AllocatedIPRange struct {
+	Project string
+	Name    string
+	// Network is the name of the VPC network of the range.
+	Network      string
+	Purpose      string
+	Address      string
+	PrefixLength int64
+}
+
+// KmsKey is a Cloud KMS key, projects/{project}/locations/{location}/keyRings/{key_ring}/cryptoKeys/{key}.
+type KmsKey struct {
+	Name    string
+	Purpose string
+	// PrimaryState is the state of the primary version of the key, used to encrypt new data.
+	PrimaryState string
+}
+
+type kmsCryptoKey struct {
+	Name    string `json:"name"`
+	Purpose string `json:"purpose"`
+	Primary *struct {
+		State string `json:"state"`
+	} `json:"primary"`
+}
+
+// GetNetwork returns the VPC network of the project.
+func (manager *gcfsServiceManager) GetNetwork(ctx context.Context, project, name string) (*VPCNetwork, error) {
+	network, err := manager.computeService.Networks.Get(project, name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return &VPCNetwork{
+		Project: project,
+		Name:    network.Name,
+	}, nil
+}
+
+// GetAllocatedIPRange returns the global internal IP range of the project.
+func (manager *gcfsServiceManager) GetAllocatedIPRange(ctx context.Context, project, name string) (*AllocatedIPRange, error) {
+	address, err := manager.computeService.GlobalAddresses.Get(project, name).Context(ctx).Do()
+	if err != nil {
+		return nil, err
+	}
+	return &AllocatedIPRange{
+		Project:      project,
+		Name:         address.Name,
+		Network:      path.Base(address.Network),
+		Purpose:      address.Purpose,
+		Address:      address.Address,
+		PrefixLength: address.PrefixLength,
+	}, nil
+}
+
+// GetKmsKey returns the Cloud KMS key of the key name.
+func (manager *gcfsServiceManager) GetKmsKey(ctx context.Context, keyName string) (*KmsKey, error) {
+	reqURL := manager.kmsBasePath + fmt.Sprintf(kmsKeyURIFmt, keyName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.V(5).Infof("Getting KMS key %s", reqURL)
+	res, err := manager.kmsClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &kmsCryptoKey{}
+	if err := json.Unmarshal(body, key); err != nil {
+		return nil, fmt.Errorf("failed to parse KMS key %s: %w", keyName, err)
+	}
+	kmsKey := &KmsKey{
+		Name:    key.Name,
+		Purpose: key.Purpose,
+	}
+	if key.Primary != nil {
+		kmsKey.PrimaryState = key.Primary.State
+	}
+	return kmsKey, nil
+}
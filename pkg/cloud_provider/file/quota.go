@@ -0,0 +1,217 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	cloudQuotasBasePath = "https://cloudquotas.googleapis.com/"
+	quotaInfosURIFmt    = "v1/projects/%s/locations/global/services/file.googleapis.com/quotaInfos"
+	quotaRegionKey      = "region"
+	// UnlimitedQuota is the limit of a quota without a limit.
+	UnlimitedQuota = -1
+)
+
+// tierToQuotaMetric maps instance tiers to the Filestore quota metric of their capacity.
+// Capacity quotas are enforced per region, for all the instances of a tier in the project.
+var tierToQuotaMetric = map[string]string{
+	"standard":       "file.googleapis.com/standard_capacity",
+	"basic_hdd":      "file.googleapis.com/standard_capacity",
+	"premium":        "file.googleapis.com/premium_capacity",
+	"basic_ssd":      "file.googleapis.com/premium_capacity",
+	"high_scale_ssd": "file.googleapis.com/high_scale_capacity",
+	"enterprise":     "file.googleapis.com/enterprise_capacity",
+	"zonal":          "file.googleapis.com/zonal_capacity",
+}
+
+// CapacityQuota is the capacity quota of a Filestore tier in a region.
+type CapacityQuota struct {
+	Tier   string
+	Region string
+	// LimitBytes is UnlimitedQuota if the quota has no limit.
+	LimitBytes int64
+	UsageBytes int64
+}
+
+// AvailableBytes returns the capacity that can still be provisioned, or UnlimitedQuota.
+func (q *CapacityQuota) AvailableBytes() int64 {
+	if q.LimitBytes == UnlimitedQuota {
+		return UnlimitedQuota
+	}
+	return util.Max(q.LimitBytes-q.UsageBytes, 0)
+}
+
+type quotaInfo struct {
+	Metric          string                 `json:"metric"`
+	Dimensions      []string               `json:"dimensions"`
+	DimensionsInfos []*quotaDimensionsInfo `json:"dimensionsInfos"`
+}
+
+type quotaDimensionsInfo struct {
+	Dimensions map[string]string `json:"dimensions"`
+	Details    struct {
+		Value int64 `json:"value,string"`
+	} `json:"details"`
+}
+
+type listQuotaInfosResponse struct {
+	QuotaInfos    []*quotaInfo `json:"quotaInfos"`
+	NextPageToken string       `json:"nextPageToken"`
+}
+
+// GetCapacityQuota returns the capacity quota of the tier in the region. The limit is read from the
+// Cloud Quotas API, and the usage is the capacity of the instances of the tier in the region.
+func (manager *gcfsServiceManager) GetCapacityQuota(ctx context.Context, project, region, tier string) (*CapacityQuota, error) {
+	metric, ok := tierToQuotaMetric[strings.ToLower(tier)]
+	if !ok {
+		return nil, fmt.Errorf("no capacity quota known for tier %q", tier)
+	}
+
+	limitBytes, err := manager.getQuotaLimit(ctx, project, region, metric)
+	if err != nil {
+		return nil, err
+	}
+
+	instances, err := manager.ListInstances(ctx, &ServiceInstance{Project: project})
+	if err != nil {
+		return nil, err
+	}
+	multishareInstances, err := manager.ListMultishareInstances(ctx, &ListFilter{Project: project, Location: "-"})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CapacityQuota{
+		Tier:       tier,
+		Region:     region,
+		LimitBytes: limitBytes,
+		UsageBytes: capacityQuotaUsage(instances, multishareInstances, region, metric),
+	}, nil
+}
+
+// getQuotaLimit returns the limit in bytes of the capacity quota metric in the region. A limit set
+// for the region takes precedence over the default limit of the metric.
+func (manager *gcfsServiceManager) getQuotaLimit(ctx context.Context, project, region, metric string) (int64, error) {
+	pageToken := ""
+	for {
+		resp, err := manager.listQuotaInfos(ctx, project, pageToken)
+		if err != nil {
+			return 0, err
+		}
+
+		for _, info := range resp.QuotaInfos {
+			if info.Metric != metric {
+				continue
+			}
+			var defaultInfo *quotaDimensionsInfo
+			for _, dimensionsInfo := range info.DimensionsInfos {
+				dimensionRegion, ok := dimensionsInfo.Dimensions[quotaRegionKey]
+				if !ok {
+					defaultInfo = dimensionsInfo
+					continue
+				}
+				if dimensionRegion == region {
+					return quotaValueToBytes(dimensionsInfo.Details.Value), nil
+				}
+			}
+			if defaultInfo != nil {
+				return quotaValueToBytes(defaultInfo.Details.Value), nil
+			}
+		}
+
+		pageToken = resp.NextPageToken
+		if pageToken == "" {
+			return 0, fmt.Errorf("no quota found for metric %s in region %s of project %s", metric, region, project)
+		}
+	}
+}
+
+func (manager *gcfsServiceManager) listQuotaInfos(ctx context.Context, project, pageToken string) (*listQuotaInfosResponse, error) {
+	reqURL := manager.quotaBasePath + fmt.Sprintf(quotaInfosURIFmt, project)
+	if pageToken != "" {
+		reqURL += "?" + url.Values{"pageToken": []string{pageToken}}.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	klog.V(5).Infof("Listing quota infos %s", reqURL)
+	res, err := manager.quotaClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &listQuotaInfosResponse{}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return nil, fmt.Errorf("failed to parse quota infos of project %s: %w", project, err)
+	}
+	return resp, nil
+}
+
+// quotaValueToBytes converts a Filestore capacity quota value, in GiB, to bytes.
+func quotaValueToBytes(value int64) int64 {
+	if value < 0 {
+		return UnlimitedQuota
+	}
+	return util.GbToBytes(value)
+}
+
+// capacityQuotaUsage returns the capacity of the instances counted in the quota metric in the region.
+func capacityQuotaUsage(instances []*ServiceInstance, multishareInstances []*MultishareInstance, region, metric string) int64 {
+	var usage int64
+	for _, instance := range instances {
+		if inQuota(instance.Location, instance.Tier, region, metric) {
+			usage += instance.Volume.SizeBytes
+		}
+	}
+	for _, instance := range multishareInstances {
+		if inQuota(instance.Location, instance.Tier, region, metric) {
+			usage += instance.CapacityBytes
+		}
+	}
+	return usage
+}
+
+func inQuota(location, tier, region, metric string) bool {
+	instanceRegion, err := deduceRegion(location, "")
+	if err != nil {
+		klog.Warningf("Failed to get region of location %q: %v", location, err)
+		return false
+	}
+	return instanceRegion == region && tierToQuotaMetric[strings.ToLower(tier)] == metric
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package file
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const testQuotaInfos = `{
+  "quotaInfos": [
+    {
+      "metric": "file.googleapis.com/premium_capacity",
+      "dimensions": ["region"],
+      "dimensionsInfos": [
+        {"dimensions": {}, "details": {"value": "-1"}}
+      ]
+    },
+    {
+      "metric": "file.googleapis.com/standard_capacity",
+      "dimensions": ["region"],
+      "dimensionsInfos": [
+        {"dimensions": {"region": "us-east1"}, "details": {"value": "2048"}},
+        {"dimensions": {}, "details": {"value": "10240"}}
+      ]
+    }
+  ]
+}`
+
+func TestGetQuotaLimit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/"+fmt.Sprintf(quotaInfosURIFmt, defaultProject) {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, testQuotaInfos)
+	}))
+	defer server.Close()
+
+	cases := []struct {
+		name          string
+		project       string
+		region        string
+		metric        string
+		expectedLimit int64
+		expectErr     bool
+	}{
+		{
+			name:          "default limit",
+			project:       defaultProject,
+			region:        defaultRegion,
+			metric:        tierToQuotaMetric["standard"],
+			expectedLimit: 10 * util.Tb,
+		},
+		{
+			name:          "region limit",
+			project:       defaultProject,
+			region:        "us-east1",
+			metric:        tierToQuotaMetric["basic_hdd"],
+			expectedLimit: 2 * util.Tb,
+		},
+		{
+			name:          "unlimited",
+			project:       defaultProject,
+			region:        defaultRegion,
+			metric:        tierToQuotaMetric["premium"],
+			expectedLimit: UnlimitedQuota,
+		},
+		{
+			name:      "unknown metric",
+			project:   defaultProject,
+			region:    defaultRegion,
+			metric:    tierToQuotaMetric["enterprise"],
+			expectErr: true,
+		},
+		{
+			name:      "unknown project",
+			project:   "foo",
+			region:    defaultRegion,
+			metric:    tierToQuotaMetric["standard"],
+			expectErr: true,
+		},
+	}
+
+	manager := &gcfsServiceManager{
+		quotaClient:   server.Client(),
+		quotaBasePath: server.URL + "/",
+	}
+	for _, test := range cases {
+		limit, err := manager.getQuotaLimit(context.TODO(), test.project, test.region, test.metric)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("test %q failed: expected error, got limit %d", test.name, limit)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %q failed: unexpected error: %v", test.name, err)
+			continue
+		}
+		if limit != test.expectedLimit {
+			t.Errorf("test %q failed: expected limit %d, got %d", test.name, test.expectedLimit, limit)
+		}
+	}
+}
+
+func TestCapacityQuotaUsage(t *testing.T) {
+	instances := []*ServiceInstance{
+		{Location: "us-central1-a", Tier: "BASIC_HDD", Volume: Volume{SizeBytes: 1 * util.Tb}},
+		{Location: "us-central1-b", Tier: "STANDARD", Volume: Volume{SizeBytes: 2 * util.Tb}},
+		{Location: "us-central1-b", Tier: "PREMIUM", Volume: Volume{SizeBytes: 4 * util.Tb}},
+		{Location: "us-east1-b", Tier: "BASIC_HDD", Volume: Volume{SizeBytes: 8 * util.Tb}},
+		{Location: "us-central1", Tier: "ENTERPRISE", Volume: Volume{SizeBytes: 16 * util.Tb}},
+	}
+	multishareInstances := []*MultishareInstance{
+		{Location: "us-central1", Tier: "ENTERPRISE", CapacityBytes: 32 * util.Tb},
+		{Location: "us-east1", Tier: "ENTERPRISE", CapacityBytes: 64 * util.Tb},
+	}
+
+	cases := []struct {
+		name          string
+		region        string
+		metric        string
+		expectedUsage int64
+	}{
+		{
+			name:          "standard tier aliases",
+			region:        "us-central1",
+			metric:        tierToQuotaMetric["standard"],
+			expectedUsage: 3 * util.Tb,
+		},
+		{
+			name:          "premium tier",
+			region:        "us-central1",
+			metric:        tierToQuotaMetric["premium"],
+			expectedUsage: 4 * util.Tb,
+		},
+		{
+			name:          "enterprise tier with multishare instances",
+			region:        "us-central1",
+			metric:        tierToQuotaMetric["enterprise"],
+			expectedUsage: 48 * util.Tb,
+		},
+		{
+			name:   "no instances",
+			region: "europe-west1",
+			metric: tierToQuotaMetric["standard"],
+		},
+	}
+
+	for _, test := range cases {
+		usage := capacityQuotaUsage(instances, multishareInstances, test.region, test.metric)
+		if usage != test.expectedUsage {
+			t.Errorf("test %q failed: expected usage %d, got %d", test.name, test.expectedUsage, usage)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// Filestore can't copy a backup to another region. A copy of a backup is a separate backup of the same
+// source, with the same name, taken in the region of the copy right after the backup, which may hold the
+// changes made to the source while the backups are taken. Only the backup is the snapshot visible to the
+// CO and is restored while its region is available; its copies are restored in their regions when it
+// isn't, and are deleted along with it.
+const (
+	// tagKeyBackupCopyOf labels a copy of a backup with the region of the backup.
+	tagKeyBackupCopyOf = "storage_gke_io_backup-copy-of"
+	// tagKeyHasBackupCopies labels a backup that was copied to other regions.
+	tagKeyHasBackupCopies = "storage_gke_io_has-backup-copies"
+)
+
+// withBackupCopiesLabel marks the labels of a backup as having copies when copy locations are requested.
+func withBackupCopiesLabel(labels map[string]string, copyLocations []string) map[string]string {
+	if len(copyLocations) > 0 {
+		labels[tagKeyHasBackupCopies] = "true"
+	}
+	return labels
+}
+
+// validateBackupCopyLocations checks that the copy locations are regions, before the backup is taken.
+func validateBackupCopyLocations(copyLocations []string) error {
+	for _, location := range copyLocations {
+		if _, _, err := file.CreateBackupURI(location, "", "", location); err != nil {
+			return status.Errorf(codes.InvalidArgument, "invalid parameter %q: %v", util.VolumeSnapshotCopyLocationsKey, err.Error())
+		}
+	}
+	return nil
+}
+
+// createBackupCopies copies the backup of backupInfo to each of the copy locations other than its own
+// region, and returns the regions of the copies. Copies that already exist are not taken again, so that
+// a retried CreateSnapshot completes the missing copies.
+func createBackupCopies(ctx context.Context, fileService file.Service, backupInfo *file.BackupInfo, copyLocations []string) ([]string, error) {
+	var regions []string
+	for _, location := range copyLocations {
+		if location == backupInfo.Location {
+			continue
+		}
+		copyURI, region, err := file.CreateBackupURI(backupInfo.Location, backupInfo.Project, backupInfo.Name, location)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		existingCopy, err := fileService.GetBackup(ctx, copyURI)
+		copyExists, err := file.CheckBackupExists(existingCopy, err)
+		if err != nil {
+			return nil, err
+		}
+		if copyExists {
+			if existingCopy.Backup.Labels[tagKeyBackupCopyOf] != backupInfo.Location {
+				return nil, status.Errorf(codes.AlreadyExists, "backup %v already exists and is not a copy of backup %v", copyURI, backupInfo.BackupURI)
+			}
+			if existingCopy.Backup.State != "READY" {
+				return nil, status.Errorf(codes.DeadlineExceeded, "copy %v of backup %v not yet ready, current state %s", copyURI, backupInfo.BackupURI, existingCopy.Backup.State)
+			}
+			regions = append(regions, region)
+			continue
+		}
+
+		copyInfo := *backupInfo
+		copyInfo.BackupURI = copyURI
+		copyInfo.Location = region
+		copyInfo.Labels = make(map[string]string, len(backupInfo.Labels)+1)
+		for k, v := range backupInfo.Labels {
+			if k != tagKeyHasBackupCopies {
+				copyInfo.Labels[k] = v
+			}
+		}
+		copyInfo.Labels[tagKeyBackupCopyOf] = backupInfo.Location
+		klog.V(4).Infof("Creating copy %v of backup %v", copyURI, backupInfo.BackupURI)
+		if _, err := fileService.CreateBackup(ctx, &copyInfo); err != nil {
+			klog.Errorf("Create copy %v of backup %v failed: %v", copyURI, backupInfo.BackupURI, err.Error())
+			return nil, file.StatusError(err)
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// backupRestoreSource returns the backup to restore a volume in the location from: the backup itself, which
+// can be restored in any region, or the ready copy of the backup in the region of the location while the
+// backup can't be looked up. The copy is a separate backup of the source of the backup, so it is only
+// restored to fail over from an unavailable region.
+func backupRestoreSource(ctx context.Context, fileService file.Service, backupURI, location string) (string, error) {
+	project, backupRegion, backupName, err := parseBackupURI(backupURI)
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	_, backupErr := fileService.GetBackup(ctx, backupURI)
+	if backupErr == nil {
+		return backupURI, nil
+	}
+	if file.IsNotFoundErr(backupErr) {
+		return "", status.Errorf(codes.NotFound, "backup %v doesn't exist", backupURI)
+	}
+
+	copyURI, region, err := file.CreateBackupURI(location, project, backupName, "")
+	if err != nil || region == backupRegion {
+		return "", file.StatusError(backupErr)
+	}
+	backupCopy, err := fileService.GetBackup(ctx, copyURI)
+	if err != nil || backupCopy.Backup.Labels[tagKeyBackupCopyOf] != backupRegion || backupCopy.Backup.State != "READY" {
+		return "", file.StatusError(backupErr)
+	}
+	klog.Warningf("Failed to get backup %v, restoring its copy %v in region %v, which may hold later data: %v", backupURI, copyURI, region, backupErr.Error())
+	return copyURI, nil
+}
+
+// deleteBackupCopies deletes the copies of a backup in the other regions of its project.
+func deleteBackupCopies(ctx context.Context, fileService file.Service, backup *file.Backup) error {
+	if backup.Backup.Labels[tagKeyHasBackupCopies] != "true" {
+		return nil
+	}
+	project, backupRegion, backupName, err := parseBackupURI(backup.Backup.Name)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	backups, err := fileService.ListBackups(ctx, &file.ListFilter{Project: project})
+	if err != nil {
+		return file.StatusError(err)
+	}
+	for _, b := range backups {
+		if b.Backup.Name == backup.Backup.Name || path.Base(b.Backup.Name) != backupName || b.Backup.Labels[tagKeyBackupCopyOf] != backupRegion {
+			continue
+		}
+		if b.SourceInstance != backup.SourceInstance || b.SourceShare != backup.SourceShare {
+			continue
+		}
+		if b.Backup.State == "DELETING" {
+			return status.Errorf(codes.DeadlineExceeded, "copy %v of backup %v is in state %s", b.Backup.Name, backup.Backup.Name, b.Backup.State)
+		}
+		klog.V(4).Infof("Deleting copy %v of backup %v", b.Backup.Name, backup.Backup.Name)
+		if err := fileService.DeleteBackup(ctx, b.Backup.Name); err != nil {
+			klog.Errorf("Delete copy %v of backup %v failed: %v", b.Backup.Name, backup.Backup.Name, err.Error())
+			return file.StatusError(err)
+		}
+	}
+	return nil
+}
+
+// parseBackupURI returns the project, region and name of a backup handle,
+// projects/{project}/locations/{region}/backups/{name}.
+func parseBackupURI(backupURI string) (string, string, string, error) {
+	if isBackup, err := util.IsBackupHandle(backupURI); err != nil || !isBackup {
+		return "", "", "", fmt.Errorf("unknown backup URI format %q", backupURI)
+	}
+	splitID := strings.Split(backupURI, "/")
+	return splitID[1], splitID[3], splitID[5], nil
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	testBackupName        = "mybackup"
+	testBackupVolumeID    = modeInstance + "/us-central1-c/myinstance/myshare"
+	testBackupURI         = "projects/test-project/locations/us-central1/backups/mybackup"
+	testBackupCopyURI     = "projects/test-project/locations/us-east1/backups/mybackup"
+	testBackupEuropeURI   = "projects/test-project/locations/europe-west1/backups/mybackup"
+	testBackupCopyRegion  = "us-east1"
+	testBackupCopyRegion2 = "europe-west1"
+)
+
+func createTestBackup(t *testing.T, fileService file.Service, backupURI, location string, labels map[string]string) {
+	_, err := fileService.CreateBackup(context.TODO(), &file.BackupInfo{
+		Name:               testBackupName,
+		Project:            testProject,
+		Location:           location,
+		SourceInstanceName: "myinstance",
+		SourceShare:        "myshare",
+		SourceVolumeId:     testBackupVolumeID,
+		BackupURI:          backupURI,
+		Labels:             labels,
+	})
+	if err != nil {
+		t.Fatalf("failed to create backup %v: %v", backupURI, err)
+	}
+}
+
+func TestCreateSnapshotWithBackupCopies(t *testing.T) {
+	cases := []struct {
+		name           string
+		params         map[string]string
+		expectedCopies []string
+		expectErr      codes.Code
+	}{
+		{
+			name: "copies in other regions",
+			params: map[string]string{
+				util.VolumeSnapshotTypeKey:          util.VolumeSnapshotTypeBackup,
+				util.VolumeSnapshotCopyLocationsKey: "us-east1, europe-west1,us-east1",
+			},
+			expectedCopies: []string{testBackupCopyURI, testBackupEuropeURI},
+		},
+		{
+			name: "copy in the region of the backup is skipped",
+			params: map[string]string{
+				util.VolumeSnapshotTypeKey:          util.VolumeSnapshotTypeBackup,
+				util.VolumeSnapshotCopyLocationsKey: "us-central1,us-east1",
+			},
+			expectedCopies: []string{testBackupCopyURI},
+		},
+		{
+			name: "invalid copy location",
+			params: map[string]string{
+				util.VolumeSnapshotTypeKey:          util.VolumeSnapshotTypeBackup,
+				util.VolumeSnapshotCopyLocationsKey: "us-east1-b",
+			},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name: "copies of a Filestore snapshot",
+			params: map[string]string{
+				util.VolumeSnapshotTypeKey:          util.VolumeSnapshotTypeSnapshot,
+				util.VolumeSnapshotCopyLocationsKey: "us-east1",
+			},
+			expectErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := initTestController(t).(*controllerServer)
+			cs.config.tagManager.(*cloud.FakeTagServiceManager).
+				On("AttachResourceTags", context.TODO(), cloud.FilestoreBackUp, testBackupName, mock.Anything, testBackupName, tc.params).
+				Return(nil)
+			req := &csi.CreateSnapshotRequest{
+				SourceVolumeId: testBackupVolumeID,
+				Name:           testBackupName,
+				Parameters:     tc.params,
+			}
+
+			// The second call checks that copies are not taken again.
+			for i := 0; i < 2; i++ {
+				resp, err := cs.CreateSnapshot(context.TODO(), req)
+				if tc.expectErr != codes.OK {
+					if status.Code(err) != tc.expectErr {
+						t.Fatalf("expected error code %v, got %v", tc.expectErr, err)
+					}
+					if _, err := cs.config.fileService.GetBackup(context.TODO(), testBackupURI); !file.IsNotFoundErr(err) {
+						t.Errorf("backup %v was taken: %v", testBackupURI, err)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				if resp.GetSnapshot().GetSnapshotId() != testBackupURI {
+					t.Errorf("got snapshot id %v, expected %v", resp.GetSnapshot().GetSnapshotId(), testBackupURI)
+				}
+			}
+
+			backups, err := cs.config.fileService.ListBackups(context.TODO(), &file.ListFilter{Project: testProject})
+			if err != nil {
+				t.Fatalf("failed to list backups: %v", err)
+			}
+			if len(backups) != len(tc.expectedCopies)+1 {
+				t.Errorf("got %d backups, expected the backup and %d copies", len(backups), len(tc.expectedCopies))
+			}
+			backup, err := cs.config.fileService.GetBackup(context.TODO(), testBackupURI)
+			if err != nil {
+				t.Fatalf("failed to get backup: %v", err)
+			}
+			if backup.Backup.Labels[tagKeyHasBackupCopies] != "true" {
+				t.Errorf("backup %v is not labeled as having copies: %v", testBackupURI, backup.Backup.Labels)
+			}
+			for _, copyURI := range tc.expectedCopies {
+				backupCopy, err := cs.config.fileService.GetBackup(context.TODO(), copyURI)
+				if err != nil {
+					t.Fatalf("failed to get copy %v: %v", copyURI, err)
+				}
+				if backupCopy.Backup.Labels[tagKeyBackupCopyOf] != testRegion {
+					t.Errorf("copy %v is not labeled as a copy of region %v: %v", copyURI, testRegion, backupCopy.Backup.Labels)
+				}
+				if backupCopy.SourceInstance != backup.SourceInstance || backupCopy.SourceShare != backup.SourceShare {
+					t.Errorf("copy %v has source %v/%v, expected %v/%v", copyURI, backupCopy.SourceInstance, backupCopy.SourceShare, backup.SourceInstance, backup.SourceShare)
+				}
+			}
+		})
+	}
+}
+
+// unavailableBackupFileService fails the lookups of the backups in an unavailable region.
+type unavailableBackupFileService struct {
+	file.Service
+	unavailableRegion string
+}
+
+func (s *unavailableBackupFileService) GetBackup(ctx context.Context, backupURI string) (*file.Backup, error) {
+	if _, region, _, err := parseBackupURI(backupURI); err == nil && region == s.unavailableRegion {
+		return nil, &googleapi.Error{Code: http.StatusServiceUnavailable, Message: "region unavailable"}
+	}
+	return s.Service.GetBackup(ctx, backupURI)
+}
+
+func TestBackupRestoreSource(t *testing.T) {
+	copyLabels := map[string]string{tagKeyBackupCopyOf: testRegion}
+	cases := []struct {
+		name              string
+		location          string
+		primaryExists     bool
+		backupUnavailable bool
+		copyLabels        map[string]string
+		expectedBackup    string
+		expectErr         codes.Code
+	}{
+		{
+			name:           "backup with a copy in the region of the zone",
+			location:       "us-east1-b",
+			primaryExists:  true,
+			copyLabels:     copyLabels,
+			expectedBackup: testBackupURI,
+		},
+		{
+			name:           "backup in the region of the zone",
+			location:       testZone,
+			primaryExists:  true,
+			copyLabels:     copyLabels,
+			expectedBackup: testBackupURI,
+		},
+		{
+			name:              "copy in the region of the zone while the backup is unavailable",
+			location:          "us-east1-b",
+			primaryExists:     true,
+			backupUnavailable: true,
+			copyLabels:        copyLabels,
+			expectedBackup:    testBackupCopyURI,
+		},
+		{
+			name:              "copy in the region while the backup is unavailable",
+			location:          testBackupCopyRegion,
+			primaryExists:     true,
+			backupUnavailable: true,
+			copyLabels:        copyLabels,
+			expectedBackup:    testBackupCopyURI,
+		},
+		{
+			name:              "no copy in the region while the backup is unavailable",
+			location:          "europe-west1-b",
+			primaryExists:     true,
+			backupUnavailable: true,
+			copyLabels:        copyLabels,
+			expectErr:         codes.Internal,
+		},
+		{
+			name:              "backup of the same name in the region is not a copy",
+			location:          "us-east1-b",
+			primaryExists:     true,
+			backupUnavailable: true,
+			copyLabels:        map[string]string{},
+			expectErr:         codes.Internal,
+		},
+		{
+			name:       "copy of a deleted backup",
+			location:   "us-east1-b",
+			copyLabels: copyLabels,
+			expectErr:  codes.NotFound,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fileService, err := file.NewFakeService()
+			if err != nil {
+				t.Fatalf("failed to initialize GCFS service: %v", err)
+			}
+			if tc.primaryExists {
+				createTestBackup(t, fileService, testBackupURI, testRegion, map[string]string{tagKeyHasBackupCopies: "true"})
+			}
+			if tc.copyLabels != nil {
+				createTestBackup(t, fileService, testBackupCopyURI, testBackupCopyRegion, tc.copyLabels)
+			}
+			if tc.backupUnavailable {
+				fileService = &unavailableBackupFileService{Service: fileService, unavailableRegion: testRegion}
+			}
+
+			backup, err := backupRestoreSource(context.TODO(), fileService, testBackupURI, tc.location)
+			if tc.expectErr != codes.OK {
+				if status.Code(err) != tc.expectErr {
+					t.Fatalf("got backup %v and error %v, expected error code %v", backup, err, tc.expectErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if backup != tc.expectedBackup {
+				t.Errorf("got backup %v, expected %v", backup, tc.expectedBackup)
+			}
+		})
+	}
+}
+
+func TestDeleteSnapshotWithBackupCopies(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	createTestBackup(t, cs.config.fileService, testBackupURI, testRegion, map[string]string{tagKeyHasBackupCopies: "true"})
+	createTestBackup(t, cs.config.fileService, testBackupCopyURI, testBackupCopyRegion, map[string]string{tagKeyBackupCopyOf: testRegion})
+	// A backup of the same name and source which is not a copy of the deleted backup.
+	createTestBackup(t, cs.config.fileService, testBackupEuropeURI, testBackupCopyRegion2, nil)
+
+	if _, err := cs.DeleteSnapshot(context.TODO(), &csi.DeleteSnapshotRequest{SnapshotId: testBackupURI}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, backupURI := range []string{testBackupURI, testBackupCopyURI} {
+		if _, err := cs.config.fileService.GetBackup(context.TODO(), backupURI); !file.IsNotFoundErr(err) {
+			t.Errorf("backup %v was not deleted: %v", backupURI, err)
+		}
+	}
+	if _, err := cs.config.fileService.GetBackup(context.TODO(), testBackupEuropeURI); err != nil {
+		t.Errorf("backup %v which is not a copy was deleted: %v", testBackupEuropeURI, err)
+	}
+}
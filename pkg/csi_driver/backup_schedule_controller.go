@@ -0,0 +1,445 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strings"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/wait"
+	coreInformers "k8s.io/client-go/informers/core/v1"
+	coreListers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/backup/v1"
+	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+	informers "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions/backup/v1"
+	listers "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/listers/backup/v1"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// The backups taken by a BackupSchedule are labeled with its name and namespace.
+	tagKeyBackupScheduleName      = "storage_gke_io_created-for_backup_schedule_name"
+	tagKeyBackupScheduleNamespace = "storage_gke_io_created-for_backup_schedule_namespace"
+
+	// maxScheduleNameInBackupName is the length of the BackupSchedule name kept in the names of its backups,
+	// the rest of the 63 characters of a backup id is used by the schedule hash and the scheduled time.
+	maxScheduleNameInBackupName = 40
+	scheduledBackupTimeFormat   = "200601021504"
+)
+
+// BackupScheduleController takes Filestore backups of the volumes of PersistentVolumeClaims on the cron
+// schedules of the BackupSchedule objects, and deletes the backups that are out of their retention.
+type BackupScheduleController struct {
+	clientset         clientset.Interface
+	cloud             *cloud.Cloud
+	driverName        string
+	extraVolumeLabels map[string]string
+	syncPeriod        time.Duration
+
+	scheduleLister       listers.BackupScheduleLister
+	scheduleListerSynced cache.InformerSynced
+
+	pvcLister       coreListers.PersistentVolumeClaimLister
+	pvcListerSynced cache.InformerSynced
+	pvLister        coreListers.PersistentVolumeLister
+	pvListerSynced  cache.InformerSynced
+
+	// inflight holds the schedules being synced, a backup takes minutes to be created.
+	inflight *util.VolumeLocks
+	now      func() time.Time
+}
+
+func NewBackupScheduleController(
+	clientset clientset.Interface,
+	config *GCFSDriverConfig,
+	scheduleInformer informers.BackupScheduleInformer,
+	pvcInformer coreInformers.PersistentVolumeClaimInformer,
+	pvInformer coreInformers.PersistentVolumeInformer,
+) *BackupScheduleController {
+	c := &BackupScheduleController{
+		clientset:         clientset,
+		cloud:             config.Cloud,
+		driverName:        config.Name,
+		extraVolumeLabels: config.ExtraVolumeLabels,
+		syncPeriod:        config.FeatureOptions.FeatureBackupSchedule.SyncPeriod,
+		inflight:          util.NewVolumeLocks(),
+		now:               time.Now,
+	}
+
+	c.scheduleLister = scheduleInformer.Lister()
+	c.scheduleListerSynced = scheduleInformer.Informer().HasSynced
+
+	c.pvcLister = pvcInformer.Lister()
+	c.pvcListerSynced = pvcInformer.Informer().HasSynced
+	c.pvLister = pvInformer.Lister()
+	c.pvListerSynced = pvInformer.Informer().HasSynced
+
+	return c
+}
+
+func (c *BackupScheduleController) Run(stopCh <-chan struct{}) {
+	defer klog.Infof("Shutting down backup schedule controller")
+
+	klog.Infof("Starting cache sync")
+	if !cache.WaitForCacheSync(stopCh, c.scheduleListerSynced, c.pvcListerSynced, c.pvListerSynced) {
+		klog.Errorf("Cannot sync caches")
+		return
+	}
+
+	klog.Infof("Cache synced, starting backup schedule controller")
+
+	go wait.Until(c.syncWorker, c.syncPeriod, stopCh)
+
+	<-stopCh
+}
+
+// syncWorker syncs all the schedules. Each schedule is synced in its own goroutine so that a backup being
+// created doesn't delay the backups of the other schedules.
+func (c *BackupScheduleController) syncWorker() {
+	schedules, err := c.scheduleLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list backup schedules: %v", err)
+		return
+	}
+
+	for _, schedule := range schedules {
+		key := schedule.Namespace + "/" + schedule.Name
+		if !c.inflight.TryAcquire(key) {
+			klog.V(4).Infof("Backup schedule %s is still being synced", key)
+			continue
+		}
+		go func(schedule *v1.BackupSchedule) {
+			defer c.inflight.Release(key)
+			if err := c.syncSchedule(context.Background(), schedule.DeepCopy()); err != nil {
+				klog.Errorf("Failed to sync backup schedule %s: %v", key, err)
+			}
+		}(schedule)
+	}
+}
+
+// syncSchedule takes the last missed backup of the schedule if any, applies the retention of the schedule
+// and records its status. Only the last missed backup is taken, after the controller was down for
+// several runs of the schedule.
+func (c *BackupScheduleController) syncSchedule(ctx context.Context, schedule *v1.BackupSchedule) error {
+	now := c.now()
+	scheduleStatus := &v1.BackupScheduleStatus{}
+	if schedule.Status != nil {
+		scheduleStatus = schedule.Status.DeepCopy()
+	}
+	scheduleStatus.Error = ""
+
+	err := c.takeAndPruneBackups(ctx, schedule, scheduleStatus, now)
+	if err != nil {
+		scheduleStatus.Error = err.Error()
+	}
+	// The status is only updated when it changes, not on every sync.
+	if schedule.Status == nil || !equality.Semantic.DeepEqual(schedule.Status, scheduleStatus) {
+		if updateErr := c.updateScheduleStatus(ctx, schedule, scheduleStatus); updateErr != nil {
+			return updateErr
+		}
+	}
+	return err
+}
+
+func (c *BackupScheduleController) takeAndPruneBackups(ctx context.Context, schedule *v1.BackupSchedule, scheduleStatus *v1.BackupScheduleStatus, now time.Time) error {
+	cron, err := util.ParseCronSchedule(schedule.Spec.Schedule)
+	if err != nil {
+		return err
+	}
+	volumeID, err := c.scheduleVolumeHandle(schedule)
+	if err != nil {
+		return err
+	}
+	source, err := scheduledBackupSource(volumeID, c.cloud.Project)
+	if err != nil {
+		return err
+	}
+	_, region, err := file.CreateBackupURI(source.Location, source.Project, "", schedule.Spec.BackupLocation)
+	if err != nil {
+		return err
+	}
+
+	backups, err := c.listScheduledBackups(ctx, source.Project, region, schedule)
+	if err != nil {
+		return err
+	}
+
+	var backupErr error
+	if !schedule.Spec.Suspend {
+		since := schedule.CreationTimestamp.Time
+		if scheduleStatus.LastScheduleTime != nil {
+			since = scheduleStatus.LastScheduleTime.Time
+		}
+		if scheduledTime := lastMissedRun(cron, since, now); !scheduledTime.IsZero() {
+			backup, err := c.takeScheduledBackup(ctx, schedule, source, scheduledTime, backups)
+			if err != nil {
+				// The backup is retried on the next sync, until the next run of the schedule.
+				backupErr = fmt.Errorf("failed to take backup of volume %s scheduled at %v: %w", volumeID, scheduledTime, err)
+			} else {
+				if backup != nil {
+					backups = append(backups, backup)
+				}
+				scheduleStatus.LastScheduleTime = &metav1.Time{Time: scheduledTime}
+				scheduleStatus.LastSuccessfulTime = &metav1.Time{Time: now}
+			}
+		}
+	}
+
+	var remaining []*file.Backup
+	deleted := map[string]bool{}
+	for _, backup := range backupsOutOfRetention(schedule.Spec.Retention, backups, now) {
+		klog.Infof("Deleting backup %s of backup schedule %s/%s out of retention", backup.Backup.Name, schedule.Namespace, schedule.Name)
+		if err := c.cloud.File.DeleteBackup(ctx, backup.Backup.Name); err != nil && !file.IsNotFoundErr(err) {
+			return fmt.Errorf("failed to delete backup %s out of retention: %w", backup.Backup.Name, err)
+		}
+		deleted[backup.Backup.Name] = true
+	}
+	for _, backup := range backups {
+		if !deleted[backup.Backup.Name] {
+			remaining = append(remaining, backup)
+		}
+	}
+	scheduleStatus.Backups = scheduledBackupsStatus(remaining)
+	return backupErr
+}
+
+// takeScheduledBackup creates the backup of the scheduled time, unless it already exists. It returns the
+// new backup, or nil if the backup already exists.
+func (c *BackupScheduleController) takeScheduledBackup(ctx context.Context, schedule *v1.BackupSchedule, source *file.BackupInfo, scheduledTime time.Time, backups []*file.Backup) (*file.Backup, error) {
+	name := scheduledBackupName(schedule, scheduledTime)
+	backupURI, region, err := file.CreateBackupURI(source.Location, source.Project, name, schedule.Spec.BackupLocation)
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		if backup.Backup.Name == backupURI {
+			return nil, nil
+		}
+	}
+
+	backupLabels, err := mergeLabels(schedule.Spec.Labels, map[string]string{
+		tagKeyCreatedBy:                strings.ReplaceAll(c.driverName, ".", "_"),
+		tagKeyCreatedForClaimName:      schedule.Spec.PersistentVolumeClaimName,
+		tagKeyCreatedForClaimNamespace: schedule.Namespace,
+		tagKeyBackupScheduleName:       schedule.Name,
+		tagKeyBackupScheduleNamespace:  schedule.Namespace,
+	}, c.extraVolumeLabels)
+	if err != nil {
+		return nil, err
+	}
+
+	backupInfo := *source
+	backupInfo.Name = name
+	backupInfo.BackupURI = backupURI
+	backupInfo.Location = region
+	backupInfo.Labels = backupLabels
+	klog.Infof("Taking backup %s of volume %s for backup schedule %s/%s", backupURI, source.SourceVolumeId, schedule.Namespace, schedule.Name)
+	backupObj, err := c.cloud.File.CreateBackup(ctx, &backupInfo)
+	if err != nil {
+		return nil, err
+	}
+	return &file.Backup{
+		Backup:         backupObj,
+		SourceInstance: backupObj.SourceInstance,
+		SourceShare:    backupObj.SourceFileShare,
+	}, nil
+}
+
+// scheduleVolumeHandle returns the CSI volume handle of the PersistentVolumeClaim of the schedule.
+func (c *BackupScheduleController) scheduleVolumeHandle(schedule *v1.BackupSchedule) (string, error) {
+	pvcName := schedule.Spec.PersistentVolumeClaimName
+	if pvcName == "" {
+		return "", fmt.Errorf("persistentVolumeClaimName must be provided")
+	}
+	pvc, err := c.pvcLister.PersistentVolumeClaims(schedule.Namespace).Get(pvcName)
+	if err != nil {
+		return "", err
+	}
+	if pvc.Spec.VolumeName == "" {
+		return "", fmt.Errorf("persistent volume claim %s/%s is not bound", schedule.Namespace, pvcName)
+	}
+	pv, err := c.pvLister.Get(pvc.Spec.VolumeName)
+	if err != nil {
+		return "", err
+	}
+	if pv.Spec.CSI == nil || pv.Spec.CSI.Driver != c.driverName {
+		return "", fmt.Errorf("persistent volume %s of claim %s/%s is not provisioned by %s", pv.Name, schedule.Namespace, pvcName, c.driverName)
+	}
+	return pv.Spec.CSI.VolumeHandle, nil
+}
+
+// listScheduledBackups lists the backups taken by the schedule.
+func (c *BackupScheduleController) listScheduledBackups(ctx context.Context, project, region string, schedule *v1.BackupSchedule) ([]*file.Backup, error) {
+	backups, err := c.cloud.File.ListBackups(ctx, &file.ListFilter{Project: project, Location: region})
+	if err != nil {
+		return nil, err
+	}
+	var scheduled []*file.Backup
+	for _, backup := range backups {
+		if backup.Backup.Labels[tagKeyBackupScheduleName] == schedule.Name && backup.Backup.Labels[tagKeyBackupScheduleNamespace] == schedule.Namespace {
+			scheduled = append(scheduled, backup)
+		}
+	}
+	return scheduled, nil
+}
+
+func (c *BackupScheduleController) updateScheduleStatus(ctx context.Context, schedule *v1.BackupSchedule, scheduleStatus *v1.BackupScheduleStatus) error {
+	scheduleClone := schedule.DeepCopy()
+	scheduleClone.Status = scheduleStatus
+	_, err := c.clientset.BackupV1().BackupSchedules(schedule.Namespace).UpdateStatus(ctx, scheduleClone, metav1.UpdateOptions{})
+	return err
+}
+
+// scheduledBackupSource returns the source of the backups of a volume. The name, uri, location and labels
+// of the backup are left to be set.
+func scheduledBackupSource(volumeID, project string) (*file.BackupInfo, error) {
+	if isMultishareVolId(volumeID) {
+		_, _, location, instanceName, shareName, err := parseMultishareVolId(volumeID)
+		if err != nil {
+			return nil, err
+		}
+		return &file.BackupInfo{
+			SourceVolumeId:     fmt.Sprintf("%s/%s/%s/%s", modeMultishare, location, instanceName, shareName),
+			Project:            project,
+			Location:           location,
+			SourceInstanceName: instanceName,
+			SourceShare:        shareName,
+		}, nil
+	}
+
+	filer, _, err := getFileInstanceFromID(volumeID)
+	if err != nil {
+		return nil, err
+	}
+	return &file.BackupInfo{
+		SourceVolumeId:     volumeID,
+		Project:            project,
+		Location:           filer.Location,
+		SourceInstanceName: filer.Name,
+		SourceShare:        filer.Volume.Name,
+	}, nil
+}
+
+// scheduledBackupName returns the name of the backup of the schedule at the scheduled time,
+// {schedule name}-{hash of the schedule namespace and name}-{scheduled time}.
+func scheduledBackupName(schedule *v1.BackupSchedule, scheduledTime time.Time) string {
+	name := strings.ReplaceAll(schedule.Name, ".", "-")
+	if len(name) > maxScheduleNameInBackupName {
+		name = strings.TrimRight(name[:maxScheduleNameInBackupName], "-")
+	}
+	// Backup names must start with a letter.
+	if name[0] < 'a' || name[0] > 'z' {
+		name = "b" + name
+	}
+	h := fnv.New32a()
+	h.Write([]byte(schedule.Namespace + "/" + schedule.Name))
+	return fmt.Sprintf("%s-%08x-%s", name, h.Sum32(), scheduledTime.UTC().Format(scheduledBackupTimeFormat))
+}
+
+// lastMissedRun returns the last run of the schedule after since and not after now, or the zero time if
+// there is none. The last run is bisected rather than scanned from since, so that the lookup takes a
+// few steps after a long outage or with a schedule that runs every minute.
+func lastMissedRun(cron *util.CronSchedule, since, now time.Time) time.Time {
+	runsAfter := func(t time.Time) bool {
+		next := cron.Next(t)
+		return !next.IsZero() && !next.After(now)
+	}
+	if !runsAfter(since) {
+		return time.Time{}
+	}
+	// The schedule runs after lo and not after now, but not after hi. Once they are a minute apart, the
+	// schedule runs once between them.
+	lo, hi := since, now
+	for hi.Sub(lo) > time.Minute {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		if runsAfter(mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return cron.Next(lo)
+}
+
+// backupsOutOfRetention returns the ready backups which none of the retention rules keeps. Backups
+// are never deleted without a retention rule.
+func backupsOutOfRetention(retention *v1.BackupRetention, backups []*file.Backup, now time.Time) []*file.Backup {
+	if retention == nil || (retention.KeepLast <= 0 && retention.KeepWithin == nil) {
+		return nil
+	}
+
+	var ready []*file.Backup
+	createTimes := map[*file.Backup]time.Time{}
+	for _, backup := range backups {
+		if backup.Backup.State != "READY" {
+			continue
+		}
+		createTime, err := time.Parse(time.RFC3339, backup.Backup.CreateTime)
+		if err != nil {
+			klog.Warningf("Backup %s has an invalid creation time %q: %v", backup.Backup.Name, backup.Backup.CreateTime, err)
+			continue
+		}
+		ready = append(ready, backup)
+		createTimes[backup] = createTime
+	}
+	// Newest first.
+	sort.Slice(ready, func(i, j int) bool {
+		return createTimes[ready[i]].After(createTimes[ready[j]])
+	})
+
+	var out []*file.Backup
+	for i, backup := range ready {
+		if int32(i) < retention.KeepLast {
+			continue
+		}
+		if retention.KeepWithin != nil && createTimes[backup].After(now.Add(-retention.KeepWithin.Duration)) {
+			continue
+		}
+		out = append(out, backup)
+	}
+	return out
+}
+
+func scheduledBackupsStatus(backups []*file.Backup) []v1.ScheduledBackup {
+	var scheduled []v1.ScheduledBackup
+	for _, backup := range backups {
+		createTime, err := time.Parse(time.RFC3339, backup.Backup.CreateTime)
+		if err != nil {
+			klog.Warningf("Backup %s has an invalid creation time %q: %v", backup.Backup.Name, backup.Backup.CreateTime, err)
+		}
+		scheduled = append(scheduled, v1.ScheduledBackup{
+			BackupHandle: backup.Backup.Name,
+			CreationTime: metav1.Time{Time: createTime},
+			State:        backup.Backup.State,
+		})
+	}
+	sort.Slice(scheduled, func(i, j int) bool {
+		return scheduled[i].CreationTime.Before(&scheduled[j].CreationTime)
+	})
+	return scheduled
+}
@@ -0,0 +1,448 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	filev1beta1 "google.golang.org/api/file/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	coreListers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/backup/v1"
+	fsfake "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	listers "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/listers/backup/v1"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	testScheduleName      = "nightly"
+	testScheduleNamespace = "default"
+	testScheduleDriver    = "test-driver"
+	testSchedulePVCName   = "data"
+	testSchedulePVName    = "pv-data"
+	testScheduleVolumeID  = modeInstance + "/" + testZone + "/myinstance/vol1"
+)
+
+func initTestBackupScheduleController(t *testing.T, schedule *v1.BackupSchedule, pvc *corev1.PersistentVolumeClaim, pv *corev1.PersistentVolume, now time.Time) (*BackupScheduleController, file.Service) {
+	fileService, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	cloudProvider, err := cloud.NewFakeCloud()
+	if err != nil {
+		t.Fatalf("Failed to get cloud provider: %v", err)
+	}
+	cloudProvider.File = fileService
+
+	scheduleIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	scheduleIndexer.Add(schedule)
+	pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	if pvc != nil {
+		pvcIndexer.Add(pvc)
+	}
+	pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	if pv != nil {
+		pvIndexer.Add(pv)
+	}
+
+	return &BackupScheduleController{
+		clientset:      fsfake.NewSimpleClientset(schedule),
+		cloud:          cloudProvider,
+		driverName:     testScheduleDriver,
+		scheduleLister: listers.NewBackupScheduleLister(scheduleIndexer),
+		pvcLister:      coreListers.NewPersistentVolumeClaimLister(pvcIndexer),
+		pvLister:       coreListers.NewPersistentVolumeLister(pvIndexer),
+		inflight:       util.NewVolumeLocks(),
+		now:            func() time.Time { return now },
+	}, fileService
+}
+
+func newTestBackupSchedule(created time.Time, spec v1.BackupScheduleSpec) *v1.BackupSchedule {
+	if spec.PersistentVolumeClaimName == "" {
+		spec.PersistentVolumeClaimName = testSchedulePVCName
+	}
+	if spec.Schedule == "" {
+		spec.Schedule = "0 2 * * *"
+	}
+	return &v1.BackupSchedule{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              testScheduleName,
+			Namespace:         testScheduleNamespace,
+			CreationTimestamp: metav1.Time{Time: created},
+		},
+		Spec: spec,
+	}
+}
+
+func newTestSchedulePVCAndPV(bound bool, driver string) (*corev1.PersistentVolumeClaim, *corev1.PersistentVolume) {
+	pvc := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{Name: testSchedulePVCName, Namespace: testScheduleNamespace},
+	}
+	if bound {
+		pvc.Spec.VolumeName = testSchedulePVName
+	}
+	pv := &corev1.PersistentVolume{
+		ObjectMeta: metav1.ObjectMeta{Name: testSchedulePVName},
+		Spec: corev1.PersistentVolumeSpec{
+			PersistentVolumeSource: corev1.PersistentVolumeSource{
+				CSI: &corev1.CSIPersistentVolumeSource{
+					Driver:       driver,
+					VolumeHandle: testScheduleVolumeID,
+				},
+			},
+		},
+	}
+	return pvc, pv
+}
+
+func TestSyncBackupSchedule(t *testing.T) {
+	created := time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC)
+	run := time.Date(2024, time.January, 11, 2, 0, 0, 0, time.UTC)
+	backupName := scheduledBackupName(newTestBackupSchedule(created, v1.BackupScheduleSpec{}), run)
+	expectedBackupURI := fmt.Sprintf(backupURIFmt, testProject, testRegion, backupName)
+
+	cases := []struct {
+		name                 string
+		spec                 v1.BackupScheduleSpec
+		pvcBound             bool
+		pvDriver             string
+		now                  time.Time
+		syncs                int
+		expectedBackups      []string
+		expectedLastSchedule *time.Time
+		expectErr            bool
+	}{
+		{
+			name:                 "backup is due",
+			pvcBound:             true,
+			pvDriver:             testScheduleDriver,
+			now:                  run.Add(30 * time.Second),
+			syncs:                1,
+			expectedBackups:      []string{expectedBackupURI},
+			expectedLastSchedule: &run,
+		},
+		{
+			name:                 "backup is due, synced twice",
+			pvcBound:             true,
+			pvDriver:             testScheduleDriver,
+			now:                  run.Add(30 * time.Second),
+			syncs:                2,
+			expectedBackups:      []string{expectedBackupURI},
+			expectedLastSchedule: &run,
+		},
+		{
+			name:     "backup is not due",
+			pvcBound: true,
+			pvDriver: testScheduleDriver,
+			now:      run.Add(-time.Minute),
+			syncs:    1,
+		},
+		{
+			name:     "schedule is suspended",
+			spec:     v1.BackupScheduleSpec{Suspend: true},
+			pvcBound: true,
+			pvDriver: testScheduleDriver,
+			now:      run.Add(30 * time.Second),
+			syncs:    1,
+		},
+		{
+			name:                 "missed runs only take the last backup",
+			pvcBound:             true,
+			pvDriver:             testScheduleDriver,
+			now:                  run.Add(48*time.Hour + time.Minute),
+			syncs:                1,
+			expectedBackups:      []string{fmt.Sprintf(backupURIFmt, testProject, testRegion, scheduledBackupName(newTestBackupSchedule(created, v1.BackupScheduleSpec{}), run.Add(48*time.Hour)))},
+			expectedLastSchedule: func() *time.Time { t := run.Add(48 * time.Hour); return &t }(),
+		},
+		{
+			name:      "claim is not bound",
+			pvDriver:  testScheduleDriver,
+			now:       run.Add(30 * time.Second),
+			syncs:     1,
+			expectErr: true,
+		},
+		{
+			name:      "volume of another driver",
+			pvcBound:  true,
+			pvDriver:  "other-driver",
+			now:       run.Add(30 * time.Second),
+			syncs:     1,
+			expectErr: true,
+		},
+		{
+			name:      "invalid schedule",
+			spec:      v1.BackupScheduleSpec{Schedule: "0 25 * * *"},
+			pvcBound:  true,
+			pvDriver:  testScheduleDriver,
+			now:       run.Add(30 * time.Second),
+			syncs:     1,
+			expectErr: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			schedule := newTestBackupSchedule(created, tc.spec)
+			pvc, pv := newTestSchedulePVCAndPV(tc.pvcBound, tc.pvDriver)
+			c, fileService := initTestBackupScheduleController(t, schedule, pvc, pv, tc.now)
+
+			var err error
+			for i := 0; i < tc.syncs; i++ {
+				if i > 0 {
+					schedule, _ = c.clientset.BackupV1().BackupSchedules(testScheduleNamespace).Get(context.TODO(), testScheduleName, metav1.GetOptions{})
+				}
+				err = c.syncSchedule(context.TODO(), schedule)
+			}
+			if tc.expectErr != (err != nil) {
+				t.Fatalf("expected error %v, got %v", tc.expectErr, err)
+			}
+
+			backups, _ := fileService.ListBackups(context.TODO(), &file.ListFilter{Project: testProject, Location: testRegion})
+			if len(backups) != len(tc.expectedBackups) {
+				t.Fatalf("expected %d backups, got %d", len(tc.expectedBackups), len(backups))
+			}
+			for i, backup := range backups {
+				if backup.Backup.Name != tc.expectedBackups[i] {
+					t.Errorf("expected backup %s, got %s", tc.expectedBackups[i], backup.Backup.Name)
+				}
+				if backup.Backup.Labels[tagKeyBackupScheduleName] != testScheduleName || backup.Backup.Labels[tagKeyBackupScheduleNamespace] != testScheduleNamespace {
+					t.Errorf("backup %s is missing the schedule labels: %v", backup.Backup.Name, backup.Backup.Labels)
+				}
+			}
+
+			updated, getErr := c.clientset.BackupV1().BackupSchedules(testScheduleNamespace).Get(context.TODO(), testScheduleName, metav1.GetOptions{})
+			if getErr != nil {
+				t.Fatalf("failed to get backup schedule: %v", getErr)
+			}
+			if updated.Status == nil {
+				t.Fatalf("expected backup schedule status to be set")
+			}
+			if tc.expectErr && updated.Status.Error == "" {
+				t.Errorf("expected error in backup schedule status")
+			}
+			if len(updated.Status.Backups) != len(tc.expectedBackups) {
+				t.Errorf("expected %d backups in status, got %d", len(tc.expectedBackups), len(updated.Status.Backups))
+			}
+			if tc.expectedLastSchedule == nil {
+				if updated.Status.LastScheduleTime != nil {
+					t.Errorf("expected no last schedule time, got %v", updated.Status.LastScheduleTime)
+				}
+			} else if updated.Status.LastScheduleTime == nil || !updated.Status.LastScheduleTime.Time.Equal(*tc.expectedLastSchedule) {
+				t.Errorf("expected last schedule time %v, got %v", tc.expectedLastSchedule, updated.Status.LastScheduleTime)
+			}
+		})
+	}
+}
+
+func TestSyncBackupScheduleStatusUnchanged(t *testing.T) {
+	created := time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC)
+	schedule := newTestBackupSchedule(created, v1.BackupScheduleSpec{})
+	pvc, pv := newTestSchedulePVCAndPV(true, testScheduleDriver)
+	c, _ := initTestBackupScheduleController(t, schedule, pvc, pv, created.Add(time.Hour))
+
+	for i := 0; i < 3; i++ {
+		if i > 0 {
+			schedule, _ = c.clientset.BackupV1().BackupSchedules(testScheduleNamespace).Get(context.TODO(), testScheduleName, metav1.GetOptions{})
+		}
+		if err := c.syncSchedule(context.TODO(), schedule); err != nil {
+			t.Fatalf("failed to sync backup schedule: %v", err)
+		}
+	}
+
+	updates := 0
+	for _, action := range c.clientset.(*fsfake.Clientset).Actions() {
+		if action.GetVerb() == "update" && action.GetSubresource() == "status" {
+			updates++
+		}
+	}
+	if updates != 1 {
+		t.Errorf("expected the status to be updated once, got %d updates", updates)
+	}
+}
+
+func TestLastMissedRun(t *testing.T) {
+	at := func(month time.Month, day, hour, min int) time.Time {
+		return time.Date(2024, month, day, hour, min, 0, 0, time.UTC)
+	}
+	cases := []struct {
+		name     string
+		schedule string
+		since    time.Time
+		now      time.Time
+		expected time.Time
+	}{
+		{
+			name:     "no run since",
+			schedule: "0 2 * * *",
+			since:    at(time.January, 10, 2, 0),
+			now:      at(time.January, 11, 1, 59),
+		},
+		{
+			name:     "run at now",
+			schedule: "0 2 * * *",
+			since:    at(time.January, 10, 2, 0),
+			now:      at(time.January, 11, 2, 0),
+			expected: at(time.January, 11, 2, 0),
+		},
+		{
+			name:     "run at since is not missed",
+			schedule: "0 2 * * *",
+			since:    at(time.January, 11, 2, 0),
+			now:      at(time.January, 11, 2, 30),
+		},
+		{
+			name:     "last of several missed runs",
+			schedule: "0 2 * * *",
+			since:    at(time.January, 10, 2, 0),
+			now:      at(time.January, 20, 12, 0),
+			expected: at(time.January, 20, 2, 0),
+		},
+		{
+			name:     "every minute after a long outage",
+			schedule: "* * * * *",
+			since:    time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC),
+			now:      at(time.June, 1, 12, 30).Add(30 * time.Second),
+			expected: at(time.June, 1, 12, 30),
+		},
+		{
+			name:     "rare runs after a long outage",
+			schedule: "30 4 29 2 *",
+			since:    time.Date(2016, time.March, 1, 0, 0, 0, 0, time.UTC),
+			now:      at(time.December, 31, 0, 0),
+			expected: at(time.February, 29, 4, 30),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cron, err := util.ParseCronSchedule(tc.schedule)
+			if err != nil {
+				t.Fatalf("failed to parse schedule %q: %v", tc.schedule, err)
+			}
+			if last := lastMissedRun(cron, tc.since, tc.now); !last.Equal(tc.expected) {
+				t.Errorf("expected last missed run %v, got %v", tc.expected, last)
+			}
+		})
+	}
+}
+
+func TestBackupScheduleRetention(t *testing.T) {
+	now := time.Date(2024, time.January, 10, 0, 0, 0, 0, time.UTC)
+	newBackup := func(name string, createTime time.Time, state string) *file.Backup {
+		return &file.Backup{
+			Backup: &filev1beta1.Backup{
+				Name:       name,
+				CreateTime: createTime.Format(time.RFC3339),
+				State:      state,
+			},
+		}
+	}
+
+	var backups []*file.Backup
+	for i, age := range []time.Duration{1 * time.Hour, 25 * time.Hour, 49 * time.Hour, 73 * time.Hour} {
+		backups = append(backups, newBackup(fmt.Sprintf("b%d", i), now.Add(-age), "READY"))
+	}
+	backups = append(backups, newBackup("creating", now.Add(-100*time.Hour), "CREATING"))
+
+	cases := []struct {
+		name      string
+		retention *v1.BackupRetention
+		expected  []string
+	}{
+		{
+			name: "no retention",
+		},
+		{
+			name:      "keep last 2",
+			retention: &v1.BackupRetention{KeepLast: 2},
+			expected:  []string{"b2", "b3"},
+		},
+		{
+			name:      "keep within 2 days",
+			retention: &v1.BackupRetention{KeepWithin: &metav1.Duration{Duration: 48 * time.Hour}},
+			expected:  []string{"b2", "b3"},
+		},
+		{
+			name:      "keep last 3 or within a day",
+			retention: &v1.BackupRetention{KeepLast: 3, KeepWithin: &metav1.Duration{Duration: 24 * time.Hour}},
+			expected:  []string{"b3"},
+		},
+		{
+			name:      "keep last 1 or within 3 days",
+			retention: &v1.BackupRetention{KeepLast: 1, KeepWithin: &metav1.Duration{Duration: 72 * time.Hour}},
+			expected:  []string{"b3"},
+		},
+	}
+
+	for _, tc := range cases {
+		var names []string
+		for _, backup := range backupsOutOfRetention(tc.retention, backups, now) {
+			names = append(names, backup.Backup.Name)
+		}
+		if strings.Join(names, ",") != strings.Join(tc.expected, ",") {
+			t.Errorf("test %q failed: got %v, expected %v", tc.name, names, tc.expected)
+		}
+	}
+}
+
+func TestScheduledBackupName(t *testing.T) {
+	scheduledTime := time.Date(2024, time.January, 10, 2, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name         string
+		scheduleName string
+		prefix       string
+	}{
+		{
+			name:         "short name",
+			scheduleName: "nightly",
+			prefix:       "nightly-",
+		},
+		{
+			name:         "name with dots",
+			scheduleName: "db.nightly",
+			prefix:       "db-nightly-",
+		},
+		{
+			name:         "name starting with a digit",
+			scheduleName: "1-nightly",
+			prefix:       "b1-nightly-",
+		},
+		{
+			name:         "long name",
+			scheduleName: strings.Repeat("a", 63),
+			prefix:       strings.Repeat("a", maxScheduleNameInBackupName) + "-",
+		},
+	}
+
+	for _, tc := range cases {
+		schedule := &v1.BackupSchedule{ObjectMeta: metav1.ObjectMeta{Name: tc.scheduleName, Namespace: testScheduleNamespace}}
+		name := scheduledBackupName(schedule, scheduledTime)
+		if !strings.HasPrefix(name, tc.prefix) || !strings.HasSuffix(name, "-202401100200") {
+			t.Errorf("test %q failed: unexpected backup name %s", tc.name, name)
+		}
+		if len(name) > 63 {
+			t.Errorf("test %q failed: backup name %s is longer than 63 characters", tc.name, name)
+		}
+	}
+}
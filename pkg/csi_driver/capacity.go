@@ -0,0 +1,120 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// defaultCapacityCacheTTL is how long the available capacity of a tier in a region is reused. The
+// external-provisioner polls GetCapacity for every topology segment and StorageClass, and computing the
+// capacity lists the instances of the project and the quota infos of Filestore.
+const defaultCapacityCacheTTL = 5 * time.Minute
+
+// capacityCache caches the available capacity computed by GetCapacity. Failed computations are not
+// cached.
+type capacityCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mux     sync.Mutex
+	entries map[string]*capacityCacheEntry
+}
+
+type capacityCacheEntry struct {
+	availableBytes int64
+	expiry         time.Time
+}
+
+func newCapacityCache(ttl time.Duration) *capacityCache {
+	if ttl <= 0 {
+		ttl = defaultCapacityCacheTTL
+	}
+	return &capacityCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*capacityCacheEntry),
+	}
+}
+
+// get returns the unexpired available capacity of the key, or computes it. A nil cache computes the
+// capacity on every call.
+func (c *capacityCache) get(key string, compute func() (int64, error)) (int64, error) {
+	if c == nil {
+		return compute()
+	}
+	c.mux.Lock()
+	entry, ok := c.entries[key]
+	c.mux.Unlock()
+	if ok && c.now().Before(entry.expiry) {
+		return entry.availableBytes, nil
+	}
+
+	availableBytes, err := compute()
+	if err != nil {
+		return 0, err
+	}
+	c.mux.Lock()
+	c.entries[key] = &capacityCacheEntry{availableBytes: availableBytes, expiry: c.now().Add(c.ttl)}
+	c.mux.Unlock()
+	return availableBytes, nil
+}
+
+// multishareFreeBytes returns the capacity of the ready multishare instances of a StorageClass in the region
+// that is not used by their shares. New shares are placed there before the instances grow or new instances
+// are created, without using capacity quota. Instances that can't hold more shares are skipped.
+func multishareFreeBytes(ctx context.Context, fileService file.Service, project, region, scLabel string, featureMaxSharePerInstance bool) (int64, error) {
+	instances, err := fileService.ListMultishareInstances(ctx, &file.ListFilter{Project: project, Location: region})
+	if err != nil {
+		return 0, err
+	}
+	shares, err := fileService.ListShares(ctx, &file.ListFilter{Project: project, Location: region, InstanceName: "-"})
+	if err != nil {
+		return 0, err
+	}
+	usedBytes := map[string]int64{}
+	shareCount := map[string]int{}
+	for _, share := range shares {
+		if share.Parent == nil {
+			continue
+		}
+		usedBytes[share.Parent.Name] += share.CapacityBytes
+		shareCount[share.Parent.Name]++
+	}
+
+	var freeBytes int64
+	for _, instance := range instances {
+		if !strings.EqualFold(instance.Location, region) || instance.State != "READY" || instance.Labels[util.ParamMultishareInstanceScLabelKey] != scLabel {
+			continue
+		}
+		maxShareCount := util.MaxSharesPerInstance
+		if featureMaxSharePerInstance {
+			maxShareCount = instance.MaxShareCount
+		}
+		if shareCount[instance.Name] >= maxShareCount {
+			continue
+		}
+		freeBytes += util.Max(instance.CapacityBytes-usedBytes[instance.Name], 0)
+	}
+	return freeBytes, nil
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestCapacityCache(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := newCapacityCache(time.Minute)
+	c.now = func() time.Time { return now }
+
+	computed := 0
+	compute := func(bytes int64, err error) func() (int64, error) {
+		return func() (int64, error) {
+			computed++
+			return bytes, err
+		}
+	}
+
+	if _, err := c.get("a", compute(0, errors.New("quota unavailable"))); err == nil {
+		t.Errorf("expected the error of the computation")
+	}
+	// Errors are not cached.
+	if bytes, err := c.get("a", compute(100, nil)); err != nil || bytes != 100 {
+		t.Errorf("got %d bytes and error %v, expected 100 bytes", bytes, err)
+	}
+	if bytes, _ := c.get("a", compute(200, nil)); bytes != 100 {
+		t.Errorf("got %d bytes, expected the cached 100 bytes", bytes)
+	}
+	if bytes, _ := c.get("b", compute(300, nil)); bytes != 300 {
+		t.Errorf("got %d bytes for another key, expected 300 bytes", bytes)
+	}
+	if computed != 3 {
+		t.Errorf("capacity computed %d times, expected 3", computed)
+	}
+
+	now = now.Add(time.Minute)
+	if bytes, _ := c.get("a", compute(200, nil)); bytes != 200 {
+		t.Errorf("got %d bytes, expected the expired entry to be computed again", bytes)
+	}
+}
+
+func TestMultishareFreeBytes(t *testing.T) {
+	instance := func(name, location, scLabel, state string, capacityBytes int64, maxShareCount int) *file.MultishareInstance {
+		return &file.MultishareInstance{
+			Project:       testProject,
+			Location:      location,
+			Name:          name,
+			CapacityBytes: capacityBytes,
+			MaxShareCount: maxShareCount,
+			Labels:        map[string]string{util.ParamMultishareInstanceScLabelKey: scLabel},
+			State:         state,
+		}
+	}
+	instances := []*file.MultishareInstance{
+		instance("half-used", testRegion, "test-sc", "READY", util.Tb, 10),
+		instance("full-shares", testRegion, "test-sc", "READY", util.Tb, 1),
+		instance("empty", testRegion, "test-sc", "READY", 2*util.Tb, 10),
+		instance("creating", testRegion, "test-sc", "CREATING", util.Tb, 10),
+		instance("other-sc", testRegion, "other-sc", "READY", util.Tb, 10),
+		instance("other-region", "us-east1", "test-sc", "READY", util.Tb, 10),
+	}
+	shares := []*file.Share{
+		{Name: "share-1", Parent: instances[0], CapacityBytes: 300 * util.Gb},
+		{Name: "share-2", Parent: instances[0], CapacityBytes: 200 * util.Gb},
+		{Name: "share-3", Parent: instances[1], CapacityBytes: 100 * util.Gb},
+	}
+	fileService, err := file.NewFakeServiceForMultishare(instances, shares, nil)
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+
+	cases := []struct {
+		name                       string
+		featureMaxSharePerInstance bool
+		expectedBytes              int64
+	}{
+		{
+			name:          "default max shares per instance",
+			expectedBytes: util.Tb - 500*util.Gb + util.Tb - 100*util.Gb + 2*util.Tb,
+		},
+		{
+			name:                       "instances without room for shares",
+			featureMaxSharePerInstance: true,
+			expectedBytes:              util.Tb - 500*util.Gb + 2*util.Tb,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			freeBytes, err := multishareFreeBytes(context.TODO(), fileService, testProject, testRegion, "test-sc", tc.featureMaxSharePerInstance)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if freeBytes != tc.expectedBytes {
+				t.Errorf("got %d free bytes, expected %d", freeBytes, tc.expectedBytes)
+			}
+		})
+	}
+}
@@ -20,6 +20,8 @@ import (
 	"bytes"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
@@ -27,7 +29,9 @@ import (
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
 	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
@@ -66,7 +70,8 @@ const (
 	privateServiceAccess = "PRIVATE_SERVICE_ACCESS"
 
 	// Keys for Topology.
-	TopologyKeyZone = "topology.gke.io/zone"
+	TopologyKeyZone   = "topology.gke.io/zone"
+	TopologyKeyRegion = "topology.gke.io/region"
 )
 
 // Volume attributes
@@ -75,8 +80,28 @@ const (
 	attrVolume             = "volume"
 	attrSupportLockRelease = "supportLockRelease"
 	attrFileProtocol       = "fileProtocol"
+	// attrEncryptionInTransit makes the node mount the volume through a TLS tunnel.
+	attrEncryptionInTransit = "encryptionInTransit"
+	// attrSecurityFlavor is the Kerberos security flavor the node mounts the volume with, and attrServerName
+	// the hostname it mounts the volume by, as Kerberos authenticates the server by its hostname.
+	attrSecurityFlavor = "securityFlavor"
+	attrServerName     = "serverName"
+	// attrTier is the tier of the instance of the volume, which selects the default mount options of the
+	// mount policy of the node.
+	attrTier = "tier"
 )
 
+// Security flavors of NFSv4.1 volumes, as the sec mount option.
+const (
+	securityFlavorSys   = "sys"
+	securityFlavorKrb5  = "krb5"
+	securityFlavorKrb5i = "krb5i"
+	securityFlavorKrb5p = "krb5p"
+)
+
+// kerberosSecurityFlavors are the Kerberos security flavors from the weakest to the strongest.
+var kerberosSecurityFlavors = []string{securityFlavorKrb5, securityFlavorKrb5i, securityFlavorKrb5p}
+
 // CreateVolume parameters
 const (
 	paramTier                      = "tier"
@@ -91,6 +116,11 @@ const (
 	ParamNfsExportOptions          = "nfs-export-options-on-create"
 	paramMaxVolumeSize             = "max-volume-size"
 	paramFileProtocol              = "protocol"
+	paramEncryptionInTransit       = "encryption-in-transit"
+	paramSecurityFlavor            = "security-flavor"
+	paramManagedADDomain           = "managed-ad-domain"
+	paramManagedADComputer         = "managed-ad-computer"
+	paramPlacementPolicy           = "placement-policy"
 
 	// Keys for PV and PVC parameters as reported by external-provisioner
 	ParameterKeyPVCName      = "csi.storage.k8s.io/pvc/name"
@@ -131,6 +161,12 @@ var tierToCapacityRange map[string]capacityRangeForTier = map[string]capacityRan
 	basicHDDTier:   defaultRange, //these two are aliases
 }
 
+// snapshotTiers are the tiers of the instances that support Filestore snapshots.
+var snapshotTiers = map[string]bool{
+	enterpriseTier: true,
+	zonalTier:      true,
+}
+
 // capacityRangeForTier represents minimum and maximum capacity values for a tier
 type capacityRangeForTier struct {
 	min int64
@@ -143,27 +179,35 @@ type controllerServer struct {
 }
 
 type controllerServerConfig struct {
-	driver               *GCFSDriver
-	fileService          file.Service
-	cloud                *cloud.Cloud
-	ipAllocator          *util.IPAllocator
-	volumeLocks          *util.VolumeLocks
-	enableMultishare     bool
-	statefulController   *MultishareStatefulController
-	multiShareController *MultishareController
-	reconciler           *MultishareReconciler
-	metricsManager       *metrics.MetricsManager
-	ecfsDescription      string
-	isRegional           bool
-	clusterName          string
-	features             *GCFSDriverFeatureOptions
-	extraVolumeLabels    map[string]string
-	tagManager           cloud.TagService
+	driver                 *GCFSDriver
+	fileService            file.Service
+	cloud                  *cloud.Cloud
+	mounter                mount.Interface
+	ipAllocator            *util.IPAllocator
+	volumeLocks            *util.VolumeLocks
+	enableMultishare       bool
+	statefulController     *MultishareStatefulController
+	multiShareController   *MultishareController
+	subDirectoryController *SubDirectoryController
+	reconciler             *MultishareReconciler
+	metricsManager         *metrics.MetricsManager
+	ecfsDescription        string
+	isRegional             bool
+	clusterName            string
+	features               *GCFSDriverFeatureOptions
+	extraVolumeLabels      map[string]string
+	tagManager             cloud.TagService
+	preflight              *preflightValidator
+	capacityCache          *capacityCache
 }
 
 func newControllerServer(config *controllerServerConfig) csi.ControllerServer {
 	cs := &controllerServer{config: config}
 	config.ipAllocator = util.NewIPAllocator(make(map[string]bool))
+	config.capacityCache = newCapacityCache(defaultCapacityCacheTTL)
+	if config.features != nil && config.features.FeaturePreflightValidation != nil && config.features.FeaturePreflightValidation.Enabled {
+		config.preflight = newPreflightValidator(config.fileService, config.features.FeaturePreflightValidation.CacheTTL)
+	}
 	if config.enableMultishare {
 		config.multiShareController = NewMultishareController(config)
 		config.multiShareController.opsManager.controllerServer = cs
@@ -176,6 +220,10 @@ func newControllerServer(config *controllerServerConfig) csi.ControllerServer {
 
 		}
 	}
+	if config.features != nil && config.features.FeatureSubDirectoryProvisioning != nil && config.features.FeatureSubDirectoryProvisioning.Enabled {
+		config.subDirectoryController = NewSubDirectoryController(config)
+		config.subDirectoryController.controllerServer = cs
+	}
 	if config.reconciler != nil {
 		klog.Infof("stateful reconciler enabled, setting its controller server")
 		config.reconciler.controllerServer = cs
@@ -208,6 +256,22 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		duration := time.Since(start)
 		s.config.metricsManager.RecordOperationMetrics(err, methodCreateVolume, modeMultishare, duration)
 
+		if err != nil {
+			klog.Errorf("CreateVolume returned an error %v, for request %+v", err, req)
+			return nil, file.StatusError(err)
+		}
+		setEncryptionInTransit(response.Volume, req.GetParameters())
+		klog.Infof("CreateVolume response %v, for request %+v", response, req)
+		return response, nil
+	}
+
+	if strings.ToLower(req.GetParameters()[paramSubDirectory]) == "true" {
+		if s.config.subDirectoryController == nil {
+			return nil, status.Error(codes.InvalidArgument, "sub-directory provisioning not enabled")
+		}
+		start := time.Now()
+		response, err := s.config.subDirectoryController.CreateVolume(ctx, req)
+		s.config.metricsManager.RecordOperationMetrics(err, methodCreateVolume, modeSubDirectory, time.Since(start))
 		if err != nil {
 			klog.Errorf("CreateVolume returned an error %v, for request %+v", err, req)
 			return nil, file.StatusError(err)
@@ -245,23 +309,40 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 	}
 	defer s.config.volumeLocks.Release(volumeID)
 
+	// A volume is cloned by restoring a transient backup of the source volume.
+	var cloneBackupInfo *file.BackupInfo
 	if req.GetVolumeContentSource() != nil {
 		if req.GetVolumeContentSource().GetVolume() != nil {
-			return nil, status.Error(codes.InvalidArgument, "Unsupported volume content source")
+			sourceVolumeID := req.GetVolumeContentSource().GetVolume().GetVolumeId()
+			if isMultishareVolId(sourceVolumeID) {
+				return nil, status.Errorf(codes.InvalidArgument, "Unsupported volume content source %v, multishare volumes can only be cloned into multishare volumes", sourceVolumeID)
+			}
+			cloneBackupInfo, err = gatherBackupInfo(name, sourceVolumeID, s.config.cloud.Project)
+			if err != nil {
+				return nil, err
+			}
+			cloneBackupInfo.BackupURI, cloneBackupInfo.Location, err = file.CreateBackupURI(cloneBackupInfo.Location, cloneBackupInfo.Project, name, "")
+			if err != nil {
+				return nil, status.Error(codes.InvalidArgument, err.Error())
+			}
 		}
 
 		if req.GetVolumeContentSource().GetSnapshot() != nil {
 			id := req.GetVolumeContentSource().GetSnapshot().GetSnapshotId()
+			// Filestore can only revert an instance to its own snapshots, they can't be restored into a new instance.
+			if util.IsSnapshotHandle(id) {
+				return nil, status.Errorf(codes.InvalidArgument, "Unsupported volume content source %v, Filestore snapshots can't be restored into a new volume", id)
+			}
 			isBackupSource, err := util.IsBackupHandle(id)
 			if err != nil || !isBackupSource {
 				return nil, status.Errorf(codes.InvalidArgument, "Unsupported volume content source %v", id)
 			}
-			_, err = s.config.fileService.GetBackup(ctx, id)
+			backupSource, err := backupRestoreSource(ctx, s.config.fileService, id, newFiler.Location)
 			if err != nil {
 				klog.Errorf("Failed to get volume %v source snapshot %v: %v", name, id, err.Error())
-				return nil, file.StatusError(err)
+				return nil, err
 			}
-			newFiler.BackupSource = id
+			newFiler.BackupSource = backupSource
 		}
 	}
 
@@ -291,6 +372,12 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			klog.V(4).Info(msg)
 			return nil, status.Error(codes.Unavailable, msg)
 		}
+		// The controller may have restarted before deleting the transient backup of a clone.
+		if cloneBackupInfo != nil {
+			if err := deleteCloneBackup(ctx, s.config.fileService, cloneBackupInfo.BackupURI); err != nil {
+				return nil, err
+			}
+		}
 	} else {
 		param := req.GetParameters()
 		// If we are creating a new instance, we need to pick an unused CIDR range from reserved-ipv4-cidr
@@ -317,6 +404,10 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			newFiler.Network.ReservedIpRange = reservedIPRange
 		}
 
+		if err := s.config.preflight.validateInstance(ctx, newFiler); err != nil {
+			return nil, err
+		}
+
 		// Add labels.
 		labels, err := extractLabels(param, s.config.extraVolumeLabels, s.config.driver.config.Name)
 		if err != nil {
@@ -324,6 +415,19 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 		}
 		newFiler.Labels = labels
 
+		if cloneBackupInfo != nil {
+			// The source volume only needs to exist until the new instance is created.
+			if err := s.validateCloneSource(ctx, cloneBackupInfo.SourceVolumeId, capBytes); err != nil {
+				klog.Errorf("Failed to validate volume %v source volume %v: %v", name, cloneBackupInfo.SourceVolumeId, err.Error())
+				return nil, err
+			}
+			cloneBackupInfo.Labels = labels
+			if err := getOrCreateCloneBackup(ctx, s.config.fileService, cloneBackupInfo, modeInstance); err != nil {
+				return nil, err
+			}
+			newFiler.BackupSource = cloneBackupInfo.BackupURI
+		}
+
 		// Create the instance
 		var createErr error
 		filer, createErr = s.config.fileService.CreateInstance(ctx, newFiler)
@@ -331,31 +435,95 @@ func (s *controllerServer) CreateVolume(ctx context.Context, req *csi.CreateVolu
 			klog.Errorf("Create volume for volume Id %s failed: %v", volumeID, createErr.Error())
 			return nil, file.StatusError(createErr)
 		}
+
+		if cloneBackupInfo != nil {
+			if err := deleteCloneBackup(ctx, s.config.fileService, cloneBackupInfo.BackupURI); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if err := s.config.tagManager.AttachResourceTags(ctx, cloud.FilestoreInstance, filer.Name, filer.Location, req.GetName(), req.GetParameters()); err != nil {
 		return nil, status.Error(codes.Unavailable, err.Error())
 	}
 	resp := &csi.CreateVolumeResponse{Volume: s.fileInstanceToCSIVolume(filer, modeInstance)}
+	setEncryptionInTransit(resp.Volume, req.GetParameters())
+	if req.GetVolumeContentSource() != nil {
+		// The transient backup of a clone and the copy of a backup are not snapshots visible to the
+		// CO.
+		resp.Volume.ContentSource = req.GetVolumeContentSource()
+	}
 
 	klog.Infof("CreateVolume succeeded: %+v", resp)
 	return resp, nil
 }
 
+// validateCloneSource checks that the source volume of a clone is an existing instance volume, which fits in the
+// requested capacity.
+func (s *controllerServer) validateCloneSource(ctx context.Context, sourceVolumeID string, capBytes int64) error {
+	sourceFiler, _, err := getFileInstanceFromID(sourceVolumeID)
+	if err != nil {
+		return status.Errorf(codes.NotFound, "source volume %v doesn't exist: %v", sourceVolumeID, err)
+	}
+	sourceFiler.Project = s.config.cloud.Project
+	sourceFiler, err = s.config.fileService.GetInstance(ctx, sourceFiler)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return status.Errorf(codes.NotFound, "source volume %v doesn't exist", sourceVolumeID)
+		}
+		return file.StatusError(err)
+	}
+	if sourceFiler.Volume.SizeBytes > capBytes {
+		return status.Errorf(codes.OutOfRange, "requested capacity %v is smaller than source volume %v capacity %v", capBytes, sourceVolumeID, sourceFiler.Volume.SizeBytes)
+	}
+	return nil
+}
+
+// getOrCreateCloneBackup takes the transient backup used to clone the source volume of the backup info, unless it
+// already exists. The backup is named after the new volume, so that a retried CreateVolume finds it again.
+func getOrCreateCloneBackup(ctx context.Context, fileService file.Service, backupInfo *file.BackupInfo, mode string) error {
+	existingBackup, err := fileService.GetBackup(ctx, backupInfo.BackupURI)
+	backupExists, err := file.CheckBackupExists(existingBackup, err)
+	if err != nil {
+		return err
+	}
+	if backupExists {
+		_, err := file.ProcessExistingBackup(ctx, existingBackup, backupInfo.SourceVolumeId, mode)
+		return err
+	}
+
+	klog.V(4).Infof("Creating backup %v to clone volume %v", backupInfo.BackupURI, backupInfo.SourceVolumeId)
+	if _, err := fileService.CreateBackup(ctx, backupInfo); err != nil {
+		klog.Errorf("Create backup %v to clone volume %v failed: %v", backupInfo.BackupURI, backupInfo.SourceVolumeId, err.Error())
+		return file.StatusError(err)
+	}
+	return nil
+}
+
+// deleteCloneBackup deletes the transient backup taken to clone a volume, if it still exists.
+func deleteCloneBackup(ctx context.Context, fileService file.Service, backupURI string) error {
+	if _, err := fileService.GetBackup(ctx, backupURI); err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil
+		}
+		return file.StatusError(err)
+	}
+
+	klog.V(4).Infof("Deleting backup %v taken to clone a volume", backupURI)
+	if err := fileService.DeleteBackup(ctx, backupURI); err != nil {
+		klog.Errorf("Delete backup %v taken to clone a volume failed: %v", backupURI, err.Error())
+		return file.StatusError(err)
+	}
+	return nil
+}
+
 // reserveIPRange returns the available IP in the cidr
 func (s *controllerServer) reserveIPRange(ctx context.Context, filer *file.ServiceInstance, cidr string) (string, error) {
 	cloudInstancesReservedIPRanges, err := s.getCloudInstancesReservedIPRanges(ctx, filer)
 	if err != nil {
 		return "", err
 	}
-	ipRangeSize := util.IpRangeSize
-	if filer.Tier == enterpriseTier {
-		ipRangeSize = util.IpRangeSizeEnterprise
-	}
-	if filer.Tier == highScaleTier || filer.Tier == zonalTier {
-		ipRangeSize = util.IpRangeSizeHighScale
-	}
-	unreservedIPBlock, err := s.config.ipAllocator.GetUnreservedIPRange(cidr, ipRangeSize, cloudInstancesReservedIPRanges)
+	unreservedIPBlock, err := s.config.ipAllocator.GetUnreservedIPRange(cidr, ipRangeSizeForTier(filer.Tier), cloudInstancesReservedIPRanges)
 	if err != nil {
 		return "", err
 	}
@@ -423,6 +591,21 @@ func (s *controllerServer) DeleteVolume(ctx context.Context, req *csi.DeleteVolu
 		return response, nil
 	}
 
+	if isSubDirectoryVolId(volumeID) {
+		if s.config.subDirectoryController == nil {
+			return nil, status.Error(codes.InvalidArgument, "sub-directory provisioning not enabled")
+		}
+		start := time.Now()
+		response, err := s.config.subDirectoryController.DeleteVolume(ctx, req)
+		s.config.metricsManager.RecordOperationMetrics(err, methodDeleteVolume, modeSubDirectory, time.Since(start))
+		if err != nil {
+			klog.Errorf("Deletevolume returned error %v, for request: %+v", err, req)
+			return nil, file.StatusError(err)
+		}
+		klog.Infof("Deletevolume response %+v, for request: %+v", response, req)
+		return response, nil
+	}
+
 	filer, _, err := getFileInstanceFromID(volumeID)
 	if err != nil {
 		// An invalid ID should be treated as doesn't exist
@@ -469,19 +652,28 @@ func (s *controllerServer) ValidateVolumeCapabilities(ctx context.Context, req *
 	}
 
 	// Check that the volume exists
-	filer, _, err := getFileInstanceFromID(volumeID)
-	if err != nil {
-		// An invalid id format is treated as doesn't exist
-		return nil, status.Error(codes.NotFound, err.Error())
-	}
+	if isSubDirectoryVolId(volumeID) {
+		if s.config.subDirectoryController == nil {
+			return nil, status.Error(codes.InvalidArgument, "sub-directory provisioning not enabled")
+		}
+		if _, err := s.config.subDirectoryController.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: volumeID}); err != nil {
+			return nil, err
+		}
+	} else {
+		filer, _, err := getFileInstanceFromID(volumeID)
+		if err != nil {
+			// An invalid id format is treated as doesn't exist
+			return nil, status.Error(codes.NotFound, err.Error())
+		}
 
-	filer.Project = s.config.cloud.Project
-	newFiler, err := s.config.fileService.GetInstance(ctx, filer)
-	if err != nil && !file.IsNotFoundErr(err) {
-		return nil, file.StatusError(err)
-	}
-	if newFiler == nil {
-		return nil, status.Errorf(codes.NotFound, "volume %v doesn't exist", volumeID)
+		filer.Project = s.config.cloud.Project
+		newFiler, err := s.config.fileService.GetInstance(ctx, filer)
+		if err != nil && !file.IsNotFoundErr(err) {
+			return nil, file.StatusError(err)
+		}
+		if newFiler == nil {
+			return nil, status.Errorf(codes.NotFound, "volume %v doesn't exist", volumeID)
+		}
 	}
 
 	// Validate that the volume matches the capabilities
@@ -507,6 +699,230 @@ func (s *controllerServer) ControllerGetCapabilities(ctx context.Context, req *c
 	}, nil
 }
 
+// ListVolumes lists the Filestore instances created by the driver, and the shares of multishare
+// instances if enabled, in the project of the controller. The starting token is the index of the
+// first entry in the list of volumes sorted by volume id.
+func (s *controllerServer) ListVolumes(ctx context.Context, req *csi.ListVolumesRequest) (*csi.ListVolumesResponse, error) {
+	klog.V(4).Infof("ListVolumes called with request %+v", req)
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "ListVolumes max entries %d must not be negative", req.GetMaxEntries())
+	}
+
+	instances, err := s.config.fileService.ListInstances(ctx, &file.ServiceInstance{Project: s.config.cloud.Project})
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	var entries []*csi.ListVolumesResponse_Entry
+	for _, instance := range instances {
+		if !s.isInstanceVolume(instance) {
+			continue
+		}
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: s.fileInstanceToCSIVolume(instance, modeInstance),
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				VolumeCondition: volumeConditionFromState(instance.State),
+			},
+		})
+	}
+
+	if s.config.multiShareController != nil {
+		multishareEntries, err := s.config.multiShareController.ListVolumes(ctx)
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+		entries = append(entries, multishareEntries...)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].GetVolume().GetVolumeId() < entries[j].GetVolume().GetVolumeId()
+	})
+	start, end, nextToken, err := paginate(len(entries), req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	return &csi.ListVolumesResponse{
+		Entries:   entries[start:end],
+		NextToken: nextToken,
+	}, nil
+}
+
+// isInstanceVolume returns true if instance is the volume of a Filestore instance created by the driver.
+// Instances created by other tools, and the multishare and sub-directory instances the volumes of which are
+// listed separately, are not instance volumes.
+func (s *controllerServer) isInstanceVolume(instance *file.ServiceInstance) bool {
+	if instance.Labels[tagKeyCreatedBy] != strings.ReplaceAll(s.config.driver.config.Name, ".", "_") {
+		return false
+	}
+	return instance.Labels[util.ParamMultishareInstanceScLabelKey] == "" && instance.Labels[tagKeySubDirectoryPool] == ""
+}
+
+// ControllerGetVolume returns the current state of a Filestore instance or multishare share.
+func (s *controllerServer) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	klog.V(4).Infof("ControllerGetVolume called with request %+v", req)
+	volumeID := req.GetVolumeId()
+	if volumeID == "" {
+		return nil, status.Error(codes.InvalidArgument, "volume id is empty")
+	}
+
+	if isMultishareVolId(volumeID) {
+		if s.config.multiShareController == nil {
+			return nil, status.Error(codes.InvalidArgument, "multishare controller not enabled")
+		}
+		return s.config.multiShareController.ControllerGetVolume(ctx, req)
+	}
+
+	if isSubDirectoryVolId(volumeID) {
+		if s.config.subDirectoryController == nil {
+			return nil, status.Error(codes.InvalidArgument, "sub-directory provisioning not enabled")
+		}
+		return s.config.subDirectoryController.ControllerGetVolume(ctx, req)
+	}
+
+	filer, _, err := getFileInstanceFromID(volumeID)
+	if err != nil {
+		// An invalid id format is treated as doesn't exist
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	filer.Project = s.config.cloud.Project
+	filer, err = s.config.fileService.GetInstance(ctx, filer)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %v doesn't exist", volumeID)
+		}
+		return nil, file.StatusError(err)
+	}
+
+	return &csi.ControllerGetVolumeResponse{
+		Volume: s.fileInstanceToCSIVolume(filer, modeInstance),
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: volumeConditionFromState(filer.State),
+		},
+	}, nil
+}
+
+// volumeConditionFromState maps the state of a Filestore instance or share to a CSI volume condition.
+// Transitional states of a healthy resource, such as CREATING, are not reported as abnormal.
+func volumeConditionFromState(state string) *csi.VolumeCondition {
+	switch state {
+	case "READY":
+		return &csi.VolumeCondition{Message: "volume is ready"}
+	case "CREATING", "RESTORING", "REVERTING", "RESUMING", "PROMOTING":
+		return &csi.VolumeCondition{Message: fmt.Sprintf("volume is in state %s", state)}
+	default:
+		return &csi.VolumeCondition{Abnormal: true, Message: fmt.Sprintf("volume is in state %s", state)}
+	}
+}
+
+// paginate returns the bounds of the page of a list of total entries described by a starting token and
+// max entries, and the token of the following page. An empty next token means there are no more entries.
+func paginate(total int, startingToken string, maxEntries int32) (int, int, string, error) {
+	start := 0
+	if startingToken != "" {
+		var err error
+		start, err = strconv.Atoi(startingToken)
+		if err != nil || start < 0 || start > total {
+			return 0, 0, "", fmt.Errorf("invalid starting token %q", startingToken)
+		}
+	}
+
+	end := total
+	if maxEntries > 0 && start+int(maxEntries) < total {
+		end = start + int(maxEntries)
+	}
+	nextToken := ""
+	if end < total {
+		nextToken = strconv.Itoa(end)
+	}
+	return start, end, nextToken, nil
+}
+
+// GetCapacity returns the capacity that can still be provisioned for the tier of the request in the
+// region of its topology segment. Filestore capacity quotas are enforced per tier and region, so
+// all the zones of a region share the same available capacity. The capacity is cached for
+// defaultCapacityCacheTTL.
+func (s *controllerServer) GetCapacity(ctx context.Context, req *csi.GetCapacityRequest) (*csi.GetCapacityResponse, error) {
+	params := req.GetParameters()
+	tier := strings.ToLower(getTierFromParams(params))
+	validRange, ok := tierToCapacityRange[tier]
+	if !ok {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid tier %q", tier)
+	}
+	if tier == zonalTier {
+		validRange.max = zonalLargeRange.max
+	}
+	multishare := strings.ToLower(params[paramMultishare]) == "true"
+	if multishare {
+		// Multishare volumes are shares of enterprise instances.
+		tier = enterpriseTier
+		validRange = capacityRangeForTier{min: util.MinShareSizeBytes, max: util.MaxShareSizeBytes}
+	}
+
+	region, err := s.getCapacityRegion(req)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	scLabel := ""
+	if multishare {
+		scLabel = params[ParamMultishareInstanceScLabel]
+	}
+	key := strings.Join([]string{region, tier, strconv.FormatBool(multishare), scLabel}, "/")
+	available, err := s.config.capacityCache.get(key, func() (int64, error) {
+		return s.availableCapacity(ctx, region, tier, multishare, scLabel, validRange.max)
+	})
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+
+	return &csi.GetCapacityResponse{
+		AvailableCapacity: available,
+		MinimumVolumeSize: wrapperspb.Int64(validRange.min),
+		MaximumVolumeSize: wrapperspb.Int64(validRange.max),
+	}, nil
+}
+
+// availableCapacity returns the capacity that can still be provisioned for the tier in the region, or
+// maxBytes if the capacity quota of the tier is unlimited. The shares of a multishare StorageClass can
+// also use the free capacity of its existing instances.
+func (s *controllerServer) availableCapacity(ctx context.Context, region, tier string, multishare bool, scLabel string, maxBytes int64) (int64, error) {
+	quota, err := s.config.fileService.GetCapacityQuota(ctx, s.config.cloud.Project, region, tier)
+	if err != nil {
+		return 0, err
+	}
+	available := quota.AvailableBytes()
+	if available == file.UnlimitedQuota {
+		available = maxBytes
+	}
+	var freeBytes int64
+	if multishare && scLabel != "" && s.config.multiShareController != nil {
+		freeBytes, err = multishareFreeBytes(ctx, s.config.fileService, s.config.cloud.Project, region, scLabel, s.config.multiShareController.featureMaxSharePerInstance)
+		if err != nil {
+			return 0, err
+		}
+	}
+	klog.V(4).Infof("GetCapacity for tier %s in region %s: %d bytes available (limit %d, usage %d, free in multishare instances %d)", tier, region, available+freeBytes, quota.LimitBytes, quota.UsageBytes, freeBytes)
+	return available + freeBytes, nil
+}
+
+// getCapacityRegion returns the region of the topology segment of the request, falling back to the
+// location parameter and then to the zone of the driver.
+func (s *controllerServer) getCapacityRegion(req *csi.GetCapacityRequest) (string, error) {
+	if segments := req.GetAccessibleTopology().GetSegments(); len(segments) != 0 {
+		return getRegionFromSegment(segments)
+	}
+	location := req.GetParameters()[paramLocation]
+	if location == "" {
+		location = s.config.cloud.Zone
+	}
+	if region, err := util.GetRegionFromZone(location); err == nil {
+		return region, nil
+	}
+	// The location is already a region.
+	return location, nil
+}
+
 // getTierFromParams returns the provided tier or default
 func getTierFromParams(params map[string]string) string {
 	if val, ok := params[paramTier]; ok {
@@ -632,6 +1048,9 @@ func (s *controllerServer) generateNewFileInstance(name string, capBytes int64,
 	connectMode := directPeering
 	kmsKeyName := ""
 	fileProtocol := ""
+	encryptionInTransit := false
+	securityFlavor := securityFlavorSys
+	var managedAD *file.ManagedActiveDirectory
 
 	// Validate parameters (case-insensitive).
 	for k, v := range params {
@@ -674,6 +1093,26 @@ func (s *controllerServer) generateNewFileInstance(name string, capBytes int64,
 			if s.config.features.FeatureNFSv4Support.Enabled {
 				fileProtocol = v
 			}
+		case paramEncryptionInTransit:
+			encryptionInTransit, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: %w", paramEncryptionInTransit, v, err)
+			}
+		case paramSecurityFlavor:
+			securityFlavor = strings.ToLower(v)
+			if securityFlavor != securityFlavorSys && !isKerberosSecurityFlavor(securityFlavor) {
+				return nil, fmt.Errorf("%s must be one of %q, %q, %q or %q, got %q", paramSecurityFlavor, securityFlavorSys, securityFlavorKrb5, securityFlavorKrb5i, securityFlavorKrb5p, v)
+			}
+		case paramManagedADDomain:
+			if managedAD == nil {
+				managedAD = &file.ManagedActiveDirectory{}
+			}
+			managedAD.Domain = v
+		case paramManagedADComputer:
+			if managedAD == nil {
+				managedAD = &file.ManagedActiveDirectory{}
+			}
+			managedAD.Computer = v
 		case ParameterKeyLabels, ParameterKeyPVCName, ParameterKeyPVCNamespace, ParameterKeyPVName:
 		case "csiprovisionersecretname", "csiprovisionersecretnamespace":
 		default:
@@ -690,6 +1129,26 @@ func (s *controllerServer) generateNewFileInstance(name string, capBytes int64,
 	default:
 		fileProtocol = v3FileProtocol
 	}
+	if encryptionInTransit && fileProtocol != v4_1FileProtocol {
+		return nil, fmt.Errorf("%s requires the %s protocol", paramEncryptionInTransit, v4_1FileProtocol)
+	}
+	if managedAD != nil && (managedAD.Domain == "" || managedAD.Computer == "") {
+		return nil, fmt.Errorf("both %s and %s must be set", paramManagedADDomain, paramManagedADComputer)
+	}
+	if isKerberosSecurityFlavor(securityFlavor) {
+		if fileProtocol != v4_1FileProtocol {
+			return nil, fmt.Errorf("%s %q requires the %s protocol", paramSecurityFlavor, securityFlavor, v4_1FileProtocol)
+		}
+		// The tunnel would hide the hostname of the server, which Kerberos authenticates. krb5p encrypts
+		// the traffic instead.
+		if encryptionInTransit {
+			return nil, fmt.Errorf("%s can't be used with %s %q", paramEncryptionInTransit, paramSecurityFlavor, securityFlavor)
+		}
+		if managedAD == nil {
+			return nil, fmt.Errorf("%s %q requires the %s and %s of the instance", paramSecurityFlavor, securityFlavor, paramManagedADDomain, paramManagedADComputer)
+		}
+		nfsExportOptions = withSecurityFlavor(nfsExportOptions, securityFlavor)
+	}
 
 	return &file.ServiceInstance{
 		Project:  s.config.cloud.Project,
@@ -704,12 +1163,88 @@ func (s *controllerServer) generateNewFileInstance(name string, capBytes int64,
 			Name:      newInstanceVolume,
 			SizeBytes: capBytes,
 		},
-		KmsKeyName:       kmsKeyName,
-		NfsExportOptions: nfsExportOptions,
-		Protocol:         fileProtocol,
+		KmsKeyName:             kmsKeyName,
+		NfsExportOptions:       nfsExportOptions,
+		Protocol:               fileProtocol,
+		ManagedActiveDirectory: managedAD,
 	}, nil
 }
 
+func isKerberosSecurityFlavor(flavor string) bool {
+	for _, f := range kerberosSecurityFlavors {
+		if flavor == f {
+			return true
+		}
+	}
+	return false
+}
+
+// withSecurityFlavor sets the security flavor of the export options that don't set theirs, or exports the
+// share to all clients with the security flavor and root squashed if there are no export options.
+func withSecurityFlavor(options []*file.NfsExportOptions, flavor string) []*file.NfsExportOptions {
+	if len(options) == 0 {
+		return []*file.NfsExportOptions{{
+			AccessMode:      "READ_WRITE",
+			SquashMode:      "ROOT_SQUASH",
+			SecurityFlavors: []string{strings.ToUpper(flavor)},
+		}}
+	}
+	for _, opt := range options {
+		if len(opt.SecurityFlavors) == 0 {
+			opt.SecurityFlavors = []string{strings.ToUpper(flavor)}
+		}
+	}
+	return options
+}
+
+// instanceSecurityFlavor returns the strongest Kerberos security flavor the share of an instance is
+// exported with, or "" if it is only exported with AUTH_SYS.
+func instanceSecurityFlavor(instance *file.ServiceInstance) string {
+	return exportSecurityFlavor(instance.NfsExportOptions)
+}
+
+// exportSecurityFlavor returns the strongest Kerberos security flavor of the export options, or "" if
+// they only export with AUTH_SYS.
+func exportSecurityFlavor(options []*file.NfsExportOptions) string {
+	strongest := -1
+	for _, opt := range options {
+		for _, flavor := range opt.SecurityFlavors {
+			for i, f := range kerberosSecurityFlavors {
+				if strings.EqualFold(flavor, f) && i > strongest {
+					strongest = i
+				}
+			}
+		}
+	}
+	if strongest < 0 {
+		return ""
+	}
+	return kerberosSecurityFlavors[strongest]
+}
+
+// instanceServerName returns the hostname of an instance in its Managed Microsoft AD domain, which is
+// {computer}.filestore.{domain}.
+func instanceServerName(ad *file.ManagedActiveDirectory) string {
+	if ad == nil {
+		return ""
+	}
+	domain := ad.Domain[strings.LastIndex(ad.Domain, "/")+1:]
+	return fmt.Sprintf("%s.filestore.%s", ad.Computer, domain)
+}
+
+// setEncryptionInTransit sets the volume attribute of a volume encrypted in transit by its StorageClass.
+// The parameter was validated along with the instance of the volume.
+func setEncryptionInTransit(volume *csi.Volume, params map[string]string) {
+	for k, v := range params {
+		if strings.ToLower(k) != paramEncryptionInTransit {
+			continue
+		}
+		if encrypted, err := strconv.ParseBool(v); err == nil && encrypted {
+			volume.VolumeContext[attrEncryptionInTransit] = "true"
+		}
+	}
+}
+
 // fileInstanceToCSIVolume generates a CSI volume spec from the cloud Instance
 func (s *controllerServer) fileInstanceToCSIVolume(instance *file.ServiceInstance, mode string) *csi.Volume {
 	resp := &csi.Volume{
@@ -742,6 +1277,18 @@ func (s *controllerServer) fileInstanceToCSIVolume(instance *file.ServiceInstanc
 	default:
 		resp.VolumeContext[attrFileProtocol] = v3FileProtocol
 	}
+	if flavor := instanceSecurityFlavor(instance); flavor != "" {
+		resp.VolumeContext[attrSecurityFlavor] = flavor
+		if serverName := instanceServerName(instance.ManagedActiveDirectory); serverName != "" {
+			resp.VolumeContext[attrServerName] = serverName
+		}
+	}
+	if s.config.features.FeatureVolumeTopology != nil && s.config.features.FeatureVolumeTopology.Enabled {
+		resp.AccessibleTopology = instanceAccessibleTopology(instance.Location, instance.Network.ConnectMode)
+	}
+	if s.config.features.FeatureMountPolicy != nil && s.config.features.FeatureMountPolicy.Enabled {
+		resp.VolumeContext[attrTier] = strings.ToLower(instance.Tier)
+	}
 
 	return resp
 }
@@ -776,6 +1323,21 @@ func (s *controllerServer) ControllerExpandVolume(ctx context.Context, req *csi.
 		return response, nil
 	}
 
+	if isSubDirectoryVolId(volumeID) {
+		if s.config.subDirectoryController == nil {
+			return nil, status.Error(codes.InvalidArgument, "sub-directory provisioning not enabled")
+		}
+		start := time.Now()
+		response, err := s.config.subDirectoryController.ControllerExpandVolume(ctx, req)
+		s.config.metricsManager.RecordOperationMetrics(err, methodExpandVolume, modeSubDirectory, time.Since(start))
+		if err != nil {
+			klog.Errorf("ControllerExpandVolume returned error %v, for request: %+v", err, req)
+			return nil, err
+		}
+		klog.Infof("ControllerExpandVolume response %+v, for request: %+v", response, req)
+		return response, nil
+	}
+
 	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
 		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
 	}
@@ -899,11 +1461,13 @@ func getZonesFromTopology(topList []*csi.Topology) ([]string, error) {
 }
 
 func getZoneFromSegment(seg map[string]string) (string, error) {
-	var zone string
+	var zone, region string
 	for k, v := range seg {
 		switch k {
 		case TopologyKeyZone:
 			zone = v
+		case TopologyKeyRegion:
+			region = v
 		default:
 			return "", fmt.Errorf("topology segment has unknown key %v", k)
 		}
@@ -912,6 +1476,11 @@ func getZoneFromSegment(seg map[string]string) (string, error) {
 	if len(zone) == 0 {
 		return "", fmt.Errorf("topology specified but could not find zone in segment: %v", seg)
 	}
+	if region != "" {
+		if zoneRegion, err := util.GetRegionFromZone(zone); err != nil || zoneRegion != region {
+			return "", fmt.Errorf("topology segment zone %v is not in region %v", zone, region)
+		}
+	}
 	return zone, nil
 }
 
@@ -1005,6 +1574,16 @@ func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 	}
+	copyLocations := util.GetBackupCopyLocations(req.GetParameters())
+	if util.GetSnapshotType(req.GetParameters()) == util.VolumeSnapshotTypeSnapshot {
+		if len(copyLocations) > 0 {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %q is only supported for volume snapshots of type backup", util.VolumeSnapshotCopyLocationsKey)
+		}
+		return s.createInstanceSnapshot(ctx, req, volumeID)
+	}
+	if err := validateBackupCopyLocations(copyLocations); err != nil {
+		return nil, err
+	}
 
 	// Check for existing snapshot
 	backupLocation := util.GetBackupLocation(req.GetParameters())
@@ -1038,7 +1617,7 @@ func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 		if err != nil {
 			return nil, err
 		}
-		backupInfo.Labels = labels
+		backupInfo.Labels = withBackupCopiesLabel(labels, copyLocations)
 
 		backupObj, err := s.config.fileService.CreateBackup(ctx, backupInfo)
 		if err != nil {
@@ -1061,6 +1640,26 @@ func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 		klog.V(4).Infof("CreateSnapshot succeeded for volume %v, Backup Id: %v", volumeID, backupObj.Name)
 	}
 
+	if len(copyLocations) > 0 {
+		// The labels of an existing backup are only processed to take its missing copies.
+		if backupInfo.Labels == nil {
+			labels, err := extractBackupLabels(req.GetParameters(), s.config.extraVolumeLabels, s.config.driver.config.Name, req.Name)
+			if err != nil {
+				return nil, err
+			}
+			backupInfo.Labels = labels
+		}
+		copyRegions, err := createBackupCopies(ctx, s.config.fileService, backupInfo, copyLocations)
+		if err != nil {
+			return nil, err
+		}
+		for _, region := range copyRegions {
+			if err := s.config.tagManager.AttachResourceTags(ctx, cloud.FilestoreBackUp, backupInfo.Name, region, req.GetName(), req.GetParameters()); err != nil {
+				return nil, status.Error(codes.Unavailable, err.Error())
+			}
+		}
+	}
+
 	if err := s.config.tagManager.AttachResourceTags(ctx, cloud.FilestoreBackUp, backupInfo.Name, backupInfo.Location, req.GetName(), req.GetParameters()); err != nil {
 		return nil, status.Error(codes.Unavailable, err.Error())
 	}
@@ -1068,6 +1667,48 @@ func (s *controllerServer) CreateSnapshot(ctx context.Context, req *csi.CreateSn
 	return snapshotResponse, nil
 }
 
+// createInstanceSnapshot takes a Filestore snapshot of the instance of the volume. Unlike a backup, the
+// snapshot is stored in the instance and is deleted along with it.
+func (s *controllerServer) createInstanceSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest, volumeID string) (*csi.CreateSnapshotResponse, error) {
+	filer, _, err := getFileInstanceFromID(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	filer.Project = s.config.cloud.Project
+	instance, err := s.config.fileService.GetInstance(ctx, filer)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil, status.Errorf(codes.NotFound, "source volume %v doesn't exist", volumeID)
+		}
+		return nil, file.StatusError(err)
+	}
+
+	snapshotURI := file.SnapshotURI(filer.Project, filer.Location, filer.Name, req.GetName())
+	existingSnapshot, err := s.config.fileService.GetSnapshot(ctx, snapshotURI)
+	if err != nil && !file.IsNotFoundErr(err) {
+		return nil, file.StatusError(err)
+	}
+	if existingSnapshot == nil {
+		labels, err := extractBackupLabels(req.GetParameters(), s.config.extraVolumeLabels, s.config.driver.config.Name, req.GetName())
+		if err != nil {
+			return nil, err
+		}
+		snapshotObj, err := s.config.fileService.CreateSnapshot(ctx, filer, req.GetName(), labels)
+		if err != nil {
+			klog.Errorf("Create snapshot for volume Id %s failed: %v", volumeID, err.Error())
+			return nil, file.StatusError(err)
+		}
+		existingSnapshot = &file.Snapshot{Snapshot: snapshotObj}
+	}
+
+	snapshot, err := file.ProcessExistingSnapshot(existingSnapshot, volumeID, instance.Volume.SizeBytes)
+	if err != nil {
+		return nil, err
+	}
+	klog.V(4).Infof("CreateSnapshot succeeded for volume %v, Snapshot Id: %v", volumeID, snapshot.SnapshotId)
+	return &csi.CreateSnapshotResponse{Snapshot: snapshot}, nil
+}
+
 func extractBackupLabels(parameters, cliLabels map[string]string, driverName string, snapshotName string) (map[string]string, error) {
 	labels, err := extractLabels(parameters, cliLabels, driverName)
 	if err != nil {
@@ -1083,6 +1724,10 @@ func (s *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSn
 		return nil, status.Error(codes.InvalidArgument, "DeleteSnapshot snapshot Id must be provided")
 	}
 
+	if util.IsSnapshotHandle(id) {
+		return s.deleteInstanceSnapshot(ctx, id)
+	}
+
 	isBackup, err := util.IsBackupHandle(id)
 	if err != nil {
 		// Sanity tests expects delete to pass for invalid handles.
@@ -1092,7 +1737,7 @@ func (s *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSn
 
 	if !isBackup {
 		klog.Errorf("Deletion of volume snapshot type %q not supported", id)
-		return nil, status.Error(codes.InvalidArgument, "deletion is only supported for volume snapshots of type backup")
+		return nil, status.Error(codes.InvalidArgument, "deletion is only supported for volume snapshots of type backup or snapshot")
 	}
 
 	backup, err := s.config.fileService.GetBackup(ctx, id)
@@ -1108,6 +1753,11 @@ func (s *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSn
 		return nil, status.Errorf(codes.DeadlineExceeded, "Volume snapshot with ID %v is in state %s", id, backup.Backup.State)
 	}
 
+	// The copies are deleted first, they can't be found once the backup is deleted.
+	if err := deleteBackupCopies(ctx, s.config.fileService, backup); err != nil {
+		return nil, err
+	}
+
 	if err = s.config.fileService.DeleteBackup(ctx, id); err != nil {
 		klog.Errorf("Delete snapshot for backup Id %s failed: %v", id, err.Error())
 		return nil, file.StatusError(err)
@@ -1116,6 +1766,271 @@ func (s *controllerServer) DeleteSnapshot(ctx context.Context, req *csi.DeleteSn
 	return &csi.DeleteSnapshotResponse{}, nil
 }
 
+// deleteInstanceSnapshot deletes a Filestore snapshot of an instance.
+func (s *controllerServer) deleteInstanceSnapshot(ctx context.Context, id string) (*csi.DeleteSnapshotResponse, error) {
+	snapshot, err := s.config.fileService.GetSnapshot(ctx, id)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			klog.Infof("Volume snapshot with ID %v not found", id)
+			return &csi.DeleteSnapshotResponse{}, nil
+		}
+		return nil, file.StatusError(err)
+	}
+
+	if snapshot.Snapshot.State == "DELETING" {
+		return nil, status.Errorf(codes.DeadlineExceeded, "Volume snapshot with ID %v is in state %s", id, snapshot.Snapshot.State)
+	}
+
+	if err = s.config.fileService.DeleteSnapshot(ctx, id); err != nil {
+		klog.Errorf("Delete snapshot for snapshot Id %s failed: %v", id, err.Error())
+		return nil, file.StatusError(err)
+	}
+
+	return &csi.DeleteSnapshotResponse{}, nil
+}
+
+// ListSnapshots lists the Filestore backups of the project of the controller, optionally filtered by
+// snapshot id and source volume id. The starting token is the index of the first entry in the list of
+// snapshots sorted by snapshot id.
+func (s *controllerServer) ListSnapshots(ctx context.Context, req *csi.ListSnapshotsRequest) (*csi.ListSnapshotsResponse, error) {
+	klog.V(4).Infof("ListSnapshots called with request %+v", req)
+	if req.GetMaxEntries() < 0 {
+		return nil, status.Errorf(codes.InvalidArgument, "ListSnapshots max entries %d must not be negative", req.GetMaxEntries())
+	}
+
+	backups, err := s.listSnapshotBackups(ctx, req.GetSnapshotId())
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	var entries []*csi.ListSnapshotsResponse_Entry
+	for _, backup := range backups {
+		if req.GetSnapshotId() == "" && !s.isSnapshotBackup(backup) {
+			continue
+		}
+		if req.GetSourceVolumeId() != "" && !isBackupOfVolume(backup, req.GetSourceVolumeId()) {
+			continue
+		}
+		snapshot, err := backupToCSISnapshot(ctx, backup)
+		if err != nil {
+			klog.Warningf("Skipping backup %v in ListSnapshots: %v", backup.Backup.Name, err)
+			continue
+		}
+		if req.GetSourceVolumeId() != "" {
+			snapshot.SourceVolumeId = req.GetSourceVolumeId()
+		}
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+
+	instanceSnapshots, err := s.listInstanceSnapshots(ctx, req.GetSnapshotId(), req.GetSourceVolumeId())
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	for _, snapshot := range instanceSnapshots {
+		entries = append(entries, &csi.ListSnapshotsResponse_Entry{Snapshot: snapshot})
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].GetSnapshot().GetSnapshotId() < entries[j].GetSnapshot().GetSnapshotId()
+	})
+	start, end, nextToken, err := paginate(len(entries), req.GetStartingToken(), req.GetMaxEntries())
+	if err != nil {
+		return nil, status.Error(codes.Aborted, err.Error())
+	}
+
+	return &csi.ListSnapshotsResponse{
+		Entries:   entries[start:end],
+		NextToken: nextToken,
+	}, nil
+}
+
+// isSnapshotBackup returns true if backup was taken by the driver for a CSI snapshot. The copies of the
+// backups in other regions, the transient backups of clones, the backups of BackupSchedules and the backups
+// taken by other tools are not listed as snapshots, unless they are looked up by id.
+func (s *controllerServer) isSnapshotBackup(backup *file.Backup) bool {
+	labels := backup.Backup.Labels
+	if labels[tagKeyCreatedBy] != strings.ReplaceAll(s.config.driver.config.Name, ".", "_") {
+		return false
+	}
+	return labels[tagKeySnapshotName] != "" && labels[tagKeyBackupCopyOf] == ""
+}
+
+// listSnapshotBackups returns the backup of the snapshot id, or all the backups of the project if the
+// snapshot id is empty. An invalid snapshot id or a snapshot that is not a backup is treated as doesn't
+// exist.
+func (s *controllerServer) listSnapshotBackups(ctx context.Context, snapshotID string) ([]*file.Backup, error) {
+	if snapshotID == "" {
+		return s.config.fileService.ListBackups(ctx, &file.ListFilter{Project: s.config.cloud.Project})
+	}
+	if isBackup, err := util.IsBackupHandle(snapshotID); err != nil || !isBackup {
+		return nil, nil
+	}
+	backup, err := s.config.fileService.GetBackup(ctx, snapshotID)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return []*file.Backup{backup}, nil
+}
+
+// listInstanceSnapshots returns the Filestore snapshots of instance volumes, as CSI snapshots. The snapshots
+// are filtered by snapshot id and by source volume id if they are not empty. Without filter, the snapshots
+// of the instance volumes of the tiers that support snapshots are listed.
+func (s *controllerServer) listInstanceSnapshots(ctx context.Context, snapshotID, sourceVolumeID string) ([]*csi.Snapshot, error) {
+	var instances []*file.ServiceInstance
+	switch {
+	case snapshotID != "":
+		project, location, instanceName, _, err := util.ParseSnapshotURI(snapshotID)
+		if err != nil {
+			return nil, nil
+		}
+		instances = append(instances, &file.ServiceInstance{Project: project, Location: location, Name: instanceName})
+	case sourceVolumeID != "":
+		if isMultishareVolId(sourceVolumeID) || isSubDirectoryVolId(sourceVolumeID) {
+			return nil, nil
+		}
+		filer, _, err := getFileInstanceFromID(sourceVolumeID)
+		if err != nil {
+			return nil, nil
+		}
+		filer.Project = s.config.cloud.Project
+		instances = append(instances, filer)
+	default:
+		allInstances, err := s.config.fileService.ListInstances(ctx, &file.ServiceInstance{Project: s.config.cloud.Project})
+		if err != nil {
+			return nil, err
+		}
+		for _, instance := range allInstances {
+			if s.isInstanceVolume(instance) && snapshotTiers[strings.ToLower(instance.Tier)] {
+				instances = append(instances, instance)
+			}
+		}
+	}
+
+	var snapshots []*csi.Snapshot
+	for _, instance := range instances {
+		if snapshotID != "" || sourceVolumeID != "" {
+			var err error
+			instance, err = s.config.fileService.GetInstance(ctx, instance)
+			if err != nil {
+				if file.IsNotFoundErr(err) {
+					continue
+				}
+				return nil, err
+			}
+		}
+		volumeID := getVolumeIDFromFileInstance(instance, modeInstance)
+		if sourceVolumeID != "" && volumeID != sourceVolumeID {
+			continue
+		}
+
+		var instanceSnapshots []*file.Snapshot
+		if snapshotID != "" {
+			snapshot, err := s.config.fileService.GetSnapshot(ctx, snapshotID)
+			if err != nil {
+				if file.IsNotFoundErr(err) {
+					continue
+				}
+				return nil, err
+			}
+			instanceSnapshots = append(instanceSnapshots, snapshot)
+		} else {
+			var err error
+			instanceSnapshots, err = s.config.fileService.ListSnapshots(ctx, instance)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, instanceSnapshot := range instanceSnapshots {
+			snapshot, err := instanceSnapshotToCSISnapshot(instanceSnapshot, volumeID, instance.Volume.SizeBytes)
+			if err != nil {
+				klog.Warningf("Skipping snapshot %v in ListSnapshots: %v", instanceSnapshot.Snapshot.Name, err)
+				continue
+			}
+			snapshots = append(snapshots, snapshot)
+		}
+	}
+	return snapshots, nil
+}
+
+// instanceSnapshotToCSISnapshot generates a CSI snapshot from a Filestore snapshot of an instance volume.
+// Snapshots that are still being created are returned as not ready to use, snapshots in any other state
+// except READY return an error.
+func instanceSnapshotToCSISnapshot(snapshot *file.Snapshot, volumeID string, sizeBytes int64) (*csi.Snapshot, error) {
+	csiSnapshot, err := file.ProcessExistingSnapshot(snapshot, volumeID, sizeBytes)
+	if status.Code(err) == codes.DeadlineExceeded {
+		tp, err := util.ParseTimestamp(snapshot.Snapshot.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+		return &csi.Snapshot{
+			SizeBytes:      sizeBytes,
+			SnapshotId:     snapshot.Snapshot.Name,
+			SourceVolumeId: volumeID,
+			CreationTime:   tp,
+			ReadyToUse:     false,
+		}, nil
+	}
+	return csiSnapshot, err
+}
+
+// isBackupOfVolume returns true if the source of the backup is the volume with the given id. Both the
+// instance and the multishare volume id formats are supported. The project is not compared, since the
+// backup source may reference the project by number.
+func isBackupOfVolume(backup *file.Backup, volumeID string) bool {
+	if isMultishareVolId(volumeID) {
+		var location, instanceName, shareName string
+		var err error
+		if len(strings.Split(volumeID, "/")) == util.MultishareCSIVolIdSplitLen {
+			_, _, location, instanceName, shareName, err = parseMultishareVolId(volumeID)
+		} else {
+			_, location, instanceName, shareName, err = parseSourceVolId(volumeID)
+		}
+		if err != nil {
+			return false
+		}
+		_, sourceLocation, sourceInstance, sourceShare, err := util.ParseShareURI(backup.SourceInstance)
+		return err == nil && sourceLocation == location && sourceInstance == instanceName && sourceShare == shareName
+	}
+
+	filer, _, err := getFileInstanceFromID(volumeID)
+	if err != nil {
+		return false
+	}
+	_, sourceLocation, sourceInstance, err := util.ParseInstanceURI(backup.SourceInstance)
+	return err == nil && sourceLocation == filer.Location && sourceInstance == filer.Name && backup.SourceShare == filer.Volume.Name
+}
+
+// backupToCSISnapshot generates a CSI snapshot from a Filestore backup. Backups that are still being
+// created are returned as not ready to use, backups in any other state except READY return an error.
+func backupToCSISnapshot(ctx context.Context, backup *file.Backup) (*csi.Snapshot, error) {
+	mode := modeInstance
+	if strings.Contains(backup.SourceInstance, "/shares/") {
+		mode = modeMultishare
+	}
+	volumeID, err := util.BackupVolumeSourceToCSIVolumeHandle(mode, backup.SourceInstance, backup.SourceShare)
+	if err != nil {
+		return nil, err
+	}
+
+	snapshot, err := file.ProcessExistingBackup(ctx, backup, volumeID, mode)
+	if status.Code(err) == codes.DeadlineExceeded {
+		tp, err := util.ParseTimestamp(backup.Backup.CreateTime)
+		if err != nil {
+			return nil, err
+		}
+		return &csi.Snapshot{
+			SizeBytes:      util.GbToBytes(backup.Backup.CapacityGb),
+			SnapshotId:     backup.Backup.Name,
+			SourceVolumeId: volumeID,
+			CreationTime:   tp,
+			ReadyToUse:     false,
+		}, nil
+	}
+	return snapshot, err
+}
+
 func parseNfsExportOptions(optionsString string) ([]*file.NfsExportOptions, error) {
 	if optionsString == "" {
 		return nil, nil
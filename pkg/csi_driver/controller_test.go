@@ -438,6 +438,274 @@ func TestCreateVolumeFromSnapshot(t *testing.T) {
 	}
 }
 
+func TestCreateVolumeFromVolume(t *testing.T) {
+	sourceInstanceName := "myinstance"
+	sourceShareName := "myshare"
+	sourceVolumeID := fmt.Sprintf("modeInstance/%s/%s/%s", testZone, sourceInstanceName, sourceShareName)
+	cloneBackupURI := fmt.Sprintf("projects/%s/locations/%s/backups/%s", testProject, testRegion, testCSIVolume)
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+	volumeContentSource := func(volumeID string) *csi.VolumeContentSource {
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: volumeID,
+				},
+			},
+		}
+	}
+
+	cases := []struct {
+		name            string
+		req             *csi.CreateVolumeRequest
+		sourceSizeBytes int64
+		existingClone   bool
+		existingBackup  bool
+		resp            *csi.CreateVolumeResponse
+		expectErr       codes.Code
+	}{
+		{
+			name: "clone instance volume",
+			req: &csi.CreateVolumeRequest{
+				Name:                testCSIVolume,
+				VolumeContentSource: volumeContentSource(sourceVolumeID),
+				VolumeCapabilities:  volumeCapabilities,
+			},
+			sourceSizeBytes: defaultTierMinSize,
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: defaultTierMinSize,
+					VolumeId:      testVolumeID,
+					VolumeContext: map[string]string{
+						attrIP:           testIP,
+						attrVolume:       newInstanceVolume,
+						attrFileProtocol: v3FileProtocol,
+					},
+					ContentSource: volumeContentSource(sourceVolumeID),
+				},
+			},
+		},
+		{
+			name: "transient backup left by a previous attempt is reused",
+			req: &csi.CreateVolumeRequest{
+				Name:                testCSIVolume,
+				VolumeContentSource: volumeContentSource(sourceVolumeID),
+				VolumeCapabilities:  volumeCapabilities,
+			},
+			sourceSizeBytes: defaultTierMinSize,
+			existingBackup:  true,
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: defaultTierMinSize,
+					VolumeId:      testVolumeID,
+					VolumeContext: map[string]string{
+						attrIP:           testIP,
+						attrVolume:       newInstanceVolume,
+						attrFileProtocol: v3FileProtocol,
+					},
+					ContentSource: volumeContentSource(sourceVolumeID),
+				},
+			},
+		},
+		{
+			name: "clone already exists, transient backup is deleted",
+			req: &csi.CreateVolumeRequest{
+				Name:                testCSIVolume,
+				VolumeContentSource: volumeContentSource(sourceVolumeID),
+				VolumeCapabilities:  volumeCapabilities,
+			},
+			sourceSizeBytes: defaultTierMinSize,
+			existingClone:   true,
+			existingBackup:  true,
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: defaultTierMinSize,
+					VolumeId:      testVolumeID,
+					VolumeContext: map[string]string{
+						attrIP:           testIP,
+						attrVolume:       newInstanceVolume,
+						attrFileProtocol: v3FileProtocol,
+					},
+					ContentSource: volumeContentSource(sourceVolumeID),
+				},
+			},
+		},
+		{
+			name: "clone already exists, source volume deleted",
+			req: &csi.CreateVolumeRequest{
+				Name:                testCSIVolume,
+				VolumeContentSource: volumeContentSource(fmt.Sprintf("modeInstance/%s/%s/%s", testZone, "missing", sourceShareName)),
+				VolumeCapabilities:  volumeCapabilities,
+			},
+			sourceSizeBytes: defaultTierMinSize,
+			existingClone:   true,
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: defaultTierMinSize,
+					VolumeId:      testVolumeID,
+					VolumeContext: map[string]string{
+						attrIP:           testIP,
+						attrVolume:       newInstanceVolume,
+						attrFileProtocol: v3FileProtocol,
+					},
+					ContentSource: volumeContentSource(fmt.Sprintf("modeInstance/%s/%s/%s", testZone, "missing", sourceShareName)),
+				},
+			},
+		},
+		{
+			name: "source volume larger than requested capacity",
+			req: &csi.CreateVolumeRequest{
+				Name:                testCSIVolume,
+				VolumeContentSource: volumeContentSource(sourceVolumeID),
+				VolumeCapabilities:  volumeCapabilities,
+			},
+			sourceSizeBytes: testBytes,
+			expectErr:       codes.OutOfRange,
+		},
+		{
+			name: "source volume not found",
+			req: &csi.CreateVolumeRequest{
+				Name:                testCSIVolume,
+				VolumeContentSource: volumeContentSource(fmt.Sprintf("modeInstance/%s/%s/%s", testZone, "missing", sourceShareName)),
+				VolumeCapabilities:  volumeCapabilities,
+			},
+			sourceSizeBytes: defaultTierMinSize,
+			expectErr:       codes.NotFound,
+		},
+		{
+			name: "multishare source volume",
+			req: &csi.CreateVolumeRequest{
+				Name:                testCSIVolume,
+				VolumeContentSource: volumeContentSource(testMultishareVolumeID),
+				VolumeCapabilities:  volumeCapabilities,
+			},
+			sourceSizeBytes: defaultTierMinSize,
+			expectErr:       codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range cases {
+		cs := initTestController(t).(*controllerServer)
+		cs.config.tagManager.(*cloud.FakeTagServiceManager).
+			On("AttachResourceTags", context.TODO(), cloud.FilestoreInstance, testCSIVolume, testLocation, test.req.GetName(), test.req.GetParameters()).
+			Return(nil)
+
+		_, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+			Name:   sourceInstanceName,
+			Tier:   defaultTier,
+			Volume: file.Volume{Name: sourceShareName, SizeBytes: test.sourceSizeBytes},
+		})
+		if err != nil {
+			t.Fatalf("test %q failed to create source instance: %v", test.name, err)
+		}
+		if test.existingBackup {
+			_, err := cs.config.fileService.CreateBackup(context.TODO(), &file.BackupInfo{
+				Name:               testCSIVolume,
+				SourceVolumeId:     sourceVolumeID,
+				BackupURI:          cloneBackupURI,
+				Project:            testProject,
+				Location:           testRegion,
+				SourceInstanceName: sourceInstanceName,
+				SourceShare:        sourceShareName,
+			})
+			if err != nil {
+				t.Fatalf("test %q failed to create backup: %v", test.name, err)
+			}
+		}
+		if test.existingClone {
+			_, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+				Name:         testCSIVolume,
+				Tier:         defaultTier,
+				Volume:       file.Volume{Name: newInstanceVolume, SizeBytes: defaultTierMinSize},
+				Network:      file.Network{Name: defaultNetwork},
+				BackupSource: cloneBackupURI,
+			})
+			if err != nil {
+				t.Fatalf("test %q failed to create clone instance: %v", test.name, err)
+			}
+		}
+
+		resp, err := cs.CreateVolume(context.TODO(), test.req)
+		if test.expectErr != codes.OK {
+			if status.Code(err) != test.expectErr {
+				t.Errorf("test %q failed; expected error code %v, got %v", test.name, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %q failed: %v", test.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(resp, test.resp) {
+			t.Errorf("test %q failed: got resp %+v, expected %+v", test.name, resp, test.resp)
+		}
+		instance, err := cs.config.fileService.GetInstance(context.TODO(), &file.ServiceInstance{Name: testCSIVolume})
+		if err != nil {
+			t.Errorf("test %q failed: couldn't get instance: %v", test.name, err)
+		} else if instance.BackupSource != cloneBackupURI {
+			t.Errorf("test %q failed: expected instance restored from %q, got %q", test.name, cloneBackupURI, instance.BackupSource)
+		}
+		if _, err := cs.config.fileService.GetBackup(context.TODO(), cloneBackupURI); !file.IsNotFoundErr(err) {
+			t.Errorf("test %q failed: expected transient backup %q to be deleted, got %v", test.name, cloneBackupURI, err)
+		}
+	}
+}
+
+func TestCreateVolumeFromInstanceSnapshot(t *testing.T) {
+	cs := initTestController(t).(*controllerServer)
+	instance, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+		Name:    testCSIVolume,
+		Tier:    defaultTier,
+		Volume:  file.Volume{Name: newInstanceVolume, SizeBytes: defaultTierMinSize},
+		Network: file.Network{Name: defaultNetwork},
+	})
+	if err != nil {
+		t.Fatalf("failed to create instance: %v", err)
+	}
+	snapshot, err := cs.config.fileService.CreateSnapshot(context.TODO(), instance, "snap", nil)
+	if err != nil {
+		t.Fatalf("failed to create snapshot: %v", err)
+	}
+
+	req := &csi.CreateVolumeRequest{
+		Name: "pvc-5a7d0b64-9f2e-4c0e-8a53-2f1e6c3b7d10",
+		VolumeContentSource: &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Snapshot{
+				Snapshot: &csi.VolumeContentSource_SnapshotSource{
+					SnapshotId: file.SnapshotURI(testProject, testZone, testCSIVolume, "snap"),
+				},
+			},
+		},
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{
+					Mount: &csi.VolumeCapability_MountVolume{},
+				},
+				AccessMode: &csi.VolumeCapability_AccessMode{
+					Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+				},
+			},
+		},
+	}
+	if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected error code %v, got %v", codes.InvalidArgument, err)
+	}
+	if _, err := cs.config.fileService.GetInstance(context.TODO(), &file.ServiceInstance{Name: req.GetName()}); !file.IsNotFoundErr(err) {
+		t.Errorf("expected no instance to be created, got %v", err)
+	}
+	if _, err := cs.config.fileService.GetSnapshot(context.TODO(), snapshot.Name); err != nil {
+		t.Errorf("expected snapshot %v to be kept, got %v", snapshot.Name, err)
+	}
+}
+
 func TestCreateVolume(t *testing.T) {
 	features := &GCFSDriverFeatureOptions{
 		FeatureNFSExportOptionsOnCreate: &FeatureNFSExportOptionsOnCreate{
@@ -488,6 +756,169 @@ func TestCreateVolume(t *testing.T) {
 			},
 			features: features,
 		},
+		{
+			name: "encryption in transit",
+			req: &csi.CreateVolumeRequest{
+				Name: testCSIVolume,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"tier":                   zonalTier,
+					"protocol":               v4_1FileProtocol,
+					paramEncryptionInTransit: "true",
+				},
+			},
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: 1 * util.Tb,
+					VolumeId:      testVolumeID,
+					VolumeContext: map[string]string{
+						attrIP:                  testIP,
+						attrVolume:              newInstanceVolume,
+						attrFileProtocol:        v4_1FileProtocol,
+						attrEncryptionInTransit: "true",
+					},
+				},
+			},
+			features: features,
+		},
+		{
+			name: "encryption in transit without NFSv4.1",
+			req: &csi.CreateVolumeRequest{
+				Name: testCSIVolume,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"tier":                   zonalTier,
+					paramEncryptionInTransit: "true",
+				},
+			},
+			expectErr: true,
+			features:  features,
+		},
+		{
+			name: "kerberos security flavor",
+			req: &csi.CreateVolumeRequest{
+				Name: testCSIVolume,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"tier":                 zonalTier,
+					"protocol":             v4_1FileProtocol,
+					paramSecurityFlavor:    "KRB5P",
+					paramManagedADDomain:   "projects/test-project/locations/global/domains/ad.example.com",
+					paramManagedADComputer: "my-computer",
+				},
+			},
+			resp: &csi.CreateVolumeResponse{
+				Volume: &csi.Volume{
+					CapacityBytes: 1 * util.Tb,
+					VolumeId:      testVolumeID,
+					VolumeContext: map[string]string{
+						attrIP:             testIP,
+						attrVolume:         newInstanceVolume,
+						attrFileProtocol:   v4_1FileProtocol,
+						attrSecurityFlavor: securityFlavorKrb5p,
+						attrServerName:     "my-computer.filestore.ad.example.com",
+					},
+				},
+			},
+			features: features,
+		},
+		{
+			name: "kerberos security flavor without NFSv4.1",
+			req: &csi.CreateVolumeRequest{
+				Name: testCSIVolume,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"tier":                 zonalTier,
+					paramSecurityFlavor:    securityFlavorKrb5,
+					paramManagedADDomain:   "projects/test-project/locations/global/domains/ad.example.com",
+					paramManagedADComputer: "my-computer",
+				},
+			},
+			expectErr: true,
+			features:  features,
+		},
+		{
+			name: "kerberos security flavor without managed AD",
+			req: &csi.CreateVolumeRequest{
+				Name: testCSIVolume,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"tier":              zonalTier,
+					"protocol":          v4_1FileProtocol,
+					paramSecurityFlavor: securityFlavorKrb5i,
+				},
+			},
+			expectErr: true,
+			features:  features,
+		},
+		{
+			name: "invalid security flavor",
+			req: &csi.CreateVolumeRequest{
+				Name: testCSIVolume,
+				VolumeCapabilities: []*csi.VolumeCapability{
+					{
+						AccessType: &csi.VolumeCapability_Mount{
+							Mount: &csi.VolumeCapability_MountVolume{},
+						},
+						AccessMode: &csi.VolumeCapability_AccessMode{
+							Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+						},
+					},
+				},
+				Parameters: map[string]string{
+					"tier":              zonalTier,
+					"protocol":          v4_1FileProtocol,
+					paramSecurityFlavor: "krb4",
+				},
+			},
+			expectErr: true,
+			features:  features,
+		},
 		{
 			name: "create volume without providing protocol for basic",
 			req: &csi.CreateVolumeRequest{
@@ -713,75 +1144,369 @@ func TestCreateVolume(t *testing.T) {
 
 	for _, test := range cases {
 		cs := initTestController(t).(*controllerServer)
-		cs.config.features = test.features
-		cs.config.tagManager.(*cloud.FakeTagServiceManager).
-			On("AttachResourceTags", context.TODO(), cloud.FilestoreInstance, testCSIVolume, testLocation, test.req.GetName(), test.req.GetParameters()).
-			Return(nil)
-		cs.config.tagManager.(*cloud.FakeTagServiceManager).
-			On("AttachResourceTags", context.TODO(), cloud.FilestoreInstance, testCSIVolume2, testLocation, test.req.GetName(), test.req.GetParameters()).
-			Return(fmt.Errorf("mock failure: error while adding tags to filestore instance"))
-
-		resp, err := cs.CreateVolume(context.TODO(), test.req)
+		cs.config.features = test.features
+		cs.config.tagManager.(*cloud.FakeTagServiceManager).
+			On("AttachResourceTags", context.TODO(), cloud.FilestoreInstance, testCSIVolume, testLocation, test.req.GetName(), test.req.GetParameters()).
+			Return(nil)
+		cs.config.tagManager.(*cloud.FakeTagServiceManager).
+			On("AttachResourceTags", context.TODO(), cloud.FilestoreInstance, testCSIVolume2, testLocation, test.req.GetName(), test.req.GetParameters()).
+			Return(fmt.Errorf("mock failure: error while adding tags to filestore instance"))
+
+		resp, err := cs.CreateVolume(context.TODO(), test.req)
+
+		if !test.expectErr && err != nil {
+			t.Errorf("test %q failed: %v", test.name, err)
+		}
+		if test.expectErr && err == nil {
+			t.Errorf("test %q failed; got success", test.name)
+		}
+		if !reflect.DeepEqual(resp, test.resp) {
+			t.Errorf("test %q failed: got resp %+v, expected %+v", test.name, resp, test.resp)
+		}
+
+		if !test.expectErr && test.req.Parameters[ParamNfsExportOptions] != "" {
+			instance, err := cs.config.fileService.GetInstance(context.TODO(), &file.ServiceInstance{Name: test.req.Name})
+			if err != nil {
+				t.Errorf("test %q failed: couldn't get instance %v: %v", test.name, test.req.Name, err)
+				return
+			}
+			for i := range test.expectedOptions {
+				if !reflect.DeepEqual(instance.NfsExportOptions[i], test.expectedOptions[i]) {
+					t.Errorf("test %q failed; nfs export options not equal at index %d: got %+v, expected %+v", test.name, i, instance.NfsExportOptions[i], test.expectedOptions[i])
+				}
+			}
+		}
+	}
+}
+
+func TestDeleteVolume(t *testing.T) {
+	cases := []struct {
+		name      string
+		req       *csi.DeleteVolumeRequest
+		expectErr bool
+	}{
+		{
+			name: "valid",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: testVolumeID,
+			},
+		},
+		{
+			name: "invalid id",
+			req: &csi.DeleteVolumeRequest{
+				VolumeId: testVolumeID + "/foo",
+			},
+		},
+		{
+			name:      "empty id",
+			req:       &csi.DeleteVolumeRequest{},
+			expectErr: true,
+		},
+		// TODO: delete failed
+	}
+
+	for _, test := range cases {
+		cs := initTestController(t)
+		_, err := cs.DeleteVolume(context.TODO(), test.req)
+		if !test.expectErr && err != nil {
+			t.Errorf("test %q failed: %v", test.name, err)
+		}
+		if test.expectErr && err == nil {
+			t.Errorf("test %q failed; got success", test.name)
+		}
+	}
+}
+
+func TestListVolumes(t *testing.T) {
+	cases := []struct {
+		name              string
+		req               *csi.ListVolumesRequest
+		expectedEntries   int
+		expectedNextToken string
+		expectErr         codes.Code
+	}{
+		{
+			name:            "all volumes",
+			req:             &csi.ListVolumesRequest{},
+			expectedEntries: 4,
+		},
+		{
+			name:              "first page",
+			req:               &csi.ListVolumesRequest{MaxEntries: 3},
+			expectedEntries:   3,
+			expectedNextToken: "3",
+		},
+		{
+			name:            "last page",
+			req:             &csi.ListVolumesRequest{MaxEntries: 3, StartingToken: "3"},
+			expectedEntries: 1,
+		},
+		{
+			name:            "starting token at end of list",
+			req:             &csi.ListVolumesRequest{StartingToken: "4"},
+			expectedEntries: 0,
+		},
+		{
+			name:      "starting token past end of list",
+			req:       &csi.ListVolumesRequest{StartingToken: "5"},
+			expectErr: codes.Aborted,
+		},
+		{
+			name:      "non numeric starting token",
+			req:       &csi.ListVolumesRequest{StartingToken: "foo"},
+			expectErr: codes.Aborted,
+		},
+		{
+			name:      "negative max entries",
+			req:       &csi.ListVolumesRequest{MaxEntries: -1},
+			expectErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range cases {
+		cs := initTestController(t).(*controllerServer)
+		// Only the instance volumes created by the driver are listed.
+		instanceLabels := map[string]map[string]string{
+			"instance-a": {tagKeyCreatedBy: "test-driver"},
+			"instance-b": {tagKeyCreatedBy: "test-driver"},
+			"instance-c": {tagKeyCreatedBy: "test-driver"},
+			"instance-d": {tagKeyCreatedBy: "test-driver"},
+			"foreign":    {tagKeyCreatedBy: "other-driver"},
+			"multishare": {tagKeyCreatedBy: "test-driver", util.ParamMultishareInstanceScLabelKey: "test-sc"},
+			"subdirs":    {tagKeyCreatedBy: "test-driver", tagKeySubDirectoryPool: "test-pool"},
+		}
+		for name, labels := range instanceLabels {
+			_, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+				Name:   name,
+				Volume: file.Volume{Name: newInstanceVolume, SizeBytes: testBytes},
+				Labels: labels,
+			})
+			if err != nil {
+				t.Fatalf("test %q failed to create instance: %v", test.name, err)
+			}
+		}
+
+		resp, err := cs.ListVolumes(context.TODO(), test.req)
+		if test.expectErr != codes.OK {
+			if status.Code(err) != test.expectErr {
+				t.Errorf("test %q failed; expected error code %v, got %v", test.name, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %q failed: %v", test.name, err)
+			continue
+		}
+		if len(resp.GetEntries()) != test.expectedEntries {
+			t.Errorf("test %q failed; expected %d entries, got %d", test.name, test.expectedEntries, len(resp.GetEntries()))
+		}
+		if resp.GetNextToken() != test.expectedNextToken {
+			t.Errorf("test %q failed; expected next token %q, got %q", test.name, test.expectedNextToken, resp.GetNextToken())
+		}
+		for _, entry := range resp.GetEntries() {
+			if entry.GetStatus().GetVolumeCondition().GetAbnormal() {
+				t.Errorf("test %q failed; unexpected abnormal volume %+v", test.name, entry)
+			}
+		}
+	}
+}
+
+func TestControllerGetVolume(t *testing.T) {
+	cases := []struct {
+		name             string
+		state            string
+		volumeID         string
+		expectedAbnormal bool
+		expectErr        codes.Code
+	}{
+		{
+			name:     "ready instance",
+			state:    "READY",
+			volumeID: testVolumeID,
+		},
+		{
+			name:     "creating instance",
+			state:    "CREATING",
+			volumeID: testVolumeID,
+		},
+		{
+			name:             "instance in error state",
+			state:            "ERROR",
+			volumeID:         testVolumeID,
+			expectedAbnormal: true,
+		},
+		{
+			name:      "instance not found",
+			state:     "READY",
+			volumeID:  fmt.Sprintf("modeInstance/%s/%s/%s", testZone, "missing", newInstanceVolume),
+			expectErr: codes.NotFound,
+		},
+		{
+			name:      "invalid id",
+			state:     "READY",
+			volumeID:  testVolumeID + "/foo",
+			expectErr: codes.NotFound,
+		},
+		{
+			name:      "empty id",
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:      "multishare id without multishare controller",
+			volumeID:  "modeMultishare/test-prefix/test-project/us-central1/test-instance/test-share",
+			expectErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, test := range cases {
+		cs := initTestController(t).(*controllerServer)
+		instance, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+			Name:   testCSIVolume,
+			Volume: file.Volume{Name: newInstanceVolume, SizeBytes: testBytes},
+		})
+		if err != nil {
+			t.Fatalf("test %q failed to create instance: %v", test.name, err)
+		}
+		instance.State = test.state
 
-		if !test.expectErr && err != nil {
-			t.Errorf("test %q failed: %v", test.name, err)
+		resp, err := cs.ControllerGetVolume(context.TODO(), &csi.ControllerGetVolumeRequest{VolumeId: test.volumeID})
+		if test.expectErr != codes.OK {
+			if status.Code(err) != test.expectErr {
+				t.Errorf("test %q failed; expected error code %v, got %v", test.name, test.expectErr, err)
+			}
+			continue
 		}
-		if test.expectErr && err == nil {
-			t.Errorf("test %q failed; got success", test.name)
+		if err != nil {
+			t.Errorf("test %q failed: %v", test.name, err)
+			continue
 		}
-		if !reflect.DeepEqual(resp, test.resp) {
-			t.Errorf("test %q failed: got resp %+v, expected %+v", test.name, resp, test.resp)
+		if resp.GetVolume().GetVolumeId() != test.volumeID {
+			t.Errorf("test %q failed; expected volume id %q, got %q", test.name, test.volumeID, resp.GetVolume().GetVolumeId())
 		}
-
-		if !test.expectErr && test.req.Parameters[ParamNfsExportOptions] != "" {
-			instance, err := cs.config.fileService.GetInstance(context.TODO(), &file.ServiceInstance{Name: test.req.Name})
-			if err != nil {
-				t.Errorf("test %q failed: couldn't get instance %v: %v", test.name, test.req.Name, err)
-				return
-			}
-			for i := range test.expectedOptions {
-				if !reflect.DeepEqual(instance.NfsExportOptions[i], test.expectedOptions[i]) {
-					t.Errorf("test %q failed; nfs export options not equal at index %d: got %+v, expected %+v", test.name, i, instance.NfsExportOptions[i], test.expectedOptions[i])
-				}
-			}
+		if resp.GetStatus().GetVolumeCondition().GetAbnormal() != test.expectedAbnormal {
+			t.Errorf("test %q failed; expected abnormal %v, got %+v", test.name, test.expectedAbnormal, resp.GetStatus().GetVolumeCondition())
 		}
 	}
 }
 
-func TestDeleteVolume(t *testing.T) {
+func TestGetCapacity(t *testing.T) {
+	quotaBytes := int64(100 * util.Tb)
 	cases := []struct {
-		name      string
-		req       *csi.DeleteVolumeRequest
-		expectErr bool
+		name              string
+		params            map[string]string
+		topology          *csi.Topology
+		existingTier      string
+		expectedAvailable int64
+		expectedMin       int64
+		expectedMax       int64
+		expectErr         codes.Code
 	}{
 		{
-			name: "valid",
-			req: &csi.DeleteVolumeRequest{
-				VolumeId: testVolumeID,
-			},
+			name:              "default tier without instances",
+			expectedAvailable: quotaBytes,
+			expectedMin:       defaultTierMinSize,
+			expectedMax:       defaultTierMaxSize,
 		},
 		{
-			name: "invalid id",
-			req: &csi.DeleteVolumeRequest{
-				VolumeId: testVolumeID + "/foo",
-			},
+			name:              "default tier with an instance in the region",
+			topology:          &csi.Topology{Segments: map[string]string{TopologyKeyZone: "us-central1-a"}},
+			existingTier:      defaultTier,
+			expectedAvailable: quotaBytes - testBytes,
+			expectedMin:       defaultTierMinSize,
+			expectedMax:       defaultTierMaxSize,
 		},
 		{
-			name:      "empty id",
-			req:       &csi.DeleteVolumeRequest{},
-			expectErr: true,
+			name:              "basic_hdd shares the quota of the standard tier",
+			params:            map[string]string{paramTier: basicHDDTier},
+			existingTier:      defaultTier,
+			expectedAvailable: quotaBytes - testBytes,
+			expectedMin:       defaultTierMinSize,
+			expectedMax:       defaultTierMaxSize,
+		},
+		{
+			name:              "instance of another tier",
+			params:            map[string]string{paramTier: premiumTier},
+			existingTier:      defaultTier,
+			expectedAvailable: quotaBytes,
+			expectedMin:       premiumTierMinSize,
+			expectedMax:       premiumTierMaxSize,
+		},
+		{
+			name:              "instance in another region",
+			topology:          &csi.Topology{Segments: map[string]string{TopologyKeyZone: "us-east1-b"}},
+			existingTier:      defaultTier,
+			expectedAvailable: quotaBytes,
+			expectedMin:       defaultTierMinSize,
+			expectedMax:       defaultTierMaxSize,
+		},
+		{
+			name:              "region location parameter",
+			params:            map[string]string{paramTier: enterpriseTier, paramLocation: testRegion},
+			expectedAvailable: quotaBytes,
+			expectedMin:       enterpriseTierMinSize,
+			expectedMax:       enterpriseTierMaxSize,
+		},
+		{
+			name:              "zonal tier",
+			params:            map[string]string{paramTier: zonalTier},
+			expectedAvailable: quotaBytes,
+			expectedMin:       zonalSmallTierMinSize,
+			expectedMax:       zonalLargeTierMaxSize,
+		},
+		{
+			name:              "multishare",
+			params:            map[string]string{paramMultishare: "true"},
+			expectedAvailable: quotaBytes,
+			expectedMin:       util.MinShareSizeBytes,
+			expectedMax:       util.MaxShareSizeBytes,
+		},
+		{
+			name:      "invalid tier",
+			params:    map[string]string{paramTier: "foo"},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:      "invalid zone",
+			topology:  &csi.Topology{Segments: map[string]string{TopologyKeyZone: "foo"}},
+			expectErr: codes.InvalidArgument,
 		},
-		// TODO: delete failed
 	}
 
 	for _, test := range cases {
-		cs := initTestController(t)
-		_, err := cs.DeleteVolume(context.TODO(), test.req)
-		if !test.expectErr && err != nil {
-			t.Errorf("test %q failed: %v", test.name, err)
+		cs := initTestController(t).(*controllerServer)
+		if test.existingTier != "" {
+			_, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+				Name:   testCSIVolume,
+				Tier:   test.existingTier,
+				Volume: file.Volume{Name: newInstanceVolume, SizeBytes: testBytes},
+			})
+			if err != nil {
+				t.Fatalf("test %q failed to create instance: %v", test.name, err)
+			}
 		}
-		if test.expectErr && err == nil {
-			t.Errorf("test %q failed; got success", test.name)
+
+		resp, err := cs.GetCapacity(context.TODO(), &csi.GetCapacityRequest{
+			Parameters:         test.params,
+			AccessibleTopology: test.topology,
+		})
+		if test.expectErr != codes.OK {
+			if status.Code(err) != test.expectErr {
+				t.Errorf("test %q failed: expected error code %v, got %v", test.name, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %q failed: unexpected error: %v", test.name, err)
+			continue
+		}
+		if resp.GetAvailableCapacity() != test.expectedAvailable {
+			t.Errorf("test %q failed: expected available capacity %d, got %d", test.name, test.expectedAvailable, resp.GetAvailableCapacity())
+		}
+		if resp.GetMinimumVolumeSize().GetValue() != test.expectedMin {
+			t.Errorf("test %q failed: expected minimum volume size %d, got %d", test.name, test.expectedMin, resp.GetMinimumVolumeSize().GetValue())
+		}
+		if resp.GetMaximumVolumeSize().GetValue() != test.expectedMax {
+			t.Errorf("test %q failed: expected maximum volume size %d, got %d", test.name, test.expectedMax, resp.GetMaximumVolumeSize().GetValue())
 		}
 	}
 }
@@ -1455,6 +2180,14 @@ func TestGetZoneFromSegment(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "Zone not in region of segment",
+			seg: map[string]string{
+				TopologyKeyZone:   "us-central1-c",
+				TopologyKeyRegion: "us-east1",
+			},
+			expectErr: true,
+		},
 		// Successful cases
 		{
 			name: "Found expected zone",
@@ -1463,6 +2196,14 @@ func TestGetZoneFromSegment(t *testing.T) {
 			},
 			expectedZone: "z1",
 		},
+		{
+			name: "Found expected zone with region",
+			seg: map[string]string{
+				TopologyKeyZone:   "us-central1-c",
+				TopologyKeyRegion: "us-central1",
+			},
+			expectedZone: "us-central1-c",
+		},
 	}
 
 	for _, tc := range cases {
@@ -2129,6 +2870,300 @@ func TestDeleteSnapshot(t *testing.T) {
 
 }
 
+func TestInstanceSnapshot(t *testing.T) {
+	snapshotName := "mysnapshot"
+	snapshotURI := file.SnapshotURI(testProject, testZone, testCSIVolume, snapshotName)
+	snapshotParams := map[string]string{util.VolumeSnapshotTypeKey: util.VolumeSnapshotTypeSnapshot}
+	cases := []struct {
+		name          string
+		req           *csi.CreateSnapshotRequest
+		existingState string
+		resp          *csi.CreateSnapshotResponse
+		expectErr     codes.Code
+	}{
+		{
+			name: "create snapshot",
+			req: &csi.CreateSnapshotRequest{
+				SourceVolumeId: testVolumeID,
+				Name:           snapshotName,
+				Parameters:     snapshotParams,
+			},
+			resp: &csi.CreateSnapshotResponse{
+				Snapshot: &csi.Snapshot{
+					SizeBytes:      testBytes,
+					SnapshotId:     snapshotURI,
+					SourceVolumeId: testVolumeID,
+					ReadyToUse:     true,
+				},
+			},
+		},
+		{
+			name: "existing snapshot",
+			req: &csi.CreateSnapshotRequest{
+				SourceVolumeId: testVolumeID,
+				Name:           snapshotName,
+				Parameters:     snapshotParams,
+			},
+			existingState: "READY",
+			resp: &csi.CreateSnapshotResponse{
+				Snapshot: &csi.Snapshot{
+					SizeBytes:      testBytes,
+					SnapshotId:     snapshotURI,
+					SourceVolumeId: testVolumeID,
+					ReadyToUse:     true,
+				},
+			},
+		},
+		{
+			name: "existing snapshot in state CREATING",
+			req: &csi.CreateSnapshotRequest{
+				SourceVolumeId: testVolumeID,
+				Name:           snapshotName,
+				Parameters:     snapshotParams,
+			},
+			existingState: "CREATING",
+			expectErr:     codes.DeadlineExceeded,
+		},
+		{
+			name: "source instance not found",
+			req: &csi.CreateSnapshotRequest{
+				SourceVolumeId: fmt.Sprintf("modeInstance/%s/%s/%s", testZone, "missing", newInstanceVolume),
+				Name:           snapshotName,
+				Parameters:     snapshotParams,
+			},
+			expectErr: codes.NotFound,
+		},
+	}
+
+	for _, test := range cases {
+		cs := initTestController(t).(*controllerServer)
+		instance, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+			Name:   testCSIVolume,
+			Volume: file.Volume{Name: newInstanceVolume, SizeBytes: testBytes},
+		})
+		if err != nil {
+			t.Fatalf("test %q failed to create instance: %v", test.name, err)
+		}
+		if test.existingState != "" {
+			snapshot, err := cs.config.fileService.CreateSnapshot(context.TODO(), instance, snapshotName, nil)
+			if err != nil {
+				t.Fatalf("test %q failed to create snapshot: %v", test.name, err)
+			}
+			snapshot.State = test.existingState
+		}
+
+		resp, err := cs.CreateSnapshot(context.TODO(), test.req)
+		if test.expectErr != codes.OK {
+			if status.Code(err) != test.expectErr {
+				t.Errorf("test %q failed; expected error code %v, got %v", test.name, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %q failed: %v", test.name, err)
+			continue
+		}
+		resp.Snapshot.CreationTime = nil
+		if !reflect.DeepEqual(resp, test.resp) {
+			t.Errorf("test %q failed: got resp %+v, expected %+v", test.name, resp, test.resp)
+		}
+		snapshot, err := cs.config.fileService.GetSnapshot(context.TODO(), snapshotURI)
+		if err != nil {
+			t.Fatalf("test %q failed: couldn't get snapshot: %v", test.name, err)
+		}
+		if test.existingState == "" && snapshot.Snapshot.Labels[tagKeySnapshotName] != snapshotName {
+			t.Errorf("test %q failed: got label %v %q, want %q", test.name, tagKeySnapshotName, snapshot.Snapshot.Labels[tagKeySnapshotName], snapshotName)
+		}
+
+		if _, err := cs.DeleteSnapshot(context.TODO(), &csi.DeleteSnapshotRequest{SnapshotId: snapshotURI}); err != nil {
+			t.Errorf("test %q failed to delete snapshot: %v", test.name, err)
+		}
+		if _, err := cs.config.fileService.GetSnapshot(context.TODO(), snapshotURI); !file.IsNotFoundErr(err) {
+			t.Errorf("test %q failed: expected snapshot %q to be deleted, got %v", test.name, snapshotURI, err)
+		}
+		// Deleting a snapshot is idempotent.
+		if _, err := cs.DeleteSnapshot(context.TODO(), &csi.DeleteSnapshotRequest{SnapshotId: snapshotURI}); err != nil {
+			t.Errorf("test %q failed to delete deleted snapshot: %v", test.name, err)
+		}
+	}
+}
+
+func TestListSnapshots(t *testing.T) {
+	instanceVolumeID := fmt.Sprintf("modeInstance/%s/%s/%s", testZone, testCSIVolume, newInstanceVolume)
+	multishareSourceVolumeID := fmt.Sprintf("%s/%s/%s/%s", modeMultishare, testRegion, testCSIVolume2, "share1")
+	multishareVolumeID := fmt.Sprintf("%s/%s/%s/%s/%s/%s", modeMultishare, "test-prefix", testProject, testRegion, testCSIVolume2, "share1")
+	backupID := func(name string) string {
+		return fmt.Sprintf("projects/%s/locations/%s/backups/%s", testProject, testRegion, name)
+	}
+	snapshotLabels := map[string]string{tagKeyCreatedBy: "test-driver", tagKeySnapshotName: "snapshot"}
+	backups := []struct {
+		name           string
+		sourceVolumeID string
+		state          string
+		labels         map[string]string
+	}{
+		{name: "backup-1", sourceVolumeID: instanceVolumeID, state: "READY", labels: snapshotLabels},
+		{name: "backup-2", sourceVolumeID: instanceVolumeID, state: "CREATING", labels: snapshotLabels},
+		{name: "backup-3", sourceVolumeID: multishareSourceVolumeID, state: "READY", labels: snapshotLabels},
+		{name: "backup-4", sourceVolumeID: instanceVolumeID, state: "ERROR", labels: snapshotLabels},
+		// Backups which are not taken for snapshots.
+		{name: "backup-copy", sourceVolumeID: instanceVolumeID, state: "READY", labels: map[string]string{tagKeyCreatedBy: "test-driver", tagKeySnapshotName: "snapshot", tagKeyBackupCopyOf: "us-east1"}},
+		{name: "backup-clone", sourceVolumeID: instanceVolumeID, state: "READY", labels: map[string]string{tagKeyCreatedBy: "test-driver"}},
+		{name: "backup-schedule", sourceVolumeID: instanceVolumeID, state: "READY", labels: map[string]string{tagKeyCreatedBy: "test-driver", tagKeyBackupScheduleName: "schedule"}},
+		{name: "backup-other-tool", sourceVolumeID: instanceVolumeID, state: "READY"},
+	}
+	snapshotID := file.SnapshotURI(testProject, testZone, testCSIVolume, "snap-1")
+
+	cases := []struct {
+		name              string
+		req               *csi.ListSnapshotsRequest
+		expectedIDs       []string
+		expectedNextToken string
+		expectErr         codes.Code
+	}{
+		{
+			name:        "all snapshots",
+			req:         &csi.ListSnapshotsRequest{},
+			expectedIDs: []string{snapshotID, backupID("backup-1"), backupID("backup-2"), backupID("backup-3")},
+		},
+		{
+			name:              "first page",
+			req:               &csi.ListSnapshotsRequest{MaxEntries: 2},
+			expectedIDs:       []string{snapshotID, backupID("backup-1")},
+			expectedNextToken: "2",
+		},
+		{
+			name:        "last page",
+			req:         &csi.ListSnapshotsRequest{MaxEntries: 2, StartingToken: "2"},
+			expectedIDs: []string{backupID("backup-2"), backupID("backup-3")},
+		},
+		{
+			name:      "invalid starting token",
+			req:       &csi.ListSnapshotsRequest{StartingToken: "foo"},
+			expectErr: codes.Aborted,
+		},
+		{
+			name:      "negative max entries",
+			req:       &csi.ListSnapshotsRequest{MaxEntries: -1},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:        "filter by snapshot id",
+			req:         &csi.ListSnapshotsRequest{SnapshotId: backupID("backup-3")},
+			expectedIDs: []string{backupID("backup-3")},
+		},
+		{
+			name:        "filter by snapshot id of a backup not taken for a snapshot",
+			req:         &csi.ListSnapshotsRequest{SnapshotId: backupID("backup-other-tool")},
+			expectedIDs: []string{backupID("backup-other-tool")},
+		},
+		{
+			name:        "filter by instance snapshot id",
+			req:         &csi.ListSnapshotsRequest{SnapshotId: snapshotID},
+			expectedIDs: []string{snapshotID},
+		},
+		{
+			name: "instance snapshot id not found",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: file.SnapshotURI(testProject, testZone, testCSIVolume, "missing")},
+		},
+		{
+			name: "instance snapshot id of a missing instance",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: file.SnapshotURI(testProject, testZone, "missing", "snap-1")},
+		},
+		{
+			name: "filter by instance snapshot id and different source volume id",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: snapshotID, SourceVolumeId: multishareVolumeID},
+		},
+		{
+			name: "snapshot id not found",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: backupID("missing")},
+		},
+		{
+			name: "invalid snapshot id",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: "foo"},
+		},
+		{
+			name:        "filter by instance source volume id",
+			req:         &csi.ListSnapshotsRequest{SourceVolumeId: instanceVolumeID},
+			expectedIDs: []string{snapshotID, backupID("backup-1"), backupID("backup-2")},
+		},
+		{
+			name:        "filter by multishare source volume id",
+			req:         &csi.ListSnapshotsRequest{SourceVolumeId: multishareVolumeID},
+			expectedIDs: []string{backupID("backup-3")},
+		},
+		{
+			name: "filter by snapshot id and different source volume id",
+			req:  &csi.ListSnapshotsRequest{SnapshotId: backupID("backup-3"), SourceVolumeId: instanceVolumeID},
+		},
+		{
+			name: "source volume id not found",
+			req:  &csi.ListSnapshotsRequest{SourceVolumeId: fmt.Sprintf("modeInstance/%s/%s/%s", testZone, "missing", newInstanceVolume)},
+		},
+	}
+
+	for _, test := range cases {
+		cs := initTestController(t).(*controllerServer)
+		instance, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+			Name:   testCSIVolume,
+			Tier:   zonalTier,
+			Volume: file.Volume{Name: newInstanceVolume, SizeBytes: testBytes},
+			Labels: map[string]string{tagKeyCreatedBy: "test-driver"},
+		})
+		if err != nil {
+			t.Fatalf("test %q failed to create instance: %v", test.name, err)
+		}
+		if _, err := cs.config.fileService.CreateSnapshot(context.TODO(), instance, "snap-1", nil); err != nil {
+			t.Fatalf("test %q failed to create snapshot: %v", test.name, err)
+		}
+		for _, b := range backups {
+			backupInfo, err := gatherBackupInfo(b.name, b.sourceVolumeID, testProject)
+			if err != nil {
+				t.Fatalf("test %q failed to gather backup info: %v", test.name, err)
+			}
+			backupInfo.BackupURI, backupInfo.Location, err = file.CreateBackupURI(backupInfo.Location, testProject, b.name, "")
+			if err != nil {
+				t.Fatalf("test %q failed to create backup uri: %v", test.name, err)
+			}
+			backupInfo.Labels = b.labels
+			backup, err := cs.config.fileService.CreateBackup(context.TODO(), backupInfo)
+			if err != nil {
+				t.Fatalf("test %q failed to create backup: %v", test.name, err)
+			}
+			backup.State = b.state
+		}
+
+		resp, err := cs.ListSnapshots(context.TODO(), test.req)
+		if test.expectErr != codes.OK {
+			if status.Code(err) != test.expectErr {
+				t.Errorf("test %q failed; expected error code %v, got %v", test.name, test.expectErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %q failed: %v", test.name, err)
+			continue
+		}
+		var ids []string
+		for _, entry := range resp.GetEntries() {
+			ids = append(ids, entry.GetSnapshot().GetSnapshotId())
+			if test.req.GetSourceVolumeId() != "" && entry.GetSnapshot().GetSourceVolumeId() != test.req.GetSourceVolumeId() {
+				t.Errorf("test %q failed; expected source volume id %q, got %q", test.name, test.req.GetSourceVolumeId(), entry.GetSnapshot().GetSourceVolumeId())
+			}
+			expectedReady := entry.GetSnapshot().GetSnapshotId() != backupID("backup-2")
+			if entry.GetSnapshot().GetReadyToUse() != expectedReady {
+				t.Errorf("test %q failed; expected snapshot %q ready to use %v", test.name, entry.GetSnapshot().GetSnapshotId(), expectedReady)
+			}
+		}
+		if !reflect.DeepEqual(ids, test.expectedIDs) {
+			t.Errorf("test %q failed; expected snapshots %v, got %v", test.name, test.expectedIDs, ids)
+		}
+		if resp.GetNextToken() != test.expectedNextToken {
+			t.Errorf("test %q failed; expected next token %q, got %q", test.name, test.expectedNextToken, resp.GetNextToken())
+		}
+	}
+}
+
 func TestCreateBackupURI(t *testing.T) {
 	backupName := "mybackup"
 	project := "test-project"
@@ -2394,6 +3429,44 @@ func TestParsingNfsExportOptions(t *testing.T) {
 	}
 }
 
+func TestWithSecurityFlavor(t *testing.T) {
+	cases := []struct {
+		name            string
+		options         []*file.NfsExportOptions
+		expectedOptions []*file.NfsExportOptions
+	}{
+		{
+			name: "no export options",
+			expectedOptions: []*file.NfsExportOptions{
+				{
+					AccessMode:      "READ_WRITE",
+					SquashMode:      "ROOT_SQUASH",
+					SecurityFlavors: []string{"KRB5P"},
+				},
+			},
+		},
+		{
+			name: "export options without security flavors",
+			options: []*file.NfsExportOptions{
+				{AccessMode: "READ_WRITE", IpRanges: []string{"10.0.0.0/24"}, SquashMode: "NO_ROOT_SQUASH"},
+				{AccessMode: "READ_ONLY", IpRanges: []string{"10.0.1.0/24"}, SecurityFlavors: []string{"KRB5"}},
+			},
+			expectedOptions: []*file.NfsExportOptions{
+				{AccessMode: "READ_WRITE", IpRanges: []string{"10.0.0.0/24"}, SquashMode: "NO_ROOT_SQUASH", SecurityFlavors: []string{"KRB5P"}},
+				{AccessMode: "READ_ONLY", IpRanges: []string{"10.0.1.0/24"}, SecurityFlavors: []string{"KRB5"}},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			options := withSecurityFlavor(tc.options, "krb5p")
+			if !reflect.DeepEqual(options, tc.expectedOptions) {
+				t.Errorf("got export options %+v, expected %+v", options, tc.expectedOptions)
+			}
+		})
+	}
+}
+
 func TestExtractLabels(t *testing.T) {
 	var (
 		driverName      = "test_driver"
@@ -0,0 +1,442 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// Defragmentation StorageClass parameters of the stateful multishare controller.
+const (
+	paramDefragMode              = "defrag-mode"
+	paramDefragMaintenanceWindow = "defrag-maintenance-window"
+
+	// defragModePlan only reports the consolidation plans, defragModeExecute executes them in the
+	// maintenance window with share migrations.
+	defragModePlan    = "plan"
+	defragModeExecute = "execute"
+
+	defaultDefragInterval = time.Hour
+
+	// defragPoolLabel is the label of the share migrations created to execute the consolidation plan of a pool.
+	defragPoolLabel = "multishare.filestore.csi.storage.gke.io/defrag-pool"
+
+	eventReasonDefragPlanned = "FilestorePoolDefragPlanned"
+)
+
+// maintenanceWindow is a daily window of time, in UTC, restricted to some days of the week if days is
+// not empty. A window ending before it starts ends the next day.
+type maintenanceWindow struct {
+	days       map[time.Weekday]bool
+	start, end time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// parseMaintenanceWindow parses a maintenance window of the form "[DAY[,DAY...] ]HH:MM-HH:MM", for example
+// "Sat,Sun 02:00-06:00". The days are the days the window starts on.
+func parseMaintenanceWindow(s string) (*maintenanceWindow, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields) > 2 {
+		return nil, fmt.Errorf("invalid maintenance window %q, must be of the form [DAY[,DAY...] ]HH:MM-HH:MM", s)
+	}
+	w := &maintenanceWindow{days: make(map[time.Weekday]bool)}
+	if len(fields) == 2 {
+		for _, day := range strings.Split(fields[0], ",") {
+			weekday, ok := weekdays[strings.ToLower(day)]
+			if !ok {
+				return nil, fmt.Errorf("invalid day %q of maintenance window %q", day, s)
+			}
+			w.days[weekday] = true
+		}
+	}
+	start, end, ok := strings.Cut(fields[len(fields)-1], "-")
+	if !ok {
+		return nil, fmt.Errorf("invalid hours of maintenance window %q, must be of the form HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.start, err = parseTimeOfDay(start); err != nil {
+		return nil, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+	}
+	if w.end, err = parseTimeOfDay(end); err != nil {
+		return nil, fmt.Errorf("invalid maintenance window %q: %w", s, err)
+	}
+	if w.start == w.end {
+		return nil, fmt.Errorf("maintenance window %q is empty", s)
+	}
+	return w, nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains returns true if t is in the maintenance window.
+func (w *maintenanceWindow) contains(t time.Time) bool {
+	t = t.UTC()
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	sinceMidnight := t.Sub(midnight)
+	startsOn := func(day time.Weekday) bool { return len(w.days) == 0 || w.days[day] }
+	if w.start < w.end {
+		return startsOn(t.Weekday()) && sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+	// The window crosses midnight, it either started today or the day before.
+	return (startsOn(t.Weekday()) && sinceMidnight >= w.start) || (startsOn(midnight.AddDate(0, 0, -1).Weekday()) && sinceMidnight < w.end)
+}
+
+// defragSettings are the defragmentation settings of a pool, from the parameters of its StorageClass.
+type defragSettings struct {
+	execute bool
+	window  *maintenanceWindow
+}
+
+func parseDefragParams(params map[string]string) (*defragSettings, error) {
+	settings := &defragSettings{}
+	switch mode := strings.ToLower(params[paramDefragMode]); mode {
+	case "", defragModePlan:
+	case defragModeExecute:
+		settings.execute = true
+	default:
+		return nil, fmt.Errorf("invalid %s %q, must be %q or %q", paramDefragMode, params[paramDefragMode], defragModePlan, defragModeExecute)
+	}
+	if v, ok := params[paramDefragMaintenanceWindow]; ok {
+		window, err := parseMaintenanceWindow(v)
+		if err != nil {
+			return nil, err
+		}
+		settings.window = window
+	}
+	if settings.execute && settings.window == nil {
+		return nil, fmt.Errorf("%s %q requires %s", paramDefragMode, defragModeExecute, paramDefragMaintenanceWindow)
+	}
+	return settings, nil
+}
+
+// defragInstance is an instance of a pool, with the shares the planner may move.
+type defragInstance struct {
+	// uri is the URI of the instance.
+	uri           string
+	capacityBytes int64
+	stepSizeGb    int64
+	maxShares     int
+	usedBytes     int64
+	// shares are the shares of the instance, sorted by capacity, the largest first.
+	shares []defragShare
+	// drainable is false if some shares of the instance can't be moved.
+	drainable bool
+}
+
+type defragShare struct {
+	name          string
+	capacityBytes int64
+}
+
+// defragPlan is a consolidation plan of a pool. moves maps the URIs of the instances the plan frees to
+// the moves of their shares.
+type defragPlan struct {
+	moves            map[string][]v1.ShareMove
+	reclaimableBytes int64
+}
+
+// planDefragmentation computes a consolidation plan of the instances of a pool. The least used instances
+// are freed first, as long as their shares can be moved to the other instances, growing them within the
+// maximum size of an instance, and the capacity freed exceeds the growth. The instances shares are moved
+// to are not freed.
+func planDefragmentation(instances []*defragInstance) *defragPlan {
+	plan := &defragPlan{moves: make(map[string][]v1.ShareMove)}
+	used := make(map[string]int64, len(instances))
+	count := make(map[string]int, len(instances))
+	receiving := make(map[string]bool)
+	for _, instance := range instances {
+		used[instance.uri] = instance.usedBytes
+		count[instance.uri] = len(instance.shares)
+	}
+	growth := func(instance *defragInstance, usedBytes int64) int64 {
+		return util.Max(multishareInstanceCapacity(usedBytes, instance.stepSizeGb)-instance.capacityBytes, 0)
+	}
+
+	sources := append([]*defragInstance{}, instances...)
+	sort.SliceStable(sources, func(i, j int) bool {
+		if sources[i].usedBytes != sources[j].usedBytes {
+			return sources[i].usedBytes < sources[j].usedBytes
+		}
+		return sources[i].uri < sources[j].uri
+	})
+	for _, source := range sources {
+		if !source.drainable || len(source.shares) == 0 || receiving[source.uri] {
+			continue
+		}
+		tentativeUsed := make(map[string]int64)
+		tentativeCount := make(map[string]int)
+		var moves []v1.ShareMove
+		var grown int64
+		placed := true
+		for _, share := range source.shares {
+			var best *defragInstance
+			var bestGrowth, bestRoom int64
+			for _, target := range instances {
+				if target.uri == source.uri || plan.moves[target.uri] != nil {
+					continue
+				}
+				targetUsed := used[target.uri] + tentativeUsed[target.uri] + share.capacityBytes
+				if count[target.uri]+tentativeCount[target.uri] >= target.maxShares || multishareInstanceCapacity(targetUsed, target.stepSizeGb) < targetUsed {
+					continue
+				}
+				// The share goes to the instance it grows the least, then the one it fills the most.
+				g := growth(target, targetUsed) - growth(target, targetUsed-share.capacityBytes)
+				room := target.capacityBytes - targetUsed
+				if best == nil || g < bestGrowth || (g == bestGrowth && room < bestRoom) {
+					best, bestGrowth, bestRoom = target, g, room
+				}
+			}
+			if best == nil {
+				placed = false
+				break
+			}
+			tentativeUsed[best.uri] += share.capacityBytes
+			tentativeCount[best.uri]++
+			grown += bestGrowth
+			moves = append(moves, v1.ShareMove{ShareInfoName: share.name, TargetInstanceHandle: best.uri})
+		}
+		if !placed || source.capacityBytes <= grown {
+			continue
+		}
+		for uri, bytes := range tentativeUsed {
+			used[uri] += bytes
+			count[uri] += tentativeCount[uri]
+			receiving[uri] = true
+		}
+		plan.moves[source.uri] = moves
+		plan.reclaimableBytes += source.capacityBytes - grown
+	}
+	return plan
+}
+
+// multishareInstanceCapacity returns the capacity of a multishare instance for shares of usedBytes, aligned
+// to the step size of the instance and bounded by the minimum and maximum sizes of an instance.
+func multishareInstanceCapacity(usedBytes, stepSizeGb int64) int64 {
+	if stepSizeGb == 0 {
+		stepSizeGb = util.DefaultStepSizeGb
+	}
+	capacityBytes := util.AlignBytes(usedBytes, util.GbToBytes(stepSizeGb))
+	capacityBytes = util.Max(capacityBytes, util.MinMultishareInstanceSizeBytes)
+	return util.Min(capacityBytes, util.MaxMultishareInstanceSizeBytes)
+}
+
+// defragmentPools computes the consolidation plans of the pools of instances once per defragmentation
+// interval, reports them in the status of their instanceInfos, and executes them in the maintenance window
+// of the pools that enable it.
+func (recon *MultishareReconciler) defragmentPools(shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, migrations []*v1.ShareMigration) {
+	if recon.defragInterval <= 0 || recon.now().Sub(recon.lastDefrag) < recon.defragInterval {
+		return
+	}
+	recon.lastDefrag = recon.now()
+
+	// The shares and instances of unfinished migrations are left out of the plans, and the plan of a pool
+	// is not executed until the migrations of its previous plan are done.
+	migrating := make(map[string]bool)
+	defragging := make(map[string]bool)
+	for _, migration := range migrations {
+		if phase := migrationPhase(migration); phase == v1.ShareMigrationCompleted || phase == v1.ShareMigrationFailed {
+			continue
+		}
+		if scTag, ok := migration.Labels[defragPoolLabel]; ok {
+			defragging[scTag] = true
+		}
+		migrating[migration.Spec.ShareInfoName] = true
+		if migration.Status != nil {
+			migrating[migration.Status.SourceInstanceHandle] = true
+			migrating[migration.Status.TargetInstanceHandle] = true
+		}
+		if migration.Spec.TargetInstanceHandle != "" {
+			migrating[migration.Spec.TargetInstanceHandle] = true
+		}
+	}
+
+	pools := make(map[string][]*defragInstance)
+	for instanceURI, instanceInfo := range instanceInfos {
+		if instanceInfo.DeletionTimestamp != nil || instanceInfo.Status == nil || instanceInfo.Status.InstanceStatus != v1.READY ||
+			instanceInfo.Status.Spare || len(instanceInfo.Status.ShareNames) == 0 || migrating[instanceURI] {
+			continue
+		}
+		_, region, _, err := util.ParseInstanceURI(instanceURI)
+		if err != nil {
+			continue
+		}
+		instance := &defragInstance{
+			uri:           instanceURI,
+			capacityBytes: instanceInfo.Spec.CapacityBytes,
+			stepSizeGb:    instanceInfo.Status.CapacityStepSizeGb,
+			maxShares:     recon.parseMaxSharePerInstance(instanceInfo.Spec.Parameters),
+			drainable:     true,
+		}
+		for _, name := range instanceInfo.Status.ShareNames {
+			shareInfo, ok := shareInfos[name]
+			if !ok {
+				instance.drainable = false
+				continue
+			}
+			instance.usedBytes += shareInfo.Spec.CapacityBytes
+			instance.shares = append(instance.shares, defragShare{name: name, capacityBytes: shareInfo.Spec.CapacityBytes})
+			if shareInfo.DeletionTimestamp != nil || shareInfo.Status == nil || shareInfo.Status.ShareStatus != v1.READY ||
+				shareInfo.Status.InstanceHandle != instanceURI || shareInfo.Spec.CapacityBytes != shareInfo.Status.CapacityBytes || migrating[name] {
+				instance.drainable = false
+			}
+		}
+		sort.SliceStable(instance.shares, func(i, j int) bool {
+			if instance.shares[i].capacityBytes != instance.shares[j].capacityBytes {
+				return instance.shares[i].capacityBytes > instance.shares[j].capacityBytes
+			}
+			return instance.shares[i].name < instance.shares[j].name
+		})
+		// The shares stay in the region of their instance.
+		pool := instanceInfo.Labels[ParamMultishareInstanceScLabel] + "/" + region
+		pools[pool] = append(pools[pool], instance)
+	}
+
+	for pool, instances := range pools {
+		scTag := pool[:strings.LastIndex(pool, "/")]
+		plan := planDefragmentation(instances)
+		klog.Infof("Consolidation plan of pool %q frees %d of %d instances and %d bytes", pool, len(plan.moves), len(instances), plan.reclaimableBytes)
+		for _, instance := range instances {
+			recon.updateDefragPlan(instanceInfos, instance.uri, plan)
+		}
+
+		if len(plan.moves) == 0 {
+			continue
+		}
+		sc, err := recon.storageClassFromTag(scTag)
+		if err != nil {
+			klog.Errorf("Not executing the consolidation plan of pool %q: %v", pool, err)
+			continue
+		}
+		settings, err := parseDefragParams(sc.Parameters)
+		if err != nil {
+			klog.Errorf("Not executing the consolidation plan of pool %q with the invalid parameters of storage class %q: %v", pool, sc.Name, err)
+			continue
+		}
+		if !settings.execute || !settings.window.contains(recon.now()) || defragging[scTag] {
+			continue
+		}
+		if !recon.defragExecute {
+			klog.Warningf("Not executing the consolidation plan of pool %q, the execution of the plans is disabled on the controller", pool)
+			continue
+		}
+		if recon.migrationLister == nil {
+			klog.Warningf("Not executing the consolidation plan of pool %q, the share migration feature is disabled", pool)
+			continue
+		}
+		recon.executeDefragPlan(scTag, plan)
+	}
+}
+
+// executeDefragPlan creates the share migrations freeing the first instance of a consolidation plan. The
+// other instances are freed by the next plans, once the migrations are done.
+func (recon *MultishareReconciler) executeDefragPlan(scTag string, plan *defragPlan) {
+	instanceURIs := make([]string, 0, len(plan.moves))
+	for uri := range plan.moves {
+		instanceURIs = append(instanceURIs, uri)
+	}
+	sort.Strings(instanceURIs)
+	instanceURI := instanceURIs[0]
+	for _, move := range plan.moves[instanceURI] {
+		migration := &v1.ShareMigration{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      fmt.Sprintf("defrag-%s-%s", move.ShareInfoName, string(uuid.NewUUID())[:8]),
+				Namespace: util.ManagedFilestoreCSINamespace,
+				Labels:    map[string]string{defragPoolLabel: scTag},
+			},
+			Spec: v1.ShareMigrationSpec{
+				ShareInfoName:        move.ShareInfoName,
+				TargetInstanceHandle: move.TargetInstanceHandle,
+			},
+		}
+		if _, err := recon.clientset.MultishareV1().ShareMigrations(util.ManagedFilestoreCSINamespace).Create(context.TODO(), migration, metav1.CreateOptions{}); err != nil {
+			klog.Errorf("Failed to create share migration of share %q to instance %q: %v", move.ShareInfoName, move.TargetInstanceHandle, err)
+			continue
+		}
+		klog.Infof("Created share migration %q to free instance %q", migration.Name, instanceURI)
+	}
+}
+
+// updateDefragPlan updates the consolidation plan in the status of the instanceInfo of an instance of the
+// pool, and records an event, if the plan changed. The instanceInfo is left unchanged if the update fails.
+func (recon *MultishareReconciler) updateDefragPlan(instanceInfos map[string]*v1.InstanceInfo, instanceURI string, plan *defragPlan) {
+	instanceInfo := instanceInfos[instanceURI]
+	moves, drain := plan.moves[instanceURI]
+	newPlan := &v1.DefragPlan{
+		PlanTime:          metav1.NewTime(recon.now()),
+		FreeableInstances: len(plan.moves),
+		ReclaimableBytes:  plan.reclaimableBytes,
+		Drain:             drain,
+		Moves:             moves,
+	}
+	if sameDefragPlan(instanceInfo.Status.DefragPlan, newPlan) {
+		return
+	}
+
+	instanceInfoClone := instanceInfo.DeepCopy()
+	instanceInfoClone.Status.DefragPlan = newPlan
+	updated, err := recon.updateInstanceInfoStatus(context.TODO(), instanceInfoClone)
+	if err != nil {
+		klog.Errorf("Failed to update consolidation plan of instanceInfo %q: %v", instanceInfo.Name, err)
+		return
+	}
+	instanceInfos[instanceURI] = updated
+	if newPlan.FreeableInstances == 0 {
+		return
+	}
+	if drain {
+		recon.event(updated, corev1.EventTypeNormal, eventReasonDefragPlanned, "Consolidation plan of the pool frees this instance by moving its %d shares, and %d instances and %d bytes in total", len(moves), newPlan.FreeableInstances, newPlan.ReclaimableBytes)
+	} else {
+		recon.event(updated, corev1.EventTypeNormal, eventReasonDefragPlanned, "Consolidation plan of the pool frees %d instances and %d bytes", newPlan.FreeableInstances, newPlan.ReclaimableBytes)
+	}
+}
+
+// sameDefragPlan returns true if two consolidation plans free the same instances with the same moves,
+// whenever they were computed.
+func sameDefragPlan(a, b *v1.DefragPlan) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.FreeableInstances == b.FreeableInstances && a.ReclaimableBytes == b.ReclaimableBytes && a.Drain == b.Drain &&
+		reflect.DeepEqual(a.Moves, b.Moves)
+}
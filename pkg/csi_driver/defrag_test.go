@@ -0,0 +1,481 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	storageListers "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	fsfake "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	listers "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/listers/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestParseMaintenanceWindow(t *testing.T) {
+	// 2024-05-04 is a Saturday.
+	at := func(day int, hour, min int) time.Time { return time.Date(2024, 5, day, hour, min, 0, 0, time.UTC) }
+	cases := []struct {
+		name      string
+		window    string
+		expectErr bool
+		inside    []time.Time
+		outside   []time.Time
+	}{
+		{
+			name:    "daily",
+			window:  "02:00-06:00",
+			inside:  []time.Time{at(1, 2, 0), at(4, 5, 59)},
+			outside: []time.Time{at(1, 1, 59), at(1, 6, 0), at(4, 12, 0)},
+		},
+		{
+			name:    "days",
+			window:  "Sat,sun 02:00-06:00",
+			inside:  []time.Time{at(4, 3, 0), at(5, 3, 0)},
+			outside: []time.Time{at(3, 3, 0), at(6, 3, 0), at(4, 7, 0)},
+		},
+		{
+			name:    "across midnight",
+			window:  "Sat 22:00-04:00",
+			inside:  []time.Time{at(4, 22, 0), at(4, 23, 59), at(5, 0, 0), at(5, 3, 59)},
+			outside: []time.Time{at(4, 3, 0), at(5, 22, 0), at(6, 1, 0), at(4, 21, 59)},
+		},
+		{
+			name:    "other time zone",
+			window:  "02:00-06:00",
+			inside:  []time.Time{time.Date(2024, 5, 1, 4, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))},
+			outside: []time.Time{time.Date(2024, 5, 1, 9, 0, 0, 0, time.FixedZone("UTC+2", 2*60*60))},
+		},
+		{
+			name:      "empty",
+			window:    "",
+			expectErr: true,
+		},
+		{
+			name:      "invalid day",
+			window:    "Someday 02:00-06:00",
+			expectErr: true,
+		},
+		{
+			name:      "invalid hours",
+			window:    "02:00",
+			expectErr: true,
+		},
+		{
+			name:      "invalid time",
+			window:    "25:00-06:00",
+			expectErr: true,
+		},
+		{
+			name:      "empty window",
+			window:    "02:00-02:00",
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			w, err := parseMaintenanceWindow(tc.window)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %t", err, tc.expectErr)
+			}
+			for _, inside := range tc.inside {
+				if !w.contains(inside) {
+					t.Errorf("window %q doesn't contain %v", tc.window, inside)
+				}
+			}
+			for _, outside := range tc.outside {
+				if w.contains(outside) {
+					t.Errorf("window %q contains %v", tc.window, outside)
+				}
+			}
+		})
+	}
+}
+
+func TestParseDefragParams(t *testing.T) {
+	cases := []struct {
+		name            string
+		params          map[string]string
+		expectedExecute bool
+		expectErr       bool
+	}{
+		{
+			name:   "default",
+			params: map[string]string{},
+		},
+		{
+			name:   "plan with window",
+			params: map[string]string{paramDefragMode: "plan", paramDefragMaintenanceWindow: "02:00-06:00"},
+		},
+		{
+			name:            "execute",
+			params:          map[string]string{paramDefragMode: "Execute", paramDefragMaintenanceWindow: "02:00-06:00"},
+			expectedExecute: true,
+		},
+		{
+			name:      "execute without window",
+			params:    map[string]string{paramDefragMode: "execute"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid mode",
+			params:    map[string]string{paramDefragMode: "always"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid window",
+			params:    map[string]string{paramDefragMaintenanceWindow: "night"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			settings, err := parseDefragParams(tc.params)
+			if (err != nil) != tc.expectErr {
+				t.Fatalf("got error %v, expected error %t", err, tc.expectErr)
+			}
+			if err == nil && settings.execute != tc.expectedExecute {
+				t.Errorf("got execute %t, expected %t", settings.execute, tc.expectedExecute)
+			}
+		})
+	}
+}
+
+func TestPlanDefragmentation(t *testing.T) {
+	instance := func(name string, capacityBytes int64, shares ...int64) *defragInstance {
+		i := &defragInstance{uri: name, capacityBytes: capacityBytes, maxShares: util.MaxSharesPerInstance, drainable: true}
+		for n, bytes := range shares {
+			i.usedBytes += bytes
+			i.shares = append(i.shares, defragShare{name: name + "-share-" + string(rune('a'+n)), capacityBytes: bytes})
+		}
+		return i
+	}
+	cases := []struct {
+		name      string
+		instances []*defragInstance
+		// expectedMoves maps the freed instances to the targets of their shares.
+		expectedMoves   map[string][]string
+		expectedReclaim int64
+	}{
+		{
+			name: "least used instance freed",
+			instances: []*defragInstance{
+				instance("a", util.Tb, 400*util.Gb),
+				instance("b", util.Tb, 300*util.Gb, 100*util.Gb),
+			},
+			expectedMoves:   map[string][]string{"a": {"b"}},
+			expectedReclaim: util.Tb,
+		},
+		{
+			name: "growth exceeds freed capacity",
+			instances: []*defragInstance{
+				instance("a", util.Tb, 600*util.Gb),
+				instance("b", util.Tb, 600*util.Gb),
+			},
+			expectedMoves: map[string][]string{},
+		},
+		{
+			name: "growth by small steps",
+			instances: []*defragInstance{
+				func() *defragInstance { i := instance("a", util.Tb, 600*util.Gb); i.stepSizeGb = 256; return i }(),
+				func() *defragInstance { i := instance("b", util.Tb, 600*util.Gb); i.stepSizeGb = 256; return i }(),
+			},
+			expectedMoves:   map[string][]string{"a": {"b"}},
+			expectedReclaim: util.Tb - 256*util.Gb,
+		},
+		{
+			name: "shares spread over several instances",
+			instances: []*defragInstance{
+				instance("a", util.Tb, 300*util.Gb, 200*util.Gb),
+				instance("b", util.Tb, 800*util.Gb),
+				instance("c", util.Tb, 700*util.Gb),
+			},
+			expectedMoves:   map[string][]string{"a": {"c", "b"}},
+			expectedReclaim: util.Tb,
+		},
+		{
+			name: "targets are not freed",
+			instances: []*defragInstance{
+				instance("a", util.Tb, 100*util.Gb),
+				instance("b", util.Tb, 200*util.Gb),
+				instance("c", util.Tb, 900*util.Gb),
+			},
+			expectedMoves:   map[string][]string{"a": {"c"}},
+			expectedReclaim: util.Tb,
+		},
+		{
+			name: "undrainable instance receives shares",
+			instances: []*defragInstance{
+				instance("a", util.Tb, 100*util.Gb),
+				func() *defragInstance { i := instance("b", util.Tb, 200*util.Gb); i.drainable = false; return i }(),
+			},
+			expectedMoves:   map[string][]string{"a": {"b"}},
+			expectedReclaim: util.Tb,
+		},
+		{
+			name: "max shares per instance",
+			instances: []*defragInstance{
+				instance("a", util.Tb, 100*util.Gb),
+				func() *defragInstance {
+					i := instance("b", util.Tb, 200*util.Gb, 200*util.Gb)
+					i.maxShares, i.drainable = 2, false
+					return i
+				}(),
+			},
+			expectedMoves: map[string][]string{},
+		},
+		{
+			name: "max instance size",
+			instances: []*defragInstance{
+				instance("a", util.Tb, 600*util.Gb),
+				instance("b", util.MaxMultishareInstanceSizeBytes, util.MaxMultishareInstanceSizeBytes-500*util.Gb),
+			},
+			expectedMoves: map[string][]string{},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			plan := planDefragmentation(tc.instances)
+			got := map[string][]string{}
+			for uri, moves := range plan.moves {
+				for _, move := range moves {
+					got[uri] = append(got[uri], move.TargetInstanceHandle)
+				}
+			}
+			if !reflect.DeepEqual(got, tc.expectedMoves) {
+				t.Errorf("got moves %v, expected %v", got, tc.expectedMoves)
+			}
+			if plan.reclaimableBytes != tc.expectedReclaim {
+				t.Errorf("got %d reclaimable bytes, expected %d", plan.reclaimableBytes, tc.expectedReclaim)
+			}
+		})
+	}
+}
+
+func TestDefragmentPools(t *testing.T) {
+	// 2024-05-04 is a Saturday.
+	inWindow := time.Date(2024, 5, 4, 3, 0, 0, 0, time.UTC)
+	outOfWindow := time.Date(2024, 5, 4, 12, 0, 0, 0, time.UTC)
+	sharedInstance := instanceURI(testProject, testRegion, "shared")
+	cases := []struct {
+		name               string
+		params             map[string]string
+		now                time.Time
+		migrationsDisabled bool
+		executeDisabled    bool
+		migrations         []*v1.ShareMigration
+		expectedMigrations int
+	}{
+		{
+			name:   "plan only",
+			params: map[string]string{},
+			now:    inWindow,
+		},
+		{
+			name:               "executed in the maintenance window",
+			params:             map[string]string{paramDefragMode: defragModeExecute, paramDefragMaintenanceWindow: "Sat 02:00-06:00"},
+			now:                inWindow,
+			expectedMigrations: 2,
+		},
+		{
+			name:   "not executed out of the maintenance window",
+			params: map[string]string{paramDefragMode: defragModeExecute, paramDefragMaintenanceWindow: "Sat 02:00-06:00"},
+			now:    outOfWindow,
+		},
+		{
+			name:               "not executed without share migrations",
+			params:             map[string]string{paramDefragMode: defragModeExecute, paramDefragMaintenanceWindow: "Sat 02:00-06:00"},
+			now:                inWindow,
+			migrationsDisabled: true,
+		},
+		{
+			name:            "not executed when disabled on the controller",
+			params:          map[string]string{paramDefragMode: defragModeExecute, paramDefragMaintenanceWindow: "Sat 02:00-06:00"},
+			now:             inWindow,
+			executeDisabled: true,
+		},
+		{
+			name:   "not executed during the previous plan",
+			params: map[string]string{paramDefragMode: defragModeExecute, paramDefragMaintenanceWindow: "Sat 02:00-06:00"},
+			now:    inWindow,
+			migrations: []*v1.ShareMigration{{
+				ObjectMeta: metav1.ObjectMeta{Name: "defrag-other", Namespace: util.ManagedFilestoreCSINamespace, Labels: map[string]string{defragPoolLabel: testInstanceScPrefix}},
+				Spec:       v1.ShareMigrationSpec{ShareInfoName: "other"},
+			}},
+			expectedMigrations: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			shareInfo := func(name, instance string, capacityBytes int64) *v1.ShareInfo {
+				return &v1.ShareInfo{
+					ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: util.ManagedFilestoreCSINamespace},
+					Spec:       v1.ShareInfoSpec{ShareName: name, CapacityBytes: capacityBytes, Region: testRegion, InstancePoolTag: testInstanceScPrefix},
+					Status: &v1.ShareInfoStatus{
+						InstanceHandle: instanceURI(testProject, testRegion, instance),
+						CapacityBytes:  capacityBytes,
+						ShareStatus:    v1.READY,
+					},
+				}
+			}
+			instanceInfo := func(name string, shares ...string) *v1.InstanceInfo {
+				return &v1.InstanceInfo{
+					ObjectMeta: metav1.ObjectMeta{
+						Name:      util.InstanceURIToInstanceInfoName(instanceURI(testProject, testRegion, name)),
+						Namespace: util.ManagedFilestoreCSINamespace,
+						Labels:    map[string]string{ParamMultishareInstanceScLabel: testInstanceScPrefix},
+					},
+					Spec:   v1.InstanceInfoSpec{CapacityBytes: util.MinMultishareInstanceSizeBytes},
+					Status: &v1.InstanceInfoStatus{ShareNames: shares, InstanceStatus: v1.READY, CapacityBytes: util.MinMultishareInstanceSizeBytes},
+				}
+			}
+			shareInfos := []*v1.ShareInfo{
+				shareInfo("pvc-1", "drained", 100*util.Gb),
+				shareInfo("pvc-2", "drained", 100*util.Gb),
+				shareInfo("pvc-3", "shared", 500*util.Gb),
+			}
+			instanceInfos := []*v1.InstanceInfo{
+				instanceInfo("drained", "pvc-1", "pvc-2"),
+				instanceInfo("shared", "pvc-3"),
+			}
+			var objects []runtime.Object
+			shareInfoMap := map[string]*v1.ShareInfo{}
+			for _, si := range shareInfos {
+				objects = append(objects, si)
+				shareInfoMap[si.Name] = si
+			}
+			instanceInfoMap := map[string]*v1.InstanceInfo{}
+			for _, ii := range instanceInfos {
+				objects = append(objects, ii)
+				instanceInfoMap[util.InstanceInfoNameToInstanceURI(ii.Name)] = ii
+			}
+			for _, migration := range tc.migrations {
+				objects = append(objects, migration)
+			}
+			clientset := fsfake.NewSimpleClientset(objects...)
+
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			sc := &storagev1.StorageClass{
+				ObjectMeta:  metav1.ObjectMeta{Name: "sc"},
+				Provisioner: "test-driver",
+				Parameters:  map[string]string{paramMultishare: "true", ParamMultishareInstanceScLabel: testInstanceScPrefix},
+			}
+			for k, v := range tc.params {
+				sc.Parameters[k] = v
+			}
+			indexer.Add(sc)
+			config := &controllerServerConfig{driver: initTestDriver(t)}
+			config.multiShareController = NewMultishareController(config)
+			recorder := record.NewFakeRecorder(10)
+			now := tc.now
+			recon := &MultishareReconciler{
+				clientset:        clientset,
+				config:           &GCFSDriverConfig{Name: "test-driver"},
+				controllerServer: &controllerServer{config: config},
+				scLister:         storageListers.NewStorageClassLister(indexer),
+				defragInterval:   time.Hour,
+				defragExecute:    !tc.executeDisabled,
+				recorder:         recorder,
+				now:              func() time.Time { return now },
+			}
+			if !tc.migrationsDisabled {
+				recon.migrationLister = listers.NewShareMigrationLister(cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{}))
+			}
+
+			recon.defragmentPools(shareInfoMap, instanceInfoMap, tc.migrations)
+			// The next plan waits for the defragmentation interval.
+			recon.defragmentPools(shareInfoMap, instanceInfoMap, tc.migrations)
+
+			drained, err := clientset.MultishareV1().InstanceInfos(util.ManagedFilestoreCSINamespace).Get(context.TODO(), instanceInfos[0].Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Failed to get instanceInfo: %v", err)
+			}
+			expectedPlan := &v1.DefragPlan{
+				PlanTime:          metav1.NewTime(tc.now),
+				FreeableInstances: 1,
+				ReclaimableBytes:  util.MinMultishareInstanceSizeBytes,
+				Drain:             true,
+				Moves: []v1.ShareMove{
+					{ShareInfoName: "pvc-1", TargetInstanceHandle: sharedInstance},
+					{ShareInfoName: "pvc-2", TargetInstanceHandle: sharedInstance},
+				},
+			}
+			if plan := drained.Status.DefragPlan; plan == nil || !plan.PlanTime.Equal(&expectedPlan.PlanTime) || plan.FreeableInstances != expectedPlan.FreeableInstances ||
+				plan.ReclaimableBytes != expectedPlan.ReclaimableBytes || plan.Drain != expectedPlan.Drain || !reflect.DeepEqual(plan.Moves, expectedPlan.Moves) {
+				t.Errorf("got plan %+v of the drained instance, expected %+v", plan, expectedPlan)
+			}
+			shared, err := clientset.MultishareV1().InstanceInfos(util.ManagedFilestoreCSINamespace).Get(context.TODO(), instanceInfos[1].Name, metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("Failed to get instanceInfo: %v", err)
+			}
+			if plan := shared.Status.DefragPlan; plan == nil || plan.Drain || plan.FreeableInstances != 1 || len(plan.Moves) != 0 {
+				t.Errorf("got plan %+v of the target instance, expected a plan freeing 1 other instance", plan)
+			}
+			if len(recorder.Events) != 2 {
+				t.Errorf("got %d events, expected one for each instanceInfo", len(recorder.Events))
+			}
+
+			// The unchanged plan of a later defragmentation interval, out of the maintenance window, doesn't
+			// update the instanceInfos.
+			updates := func() int {
+				n := 0
+				for _, action := range clientset.Actions() {
+					if action.GetVerb() == "update" && action.GetResource().Resource == "instanceinfos" {
+						n++
+					}
+				}
+				return n
+			}
+			before := updates()
+			now = now.Add(12 * time.Hour)
+			recon.defragmentPools(shareInfoMap, instanceInfoMap, tc.migrations)
+			if after := updates(); after != before {
+				t.Errorf("got %d updates of the instanceInfos with the unchanged plan, expected none", after-before)
+			}
+			if plan := instanceInfoMap[drained.Status.DefragPlan.Moves[0].TargetInstanceHandle].Status.DefragPlan; !plan.PlanTime.Equal(&expectedPlan.PlanTime) {
+				t.Errorf("got plan time %v, expected the time of the first plan %v", plan.PlanTime, expectedPlan.PlanTime)
+			}
+			if len(recorder.Events) != 2 {
+				t.Errorf("got %d events, expected none for the unchanged plan", len(recorder.Events)-2)
+			}
+
+			migrations, err := clientset.MultishareV1().ShareMigrations(util.ManagedFilestoreCSINamespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("Failed to list share migrations: %v", err)
+			}
+			if len(migrations.Items) != tc.expectedMigrations {
+				t.Fatalf("got %d share migrations, expected %d", len(migrations.Items), tc.expectedMigrations)
+			}
+			for _, migration := range migrations.Items {
+				if migration.Labels[defragPoolLabel] != testInstanceScPrefix {
+					t.Errorf("share migration %q has labels %v", migration.Name, migration.Labels)
+				}
+				if len(tc.migrations) == 0 && migration.Spec.TargetInstanceHandle != sharedInstance {
+					t.Errorf("share migration %q has target %q, expected %q", migration.Name, migration.Spec.TargetInstanceHandle, sharedInstance)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,111 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// volumeContextKeyEphemeral is set to true by the kubelet in the volume context of the CSI ephemeral
+// inline volumes of pods.
+const volumeContextKeyEphemeral = "csi.storage.k8s.io/ephemeral"
+
+func isEphemeralVolume(volumeContext map[string]string) bool {
+	return volumeContext[volumeContextKeyEphemeral] == "true"
+}
+
+// nodePublishEphemeralVolume mounts an ephemeral inline volume, the existing share of an instance named by
+// the volume attributes of the pod, at its publish path. Ephemeral volumes are not staged, and their
+// volume IDs are generated by the kubelet. The mount is removed by NodeUnpublishVolume.
+func (s *nodeServer) nodePublishEphemeralVolume(req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	targetPath := req.GetTargetPath()
+	if s.features.FeatureEphemeralVolumes == nil || !s.features.FeatureEphemeralVolumes.Enabled {
+		return nil, status.Errorf(codes.FailedPrecondition, "ephemeral inline volumes are not enabled on node %s", s.driver.config.NodeName)
+	}
+	if goOs == "windows" {
+		return nil, status.Error(codes.InvalidArgument, "ephemeral inline volumes are not supported on Windows")
+	}
+	if err := s.driver.validateVolumeCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	attr := req.GetVolumeContext()
+	if err := validateVolumeAttributes(attr); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, key := range []string{attrEncryptionInTransit, attrSecurityFlavor} {
+		if _, ok := attr[key]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "volume attribute %s is not supported for ephemeral inline volumes", key)
+		}
+	}
+	mountGroup, err := parseVolumeMountGroup(req.GetVolumeCapability())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.GetReadonly() || isReadOnlyAccessMode(req.GetVolumeCapability()) {
+		mountGroup = -1
+	}
+	options, err := s.mountPolicy.apply(stageMountOptions(req.GetVolumeCapability()), attr, attr[attrFileProtocol], req.GetVolumeCapability())
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mount options of volume %v: %v", volumeID, err)
+	}
+	if req.GetReadonly() {
+		options = append(options, "ro")
+	}
+
+	if acquired := s.volumeLocks.TryAcquire(targetPath); !acquired {
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, targetPath)
+	}
+	defer s.volumeLocks.Release(targetPath)
+
+	mounted, err := s.isDirMounted(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !mounted {
+		if os.IsNotExist(err) {
+			if mkdirErr := os.MkdirAll(targetPath, 0750); mkdirErr != nil {
+				return nil, status.Errorf(codes.Internal, "mkdir failed on path %s (%v)", targetPath, mkdirErr.Error())
+			}
+		}
+		source := fmt.Sprintf("%s:/%s", attr[attrIP], attr[attrVolume])
+		if err := s.mounter.Mount(source, targetPath, "nfs", options); err != nil {
+			klog.Errorf("Mount %q failed on node %s, cleaning up", targetPath, s.driver.config.NodeName)
+			if unmntErr := mount.CleanupMountPoint(targetPath, s.mounter, false /* extensiveMountPointCheck */); unmntErr != nil {
+				klog.Errorf("Unmount %q failed on node %s: %v", targetPath, s.driver.config.NodeName, unmntErr.Error())
+			}
+			return nil, status.Errorf(codes.Internal, "mount %q failed on node %s: %v", targetPath, s.driver.config.NodeName, err.Error())
+		}
+	}
+
+	if mountGroup >= 0 {
+		if err := setVolumeMountGroup(targetPath, mountGroup); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to set the group of volume %v to %d: %v", volumeID, mountGroup, err)
+		}
+	}
+	s.stagedVolumes.stage(volumeID, targetPath)
+	klog.V(4).Infof("NodePublishVolume succeeded on ephemeral volume %v to path %s on node %s", volumeID, targetPath, s.driver.config.NodeName)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
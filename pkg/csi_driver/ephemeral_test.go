@@ -0,0 +1,181 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
+)
+
+const testEphemeralVolumeID = "csi-4d2a6b8f0c1e3a5b7d9f"
+
+func initTestEphemeralNodeServer(t *testing.T, enabled bool) (*nodeServer, *mount.FakeMounter) {
+	mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{}}
+	metaService, err := metadata.NewFakeService()
+	if err != nil {
+		t.Fatalf("Failed to init metadata service")
+	}
+	ns, err := newNodeServer(initTestDriver(t), mounter, metaService, &GCFSDriverFeatureOptions{
+		FeatureLockRelease:      &FeatureLockRelease{},
+		FeatureEphemeralVolumes: &FeatureEphemeralVolumes{Enabled: enabled},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create node server: %v", err)
+	}
+	server := ns.(*nodeServer)
+	server.stagedVolumes = newStagedVolumes()
+	return server, mounter
+}
+
+func ephemeralVolumeContext(attributes map[string]string) map[string]string {
+	volumeContext := map[string]string{
+		volumeContextKeyEphemeral:          "true",
+		"csi.storage.k8s.io/pod.name":      "batch-job",
+		"csi.storage.k8s.io/pod.uid":       "f0a8c2d4-2b6e-4b0e-9d2f-1c3a5e7b9d1f",
+		"csi.storage.k8s.io/pod.namespace": "default",
+	}
+	for k, v := range attributes {
+		volumeContext[k] = v
+	}
+	return volumeContext
+}
+
+func TestNodePublishEphemeralVolume(t *testing.T) {
+	ns, mounter := initTestEphemeralNodeServer(t, true)
+	targetPath := filepath.Join(t.TempDir(), "target")
+
+	// Ephemeral volumes are mounted at their publish path without a staging path.
+	req := &csi.NodePublishVolumeRequest{
+		VolumeId:         testEphemeralVolumeID,
+		TargetPath:       targetPath,
+		VolumeCapability: testVolumeCapability,
+		VolumeContext:    ephemeralVolumeContext(testVolumeAttributes),
+	}
+	if _, err := ns.NodePublishVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	validateMountPoint(t, "ephemeral volume", mounter, &mount.MountPoint{
+		Device: testDevice,
+		Path:   targetPath,
+		Type:   "nfs",
+	})
+	if got := ns.stagedVolumes.volumes()[targetPath]; got != testEphemeralVolumeID {
+		t.Errorf("got volume %q mounted at %s, expected %q", got, targetPath, testEphemeralVolumeID)
+	}
+
+	// Publishing the volume again is a no-op.
+	if _, err := ns.NodePublishVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodePublishVolume failed again: %v", err)
+	}
+	validateMountPoint(t, "ephemeral volume published again", mounter, &mount.MountPoint{
+		Device: testDevice,
+		Path:   targetPath,
+		Type:   "nfs",
+	})
+
+	if _, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: testEphemeralVolumeID, TargetPath: targetPath}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+	validateMountPoint(t, "unpublished ephemeral volume", mounter, nil)
+	if _, err := os.Stat(targetPath); !os.IsNotExist(err) {
+		t.Errorf("publish path of unpublished ephemeral volume was not removed: %v", err)
+	}
+	if _, ok := ns.stagedVolumes.volumes()[targetPath]; ok {
+		t.Errorf("unpublished ephemeral volume is still reported")
+	}
+
+	// A read-only ephemeral volume is mounted read-only.
+	req.Readonly = true
+	if _, err := ns.NodePublishVolume(context.Background(), req); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	validateMountPoint(t, "read-only ephemeral volume", mounter, &mount.MountPoint{
+		Device: testDevice,
+		Path:   targetPath,
+		Type:   "nfs",
+		Opts:   []string{"ro"},
+	})
+}
+
+func TestNodePublishEphemeralVolumeErrors(t *testing.T) {
+	cases := []struct {
+		name       string
+		disabled   bool
+		attributes map[string]string
+		expected   codes.Code
+	}{
+		{
+			name:     "feature disabled",
+			disabled: true,
+			attributes: map[string]string{
+				attrIP:     "1.1.1.1",
+				attrVolume: "test-volume",
+			},
+			expected: codes.FailedPrecondition,
+		},
+		{
+			name:       "missing ip",
+			attributes: map[string]string{attrVolume: "test-volume"},
+			expected:   codes.InvalidArgument,
+		},
+		{
+			name: "invalid ip",
+			attributes: map[string]string{
+				attrIP:     "filestore.example.com",
+				attrVolume: "test-volume",
+			},
+			expected: codes.InvalidArgument,
+		},
+		{
+			name:       "missing share",
+			attributes: map[string]string{attrIP: "1.1.1.1"},
+			expected:   codes.InvalidArgument,
+		},
+		{
+			name: "encryption in transit",
+			attributes: map[string]string{
+				attrIP:                  "1.1.1.1",
+				attrVolume:              "test-volume",
+				attrEncryptionInTransit: "true",
+			},
+			expected: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ns, mounter := initTestEphemeralNodeServer(t, !tc.disabled)
+			_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+				VolumeId:         testEphemeralVolumeID,
+				TargetPath:       filepath.Join(t.TempDir(), "target"),
+				VolumeCapability: testVolumeCapability,
+				VolumeContext:    ephemeralVolumeContext(tc.attributes),
+			})
+			if status.Code(err) != tc.expected {
+				t.Errorf("got error %v, expected code %v", err, tc.expected)
+			}
+			validateMountPoint(t, tc.name, mounter, nil)
+		})
+	}
+}
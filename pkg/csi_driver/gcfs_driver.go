@@ -28,18 +28,22 @@ import (
 	"github.com/kubernetes-csi/csi-lib-utils/leaderelection"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
+	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	mount "k8s.io/mount-utils"
 	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
 	sharescheme "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/scheme"
 	fsInformers "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions"
+	multishareInformers "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/informers/externalversions/multishare/v1"
 	listers "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/listers/multishare/v1"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
 	metadataservice "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
@@ -82,6 +86,7 @@ type GCFSDriver struct {
 	ids csi.IdentityServer
 	ns  csi.NodeServer
 	cs  csi.ControllerServer
+	gcs csi.GroupControllerServer
 
 	// Stateful CSI driver
 	recon         *MultishareReconciler
@@ -89,10 +94,16 @@ type GCFSDriver struct {
 	coreFactory   informers.SharedInformerFactory
 	driverFactory fsInformers.SharedInformerFactory
 
+	// Backup schedules
+	backupScheduleController  *BackupScheduleController
+	backupScheduleFactory     fsInformers.SharedInformerFactory
+	backupScheduleCoreFactory informers.SharedInformerFactory
+
 	// Plugin capabilities
-	vcap  map[csi.VolumeCapability_AccessMode_Mode]*csi.VolumeCapability_AccessMode
-	cscap []*csi.ControllerServiceCapability
-	nscap []*csi.NodeServiceCapability
+	vcap   map[csi.VolumeCapability_AccessMode_Mode]*csi.VolumeCapability_AccessMode
+	cscap  []*csi.ControllerServiceCapability
+	gcscap []*csi.GroupControllerServiceCapability
+	nscap  []*csi.NodeServiceCapability
 }
 
 type GCFSDriverFeatureOptions struct {
@@ -104,6 +115,143 @@ type GCFSDriverFeatureOptions struct {
 	FeatureMultishareBackups        *FeatureMultishareBackups
 	FeatureNFSExportOptionsOnCreate *FeatureNFSExportOptionsOnCreate
 	FeatureNFSv4Support             *FeatureNFSv4Support
+	// FeatureBackupSchedule will run the controller of the BackupSchedule objects if sets to true.
+	FeatureBackupSchedule *FeatureBackupSchedule
+	// FeaturePreflightValidation will validate the network, reserved IP range and KMS key of new instances if sets to true.
+	FeaturePreflightValidation *FeaturePreflightValidation
+	// FeatureMountHealthMonitor will probe the mounts of the staged volumes on the node if sets to true.
+	FeatureMountHealthMonitor *FeatureMountHealthMonitor
+	// FeatureNFSMountStatsMetrics will export the NFS client statistics of the staged volumes on the node if sets to true.
+	FeatureNFSMountStatsMetrics *FeatureNFSMountStatsMetrics
+	// FeatureSubDirectoryProvisioning will provision volumes as sub-directories of basic instances if sets to true.
+	FeatureSubDirectoryProvisioning *FeatureSubDirectoryProvisioning
+	// FeatureEncryptionInTransit will mount the volumes encrypted in transit through TLS tunnels on the node if sets to true.
+	FeatureEncryptionInTransit *FeatureEncryptionInTransit
+	// FeatureVolumeMountGroup will set the group of the volumes to the fsGroup of the pods on the node if sets to true.
+	FeatureVolumeMountGroup *FeatureVolumeMountGroup
+	// FeatureEphemeralVolumes will mount the ephemeral inline volumes of the pods on the node if sets to true.
+	FeatureEphemeralVolumes *FeatureEphemeralVolumes
+	// FeatureVolumeTopology will report the region of the nodes and constrain the volumes to the zone or region of their instance if sets to true.
+	FeatureVolumeTopology *FeatureVolumeTopology
+	// FeatureMountPolicy will validate the mount options of the volumes and add the default ones on the node if sets to true.
+	FeatureMountPolicy *FeatureMountPolicy
+	// FeatureKerberos will mount the volumes with Kerberos security flavors with the keytabs of their secrets if sets to true.
+	FeatureKerberos *FeatureKerberos
+	// FeatureShareMigration will move the shares of multishare instances to the instances of the ShareMigration objects if sets to true.
+	FeatureShareMigration *FeatureShareMigration
+	// FeatureMultishareDefrag will plan the consolidation of the instance pools of the stateful multishare controller if sets to true.
+	FeatureMultishareDefrag *FeatureMultishareDefrag
+}
+
+type FeatureShareMigration struct {
+	Enabled bool
+	// RelocateInterval is the interval the node checks whether the shares of its staged multishare volumes
+	// have been migrated to another instance.
+	RelocateInterval time.Duration
+}
+
+type FeatureMultishareDefrag struct {
+	Enabled bool
+	// Interval is the interval the consolidation plans of the instance pools are computed.
+	Interval time.Duration
+	// ExecutePlans executes the consolidation plans of the pools with the execute mode. The plans are
+	// only reported if it is false.
+	ExecutePlans bool
+}
+
+type FeatureKerberos struct {
+	Enabled bool
+	// KinitPath is the path of the kinit binary obtaining the tickets of the volumes.
+	KinitPath string
+	// StateDir is the directory the keytabs of the staged volumes are saved in, to refresh their tickets
+	// after the driver restarts.
+	StateDir string
+	// CredentialCacheDir is the directory rpc.gssd looks up the credential caches of the volumes in.
+	CredentialCacheDir string
+	// RefreshInterval is the interval the tickets of the volumes are obtained again.
+	RefreshInterval time.Duration
+}
+
+type FeatureEncryptionInTransit struct {
+	Enabled bool
+	// StunnelPath is the path of the stunnel binary running the tunnels.
+	StunnelPath string
+	// StateDir is the directory the tunnels are saved in, to restore them when the driver restarts.
+	StateDir string
+	// CAFile is the CA bundle the certificates of the NFS servers are verified with. The volumes encrypted
+	// in transit are not staged without it, unless InsecureSkipVerify is set.
+	CAFile string
+	// ServerName is the name the certificates of the NFS servers are checked against, instead of their IP.
+	ServerName string
+	// InsecureSkipVerify starts the tunnels without verifying the certificates of the NFS servers when
+	// CAFile is empty.
+	InsecureSkipVerify bool
+	// ServerPort is the port of the TLS endpoint of the NFS servers.
+	ServerPort int
+	// ProbeInterval is the interval the tunnels are probed.
+	ProbeInterval time.Duration
+}
+
+type FeatureSubDirectoryProvisioning struct {
+	Enabled bool
+	// WorkingDir is the directory the controller mounts the shares of the instances under to manage their
+	// sub-directories.
+	WorkingDir string
+}
+
+type FeatureMountPolicy struct {
+	Enabled bool
+	// ConfigFile is the JSON file of the default mount options by tier and protocol, and of the denied
+	// mount options. Only the built-in policy is applied if empty.
+	ConfigFile string
+}
+
+type FeatureVolumeTopology struct {
+	Enabled bool
+}
+
+type FeatureEphemeralVolumes struct {
+	Enabled bool
+}
+
+type FeatureVolumeMountGroup struct {
+	Enabled bool
+}
+
+type FeatureNFSMountStatsMetrics struct {
+	Enabled bool
+}
+
+type FeatureMountHealthMonitor struct {
+	Enabled bool
+	// ProbeInterval is the interval the staging paths are probed.
+	ProbeInterval time.Duration
+	// ProbeTimeout is how long a probe waits for a staging path to respond.
+	ProbeTimeout time.Duration
+	// Remount will lazily unmount and mount again the staging path of an unhealthy volume if sets to true.
+	Remount bool
+}
+
+type FeatureBackupSchedule struct {
+	Enabled      bool
+	KubeAPIQPS   float64
+	KubeAPIBurst int
+	KubeConfig   string
+	ResyncPeriod time.Duration
+	// SyncPeriod is the interval the schedules are checked for backups to take.
+	SyncPeriod time.Duration
+
+	LeaderElection              bool
+	LeaderElectionNamespace     string
+	LeaderElectionLeaseDuration time.Duration
+	LeaderElectionRenewDeadline time.Duration
+	LeaderElectionRetryPeriod   time.Duration
+}
+
+type FeaturePreflightValidation struct {
+	Enabled bool
+	// CacheTTL is how long the result of the lookup of a network, reserved IP range or KMS key is reused.
+	CacheTTL time.Duration
 }
 
 type FeatureMultishareBackups struct {
@@ -181,6 +329,12 @@ func NewGCFSDriver(config *GCFSDriverConfig) (*GCFSDriver, error) {
 			csi.NodeServiceCapability_RPC_STAGE_UNSTAGE_VOLUME,
 			csi.NodeServiceCapability_RPC_GET_VOLUME_STATS,
 		}
+		if config.FeatureOptions.FeatureMountHealthMonitor != nil && config.FeatureOptions.FeatureMountHealthMonitor.Enabled {
+			nscap = append(nscap, csi.NodeServiceCapability_RPC_VOLUME_CONDITION)
+		}
+		if config.FeatureOptions.FeatureVolumeMountGroup != nil && config.FeatureOptions.FeatureVolumeMountGroup.Enabled {
+			nscap = append(nscap, csi.NodeServiceCapability_RPC_VOLUME_MOUNT_GROUP)
+		}
 		ns, err := newNodeServer(driver, config.Mounter, config.MetadataService, config.FeatureOptions)
 		if err != nil {
 			return nil, err
@@ -193,17 +347,28 @@ func NewGCFSDriver(config *GCFSDriverConfig) (*GCFSDriver, error) {
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_VOLUME,
 			csi.ControllerServiceCapability_RPC_EXPAND_VOLUME,
 			csi.ControllerServiceCapability_RPC_CREATE_DELETE_SNAPSHOT,
+			csi.ControllerServiceCapability_RPC_LIST_SNAPSHOTS,
+			csi.ControllerServiceCapability_RPC_CLONE_VOLUME,
+			csi.ControllerServiceCapability_RPC_LIST_VOLUMES,
+			csi.ControllerServiceCapability_RPC_GET_VOLUME,
+			csi.ControllerServiceCapability_RPC_VOLUME_CONDITION,
+			csi.ControllerServiceCapability_RPC_GET_CAPACITY,
+			csi.ControllerServiceCapability_RPC_MODIFY_VOLUME,
 		}
 		driver.addControllerServiceCapabilities(csc)
 
 		if config.FeatureOptions.FeatureStateful != nil && config.FeatureOptions.FeatureStateful.Enabled {
 			driver.recon, driver.factory, driver.coreFactory, driver.driverFactory = initMultishareReconciler(config)
 		}
+		if config.FeatureOptions.FeatureBackupSchedule != nil && config.FeatureOptions.FeatureBackupSchedule.Enabled {
+			driver.backupScheduleController, driver.backupScheduleFactory, driver.backupScheduleCoreFactory = initBackupScheduleController(config)
+		}
 		// Configure controller server
 		driver.cs = newControllerServer(&controllerServerConfig{
 			driver:            driver,
 			fileService:       config.Cloud.File,
 			cloud:             config.Cloud,
+			mounter:           config.Mounter,
 			volumeLocks:       util.NewVolumeLocks(),
 			enableMultishare:  config.EnableMultishare,
 			reconciler:        config.Reconciler,
@@ -215,6 +380,14 @@ func NewGCFSDriver(config *GCFSDriverConfig) (*GCFSDriver, error) {
 			extraVolumeLabels: config.ExtraVolumeLabels,
 			tagManager:        config.TagManager,
 		})
+
+		// Volume group snapshots are backups of the shares of a multishare instance.
+		if config.EnableMultishare && config.FeatureOptions.FeatureMultishareBackups != nil && config.FeatureOptions.FeatureMultishareBackups.Enabled {
+			driver.addGroupControllerServiceCapabilities([]csi.GroupControllerServiceCapability_RPC_Type{
+				csi.GroupControllerServiceCapability_RPC_CREATE_DELETE_GET_VOLUME_GROUP_SNAPSHOT,
+			})
+			driver.gcs = driver.cs.(csi.GroupControllerServer)
+		}
 	}
 
 	return driver, nil
@@ -283,6 +456,16 @@ func (driver *GCFSDriver) addControllerServiceCapabilities(cl []csi.ControllerSe
 	return nil
 }
 
+func (driver *GCFSDriver) addGroupControllerServiceCapabilities(gl []csi.GroupControllerServiceCapability_RPC_Type) error {
+	var gcsc []*csi.GroupControllerServiceCapability
+	for _, g := range gl {
+		klog.Infof("Enabling group controller service capability: %v", g.String())
+		gcsc = append(gcsc, NewGroupControllerServiceCapability(g))
+	}
+	driver.gcscap = gcsc
+	return nil
+}
+
 func (driver *GCFSDriver) addNodeServiceCapabilities(nl []csi.NodeServiceCapability_RPC_Type) error {
 	var nsc []*csi.NodeServiceCapability
 	for _, n := range nl {
@@ -326,6 +509,9 @@ func (driver *GCFSDriver) Run(endpoint string) {
 		if driver.recon != nil {
 			runMultishareReconciler(driver.config, driver.recon, driver.factory, driver.coreFactory, driver.driverFactory)
 		}
+		if driver.backupScheduleController != nil {
+			runBackupScheduleController(driver.config, driver.backupScheduleController, driver.backupScheduleFactory, driver.backupScheduleCoreFactory)
+		}
 
 		klog.Infof("runcontroller %v", driver.config.RunController)
 		go run(context.TODO())
@@ -333,11 +519,17 @@ func (driver *GCFSDriver) Run(endpoint string) {
 
 	// Start the nonblocking GRPC.
 	s := NewNonBlockingGRPCServer()
-	s.Start(endpoint, driver.ids, driver.cs, driver.ns)
+	s.Start(endpoint, driver.ids, driver.cs, driver.gcs, driver.ns)
 	if driver.config.RunNode && driver.config.FeatureOptions.FeatureLockRelease.Enabled && !driver.config.FeatureOptions.FeatureLockRelease.Standalone {
 		// Start the lock release controller on node driver.
 		driver.ns.(*nodeServer).lockReleaseController.Run(context.Background())
 	}
+	if driver.config.RunNode && driver.ns.(*nodeServer).mountHealth != nil {
+		go driver.ns.(*nodeServer).mountHealth.Run(wait.NeverStop)
+	}
+	if driver.config.RunNode && driver.ns.(*nodeServer).shareLocator != nil {
+		go driver.ns.(*nodeServer).shareLocator.Run(wait.NeverStop)
+	}
 	s.Wait()
 }
 
@@ -374,13 +566,24 @@ func initMultishareReconciler(driverConfig *GCFSDriverConfig) (*MultishareReconc
 	driverFactory := fsInformers.NewSharedInformerFactoryWithOptions(driverfsClient, resyncPeriod, fsInformers.WithNamespace(util.ManagedFilestoreCSINamespace))
 	sharescheme.AddToScheme(scheme.Scheme)
 
+	var migrationInformer multishareInformers.ShareMigrationInformer
+	if driverConfig.FeatureOptions.FeatureShareMigration != nil && driverConfig.FeatureOptions.FeatureShareMigration.Enabled {
+		migrationInformer = factory.Multishare().V1().ShareMigrations()
+	}
 	recon := NewMultishareReconciler(
 		fsClient,
 		driverConfig,
 		factory.Multishare().V1().ShareInfos(),
 		factory.Multishare().V1().InstanceInfos(),
 		coreFactory.Storage().V1().StorageClasses().Lister(),
+		migrationInformer,
 	)
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedv1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	recon.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "filestore-csi-controller"})
+	recon.pvLister = coreFactory.Core().V1().PersistentVolumes().Lister()
+	recon.pvcLister = coreFactory.Core().V1().PersistentVolumeClaims().Lister()
+	recon.kubeClient = kubeClient
 	driverConfig.Reconciler = recon
 	driverConfig.FeatureOptions.FeatureStateful.DriverClientSet = driverfsClient
 	driverConfig.FeatureOptions.FeatureStateful.ShareLister = driverFactory.Multishare().V1().ShareInfos().Lister()
@@ -389,6 +592,12 @@ func initMultishareReconciler(driverConfig *GCFSDriverConfig) (*MultishareReconc
 		klog.Errorf("Exiting due to failure to ensure CRDs exist during startup: %+v", err)
 		os.Exit(1)
 	}
+	if migrationInformer != nil {
+		if err := ensureShareMigrationCRDExists(fsClient); err != nil {
+			klog.Errorf("Exiting due to failure to ensure the ShareMigration CRD exists during startup: %+v", err)
+			os.Exit(1)
+		}
+	}
 
 	return recon, factory, coreFactory, driverFactory
 }
@@ -441,6 +650,94 @@ func runMultishareReconciler(driverConfig *GCFSDriverConfig, recon *MultishareRe
 	}
 }
 
+func initBackupScheduleController(driverConfig *GCFSDriverConfig) (*BackupScheduleController, fsInformers.SharedInformerFactory, informers.SharedInformerFactory) {
+	scheduleConfig := driverConfig.FeatureOptions.FeatureBackupSchedule
+	config, err := util.BuildConfig(scheduleConfig.KubeConfig)
+	if err != nil {
+		klog.Error(err.Error())
+		os.Exit(1)
+	}
+	config.QPS = (float32)(scheduleConfig.KubeAPIQPS)
+	config.Burst = scheduleConfig.KubeAPIBurst
+
+	configProtobuf := rest.CopyConfig(config)
+	configProtobuf.ContentType = runtime.ContentTypeProtobuf
+	kubeClient, err := kubernetes.NewForConfig(configProtobuf)
+	if err != nil {
+		klog.Error(err.Error())
+		os.Exit(1)
+	}
+	fsClient, err := clientset.NewForConfig(config)
+	if err != nil {
+		klog.Error(err.Error())
+		os.Exit(1)
+	}
+
+	factory := fsInformers.NewSharedInformerFactory(fsClient, scheduleConfig.ResyncPeriod)
+	coreFactory := informers.NewSharedInformerFactory(kubeClient, scheduleConfig.ResyncPeriod)
+	sharescheme.AddToScheme(scheme.Scheme)
+
+	controller := NewBackupScheduleController(
+		fsClient,
+		driverConfig,
+		factory.Backup().V1().BackupSchedules(),
+		coreFactory.Core().V1().PersistentVolumeClaims(),
+		coreFactory.Core().V1().PersistentVolumes(),
+	)
+
+	if err := ensureBackupScheduleCRDExists(fsClient); err != nil {
+		klog.Errorf("Exiting due to failure to ensure BackupSchedule CRD exists during startup: %+v", err)
+		os.Exit(1)
+	}
+
+	return controller, factory, coreFactory
+}
+
+func runBackupScheduleController(driverConfig *GCFSDriverConfig, controller *BackupScheduleController, factory fsInformers.SharedInformerFactory, coreFactory informers.SharedInformerFactory) {
+	run := func(context.Context) {
+		stopCh := make(chan struct{})
+		factory.Start(stopCh)
+		coreFactory.Start(stopCh)
+		go controller.Run(stopCh)
+
+		// ...until SIGINT
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt)
+		<-c
+		close(stopCh)
+	}
+
+	scheduleConfig := driverConfig.FeatureOptions.FeatureBackupSchedule
+
+	if !scheduleConfig.LeaderElection {
+		go run(context.TODO())
+	} else {
+		go func() {
+			lockName := "filestore-backup-schedule-leader"
+			config, err := util.BuildConfig(scheduleConfig.KubeConfig)
+			if err != nil {
+				klog.Fatal(err.Error())
+			}
+			config.ContentType = runtime.ContentTypeProtobuf
+
+			leClient, err := kubernetes.NewForConfig(config)
+			if err != nil {
+				klog.Fatalf("Failed to create leaderelection client: %v", err)
+			}
+			le := leaderelection.NewLeaderElection(leClient, lockName, run)
+			if scheduleConfig.LeaderElectionNamespace != "" {
+				le.WithNamespace(scheduleConfig.LeaderElectionNamespace)
+			}
+			le.WithLeaseDuration(scheduleConfig.LeaderElectionLeaseDuration)
+			le.WithRenewDeadline(scheduleConfig.LeaderElectionRenewDeadline)
+			le.WithRetryPeriod(scheduleConfig.LeaderElectionRetryPeriod)
+			if err := le.Run(); err != nil {
+				klog.Fatalf("Failed to initialize leader election: %v", err)
+			}
+		}()
+	}
+}
+
 // Checks that the ShareInfo v1 CRDs exist.
 func ensureCustomResourceDefinitionsExist(client *clientset.Clientset) error {
 	condition := func() (bool, error) {
@@ -456,6 +753,41 @@ func ensureCustomResourceDefinitionsExist(client *clientset.Clientset) error {
 		return true, nil
 	}
 
+	return waitForCustomResourceDefinition(condition)
+}
+
+// Checks that the ShareMigration v1 CRD exists.
+func ensureShareMigrationCRDExists(client *clientset.Clientset) error {
+	condition := func() (bool, error) {
+		_, err := client.MultishareV1().ShareMigrations(util.ManagedFilestoreCSINamespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			klog.Errorf("Failed to list v1 sharemigrations with error=%+v", err)
+			return false, nil
+		}
+
+		return true, nil
+	}
+
+	return waitForCustomResourceDefinition(condition)
+}
+
+// Checks that the BackupSchedule v1 CRD exists.
+func ensureBackupScheduleCRDExists(client *clientset.Clientset) error {
+	condition := func() (bool, error) {
+		_, err := client.BackupV1().BackupSchedules(metav1.NamespaceAll).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			klog.Errorf("Failed to list v1 backupschedules with error=%+v", err)
+			return false, nil
+		}
+
+		return true, nil
+	}
+
+	return waitForCustomResourceDefinition(condition)
+}
+
+// waitForCustomResourceDefinition retries the condition until a CRD can be listed.
+func waitForCustomResourceDefinition(condition wait.ConditionFunc) error {
 	maxMs := (5 * time.Second).Milliseconds()
 	if maxMs < crdCheckInitialDurationMs {
 		maxMs = crdCheckInitialDurationMs
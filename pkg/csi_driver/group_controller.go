@@ -0,0 +1,116 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	methodCreateVolumeGroupSnapshot = "CreateVolumeGroupSnapshot"
+	methodDeleteVolumeGroupSnapshot = "DeleteVolumeGroupSnapshot"
+	methodGetVolumeGroupSnapshot    = "GetVolumeGroupSnapshot"
+
+	// A group snapshot handle is projects/{project}/locations/{region}/volumeGroupSnapshots/{name}. It is not
+	// a Filestore resource, the member backups of the group are labeled with its name.
+	groupSnapshotHandleFmt = "projects/%s/locations/%s/volumeGroupSnapshots/%s"
+	groupSnapshotHandleKey = "volumeGroupSnapshots"
+	// number of elements in a group snapshot handle.
+	groupSnapshotTotalElements = 6
+)
+
+var _ csi.GroupControllerServer = &controllerServer{}
+
+func (s *controllerServer) GroupControllerGetCapabilities(ctx context.Context, req *csi.GroupControllerGetCapabilitiesRequest) (*csi.GroupControllerGetCapabilitiesResponse, error) {
+	return &csi.GroupControllerGetCapabilitiesResponse{
+		Capabilities: s.config.driver.gcscap,
+	}, nil
+}
+
+// CreateVolumeGroupSnapshot takes a group snapshot of multishare volumes. Only multishare volumes are supported,
+// since the shares of a multishare instance are the only volumes an application spreads its data across on a
+// single Filestore instance.
+func (s *controllerServer) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	if s.config.multiShareController == nil {
+		return nil, status.Error(codes.InvalidArgument, "multishare controller not enabled")
+	}
+	start := time.Now()
+	response, err := s.config.multiShareController.CreateVolumeGroupSnapshot(ctx, req)
+	duration := time.Since(start)
+	s.config.metricsManager.RecordOperationMetrics(err, methodCreateVolumeGroupSnapshot, modeMultishare, duration)
+	if err != nil {
+		klog.Errorf("CreateVolumeGroupSnapshot returned error %v, for request %+v", err, req)
+		return nil, err
+	}
+	klog.Infof("CreateVolumeGroupSnapshot response %+v, for request %+v", response, req)
+	return response, nil
+}
+
+func (s *controllerServer) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+	if s.config.multiShareController == nil {
+		return nil, status.Error(codes.InvalidArgument, "multishare controller not enabled")
+	}
+	start := time.Now()
+	response, err := s.config.multiShareController.DeleteVolumeGroupSnapshot(ctx, req)
+	duration := time.Since(start)
+	s.config.metricsManager.RecordOperationMetrics(err, methodDeleteVolumeGroupSnapshot, modeMultishare, duration)
+	if err != nil {
+		klog.Errorf("DeleteVolumeGroupSnapshot returned error %v, for request %+v", err, req)
+		return nil, err
+	}
+	klog.Infof("DeleteVolumeGroupSnapshot succeeded for request %+v", req)
+	return response, nil
+}
+
+func (s *controllerServer) GetVolumeGroupSnapshot(ctx context.Context, req *csi.GetVolumeGroupSnapshotRequest) (*csi.GetVolumeGroupSnapshotResponse, error) {
+	if s.config.multiShareController == nil {
+		return nil, status.Error(codes.InvalidArgument, "multishare controller not enabled")
+	}
+	start := time.Now()
+	response, err := s.config.multiShareController.GetVolumeGroupSnapshot(ctx, req)
+	duration := time.Since(start)
+	s.config.metricsManager.RecordOperationMetrics(err, methodGetVolumeGroupSnapshot, modeMultishare, duration)
+	if err != nil {
+		klog.Errorf("GetVolumeGroupSnapshot returned error %v, for request %+v", err, req)
+		return nil, err
+	}
+	return response, nil
+}
+
+func groupSnapshotHandle(project, region, name string) string {
+	return fmt.Sprintf(groupSnapshotHandleFmt, project, region, name)
+}
+
+// parseGroupSnapshotHandle returns the project, region and name of a group snapshot handle.
+func parseGroupSnapshotHandle(handle string) (string, string, string, error) {
+	tokens := strings.Split(handle, "/")
+	if len(tokens) != groupSnapshotTotalElements || tokens[0] != "projects" || tokens[2] != "locations" || tokens[4] != groupSnapshotHandleKey {
+		return "", "", "", fmt.Errorf("failed to get id components. Expected 'projects/{project}/locations/{region}/volumeGroupSnapshots/{name}'. Got: %s", handle)
+	}
+	if tokens[1] == "" || tokens[3] == "" || tokens[5] == "" {
+		return "", "", "", fmt.Errorf("invalid group snapshot id %s", handle)
+	}
+	return tokens[1], tokens[3], tokens[5], nil
+}
@@ -0,0 +1,278 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// Keys of the NodeStageVolume secrets of a volume with a Kerberos security flavor. The keytab is base64
+	// encoded, as the secrets are passed as strings.
+	secretKerberosPrincipal = "principal"
+	secretKerberosKeytab    = "keytab"
+
+	// kerberosCachePrefix prefixes the credential caches of the volumes. rpc.gssd looks up the credential
+	// caches of uid 0, which mounts the volumes, by the krb5cc_0 prefix. It establishes the security
+	// contexts of all the mounts with any of them, so the volumes of a node must share a principal.
+	kerberosCachePrefix = "krb5cc_0_"
+
+	// defaultKerberosRefreshInterval is the interval the tickets are obtained again if not configured.
+	// It is well below the default ticket lifetime of 10 hours.
+	defaultKerberosRefreshInterval = 1 * time.Hour
+	// The backoff of the retries of a failed refresh.
+	kerberosMinBackoff = 10 * time.Second
+	kerberosMaxBackoff = 5 * time.Minute
+)
+
+// errKerberosPrincipalConflict is returned when the credentials of a volume are staged for a principal
+// other than the one of the volumes staged on the node.
+var errKerberosPrincipalConflict = errors.New("Kerberos principal conflict")
+
+// kerberosCredentials are the credentials of a staged volume with a Kerberos security flavor. The exported
+// fields are the state saved in the state directory, along with the keytab.
+type kerberosCredentials struct {
+	VolumeID  string `json:"volumeID"`
+	Principal string `json:"principal"`
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// kerberosCredentialManager obtains the tickets of the staged volumes with Kerberos security flavors from
+// the keytabs of their NodeStageVolume secrets. Each volume has its own credential cache, which rpc.gssd
+// establishes the security contexts of the mounts with, and which is refreshed before its tickets expire.
+// The keytabs are saved in the state directory, so that the refreshes are restored when the driver
+// restarts. restore and unstage are no-ops on a nil manager, when Kerberos is disabled.
+type kerberosCredentialManager struct {
+	mux sync.Mutex
+	// credentials are the credentials by volume ID.
+	credentials map[string]*kerberosCredentials
+
+	kinitPath          string
+	stateDir           string
+	credentialCacheDir string
+	refreshInterval    time.Duration
+
+	// kinit obtains the ticket of a principal from a keytab into a credential cache. It is replaced in tests.
+	kinit func(keytabPath, principal, cachePath string) error
+}
+
+func newKerberosCredentialManager(features *FeatureKerberos) *kerberosCredentialManager {
+	m := &kerberosCredentialManager{
+		credentials:        map[string]*kerberosCredentials{},
+		kinitPath:          features.KinitPath,
+		stateDir:           features.StateDir,
+		credentialCacheDir: features.CredentialCacheDir,
+		refreshInterval:    features.RefreshInterval,
+	}
+	if m.refreshInterval <= 0 {
+		m.refreshInterval = defaultKerberosRefreshInterval
+	}
+	m.kinit = m.runKinit
+	return m
+}
+
+// restore refreshes the credentials saved in the state directory.
+func (m *kerberosCredentialManager) restore() error {
+	if m == nil {
+		return nil
+	}
+	if err := os.MkdirAll(m.stateDir, 0700); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(m.stateDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		c := &kerberosCredentials{}
+		if err := json.Unmarshal(data, c); err != nil {
+			klog.Errorf("Ignoring invalid Kerberos credentials state %s: %v", path, err)
+			continue
+		}
+		klog.Infof("Restoring Kerberos credentials of volume %s for %s", c.VolumeID, c.Principal)
+		m.run(c, true /* refreshNow */)
+	}
+	return nil
+}
+
+// parseKerberosSecrets returns the principal and the keytab of the NodeStageVolume secrets of a volume.
+func parseKerberosSecrets(secrets map[string]string) (string, []byte, error) {
+	principal := secrets[secretKerberosPrincipal]
+	if principal == "" {
+		return "", nil, fmt.Errorf("secret %q must be set", secretKerberosPrincipal)
+	}
+	keytab, err := base64.StdEncoding.DecodeString(secrets[secretKerberosKeytab])
+	if err != nil || len(keytab) == 0 {
+		return "", nil, fmt.Errorf("secret %q must be a base64 encoded keytab", secretKerberosKeytab)
+	}
+	return principal, keytab, nil
+}
+
+// stage saves the keytab of a volume, and obtains the ticket of its principal. Staging a staged volume
+// again saves its keytab, which may have been rotated. The volumes of a node must share a principal, as
+// rpc.gssd would access the volumes of a principal with the credentials of another.
+func (m *kerberosCredentialManager) stage(volumeID, principal string, keytab []byte) error {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, other := range m.credentials {
+		if other.Principal != principal {
+			return fmt.Errorf("%w: volume %s is staged for %s, volumes for %s can't be staged on the same node", errKerberosPrincipalConflict, other.VolumeID, other.Principal, principal)
+		}
+	}
+	c, ok := m.credentials[volumeID]
+	if err := os.MkdirAll(m.stateDir, 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(m.credentialCacheDir, 0700); err != nil {
+		return err
+	}
+	if err := m.saveKeytab(volumeID, keytab); err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+
+	c = &kerberosCredentials{VolumeID: volumeID, Principal: principal}
+	if err := m.kinit(m.keytabPath(volumeID), principal, m.cachePath(volumeID)); err != nil {
+		m.remove(volumeID)
+		return fmt.Errorf("failed to obtain the ticket of %s: %w", principal, err)
+	}
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.statePath(volumeID), data, 0600); err != nil {
+		m.remove(volumeID)
+		return err
+	}
+	klog.Infof("Obtained the Kerberos ticket of volume %s for %s", volumeID, principal)
+	m.run(c, false /* refreshNow */)
+	return nil
+}
+
+// unstage stops refreshing the credentials of a volume, and removes its keytab and credential cache.
+func (m *kerberosCredentialManager) unstage(volumeID string) error {
+	if m == nil {
+		return nil
+	}
+	m.mux.Lock()
+	c, ok := m.credentials[volumeID]
+	delete(m.credentials, volumeID)
+	m.mux.Unlock()
+	if ok {
+		close(c.stopCh)
+		<-c.doneCh
+		klog.Infof("Removed the Kerberos credentials of volume %s", volumeID)
+	}
+	return m.remove(volumeID)
+}
+
+// run registers the credentials of a volume and refreshes them. It must be called with mux held.
+func (m *kerberosCredentialManager) run(c *kerberosCredentials, refreshNow bool) {
+	c.stopCh = make(chan struct{})
+	c.doneCh = make(chan struct{})
+	m.credentials[c.VolumeID] = c
+	go m.refresh(c, refreshNow)
+}
+
+// refresh obtains the ticket of the credentials of a volume every refresh interval until they are
+// unstaged. A failed refresh is retried with a backoff, as the previous ticket is valid for a while.
+func (m *kerberosCredentialManager) refresh(c *kerberosCredentials, now bool) {
+	defer close(c.doneCh)
+	wait := m.refreshInterval
+	if now {
+		wait = 0
+	}
+	backoff := kerberosMinBackoff
+	for {
+		select {
+		case <-c.stopCh:
+			return
+		case <-time.After(wait):
+		}
+		if err := m.kinit(m.keytabPath(c.VolumeID), c.Principal, m.cachePath(c.VolumeID)); err != nil {
+			klog.Errorf("Failed to refresh the Kerberos ticket of volume %s for %s, retrying in %v: %v", c.VolumeID, c.Principal, backoff, err)
+			wait = backoff
+			backoff *= 2
+			if backoff > kerberosMaxBackoff {
+				backoff = kerberosMaxBackoff
+			}
+			continue
+		}
+		klog.V(4).Infof("Refreshed the Kerberos ticket of volume %s for %s", c.VolumeID, c.Principal)
+		wait = m.refreshInterval
+		backoff = kerberosMinBackoff
+	}
+}
+
+// remove removes the files of the credentials of a volume.
+func (m *kerberosCredentialManager) remove(volumeID string) error {
+	for _, path := range []string{m.cachePath(volumeID), m.keytabPath(volumeID), m.statePath(volumeID)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *kerberosCredentialManager) runKinit(keytabPath, principal, cachePath string) error {
+	cmd := exec.Command(m.kinitPath, "-k", "-t", keytabPath, "-c", "FILE:"+cachePath, principal)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%v: %s", err, out)
+	}
+	return nil
+}
+
+func (m *kerberosCredentialManager) statePath(volumeID string) string {
+	return filepath.Join(m.stateDir, volumeFileName(volumeID)+".json")
+}
+
+func (m *kerberosCredentialManager) keytabPath(volumeID string) string {
+	return filepath.Join(m.stateDir, volumeFileName(volumeID)+".keytab")
+}
+
+func (m *kerberosCredentialManager) cachePath(volumeID string) string {
+	return filepath.Join(m.credentialCacheDir, kerberosCachePrefix+volumeFileName(volumeID))
+}
+
+// saveKeytab replaces the keytab of a volume atomically, as it may be read by a refresh.
+func (m *kerberosCredentialManager) saveKeytab(volumeID string, keytab []byte) error {
+	tmp := m.keytabPath(volumeID) + ".tmp"
+	if err := os.WriteFile(tmp, keytab, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.keytabPath(volumeID))
+}
@@ -0,0 +1,287 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
+)
+
+const (
+	testPrincipal = "filestore-client@AD.EXAMPLE.COM"
+	testKeytab    = "test keytab"
+)
+
+var testKerberosSecrets = map[string]string{
+	secretKerberosPrincipal: testPrincipal,
+	secretKerberosKeytab:    base64.StdEncoding.EncodeToString([]byte(testKeytab)),
+}
+
+// fakeKinit obtains tickets by writing the keytab to the credential cache, unless it fails.
+type fakeKinit struct {
+	mux  sync.Mutex
+	fail bool
+	// calls receives the principals of the tickets as they are obtained.
+	calls chan string
+}
+
+func newTestKerberosCredentialManager(t *testing.T, stateDir string, refreshInterval time.Duration) (*kerberosCredentialManager, *fakeKinit) {
+	m := newKerberosCredentialManager(&FeatureKerberos{
+		Enabled:            true,
+		StateDir:           stateDir,
+		CredentialCacheDir: filepath.Join(stateDir, "ccache"),
+		RefreshInterval:    refreshInterval,
+	})
+	fake := &fakeKinit{calls: make(chan string, 100)}
+	m.kinit = func(keytabPath, principal, cachePath string) error {
+		fake.mux.Lock()
+		defer fake.mux.Unlock()
+		if fake.fail {
+			return errors.New("kinit: Client not found in Kerberos database")
+		}
+		keytab, err := os.ReadFile(keytabPath)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(cachePath, keytab, 0600); err != nil {
+			return err
+		}
+		fake.calls <- principal
+		return nil
+	}
+	return m, fake
+}
+
+func (f *fakeKinit) setFail(fail bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.fail = fail
+}
+
+func (f *fakeKinit) nextCall(t *testing.T) string {
+	select {
+	case principal := <-f.calls:
+		return principal
+	case <-time.After(5 * time.Second):
+		t.Fatalf("ticket was not obtained")
+		return ""
+	}
+}
+
+func TestKerberosCredentialsStageUnstage(t *testing.T) {
+	m, fake := newTestKerberosCredentialManager(t, t.TempDir(), time.Hour)
+
+	if err := m.stage(testVolumeID, testPrincipal, []byte(testKeytab)); err != nil {
+		t.Fatalf("Failed to stage credentials: %v", err)
+	}
+	if got := fake.nextCall(t); got != testPrincipal {
+		t.Errorf("got ticket of %q, expected %q", got, testPrincipal)
+	}
+	info, err := os.Stat(m.keytabPath(testVolumeID))
+	if err != nil {
+		t.Fatalf("Failed to stat keytab: %v", err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("got keytab mode %v, expected 0600", info.Mode().Perm())
+	}
+	if filepath.Base(m.cachePath(testVolumeID))[:len("krb5cc_0")] != "krb5cc_0" {
+		t.Errorf("credential cache %s is not looked up by rpc.gssd for uid 0", m.cachePath(testVolumeID))
+	}
+	if cache, err := os.ReadFile(m.cachePath(testVolumeID)); err != nil || string(cache) != testKeytab {
+		t.Errorf("got credential cache %q, error %v", cache, err)
+	}
+
+	// Staging the volume again saves its rotated keytab, without obtaining a ticket.
+	if err := m.stage(testVolumeID, testPrincipal, []byte("rotated keytab")); err != nil {
+		t.Fatalf("Failed to stage credentials again: %v", err)
+	}
+	if keytab, err := os.ReadFile(m.keytabPath(testVolumeID)); err != nil || string(keytab) != "rotated keytab" {
+		t.Errorf("got keytab %q, error %v, expected the rotated keytab", keytab, err)
+	}
+	if err := m.stage(testVolumeID, "other@AD.EXAMPLE.COM", []byte(testKeytab)); err == nil {
+		t.Errorf("staged the credentials of the volume for another principal")
+	}
+	// The volumes of a node share a principal.
+	if err := m.stage("modeInstance/us-central1-c/other/vol1", "other@AD.EXAMPLE.COM", []byte(testKeytab)); !errors.Is(err, errKerberosPrincipalConflict) {
+		t.Errorf("got error %v staging the credentials of another volume for another principal, expected a principal conflict", err)
+	}
+	if err := m.stage("modeInstance/us-central1-c/other/vol1", testPrincipal, []byte(testKeytab)); err != nil {
+		t.Errorf("Failed to stage the credentials of another volume for the same principal: %v", err)
+	}
+	fake.nextCall(t)
+	if err := m.unstage("modeInstance/us-central1-c/other/vol1"); err != nil {
+		t.Fatalf("Failed to unstage credentials: %v", err)
+	}
+
+	if err := m.unstage(testVolumeID); err != nil {
+		t.Fatalf("Failed to unstage credentials: %v", err)
+	}
+	for _, path := range []string{m.keytabPath(testVolumeID), m.cachePath(testVolumeID), m.statePath(testVolumeID)} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was not removed: %v", path, err)
+		}
+	}
+	if err := m.unstage(testVolumeID); err != nil {
+		t.Errorf("Failed to unstage unstaged credentials: %v", err)
+	}
+
+	// The keytab of a principal without a ticket is not kept.
+	fake.setFail(true)
+	if err := m.stage(testVolumeID, testPrincipal, []byte(testKeytab)); err == nil {
+		t.Errorf("staged credentials without a ticket")
+	}
+	if _, err := os.Stat(m.keytabPath(testVolumeID)); !os.IsNotExist(err) {
+		t.Errorf("keytab of failed credentials was not removed: %v", err)
+	}
+
+	var disabled *kerberosCredentialManager
+	if err := disabled.unstage(testVolumeID); err != nil {
+		t.Errorf("unstage of disabled manager failed: %v", err)
+	}
+}
+
+func TestKerberosCredentialsRefresh(t *testing.T) {
+	m, fake := newTestKerberosCredentialManager(t, t.TempDir(), 10*time.Millisecond)
+	defer m.unstage(testVolumeID)
+	if err := m.stage(testVolumeID, testPrincipal, []byte(testKeytab)); err != nil {
+		t.Fatalf("Failed to stage credentials: %v", err)
+	}
+	fake.nextCall(t)
+	// The ticket is obtained again every refresh interval.
+	fake.nextCall(t)
+	fake.nextCall(t)
+}
+
+func TestKerberosCredentialsRestore(t *testing.T) {
+	stateDir := t.TempDir()
+	m, _ := newTestKerberosCredentialManager(t, stateDir, time.Hour)
+	if err := m.stage(testVolumeID, testPrincipal, []byte(testKeytab)); err != nil {
+		t.Fatalf("Failed to stage credentials: %v", err)
+	}
+	defer m.unstage(testVolumeID)
+
+	// The ticket of a restarted driver is obtained again from the saved keytab.
+	restored, fake := newTestKerberosCredentialManager(t, stateDir, time.Hour)
+	if err := restored.restore(); err != nil {
+		t.Fatalf("Failed to restore credentials: %v", err)
+	}
+	defer restored.unstage(testVolumeID)
+	if got := fake.nextCall(t); got != testPrincipal {
+		t.Errorf("got ticket of %q for restored credentials, expected %q", got, testPrincipal)
+	}
+}
+
+func TestNodeStageKerberosVolume(t *testing.T) {
+	mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{}}
+	metaService, err := metadata.NewFakeService()
+	if err != nil {
+		t.Fatalf("Failed to init metadata service")
+	}
+	server, err := newNodeServer(initTestDriver(t), mounter, metaService, &GCFSDriverFeatureOptions{
+		FeatureLockRelease: &FeatureLockRelease{},
+		FeatureKerberos:    &FeatureKerberos{Enabled: true, StateDir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create node server: %v", err)
+	}
+	ns := server.(*nodeServer)
+	var fake *fakeKinit
+	ns.kerberos, fake = newTestKerberosCredentialManager(t, ns.kerberos.stateDir, time.Hour)
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+
+	attributes := map[string]string{
+		attrIP:             "1.1.1.1",
+		attrVolume:         "test-volume",
+		attrFileProtocol:   v4_1FileProtocol,
+		attrSecurityFlavor: securityFlavorKrb5p,
+		attrServerName:     "my-computer.filestore.ad.example.com",
+	}
+	if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     attributes,
+		Secrets:           testKerberosSecrets,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	fake.nextCall(t)
+	validateMountPoint(t, "kerberos volume", mounter, &mount.MountPoint{
+		Device: "my-computer.filestore.ad.example.com:/test-volume",
+		Path:   stagingPath,
+		Type:   "nfs",
+		Opts:   []string{"sec=krb5p", "vers=4.1"},
+	})
+
+	if _, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume failed: %v", err)
+	}
+	if _, err := os.Stat(ns.kerberos.cachePath(testVolumeID)); !os.IsNotExist(err) {
+		t.Errorf("credential cache of unstaged volume was not removed: %v", err)
+	}
+
+	// The keytab is required.
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     attributes,
+		Secrets:           map[string]string{secretKerberosPrincipal: testPrincipal},
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v staging a volume without keytab, expected InvalidArgument", err)
+	}
+
+	// Only NFSv4.1 volumes have Kerberos security flavors.
+	attributes[attrFileProtocol] = v3FileProtocol
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     attributes,
+		Secrets:           testKerberosSecrets,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v staging an NFSv3 volume with a Kerberos security flavor, expected InvalidArgument", err)
+	}
+
+	// The volume can't be staged on a node without Kerberos.
+	ns.kerberos = nil
+	attributes[attrFileProtocol] = v4_1FileProtocol
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     attributes,
+		Secrets:           testKerberosSecrets,
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v staging a Kerberos volume without the feature, expected FailedPrecondition", err)
+	}
+}
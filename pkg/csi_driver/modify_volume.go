@@ -0,0 +1,276 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// Mutable parameters of a VolumeAttributesClass, in addition to the labels, resource tags and NFS export
+// options parameters of a StorageClass.
+const (
+	ParamMaxIops      = "max-iops"
+	ParamMaxIopsPerTb = "max-iops-per-tb"
+
+	methodModifyVolume = "ModifyVolume"
+)
+
+// volumeModification is the update of a volume requested by the mutable parameters.
+type volumeModification struct {
+	labels            map[string]string
+	resourceTags      bool
+	nfsExportOptions  []*file.NfsExportOptions
+	performanceConfig *file.PerformanceConfig
+}
+
+// parseMutableParameters returns the modification of the mutable parameters. Labels are added to the
+// labels of the volume or update them, the labels set by the driver can't be modified.
+func parseMutableParameters(params map[string]string, nfsExportOptionsEnabled bool) (*volumeModification, error) {
+	mod := &volumeModification{}
+	for k, v := range params {
+		var err error
+		switch strings.ToLower(k) {
+		case ParameterKeyLabels:
+			mod.labels, err = util.ConvertLabelsStringToMap(v)
+			if err != nil {
+				return nil, fmt.Errorf("parameters contain invalid labels parameter: %w", err)
+			}
+			for key := range mod.labels {
+				if isDriverLabel(key) {
+					return nil, fmt.Errorf("labels cannot contain metadata label key %s", key)
+				}
+			}
+		case cloud.ParameterKeyResourceTags:
+			mod.resourceTags = true
+		case ParamNfsExportOptions:
+			if !nfsExportOptionsEnabled {
+				return nil, fmt.Errorf("nfsExportOptions are disabled")
+			}
+			mod.nfsExportOptions, err = parseNfsExportOptions(v)
+			if err != nil {
+				return nil, err
+			}
+		case ParamMaxIops, ParamMaxIopsPerTb:
+			iops, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || iops <= 0 {
+				return nil, fmt.Errorf("parameter %q must be a positive integer, got %q", k, v)
+			}
+			if mod.performanceConfig == nil {
+				mod.performanceConfig = &file.PerformanceConfig{}
+			}
+			if strings.ToLower(k) == ParamMaxIops {
+				mod.performanceConfig.MaxIops = iops
+			} else {
+				mod.performanceConfig.MaxIopsPerTb = iops
+			}
+		default:
+			return nil, fmt.Errorf("invalid mutable parameter %q", k)
+		}
+	}
+	if c := mod.performanceConfig; c != nil && c.MaxIops != 0 && c.MaxIopsPerTb != 0 {
+		return nil, fmt.Errorf("parameters %q and %q are mutually exclusive", ParamMaxIops, ParamMaxIopsPerTb)
+	}
+	return mod, nil
+}
+
+// isDriverLabel returns true for the keys of the labels the driver sets on the resources it creates.
+func isDriverLabel(key string) bool {
+	switch key {
+	case tagKeyCreatedForClaimNamespace, tagKeyCreatedForClaimName, tagKeyCreatedForVolumeName:
+		return true
+	}
+	return strings.HasPrefix(key, "storage_gke_io_")
+}
+
+// withLabels returns the labels updated with the labels of the modification.
+func (mod *volumeModification) withLabels(labels map[string]string) map[string]string {
+	result := make(map[string]string, len(labels)+len(mod.labels))
+	for k, v := range labels {
+		result[k] = v
+	}
+	for k, v := range mod.labels {
+		result[k] = v
+	}
+	return result
+}
+
+// ControllerModifyVolume applies the mutable parameters of a VolumeAttributesClass to a volume in place.
+func (s *controllerServer) ControllerModifyVolume(ctx context.Context, req *csi.ControllerModifyVolumeRequest) (*csi.ControllerModifyVolumeResponse, error) {
+	klog.V(4).Infof("ControllerModifyVolume called with request %+v", req)
+	if err := s.modifyVolume(ctx, req.GetVolumeId(), req.GetMutableParameters()); err != nil {
+		klog.Errorf("ControllerModifyVolume returned error %v, for request: %+v", err, req)
+		return nil, err
+	}
+	return &csi.ControllerModifyVolumeResponse{}, nil
+}
+
+// modifyVolume applies the mutable parameters to the instance or the share of a volume.
+func (s *controllerServer) modifyVolume(ctx context.Context, volumeID string, mutableParameters map[string]string) error {
+	if volumeID == "" {
+		return status.Error(codes.InvalidArgument, "ControllerModifyVolume volume ID must be provided")
+	}
+	nfsExportOptionsEnabled := s.config.features != nil && s.config.features.FeatureNFSExportOptionsOnCreate != nil && s.config.features.FeatureNFSExportOptionsOnCreate.Enabled
+	mod, err := parseMutableParameters(mutableParameters, nfsExportOptionsEnabled)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if isMultishareVolId(volumeID) {
+		if s.config.multiShareController == nil {
+			return status.Error(codes.InvalidArgument, "multishare controller not enabled")
+		}
+		if s.config.features.FeatureStateful.Enabled {
+			return status.Error(codes.Unimplemented, "ControllerModifyVolume is not supported by the stateful multishare controller")
+		}
+		start := time.Now()
+		err := s.config.multiShareController.modifyVolume(ctx, volumeID, mod)
+		s.config.metricsManager.RecordOperationMetrics(err, methodModifyVolume, modeMultishare, time.Since(start))
+		return err
+	}
+
+	if isSubDirectoryVolId(volumeID) {
+		return status.Error(codes.InvalidArgument, "sub-directory volumes have no mutable parameters")
+	}
+
+	if acquired := s.config.volumeLocks.TryAcquire(volumeID); !acquired {
+		return status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer s.config.volumeLocks.Release(volumeID)
+
+	filer, _, err := getFileInstanceFromID(volumeID)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	filer.Project = s.config.cloud.Project
+	filer, err = s.config.fileService.GetInstance(ctx, filer)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return status.Errorf(codes.NotFound, "volume %q not found", volumeID)
+		}
+		return file.StatusError(err)
+	}
+	if filer.State != "READY" {
+		return status.Errorf(codes.Unavailable, "volume %q is not yet ready, current state %q", volumeID, filer.State)
+	}
+
+	var updateMask []string
+	if mod.labels != nil {
+		filer.Labels = mod.withLabels(filer.Labels)
+		updateMask = append(updateMask, file.LabelsUpdateMask)
+	}
+	if mod.nfsExportOptions != nil {
+		// The new export options keep the Kerberos security flavor the share was created with.
+		options := mod.nfsExportOptions
+		if flavor := instanceSecurityFlavor(filer); flavor != "" {
+			options = withSecurityFlavor(options, flavor)
+		}
+		filer.NfsExportOptions = options
+		updateMask = append(updateMask, file.FileShareUpdateMask)
+	}
+	if mod.performanceConfig != nil {
+		filer.PerformanceConfig = mod.performanceConfig
+		updateMask = append(updateMask, file.PerformanceConfigUpdateMask)
+	}
+	if len(updateMask) > 0 {
+		if _, err := s.config.fileService.PatchInstance(ctx, filer, updateMask); err != nil {
+			klog.Errorf("Modify volume %v failed: %v", volumeID, err.Error())
+			return file.StatusError(err)
+		}
+	}
+
+	if mod.resourceTags {
+		if err := s.config.tagManager.AttachResourceTags(ctx, cloud.FilestoreInstance, filer.Name, filer.Location, volumeID, mutableParameters); err != nil {
+			return status.Error(codes.Unavailable, err.Error())
+		}
+	}
+	klog.Infof("Modified volume %v with mutable parameters %v", volumeID, mutableParameters)
+	return nil
+}
+
+// modifyVolume applies the modification to a share. The performance and resource tags of a multishare
+// instance are shared by all its volumes, and can't be modified for one of them.
+func (m *MultishareController) modifyVolume(ctx context.Context, volumeID string, mod *volumeModification) error {
+	if mod.performanceConfig != nil {
+		return status.Errorf(codes.InvalidArgument, "parameters %q and %q are not supported for multishare volumes", ParamMaxIops, ParamMaxIopsPerTb)
+	}
+	if mod.resourceTags {
+		return status.Errorf(codes.InvalidArgument, "parameter %q is not supported for multishare volumes", cloud.ParameterKeyResourceTags)
+	}
+	_, project, location, instanceName, shareName, err := parseMultishareVolId(volumeID)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if acquired := m.volumeLocks.TryAcquire(volumeID); !acquired {
+		return status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer m.volumeLocks.Release(volumeID)
+
+	share, err := m.cloud.File.GetShare(ctx, &file.Share{
+		Parent: &file.MultishareInstance{
+			Project:  project,
+			Location: location,
+			Name:     instanceName,
+		},
+		Name: shareName,
+	})
+	if share == nil || file.IsNotFoundErr(err) {
+		return status.Errorf(codes.NotFound, "Couldn't find share with name %q", volumeID)
+	}
+	if err != nil {
+		return file.StatusError(err)
+	}
+
+	var updateMask []string
+	if mod.labels != nil {
+		share.Labels = mod.withLabels(share.Labels)
+		updateMask = append(updateMask, file.LabelsUpdateMask)
+	}
+	if mod.nfsExportOptions != nil {
+		options := mod.nfsExportOptions
+		if flavor := exportSecurityFlavor(share.NfsExportOptions); flavor != "" {
+			options = withSecurityFlavor(options, flavor)
+		}
+		share.NfsExportOptions = options
+		updateMask = append(updateMask, file.NfsExportOptionsUpdateMask)
+	}
+	if len(updateMask) == 0 {
+		return nil
+	}
+
+	workflow, err := m.opsManager.startShareModifyWorkflowSafe(ctx, share, updateMask)
+	if err != nil {
+		return file.StatusError(err)
+	}
+	if err := m.waitOnWorkflow(ctx, workflow); err != nil {
+		return file.StatusError(fmt.Errorf("wait on share modify op %q failed with error: %w", workflow.opName, err))
+	}
+	klog.Infof("Modified share %v with update mask %v", volumeID, updateMask)
+	return nil
+}
@@ -0,0 +1,311 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	testModifyInstance = "myinstance"
+	testModifyVolumeID = modeInstance + "/" + testZone + "/" + testModifyInstance + "/vol1"
+	testNfsExportRule  = `[{"accessMode":"READ_ONLY","ipRanges":["10.0.0.0/24"],"squashMode":"ROOT_SQUASH","anonUid":"65534","anonGid":"65534"}]`
+)
+
+func TestParseMutableParameters(t *testing.T) {
+	cases := []struct {
+		name        string
+		params      map[string]string
+		expected    *volumeModification
+		expectError bool
+	}{
+		{
+			name:     "labels",
+			params:   map[string]string{ParameterKeyLabels: "team=storage,env=prod"},
+			expected: &volumeModification{labels: map[string]string{"team": "storage", "env": "prod"}},
+		},
+		{
+			name:        "driver label",
+			params:      map[string]string{ParameterKeyLabels: tagKeyCreatedBy + "=someone"},
+			expectError: true,
+		},
+		{
+			name:     "fixed IOPS",
+			params:   map[string]string{ParamMaxIops: "17000"},
+			expected: &volumeModification{performanceConfig: &file.PerformanceConfig{MaxIops: 17000}},
+		},
+		{
+			name:     "IOPS per TiB",
+			params:   map[string]string{ParamMaxIopsPerTb: "17000"},
+			expected: &volumeModification{performanceConfig: &file.PerformanceConfig{MaxIopsPerTb: 17000}},
+		},
+		{
+			name:        "fixed IOPS and IOPS per TiB",
+			params:      map[string]string{ParamMaxIops: "17000", ParamMaxIopsPerTb: "17000"},
+			expectError: true,
+		},
+		{
+			name:        "invalid IOPS",
+			params:      map[string]string{ParamMaxIops: "-1"},
+			expectError: true,
+		},
+		{
+			name:     "resource tags",
+			params:   map[string]string{cloud.ParameterKeyResourceTags: "kubernetes/test1/test1"},
+			expected: &volumeModification{resourceTags: true},
+		},
+		{
+			name:        "immutable parameter",
+			params:      map[string]string{paramTier: enterpriseTier},
+			expectError: true,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mod, err := parseMutableParameters(tc.params, false)
+			if tc.expectError {
+				if err == nil {
+					t.Fatalf("expected error, got modification %+v", mod)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(mod, tc.expected) {
+				t.Errorf("got modification %+v, expected %+v", mod, tc.expected)
+			}
+		})
+	}
+}
+
+func TestModifyVolume(t *testing.T) {
+	cases := []struct {
+		name               string
+		volumeID           string
+		nfsExportOptions   []*file.NfsExportOptions
+		params             map[string]string
+		expectedLabels     map[string]string
+		expectedNfsRules   int
+		expectedFlavors    []string
+		expectedPerf       *file.PerformanceConfig
+		expectErr          codes.Code
+		expectTagsAttached bool
+	}{
+		{
+			name:           "labels are added to the labels of the instance",
+			volumeID:       testModifyVolumeID,
+			params:         map[string]string{ParameterKeyLabels: "team=storage"},
+			expectedLabels: map[string]string{tagKeyCreatedBy: "test-driver", "team": "storage"},
+		},
+		{
+			name:           "performance",
+			volumeID:       testModifyVolumeID,
+			params:         map[string]string{ParamMaxIopsPerTb: "17000"},
+			expectedLabels: map[string]string{tagKeyCreatedBy: "test-driver"},
+			expectedPerf:   &file.PerformanceConfig{MaxIopsPerTb: 17000},
+		},
+		{
+			name:             "NFS export options",
+			volumeID:         testModifyVolumeID,
+			params:           map[string]string{ParamNfsExportOptions: testNfsExportRule},
+			expectedLabels:   map[string]string{tagKeyCreatedBy: "test-driver"},
+			expectedNfsRules: 1,
+		},
+		{
+			name:     "NFS export options keep the Kerberos security flavor",
+			volumeID: testModifyVolumeID,
+			nfsExportOptions: []*file.NfsExportOptions{{
+				AccessMode:      "READ_WRITE",
+				SquashMode:      "ROOT_SQUASH",
+				SecurityFlavors: []string{"KRB5P"},
+			}},
+			params:           map[string]string{ParamNfsExportOptions: testNfsExportRule},
+			expectedLabels:   map[string]string{tagKeyCreatedBy: "test-driver"},
+			expectedNfsRules: 1,
+			expectedFlavors:  []string{"KRB5P"},
+		},
+		{
+			name:               "resource tags",
+			volumeID:           testModifyVolumeID,
+			params:             map[string]string{cloud.ParameterKeyResourceTags: "kubernetes/test1/test1"},
+			expectedLabels:     map[string]string{tagKeyCreatedBy: "test-driver"},
+			expectTagsAttached: true,
+		},
+		{
+			name:      "volume not found",
+			volumeID:  modeInstance + "/" + testZone + "/other/vol1",
+			params:    map[string]string{ParameterKeyLabels: "team=storage"},
+			expectErr: codes.NotFound,
+		},
+		{
+			name:      "invalid parameter",
+			volumeID:  testModifyVolumeID,
+			params:    map[string]string{paramTier: enterpriseTier},
+			expectErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			cs := initTestController(t).(*controllerServer)
+			cs.config.features.FeatureNFSExportOptionsOnCreate = &FeatureNFSExportOptionsOnCreate{Enabled: true}
+			tagManager := cs.config.tagManager.(*cloud.FakeTagServiceManager)
+			tagManager.On("AttachResourceTags", mock.Anything, cloud.FilestoreInstance, testModifyInstance, testZone, tc.volumeID, tc.params).Return(nil)
+			_, err := cs.config.fileService.CreateInstance(context.TODO(), &file.ServiceInstance{
+				Project:          testProject,
+				Location:         testZone,
+				Name:             testModifyInstance,
+				Tier:             defaultTier,
+				Volume:           file.Volume{Name: "vol1", SizeBytes: 1 * util.Tb},
+				Labels:           map[string]string{tagKeyCreatedBy: "test-driver"},
+				NfsExportOptions: tc.nfsExportOptions,
+			})
+			if err != nil {
+				t.Fatalf("failed to create instance: %v", err)
+			}
+
+			_, err = cs.ControllerModifyVolume(context.TODO(), &csi.ControllerModifyVolumeRequest{VolumeId: tc.volumeID, MutableParameters: tc.params})
+			if status.Code(err) != tc.expectErr {
+				t.Fatalf("expected error code %v, got %v", tc.expectErr, err)
+			}
+			if tc.expectErr != codes.OK {
+				return
+			}
+
+			instance, err := cs.config.fileService.GetInstance(context.TODO(), &file.ServiceInstance{Project: testProject, Location: testZone, Name: testModifyInstance})
+			if err != nil {
+				t.Fatalf("failed to get instance: %v", err)
+			}
+			if !reflect.DeepEqual(instance.Labels, tc.expectedLabels) {
+				t.Errorf("got labels %v, expected %v", instance.Labels, tc.expectedLabels)
+			}
+			if len(instance.NfsExportOptions) != tc.expectedNfsRules {
+				t.Errorf("got %d NFS export rules, expected %d", len(instance.NfsExportOptions), tc.expectedNfsRules)
+			}
+			for _, opt := range instance.NfsExportOptions {
+				if !reflect.DeepEqual(opt.SecurityFlavors, tc.expectedFlavors) {
+					t.Errorf("got NFS export rule security flavors %v, expected %v", opt.SecurityFlavors, tc.expectedFlavors)
+				}
+			}
+			if !reflect.DeepEqual(instance.PerformanceConfig, tc.expectedPerf) {
+				t.Errorf("got performance config %+v, expected %+v", instance.PerformanceConfig, tc.expectedPerf)
+			}
+			if instance.Volume.SizeBytes != 1*util.Tb {
+				t.Errorf("instance was resized to %d bytes", instance.Volume.SizeBytes)
+			}
+			if tc.expectTagsAttached {
+				tagManager.AssertCalled(t, "AttachResourceTags", mock.Anything, cloud.FilestoreInstance, testModifyInstance, testZone, tc.volumeID, tc.params)
+			} else {
+				tagManager.AssertNotCalled(t, "AttachResourceTags", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+			}
+		})
+	}
+}
+
+func TestMultishareModifyVolume(t *testing.T) {
+	volumeID := modeMultishare + "/" + testInstanceScPrefix + "/" + testProject + "/" + testLocation + "/" + testInstanceName + "/" + testShareName
+	cases := []struct {
+		name           string
+		params         map[string]string
+		expectedLabels map[string]string
+		expectedNfs    int
+		expectErr      codes.Code
+	}{
+		{
+			name:           "labels are added to the labels of the share",
+			params:         map[string]string{ParameterKeyLabels: "team=storage"},
+			expectedLabels: map[string]string{"existing": "label", "team": "storage"},
+		},
+		{
+			name:           "NFS export options",
+			params:         map[string]string{ParamNfsExportOptions: testNfsExportRule},
+			expectedLabels: map[string]string{"existing": "label"},
+			expectedNfs:    1,
+		},
+		{
+			name:      "performance of the instance",
+			params:    map[string]string{ParamMaxIops: "17000"},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:      "resource tags of the instance",
+			params:    map[string]string{cloud.ParameterKeyResourceTags: "kubernetes/test1/test1"},
+			expectErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := initTestMultishareController(t)
+			cs := &controllerServer{config: &controllerServerConfig{
+				multiShareController: m,
+				features: &GCFSDriverFeatureOptions{
+					FeatureStateful:                 &FeatureStateful{},
+					FeatureNFSExportOptionsOnCreate: &FeatureNFSExportOptionsOnCreate{Enabled: true},
+				},
+			}}
+			parent := &file.MultishareInstance{
+				Project:  testProject,
+				Location: testLocation,
+				Name:     testInstanceName,
+				Tier:     enterpriseTier,
+			}
+			if _, err := m.cloud.File.StartCreateMultishareInstanceOp(context.TODO(), parent); err != nil {
+				t.Fatalf("failed to create instance: %v", err)
+			}
+			share := &file.Share{
+				Name:          testShareName,
+				Parent:        parent,
+				Labels:        map[string]string{"existing": "label"},
+				CapacityBytes: 100 * util.Gb,
+			}
+			if _, err := m.cloud.File.StartCreateShareOp(context.TODO(), share); err != nil {
+				t.Fatalf("failed to create share: %v", err)
+			}
+
+			_, err := cs.ControllerModifyVolume(context.TODO(), &csi.ControllerModifyVolumeRequest{VolumeId: volumeID, MutableParameters: tc.params})
+			if status.Code(err) != tc.expectErr {
+				t.Fatalf("expected error code %v, got %v", tc.expectErr, err)
+			}
+			if tc.expectErr != codes.OK {
+				return
+			}
+			share, err = m.cloud.File.GetShare(context.TODO(), share)
+			if err != nil {
+				t.Fatalf("failed to get share: %v", err)
+			}
+			if !reflect.DeepEqual(share.Labels, tc.expectedLabels) {
+				t.Errorf("got labels %v, expected %v", share.Labels, tc.expectedLabels)
+			}
+			if len(share.NfsExportOptions) != tc.expectedNfs {
+				t.Errorf("got %d NFS export rules, expected %d", len(share.NfsExportOptions), tc.expectedNfs)
+			}
+		})
+	}
+}
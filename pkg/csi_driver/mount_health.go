@@ -0,0 +1,393 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	eventReasonMountUnhealthy = "FilestoreMountUnhealthy"
+	eventReasonMountRecovered = "FilestoreMountRecovered"
+	eventReasonRemountFailed  = "FilestoreRemountFailed"
+	eventReasonRelocated      = "FilestoreVolumeRelocated"
+
+	defaultMountProbeInterval = time.Minute
+	defaultMountProbeTimeout  = 10 * time.Second
+)
+
+var errMountNotResponding = errors.New("mount is not responding")
+
+// mountCondition is the last observed health of a staged volume.
+type mountCondition struct {
+	abnormal bool
+	message  string
+}
+
+// stagedVolume is a volume mounted at a staging path of the node, and bind mounted at its publish paths.
+type stagedVolume struct {
+	volumeID    string
+	stagingPath string
+	source      string
+	fstype      string
+	options     []string
+	// published maps the publish paths of the volume to their bind mount options.
+	published map[string][]string
+
+	condition mountCondition
+	// probeInFlight is set while a probe of the staging path is blocked on a hung mount, so that
+	// probes of a hung mount don't pile up. generation counts the remounts of the staging path, a
+	// probe of a previous mount doesn't clear probeInFlight.
+	probeInFlight bool
+	generation    int
+}
+
+// mountHealthMonitor periodically probes the staging paths of the volumes staged on the node, with a
+// bounded timeout, to detect the mounts left stale or hung by a failover or an unreachable instance.
+// The condition of a volume is reported by NodeGetVolumeStats, and its changes are recorded as events
+// of the node. With remount enabled, the staging path of an abnormal volume is lazily unmounted and
+// mounted again, and its publish paths are bind mounted again.
+//
+// Only the volumes staged or published since the driver started are monitored.
+type mountHealthMonitor struct {
+	mounter     mount.Interface
+	volumeLocks *util.VolumeLocks
+	nodeName    string
+	interval    time.Duration
+	timeout     time.Duration
+	remount     bool
+	recorder    record.EventRecorder
+
+	// For testing purposes
+	statPath    func(path string) error
+	lazyUnmount func(path string) error
+
+	mux     sync.Mutex
+	volumes map[string]*stagedVolume
+}
+
+func newMountHealthMonitor(mounter mount.Interface, volumeLocks *util.VolumeLocks, nodeName string, feature *FeatureMountHealthMonitor) *mountHealthMonitor {
+	m := &mountHealthMonitor{
+		mounter:     mounter,
+		volumeLocks: volumeLocks,
+		nodeName:    nodeName,
+		interval:    feature.ProbeInterval,
+		timeout:     feature.ProbeTimeout,
+		remount:     feature.Remount,
+		statPath:    statfsPath,
+		lazyUnmount: lazyUnmountPath,
+		volumes:     make(map[string]*stagedVolume),
+	}
+	if m.interval <= 0 {
+		m.interval = defaultMountProbeInterval
+	}
+	if m.timeout <= 0 {
+		m.timeout = defaultMountProbeTimeout
+	}
+	return m
+}
+
+// initEventRecorder records the mount health events of the node with an in-cluster client. The monitor
+// runs without events when the driver doesn't run in a cluster.
+func (m *mountHealthMonitor) initEventRecorder() {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		klog.Warningf("Mount health events are disabled, failed to get in-cluster config: %v", err)
+		return
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		klog.Warningf("Mount health events are disabled, failed to create kube client: %v", err)
+		return
+	}
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartRecordingToSink(&typedv1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	m.recorder = broadcaster.NewRecorder(scheme.Scheme, v1.EventSource{Component: "filestore-csi-node", Host: m.nodeName})
+}
+
+// Run probes the staged volumes until stopCh is closed.
+func (m *mountHealthMonitor) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting mount health monitor, probe interval %v, probe timeout %v, remount %v", m.interval, m.timeout, m.remount)
+	wait.Until(m.probeAll, m.interval, stopCh)
+}
+
+// stage records the mount of a volume at its staging path. The methods recording the mounts of a nil
+// monitor do nothing.
+func (m *mountHealthMonitor) stage(volumeID, stagingPath, source, fstype string, options []string) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if v, ok := m.volumes[volumeID]; ok && v.stagingPath == stagingPath {
+		v.source, v.fstype, v.options = source, fstype, options
+		return
+	}
+	m.volumes[volumeID] = &stagedVolume{
+		volumeID:    volumeID,
+		stagingPath: stagingPath,
+		source:      source,
+		fstype:      fstype,
+		options:     options,
+		published:   make(map[string][]string),
+	}
+}
+
+// unstage stops monitoring a volume.
+func (m *mountHealthMonitor) unstage(volumeID string) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	delete(m.volumes, volumeID)
+}
+
+// publish records the bind mount of a staged volume at a publish path.
+func (m *mountHealthMonitor) publish(volumeID, targetPath string, options []string) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if v, ok := m.volumes[volumeID]; ok {
+		v.published[targetPath] = options
+	}
+}
+
+// unpublish records the unmount of a publish path of a volume.
+func (m *mountHealthMonitor) unpublish(volumeID, targetPath string) {
+	if m == nil {
+		return
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	if v, ok := m.volumes[volumeID]; ok {
+		delete(v.published, targetPath)
+	}
+}
+
+// condition returns the last observed condition of a volume, and false if the volume is not monitored.
+func (m *mountHealthMonitor) condition(volumeID string) (mountCondition, bool) {
+	if m == nil {
+		return mountCondition{}, false
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	v, ok := m.volumes[volumeID]
+	if !ok {
+		return mountCondition{}, false
+	}
+	return v.condition, true
+}
+
+func (m *mountHealthMonitor) probeAll() {
+	m.mux.Lock()
+	volumeIDs := make([]string, 0, len(m.volumes))
+	for volumeID := range m.volumes {
+		volumeIDs = append(volumeIDs, volumeID)
+	}
+	m.mux.Unlock()
+
+	for _, volumeID := range volumeIDs {
+		m.probeVolume(volumeID)
+	}
+}
+
+// probeVolume probes the staging path of a volume, updates its condition, and remounts it if it is
+// abnormal and remount is enabled.
+func (m *mountHealthMonitor) probeVolume(volumeID string) {
+	m.mux.Lock()
+	v, ok := m.volumes[volumeID]
+	if !ok {
+		m.mux.Unlock()
+		return
+	}
+	stagingPath := v.stagingPath
+	m.mux.Unlock()
+
+	err := m.probe(v)
+	if err != nil && m.remount {
+		if remountErr := m.remountVolume(volumeID); remountErr != nil {
+			klog.Errorf("Remount of volume %v at staging path %s failed: %v", volumeID, stagingPath, remountErr.Error())
+			m.event(v1.EventTypeWarning, eventReasonRemountFailed, "Remount of Filestore volume %s at %s failed: %v", volumeID, stagingPath, remountErr.Error())
+		} else {
+			err = m.probe(v)
+		}
+	}
+
+	condition := mountCondition{message: "volume is healthy"}
+	if err != nil {
+		condition = mountCondition{abnormal: true, message: fmt.Sprintf("mount at %s is unhealthy: %v", stagingPath, err.Error())}
+	}
+
+	m.mux.Lock()
+	previous := v.condition
+	v.condition = condition
+	m.mux.Unlock()
+
+	switch {
+	case condition.abnormal && !previous.abnormal:
+		klog.Warningf("Volume %v: %s", volumeID, condition.message)
+		m.event(v1.EventTypeWarning, eventReasonMountUnhealthy, "Filestore volume %s: %s", volumeID, condition.message)
+	case !condition.abnormal && previous.abnormal:
+		klog.Infof("Volume %v mount at %s recovered", volumeID, stagingPath)
+		m.event(v1.EventTypeNormal, eventReasonMountRecovered, "Filestore volume %s mount at %s recovered", volumeID, stagingPath)
+	}
+}
+
+// probe stats the staging path of a volume within the probe timeout. A probe blocked on a hung mount is
+// left running, and the mount is reported as not responding until it returns.
+func (m *mountHealthMonitor) probe(v *stagedVolume) error {
+	m.mux.Lock()
+	if v.probeInFlight {
+		m.mux.Unlock()
+		return errMountNotResponding
+	}
+	v.probeInFlight = true
+	path, generation := v.stagingPath, v.generation
+	m.mux.Unlock()
+
+	done := make(chan error, 1)
+	go func() {
+		err := m.statPath(path)
+		m.mux.Lock()
+		if v.generation == generation {
+			v.probeInFlight = false
+		}
+		m.mux.Unlock()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if isStaleFileHandle(err) {
+			return fmt.Errorf("stale file handle: %w", err)
+		}
+		return err
+	case <-time.After(m.timeout):
+		return fmt.Errorf("%w within %v", errMountNotResponding, m.timeout)
+	}
+}
+
+// remountVolume lazily unmounts the staging path of a volume and mounts it again, then bind mounts its
+// publish paths again, the same way NodeStageVolume and NodePublishVolume mount them. The lazy unmount
+// doesn't block on the hung mount, which is released once no process uses it anymore.
+func (m *mountHealthMonitor) remountVolume(volumeID string) error {
+	if acquired := m.volumeLocks.TryAcquire(volumeID); !acquired {
+		return fmt.Errorf(util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer m.volumeLocks.Release(volumeID)
+
+	m.mux.Lock()
+	v, ok := m.volumes[volumeID]
+	if !ok {
+		m.mux.Unlock()
+		return nil
+	}
+	stagingPath, source, fstype := v.stagingPath, v.source, v.fstype
+	options := append([]string{}, v.options...)
+	published := make(map[string][]string, len(v.published))
+	for targetPath, publishOptions := range v.published {
+		published[targetPath] = publishOptions
+	}
+	m.mux.Unlock()
+
+	klog.Infof("Remounting volume %v at staging path %s", volumeID, stagingPath)
+	for targetPath := range published {
+		if err := m.lazyUnmount(targetPath); err != nil {
+			return fmt.Errorf("lazy unmount of publish path %s failed: %w", targetPath, err)
+		}
+	}
+	if err := m.lazyUnmount(stagingPath); err != nil {
+		return fmt.Errorf("lazy unmount of staging path %s failed: %w", stagingPath, err)
+	}
+	if err := m.mounter.Mount(source, stagingPath, fstype, options); err != nil {
+		return fmt.Errorf("mount of staging path %s failed: %w", stagingPath, err)
+	}
+	m.mux.Lock()
+	v.generation++
+	v.probeInFlight = false
+	m.mux.Unlock()
+	for targetPath, publishOptions := range published {
+		if err := m.mounter.Mount(stagedVolumePath(volumeID, stagingPath), targetPath, fstype, publishOptions); err != nil {
+			return fmt.Errorf("bind mount of publish path %s failed: %w", targetPath, err)
+		}
+	}
+	klog.Infof("Remounted volume %v at staging path %s and %d publish paths", volumeID, stagingPath, len(published))
+	return nil
+}
+
+// stagedSources returns the mount sources of the staged volumes by volume ID.
+func (m *mountHealthMonitor) stagedSources() map[string]string {
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	sources := make(map[string]string, len(m.volumes))
+	for volumeID, v := range m.volumes {
+		sources[volumeID] = v.source
+	}
+	return sources
+}
+
+// relocate remounts a staged volume from a new source, the share of a multishare volume migrated to
+// another instance. The previous source is kept if the remount fails, so that it is retried.
+func (m *mountHealthMonitor) relocate(volumeID, source string) error {
+	m.mux.Lock()
+	v, ok := m.volumes[volumeID]
+	if !ok {
+		m.mux.Unlock()
+		return nil
+	}
+	previous := v.source
+	v.source = source
+	m.mux.Unlock()
+
+	if err := m.remountVolume(volumeID); err != nil {
+		m.mux.Lock()
+		if v.source == source {
+			v.source = previous
+		}
+		m.mux.Unlock()
+		m.event(v1.EventTypeWarning, eventReasonRemountFailed, "Relocation of Filestore volume %s from %s to %s failed: %v", volumeID, previous, source, err.Error())
+		return err
+	}
+	m.event(v1.EventTypeNormal, eventReasonRelocated, "Filestore volume %s relocated from %s to %s", volumeID, previous, source)
+	return nil
+}
+
+func (m *mountHealthMonitor) event(eventtype, reason, messageFmt string, args ...interface{}) {
+	if m.recorder == nil {
+		return
+	}
+	node := &v1.ObjectReference{Kind: "Node", Name: m.nodeName, UID: types.UID(m.nodeName)}
+	m.recorder.Eventf(node, eventtype, reason, messageFmt, args...)
+}
@@ -0,0 +1,176 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"k8s.io/client-go/tools/record"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
+)
+
+type mountHealthTestEnv struct {
+	ns          *nodeServer
+	fm          *mount.FakeMounter
+	recorder    *record.FakeRecorder
+	stagingPath string
+	targetPath  string
+}
+
+// initMountHealthTestEnv stages and publishes the test volume on a node server running the mount health
+// monitor.
+func initMountHealthTestEnv(t *testing.T, remount bool) *mountHealthTestEnv {
+	mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{}}
+	metaService, err := metadata.NewFakeService()
+	if err != nil {
+		t.Fatalf("Failed to init metadata service")
+	}
+	features := &GCFSDriverFeatureOptions{
+		FeatureLockRelease: &FeatureLockRelease{},
+		FeatureMountHealthMonitor: &FeatureMountHealthMonitor{
+			Enabled:      true,
+			ProbeTimeout: 100 * time.Millisecond,
+			Remount:      remount,
+		},
+	}
+	server, err := newNodeServer(initTestDriver(t), mounter, metaService, features)
+	if err != nil {
+		t.Fatalf("Failed to create node server: %v", err)
+	}
+	ns := server.(*nodeServer)
+	recorder := record.NewFakeRecorder(10)
+	ns.mountHealth.recorder = recorder
+	ns.mountHealth.lazyUnmount = mounter.Unmount
+
+	tempDir := t.TempDir()
+	env := &mountHealthTestEnv{
+		ns:          ns,
+		fm:          mounter,
+		recorder:    recorder,
+		stagingPath: filepath.Join(tempDir, "staging"),
+		targetPath:  filepath.Join(tempDir, "mount"),
+	}
+	if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: env.stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     testVolumeAttributes,
+	}); err != nil {
+		t.Fatalf("Failed to stage volume: %v", err)
+	}
+	if _, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          testVolumeID,
+		TargetPath:        env.targetPath,
+		StagingTargetPath: env.stagingPath,
+		Readonly:          true,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     testVolumeAttributes,
+	}); err != nil {
+		t.Fatalf("Failed to publish volume: %v", err)
+	}
+	return env
+}
+
+func (env *mountHealthTestEnv) volumeCondition(t *testing.T) *csi.VolumeCondition {
+	resp, err := env.ns.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{
+		VolumeId:   testVolumeID,
+		VolumePath: env.targetPath,
+	})
+	if err != nil {
+		t.Fatalf("NodeGetVolumeStats failed: %v", err)
+	}
+	return resp.GetVolumeCondition()
+}
+
+func TestMountHealthMonitorHungProbes(t *testing.T) {
+	env := initMountHealthTestEnv(t, false)
+	hung := make(chan struct{})
+	var mux sync.Mutex
+	probes := 0
+	env.ns.mountHealth.statPath = func(path string) error {
+		mux.Lock()
+		probes++
+		mux.Unlock()
+		<-hung
+		return nil
+	}
+
+	for i := 0; i < 3; i++ {
+		env.ns.mountHealth.probeAll()
+	}
+	mux.Lock()
+	if probes != 1 {
+		t.Errorf("got %d probes of the hung mount, expected 1", probes)
+	}
+	mux.Unlock()
+	if condition := env.volumeCondition(t); !condition.GetAbnormal() {
+		t.Errorf("hung mount is not abnormal")
+	}
+
+	// The condition recovers once the hung probe returns.
+	close(hung)
+	err := pollUntil(func() bool {
+		env.ns.mountHealth.probeAll()
+		return !env.volumeCondition(t).GetAbnormal()
+	})
+	if err != nil {
+		t.Errorf("condition did not recover: %v", err)
+	}
+}
+
+func TestMountHealthMonitorUnstage(t *testing.T) {
+	env := initMountHealthTestEnv(t, false)
+	if _, err := env.ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: testVolumeID, TargetPath: env.targetPath}); err != nil {
+		t.Fatalf("Failed to unpublish volume: %v", err)
+	}
+	if _, err := env.ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: env.stagingPath}); err != nil {
+		t.Fatalf("Failed to unstage volume: %v", err)
+	}
+	if _, monitored := env.ns.mountHealth.condition(testVolumeID); monitored {
+		t.Errorf("unstaged volume is still monitored")
+	}
+	if _, err := os.Stat(env.stagingPath); err == nil {
+		t.Errorf("staging path %s was not cleaned up", env.stagingPath)
+	}
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// pollUntil polls condition for up to a second.
+func pollUntil(condition func() bool) error {
+	for i := 0; i < 100; i++ {
+		if condition() {
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return os.ErrDeadlineExceeded
+}
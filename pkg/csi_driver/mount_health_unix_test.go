@@ -0,0 +1,132 @@
+//go:build !windows
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+	mount "k8s.io/mount-utils"
+)
+
+func TestMountHealthMonitorCondition(t *testing.T) {
+	hung := make(chan struct{})
+	defer close(hung)
+	cases := []struct {
+		name            string
+		statErr         error
+		hang            bool
+		expectAbnormal  bool
+		expectedMessage string
+	}{
+		{
+			name: "healthy mount",
+		},
+		{
+			name:            "stale mount",
+			statErr:         unix.ESTALE,
+			expectAbnormal:  true,
+			expectedMessage: "stale file handle",
+		},
+		{
+			name:            "hung mount",
+			hang:            true,
+			expectAbnormal:  true,
+			expectedMessage: "not responding",
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := initMountHealthTestEnv(t, false)
+			env.ns.mountHealth.statPath = func(path string) error {
+				if path != env.stagingPath {
+					t.Errorf("probed path %s, expected staging path %s", path, env.stagingPath)
+				}
+				if tc.hang {
+					<-hung
+				}
+				return tc.statErr
+			}
+
+			env.ns.mountHealth.probeAll()
+			condition := env.volumeCondition(t)
+			if condition == nil {
+				t.Fatalf("no volume condition reported")
+			}
+			if condition.Abnormal != tc.expectAbnormal {
+				t.Errorf("got abnormal %v, expected %v: %s", condition.Abnormal, tc.expectAbnormal, condition.Message)
+			}
+			if !strings.Contains(condition.Message, tc.expectedMessage) {
+				t.Errorf("got message %q, expected it to contain %q", condition.Message, tc.expectedMessage)
+			}
+			if tc.expectAbnormal {
+				if event := <-env.recorder.Events; !strings.Contains(event, eventReasonMountUnhealthy) {
+					t.Errorf("got event %q, expected reason %s", event, eventReasonMountUnhealthy)
+				}
+			}
+		})
+	}
+}
+
+func TestMountHealthMonitorRemount(t *testing.T) {
+	env := initMountHealthTestEnv(t, true)
+	stale := true
+	env.ns.mountHealth.statPath = func(path string) error {
+		if stale {
+			return unix.ESTALE
+		}
+		return nil
+	}
+	env.ns.mountHealth.lazyUnmount = func(path string) error {
+		// The mount is not stale once the staging path is mounted again.
+		if path == env.stagingPath {
+			stale = false
+		}
+		return env.fm.Unmount(path)
+	}
+	env.fm.ResetLog()
+
+	env.ns.mountHealth.probeAll()
+	if condition := env.volumeCondition(t); condition.GetAbnormal() {
+		t.Errorf("remounted volume is abnormal: %s", condition.GetMessage())
+	}
+
+	expectedLog := []mount.FakeAction{
+		{Action: mount.FakeActionUnmount, Target: env.targetPath},
+		{Action: mount.FakeActionUnmount, Target: env.stagingPath},
+		{Action: mount.FakeActionMount, Source: testDevice, Target: env.stagingPath, FSType: "nfs"},
+		{Action: mount.FakeActionMount, Source: testDevice, Target: env.targetPath, FSType: "nfs"},
+	}
+	log := env.fm.GetLog()
+	if len(log) != len(expectedLog) {
+		t.Fatalf("got mount actions %+v, expected %+v", log, expectedLog)
+	}
+	for i := range log {
+		if log[i] != expectedLog[i] {
+			t.Errorf("got mount action %+v, expected %+v", log[i], expectedLog[i])
+		}
+	}
+	for _, mp := range env.fm.MountPoints {
+		if mp.Path == env.targetPath && !hasOption(mp.Opts, "ro") {
+			t.Errorf("publish path %s was bind mounted again without its options: %v", env.targetPath, mp.Opts)
+		}
+	}
+}
@@ -0,0 +1,206 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+)
+
+// protocolMountVersions are the NFS versions the volumes of a file protocol are mounted with.
+var protocolMountVersions = map[string]string{
+	v3FileProtocol:   "3",
+	v4_1FileProtocol: "4.1",
+}
+
+// isMultiWriterDeniedMountOption returns whether a mount option is denied for the volumes written by several
+// nodes. A soft mount returns errors to the applications on timeouts, which corrupts the files written
+// concurrently by other nodes, and local locks are not seen by the other nodes.
+func isMultiWriterDeniedMountOption(option string) bool {
+	switch mountOptionName(option) {
+	case "soft", "softerr", "nolock":
+		return true
+	case "local_lock":
+		return mountOptionValue(option) != "none"
+	}
+	return false
+}
+
+// mountPolicyConfig is the configuration file of the mount policy of the node driver.
+type mountPolicyConfig struct {
+	// Defaults are the default mount options of the volumes, by tier and protocol.
+	Defaults []mountOptionDefaults `json:"defaults,omitempty"`
+	// DeniedOptions are the mount options denied for all the volumes, in addition to the built-in ones.
+	DeniedOptions []string `json:"deniedOptions,omitempty"`
+}
+
+// mountOptionDefaults are the default mount options of the volumes of a tier and protocol. An empty tier
+// or protocol matches all the volumes. The options of all the matching defaults are added in order to the
+// options of a volume that are not set yet, so the most specific defaults should be listed first.
+type mountOptionDefaults struct {
+	Tier     string   `json:"tier,omitempty"`
+	Protocol string   `json:"protocol,omitempty"`
+	Options  []string `json:"options"`
+}
+
+// mountPolicy validates the mount options of the volumes and adds the default ones. A nil policy keeps
+// the mount options unchanged.
+type mountPolicy struct {
+	defaults      []mountOptionDefaults
+	deniedOptions map[string]bool
+}
+
+func newMountPolicy(configFile string) (*mountPolicy, error) {
+	p := &mountPolicy{deniedOptions: map[string]bool{}}
+	if configFile == "" {
+		return p, nil
+	}
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &mountPolicyConfig{}
+	if err := json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse mount policy %s: %w", configFile, err)
+	}
+	for _, d := range config.Defaults {
+		d.Tier = strings.ToLower(d.Tier)
+		if d.Protocol != "" {
+			if _, ok := protocolMountVersions[d.Protocol]; !ok {
+				return nil, fmt.Errorf("invalid protocol %q of default mount options, must be one of %q or %q", d.Protocol, v3FileProtocol, v4_1FileProtocol)
+			}
+		}
+		if err := validateMountOptions(d.Options); err != nil {
+			return nil, fmt.Errorf("invalid default mount options %v: %w", d.Options, err)
+		}
+		p.defaults = append(p.defaults, d)
+	}
+	for _, option := range config.DeniedOptions {
+		p.deniedOptions[mountOptionName(option)] = true
+	}
+	return p, nil
+}
+
+// apply returns the mount options of a volume with the volume attributes attr, or an error if an option of
+// the volume is denied or conflicts with another option or with the volume. The NFS version is set from
+// protocol, the file protocol of the volume, unless empty, followed by the default options of the tier
+// and protocol of the volume.
+func (p *mountPolicy) apply(options []string, attr map[string]string, protocol string, vc *csi.VolumeCapability) ([]string, error) {
+	if p == nil {
+		return options, nil
+	}
+	if _, ok := protocolMountVersions[protocol]; !ok {
+		protocol = ""
+	}
+	if err := validateMountOptions(options); err != nil {
+		return nil, err
+	}
+	set := map[string]string{}
+	for _, option := range options {
+		if p.deniedOptions[mountOptionName(option)] {
+			return nil, fmt.Errorf("mount option %q is denied by the mount policy", option)
+		}
+		if isMultiWriterAccessMode(vc) && isMultiWriterDeniedMountOption(option) {
+			return nil, fmt.Errorf("mount option %q is denied for volumes written by several nodes", option)
+		}
+		set[mountOptionKey(option)] = mountOptionValue(option)
+	}
+
+	if version, ok := set["vers"]; ok && protocol != "" && version != protocolMountVersions[protocol] {
+		return nil, fmt.Errorf("mount option vers=%s conflicts with the %s protocol of the volume", version, protocol)
+	}
+	if _, ok := set["port"]; ok && strings.ToLower(attr[attrEncryptionInTransit]) == "true" {
+		return nil, fmt.Errorf("mount option port conflicts with the TLS tunnel of the volume encrypted in transit")
+	}
+	if flavor, ok := set["sec"]; ok && attr[attrSecurityFlavor] != "" && flavor != strings.ToLower(attr[attrSecurityFlavor]) {
+		return nil, fmt.Errorf("mount option sec=%s conflicts with the %s security flavor of the volume", flavor, attr[attrSecurityFlavor])
+	}
+
+	policyOptions := append([]string{}, options...)
+	if _, ok := set["vers"]; !ok && protocol != "" {
+		policyOptions = append(policyOptions, "vers="+protocolMountVersions[protocol])
+		set["vers"] = protocolMountVersions[protocol]
+	}
+	tier := strings.ToLower(attr[attrTier])
+	for _, d := range p.defaults {
+		if (d.Tier != "" && d.Tier != tier) || (d.Protocol != "" && d.Protocol != protocol) {
+			continue
+		}
+		for _, option := range d.Options {
+			key := mountOptionKey(option)
+			if _, ok := set[key]; ok {
+				continue
+			}
+			policyOptions = append(policyOptions, option)
+			set[key] = mountOptionValue(option)
+		}
+	}
+	return policyOptions, nil
+}
+
+// validateMountOptions checks that options don't set an option twice to different values.
+func validateMountOptions(options []string) error {
+	set := map[string]string{}
+	for _, option := range options {
+		key, value := mountOptionKey(option), mountOptionValue(option)
+		if previous, ok := set[key]; ok && previous != value {
+			return fmt.Errorf("mount option %q conflicts with another option", option)
+		}
+		set[key] = value
+	}
+	return nil
+}
+
+// mountOptionName returns the name of a mount option, without its value.
+func mountOptionName(option string) string {
+	return strings.SplitN(option, "=", 2)[0]
+}
+
+// mountOptionKey returns the key of a mount option, the same for the options overriding each other.
+func mountOptionKey(option string) string {
+	name := mountOptionName(option)
+	switch name {
+	case "hard", "soft", "softerr":
+		return "recovery"
+	case "nfsvers":
+		return "vers"
+	}
+	if !strings.Contains(option, "=") {
+		return strings.TrimPrefix(name, "no")
+	}
+	return name
+}
+
+// mountOptionValue returns the value of a mount option, the option itself for flags.
+func mountOptionValue(option string) string {
+	if parts := strings.SplitN(option, "=", 2); len(parts) == 2 {
+		return parts[1]
+	}
+	return option
+}
+
+func isMultiWriterAccessMode(vc *csi.VolumeCapability) bool {
+	switch vc.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER:
+		return true
+	}
+	return false
+}
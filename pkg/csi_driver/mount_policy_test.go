@@ -0,0 +1,241 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
+)
+
+const testMountPolicyConfig = `{
+  "defaults": [
+    {"tier": "enterprise", "protocol": "NFS_V4_1", "options": ["nconnect=4"]},
+    {"options": ["hard", "rsize=1048576", "wsize=1048576"]}
+  ],
+  "deniedOptions": ["nosharecache"]
+}`
+
+func newTestMountPolicy(t *testing.T) *mountPolicy {
+	configFile := filepath.Join(t.TempDir(), "mount-policy.json")
+	if err := os.WriteFile(configFile, []byte(testMountPolicyConfig), 0644); err != nil {
+		t.Fatalf("Failed to write mount policy: %v", err)
+	}
+	p, err := newMountPolicy(configFile)
+	if err != nil {
+		t.Fatalf("Failed to load mount policy: %v", err)
+	}
+	return p
+}
+
+func mountPolicyCapability(mode csi.VolumeCapability_AccessMode_Mode, flags ...string) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{MountFlags: flags},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+func TestMountPolicyApply(t *testing.T) {
+	p := newTestMountPolicy(t)
+	cases := []struct {
+		name      string
+		options   []string
+		attr      map[string]string
+		protocol  string
+		mode      csi.VolumeCapability_AccessMode_Mode
+		expected  []string
+		expectErr bool
+	}{
+		{
+			name:     "defaults",
+			protocol: v3FileProtocol,
+			mode:     csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			expected: []string{"vers=3", "hard", "rsize=1048576", "wsize=1048576"},
+		},
+		{
+			name:     "tier and protocol defaults",
+			attr:     map[string]string{attrTier: enterpriseTier},
+			protocol: v4_1FileProtocol,
+			mode:     csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			expected: []string{"vers=4.1", "nconnect=4", "hard", "rsize=1048576", "wsize=1048576"},
+		},
+		{
+			name:     "options of the volume override the defaults",
+			options:  []string{"rsize=65536", "nconnect=2"},
+			attr:     map[string]string{attrTier: enterpriseTier},
+			protocol: v4_1FileProtocol,
+			mode:     csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			expected: []string{"rsize=65536", "nconnect=2", "vers=4.1", "hard", "wsize=1048576"},
+		},
+		{
+			name:     "version of the volume without protocol",
+			options:  []string{"nfsvers=4.1"},
+			mode:     csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expected: []string{"nfsvers=4.1", "hard", "rsize=1048576", "wsize=1048576"},
+		},
+		{
+			name:     "soft mount of a single writer",
+			options:  []string{"soft"},
+			mode:     csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expected: []string{"soft", "rsize=1048576", "wsize=1048576"},
+		},
+		{
+			name:      "soft mount of multiple writers",
+			options:   []string{"soft"},
+			mode:      csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER,
+			expectErr: true,
+		},
+		{
+			name:      "local locks of multiple writers",
+			options:   []string{"local_lock=all"},
+			mode:      csi.VolumeCapability_AccessMode_MULTI_NODE_SINGLE_WRITER,
+			expectErr: true,
+		},
+		{
+			name:      "denied option",
+			options:   []string{"nosharecache"},
+			mode:      csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expectErr: true,
+		},
+		{
+			name:      "version conflicting with the protocol",
+			options:   []string{"vers=3"},
+			protocol:  v4_1FileProtocol,
+			mode:      csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expectErr: true,
+		},
+		{
+			name:      "conflicting options",
+			options:   []string{"hard", "soft"},
+			mode:      csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expectErr: true,
+		},
+		{
+			name:      "port of a volume encrypted in transit",
+			options:   []string{"port=2049"},
+			attr:      map[string]string{attrEncryptionInTransit: "true"},
+			protocol:  v4_1FileProtocol,
+			mode:      csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expectErr: true,
+		},
+		{
+			name:      "security flavor conflicting with the volume",
+			options:   []string{"sec=sys"},
+			attr:      map[string]string{attrSecurityFlavor: securityFlavorKrb5p},
+			protocol:  v4_1FileProtocol,
+			mode:      csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			options, err := p.apply(tc.options, tc.attr, tc.protocol, mountPolicyCapability(tc.mode))
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got options %v", options)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(options, tc.expected) {
+				t.Errorf("got options %v, expected %v", options, tc.expected)
+			}
+		})
+	}
+
+	var disabled *mountPolicy
+	if options, err := disabled.apply([]string{"soft"}, nil, v3FileProtocol, mountPolicyCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER)); err != nil || !reflect.DeepEqual(options, []string{"soft"}) {
+		t.Errorf("got options %v, error %v of disabled policy, expected the options unchanged", options, err)
+	}
+}
+
+func TestNewMountPolicyInvalid(t *testing.T) {
+	for _, config := range []string{
+		`{"defaults": [`,
+		`{"defaults": [{"protocol": "NFS_V4_2", "options": ["hard"]}]}`,
+		`{"defaults": [{"options": ["hard", "soft"]}]}`,
+	} {
+		configFile := filepath.Join(t.TempDir(), "mount-policy.json")
+		if err := os.WriteFile(configFile, []byte(config), 0644); err != nil {
+			t.Fatalf("Failed to write mount policy: %v", err)
+		}
+		if _, err := newMountPolicy(configFile); err == nil {
+			t.Errorf("loaded invalid mount policy %s", config)
+		}
+	}
+}
+
+func TestNodeStageMountPolicy(t *testing.T) {
+	mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{}}
+	metaService, err := metadata.NewFakeService()
+	if err != nil {
+		t.Fatalf("Failed to init metadata service")
+	}
+	ns, err := newNodeServer(initTestDriver(t), mounter, metaService, &GCFSDriverFeatureOptions{
+		FeatureLockRelease:  &FeatureLockRelease{},
+		FeatureNFSv4Support: &FeatureNFSv4Support{Enabled: true},
+		FeatureMountPolicy:  &FeatureMountPolicy{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create node server: %v", err)
+	}
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+	attributes := map[string]string{
+		attrIP:           "1.1.1.1",
+		attrVolume:       "test-volume",
+		attrFileProtocol: v4_1FileProtocol,
+	}
+
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  mountPolicyCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, "soft"),
+		VolumeContext:     attributes,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v staging a soft mount of multiple writers, expected InvalidArgument", err)
+	}
+	validateMountPoint(t, "denied mount options", mounter, nil)
+
+	// The NFS version of the volume is set from its protocol.
+	if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  mountPolicyCapability(csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER, "nconnect=4"),
+		VolumeContext:     attributes,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	validateMountPoint(t, "mount policy", mounter, &mount.MountPoint{
+		Device: testDevice,
+		Path:   stagingPath,
+		Type:   "nfs",
+		Opts:   []string{"nconnect=4", "vers=4.1"},
+	})
+}
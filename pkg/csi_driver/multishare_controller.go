@@ -153,6 +153,14 @@ func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.Create
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	if _, err := placementPolicyFromParams(req.GetParameters()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, param := range []string{paramMinSpareInstances, paramSpareInstanceIdlePeriod} {
+		if _, ok := req.GetParameters()[param]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "parameter %q requires the stateful multishare controller", param)
+		}
+	}
 
 	var reqBytes int64
 	if m.featureMaxSharePerInstance {
@@ -196,7 +204,10 @@ func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.Create
 
 	if share != nil {
 		resp, err := m.getShareAndGenerateCSICreateVolumeResponse(ctx, instanceScPrefix, share, maxShareSizeSizeBytes)
-		return resp, file.StatusError(err)
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+		return m.finishCreateVolume(ctx, req, resp)
 	}
 
 	// lock released. poll for op.
@@ -208,7 +219,10 @@ func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.Create
 	klog.Infof("Poll for operation %s (type %s) completed", workflow.opName, workflow.opType.String())
 	if workflow.opType == util.ShareCreate {
 		resp, err := m.getShareAndGenerateCSICreateVolumeResponse(ctx, instanceScPrefix, workflow.share, maxShareSizeSizeBytes)
-		return resp, file.StatusError(err)
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+		return m.finishCreateVolume(ctx, req, resp)
 	}
 
 	var shareCreateWorkflow *Workflow
@@ -233,7 +247,10 @@ func (m *MultishareController) CreateVolume(ctx context.Context, req *csi.Create
 		return nil, common.NewTemporaryError(codes.Unavailable, fmt.Errorf("%v operation %q poll error: %w", shareCreateWorkflow.opType, shareCreateWorkflow.opName, err))
 	}
 	resp, err := m.getShareAndGenerateCSICreateVolumeResponse(ctx, instanceScPrefix, newShare, maxShareSizeSizeBytes)
-	return resp, file.StatusError(err)
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	return m.finishCreateVolume(ctx, req, resp)
 }
 
 func (m *MultishareController) CreateSnapshot(ctx context.Context, req *csi.CreateSnapshotRequest) (*csi.CreateSnapshotResponse, error) {
@@ -254,11 +271,19 @@ func (m *MultishareController) CreateSnapshot(ctx context.Context, req *csi.Crea
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
 	}
+	// Filestore snapshots are taken of a whole instance, which is shared by many multishare volumes.
+	if util.GetSnapshotType(req.GetParameters()) != util.VolumeSnapshotTypeBackup {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume snapshot type %q not supported for multishare volumes", util.GetSnapshotType(req.GetParameters()))
+	}
 	_, location, instanceName, shareName, err := parseSourceVolId(volumeID)
 	if err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
 	project := m.cloud.Project
+	copyLocations := util.GetBackupCopyLocations(req.GetParameters())
+	if err := validateBackupCopyLocations(copyLocations); err != nil {
+		return nil, err
+	}
 
 	backupLocation := util.GetBackupLocation(req.GetParameters()) //Optional provided locaiton for cross-region backups
 	backupURI, backupRegion, err := file.CreateBackupURI(location, project, name, backupLocation)
@@ -273,6 +298,15 @@ func (m *MultishareController) CreateSnapshot(ctx context.Context, req *csi.Crea
 		return nil, file.StatusError(err)
 	}
 
+	backupInfo := &file.BackupInfo{
+		Name:               name,
+		SourceVolumeId:     volumeID,
+		Project:            project,
+		Location:           backupRegion,
+		SourceShare:        shareName,
+		SourceInstanceName: instanceName,
+		BackupURI:          backupURI,
+	}
 	var snapshotResponse *csi.CreateSnapshotResponse
 	if backupExists {
 		// process existing backup
@@ -286,21 +320,11 @@ func (m *MultishareController) CreateSnapshot(ctx context.Context, req *csi.Crea
 		}
 	} else {
 		//no existing backup
-		backupInfo := &file.BackupInfo{
-			Name:               name,
-			SourceVolumeId:     volumeID,
-			Project:            project,
-			Location:           backupRegion,
-			SourceShare:        shareName,
-			SourceInstanceName: instanceName,
-			BackupURI:          backupURI,
-		}
-
 		labels, err := extractBackupLabels(req.GetParameters(), m.extraVolumeLabels, m.driver.config.Name, req.Name)
 		if err != nil {
 			return nil, err
 		}
-		backupInfo.Labels = labels
+		backupInfo.Labels = withBackupCopiesLabel(labels, copyLocations)
 
 		snapshot, err := m.createNewBackup(ctx, backupInfo)
 		if err != nil {
@@ -312,6 +336,26 @@ func (m *MultishareController) CreateSnapshot(ctx context.Context, req *csi.Crea
 		}
 	}
 
+	if len(copyLocations) > 0 {
+		// The labels of an existing backup are only processed to take its missing copies.
+		if backupInfo.Labels == nil {
+			labels, err := extractBackupLabels(req.GetParameters(), m.extraVolumeLabels, m.driver.config.Name, req.Name)
+			if err != nil {
+				return nil, err
+			}
+			backupInfo.Labels = labels
+		}
+		copyRegions, err := createBackupCopies(ctx, m.cloud.File, backupInfo, copyLocations)
+		if err != nil {
+			return nil, err
+		}
+		for _, region := range copyRegions {
+			if err := m.tagManager.AttachResourceTags(ctx, cloud.FilestoreBackUp, backupInfo.Name, region, req.GetName(), req.GetParameters()); err != nil {
+				return nil, status.Error(codes.Unavailable, err.Error())
+			}
+		}
+	}
+
 	if err := m.tagManager.AttachResourceTags(ctx, cloud.FilestoreBackUp, name, backupRegion, req.GetName(), req.GetParameters()); err != nil {
 		return nil, status.Error(codes.Unavailable, err.Error())
 	}
@@ -574,6 +618,7 @@ func (m *MultishareController) generateNewMultishareInstance(instanceName string
 	connectMode := directPeering
 	kmsKeyName := ""
 	fileProtocol := ""
+	encryptionInTransit := false
 	for k, v := range req.GetParameters() {
 		switch strings.ToLower(k) {
 		case paramTier:
@@ -595,6 +640,11 @@ func (m *MultishareController) generateNewMultishareInstance(instanceName string
 			continue
 		case paramFileProtocol:
 			fileProtocol = v
+		case paramEncryptionInTransit:
+			encryptionInTransit, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, status.Errorf(codes.InvalidArgument, "invalid %s %q: %v", paramEncryptionInTransit, v, err)
+			}
 		// Ignore the cidr flag as it is not passed to the cloud provider
 		// It will be used to get unreserved IP in the reserveIPV4Range function
 		// ignore IPRange flag as it will be handled at the same place as cidr
@@ -604,6 +654,8 @@ func (m *MultishareController) generateNewMultishareInstance(instanceName string
 			continue
 		case paramMaxVolumeSize:
 			continue
+		case paramPlacementPolicy:
+			continue
 		case cloud.ParameterKeyResourceTags:
 			continue
 		case ParameterKeyLabels, ParameterKeyPVCName, ParameterKeyPVCNamespace, ParameterKeyPVName, paramMultishare:
@@ -632,6 +684,9 @@ func (m *MultishareController) generateNewMultishareInstance(instanceName string
 	if fileProtocol == "" {
 		fileProtocol = v3FileProtocol
 	}
+	if encryptionInTransit && fileProtocol != v4_1FileProtocol {
+		return nil, status.Errorf(codes.InvalidArgument, "%s requires the %s protocol", paramEncryptionInTransit, v4_1FileProtocol)
+	}
 
 	f := &file.MultishareInstance{
 		Project:       m.cloud.Project,
@@ -660,7 +715,7 @@ func (m *MultishareController) checkVolumeContentSource(ctx context.Context, req
 			return "", status.Error(codes.InvalidArgument, "Multishare backed volumes do not support volume content source")
 		}
 		if req.GetVolumeContentSource().GetVolume() != nil {
-			return "", status.Error(codes.InvalidArgument, "Unsupported volume content source type \"volume\"")
+			return m.checkCloneSource(ctx, req)
 		}
 
 		if req.GetVolumeContentSource().GetSnapshot() != nil {
@@ -669,18 +724,123 @@ func (m *MultishareController) checkVolumeContentSource(ctx context.Context, req
 			if err != nil || !isBackupSource {
 				return "", status.Errorf(codes.InvalidArgument, "Unsupported volume content source %v", id)
 			}
-			_, err = m.cloud.File.GetBackup(ctx, id)
+			region, err := m.pickRegion(req.GetAccessibilityRequirements())
+			if err != nil {
+				return "", status.Error(codes.InvalidArgument, err.Error())
+			}
+			backupSource, err := backupRestoreSource(ctx, m.cloud.File, id, region)
 			if err != nil {
 				klog.Errorf("Failed to get volume %v source snapshot %v: %v", req.GetName(), id, err.Error())
-				return "", file.StatusError(err)
+				return "", err
 			}
-			return id, nil
+			return backupSource, nil
 		}
 	}
 	return "", nil
 
 }
 
+// checkCloneSource checks that the source volume of a clone is an existing multishare volume, and takes the
+// transient backup the new share is restored from, unless the new share already exists.
+func (m *MultishareController) checkCloneSource(ctx context.Context, req *csi.CreateVolumeRequest) (string, error) {
+	sourceVolumeID := req.GetVolumeContentSource().GetVolume().GetVolumeId()
+	backupInfo, err := m.cloneBackupInfo(req)
+	if err != nil {
+		return "", err
+	}
+
+	// The transient backup is deleted once the new share is ready, don't take it again when CreateVolume is retried.
+	// Only the regions the new share can be placed in are searched for it.
+	regions, err := m.opsManager.listRegions(req.GetAccessibilityRequirements())
+	if err != nil {
+		return "", status.Error(codes.InvalidArgument, err.Error())
+	}
+	shareName := util.ConvertVolToShareName(req.GetName())
+	for _, region := range regions {
+		shares, err := m.cloud.File.ListShares(ctx, &file.ListFilter{Project: m.cloud.Project, Location: region, InstanceName: "-"})
+		if err != nil {
+			return "", file.StatusError(err)
+		}
+		for _, share := range shares {
+			if share.Name == shareName {
+				return "", nil
+			}
+		}
+	}
+
+	sourceShare, err := m.cloud.File.GetShare(ctx, &file.Share{
+		Parent: &file.MultishareInstance{
+			Project:  backupInfo.Project,
+			Location: backupInfo.SourceVolumeLocation(),
+			Name:     backupInfo.SourceInstanceName,
+		},
+		Name: backupInfo.SourceShare,
+	})
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return "", status.Errorf(codes.NotFound, "source volume %v doesn't exist", sourceVolumeID)
+		}
+		return "", file.StatusError(err)
+	}
+	if limitBytes := req.GetCapacityRange().GetLimitBytes(); limitBytes > 0 && sourceShare.CapacityBytes > limitBytes {
+		return "", status.Errorf(codes.OutOfRange, "capacity limit %v is smaller than source volume %v capacity %v", limitBytes, sourceVolumeID, sourceShare.CapacityBytes)
+	}
+
+	labels, err := extractLabels(req.GetParameters(), m.extraVolumeLabels, m.driver.config.Name)
+	if err != nil {
+		return "", err
+	}
+	backupInfo.Labels = labels
+	if err := getOrCreateCloneBackup(ctx, m.cloud.File, backupInfo, modeMultishare); err != nil {
+		return "", err
+	}
+	return backupInfo.BackupURI, nil
+}
+
+// cloneBackupInfo generates the info of the transient backup taken to clone the source volume of the request.
+// The backup is named after the new volume, in the region of the source volume.
+func (m *MultishareController) cloneBackupInfo(req *csi.CreateVolumeRequest) (*file.BackupInfo, error) {
+	sourceVolumeID := req.GetVolumeContentSource().GetVolume().GetVolumeId()
+	if !isMultishareVolId(sourceVolumeID) {
+		return nil, status.Errorf(codes.InvalidArgument, "Unsupported volume content source %v, only multishare volumes can be cloned into multishare volumes", sourceVolumeID)
+	}
+	_, project, location, instanceName, shareName, err := parseMultishareVolId(sourceVolumeID)
+	if err != nil {
+		return nil, status.Errorf(codes.NotFound, "source volume %v doesn't exist: %v", sourceVolumeID, err)
+	}
+	backupURI, region, err := file.CreateBackupURI(location, project, req.GetName(), "")
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	return &file.BackupInfo{
+		Name:               req.GetName(),
+		SourceVolumeId:     fmt.Sprintf("%s/%s/%s/%s", modeMultishare, location, instanceName, shareName),
+		Project:            project,
+		Location:           region,
+		SourceShare:        shareName,
+		SourceInstanceName: instanceName,
+		BackupURI:          backupURI,
+	}, nil
+}
+
+// finishCreateVolume deletes the transient backup taken to clone the source volume of the request, if any, once
+// the new share is ready.
+func (m *MultishareController) finishCreateVolume(ctx context.Context, req *csi.CreateVolumeRequest, resp *csi.CreateVolumeResponse) (*csi.CreateVolumeResponse, error) {
+	if req.GetVolumeContentSource().GetVolume() == nil {
+		return resp, nil
+	}
+	backupInfo, err := m.cloneBackupInfo(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := deleteCloneBackup(ctx, m.cloud.File, backupInfo.BackupURI); err != nil {
+		return nil, err
+	}
+	// The transient backup of a clone is not a snapshot visible to the CO.
+	resp.Volume.ContentSource = req.GetVolumeContentSource()
+	return resp, nil
+}
+
 func generateNewShare(name string, parent *file.MultishareInstance, req *csi.CreateVolumeRequest, sourceSnapshotId string) (*file.Share, error) {
 	if parent == nil {
 		return nil, status.Error(codes.Internal, "parent multishare instance is empty")
@@ -720,15 +880,7 @@ func (m *MultishareController) pickRegion(top *csi.TopologyRequirement) (string,
 		return region, nil
 	}
 
-	zone, err := pickZoneFromTopology(top)
-	if err != nil {
-		return "", err
-	}
-	region, err := util.GetRegionFromZone(zone)
-	if err != nil {
-		return "", err
-	}
-	return region, nil
+	return pickRegionFromTopology(top)
 }
 
 func extractInstanceLabels(parameters, cliLabels map[string]string, driverName, clusterName, location string) (map[string]string, error) {
@@ -823,18 +975,33 @@ func getShareRequestCapacity(capRange *csi.CapacityRange, minShareSizeBytes, max
 }
 
 func (m *MultishareController) generateCSICreateVolumeResponse(instancePrefix string, s *file.Share, maxShareSizeBytes int64) (*csi.CreateVolumeResponse, error) {
+	volume, err := m.shareToCSIVolume(instancePrefix, s)
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &csi.CreateVolumeResponse{
+		Volume: volume,
+	}
+	if m.featureMaxSharePerInstance {
+		resp.Volume.VolumeContext[attrMaxShareSize] = strconv.Itoa(int(maxShareSizeBytes))
+	}
+	klog.Infof("CreateVolume resp: %+v", resp)
+	return resp, nil
+}
+
+// shareToCSIVolume generates a CSI volume spec from the multishare share.
+func (m *MultishareController) shareToCSIVolume(instancePrefix string, s *file.Share) (*csi.Volume, error) {
 	volId, err := generateMultishareVolumeIdFromShare(instancePrefix, s)
 	if err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
 
-	resp := &csi.CreateVolumeResponse{
-		Volume: &csi.Volume{
-			VolumeId:      volId,
-			CapacityBytes: s.CapacityBytes,
-			VolumeContext: map[string]string{
-				attrIP: s.Parent.Network.Ip,
-			},
+	volume := &csi.Volume{
+		VolumeId:      volId,
+		CapacityBytes: s.CapacityBytes,
+		VolumeContext: map[string]string{
+			attrIP: s.Parent.Network.Ip,
 		},
 	}
 	if s.BackupId != "" {
@@ -845,22 +1012,116 @@ func (m *MultishareController) generateCSICreateVolumeResponse(instancePrefix st
 				},
 			},
 		}
-		resp.Volume.ContentSource = contentSource
+		volume.ContentSource = contentSource
 	}
 	if m.driver.config.FeatureOptions.FeatureLockRelease.Enabled {
-		resp.Volume.VolumeContext[attrSupportLockRelease] = "true"
-	}
-	if m.featureMaxSharePerInstance {
-		resp.Volume.VolumeContext[attrMaxShareSize] = strconv.Itoa(int(maxShareSizeBytes))
+		volume.VolumeContext[attrSupportLockRelease] = "true"
 	}
 
 	if s.Parent.Protocol == v4_1FileProtocol {
-		resp.Volume.VolumeContext[attrFileProtocol] = v4_1FileProtocol
+		volume.VolumeContext[attrFileProtocol] = v4_1FileProtocol
 	} else {
-		resp.Volume.VolumeContext[attrFileProtocol] = v3FileProtocol
+		volume.VolumeContext[attrFileProtocol] = v3FileProtocol
 	}
-	klog.Infof("CreateVolume resp: %+v", resp)
-	return resp, nil
+	if features := m.driver.config.FeatureOptions; features.FeatureVolumeTopology != nil && features.FeatureVolumeTopology.Enabled {
+		volume.AccessibleTopology = instanceAccessibleTopology(s.Parent.Location, s.Parent.Network.ConnectMode)
+	}
+	if features := m.driver.config.FeatureOptions; features.FeatureMountPolicy != nil && features.FeatureMountPolicy.Enabled {
+		volume.VolumeContext[attrTier] = strings.ToLower(s.Parent.Tier)
+	}
+	return volume, nil
+}
+
+// ListVolumes returns an entry for every share hosted on a multishare instance provisioned by the driver.
+// Instances without a storage class label are skipped, since the volume id of their shares cannot be generated.
+func (m *MultishareController) ListVolumes(ctx context.Context) ([]*csi.ListVolumesResponse_Entry, error) {
+	instances, err := m.cloud.File.ListMultishareInstances(ctx, &file.ListFilter{Project: m.cloud.Project, Location: "-"})
+	if err != nil {
+		return nil, err
+	}
+	instanceMap := make(map[string]*file.MultishareInstance)
+	for _, instance := range instances {
+		if instance.Labels[util.ParamMultishareInstanceScLabelKey] == "" {
+			continue
+		}
+		instanceHandle, err := file.GetMultishareInstanceHandle(instance)
+		if err != nil {
+			return nil, err
+		}
+		instanceMap[instanceHandle] = instance
+	}
+
+	shares, err := m.cloud.File.ListShares(ctx, &file.ListFilter{Project: m.cloud.Project, Location: "-", InstanceName: "-"})
+	if err != nil {
+		return nil, err
+	}
+	var entries []*csi.ListVolumesResponse_Entry
+	for _, share := range shares {
+		instanceHandle, err := file.GetMultishareInstanceHandle(share.Parent)
+		if err != nil {
+			return nil, err
+		}
+		instance, ok := instanceMap[instanceHandle]
+		if !ok {
+			continue
+		}
+		share.Parent = instance
+		volume, err := m.shareToCSIVolume(instance.Labels[util.ParamMultishareInstanceScLabelKey], share)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, &csi.ListVolumesResponse_Entry{
+			Volume: volume,
+			Status: &csi.ListVolumesResponse_VolumeStatus{
+				VolumeCondition: shareVolumeCondition(share),
+			},
+		})
+	}
+	return entries, nil
+}
+
+func (m *MultishareController) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	instancePrefix, project, location, instanceName, shareName, err := parseMultishareVolId(req.GetVolumeId())
+	if err != nil {
+		// An invalid id format is treated as doesn't exist
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	share, err := m.cloud.File.GetShare(ctx, &file.Share{
+		Parent: &file.MultishareInstance{
+			Project:  project,
+			Location: location,
+			Name:     instanceName,
+		},
+		Name: shareName,
+	})
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %v doesn't exist", req.GetVolumeId())
+		}
+		return nil, file.StatusError(err)
+	}
+
+	volume, err := m.shareToCSIVolume(instancePrefix, share)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.ControllerGetVolumeResponse{
+		Volume: volume,
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: shareVolumeCondition(share),
+		},
+	}, nil
+}
+
+// shareVolumeCondition reports the condition of the share, or the condition of the
+// parent instance if the instance is unhealthy.
+func shareVolumeCondition(share *file.Share) *csi.VolumeCondition {
+	if condition := volumeConditionFromState(share.Parent.State); condition.Abnormal {
+		condition.Message = fmt.Sprintf("instance %s is in state %s", share.Parent.Name, share.Parent.State)
+		return condition
+	}
+	return volumeConditionFromState(share.State)
 }
 
 func containsInstancePrefix(shareHandle string, project, location, instanceName string) bool {
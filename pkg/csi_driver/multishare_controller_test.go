@@ -27,6 +27,8 @@ import (
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	filev1beta1multishare "google.golang.org/api/file/v1beta1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
 	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
@@ -223,6 +225,33 @@ func TestPickRegion(t *testing.T) {
 			},
 			expectErr: true,
 		},
+		{
+			name: "region segment",
+			toporeq: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{
+						Segments: map[string]string{
+							TopologyKeyRegion: "us-east1",
+						},
+					},
+				},
+			},
+			expectedRegion: "us-east1",
+		},
+		{
+			name: "zone and region segment",
+			toporeq: &csi.TopologyRequirement{
+				Requisite: []*csi.Topology{
+					{
+						Segments: map[string]string{
+							TopologyKeyZone:   "us-east1-b",
+							TopologyKeyRegion: "us-east1",
+						},
+					},
+				},
+			},
+			expectedRegion: "us-east1",
+		},
 		{
 			name: "malformed zone name in req list",
 			toporeq: &csi.TopologyRequirement{
@@ -2070,6 +2099,210 @@ func TestMultishareCreateVolumeFromBackup(t *testing.T) {
 	}
 }
 
+func TestMultishareCreateVolumeFromVolume(t *testing.T) {
+	testVolName := "pvc-" + string(uuid.NewUUID())
+	testShareName := util.ConvertVolToShareName(testVolName)
+	testInstanceName := "fs-" + string(uuid.NewUUID())
+	sourceShareName := "source_share"
+	sourceVolumeID := fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName, sourceShareName)
+	cloneBackupURI := fmt.Sprintf(backupURIFmt, testProject, testRegion, testVolName)
+	volumeCapabilities := []*csi.VolumeCapability{
+		{
+			AccessType: &csi.VolumeCapability_Mount{
+				Mount: &csi.VolumeCapability_MountVolume{},
+			},
+			AccessMode: &csi.VolumeCapability_AccessMode{
+				Mode: csi.VolumeCapability_AccessMode_SINGLE_NODE_WRITER,
+			},
+		},
+	}
+	features := &GCFSDriverFeatureOptions{
+		FeatureMultishareBackups: &FeatureMultishareBackups{
+			Enabled: true,
+		},
+	}
+	volumeContentSource := func(volumeID string) *csi.VolumeContentSource {
+		return &csi.VolumeContentSource{
+			Type: &csi.VolumeContentSource_Volume{
+				Volume: &csi.VolumeContentSource_VolumeSource{
+					VolumeId: volumeID,
+				},
+			},
+		}
+	}
+	newRequest := func(source *csi.VolumeContentSource, capacityRange *csi.CapacityRange) *csi.CreateVolumeRequest {
+		return &csi.CreateVolumeRequest{
+			Name:          testVolName,
+			CapacityRange: capacityRange,
+			Parameters: map[string]string{
+				ParamMultishareInstanceScLabel: testInstanceScPrefix,
+			},
+			VolumeCapabilities:  volumeCapabilities,
+			VolumeContentSource: source,
+		}
+	}
+	sourceInstance := &file.MultishareInstance{
+		Name:     testInstanceName,
+		Location: testRegion,
+		Project:  testProject,
+		Labels: map[string]string{
+			util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+		},
+		CapacityBytes: 1 * util.Tb,
+		Tier:          enterpriseTier,
+		Network: file.Network{
+			Ip: testIP,
+		},
+		State:    "READY",
+		Protocol: v3FileProtocol,
+	}
+	sourceShare := &file.Share{
+		Name:           sourceShareName,
+		Parent:         sourceInstance,
+		CapacityBytes:  200 * util.Gb,
+		MountPointName: sourceShareName,
+		State:          "READY",
+	}
+
+	tests := []struct {
+		name           string
+		initShares     []*file.Share
+		initBackup     bool
+		req            *csi.CreateVolumeRequest
+		features       *GCFSDriverFeatureOptions
+		expectedVolume string
+		errorExpected  codes.Code
+	}{
+		{
+			name:           "clone into new share",
+			initShares:     []*file.Share{sourceShare},
+			req:            newRequest(volumeContentSource(sourceVolumeID), &csi.CapacityRange{RequiredBytes: 200 * util.Gb}),
+			features:       features,
+			expectedVolume: testShareName,
+		},
+		{
+			name: "clone already exists, transient backup is deleted",
+			initShares: []*file.Share{
+				sourceShare,
+				{
+					Name:           testShareName,
+					Parent:         sourceInstance,
+					CapacityBytes:  200 * util.Gb,
+					MountPointName: testShareName,
+					State:          "READY",
+					BackupId:       cloneBackupURI,
+				},
+			},
+			initBackup:     true,
+			req:            newRequest(volumeContentSource(sourceVolumeID), &csi.CapacityRange{RequiredBytes: 200 * util.Gb}),
+			features:       features,
+			expectedVolume: testShareName,
+		},
+		{
+			name: "clone already exists, source volume deleted",
+			initShares: []*file.Share{
+				{
+					Name:           testShareName,
+					Parent:         sourceInstance,
+					CapacityBytes:  200 * util.Gb,
+					MountPointName: testShareName,
+					State:          "READY",
+					BackupId:       cloneBackupURI,
+				},
+			},
+			req:            newRequest(volumeContentSource(sourceVolumeID), &csi.CapacityRange{RequiredBytes: 200 * util.Gb}),
+			features:       features,
+			expectedVolume: testShareName,
+		},
+		{
+			name:          "multishare backup feature is disabled",
+			initShares:    []*file.Share{sourceShare},
+			req:           newRequest(volumeContentSource(sourceVolumeID), &csi.CapacityRange{RequiredBytes: 200 * util.Gb}),
+			errorExpected: codes.InvalidArgument,
+		},
+		{
+			name:          "source volume not found",
+			req:           newRequest(volumeContentSource(sourceVolumeID), &csi.CapacityRange{RequiredBytes: 200 * util.Gb}),
+			features:      features,
+			errorExpected: codes.NotFound,
+		},
+		{
+			name:          "instance source volume",
+			initShares:    []*file.Share{sourceShare},
+			req:           newRequest(volumeContentSource(testVolumeID), &csi.CapacityRange{RequiredBytes: 200 * util.Gb}),
+			features:      features,
+			errorExpected: codes.InvalidArgument,
+		},
+		{
+			name:          "capacity limit smaller than source volume",
+			initShares:    []*file.Share{sourceShare},
+			req:           newRequest(volumeContentSource(sourceVolumeID), &csi.CapacityRange{RequiredBytes: 100 * util.Gb, LimitBytes: 100 * util.Gb}),
+			features:      features,
+			errorExpected: codes.OutOfRange,
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{sourceInstance}, tc.initShares, nil)
+			if err != nil {
+				t.Fatalf("failed to fake service: %v", err)
+			}
+			cloudProvider, _ := cloud.NewFakeCloud()
+			cloudProvider.File = s
+			config := &controllerServerConfig{
+				driver:          initTestDriver(t),
+				fileService:     s,
+				cloud:           cloudProvider,
+				volumeLocks:     util.NewVolumeLocks(),
+				ecfsDescription: "",
+				features:        tc.features,
+			}
+			mcs := NewMultishareController(config)
+			if tc.initBackup {
+				_, err := s.CreateBackup(context.TODO(), &file.BackupInfo{
+					Name:               testVolName,
+					SourceVolumeId:     fmt.Sprintf("%s/%s/%s/%s", modeMultishare, testRegion, testInstanceName, sourceShareName),
+					BackupURI:          cloneBackupURI,
+					Project:            testProject,
+					Location:           testRegion,
+					SourceInstanceName: testInstanceName,
+					SourceShare:        sourceShareName,
+				})
+				if err != nil {
+					t.Fatalf("failed to create backup: %v", err)
+				}
+			}
+
+			resp, err := mcs.CreateVolume(context.Background(), tc.req)
+			if tc.errorExpected != codes.OK {
+				if status.Code(err) != tc.errorExpected {
+					t.Errorf("expected error code %v, got %v", tc.errorExpected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !strings.Contains(resp.Volume.VolumeId, modeMultishare) || !strings.HasSuffix(resp.Volume.VolumeId, tc.expectedVolume) {
+				t.Errorf("unexpected vol id %s", resp.Volume.VolumeId)
+			}
+			if !reflect.DeepEqual(resp.Volume.ContentSource, tc.req.VolumeContentSource) {
+				t.Errorf("got content source %+v, expected %+v", resp.Volume.ContentSource, tc.req.VolumeContentSource)
+			}
+			share, err := s.GetShare(context.TODO(), &file.Share{Name: testShareName})
+			if err != nil {
+				t.Fatalf("couldn't get share %v: %v", testShareName, err)
+			}
+			if share.BackupId != cloneBackupURI {
+				t.Errorf("expected share restored from %q, got %q", cloneBackupURI, share.BackupId)
+			}
+			if _, err := s.GetBackup(context.TODO(), cloneBackupURI); !file.IsNotFoundErr(err) {
+				t.Errorf("expected transient backup %q to be deleted, got %v", cloneBackupURI, err)
+			}
+		})
+	}
+}
+
 func TestMultishareDeleteVolume(t *testing.T) {
 	testVolName := "pvc-" + string(uuid.NewUUID())
 	testShareName := util.ConvertVolToShareName(testVolName)
@@ -2269,6 +2502,113 @@ func TestMultishareDeleteVolume(t *testing.T) {
 
 }
 
+func TestMultishareListVolumes(t *testing.T) {
+	testInstanceName1 := "fs-" + string(uuid.NewUUID())
+	testInstanceName2 := "fs-" + string(uuid.NewUUID())
+	testInstanceName3 := "fs-" + string(uuid.NewUUID())
+	newInstance := func(name, state string, labels map[string]string) *file.MultishareInstance {
+		return &file.MultishareInstance{
+			Name:     name,
+			Location: testRegion,
+			Project:  testProject,
+			Labels:   labels,
+			State:    state,
+			Network: file.Network{
+				Ip: testIP,
+			},
+		}
+	}
+	newShare := func(name, state string, parent *file.MultishareInstance) *file.Share {
+		return &file.Share{
+			Name:           name,
+			Parent:         &file.MultishareInstance{Name: parent.Name, Location: parent.Location, Project: parent.Project},
+			CapacityBytes:  100 * util.Gb,
+			State:          state,
+			MountPointName: name,
+		}
+	}
+	scLabels := map[string]string{
+		util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+	}
+	readyInstance := newInstance(testInstanceName1, "READY", scLabels)
+	errorInstance := newInstance(testInstanceName2, "ERROR", scLabels)
+	unlabelledInstance := newInstance(testInstanceName3, "READY", nil)
+
+	tests := []struct {
+		name             string
+		initInstance     []*file.MultishareInstance
+		initShares       []*file.Share
+		expectedAbnormal map[string]bool
+	}{
+		{
+			name: "no instances",
+		},
+		{
+			name:         "shares on ready instance",
+			initInstance: []*file.MultishareInstance{readyInstance},
+			initShares: []*file.Share{
+				newShare("share1", "READY", readyInstance),
+				newShare("share2", "ERROR", readyInstance),
+			},
+			expectedAbnormal: map[string]bool{
+				fmt.Sprintf("%s/%s/%s/%s/%s/%s", modeMultishare, testInstanceScPrefix, testProject, testRegion, testInstanceName1, "share1"): false,
+				fmt.Sprintf("%s/%s/%s/%s/%s/%s", modeMultishare, testInstanceScPrefix, testProject, testRegion, testInstanceName1, "share2"): true,
+			},
+		},
+		{
+			name:         "share on instance in error state",
+			initInstance: []*file.MultishareInstance{errorInstance},
+			initShares: []*file.Share{
+				newShare("share3", "READY", errorInstance),
+			},
+			expectedAbnormal: map[string]bool{
+				fmt.Sprintf("%s/%s/%s/%s/%s/%s", modeMultishare, testInstanceScPrefix, testProject, testRegion, testInstanceName2, "share3"): true,
+			},
+		},
+		{
+			name:         "share on instance without storage class label is skipped",
+			initInstance: []*file.MultishareInstance{unlabelledInstance},
+			initShares: []*file.Share{
+				newShare("share4", "READY", unlabelledInstance),
+			},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			s, err := file.NewFakeServiceForMultishare(tc.initInstance, tc.initShares, nil)
+			if err != nil {
+				t.Fatalf("failed to fake service: %v", err)
+			}
+			cloudProvider, _ := cloud.NewFakeCloud()
+			cloudProvider.File = s
+			config := &controllerServerConfig{
+				driver:      initTestDriver(t),
+				fileService: s,
+				cloud:       cloudProvider,
+				volumeLocks: util.NewVolumeLocks(),
+			}
+			mcs := NewMultishareController(config)
+			entries, err := mcs.ListVolumes(context.Background())
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(entries) != len(tc.expectedAbnormal) {
+				t.Fatalf("got %d entries, expected %d", len(entries), len(tc.expectedAbnormal))
+			}
+			for _, entry := range entries {
+				abnormal, ok := tc.expectedAbnormal[entry.GetVolume().GetVolumeId()]
+				if !ok {
+					t.Errorf("unexpected volume %q", entry.GetVolume().GetVolumeId())
+					continue
+				}
+				if entry.GetStatus().GetVolumeCondition().GetAbnormal() != abnormal {
+					t.Errorf("volume %q: got condition %+v, expected abnormal %v", entry.GetVolume().GetVolumeId(), entry.GetStatus().GetVolumeCondition(), abnormal)
+				}
+			}
+		})
+	}
+}
+
 func TestMultishareControllerExpandVolume(t *testing.T) {
 	testVolName := "pvc-" + string(uuid.NewUUID())
 	testShareName := util.ConvertVolToShareName(testVolName)
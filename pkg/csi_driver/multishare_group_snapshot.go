@@ -0,0 +1,331 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	// tagKeyGroupSnapshotName labels the member backups of a volume group snapshot with the name of the group.
+	tagKeyGroupSnapshotName = "storage_gke_io_created-for_csi_group_snapshot_name"
+	// maxBackupNameLength is the maximum length of a Filestore backup id.
+	maxBackupNameLength = 63
+	// paramIndependentMemberBackups acknowledges, in the VolumeGroupSnapshotClass parameters, that the member
+	// backups of a group snapshot are not consistent with each other.
+	paramIndependentMemberBackups = "independent-member-backups"
+)
+
+// CreateVolumeGroupSnapshot takes a backup of every share of the group. All the shares must belong to the same
+// multishare instance. Filestore can't fence the writes to several shares, nor back them up atomically, so
+// the member backups are independent backups taken at slightly different times and the group snapshot is not
+// crash-consistent. It is rejected unless the parameters acknowledge it with paramIndependentMemberBackups.
+// The backup of the i-th volume, sorted by volume id, is named {group name}-{i} and labeled with the group name.
+func (m *MultishareController) CreateVolumeGroupSnapshot(ctx context.Context, req *csi.CreateVolumeGroupSnapshotRequest) (*csi.CreateVolumeGroupSnapshotResponse, error) {
+	if !m.featureMultishareBackups {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolumeGroupSnapshot is not supported for multishare backed volumes")
+	}
+	klog.Infof("CreateVolumeGroupSnapshot called with request %+v", req)
+	name := req.GetName()
+	if name == "" {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolumeGroupSnapshot name must be provided")
+	}
+	if err := util.CheckLabelValueRegex(name); err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid group snapshot name %q: %v", name, err)
+	}
+	if len(req.GetSourceVolumeIds()) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolumeGroupSnapshot source volume ids must be provided")
+	}
+	if req.GetParameters() != nil {
+		if _, err := util.IsSnapshotTypeSupported(req.GetParameters()); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+	if util.GetSnapshotType(req.GetParameters()) != util.VolumeSnapshotTypeBackup {
+		return nil, status.Errorf(codes.InvalidArgument, "Volume snapshot type %q not supported for multishare volumes", util.GetSnapshotType(req.GetParameters()))
+	}
+	if independent, err := strconv.ParseBool(req.GetParameters()[paramIndependentMemberBackups]); err != nil || !independent {
+		return nil, status.Errorf(codes.InvalidArgument, "volume group snapshots of multishare volumes are not crash-consistent: the shares are backed up independently without fencing their writes. Set parameter %q to \"true\" in the VolumeGroupSnapshotClass to take them anyway", paramIndependentMemberBackups)
+	}
+
+	volumeIDs := append([]string{}, req.GetSourceVolumeIds()...)
+	sort.Strings(volumeIDs)
+	var instancePrefix, location, instanceName string
+	for i, volumeID := range volumeIDs {
+		if i > 0 && volumeID == volumeIDs[i-1] {
+			return nil, status.Errorf(codes.InvalidArgument, "duplicate source volume id %s", volumeID)
+		}
+		prefix, _, volumeLocation, volumeInstanceName, _, err := parseMultishareVolId(volumeID)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if i == 0 {
+			instancePrefix, location, instanceName = prefix, volumeLocation, volumeInstanceName
+			continue
+		}
+		if prefix != instancePrefix || volumeLocation != location || volumeInstanceName != instanceName {
+			return nil, status.Errorf(codes.InvalidArgument, "volume %s is not a share of instance %s in location %s, all the volumes of a group snapshot must be shares of the same multishare instance", volumeID, instanceName, location)
+		}
+	}
+
+	for i, volumeID := range volumeIDs {
+		if acquired := m.volumeLocks.TryAcquire(volumeID); !acquired {
+			for _, acquiredID := range volumeIDs[:i] {
+				m.volumeLocks.Release(acquiredID)
+			}
+			return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+		}
+	}
+	defer func() {
+		for _, volumeID := range volumeIDs {
+			m.volumeLocks.Release(volumeID)
+		}
+	}()
+
+	labels, err := extractBackupLabels(req.GetParameters(), m.extraVolumeLabels, m.driver.config.Name, name)
+	if err != nil {
+		return nil, err
+	}
+	labels[tagKeyGroupSnapshotName] = name
+	labels[util.ParamMultishareInstanceScLabelKey] = instancePrefix
+
+	project := m.cloud.Project
+	backupLocation := util.GetBackupLocation(req.GetParameters())
+	var region string
+	members := make([]*file.BackupInfo, len(volumeIDs))
+	for i, volumeID := range volumeIDs {
+		_, _, _, _, shareName, _ := parseMultishareVolId(volumeID)
+		memberName := fmt.Sprintf("%s-%d", name, i)
+		if len(memberName) > maxBackupNameLength {
+			return nil, status.Errorf(codes.InvalidArgument, "group snapshot name %q is too long for the backup %q of volume %s", name, memberName, volumeID)
+		}
+		backupURI, backupRegion, err := file.CreateBackupURI(location, project, memberName, backupLocation)
+		if err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		region = backupRegion
+		members[i] = &file.BackupInfo{
+			Name:               memberName,
+			SourceVolumeId:     fmt.Sprintf("%s/%s/%s/%s", modeMultishare, location, instanceName, shareName),
+			Project:            project,
+			Location:           backupRegion,
+			SourceShare:        shareName,
+			SourceInstanceName: instanceName,
+			BackupURI:          backupURI,
+			Labels:             labels,
+		}
+	}
+
+	existingBackups, err := m.listGroupSnapshotBackups(ctx, project, region, name)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]*file.Backup{}
+	for _, backup := range existingBackups {
+		existing[backup.Backup.Name] = backup
+	}
+	var missing []*file.BackupInfo
+	for _, member := range members {
+		backup, ok := existing[member.BackupURI]
+		if !ok {
+			missing = append(missing, member)
+			continue
+		}
+		delete(existing, member.BackupURI)
+		if _, err := file.ProcessExistingBackup(ctx, backup, member.SourceVolumeId, modeMultishare); err != nil {
+			return nil, err
+		}
+	}
+	if len(existing) > 0 {
+		return nil, status.Errorf(codes.AlreadyExists, "group snapshot %s already exists with different source volumes", name)
+	}
+
+	// The backups of the shares are started together, to shorten the time between them.
+	errs := make([]error, len(missing))
+	var wg sync.WaitGroup
+	for i, member := range missing {
+		wg.Add(1)
+		go func(i int, member *file.BackupInfo) {
+			defer wg.Done()
+			if _, err := m.cloud.File.CreateBackup(ctx, member); err != nil {
+				klog.Errorf("Create backup %s of volume %s for group snapshot %s failed: %v", member.BackupURI, member.SourceVolumeId, name, err.Error())
+				errs[i] = err
+			}
+		}(i, member)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+	}
+
+	for _, member := range members {
+		if err := m.tagManager.AttachResourceTags(ctx, cloud.FilestoreBackUp, member.Name, member.Location, name, req.GetParameters()); err != nil {
+			return nil, status.Error(codes.Unavailable, err.Error())
+		}
+	}
+
+	groupSnapshot, err := m.getGroupSnapshot(ctx, project, region, name)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.CreateVolumeGroupSnapshotResponse{GroupSnapshot: groupSnapshot}, nil
+}
+
+// DeleteVolumeGroupSnapshot deletes all the member backups of a group snapshot.
+func (m *MultishareController) DeleteVolumeGroupSnapshot(ctx context.Context, req *csi.DeleteVolumeGroupSnapshotRequest) (*csi.DeleteVolumeGroupSnapshotResponse, error) {
+	id := req.GetGroupSnapshotId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "DeleteVolumeGroupSnapshot group snapshot id must be provided")
+	}
+	project, region, name, err := parseGroupSnapshotHandle(id)
+	if err != nil {
+		// An invalid handle is a group snapshot that doesn't exist.
+		klog.Warningf("Could not parse group snapshot handle %v", id)
+		return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+	}
+
+	backups, err := m.listGroupSnapshotBackups(ctx, project, region, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, backup := range backups {
+		if backup.Backup.State == "DELETING" {
+			return nil, status.Errorf(codes.DeadlineExceeded, "Volume snapshot with ID %v of group snapshot %v is in state %s", backup.Backup.Name, id, backup.Backup.State)
+		}
+		if err := m.cloud.File.DeleteBackup(ctx, backup.Backup.Name); err != nil {
+			if file.IsNotFoundErr(err) {
+				continue
+			}
+			klog.Errorf("Delete backup %s of group snapshot %s failed: %v", backup.Backup.Name, id, err.Error())
+			return nil, file.StatusError(err)
+		}
+	}
+	return &csi.DeleteVolumeGroupSnapshotResponse{}, nil
+}
+
+func (m *MultishareController) GetVolumeGroupSnapshot(ctx context.Context, req *csi.GetVolumeGroupSnapshotRequest) (*csi.GetVolumeGroupSnapshotResponse, error) {
+	id := req.GetGroupSnapshotId()
+	if id == "" {
+		return nil, status.Error(codes.InvalidArgument, "GetVolumeGroupSnapshot group snapshot id must be provided")
+	}
+	project, region, name, err := parseGroupSnapshotHandle(id)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	groupSnapshot, err := m.getGroupSnapshot(ctx, project, region, name)
+	if err != nil {
+		return nil, err
+	}
+	members := map[string]bool{}
+	for _, snapshot := range groupSnapshot.GetSnapshots() {
+		members[snapshot.GetSnapshotId()] = true
+	}
+	for _, snapshotID := range req.GetSnapshotIds() {
+		if !members[snapshotID] {
+			return nil, status.Errorf(codes.InvalidArgument, "snapshot %s is not a member of group snapshot %s", snapshotID, id)
+		}
+	}
+	return &csi.GetVolumeGroupSnapshotResponse{GroupSnapshot: groupSnapshot}, nil
+}
+
+// getGroupSnapshot returns the group snapshot made of the backups labeled with its name. The group is ready
+// to use once all its backups are.
+func (m *MultishareController) getGroupSnapshot(ctx context.Context, project, region, name string) (*csi.VolumeGroupSnapshot, error) {
+	backups, err := m.listGroupSnapshotBackups(ctx, project, region, name)
+	if err != nil {
+		return nil, err
+	}
+	if len(backups) == 0 {
+		return nil, status.Errorf(codes.NotFound, "group snapshot %s not found", groupSnapshotHandle(project, region, name))
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].Backup.Name < backups[j].Backup.Name
+	})
+
+	groupSnapshot := &csi.VolumeGroupSnapshot{
+		GroupSnapshotId: groupSnapshotHandle(project, region, name),
+		ReadyToUse:      true,
+	}
+	for _, backup := range backups {
+		snapshot, err := backupToGroupMemberSnapshot(backup, project, groupSnapshot.GroupSnapshotId)
+		if err != nil {
+			return nil, err
+		}
+		groupSnapshot.Snapshots = append(groupSnapshot.Snapshots, snapshot)
+		groupSnapshot.ReadyToUse = groupSnapshot.ReadyToUse && snapshot.ReadyToUse
+		if groupSnapshot.CreationTime == nil || snapshot.CreationTime.AsTime().Before(groupSnapshot.CreationTime.AsTime()) {
+			groupSnapshot.CreationTime = snapshot.CreationTime
+		}
+	}
+	return groupSnapshot, nil
+}
+
+// backupToGroupMemberSnapshot converts a member backup of a group snapshot to a CSI snapshot of the multishare
+// volume it was taken of.
+func backupToGroupMemberSnapshot(backup *file.Backup, project, groupSnapshotID string) (*csi.Snapshot, error) {
+	_, location, instanceName, shareName, err := util.ParseShareURI(backup.SourceInstance)
+	if err != nil {
+		return nil, status.Errorf(codes.Internal, "backup %s of group snapshot %s has an invalid source: %v", backup.Backup.Name, groupSnapshotID, err)
+	}
+	switch backup.Backup.State {
+	case "READY", "CREATING", "FINALIZING":
+	default:
+		return nil, status.Errorf(codes.Internal, "backup %s of group snapshot %s is in state %s", backup.Backup.Name, groupSnapshotID, backup.Backup.State)
+	}
+	tp, err := util.ParseTimestamp(backup.Backup.CreateTime)
+	if err != nil {
+		return nil, err
+	}
+	return &csi.Snapshot{
+		SizeBytes:       util.GbToBytes(backup.Backup.CapacityGb),
+		SnapshotId:      backup.Backup.Name,
+		SourceVolumeId:  fmt.Sprintf("%s/%s/%s/%s/%s/%s", modeMultishare, backup.Backup.Labels[util.ParamMultishareInstanceScLabelKey], project, location, instanceName, shareName),
+		CreationTime:    tp,
+		ReadyToUse:      backup.Backup.State == "READY",
+		GroupSnapshotId: groupSnapshotID,
+	}, nil
+}
+
+// listGroupSnapshotBackups lists the member backups of a group snapshot.
+func (m *MultishareController) listGroupSnapshotBackups(ctx context.Context, project, region, name string) ([]*file.Backup, error) {
+	backups, err := m.cloud.File.ListBackups(ctx, &file.ListFilter{Project: project, Location: region})
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	var members []*file.Backup
+	for _, backup := range backups {
+		if backup.Backup.Labels[tagKeyGroupSnapshotName] == name {
+			members = append(members, backup)
+		}
+	}
+	return members, nil
+}
@@ -0,0 +1,313 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"github.com/stretchr/testify/mock"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// independentParams are the parameters of a VolumeGroupSnapshotClass of independent member backups.
+var independentParams = map[string]string{
+	util.VolumeSnapshotTypeKey:    util.VolumeSnapshotTypeBackup,
+	paramIndependentMemberBackups: "true",
+}
+
+func TestCreateVolumeGroupSnapshot(t *testing.T) {
+	groupName := "mygroup"
+	volumeID1 := fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName, "share1")
+	volumeID2 := fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName, "share2")
+	otherInstanceVolumeID := fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, "otherInstance", "share3")
+	groupID := groupSnapshotHandle(testProject, testRegion, groupName)
+	features := &GCFSDriverFeatureOptions{
+		FeatureMultishareBackups: &FeatureMultishareBackups{
+			Enabled: true,
+		},
+	}
+
+	cases := []struct {
+		name          string
+		req           *csi.CreateVolumeGroupSnapshotRequest
+		features      *GCFSDriverFeatureOptions
+		createTwice   bool
+		expectedIDs   map[string]string
+		errorExpected codes.Code
+	}{
+		{
+			name: "group snapshot of two shares",
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            groupName,
+				SourceVolumeIds: []string{volumeID2, volumeID1},
+				Parameters:      independentParams,
+			},
+			features: features,
+			expectedIDs: map[string]string{
+				fmt.Sprintf(backupURIFmt, testProject, testRegion, groupName+"-0"): volumeID1,
+				fmt.Sprintf(backupURIFmt, testProject, testRegion, groupName+"-1"): volumeID2,
+			},
+		},
+		{
+			name: "group snapshot already exists",
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            groupName,
+				SourceVolumeIds: []string{volumeID1, volumeID2},
+				Parameters:      independentParams,
+			},
+			features:    features,
+			createTwice: true,
+			expectedIDs: map[string]string{
+				fmt.Sprintf(backupURIFmt, testProject, testRegion, groupName+"-0"): volumeID1,
+				fmt.Sprintf(backupURIFmt, testProject, testRegion, groupName+"-1"): volumeID2,
+			},
+		},
+		{
+			name: "multishare backup feature is disabled",
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            groupName,
+				SourceVolumeIds: []string{volumeID1, volumeID2},
+			},
+			errorExpected: codes.InvalidArgument,
+		},
+		{
+			name: "shares of different instances",
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            groupName,
+				SourceVolumeIds: []string{volumeID1, otherInstanceVolumeID},
+				Parameters:      independentParams,
+			},
+			features:      features,
+			errorExpected: codes.InvalidArgument,
+		},
+		{
+			name: "duplicate source volumes",
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            groupName,
+				SourceVolumeIds: []string{volumeID1, volumeID1},
+				Parameters:      independentParams,
+			},
+			features:      features,
+			errorExpected: codes.InvalidArgument,
+		},
+		{
+			name: "member backups are not acknowledged as independent",
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            groupName,
+				SourceVolumeIds: []string{volumeID1, volumeID2},
+				Parameters: map[string]string{
+					util.VolumeSnapshotTypeKey: util.VolumeSnapshotTypeBackup,
+				},
+			},
+			features:      features,
+			errorExpected: codes.InvalidArgument,
+		},
+		{
+			name: "instance snapshot type",
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name:            groupName,
+				SourceVolumeIds: []string{volumeID1, volumeID2},
+				Parameters: map[string]string{
+					util.VolumeSnapshotTypeKey: util.VolumeSnapshotTypeSnapshot,
+				},
+			},
+			features:      features,
+			errorExpected: codes.InvalidArgument,
+		},
+		{
+			name: "no source volumes",
+			req: &csi.CreateVolumeGroupSnapshotRequest{
+				Name: groupName,
+			},
+			features:      features,
+			errorExpected: codes.InvalidArgument,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := initTestMultishareControllerWithFeatureOpts(t, tc.features)
+			m.tagManager.(*cloud.FakeTagServiceManager).
+				On("AttachResourceTags", context.TODO(), cloud.FilestoreBackUp, mock.Anything, testRegion, groupName, tc.req.GetParameters()).
+				Return(nil)
+
+			if tc.createTwice {
+				if _, err := m.CreateVolumeGroupSnapshot(context.TODO(), tc.req); err != nil {
+					t.Fatalf("failed to create group snapshot: %v", err)
+				}
+			}
+			resp, err := m.CreateVolumeGroupSnapshot(context.TODO(), tc.req)
+			if tc.errorExpected != codes.OK {
+				if status.Code(err) != tc.errorExpected {
+					t.Fatalf("expected error code %v, got %v", tc.errorExpected, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			groupSnapshot := resp.GetGroupSnapshot()
+			if groupSnapshot.GetGroupSnapshotId() != groupID {
+				t.Errorf("expected group snapshot id %s, got %s", groupID, groupSnapshot.GetGroupSnapshotId())
+			}
+			if !groupSnapshot.GetReadyToUse() {
+				t.Errorf("expected group snapshot to be ready to use")
+			}
+			if len(groupSnapshot.GetSnapshots()) != len(tc.expectedIDs) {
+				t.Fatalf("expected %d snapshots, got %d", len(tc.expectedIDs), len(groupSnapshot.GetSnapshots()))
+			}
+			for _, snapshot := range groupSnapshot.GetSnapshots() {
+				volumeID, ok := tc.expectedIDs[snapshot.GetSnapshotId()]
+				if !ok {
+					t.Errorf("unexpected snapshot %s", snapshot.GetSnapshotId())
+					continue
+				}
+				if snapshot.GetSourceVolumeId() != volumeID {
+					t.Errorf("expected source volume %s of snapshot %s, got %s", volumeID, snapshot.GetSnapshotId(), snapshot.GetSourceVolumeId())
+				}
+				if snapshot.GetGroupSnapshotId() != groupID {
+					t.Errorf("expected group snapshot id %s of snapshot %s, got %s", groupID, snapshot.GetSnapshotId(), snapshot.GetGroupSnapshotId())
+				}
+			}
+		})
+	}
+}
+
+func TestGetAndDeleteVolumeGroupSnapshot(t *testing.T) {
+	groupName := "mygroup"
+	volumeID1 := fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName, "share1")
+	volumeID2 := fmt.Sprintf(multishareVolIdFmt, testInstanceScPrefix, testProject, testRegion, testInstanceName, "share2")
+	groupID := groupSnapshotHandle(testProject, testRegion, groupName)
+	backupURI1 := fmt.Sprintf(backupURIFmt, testProject, testRegion, groupName+"-0")
+	features := &GCFSDriverFeatureOptions{
+		FeatureMultishareBackups: &FeatureMultishareBackups{
+			Enabled: true,
+		},
+	}
+
+	m := initTestMultishareControllerWithFeatureOpts(t, features)
+	m.tagManager.(*cloud.FakeTagServiceManager).
+		On("AttachResourceTags", context.TODO(), cloud.FilestoreBackUp, mock.Anything, testRegion, groupName, mock.Anything).
+		Return(nil)
+	if _, err := m.CreateVolumeGroupSnapshot(context.TODO(), &csi.CreateVolumeGroupSnapshotRequest{
+		Name:            groupName,
+		SourceVolumeIds: []string{volumeID1, volumeID2},
+		Parameters:      independentParams,
+	}); err != nil {
+		t.Fatalf("failed to create group snapshot: %v", err)
+	}
+
+	cases := []struct {
+		name          string
+		req           *csi.GetVolumeGroupSnapshotRequest
+		errorExpected codes.Code
+	}{
+		{
+			name: "get group snapshot",
+			req:  &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: groupID},
+		},
+		{
+			name: "get group snapshot with member snapshot ids",
+			req:  &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: groupID, SnapshotIds: []string{backupURI1}},
+		},
+		{
+			name:          "snapshot id is not a member",
+			req:           &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: groupID, SnapshotIds: []string{fmt.Sprintf(backupURIFmt, testProject, testRegion, "other")}},
+			errorExpected: codes.InvalidArgument,
+		},
+		{
+			name:          "group snapshot not found",
+			req:           &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: groupSnapshotHandle(testProject, testRegion, "othergroup")},
+			errorExpected: codes.NotFound,
+		},
+		{
+			name:          "invalid group snapshot id",
+			req:           &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: "invalid"},
+			errorExpected: codes.NotFound,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			resp, err := m.GetVolumeGroupSnapshot(context.TODO(), tc.req)
+			if status.Code(err) != tc.errorExpected {
+				t.Fatalf("expected error code %v, got %v", tc.errorExpected, err)
+			}
+			if err == nil && len(resp.GetGroupSnapshot().GetSnapshots()) != 2 {
+				t.Errorf("expected 2 snapshots, got %d", len(resp.GetGroupSnapshot().GetSnapshots()))
+			}
+		})
+	}
+
+	if _, err := m.DeleteVolumeGroupSnapshot(context.TODO(), &csi.DeleteVolumeGroupSnapshotRequest{GroupSnapshotId: groupID}); err != nil {
+		t.Fatalf("failed to delete group snapshot: %v", err)
+	}
+	if _, err := m.GetVolumeGroupSnapshot(context.TODO(), &csi.GetVolumeGroupSnapshotRequest{GroupSnapshotId: groupID}); status.Code(err) != codes.NotFound {
+		t.Errorf("expected group snapshot to be deleted, got %v", err)
+	}
+	// Deleting a group snapshot that doesn't exist succeeds.
+	if _, err := m.DeleteVolumeGroupSnapshot(context.TODO(), &csi.DeleteVolumeGroupSnapshotRequest{GroupSnapshotId: groupID}); err != nil {
+		t.Errorf("failed to delete a deleted group snapshot: %v", err)
+	}
+}
+
+func TestParseGroupSnapshotHandle(t *testing.T) {
+	cases := []struct {
+		name         string
+		handle       string
+		expectedName string
+		expectErr    bool
+	}{
+		{
+			name:         "valid handle",
+			handle:       groupSnapshotHandle(testProject, testRegion, "mygroup"),
+			expectedName: "mygroup",
+		},
+		{
+			name:      "backup handle",
+			handle:    fmt.Sprintf(backupURIFmt, testProject, testRegion, "mybackup"),
+			expectErr: true,
+		},
+		{
+			name:      "empty name",
+			handle:    groupSnapshotHandle(testProject, testRegion, ""),
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			project, region, name, err := parseGroupSnapshotHandle(tc.handle)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error for handle %s", tc.handle)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if project != testProject || region != testRegion || name != tc.expectedName {
+				t.Errorf("got %s/%s/%s, want %s/%s/%s", project, region, name, testProject, testRegion, tc.expectedName)
+			}
+		})
+	}
+}
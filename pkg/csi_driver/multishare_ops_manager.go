@@ -20,7 +20,6 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"math/rand"
 	"strings"
 	"sync"
 
@@ -46,6 +45,8 @@ type Workflow struct {
 	share    *file.Share
 	opType   util.OperationType
 	opName   string
+	// updateMask is the fields patched by a share update other than a resize.
+	updateMask []string
 }
 
 // MultishareOpsManager manages the lifecycle of all instance and share operations.
@@ -107,10 +108,12 @@ func (m *MultishareOpsManager) setupEligibleInstanceAndStartWorkflow(ctx context
 	}
 
 	if len(eligible) > 0 {
-		// pick a random eligible instance
-		index := rand.Intn(len(eligible))
-		klog.V(5).Infof("For share %s, using instance %s as placeholder", shareName, eligible[index].String())
-		share, err := generateNewShare(shareName, eligible[index], req, sourceSnapshotId)
+		placed, err := m.placeShareOnEligibleInstance(ctx, req, shareName, eligible)
+		if err != nil {
+			return nil, nil, err
+		}
+		klog.V(5).Infof("For share %s, using instance %s as placeholder", shareName, placed.String())
+		share, err := generateNewShare(shareName, placed, req, sourceSnapshotId)
 		if err != nil {
 			return nil, nil, status.Error(codes.Internal, err.Error())
 		}
@@ -121,8 +124,8 @@ func (m *MultishareOpsManager) setupEligibleInstanceAndStartWorkflow(ctx context
 		}
 
 		if needExpand {
-			eligible[index].CapacityBytes = targetBytes
-			w, err := m.startInstanceWorkflow(ctx, &Workflow{instance: eligible[index], opType: util.InstanceUpdate}, ops)
+			placed.CapacityBytes = targetBytes
+			w, err := m.startInstanceWorkflow(ctx, &Workflow{instance: placed, opType: util.InstanceUpdate}, ops)
 			return w, nil, err
 		}
 
@@ -224,6 +227,11 @@ func (m *MultishareOpsManager) startInstanceWorkflow(ctx context.Context, w *Wor
 	}
 	switch w.opType {
 	case util.InstanceCreate:
+		if m.controllerServer != nil {
+			if err := m.controllerServer.config.preflight.validateMultishareInstance(ctx, w.instance); err != nil {
+				return nil, err
+			}
+		}
 		op, err := m.cloud.File.StartCreateMultishareInstanceOp(ctx, w.instance)
 		if err != nil {
 			return nil, err
@@ -308,7 +316,13 @@ func (m *MultishareOpsManager) startShareWorkflow(ctx context.Context, w *Workfl
 		}
 		w.opName = op.Name
 	case util.ShareUpdate:
-		op, err := m.cloud.File.StartResizeShareOp(ctx, w.share)
+		var op *filev1beta1multishare.Operation
+		var err error
+		if len(w.updateMask) > 0 {
+			op, err = m.cloud.File.StartPatchShareOp(ctx, w.share, w.updateMask)
+		} else {
+			op, err = m.cloud.File.StartResizeShareOp(ctx, w.share)
+		}
 		if err != nil {
 			return nil, err
 		}
@@ -425,6 +439,66 @@ func (m *MultishareOpsManager) runEligibleInstanceCheck(ctx context.Context, req
 	return readyEligibleInstances, nil
 }
 
+// placeShareOnEligibleInstance returns the eligible instance the placement policy of a request places a new
+// share on.
+func (m *MultishareOpsManager) placeShareOnEligibleInstance(ctx context.Context, req *csi.CreateVolumeRequest, shareName string, eligible []*file.MultishareInstance) (*file.MultishareInstance, error) {
+	policy, err := placementPolicyFromParams(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	shareBytes, err := getShareRequestCapacity(req.GetCapacityRange(), util.ConfigurablePackMinShareSizeBytes, util.MaxShareSizeBytes)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	// The shares of the eligible instances are listed once per region, rather than once per instance.
+	sharesByInstance := map[string][]*file.Share{}
+	listed := map[string]bool{}
+	for _, instance := range eligible {
+		region := instance.Project + "/" + instance.Location
+		if listed[region] {
+			continue
+		}
+		listed[region] = true
+		shares, err := m.cloud.File.ListShares(ctx, &file.ListFilter{Project: instance.Project, Location: instance.Location, InstanceName: "-"})
+		if err != nil {
+			return nil, err
+		}
+		for _, share := range shares {
+			if share.Parent == nil {
+				continue
+			}
+			uri, err := file.GenerateMultishareInstanceURI(share.Parent)
+			if err != nil {
+				return nil, status.Error(codes.Internal, err.Error())
+			}
+			sharesByInstance[uri] = append(sharesByInstance[uri], share)
+		}
+	}
+
+	instances := map[string]*file.MultishareInstance{}
+	var candidates []*PlacementCandidate
+	for _, instance := range eligible {
+		uri, err := file.GenerateMultishareInstanceURI(instance)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		candidate, err := instancePlacementCandidate(instance, sharesByInstance[uri])
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		instances[candidate.Name] = instance
+		candidates = append(candidates, candidate)
+	}
+	placed := placeShare(policy, &PlacementShare{
+		Name:          shareName,
+		CapacityBytes: shareBytes,
+		Namespace:     req.GetParameters()[ParameterKeyPVCNamespace],
+	}, candidates)
+	klog.V(5).Infof("Placement policy %s placed share %s on instance %s", policy.Name(), shareName, placed.Name)
+	return instances[placed.Name], nil
+}
+
 func (m *MultishareOpsManager) instanceNeedsExpand(ctx context.Context, share *file.Share, capacityNeeded int64) (bool, int64, error) {
 	if share == nil {
 		return false, 0, fmt.Errorf("empty share")
@@ -507,6 +581,18 @@ func (m *MultishareOpsManager) startShareExpandWorkflowSafe(ctx context.Context,
 	return m.startShareWorkflow(ctx, &Workflow{share: share, opType: util.ShareUpdate}, ops)
 }
 
+// startShareModifyWorkflowSafe starts the update of the fields of the update mask of the share.
+func (m *MultishareOpsManager) startShareModifyWorkflowSafe(ctx context.Context, share *file.Share, updateMask []string) (*Workflow, error) {
+	m.Lock()
+	defer m.Unlock()
+	ops, err := m.listMultishareResourceRunningOps(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.startShareWorkflow(ctx, &Workflow{share: share, opType: util.ShareUpdate, updateMask: updateMask}, ops)
+}
+
 func (m *MultishareOpsManager) checkAndStartShareDeleteWorkflow(ctx context.Context, share *file.Share) (*Workflow, error) {
 	m.Lock()
 	defer m.Unlock()
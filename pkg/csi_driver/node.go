@@ -17,16 +17,17 @@ limitations under the License.
 package driver
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
+	"path/filepath"
 	"runtime"
 	"strings"
 	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"golang.org/x/net/context"
-	"golang.org/x/sys/unix"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	kuberuntime "k8s.io/apimachinery/pkg/runtime"
@@ -59,15 +60,28 @@ type nodeServer struct {
 	volumeLocks           *util.VolumeLocks
 	lockReleaseController *lockrelease.LockReleaseController
 	features              *GCFSDriverFeatureOptions
+	mountHealth           *mountHealthMonitor
+	shareLocator          *shareLocator
+	stagedVolumes         *stagedVolumes
+	tlsTunnels            *tlsTunnelManager
+	kerberos              *kerberosCredentialManager
+	mountPolicy           *mountPolicy
+	smb                   smbMounter
+	// subDirectoryUsage caches the used bytes of the sub-directory volumes, which are walked to be measured.
+	subDirectoryUsage *usageCache
 }
 
 func newNodeServer(driver *GCFSDriver, mounter mount.Interface, metaService metadata.Service, featureOptions *GCFSDriverFeatureOptions) (csi.NodeServer, error) {
 	ns := &nodeServer{
-		driver:      driver,
-		mounter:     mounter,
-		metaService: metaService,
-		volumeLocks: util.NewVolumeLocks(),
-		features:    featureOptions,
+		driver:            driver,
+		mounter:           mounter,
+		metaService:       metaService,
+		volumeLocks:       util.NewVolumeLocks(),
+		features:          featureOptions,
+		subDirectoryUsage: newUsageCache(subDirectoryUsageCacheTTL),
+	}
+	if goOs == "windows" {
+		ns.smb = &powershellSMBMounter{}
 	}
 	if ns.features.FeatureLockRelease.Enabled {
 		config, err := rest.InClusterConfig()
@@ -85,6 +99,40 @@ func newNodeServer(driver *GCFSDriver, mounter mount.Interface, metaService meta
 		}
 		ns.lockReleaseController = lc
 	}
+	if ns.features.FeatureMountHealthMonitor != nil && ns.features.FeatureMountHealthMonitor.Enabled {
+		ns.mountHealth = newMountHealthMonitor(mounter, ns.volumeLocks, driver.config.NodeName, ns.features.FeatureMountHealthMonitor)
+		ns.mountHealth.initEventRecorder()
+	}
+	if ns.features.FeatureShareMigration != nil && ns.features.FeatureShareMigration.Enabled {
+		locator, err := newShareLocator(ns.features.FeatureShareMigration, ns.mountHealth, driver.config.NodeName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the share locator: %w", err)
+		}
+		ns.shareLocator = locator
+	}
+	if ns.features.FeatureNFSMountStatsMetrics != nil && ns.features.FeatureNFSMountStatsMetrics.Enabled && driver.config.Metrics != nil {
+		ns.stagedVolumes = newStagedVolumes()
+		driver.config.Metrics.RegisterNFSMountStatsCollector(ns.stagedVolumes.volumes)
+	}
+	if ns.features.FeatureEncryptionInTransit != nil && ns.features.FeatureEncryptionInTransit.Enabled {
+		ns.tlsTunnels = newTLSTunnelManager(ns.features.FeatureEncryptionInTransit)
+		if err := ns.tlsTunnels.restore(); err != nil {
+			return nil, fmt.Errorf("failed to restore the TLS tunnels: %w", err)
+		}
+	}
+	if ns.features.FeatureMountPolicy != nil && ns.features.FeatureMountPolicy.Enabled {
+		policy, err := newMountPolicy(ns.features.FeatureMountPolicy.ConfigFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the mount policy: %w", err)
+		}
+		ns.mountPolicy = policy
+	}
+	if ns.features.FeatureKerberos != nil && ns.features.FeatureKerberos.Enabled {
+		ns.kerberos = newKerberosCredentialManager(ns.features.FeatureKerberos)
+		if err := ns.kerberos.restore(); err != nil {
+			return nil, fmt.Errorf("failed to restore the Kerberos credentials: %w", err)
+		}
+	}
 	return ns, nil
 }
 
@@ -97,6 +145,9 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	if len(targetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume target path must be provided")
 	}
+	if isEphemeralVolume(req.GetVolumeContext()) {
+		return s.nodePublishEphemeralVolume(req)
+	}
 	if len(stagingTargetPath) == 0 {
 		return nil, status.Error(codes.InvalidArgument, "NodePublishVolume stagingTargetPath path must be provided")
 	}
@@ -104,6 +155,13 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	if err := s.driver.validateVolumeCapabilities([]*csi.VolumeCapability{req.GetVolumeCapability()}); err != nil {
 		return nil, status.Error(codes.InvalidArgument, err.Error())
 	}
+	mountGroup, err := parseVolumeMountGroup(req.GetVolumeCapability())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if readOnly || isReadOnlyAccessMode(req.GetVolumeCapability()) {
+		mountGroup = -1
+	}
 
 	// Acquire a lock on the target path instead of volumeID, since we do not want to serialize multiple node publish calls on the same volume.
 	if acquired := s.volumeLocks.TryAcquire(targetPath); !acquired {
@@ -111,44 +169,35 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 	}
 	defer s.volumeLocks.Release(targetPath)
 
-	var err error
+	// Windows nodes publish the SMB share linked from the staging path.
+	if goOs == "windows" {
+		return s.nodePublishSMBVolume(req)
+	}
+
 	// FileSystem type
 	fstype := "nfs"
 	// Mount options
 	options := []string{"bind"}
-	// Windows specific values (TODO: Revisit windows specific logic for bind mount)
-	if goOs == "windows" {
-		fstype = "cifs"
-
-		// Login credentials
-		secrets := req.GetSecrets()
-		if err := validateSmbNodePublishSecrets(secrets); err != nil {
-			return nil, status.Error(codes.InvalidArgument, err.Error())
-		}
-
-		options = append(options, secrets[optionSmbUser])
-		options = append(options, secrets[optionSmbPassword])
-
-		//TODO: Remove this workaround after https://github.com/kubernetes/kubernetes/issues/75535
-		if err := os.Remove(targetPath); err != nil && !os.IsNotExist(err) {
-			return nil, err
-		}
-	} else {
-		// TODO: If target path does not exist create it and then proceed to mount.
-		// (https://github.com/kubernetes-sigs/gcp-filestore-csi-driver/issues/47)
-		// Check kubernetes/kubernetes#75535. CO may create only the parent directory.
-		mounted, err := s.isDirMounted(targetPath)
-		if err != nil && !os.IsNotExist(err) {
-			return nil, err
-		}
-		if mounted {
-			return &csi.NodePublishVolumeResponse{}, nil
-		}
-		if os.IsNotExist(err) {
-			if mkdirErr := os.MkdirAll(targetPath, 0750); mkdirErr != nil {
-				return nil, status.Errorf(codes.Internal, "mkdir failed on path %s (%v)", targetPath, mkdirErr.Error())
+	// TODO: If target path does not exist create it and then proceed to mount.
+	// (https://github.com/kubernetes-sigs/gcp-filestore-csi-driver/issues/47)
+	// Check kubernetes/kubernetes#75535. CO may create only the parent directory.
+	mounted, err := s.isDirMounted(targetPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if mounted {
+		if mountGroup >= 0 {
+			if err := setVolumeMountGroup(targetPath, mountGroup); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to set the group of volume %v to %d: %v", req.GetVolumeId(), mountGroup, err)
 			}
 		}
+		s.mountHealth.publish(req.GetVolumeId(), targetPath, publishMountOptions(req))
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	if os.IsNotExist(err) {
+		if mkdirErr := os.MkdirAll(targetPath, 0750); mkdirErr != nil {
+			return nil, status.Errorf(codes.Internal, "mkdir failed on path %s (%v)", targetPath, mkdirErr.Error())
+		}
 	}
 
 	if readOnly {
@@ -158,7 +207,16 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		options = append(options, capMount.GetMountFlags()...)
 	}
 
-	err = s.mounter.Mount(stagingTargetPath, targetPath, fstype, options)
+	source := stagedVolumePath(req.GetVolumeId(), stagingTargetPath)
+	if source != stagingTargetPath {
+		if _, err := os.Stat(source); err != nil {
+			if os.IsNotExist(err) {
+				return nil, status.Errorf(codes.NotFound, "sub-directory of volume %v not found in its share", req.GetVolumeId())
+			}
+			return nil, status.Errorf(codes.Internal, "failed to stat %s: %v", source, err)
+		}
+	}
+	err = s.mounter.Mount(source, targetPath, fstype, options)
 	if err != nil {
 		klog.Errorf("Mount %q failed on node %s, cleaning up", targetPath, s.driver.config.NodeName)
 		if unmntErr := mount.CleanupMountPoint(stagingTargetPath, s.mounter, false /* extensiveMountPointCheck */); unmntErr != nil {
@@ -168,10 +226,29 @@ func (s *nodeServer) NodePublishVolume(ctx context.Context, req *csi.NodePublish
 		return nil, status.Errorf(codes.Internal, "mount %q failed: %v", targetPath, err.Error())
 	}
 
+	// The group of a volume staged for another pod is set for the fsGroup of this pod.
+	if mountGroup >= 0 {
+		if err := setVolumeMountGroup(targetPath, mountGroup); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to set the group of volume %v to %d: %v", req.GetVolumeId(), mountGroup, err)
+		}
+	}
+	s.mountHealth.publish(req.GetVolumeId(), targetPath, options)
 	klog.V(4).Infof("Successfully mounted %s on node %s", targetPath, s.driver.config.NodeName)
 	return &csi.NodePublishVolumeResponse{}, nil
 }
 
+// publishMountOptions returns the options of the bind mount of a publish path on Linux.
+func publishMountOptions(req *csi.NodePublishVolumeRequest) []string {
+	options := []string{"bind"}
+	if req.GetReadonly() {
+		options = append(options, "ro")
+	}
+	if capMount := req.GetVolumeCapability().GetMount(); capMount != nil {
+		options = append(options, capMount.GetMountFlags()...)
+	}
+	return options
+}
+
 // NodeUnpublishVolume unmounts the GCFS volume
 func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpublishVolumeRequest) (*csi.NodeUnpublishVolumeResponse, error) {
 	// Validate arguments
@@ -186,19 +263,27 @@ func (s *nodeServer) NodeUnpublishVolume(ctx context.Context, req *csi.NodeUnpub
 	}
 	defer s.volumeLocks.Release(targetPath)
 
+	if goOs == "windows" {
+		if err := removeSMBLink(targetPath); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return &csi.NodeUnpublishVolumeResponse{}, nil
+	}
 	if err := mount.CleanupMountPoint(targetPath, s.mounter, false /* extensiveMountPointCheck */); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	s.mountHealth.unpublish(req.GetVolumeId(), targetPath)
+	// The publish path of an ephemeral volume is its only mount.
+	s.stagedVolumes.unstage(targetPath)
+	s.subDirectoryUsage.evict(req.GetVolumeId())
 
 	return &csi.NodeUnpublishVolumeResponse{}, nil
 }
 
 func (s *nodeServer) NodeGetInfo(ctx context.Context, req *csi.NodeGetInfoRequest) (*csi.NodeGetInfoResponse, error) {
 	return &csi.NodeGetInfoResponse{
-		NodeId: s.driver.config.NodeName,
-		AccessibleTopology: &csi.Topology{
-			Segments: map[string]string{TopologyKeyZone: s.metaService.GetZone()},
-		},
+		NodeId:             s.driver.config.NodeName,
+		AccessibleTopology: nodeTopology(s.metaService.GetZone(), s.features.FeatureVolumeTopology != nil && s.features.FeatureVolumeTopology.Enabled),
 	}, nil
 }
 
@@ -216,6 +301,14 @@ func (s *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVol
 		return nil, status.Error(codes.InvalidArgument, "NodeGetVolumeStats volume path was empty")
 	}
 
+	// The path of an unhealthy mount is not stat'ed, a stat of a hung mount would block.
+	condition, monitored := s.mountHealth.condition(req.VolumeId)
+	if monitored && condition.abnormal {
+		return &csi.NodeGetVolumeStatsResponse{
+			VolumeCondition: &csi.VolumeCondition{Abnormal: true, Message: condition.message},
+		}, nil
+	}
+
 	_, err := os.Lstat(req.VolumePath)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -226,10 +319,32 @@ func (s *nodeServer) NodeGetVolumeStats(ctx context.Context, req *csi.NodeGetVol
 
 	available, capacity, used, inodesFree, inodes, inodesUsed, err := getFSStat(req.VolumePath)
 	if err != nil {
+		if monitored {
+			return &csi.NodeGetVolumeStatsResponse{
+				VolumeCondition: &csi.VolumeCondition{Abnormal: true, Message: err.Error()},
+			}, nil
+		}
 		return nil, status.Errorf(codes.Internal, "failed to get fs info on path %s: %v", req.VolumePath, err.Error())
 	}
+	// The share of a sub-directory volume is shared with other volumes, its usage is reported against
+	// the logical capacity of the volume instead.
+	if isSubDirectoryVolId(req.VolumeId) {
+		capacity, used, err = s.getSubDirectoryStat(req.VolumeId, req.VolumePath, req.GetStagingTargetPath())
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to get sub-directory usage on path %s: %v", req.VolumePath, err.Error())
+		}
+		available = capacity - used
+		if available < 0 {
+			available = 0
+		}
+	}
 
+	var volumeCondition *csi.VolumeCondition
+	if monitored {
+		volumeCondition = &csi.VolumeCondition{Abnormal: false, Message: condition.message}
+	}
 	return &csi.NodeGetVolumeStatsResponse{
+		VolumeCondition: volumeCondition,
 		Usage: []*csi.VolumeUsage{
 			{
 				Unit:      csi.VolumeUsage_BYTES,
@@ -266,10 +381,22 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 	if err := validateVolumeCapability(volumeCapability); err != nil {
 		return nil, status.Errorf(codes.InvalidArgument, "VolumeCapability is invalid: %v", err.Error())
 	}
+	// Windows nodes stage the SMB share of the volume instead of mounting it with NFS.
+	if goOs == "windows" {
+		return s.nodeStageSMBVolume(req)
+	}
+	mountGroup, err := parseVolumeMountGroup(volumeCapability)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if isReadOnlyAccessMode(volumeCapability) {
+		mountGroup = -1
+	}
 
 	// Validate volume attributes
 	var source string
 	attr := req.GetVolumeContext()
+	ip := attr[attrIP]
 	if isMultishareVolId(volumeID) {
 		if err := validateMultishareVolumeAttributes(attr); err != nil {
 			return nil, status.Error(codes.InvalidArgument, err.Error())
@@ -278,12 +405,24 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 		if err != nil {
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
-		source = fmt.Sprintf("%s:/%s", attr[attrIP], shareName)
+		// The share may have been migrated to another instance than the one of the volume context.
+		ip = s.shareLocator.instanceIP(ctx, shareName, ip)
+		source = fmt.Sprintf("%s:/%s", ip, shareName)
+	} else if isSubDirectoryVolId(volumeID) {
+		if err := validateVolumeAttributes(attr); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		if _, _, err := parseSubDirectoryVolumeID(volumeID); err != nil {
+			return nil, status.Error(codes.InvalidArgument, err.Error())
+		}
+		// The share is staged, so that the metadata of the volume is read on the node. Only the
+		// sub-directory of the volume is published.
+		source = fmt.Sprintf("%s:/%s", ip, attr[attrVolume])
 	} else {
 		if err := validateVolumeAttributes(attr); err != nil {
 			return nil, status.Error(codes.InvalidArgument, err.Error())
 		}
-		source = fmt.Sprintf("%s:/%s", attr[attrIP], attr[attrVolume])
+		source = fmt.Sprintf("%s:/%s", ip, attr[attrVolume])
 	}
 
 	if acquired := s.volumeLocks.TryAcquire(volumeID); !acquired {
@@ -307,6 +446,60 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 		fileProtocol = v3FileProtocol
 	}
 
+	fstype := "nfs"
+	options, err := s.mountPolicy.apply(stageMountOptions(volumeCapability), attr, policyFileProtocol(attr, fileProtocol), volumeCapability)
+	if err != nil {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid mount options of volume %v: %v", volumeID, err)
+	}
+	// A volume encrypted in transit is mounted through the local endpoint of its TLS tunnel. NFSv3 needs
+	// the mount and lock protocols on other ports, so only NFSv4.1 is tunneled.
+	encrypted := strings.ToLower(attr[attrEncryptionInTransit]) == "true"
+	if encrypted {
+		if s.tlsTunnels == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %v is encrypted in transit, which is not enabled on node %s", volumeID, s.driver.config.NodeName)
+		}
+		if fileProtocol != v4_1FileProtocol {
+			return nil, status.Errorf(codes.InvalidArgument, "encryption in transit of volume %v requires the %s protocol", volumeID, v4_1FileProtocol)
+		}
+		if !s.tlsTunnels.verifiesServers() {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %v is encrypted in transit, which requires a CA bundle to verify the NFS server on node %s", volumeID, s.driver.config.NodeName)
+		}
+		port, err := s.tlsTunnels.start(volumeID, ip)
+		if err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to start the TLS tunnel of volume %v: %v", volumeID, err)
+		}
+		source = tlsTunnelHost + source[strings.Index(source, ":"):]
+		options = tlsTunnelMountOptions(options, port)
+	}
+	// A volume with a Kerberos security flavor is mounted with the credential cache rpc.gssd looks up, which
+	// is obtained from the keytab of its secrets. The server is mounted by its hostname, which Kerberos
+	// authenticates.
+	if securityFlavor := strings.ToLower(attr[attrSecurityFlavor]); isKerberosSecurityFlavor(securityFlavor) {
+		if s.kerberos == nil {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %v has the Kerberos security flavor %s, which is not enabled on node %s", volumeID, securityFlavor, s.driver.config.NodeName)
+		}
+		if fileProtocol != v4_1FileProtocol {
+			return nil, status.Errorf(codes.InvalidArgument, "security flavor %s of volume %v requires the %s protocol", securityFlavor, volumeID, v4_1FileProtocol)
+		}
+		if encrypted {
+			return nil, status.Errorf(codes.InvalidArgument, "volume %v can't be encrypted in transit with the security flavor %s", volumeID, securityFlavor)
+		}
+		principal, keytab, err := parseKerberosSecrets(req.GetSecrets())
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid Kerberos secrets of volume %v: %v", volumeID, err)
+		}
+		if err := s.kerberos.stage(volumeID, principal, keytab); err != nil {
+			if errors.Is(err, errKerberosPrincipalConflict) {
+				return nil, status.Errorf(codes.FailedPrecondition, "failed to stage the Kerberos credentials of volume %v on node %s: %v", volumeID, s.driver.config.NodeName, err)
+			}
+			return nil, status.Errorf(codes.Internal, "failed to stage the Kerberos credentials of volume %v: %v", volumeID, err)
+		}
+		if serverName := attr[attrServerName]; serverName != "" {
+			source = serverName + source[strings.Index(source, ":"):]
+		}
+		options = kerberosMountOptions(options, securityFlavor)
+	}
+
 	if mounted {
 		if fileProtocol == v3FileProtocol && s.features.FeatureLockRelease.Enabled {
 			klog.V(4).Infof("NodeStageVolume mounted volume %v to staging target path %s, mount already exists on node %s. Proceed to lock info configmap updates", volumeID, stagingTargetPath, s.driver.config.NodeName)
@@ -314,6 +507,16 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 				return nil, status.Errorf(codes.Internal, "failed to store lock info after NodeStageVolume succeeded on volume %v to path %s: %v", volumeID, stagingTargetPath, err.Error())
 			}
 		}
+		if mountGroup >= 0 {
+			if err := setVolumeMountGroup(stagedVolumePath(volumeID, stagingTargetPath), mountGroup); err != nil {
+				return nil, status.Errorf(codes.Internal, "failed to set the group of volume %v to %d: %v", volumeID, mountGroup, err)
+			}
+		}
+		s.mountHealth.stage(volumeID, stagingTargetPath, source, fstype, options)
+		s.stagedVolumes.stage(volumeID, stagingTargetPath)
+		if isMultishareVolId(volumeID) {
+			s.shareLocator.stage(volumeID, ip)
+		}
 		klog.V(4).Infof("NodeStageVolume succeeded on volume %v to staging target path %s on node %s, mount already exists.", volumeID, stagingTargetPath, s.driver.config.NodeName)
 		return &csi.NodeStageVolumeResponse{}, nil
 	}
@@ -325,19 +528,18 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 		}
 	}
 
-	fstype := "nfs"
-	options := []string{}
-	if mnt := volumeCapability.GetMount(); mnt != nil {
-		for _, flag := range mnt.MountFlags {
-			options = append(options, flag)
-		}
-	}
-
 	err = s.mounter.Mount(source, stagingTargetPath, fstype, options)
 	if err != nil {
 		klog.Errorf("Mount %q failed on node %s, cleaning up", stagingTargetPath, s.driver.config.NodeName)
 		if unmntErr := mount.CleanupMountPoint(stagingTargetPath, s.mounter, false /* extensiveMountPointCheck */); unmntErr != nil {
 			klog.Errorf("Unmount %q failed on node %s: %v", stagingTargetPath, s.driver.config.NodeName, unmntErr.Error())
+		} else {
+			if stopErr := s.tlsTunnels.stop(volumeID); stopErr != nil {
+				klog.Errorf("Failed to stop the TLS tunnel of volume %v: %v", volumeID, stopErr)
+			}
+			if unstageErr := s.kerberos.unstage(volumeID); unstageErr != nil {
+				klog.Errorf("Failed to remove the Kerberos credentials of volume %v: %v", volumeID, unstageErr)
+			}
 		}
 		return nil, status.Errorf(codes.Internal, "mount %q failed on node %s: %v", stagingTargetPath, s.driver.config.NodeName, err.Error())
 	}
@@ -349,10 +551,70 @@ func (s *nodeServer) NodeStageVolume(ctx context.Context, req *csi.NodeStageVolu
 		}
 	}
 
+	if mountGroup >= 0 {
+		if err := setVolumeMountGroup(stagedVolumePath(volumeID, stagingTargetPath), mountGroup); err != nil {
+			return nil, status.Errorf(codes.Internal, "failed to set the group of volume %v to %d: %v", volumeID, mountGroup, err)
+		}
+	}
+
+	s.mountHealth.stage(volumeID, stagingTargetPath, source, fstype, options)
+	s.stagedVolumes.stage(volumeID, stagingTargetPath)
+	if isMultishareVolId(volumeID) {
+		s.shareLocator.stage(volumeID, ip)
+	}
 	klog.V(4).Infof("NodeStageVolume succeeded on volume %v to path %s on node %s", volumeID, stagingTargetPath, s.driver.config.NodeName)
 	return &csi.NodeStageVolumeResponse{}, nil
 }
 
+// tlsTunnelMountOptions adds the local port of the TLS tunnel of a volume to its mount options, and
+// pins the NFS version to 4.1 unless set.
+func tlsTunnelMountOptions(options []string, port int) []string {
+	tunnelOptions := append([]string{}, options...)
+	return withNFSv41(append(tunnelOptions, fmt.Sprintf("port=%d", port)))
+}
+
+// kerberosMountOptions adds the Kerberos security flavor of a volume to its mount options, and pins the
+// NFS version to 4.1 unless set.
+func kerberosMountOptions(options []string, securityFlavor string) []string {
+	kerberosOptions := []string{}
+	for _, option := range options {
+		if !strings.HasPrefix(option, "sec=") {
+			kerberosOptions = append(kerberosOptions, option)
+		}
+	}
+	return withNFSv41(append(kerberosOptions, "sec="+securityFlavor))
+}
+
+// withNFSv41 pins the NFS version of mount options to 4.1 unless set.
+func withNFSv41(options []string) []string {
+	for _, option := range options {
+		if strings.HasPrefix(option, "vers=") || strings.HasPrefix(option, "nfsvers=") {
+			return options
+		}
+	}
+	return append(options, "vers=4.1")
+}
+
+// policyFileProtocol returns the file protocol the mount policy sets the NFS version of a volume from, the
+// protocol of its volume attributes if it is mounted with it.
+func policyFileProtocol(attr map[string]string, fileProtocol string) string {
+	if attr[attrFileProtocol] != fileProtocol {
+		return ""
+	}
+	return fileProtocol
+}
+
+// stageMountOptions returns the options of the mount of a staging path.
+func stageMountOptions(volumeCapability *csi.VolumeCapability) []string {
+	options := []string{}
+	if mnt := volumeCapability.GetMount(); mnt != nil {
+		for _, flag := range mnt.MountFlags {
+			options = append(options, flag)
+		}
+	}
+	return options
+}
+
 func (s *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstageVolumeRequest) (*csi.NodeUnstageVolumeResponse, error) {
 	// Validate arguments
 	volumeID := req.GetVolumeId()
@@ -369,9 +631,26 @@ func (s *nodeServer) NodeUnstageVolume(ctx context.Context, req *csi.NodeUnstage
 	}
 	defer s.volumeLocks.Release(volumeID)
 
+	if goOs == "windows" {
+		if err := s.unstageSMBVolume(stagingTargetPath); err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		klog.V(4).Infof("NodeUnstageVolume succeeded on volume %v from staging target path %s on node %s", volumeID, stagingTargetPath, s.driver.config.NodeName)
+		return &csi.NodeUnstageVolumeResponse{}, nil
+	}
 	if err := mount.CleanupMountPoint(stagingTargetPath, s.mounter, false /* extensiveMountPointCheck */); err != nil {
 		return nil, status.Error(codes.Internal, err.Error())
 	}
+	s.mountHealth.unstage(volumeID)
+	s.stagedVolumes.unstage(stagingTargetPath)
+	s.shareLocator.unstage(volumeID)
+	s.subDirectoryUsage.evict(volumeID)
+	if err := s.tlsTunnels.stop(volumeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to stop the TLS tunnel of volume %v: %v", volumeID, err)
+	}
+	if err := s.kerberos.unstage(volumeID); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to remove the Kerberos credentials of volume %v: %v", volumeID, err)
+	}
 
 	if s.features.FeatureLockRelease.Enabled {
 		klog.V(4).Infof("NodeUnstageVolume succeeded on volume %v from staging target path %s on node %s, proceed to lock info configmap updates", volumeID, stagingTargetPath, s.driver.config.NodeName)
@@ -444,7 +723,7 @@ func validateVolumeAttributes(attr map[string]string) error {
 	return nil
 }
 
-func validateSmbNodePublishSecrets(secrets map[string]string) error {
+func validateSmbNodeStageSecrets(secrets map[string]string) error {
 	if secrets[optionSmbUser] == "" {
 		return fmt.Errorf("secret %v not set", optionSmbUser)
 	}
@@ -483,24 +762,41 @@ func validateMultishareVolumeAttributes(attr map[string]string) error {
 	return nil
 }
 
-func getFSStat(path string) (available, capacity, used, inodesFree, inodes, inodesUsed int64, err error) {
-	statfs := &unix.Statfs_t{}
-	err = unix.Statfs(path, statfs)
+// stagedVolumePath returns the path of a volume staged at stagingPath: the sub-directory of the staged share
+// of a sub-directory volume, the staging path otherwise.
+func stagedVolumePath(volumeID, stagingPath string) string {
+	if !isSubDirectoryVolId(volumeID) {
+		return stagingPath
+	}
+	_, subDirectory, err := parseSubDirectoryVolumeID(volumeID)
+	if err != nil {
+		return stagingPath
+	}
+	return filepath.Join(stagingPath, subDirectory)
+}
+
+// getSubDirectoryStat returns the logical capacity of the sub-directory volume mounted at path, read from
+// the metadata in its staged share, and the apparent size of its files. The files are walked at most once
+// per subDirectoryUsageCacheTTL, like the du of an emptyDir volume.
+func (s *nodeServer) getSubDirectoryStat(volumeID, path, stagingPath string) (capacity, used int64, err error) {
+	if stagingPath == "" {
+		return 0, 0, fmt.Errorf("the staging path of sub-directory volume %s is required", volumeID)
+	}
+	_, subDirectory, err := parseSubDirectoryVolumeID(volumeID)
 	if err != nil {
-		err = fmt.Errorf("failed to get fs info on path %s: %w", path, err)
-		return
-	}
-
-	// Available is blocks available * fragment size to root user
-	available = int64(statfs.Bfree) * int64(statfs.Bsize)
-	// Capacity is total block count * fragment size
-	capacity = int64(statfs.Blocks) * int64(statfs.Bsize)
-	// Usage is block being used * fragment size (aka block size).
-	used = (int64(statfs.Blocks) - int64(statfs.Bfree)) * int64(statfs.Bsize)
-	inodes = int64(statfs.Files)
-	inodesFree = int64(statfs.Ffree)
-	inodesUsed = inodes - inodesFree
-	return
+		return 0, 0, err
+	}
+	metadata, err := readSubDirectoryMetadata(stagingPath, subDirectory)
+	if err != nil {
+		return 0, 0, err
+	}
+	used, err = s.subDirectoryUsage.get(volumeID, func() (int64, error) {
+		return diskUsage(path)
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	return metadata.CapacityBytes, used, nil
 }
 
 // nodeStageVolumeUpdateLockInfo updates lock info after NodeStageVolume succeed.
@@ -22,11 +22,13 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	csi "github.com/container-storage-interface/spec/lib/go/csi"
 	"github.com/google/go-cmp/cmp"
 	"golang.org/x/net/context"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 	corev1 "k8s.io/api/core/v1"
 	apiError "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -64,13 +66,6 @@ var (
 		attrSupportLockRelease: "true",
 	}
 	testDevice = "1.1.1.1:/test-volume"
-
-	testWindowsValidPath = "C:\\test"
-	testWindowsSecrets   = map[string]string{
-		optionSmbUser:     "foo",
-		optionSmbPassword: "bar",
-	}
-	testWindowsDevice = "\\\\1.1.1.1\\test-volume"
 )
 
 type nodeServerTestEnv struct {
@@ -367,106 +362,6 @@ func TestNodePublishVolume(t *testing.T) {
 	}
 }
 
-// TODO: Revisit windows tests
-func testWindowsNodePublishVolume(t *testing.T) {
-	defaultPerm := os.FileMode(0750) + os.ModeDir
-	defaultOsString := goOs
-
-	// Setup mount target path
-	base, err := ioutil.TempDir("", "node-publish-")
-	if err != nil {
-		t.Fatalf("failed to setup testdir: %v", err)
-	}
-	testTargetPath := filepath.Join(base, "mount")
-	if err = os.MkdirAll(testTargetPath, defaultPerm); err != nil {
-		t.Fatalf("failed to setup target path: %v", err)
-	}
-	defer os.RemoveAll(base)
-
-	goOs = "windows"
-
-	cases := []struct {
-		name          string
-		mounts        []mount.MountPoint // already existing mounts
-		req           *csi.NodePublishVolumeRequest
-		actions       []mount.FakeAction
-		expectedMount *mount.MountPoint
-		expectErr     bool
-	}{
-		// TODO: enable this test after https://github.com/kubernetes/kubernetes/issues/81609
-
-		// {
-		// 	name:     "windows target path does exist",
-		// 	req: &csi.NodePublishVolumeRequest{
-		// 		VolumeId:         testVolumeID,
-		// 		TargetPath:       testTargetPath,
-		// 		VolumeCapability: testVolumeCapability,
-		// 		VolumeAttributes: testVolumeAttributes,
-		// 		NodePublishSecrets: testWindowsSecrets
-		// 	},
-		// 	expectErr: true,
-		// },
-		{
-			name: "windows target path doesn't exist",
-			req: &csi.NodePublishVolumeRequest{
-				VolumeId:         testVolumeID,
-				TargetPath:       testWindowsValidPath,
-				VolumeCapability: testVolumeCapability,
-				VolumeContext:    testVolumeAttributes,
-				Secrets:          testWindowsSecrets,
-			},
-
-			actions:       []mount.FakeAction{{Action: mount.FakeActionMount}},
-			expectedMount: &mount.MountPoint{Device: testWindowsDevice, Path: testWindowsValidPath, Type: "cifs", Opts: []string{"foo", "bar"}},
-		},
-		{
-			name: "windows no user",
-			req: &csi.NodePublishVolumeRequest{
-				VolumeId:         testVolumeID,
-				TargetPath:       testWindowsValidPath,
-				VolumeCapability: testVolumeCapability,
-				VolumeContext:    testVolumeAttributes,
-				Secrets: map[string]string{
-					optionSmbPassword: "bar",
-				},
-			},
-			expectErr: true,
-		},
-		{
-			name: "windows no password",
-			req: &csi.NodePublishVolumeRequest{
-				VolumeId:         testVolumeID,
-				TargetPath:       testWindowsValidPath,
-				VolumeCapability: testVolumeCapability,
-				VolumeContext:    testVolumeAttributes,
-				Secrets: map[string]string{
-					optionSmbUser: "foo",
-				},
-			},
-			expectErr: true,
-		},
-	}
-
-	for _, test := range cases {
-		testEnv := initTestNodeServer(t)
-		if test.mounts != nil {
-			testEnv.fm.MountPoints = test.mounts
-		}
-
-		_, err = testEnv.ns.NodePublishVolume(context.TODO(), test.req)
-		if !test.expectErr && err != nil {
-			t.Errorf("test %q failed: %v", test.name, err)
-		}
-		if test.expectErr && err == nil {
-			t.Errorf("test %q failed: got success", test.name)
-		}
-
-		validateMountPoint(t, test.name, testEnv.fm, test.expectedMount)
-		// TODO: ValidateMountActions if possible.
-	}
-	goOs = defaultOsString
-}
-
 func TestNodeUnpublishVolume(t *testing.T) {
 	defaultPerm := os.FileMode(0750) + os.ModeDir
 
@@ -587,8 +482,29 @@ func TestValidateVolumeAttributes(t *testing.T) {
 func TestNodeGetId(t *testing.T) {
 }
 
-// TODO
 func TestNodeGetInfo(t *testing.T) {
+	ns := initTestNodeServer(t).ns.(*nodeServer)
+	resp, err := ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo failed: %v", err)
+	}
+	zone := ns.metaService.GetZone()
+	if resp.GetNodeId() != "test-node" || resp.GetAccessibleTopology().GetSegments()[TopologyKeyZone] != zone {
+		t.Errorf("got node %q in topology %v, expected test-node in zone %s", resp.GetNodeId(), resp.GetAccessibleTopology().GetSegments(), zone)
+	}
+	if _, ok := resp.GetAccessibleTopology().GetSegments()[TopologyKeyRegion]; ok {
+		t.Errorf("got region segment without the volume topology feature")
+	}
+
+	ns.features.FeatureVolumeTopology = &FeatureVolumeTopology{Enabled: true}
+	resp, err = ns.NodeGetInfo(context.Background(), &csi.NodeGetInfoRequest{})
+	if err != nil {
+		t.Fatalf("NodeGetInfo failed: %v", err)
+	}
+	region, _ := util.GetRegionFromZone(zone)
+	if got := resp.GetAccessibleTopology().GetSegments()[TopologyKeyRegion]; got != region {
+		t.Errorf("got region %q, expected %q", got, region)
+	}
 }
 
 // TODO
@@ -668,6 +584,111 @@ func TestNodeGetVolumeStats(t *testing.T) {
 
 }
 
+func TestSubDirectoryVolume(t *testing.T) {
+	testEnv := initTestNodeServer(t)
+	ns := testEnv.ns
+	now := time.Now()
+	ns.(*nodeServer).subDirectoryUsage.now = func() time.Time { return now }
+	tempDir := t.TempDir()
+	stagingPath := filepath.Join(tempDir, "staging")
+	targetPath := filepath.Join(tempDir, "mount")
+	volumeID := "modeSubdirectory/us-central1-c/shared/test-volume/pvc-1"
+	volumeContext := map[string]string{
+		attrIP:           "1.1.1.1",
+		attrVolume:       "test-volume",
+		attrSubDirectory: "pvc-1",
+	}
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     volumeContext,
+	})
+	if err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	validateMountPoint(t, "stage sub-directory", testEnv.fm, &mount.MountPoint{Device: testDevice, Path: stagingPath, Type: "nfs"})
+
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          volumeID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     volumeContext,
+	}
+	if _, err := ns.NodePublishVolume(context.Background(), publishReq); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v publishing a missing sub-directory, expected NotFound", err)
+	}
+
+	// The fake mounter doesn't mount the share, write the sub-directory of the volume and its metadata in
+	// the staging path.
+	volumePath := filepath.Join(stagingPath, "pvc-1")
+	if err := os.Mkdir(volumePath, 0755); err != nil {
+		t.Fatalf("Failed to create sub-directory: %v", err)
+	}
+	if err := writeSubDirectoryMetadata(stagingPath, "pvc-1", &subDirectoryMetadata{CapacityBytes: 1000}); err != nil {
+		t.Fatalf("Failed to write metadata: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(volumePath, "data"), make([]byte, 300), 0644); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+	testEnv.fm.ResetLog()
+	if _, err := ns.NodePublishVolume(context.Background(), publishReq); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	if log := testEnv.fm.GetLog(); len(log) != 1 || log[0].Source != volumePath || log[0].Target != targetPath {
+		t.Errorf("got mount actions %+v, expected a bind mount of %s", log, volumePath)
+	}
+
+	// The fake mounter doesn't bind mount the sub-directory, its usage is read from the sub-directory.
+	stats := func() *csi.VolumeUsage {
+		t.Helper()
+		resp, err := ns.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: volumeID, VolumePath: volumePath, StagingTargetPath: stagingPath})
+		if err != nil {
+			t.Fatalf("NodeGetVolumeStats failed: %v", err)
+		}
+		return resp.Usage[0]
+	}
+	if usage := stats(); usage.Total != 1000 || usage.Used != 300 || usage.Available != 700 {
+		t.Errorf("got usage %+v, expected total 1000 and used 300", usage)
+	}
+
+	// The usage is measured again once the cached usage expires.
+	if err := os.WriteFile(filepath.Join(volumePath, "more-data"), make([]byte, 200), 0644); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+	if usage := stats(); usage.Used != 300 {
+		t.Errorf("got used %d bytes, expected the cached 300 bytes", usage.Used)
+	}
+	now = now.Add(subDirectoryUsageCacheTTL)
+	if usage := stats(); usage.Used != 500 {
+		t.Errorf("got used %d bytes, expected 500 bytes", usage.Used)
+	}
+
+	// The cached usage is evicted when the volume is unpublished.
+	if err := os.WriteFile(filepath.Join(volumePath, "even-more-data"), make([]byte, 100), 0644); err != nil {
+		t.Fatalf("Failed to write data: %v", err)
+	}
+	if usage := stats(); usage.Used != 500 {
+		t.Errorf("got used %d bytes, expected the cached 500 bytes", usage.Used)
+	}
+	if _, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: volumeID, TargetPath: targetPath}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+	if usage := stats(); usage.Used != 600 {
+		t.Errorf("got used %d bytes, expected 600 bytes", usage.Used)
+	}
+
+	// A sub-directory volume without metadata has no capacity to report.
+	if err := removeSubDirectoryMetadata(stagingPath, "pvc-1"); err != nil {
+		t.Fatalf("Failed to remove metadata: %v", err)
+	}
+	if _, err := ns.NodeGetVolumeStats(context.Background(), &csi.NodeGetVolumeStatsRequest{VolumeId: volumeID, VolumePath: volumePath, StagingTargetPath: stagingPath}); err == nil {
+		t.Errorf("NodeGetVolumeStats succeeded without metadata")
+	}
+}
+
 func validateMountPoint(t *testing.T, name string, fm *mount.FakeMounter, e *mount.MountPoint) {
 	if e == nil {
 		if len(fm.MountPoints) != 0 {
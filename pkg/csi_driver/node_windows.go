@@ -0,0 +1,58 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/windows"
+)
+
+// getFSStat returns the usage of the SMB share a volume path links to. SMB doesn't report inodes, so
+// their counts are zero.
+func getFSStat(path string) (available, capacity, used, inodesFree, inodes, inodesUsed int64, err error) {
+	pathPtr, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		err = fmt.Errorf("invalid path %s: %w", path, err)
+		return
+	}
+	var freeBytesAvailable, totalBytes, totalFreeBytes uint64
+	if err = windows.GetDiskFreeSpaceEx(pathPtr, &freeBytesAvailable, &totalBytes, &totalFreeBytes); err != nil {
+		err = fmt.Errorf("failed to get fs info on path %s: %w", path, err)
+		return
+	}
+
+	available = int64(freeBytesAvailable)
+	capacity = int64(totalBytes)
+	used = int64(totalBytes) - int64(totalFreeBytes)
+	return
+}
+
+// The mounts of Windows nodes are not monitored, the probes of the mount health monitor are not used.
+
+func statfsPath(path string) error {
+	_, _, _, _, _, _, err := getFSStat(path)
+	return err
+}
+
+func lazyUnmountPath(path string) error {
+	return fmt.Errorf("lazy unmount of %s is not supported on Windows", path)
+}
+
+func isStaleFileHandle(err error) bool {
+	return false
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// Placement policies of the shares of multishare volumes, selected by the placement-policy StorageClass
+// parameter.
+const (
+	placementPolicyFirstFit          = "first-fit"
+	placementPolicyBestFit           = "best-fit"
+	placementPolicySpreadByNamespace = "spread-by-namespace"
+	placementPolicyLeastShareCount   = "least-share-count"
+)
+
+// PlacementShare is a new share of a multishare volume to place on an instance of its pool.
+type PlacementShare struct {
+	Name          string
+	CapacityBytes int64
+	// Namespace is the namespace of the PVC of the volume, empty unless the provisioner passes the PVC
+	// metadata.
+	Namespace string
+}
+
+// PlacementCandidate is an instance of a pool eligible for a new share.
+type PlacementCandidate struct {
+	// Name is the URI of the instance.
+	Name          string
+	CapacityBytes int64
+	// UsedBytes is the sum of the capacity of the shares of the instance.
+	UsedBytes  int64
+	ShareCount int
+	// Namespaces counts the shares of the instance by the namespace of their PVC.
+	Namespaces map[string]int
+}
+
+// RemainingBytes returns the capacity of the instance not used by its shares.
+func (c *PlacementCandidate) RemainingBytes() int64 {
+	return c.CapacityBytes - c.UsedBytes
+}
+
+// PlacementPolicy picks the instance of a pool a new share is placed on. The multishare controllers only
+// pass the instances that are eligible for the share, which are grown if the share doesn't fit in their
+// remaining capacity.
+type PlacementPolicy interface {
+	// Name returns the value of the placement-policy parameter selecting the policy.
+	Name() string
+	// Place returns the candidate the share is placed on. The candidates are sorted by name, and never
+	// empty.
+	Place(share *PlacementShare, candidates []*PlacementCandidate) *PlacementCandidate
+}
+
+var placementPolicies = map[string]PlacementPolicy{
+	placementPolicyFirstFit:          firstFitPolicy{},
+	placementPolicyBestFit:           bestFitPolicy{},
+	placementPolicySpreadByNamespace: spreadByNamespacePolicy{},
+	placementPolicyLeastShareCount:   leastShareCountPolicy{},
+}
+
+// placementPolicyFromParams returns the placement policy selected by the parameters of a StorageClass,
+// first-fit if unset.
+func placementPolicyFromParams(params map[string]string) (PlacementPolicy, error) {
+	for k, v := range params {
+		if strings.ToLower(k) != paramPlacementPolicy {
+			continue
+		}
+		policy, ok := placementPolicies[strings.ToLower(v)]
+		if !ok {
+			return nil, fmt.Errorf("invalid %s %q, must be one of %q, %q, %q or %q", paramPlacementPolicy, v, placementPolicyFirstFit, placementPolicyBestFit, placementPolicySpreadByNamespace, placementPolicyLeastShareCount)
+		}
+		return policy, nil
+	}
+	return firstFitPolicy{}, nil
+}
+
+// placeShare returns the candidate a policy places a share on, or nil if there are no candidates and a
+// new instance is needed.
+func placeShare(policy PlacementPolicy, share *PlacementShare, candidates []*PlacementCandidate) *PlacementCandidate {
+	if len(candidates) == 0 {
+		return nil
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+	return policy.Place(share, candidates)
+}
+
+// firstFitPolicy places a share on the first eligible instance.
+type firstFitPolicy struct{}
+
+func (firstFitPolicy) Name() string { return placementPolicyFirstFit }
+
+func (firstFitPolicy) Place(share *PlacementShare, candidates []*PlacementCandidate) *PlacementCandidate {
+	return candidates[0]
+}
+
+// bestFitPolicy places a share on the instance with the least remaining capacity the share fits in, which
+// packs the pool on the fewest instances. If the share fits in none, it is placed on the instance with the
+// most remaining capacity, which needs the least growth.
+type bestFitPolicy struct{}
+
+func (bestFitPolicy) Name() string { return placementPolicyBestFit }
+
+func (bestFitPolicy) Place(share *PlacementShare, candidates []*PlacementCandidate) *PlacementCandidate {
+	var best, largest *PlacementCandidate
+	for _, c := range candidates {
+		if c.RemainingBytes() >= share.CapacityBytes && (best == nil || c.RemainingBytes() < best.RemainingBytes()) {
+			best = c
+		}
+		if largest == nil || c.RemainingBytes() > largest.RemainingBytes() {
+			largest = c
+		}
+	}
+	if best != nil {
+		return best
+	}
+	return largest
+}
+
+// spreadByNamespacePolicy places a share on the instance with the fewest shares of the namespace of the
+// share, so that the volumes of a namespace don't all depend on one instance, then with the fewest shares.
+type spreadByNamespacePolicy struct{}
+
+func (spreadByNamespacePolicy) Name() string { return placementPolicySpreadByNamespace }
+
+func (spreadByNamespacePolicy) Place(share *PlacementShare, candidates []*PlacementCandidate) *PlacementCandidate {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if n, bestN := c.Namespaces[share.Namespace], best.Namespaces[share.Namespace]; n < bestN || (n == bestN && c.ShareCount < best.ShareCount) {
+			best = c
+		}
+	}
+	return best
+}
+
+// leastShareCountPolicy places a share on the instance with the fewest shares, which spreads the load of a
+// pool over its instances.
+type leastShareCountPolicy struct{}
+
+func (leastShareCountPolicy) Name() string { return placementPolicyLeastShareCount }
+
+func (leastShareCountPolicy) Place(share *PlacementShare, candidates []*PlacementCandidate) *PlacementCandidate {
+	best := candidates[0]
+	for _, c := range candidates[1:] {
+		if c.ShareCount < best.ShareCount {
+			best = c
+		}
+	}
+	return best
+}
+
+// instancePlacementCandidate returns the placement candidate of an instance with its shares.
+func instancePlacementCandidate(instance *file.MultishareInstance, shares []*file.Share) (*PlacementCandidate, error) {
+	uri, err := file.GenerateMultishareInstanceURI(instance)
+	if err != nil {
+		return nil, err
+	}
+	c := &PlacementCandidate{
+		Name:          uri,
+		CapacityBytes: instance.CapacityBytes,
+		ShareCount:    len(shares),
+		Namespaces:    map[string]int{},
+	}
+	for _, s := range shares {
+		c.UsedBytes += s.CapacityBytes
+		c.Namespaces[s.Labels[tagKeyCreatedForClaimNamespace]]++
+	}
+	return c, nil
+}
+
+// instanceInfoPlacementCandidate returns the placement candidate of an instanceInfo of the stateful
+// multishare controller, with the shareInfos of the shares assigned to it.
+func instanceInfoPlacementCandidate(instanceInfo *v1.InstanceInfo, shareInfos map[string]*v1.ShareInfo) *PlacementCandidate {
+	c := &PlacementCandidate{
+		Name:          util.InstanceInfoNameToInstanceURI(instanceInfo.Name),
+		CapacityBytes: instanceInfo.Spec.CapacityBytes,
+		Namespaces:    map[string]int{},
+	}
+	if instanceInfo.Status == nil {
+		return c
+	}
+	c.ShareCount = len(instanceInfo.Status.ShareNames)
+	for _, name := range instanceInfo.Status.ShareNames {
+		shareInfo, ok := shareInfos[name]
+		if !ok {
+			continue
+		}
+		c.UsedBytes += shareInfo.Spec.CapacityBytes
+		c.Namespaces[shareInfo.Spec.Parameters[ParameterKeyPVCNamespace]]++
+	}
+	return c
+}
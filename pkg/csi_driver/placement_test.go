@@ -0,0 +1,325 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	fsfake "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// testPlacementCandidates are three instances of a pool: a is the fullest with the most shares, b has the
+// least remaining capacity, and c the fewest shares.
+func testPlacementCandidates() []*PlacementCandidate {
+	return []*PlacementCandidate{
+		{Name: "c", CapacityBytes: 10 * util.Tb, UsedBytes: 1 * util.Tb, ShareCount: 1, Namespaces: map[string]int{"team-b": 1}},
+		{Name: "a", CapacityBytes: 10 * util.Tb, UsedBytes: 9 * util.Tb, ShareCount: 6, Namespaces: map[string]int{"team-b": 6}},
+		{Name: "b", CapacityBytes: 4 * util.Tb, UsedBytes: 2 * util.Tb, ShareCount: 3, Namespaces: map[string]int{"team-a": 2, "team-b": 1}},
+	}
+}
+
+func TestPlacementPolicies(t *testing.T) {
+	cases := []struct {
+		name     string
+		policy   string
+		share    *PlacementShare
+		expected string
+	}{
+		{
+			name:     "first fit",
+			policy:   placementPolicyFirstFit,
+			share:    &PlacementShare{CapacityBytes: 100 * util.Gb},
+			expected: "a",
+		},
+		{
+			name:     "best fit",
+			policy:   placementPolicyBestFit,
+			share:    &PlacementShare{CapacityBytes: 100 * util.Gb},
+			expected: "a",
+		},
+		{
+			name:     "best fit skips instances the share does not fit",
+			policy:   placementPolicyBestFit,
+			share:    &PlacementShare{CapacityBytes: 2 * util.Tb},
+			expected: "b",
+		},
+		{
+			name:     "best fit grows the largest instance",
+			policy:   placementPolicyBestFit,
+			share:    &PlacementShare{CapacityBytes: 10 * util.Tb},
+			expected: "c",
+		},
+		{
+			name:     "spread by namespace",
+			policy:   placementPolicySpreadByNamespace,
+			share:    &PlacementShare{CapacityBytes: 100 * util.Gb, Namespace: "team-a"},
+			expected: "c",
+		},
+		{
+			name:     "spread by namespace breaks ties by share count",
+			policy:   placementPolicySpreadByNamespace,
+			share:    &PlacementShare{CapacityBytes: 100 * util.Gb, Namespace: "team-b"},
+			expected: "c",
+		},
+		{
+			name:     "spread by namespace without namespace",
+			policy:   placementPolicySpreadByNamespace,
+			share:    &PlacementShare{CapacityBytes: 100 * util.Gb},
+			expected: "c",
+		},
+		{
+			name:     "least share count",
+			policy:   placementPolicyLeastShareCount,
+			share:    &PlacementShare{CapacityBytes: 100 * util.Gb},
+			expected: "c",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			policy, err := placementPolicyFromParams(map[string]string{paramPlacementPolicy: tc.policy})
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			placed := placeShare(policy, tc.share, testPlacementCandidates())
+			if placed == nil || placed.Name != tc.expected {
+				t.Errorf("got share placed on %+v, expected %s", placed, tc.expected)
+			}
+		})
+	}
+
+	if placed := placeShare(firstFitPolicy{}, &PlacementShare{}, nil); placed != nil {
+		t.Errorf("got share placed on %+v without candidates, expected a new instance", placed)
+	}
+}
+
+func TestPlacementPolicyFromParams(t *testing.T) {
+	cases := []struct {
+		params    map[string]string
+		expected  string
+		expectErr bool
+	}{
+		{params: nil, expected: placementPolicyFirstFit},
+		{params: map[string]string{paramPlacementPolicy: "Best-Fit"}, expected: placementPolicyBestFit},
+		{params: map[string]string{"Placement-Policy": placementPolicyLeastShareCount}, expected: placementPolicyLeastShareCount},
+		{params: map[string]string{paramPlacementPolicy: "worst-fit"}, expectErr: true},
+	}
+	for _, tc := range cases {
+		policy, err := placementPolicyFromParams(tc.params)
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("params %v: expected error, got policy %s", tc.params, policy.Name())
+			}
+			continue
+		}
+		if err != nil || policy.Name() != tc.expected {
+			t.Errorf("params %v: got policy %v, error %v, expected %s", tc.params, policy, err, tc.expected)
+		}
+	}
+}
+
+func testPlacementInstance(name string, capacityBytes int64) *file.MultishareInstance {
+	return &file.MultishareInstance{
+		Name:          name,
+		Project:       testProject,
+		Location:      testRegion,
+		CapacityBytes: capacityBytes,
+		State:         "READY",
+		Labels: map[string]string{
+			util.ParamMultishareInstanceScLabelKey: testInstanceScPrefix,
+			TagKeyClusterLocation:                  testLocation,
+			TagKeyClusterName:                      testClusterName,
+		},
+	}
+}
+
+func testPlacementShare(name string, parent *file.MultishareInstance, capacityBytes int64, namespace string) *file.Share {
+	return &file.Share{
+		Name:          name,
+		Parent:        parent,
+		CapacityBytes: capacityBytes,
+		Labels:        map[string]string{tagKeyCreatedForClaimNamespace: namespace},
+	}
+}
+
+// listSharesCountingFileService counts the ListShares calls.
+type listSharesCountingFileService struct {
+	file.Service
+	listShares int
+}
+
+func (s *listSharesCountingFileService) ListShares(ctx context.Context, filter *file.ListFilter) ([]*file.Share, error) {
+	s.listShares++
+	return s.Service.ListShares(ctx, filter)
+}
+
+func TestPlaceShareOnEligibleInstance(t *testing.T) {
+	// instance-1 has a share of team-a and room for 100GiB, instance-2 two shares of team-b and room for
+	// 1.5TiB.
+	instance1 := testPlacementInstance("instance-1", 1*util.Tb)
+	instance2 := testPlacementInstance("instance-2", 2*util.Tb)
+	shares := []*file.Share{
+		testPlacementShare("share-1", instance1, 924*util.Gb, "team-a"),
+		testPlacementShare("share-2", instance2, 256*util.Gb, "team-b"),
+		testPlacementShare("share-3", instance2, 256*util.Gb, "team-b"),
+	}
+
+	cases := []struct {
+		name          string
+		policy        string
+		namespace     string
+		capacityBytes int64
+		expected      string
+	}{
+		{name: "default", capacityBytes: 100 * util.Gb, expected: "instance-1"},
+		{name: "best fit", policy: placementPolicyBestFit, capacityBytes: 100 * util.Gb, expected: "instance-1"},
+		{name: "best fit larger share", policy: placementPolicyBestFit, capacityBytes: 200 * util.Gb, expected: "instance-2"},
+		{name: "spread by namespace", policy: placementPolicySpreadByNamespace, namespace: "team-a", capacityBytes: 100 * util.Gb, expected: "instance-2"},
+		{name: "spread by other namespace", policy: placementPolicySpreadByNamespace, namespace: "team-b", capacityBytes: 100 * util.Gb, expected: "instance-1"},
+		{name: "least share count", policy: placementPolicyLeastShareCount, capacityBytes: 100 * util.Gb, expected: "instance-1"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fakeService, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{instance2, instance1}, shares, nil)
+			if err != nil {
+				t.Fatalf("failed to fake service: %v", err)
+			}
+			s := &listSharesCountingFileService{Service: fakeService}
+			cloudProvider, _ := cloud.NewFakeCloud()
+			cloudProvider.File = s
+			mcs := NewMultishareController(&controllerServerConfig{
+				driver:      initTestDriver(t),
+				fileService: s,
+				cloud:       cloudProvider,
+			})
+			eligible, err := s.ListMultishareInstances(context.Background(), &file.ListFilter{Project: testProject, Location: testRegion})
+			if err != nil {
+				t.Fatalf("failed to list instances: %v", err)
+			}
+
+			params := map[string]string{ParamMultishareInstanceScLabel: testInstanceScPrefix}
+			if tc.policy != "" {
+				params[paramPlacementPolicy] = tc.policy
+			}
+			if tc.namespace != "" {
+				params[ParameterKeyPVCNamespace] = tc.namespace
+			}
+			placed, err := mcs.opsManager.placeShareOnEligibleInstance(context.Background(), &csi.CreateVolumeRequest{
+				Name:          "pvc-new",
+				Parameters:    params,
+				CapacityRange: &csi.CapacityRange{RequiredBytes: tc.capacityBytes},
+			}, "pvc_new", eligible)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if placed.Name != tc.expected {
+				t.Errorf("got share placed on %s, expected %s", placed.Name, tc.expected)
+			}
+			if s.listShares != 1 {
+				t.Errorf("shares listed %d times, expected once for the region", s.listShares)
+			}
+		})
+	}
+}
+
+func TestReconcilerSharePlacement(t *testing.T) {
+	instanceInfo := func(name string, capacityBytes int64, shares ...string) *v1.InstanceInfo {
+		uri, _ := file.GenerateMultishareInstanceURI(testPlacementInstance(name, capacityBytes))
+		return &v1.InstanceInfo{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      util.InstanceURIToInstanceInfoName(uri),
+				Namespace: util.ManagedFilestoreCSINamespace,
+				Labels:    map[string]string{ParamMultishareInstanceScLabel: testInstanceScPrefix},
+			},
+			Spec:   v1.InstanceInfoSpec{CapacityBytes: capacityBytes},
+			Status: &v1.InstanceInfoStatus{ShareNames: shares, InstanceStatus: v1.READY},
+		}
+	}
+	shareInfo := func(name string, capacityBytes int64, namespace, policy, instance string) *v1.ShareInfo {
+		s := &v1.ShareInfo{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: util.ManagedFilestoreCSINamespace},
+			Spec: v1.ShareInfoSpec{
+				ShareName:       name,
+				CapacityBytes:   capacityBytes,
+				Region:          testRegion,
+				InstancePoolTag: testInstanceScPrefix,
+				Parameters: map[string]string{
+					ParamMultishareInstanceScLabel: testInstanceScPrefix,
+					ParameterKeyPVCNamespace:       namespace,
+					paramPlacementPolicy:           policy,
+				},
+			},
+		}
+		if instance != "" {
+			s.Status = &v1.ShareInfoStatus{InstanceHandle: instance, ShareStatus: v1.READY}
+		}
+		return s
+	}
+
+	cases := []struct {
+		name      string
+		policy    string
+		namespace string
+		expected  string
+	}{
+		{name: "first fit", policy: placementPolicyFirstFit, namespace: "team-a", expected: "instance-1"},
+		{name: "best fit", policy: placementPolicyBestFit, namespace: "team-a", expected: "instance-2"},
+		{name: "spread by namespace", policy: placementPolicySpreadByNamespace, namespace: "team-a", expected: "instance-2"},
+		{name: "least share count", policy: placementPolicyLeastShareCount, namespace: "team-a", expected: "instance-2"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			// instance-1 has two shares of team-a and 1.5TiB left, instance-2 one share of team-b and 200GiB left.
+			ii1 := instanceInfo("instance-1", 2*util.Tb, "share-1", "share-2")
+			ii2 := instanceInfo("instance-2", 1*util.Tb, "share-3")
+			uri1, uri2 := util.InstanceInfoNameToInstanceURI(ii1.Name), util.InstanceInfoNameToInstanceURI(ii2.Name)
+			shareInfos := map[string]*v1.ShareInfo{
+				"share-1": shareInfo("share-1", 256*util.Gb, "team-a", tc.policy, uri1),
+				"share-2": shareInfo("share-2", 256*util.Gb, "team-a", tc.policy, uri1),
+				"share-3": shareInfo("share-3", 824*util.Gb, "team-b", tc.policy, uri2),
+				"share-4": shareInfo("share-4", 100*util.Gb, tc.namespace, tc.policy, ""),
+			}
+			instanceInfos := map[string]*v1.InstanceInfo{uri1: ii1, uri2: ii2}
+
+			config := &controllerServerConfig{driver: initTestDriver(t)}
+			config.multiShareController = NewMultishareController(config)
+			recon := &MultishareReconciler{
+				clientset:        fsfake.NewSimpleClientset(ii1, ii2, shareInfos["share-4"]),
+				controllerServer: &controllerServer{config: config},
+			}
+			recon.assignSharesToEligibleOrNewInstances(shareInfos, instanceInfos, map[string][]*file.Share{uri1: nil, uri2: nil})
+
+			expectedURI := uri1
+			if tc.expected == "instance-2" {
+				expectedURI = uri2
+			}
+			if status := shareInfos["share-4"].Status; status == nil || status.InstanceHandle != expectedURI {
+				t.Errorf("got share status %+v, expected share assigned to %q", status, expectedURI)
+			}
+			names := instanceInfos[expectedURI].Status.ShareNames
+			if names[len(names)-1] != "share-4" {
+				t.Errorf("got shares %v of instanceInfo %s, expected share-4 to be assigned", names, tc.expected)
+			}
+		})
+	}
+}
@@ -0,0 +1,293 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	allocatedIPRangePurpose  = "VPC_PEERING"
+	kmsKeyPurposeEncryption  = "ENCRYPT_DECRYPT"
+	kmsKeyVersionEnabled     = "ENABLED"
+	defaultPreflightCacheTTL = 5 * time.Minute
+)
+
+var (
+	// A network of another project, for Shared VPC, is given by its resource name.
+	networkResourceRegex = regexp.MustCompile(`^projects/([^/]+)/global/networks/([^/]+)$`)
+	kmsKeyNameRegex      = regexp.MustCompile(`^projects/([^/]+)/locations/([^/]+)/keyRings/([^/]+)/cryptoKeys/([^/]+)$`)
+)
+
+// preflightValidator checks that the network, the allocated IP range and the KMS key of a new instance
+// are usable before the instance is created, so that a misconfigured StorageClass fails CreateVolume
+// right away instead of failing the long running create operation. Lookups that the driver is not
+// permitted to make are skipped. The results of the lookups are cached for cacheTTL.
+type preflightValidator struct {
+	fileService file.Service
+	cacheTTL    time.Duration
+	now         func() time.Time
+
+	mux   sync.Mutex
+	cache map[string]*preflightCacheEntry
+}
+
+type preflightCacheEntry struct {
+	ipRange *file.AllocatedIPRange
+	err     error
+	expiry  time.Time
+}
+
+func newPreflightValidator(fileService file.Service, cacheTTL time.Duration) *preflightValidator {
+	if cacheTTL <= 0 {
+		cacheTTL = defaultPreflightCacheTTL
+	}
+	return &preflightValidator{
+		fileService: fileService,
+		cacheTTL:    cacheTTL,
+		now:         time.Now,
+		cache:       make(map[string]*preflightCacheEntry),
+	}
+}
+
+// validateInstance validates the network, reserved IP range and KMS key of a new instance. A nil
+// validator validates nothing.
+func (v *preflightValidator) validateInstance(ctx context.Context, filer *file.ServiceInstance) error {
+	if v == nil {
+		return nil
+	}
+	return v.validate(ctx, filer.Project, filer.Location, filer.Tier, filer.Network, filer.KmsKeyName)
+}
+
+// validateMultishareInstance validates the network, reserved IP range and KMS key of a new multishare
+// instance. A nil validator validates nothing.
+func (v *preflightValidator) validateMultishareInstance(ctx context.Context, instance *file.MultishareInstance) error {
+	if v == nil {
+		return nil
+	}
+	return v.validate(ctx, instance.Project, instance.Location, instance.Tier, instance.Network, instance.KmsKeyName)
+}
+
+func (v *preflightValidator) validate(ctx context.Context, project, location, tier string, network file.Network, kmsKeyName string) error {
+	networkProject, networkName := parseNetwork(project, network.Name)
+	if err := v.validateNetwork(ctx, networkProject, networkName); err != nil {
+		return err
+	}
+	if network.ConnectMode == privateServiceAccess && network.ReservedIpRange != "" {
+		if err := v.validateAllocatedIPRange(ctx, networkProject, networkName, network.ReservedIpRange, tier); err != nil {
+			return err
+		}
+	}
+	if kmsKeyName != "" {
+		if err := v.validateKmsKey(ctx, kmsKeyName, location); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (v *preflightValidator) validateNetwork(ctx context.Context, project, name string) error {
+	entry := v.cached("network/"+project+"/"+name, func() *preflightCacheEntry {
+		_, err := v.fileService.GetNetwork(ctx, project, name)
+		switch {
+		case err == nil:
+			return &preflightCacheEntry{}
+		case isNotFoundAPIError(err):
+			return &preflightCacheEntry{err: status.Errorf(codes.InvalidArgument, "network %q not found in project %q", name, project)}
+		}
+		return lookupErrorEntry(fmt.Sprintf("network %q of project %q", name, project), err)
+	})
+	return entry.err
+}
+
+// validateAllocatedIPRange checks that the named allocated range is an IP range of the network, and has
+// room for the IP block of an instance of the tier. Only the blocks of the Filestore instances in the
+// range are known to be used, the blocks of other services in the same range are not accounted for.
+func (v *preflightValidator) validateAllocatedIPRange(ctx context.Context, project, networkName, rangeName, tier string) error {
+	entry := v.cached("range/"+project+"/"+rangeName, func() *preflightCacheEntry {
+		ipRange, err := v.fileService.GetAllocatedIPRange(ctx, project, rangeName)
+		switch {
+		case err == nil:
+			return &preflightCacheEntry{ipRange: ipRange}
+		case isNotFoundAPIError(err):
+			return &preflightCacheEntry{err: status.Errorf(codes.InvalidArgument, "allocated IP range %q not found in project %q", rangeName, project)}
+		}
+		return lookupErrorEntry(fmt.Sprintf("allocated IP range %q of project %q", rangeName, project), err)
+	})
+	if entry.err != nil || entry.ipRange == nil {
+		return entry.err
+	}
+
+	ipRange := entry.ipRange
+	if ipRange.Purpose != allocatedIPRangePurpose {
+		return status.Errorf(codes.InvalidArgument, "IP range %q has purpose %q, a range allocated for private services access with purpose %q is required", rangeName, ipRange.Purpose, allocatedIPRangePurpose)
+	}
+	if !strings.EqualFold(ipRange.Network, networkName) {
+		return status.Errorf(codes.InvalidArgument, "allocated IP range %q belongs to network %q, not to network %q", rangeName, ipRange.Network, networkName)
+	}
+	blockPrefix := ipRangeSizeForTier(tier)
+	if ipRange.PrefixLength > int64(blockPrefix) {
+		return status.Errorf(codes.InvalidArgument, "allocated IP range %q of size /%d is smaller than the /%d block required by tier %q", rangeName, ipRange.PrefixLength, blockPrefix, tier)
+	}
+
+	used, err := v.allocatedIPRangeUsage(ctx, project, networkName, rangeName)
+	if err != nil {
+		return err
+	}
+	if ipBlockAddresses(int(ipRange.PrefixLength))-used < ipBlockAddresses(blockPrefix) {
+		return status.Errorf(codes.FailedPrecondition, "allocated IP range %q has no room left for the /%d block required by tier %q, %d of its %d addresses are used by Filestore instances", rangeName, blockPrefix, tier, used, ipBlockAddresses(int(ipRange.PrefixLength)))
+	}
+	return nil
+}
+
+// allocatedIPRangeUsage returns the number of addresses of the allocated range used by Filestore instances.
+func (v *preflightValidator) allocatedIPRangeUsage(ctx context.Context, project, networkName, rangeName string) (int64, error) {
+	instances, err := v.fileService.ListInstances(ctx, &file.ServiceInstance{Project: project, Location: "-"})
+	if err != nil {
+		return 0, file.StatusError(err)
+	}
+	multishareInstances, err := v.fileService.ListMultishareInstances(ctx, &file.ListFilter{Project: project, Location: "-"})
+	if err != nil {
+		return 0, file.StatusError(err)
+	}
+
+	var used int64
+	inRange := func(network file.Network) bool {
+		_, name := parseNetwork(project, network.Name)
+		return network.ReservedIpRange == rangeName && strings.EqualFold(name, networkName)
+	}
+	for _, instance := range instances {
+		if inRange(instance.Network) {
+			used += ipBlockAddresses(ipRangeSizeForTier(instance.Tier))
+		}
+	}
+	for _, instance := range multishareInstances {
+		if inRange(instance.Network) {
+			used += ipBlockAddresses(ipRangeSizeForTier(instance.Tier))
+		}
+	}
+	return used, nil
+}
+
+// validateKmsKey checks that the KMS key is in the region of the instance, and that its primary version
+// can encrypt new data. Whether the Filestore service agent can use the key is not checked.
+func (v *preflightValidator) validateKmsKey(ctx context.Context, keyName, location string) error {
+	match := kmsKeyNameRegex.FindStringSubmatch(keyName)
+	if match == nil {
+		return status.Errorf(codes.InvalidArgument, "invalid KMS key name %q, expected projects/{project}/locations/{location}/keyRings/{key_ring}/cryptoKeys/{key}", keyName)
+	}
+	region := location
+	if r, err := util.GetRegionFromZone(location); err == nil {
+		region = r
+	}
+	if keyRegion := match[2]; keyRegion != region {
+		return status.Errorf(codes.InvalidArgument, "KMS key %q is in location %q, it must be in the region of the instance %q", keyName, keyRegion, region)
+	}
+
+	entry := v.cached("kms/"+keyName, func() *preflightCacheEntry {
+		key, err := v.fileService.GetKmsKey(ctx, keyName)
+		switch {
+		case err == nil:
+		case isNotFoundAPIError(err):
+			return &preflightCacheEntry{err: status.Errorf(codes.InvalidArgument, "KMS key %q not found", keyName)}
+		default:
+			return lookupErrorEntry(fmt.Sprintf("KMS key %q", keyName), err)
+		}
+		if key.Purpose != kmsKeyPurposeEncryption {
+			return &preflightCacheEntry{err: status.Errorf(codes.InvalidArgument, "KMS key %q has purpose %q, a key with purpose %q is required", keyName, key.Purpose, kmsKeyPurposeEncryption)}
+		}
+		if key.PrimaryState != kmsKeyVersionEnabled {
+			return &preflightCacheEntry{err: status.Errorf(codes.FailedPrecondition, "primary version of KMS key %q is in state %q, it must be %q", keyName, key.PrimaryState, kmsKeyVersionEnabled)}
+		}
+		return &preflightCacheEntry{}
+	})
+	return entry.err
+}
+
+// cached returns the unexpired cache entry of the key, or looks it up. Entries of transient lookup
+// errors are not cached.
+func (v *preflightValidator) cached(key string, lookup func() *preflightCacheEntry) *preflightCacheEntry {
+	v.mux.Lock()
+	entry, ok := v.cache[key]
+	v.mux.Unlock()
+	if ok && v.now().Before(entry.expiry) {
+		return entry
+	}
+
+	entry = lookup()
+	if code := status.Code(entry.err); code == codes.OK || code == codes.InvalidArgument || code == codes.FailedPrecondition {
+		entry.expiry = v.now().Add(v.cacheTTL)
+		v.mux.Lock()
+		v.cache[key] = entry
+		v.mux.Unlock()
+	}
+	return entry
+}
+
+// lookupErrorEntry skips the validation of a resource the driver is not permitted to get, and returns
+// the other errors.
+func lookupErrorEntry(resource string, err error) *preflightCacheEntry {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) && apiErr.Code == http.StatusForbidden {
+		klog.Warningf("Skipping pre-flight validation of %s, permission denied: %v", resource, err.Error())
+		return &preflightCacheEntry{}
+	}
+	klog.Errorf("Pre-flight validation of %s failed: %v", resource, err.Error())
+	return &preflightCacheEntry{err: file.StatusError(err)}
+}
+
+func isNotFoundAPIError(err error) bool {
+	var apiErr *googleapi.Error
+	return file.IsNotFoundErr(err) || (errors.As(err, &apiErr) && apiErr.Code == http.StatusNotFound)
+}
+
+// parseNetwork returns the project and name of the network of an instance of the project.
+func parseNetwork(project, network string) (string, string) {
+	if match := networkResourceRegex.FindStringSubmatch(network); match != nil {
+		return match[1], match[2]
+	}
+	return project, network
+}
+
+// ipRangeSizeForTier returns the prefix length of the IP block of an instance of the tier.
+func ipRangeSizeForTier(tier string) int {
+	switch strings.ToLower(tier) {
+	case enterpriseTier:
+		return util.IpRangeSizeEnterprise
+	case highScaleTier, zonalTier:
+		return util.IpRangeSizeHighScale
+	}
+	return util.IpRangeSize
+}
+
+func ipBlockAddresses(prefixLength int) int64 {
+	return int64(1) << uint(32-prefixLength)
+}
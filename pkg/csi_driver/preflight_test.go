@@ -0,0 +1,290 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// countingFileService counts the network lookups, and fails them with lookupErr when set.
+type countingFileService struct {
+	file.Service
+	networkLookups int
+	lookupErr      error
+}
+
+func (s *countingFileService) GetNetwork(ctx context.Context, project, name string) (*file.VPCNetwork, error) {
+	s.networkLookups++
+	if s.lookupErr != nil {
+		return nil, s.lookupErr
+	}
+	return s.Service.GetNetwork(ctx, project, name)
+}
+
+func TestPreflightValidateInstance(t *testing.T) {
+	pscNetwork := func(ipRange string) file.Network {
+		return file.Network{Name: "default", ConnectMode: privateServiceAccess, ReservedIpRange: ipRange}
+	}
+	cases := []struct {
+		name      string
+		instance  *file.ServiceInstance
+		existing  []*file.ServiceInstance
+		expectErr codes.Code
+	}{
+		{
+			name:     "default network",
+			instance: &file.ServiceInstance{Location: testZone, Tier: defaultTier, Network: file.Network{Name: "default"}},
+		},
+		{
+			name:     "network of the project by resource name",
+			instance: &file.ServiceInstance{Location: testZone, Tier: defaultTier, Network: file.Network{Name: "projects/test-project/global/networks/default"}},
+		},
+		{
+			name:      "network not found",
+			instance:  &file.ServiceInstance{Location: testZone, Tier: defaultTier, Network: file.Network{Name: "other"}},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:     "allocated range",
+			instance: &file.ServiceInstance{Location: testZone, Tier: enterpriseTier, Network: pscNetwork(file.FakeAllocatedIPRange)},
+		},
+		{
+			name:      "allocated range not found",
+			instance:  &file.ServiceInstance{Location: testZone, Tier: defaultTier, Network: pscNetwork("other-range")},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:      "allocated range too small for the tier",
+			instance:  &file.ServiceInstance{Location: testZone, Tier: enterpriseTier, Network: pscNetwork(file.FakeSmallAllocatedIPRange)},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:     "allocated range with room",
+			instance: &file.ServiceInstance{Location: testZone, Tier: defaultTier, Network: pscNetwork(file.FakeAllocatedIPRange)},
+			existing: []*file.ServiceInstance{
+				{Name: "instance-1", Tier: "ENTERPRISE", Network: pscNetwork(file.FakeAllocatedIPRange)},
+				{Name: "instance-2", Tier: "ENTERPRISE", Network: pscNetwork(file.FakeAllocatedIPRange)},
+				{Name: "instance-3", Tier: "ENTERPRISE", Network: pscNetwork(file.FakeAllocatedIPRange)},
+			},
+		},
+		{
+			name:     "allocated range full",
+			instance: &file.ServiceInstance{Location: testZone, Tier: defaultTier, Network: pscNetwork(file.FakeSmallAllocatedIPRange)},
+			existing: []*file.ServiceInstance{
+				{Name: "instance-1", Tier: "BASIC_HDD", Network: pscNetwork(file.FakeSmallAllocatedIPRange)},
+			},
+			expectErr: codes.FailedPrecondition,
+		},
+		{
+			name:     "reserved range of direct peering is not checked",
+			instance: &file.ServiceInstance{Location: testZone, Tier: defaultTier, Network: file.Network{Name: "default", ReservedIpRange: "10.0.0.0/29"}},
+		},
+		{
+			name:     "enabled KMS key",
+			instance: &file.ServiceInstance{Location: testZone, Tier: enterpriseTier, Network: file.Network{Name: "default"}, KmsKeyName: file.FakeKmsKey},
+		},
+		{
+			name:      "disabled KMS key",
+			instance:  &file.ServiceInstance{Location: testRegion, Tier: enterpriseTier, Network: file.Network{Name: "default"}, KmsKeyName: file.FakeDisabledKmsKey},
+			expectErr: codes.FailedPrecondition,
+		},
+		{
+			name:      "KMS key not found",
+			instance:  &file.ServiceInstance{Location: testZone, Tier: enterpriseTier, Network: file.Network{Name: "default"}, KmsKeyName: "projects/test-project/locations/us-central1/keyRings/test-ring/cryptoKeys/other"},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:      "KMS key in another region",
+			instance:  &file.ServiceInstance{Location: "us-east1-b", Tier: enterpriseTier, Network: file.Network{Name: "default"}, KmsKeyName: file.FakeKmsKey},
+			expectErr: codes.InvalidArgument,
+		},
+		{
+			name:      "malformed KMS key",
+			instance:  &file.ServiceInstance{Location: testZone, Tier: enterpriseTier, Network: file.Network{Name: "default"}, KmsKeyName: "test-key"},
+			expectErr: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fileService, err := file.NewFakeService()
+			if err != nil {
+				t.Fatalf("failed to initialize GCFS service: %v", err)
+			}
+			for _, instance := range tc.existing {
+				if _, err := fileService.CreateInstance(context.TODO(), instance); err != nil {
+					t.Fatalf("failed to create instance %v: %v", instance.Name, err)
+				}
+			}
+			tc.instance.Project = testProject
+			tc.instance.Name = "new-instance"
+
+			err = newPreflightValidator(fileService, time.Minute).validateInstance(context.TODO(), tc.instance)
+			if status.Code(err) != tc.expectErr {
+				t.Errorf("expected error code %v, got %v", tc.expectErr, err)
+			}
+		})
+	}
+}
+
+func TestPreflightValidateMultishareInstance(t *testing.T) {
+	fileService, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	instance := &file.MultishareInstance{
+		Project:  testProject,
+		Location: testRegion,
+		Name:     "new-instance",
+		Tier:     enterpriseTier,
+		Network: file.Network{
+			Name:            "default",
+			ConnectMode:     privateServiceAccess,
+			ReservedIpRange: file.FakeSmallAllocatedIPRange,
+		},
+	}
+	err = newPreflightValidator(fileService, time.Minute).validateMultishareInstance(context.TODO(), instance)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("expected error code %v, got %v", codes.InvalidArgument, err)
+	}
+
+	var validator *preflightValidator
+	if err := validator.validateMultishareInstance(context.TODO(), instance); err != nil {
+		t.Errorf("unexpected error of a disabled validation: %v", err)
+	}
+}
+
+func TestPreflightCache(t *testing.T) {
+	fakeService, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	cases := []struct {
+		name            string
+		network         string
+		lookupErr       error
+		expectErr       codes.Code
+		expectedLookups int
+	}{
+		{
+			name:            "found network is cached",
+			network:         "default",
+			expectedLookups: 1,
+		},
+		{
+			name:            "network not found is cached",
+			network:         "other",
+			expectErr:       codes.InvalidArgument,
+			expectedLookups: 1,
+		},
+		{
+			name:            "transient error is not cached",
+			network:         "default",
+			lookupErr:       &googleapi.Error{Code: http.StatusServiceUnavailable},
+			expectErr:       codes.Internal,
+			expectedLookups: 2,
+		},
+		{
+			name:            "permission denied skips the check",
+			network:         "default",
+			lookupErr:       &googleapi.Error{Code: http.StatusForbidden},
+			expectedLookups: 1,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fileService := &countingFileService{Service: fakeService, lookupErr: tc.lookupErr}
+			validator := newPreflightValidator(fileService, time.Minute)
+			now := time.Now()
+			validator.now = func() time.Time { return now }
+			instance := &file.ServiceInstance{Project: testProject, Location: testZone, Tier: defaultTier, Network: file.Network{Name: tc.network}}
+
+			for i := 0; i < 2; i++ {
+				if err := validator.validateInstance(context.TODO(), instance); status.Code(err) != tc.expectErr {
+					t.Fatalf("expected error code %v, got %v", tc.expectErr, err)
+				}
+			}
+			if fileService.networkLookups != tc.expectedLookups {
+				t.Errorf("got %d network lookups, expected %d", fileService.networkLookups, tc.expectedLookups)
+			}
+
+			now = now.Add(2 * time.Minute)
+			if err := validator.validateInstance(context.TODO(), instance); status.Code(err) != tc.expectErr {
+				t.Fatalf("expected error code %v, got %v", tc.expectErr, err)
+			}
+			if fileService.networkLookups != tc.expectedLookups+1 {
+				t.Errorf("expired lookup was not repeated, got %d network lookups", fileService.networkLookups)
+			}
+		})
+	}
+}
+
+func TestCreateVolumePreflightValidation(t *testing.T) {
+	fileService, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	cloudProvider, err := cloud.NewFakeCloud()
+	if err != nil {
+		t.Fatalf("failed to get cloud provider: %v", err)
+	}
+	cs := newControllerServer(&controllerServerConfig{
+		driver:      initTestDriver(t),
+		fileService: fileService,
+		cloud:       cloudProvider,
+		volumeLocks: util.NewVolumeLocks(),
+		features: &GCFSDriverFeatureOptions{
+			FeatureLockRelease:         &FeatureLockRelease{},
+			FeaturePreflightValidation: &FeaturePreflightValidation{Enabled: true},
+		},
+		tagManager: cloud.NewFakeTagManager(),
+	})
+
+	req := &csi.CreateVolumeRequest{
+		Name: testCSIVolume,
+		VolumeCapabilities: []*csi.VolumeCapability{
+			{
+				AccessType: &csi.VolumeCapability_Mount{Mount: &csi.VolumeCapability_MountVolume{}},
+				AccessMode: &csi.VolumeCapability_AccessMode{Mode: csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER},
+			},
+		},
+		Parameters: map[string]string{
+			paramTier:                     enterpriseTier,
+			ParamConnectMode:              privateServiceAccess,
+			ParamReservedIPRange:          file.FakeAllocatedIPRange,
+			ParamInstanceEncryptionKmsKey: file.FakeDisabledKmsKey,
+		},
+	}
+	if _, err := cs.CreateVolume(context.TODO(), req); status.Code(err) != codes.FailedPrecondition {
+		t.Fatalf("expected error code %v, got %v", codes.FailedPrecondition, err)
+	}
+	if _, err := fileService.GetInstance(context.TODO(), &file.ServiceInstance{Project: testProject, Location: testZone, Name: testCSIVolume}); err == nil {
+		t.Errorf("instance was created despite the failed validation")
+	}
+}
@@ -26,14 +26,19 @@ import (
 	filev1beta1 "google.golang.org/api/file/v1beta1"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	corev1 "k8s.io/api/core/v1"
 	storagev1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	coreListers "k8s.io/client-go/listers/core/v1"
 	storageListers "k8s.io/client-go/listers/storage/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
 	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
@@ -64,6 +69,30 @@ type MultishareReconciler struct {
 	instanceListerSynced cache.InformerSynced
 
 	scLister storageListers.StorageClassLister
+
+	// migrationLister is nil unless the share migration feature is enabled.
+	migrationLister       listers.ShareMigrationLister
+	migrationListerSynced cache.InformerSynced
+	migrationBackups      *migrationBackupOps
+
+	// defragInterval is the interval of the consolidation plans of the pools, 0 if the defragmentation
+	// planner is disabled.
+	defragInterval time.Duration
+	lastDefrag     time.Time
+	// defragExecute is true if the consolidation plans of the pools with the execute mode are executed.
+	defragExecute bool
+
+	// recorder records the events of the shareInfos and instanceInfos, it may be nil.
+	recorder record.EventRecorder
+	// pvLister and pvcLister get the PVs and PVCs the events of the shareInfos are recorded on, they may
+	// be nil.
+	pvLister  coreListers.PersistentVolumeLister
+	pvcLister coreListers.PersistentVolumeClaimLister
+	// kubeClient lists the events of the nodes still mounting the source shares of the migrations, it may
+	// be nil.
+	kubeClient kubernetes.Interface
+
+	now func() time.Time
 }
 
 func NewMultishareReconciler(
@@ -72,12 +101,15 @@ func NewMultishareReconciler(
 	shareInformer informers.ShareInfoInformer,
 	instanceInformar informers.InstanceInfoInformer,
 	scLister storageListers.StorageClassLister,
+	migrationInformer informers.ShareMigrationInformer,
 ) *MultishareReconciler {
 	recon := &MultishareReconciler{
-		clientset: clientset,
-		cloud:     config.Cloud,
-		config:    config,
-		scLister:  scLister,
+		clientset:        clientset,
+		cloud:            config.Cloud,
+		config:           config,
+		scLister:         scLister,
+		migrationBackups: newMigrationBackupOps(),
+		now:              time.Now,
 	}
 
 	recon.shareLister = shareInformer.Lister()
@@ -86,6 +118,19 @@ func NewMultishareReconciler(
 	recon.instanceLister = instanceInformar.Lister()
 	recon.instanceListerSynced = instanceInformar.Informer().HasSynced
 
+	if migrationInformer != nil {
+		recon.migrationLister = migrationInformer.Lister()
+		recon.migrationListerSynced = migrationInformer.Informer().HasSynced
+	}
+
+	if config.FeatureOptions != nil && config.FeatureOptions.FeatureMultishareDefrag != nil && config.FeatureOptions.FeatureMultishareDefrag.Enabled {
+		recon.defragInterval = config.FeatureOptions.FeatureMultishareDefrag.Interval
+		if recon.defragInterval <= 0 {
+			recon.defragInterval = defaultDefragInterval
+		}
+		recon.defragExecute = config.FeatureOptions.FeatureMultishareDefrag.ExecutePlans
+	}
+
 	return recon
 }
 
@@ -94,6 +139,9 @@ func (recon *MultishareReconciler) Run(stopCh <-chan struct{}) {
 
 	klog.Infof("Starting cache sync")
 	informerSynced := []cache.InformerSynced{recon.shareListerSynced, recon.instanceListerSynced}
+	if recon.migrationListerSynced != nil {
+		informerSynced = append(informerSynced, recon.migrationListerSynced)
+	}
 	if !cache.WaitForCacheSync(stopCh, informerSynced...) {
 		klog.Errorf("Cannot sync caches")
 		return
@@ -129,12 +177,20 @@ func (recon *MultishareReconciler) reconcileWorker() {
 	instanceListStamp := time.Now()
 	klog.V(6).Infof("ListInstance finished in %v", time.Since(shareListStamp))
 
-	instances, shares, instanceShares, err := recon.managedInstanceAndShare(instances, shares)
+	instances, shares, allInstanceShares, err := recon.managedInstanceAndShare(instances, shares)
 	if err != nil {
 		klog.Errorf("Failed to filter out managed instance and shares: %s", err.Error())
 		return
 	}
 
+	migrations, err := recon.listShareMigrations()
+	if err != nil {
+		klog.Errorf("Filestore CSI driver cannot list ShareMigration objects: %v", err)
+		return
+	}
+	// Only the copies of the migrating shares they are served from are reconciled, the migrations handle the other copies.
+	shares, instanceShares := servingShareCopies(migrations, shares, allInstanceShares)
+
 	// Create shareInfo objects if does not exist, update shareInfo.Status based on listed out shares' status.
 	shareInfoMap := recon.createAndUpdateShareInfos(shares)
 
@@ -179,7 +235,7 @@ func (recon *MultishareReconciler) reconcileWorker() {
 
 	// Assign un-assigned shares to instances; update shareInfo and instanceInfo accordingly,
 	// if there's inconsistency between share and instnace then share has source of truth.
-	recon.assignSharesToInstances(shareInfoMap, instanceInfoMap, instanceShares)
+	recon.assignSharesToInstances(shareInfoMap, instanceInfoMap, instanceShares, migrations)
 
 	assignmentStamp := time.Now()
 	klog.V(6).Infof("assignment finished in %v", time.Since(reconstructionStamp))
@@ -193,10 +249,20 @@ func (recon *MultishareReconciler) reconcileWorker() {
 	opStamp := time.Now()
 	klog.V(6).Infof("List Op finished in %v", time.Since(assignmentStamp))
 
+	recon.runShareMigrations(migrations, shareInfoMap, instanceInfoMap, instances, allInstanceShares, ops)
+
+	migrationStamp := time.Now()
+	klog.V(6).Infof("share migrations finished in %v", time.Since(opStamp))
+
+	recon.defragmentPools(shareInfoMap, instanceInfoMap, migrations)
+
+	defragStamp := time.Now()
+	klog.V(6).Infof("defragmentation finished in %v", time.Since(migrationStamp))
+
 	recon.sendInstanceRequests(instanceInfoMap, ops)
 
 	instanceReqStamp := time.Now()
-	klog.V(6).Infof("instanceRequest finished in %v", time.Since(opStamp))
+	klog.V(6).Infof("instanceRequest finished in %v", time.Since(defragStamp))
 
 	recon.sendShareRequests(instanceInfoMap, shareInfoMap, instanceShares, ops)
 
@@ -276,21 +342,31 @@ func (recon *MultishareReconciler) sendShareRequests(instanceInfos map[string]*v
 		}
 		op, err := runningOpMaybeErrForTarget(shareURI, ops)
 		if err != nil {
-			recon.updateShareInfoErr(shareInfo, err)
+			shareInfo = recon.updateShareInfoErr(shareInfo, err)
 		}
 
 		if op == nil {
 			klog.Infof("no running Op found for %s", shareURI)
+			var startedOp *filev1beta1.Operation
+			var verb string
 			if needDelete {
 				klog.Infof("Starting share Delete operation for %s", shareURI)
-				_, err = recon.cloud.File.StartDeleteShareOp(context.TODO(), share)
+				startedOp, err = recon.cloud.File.StartDeleteShareOp(context.TODO(), share)
+				verb = util.OpVerbDelete
 			} else if shareInfo.Status.ShareStatus != v1.READY {
 				klog.Infof("Starting share Create operation for %s", shareURI)
-				_, err = recon.cloud.File.StartCreateShareOp(context.TODO(), share)
+				startedOp, err = recon.cloud.File.StartCreateShareOp(context.TODO(), share)
+				verb = util.OpVerbCreate
 			} else if shareInfo.Status.CapacityBytes != 0 && shareInfo.Spec.CapacityBytes != shareInfo.Status.CapacityBytes {
 				klog.Infof("Starting share Resize operation for %s", shareURI)
-				_, err = recon.cloud.File.StartResizeShareOp(context.TODO(), share)
+				startedOp, err = recon.cloud.File.StartResizeShareOp(context.TODO(), share)
+				verb = util.OpVerbUpdate
 			}
+			if err == nil && startedOp != nil {
+				shareInfo = recon.updateShareInfoLastOperation(shareInfo, startedOp.Name, verb)
+			}
+		} else {
+			shareInfo = recon.updateShareInfoLastOperation(shareInfo, op.Id, operationVerb(op.Type))
 		}
 		if err != nil {
 			recon.updateShareInfoErr(shareInfo, err)
@@ -320,6 +396,7 @@ func (recon *MultishareReconciler) maybeMarkShareInfoStatusDeleted(shareInfo *v1
 		shareInfoClone.Status = &v1.ShareInfoStatus{}
 	}
 	shareInfoClone.Status.ShareStatus = v1.DELETED
+	recon.refreshShareInfoStatus(shareInfoClone, shareInfoClone.Status)
 	_, err := recon.updateShareInfoStatus(context.TODO(), shareInfoClone)
 	if err != nil {
 		return instanceInfoClone, fmt.Errorf("failed to update %s.Status.ShareStatus to DELETED: %s", shareInfo.Name, err.Error())
@@ -344,7 +421,7 @@ func (recon *MultishareReconciler) sendInstanceRequests(instanceInfos map[string
 		instanceURI := util.InstanceInfoNameToInstanceURI(instanceInfo.Name)
 		op, err := runningOpMaybeErrForTarget(instanceURI, ops)
 		if err != nil {
-			recon.updateInstanceInfoErr(instanceInfo, err)
+			instanceInfo = recon.updateInstanceInfoErr(instanceInfo, err)
 		}
 		if op == nil {
 			klog.Infof("no running Op found for %s", instanceURI)
@@ -355,9 +432,12 @@ func (recon *MultishareReconciler) sendInstanceRequests(instanceInfos map[string
 				continue
 			}
 
+			var startedOp *filev1beta1.Operation
+			var verb string
 			if needDelete {
 				klog.Infof("Starting instance Delete operation for %s", instanceURI)
-				_, err = recon.cloud.File.StartDeleteMultishareInstanceOp(context.TODO(), instance)
+				startedOp, err = recon.cloud.File.StartDeleteMultishareInstanceOp(context.TODO(), instance)
+				verb = util.OpVerbDelete
 
 			} else if instanceInfo.Status == nil || (instanceInfo.Status.InstanceStatus != v1.READY && instanceInfo.Status.InstanceStatus != v1.UPDATING) {
 				instance, err = recon.generateNewMultishareInstance(instanceInfo)
@@ -365,15 +445,24 @@ func (recon *MultishareReconciler) sendInstanceRequests(instanceInfos map[string
 					klog.Errorf("error while generating new instance for %s to call API: %s", instanceInfo.Name, err.Error())
 					continue
 				}
-				klog.Infof("Starting instance Create operation for %s", instanceURI)
-				_, err = recon.cloud.File.StartCreateMultishareInstanceOp(context.TODO(), instance)
+				if err = recon.controllerServer.config.preflight.validateMultishareInstance(context.TODO(), instance); err == nil {
+					klog.Infof("Starting instance Create operation for %s", instanceURI)
+					startedOp, err = recon.cloud.File.StartCreateMultishareInstanceOp(context.TODO(), instance)
+					verb = util.OpVerbCreate
+				}
 
 				defer recon.controllerServer.config.ipAllocator.ReleaseIPRange(instance.Network.ReservedIpRange)
 
 			} else if instanceInfo.Status != nil && instanceInfo.Status.CapacityBytes != 0 && instanceInfo.Spec.CapacityBytes != instanceInfo.Status.CapacityBytes {
 				klog.Infof("Starting instance Resize operation for %s", instanceURI)
-				_, err = recon.cloud.File.StartResizeMultishareInstanceOp(context.TODO(), instance)
+				startedOp, err = recon.cloud.File.StartResizeMultishareInstanceOp(context.TODO(), instance)
+				verb = util.OpVerbUpdate
 			}
+			if err == nil && startedOp != nil {
+				instanceInfo = recon.updateInstanceInfoLastOperation(instanceInfo, startedOp.Name, verb)
+			}
+		} else {
+			instanceInfo = recon.updateInstanceInfoLastOperation(instanceInfo, op.Id, operationVerb(op.Type))
 		}
 
 		if err != nil {
@@ -382,7 +471,9 @@ func (recon *MultishareReconciler) sendInstanceRequests(instanceInfos map[string
 	}
 }
 
-func (recon *MultishareReconciler) updateInstanceInfoErr(instanceInfo *v1.InstanceInfo, err error) {
+// updateInstanceInfoErr sets the error of the status of instanceInfo, and records an event if the error
+// is new. It returns the updated instanceInfo, or instanceInfo if it is unchanged or the update fails.
+func (recon *MultishareReconciler) updateInstanceInfoErr(instanceInfo *v1.InstanceInfo, err error) *v1.InstanceInfo {
 	klog.Infof("found error message for instance %s", instanceInfo.Name)
 	instanceInfoClone := instanceInfo.DeepCopy()
 	if instanceInfoClone.Status == nil {
@@ -392,15 +483,23 @@ func (recon *MultishareReconciler) updateInstanceInfoErr(instanceInfo *v1.Instan
 	if !strings.EqualFold(instanceInfoClone.Status.Error, err.Error()) {
 		klog.V(6).Infof("previous Error message: %s", instanceInfoClone.Status.Error)
 		instanceInfoClone.Status.Error = err.Error()
+		recon.refreshInstanceInfoStatus(instanceInfoClone, instanceInfoClone.Status)
 		klog.V(6).Infof("new error message found: %s, trying to update instanceInfo %s", err.Error(), instanceInfoClone.Name)
-		_, err := recon.updateInstanceInfoStatus(context.TODO(), instanceInfoClone)
+		updated, err := recon.updateInstanceInfoStatus(context.TODO(), instanceInfoClone)
 		if err != nil {
 			klog.Errorf("failed to update instanceInfo %s: %s", instanceInfoClone.Name, err.Error())
+			return instanceInfo
 		}
+		recon.event(updated, corev1.EventTypeWarning, eventReasonInstanceOperationFailed, "Filestore instance %s: %s", util.InstanceInfoNameToInstanceURI(updated.Name), updated.Status.Error)
+		return updated
 	}
+	return instanceInfo
 }
 
-func (recon *MultishareReconciler) updateShareInfoErr(shareInfo *v1.ShareInfo, err error) {
+// updateShareInfoErr sets the error of the status of shareInfo, and records an event on the shareInfo and
+// the PV and PVC of its volume if the error is new. It returns the updated shareInfo, or shareInfo if it
+// is unchanged or the update fails.
+func (recon *MultishareReconciler) updateShareInfoErr(shareInfo *v1.ShareInfo, err error) *v1.ShareInfo {
 	shareInfoClone := shareInfo.DeepCopy()
 	if shareInfoClone.Status == nil {
 		shareInfoClone.Status = &v1.ShareInfoStatus{}
@@ -409,12 +508,17 @@ func (recon *MultishareReconciler) updateShareInfoErr(shareInfo *v1.ShareInfo, e
 	if !strings.EqualFold(shareInfoClone.Status.Error, err.Error()) {
 		klog.V(6).Infof("previous Error message: %s", shareInfoClone.Status.Error)
 		shareInfoClone.Status.Error = err.Error()
+		recon.refreshShareInfoStatus(shareInfoClone, shareInfoClone.Status)
 		klog.V(6).Infof("new error message found: %s, trying to update shareInfo %s", err.Error(), shareInfoClone.Name)
-		_, err := recon.updateShareInfoStatus(context.TODO(), shareInfoClone)
+		updated, err := recon.updateShareInfoStatus(context.TODO(), shareInfoClone)
 		if err != nil {
 			klog.Errorf("failed to update shareInfo %s: %s", shareInfoClone.Name, err.Error())
+			return shareInfo
 		}
+		recon.shareOperationFailedEvent(updated, updated.Status.Error)
+		return updated
 	}
+	return shareInfo
 }
 
 // basicMultishareInstanceFromInstanceInfo generates a MultishareInstance object with basic info for deletion and expansion purpose
@@ -469,6 +573,7 @@ func (recon *MultishareReconciler) generateNewMultishareInstance(instanceInfo *v
 			kmsKeyName = v
 		case ParamReservedIPV4CIDR, ParamReservedIPRange:
 		case cloud.ParameterKeyResourceTags:
+		case paramPlacementPolicy, paramMinSpareInstances, paramSpareInstanceIdlePeriod:
 		case ParamMultishareInstanceScLabel, ParameterKeyLabels, ParameterKeyPVCName, ParameterKeyPVCNamespace, ParameterKeyPVName, paramMultishare:
 		case "csiprovisionersecretname", "csiprovisionersecretnamespace":
 		default:
@@ -544,14 +649,21 @@ func (recon *MultishareReconciler) generateNewMultishareInstance(instanceInfo *v
 	return instance, nil
 }
 
-func (recon *MultishareReconciler) assignSharesToInstances(shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, instanceShares map[string][]*file.Share) {
-	recon.fixTwoWayPointers(shareInfos, instanceInfos)
+func (recon *MultishareReconciler) assignSharesToInstances(shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, instanceShares map[string][]*file.Share, migrations []*v1.ShareMigration) {
+	recon.fixTwoWayPointers(shareInfos, instanceInfos, migrationTargets(migrations))
 
 	recon.assignSharesToEligibleOrNewInstances(shareInfos, instanceInfos, instanceShares)
 
+	// Spare instances are counted after the assignment, an empty spare instance a share was just assigned to is replaced.
+	kept := recon.maintainWarmPools(instanceInfos)
+	// The source instances of the migrated shares keep them until the end of the cutover.
+	for instanceURI := range migrationSources(migrations) {
+		kept[instanceURI] = true
+	}
+
 	// Have to call deleteOrResizeInstances() after assigning shares and/or fixing two way pointers because no resizing were attempted in
 	// assignSharesToEligibleOrNewInstances() or fixTwoWayPointers()
-	recon.deleteOrResizeInstances(instanceInfos)
+	recon.deleteOrResizeInstances(instanceInfos, kept)
 }
 
 // fixTwoWayPointers scans over all instanceInfo objects and try to fix the 2 way pointer between instanceInfo and shareInfo objects.
@@ -560,7 +672,8 @@ func (recon *MultishareReconciler) assignSharesToInstances(shareInfos map[string
 // have been created and we couldn't move shares around.
 // instanceInfo.Status.ShareNames -> shareInfo
 // shareInfo.Status.InstanceHandle -> instanceInfo
-func (recon *MultishareReconciler) fixTwoWayPointers(shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo) {
+// The shares assigned to the target instances of share migrations before the cutover, in migrationTargets, are left as is.
+func (recon *MultishareReconciler) fixTwoWayPointers(shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, migrationTargets map[string]string) {
 	for _, instanceInfo := range instanceInfos {
 		if instanceInfo.Status == nil {
 			klog.V(6).Infof("Instance %q has Status nil", instanceInfo.Name)
@@ -577,6 +690,10 @@ func (recon *MultishareReconciler) fixTwoWayPointers(shareInfos map[string]*v1.S
 				klog.Errorf("Share %q is assigned to instance %q but shareInfo does not exist", shareName, instanceURI)
 				continue
 			}
+			if migrationTargets[shareName] == instanceURI {
+				klog.V(4).Infof("Share %q is assigned to instance %q by a share migration", shareName, instanceURI)
+				continue
+			}
 			if shareInfo.Status == nil || shareInfo.Status.InstanceHandle == "" {
 				shareInfo, err := recon.assignInstanceToShareInfo(shareInfo, instanceURI)
 				if err != nil {
@@ -627,8 +744,8 @@ func (recon *MultishareReconciler) fixTwoWayPointers(shareInfos map[string]*v1.S
 	}
 }
 
-// assignSharesToEligibleOrNewInstances assigns shares that are not already assigned to eligible instances.
-// If there're no eligible instances, generate a new one.
+// assignSharesToEligibleOrNewInstances assigns shares that are not already assigned to the eligible instance
+// picked by the placement policy of their StorageClass. If there're no eligible instances, generate a new one.
 func (recon *MultishareReconciler) assignSharesToEligibleOrNewInstances(shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, instanceShares map[string][]*file.Share) {
 	for _, shareInfo := range shareInfos {
 		if shareInfo.Status == nil || shareInfo.Status.InstanceHandle == "" {
@@ -645,8 +762,12 @@ func (recon *MultishareReconciler) assignSharesToEligibleOrNewInstances(shareInf
 				continue
 			}
 
-			var instanceURI string
-			var err error
+			policy, err := placementPolicyFromParams(shareInfo.Spec.Parameters)
+			if err != nil {
+				klog.Errorf("ShareInfo %q has an invalid placement policy: %v", shareInfo.Name, err)
+				continue
+			}
+			var candidates []*PlacementCandidate
 			for _, instanceInfo := range instanceInfos {
 				_, ok := instanceShares[util.InstanceInfoNameToInstanceURI(instanceInfo.Name)]
 				if !ok && instanceInfo.Status != nil && instanceInfo.Status.InstanceStatus != "" {
@@ -654,19 +775,24 @@ func (recon *MultishareReconciler) assignSharesToEligibleOrNewInstances(shareInf
 					klog.Warningf("instanceInfo %s has non empty InstanceStatus but underlying instance does not exist. Skip assignment to that instance", instanceInfo.Name)
 				}
 				if recon.instanceFitShare(instanceInfo, shareInfo) {
-					instanceURI = util.InstanceInfoNameToInstanceURI(instanceInfo.Name)
-					instanceInfo, err = recon.assignShareToInstanceInfo(instanceInfo, shareInfo.Name)
-					if err != nil {
-						klog.Errorf("Failed to add share %q to instanceInfo %q: %v", shareInfo.Name, instanceInfo.Name, err)
-						continue
-					}
-					klog.Infof("Share %q is now assigned to instance %q", shareInfo.Name, instanceURI)
-					instanceInfos[instanceURI] = instanceInfo
-					break
+					candidates = append(candidates, instanceInfoPlacementCandidate(instanceInfo, shareInfos))
 				}
 			}
-			if err != nil {
-				continue
+
+			var instanceURI string
+			if placed := placeShare(policy, &PlacementShare{
+				Name:          shareInfo.Name,
+				CapacityBytes: shareInfo.Spec.CapacityBytes,
+				Namespace:     shareInfo.Spec.Parameters[ParameterKeyPVCNamespace],
+			}, candidates); placed != nil {
+				instanceURI = placed.Name
+				instanceInfo, err := recon.assignShareToInstanceInfo(instanceInfos[instanceURI], shareInfo.Name)
+				if err != nil {
+					klog.Errorf("Failed to add share %q to instanceInfo %q: %v", shareInfo.Name, instanceInfos[instanceURI].Name, err)
+					continue
+				}
+				klog.Infof("Share %q is now assigned to instance %q by placement policy %s", shareInfo.Name, instanceURI, policy.Name())
+				instanceInfos[instanceURI] = instanceInfo
 			}
 
 			if instanceURI == "" {
@@ -703,11 +829,11 @@ func (recon *MultishareReconciler) assignSharesToEligibleOrNewInstances(shareInf
 }
 
 // deleteOrResizeInstances takes a map of instanceUri -> instanceInfos and
-// 1) add DeletionTimestamp for any instanceInfo that's empty (doesn't have share assigned to it).
+// 1) add DeletionTimestamp for any instanceInfo that's empty (doesn't have share assigned to it) and not kept by a warm pool.
 // 2) calculates and updates the minimum viable Spec.CapacityBytes for instanceInfos that are not empty.
-func (recon *MultishareReconciler) deleteOrResizeInstances(instanceInfos map[string]*v1.InstanceInfo) {
+func (recon *MultishareReconciler) deleteOrResizeInstances(instanceInfos map[string]*v1.InstanceInfo, kept map[string]bool) {
 	for instanceURI, instanceInfo := range instanceInfos {
-		if instanceInfo.DeletionTimestamp != nil {
+		if instanceInfo.DeletionTimestamp != nil || kept[instanceURI] {
 			continue
 		}
 
@@ -984,11 +1110,7 @@ func (recon *MultishareReconciler) instanceInfoNewCapacity(instanceInfoClone *v1
 		}
 		targetInstanceSizeByte += shareInfo.Spec.CapacityBytes
 	}
-	targetInstanceSizeByte = util.AlignBytes(targetInstanceSizeByte, util.GbToBytes(stepSizeGb))
-
-	// bound InstanceSizeByte to max and min of Multishare instance size
-	targetInstanceSizeByte = util.Max(targetInstanceSizeByte, util.MinMultishareInstanceSizeBytes)
-	targetInstanceSizeByte = util.Min(targetInstanceSizeByte, util.MaxMultishareInstanceSizeBytes)
+	targetInstanceSizeByte = multishareInstanceCapacity(targetInstanceSizeByte, stepSizeGb)
 
 	if targetInstanceSizeByte == instanceInfoClone.Spec.CapacityBytes {
 		return instanceInfoClone, false
@@ -1056,27 +1178,33 @@ func (recon *MultishareReconciler) maybeUpdateInstanceInfoStatus(instance *file.
 		}
 	}
 
-	if instanceInfo.Status != nil &&
-		instance.CapacityBytes == instanceInfo.Status.CapacityBytes &&
-		status == instanceInfo.Status.InstanceStatus &&
-		instance.CapacityStepSizeGb == instanceInfo.Status.CapacityStepSizeGb &&
-		!shareNamesUpdated {
-		return instanceInfo, nil
-	}
+	statusUpdated := instanceInfo.Status == nil ||
+		instance.CapacityBytes != instanceInfo.Status.CapacityBytes ||
+		status != instanceInfo.Status.InstanceStatus ||
+		instance.CapacityStepSizeGb != instanceInfo.Status.CapacityStepSizeGb ||
+		shareNamesUpdated
 
-	shareNameList := make([]string, 0, len(shareNames))
-	for name := range shareNames {
-		shareNameList = append(shareNameList, name)
+	newStatus := instanceInfoClone.Status
+	if newStatus == nil {
+		newStatus = &v1.InstanceInfoStatus{}
 	}
-	newStatus := &v1.InstanceInfoStatus{
-		CapacityBytes:      instance.CapacityBytes,
-		InstanceStatus:     status,
-		ShareNames:         shareNameList,
-		CapacityStepSizeGb: instance.CapacityStepSizeGb,
-		Cidr:               instance.Network.ReservedIpRange,
+	if statusUpdated {
+		shareNameList := make([]string, 0, len(shareNames))
+		for name := range shareNames {
+			shareNameList = append(shareNameList, name)
+		}
+		newStatus.CapacityBytes = instance.CapacityBytes
+		newStatus.InstanceStatus = status
+		newStatus.ShareNames = shareNameList
+		newStatus.CapacityStepSizeGb = instance.CapacityStepSizeGb
+		newStatus.Cidr = instance.Network.ReservedIpRange
+		// The errors of the previous operations are cleared once the instance is ready at the capacity of its spec.
+		if status == v1.READY && instance.CapacityBytes == instanceInfo.Spec.CapacityBytes {
+			newStatus.Error = ""
+		}
 	}
-	if instanceInfoClone.Status != nil {
-		newStatus.Error = instanceInfoClone.Status.Error
+	if !recon.refreshInstanceInfoStatus(instanceInfo, newStatus) && !statusUpdated {
+		return instanceInfo, nil
 	}
 	instanceInfoClone.Status = newStatus
 	klog.Infof("Trying to update InstanceInfo %s Status to %v", instanceInfo.Name, instanceInfoClone.Status)
@@ -1096,20 +1224,29 @@ func (recon *MultishareReconciler) maybeUpdateShareInfoStatus(share *file.Share,
 		return shareInfo, fmt.Errorf("Error generating instanceHandle from share %q: %v", share.Name, err)
 	}
 
-	if shareInfo.Status != nil &&
-		share.CapacityBytes == shareInfo.Status.CapacityBytes &&
-		status == shareInfo.Status.ShareStatus &&
-		instanceHandle == shareInfo.Status.InstanceHandle {
-		return shareInfo, nil
-	}
+	statusUpdated := shareInfo.Status == nil ||
+		share.CapacityBytes != shareInfo.Status.CapacityBytes ||
+		status != shareInfo.Status.ShareStatus ||
+		instanceHandle != shareInfo.Status.InstanceHandle
 
-	newStatus := &v1.ShareInfoStatus{
-		CapacityBytes:  share.CapacityBytes,
-		ShareStatus:    status,
-		InstanceHandle: instanceHandle,
+	newStatus := shareInfoClone.Status
+	if newStatus == nil {
+		newStatus = &v1.ShareInfoStatus{}
 	}
-	if shareInfoClone.Status != nil {
-		newStatus.Error = shareInfoClone.Status.Error
+	if statusUpdated {
+		if instanceHandle != newStatus.InstanceHandle {
+			newStatus.InstanceIP = ""
+		}
+		newStatus.CapacityBytes = share.CapacityBytes
+		newStatus.ShareStatus = status
+		newStatus.InstanceHandle = instanceHandle
+		// The errors of the previous operations are cleared once the share is ready at the capacity of its spec.
+		if status == v1.READY && share.CapacityBytes == shareInfo.Spec.CapacityBytes {
+			newStatus.Error = ""
+		}
+	}
+	if !recon.refreshShareInfoStatus(shareInfo, newStatus) && !statusUpdated {
+		return shareInfo, nil
 	}
 	shareInfoClone.Status = newStatus
 	klog.Infof("Trying to update ShareInfo %q status to %v", shareInfo.Name, shareInfoClone.Status)
@@ -1150,6 +1287,14 @@ func (recon *MultishareReconciler) updateInstanceInfoStatus(ctx context.Context,
 	return result, nil
 }
 
+// event records an event of obj, if the reconciler has an event recorder.
+func (recon *MultishareReconciler) event(obj runtime.Object, eventType, reason, messageFmt string, args ...interface{}) {
+	if recon.recorder == nil {
+		return
+	}
+	recon.recorder.Eventf(obj, eventType, reason, messageFmt, args...)
+}
+
 func (recon *MultishareReconciler) updateInstanceInfo(ctx context.Context, instanceInfoClone *v1.InstanceInfo) (*v1.InstanceInfo, error) {
 	result, err := recon.clientset.MultishareV1().InstanceInfos(util.ManagedFilestoreCSINamespace).Update(ctx, instanceInfoClone, metav1.UpdateOptions{})
 	if err != nil {
@@ -1264,12 +1409,16 @@ func (recon *MultishareReconciler) listMultishareResourceOps(ctx context.Context
 // instanceFitShare returns true if shareInfo can be assigned to instanceInfo.
 func (recon *MultishareReconciler) instanceFitShare(instanceInfo *v1.InstanceInfo, shareInfo *v1.ShareInfo) bool {
 	// Instance needs to be:
-	// 1. not up for delete 2.of the same storage class and 3. has less than max number of shares assigned already.
+	// 1. not up for delete 2.of the same storage class 3. in the region of the share and 4. has less than max number of shares assigned already.
 	if instanceInfo.DeletionTimestamp != nil ||
 		instanceInfo.Labels[ParamMultishareInstanceScLabel] != shareInfo.Spec.InstancePoolTag {
 		return false
 	}
 
+	if _, region, _, err := util.ParseInstanceURI(util.InstanceInfoNameToInstanceURI(instanceInfo.Name)); err != nil || (shareInfo.Spec.Region != "" && region != shareInfo.Spec.Region) {
+		return false
+	}
+
 	maxSharePerInstance := recon.parseMaxSharePerInstance(instanceInfo.Spec.Parameters)
 
 	if instanceInfo.Status != nil && len(instanceInfo.Status.ShareNames) >= maxSharePerInstance {
@@ -0,0 +1,201 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedv1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	clientset "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const defaultShareRelocateInterval = time.Minute
+
+// eventReasonShareMountedFromSource is recorded on the shareInfo of a migrated share by the nodes that
+// still mount a volume of the share from the source instance. The controller keeps the source share
+// while these events are recorded.
+const eventReasonShareMountedFromSource = "FilestoreShareMountedFromSource"
+
+// shareLocator looks up the instance serving the share of a multishare volume of the stateful multishare
+// controller. The volume context of a multishare volume keeps the IP address of the instance the share
+// was created on, a share migrated to another instance is served from the IP address in the status of
+// its shareInfo.
+//
+// The staged volumes whose share has been migrated are remounted from the new instance by the mount
+// health monitor, which the share migration requires. The volumes mounted through a TLS tunnel or by the
+// hostname of a Kerberos server are only relocated when they are staged again. Until then, and while a
+// relocation fails, the volume is reported on the shareInfo as mounted from the source instance, so that
+// the controller doesn't delete the source share.
+type shareLocator struct {
+	client      clientset.Interface
+	interval    time.Duration
+	mountHealth *mountHealthMonitor
+	nodeName    string
+	recorder    record.EventRecorder
+
+	mux sync.Mutex
+	// instanceIPs are the IP addresses of the instances the staged multishare volumes are mounted from, by
+	// volume ID.
+	instanceIPs map[string]string
+}
+
+func newShareLocator(feature *FeatureShareMigration, mountHealth *mountHealthMonitor, nodeName string) (*shareLocator, error) {
+	if mountHealth == nil {
+		return nil, fmt.Errorf("share migration requires the mount health monitor to relocate the staged volumes")
+	}
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := clientset.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	kubeClient, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	l := &shareLocator{
+		client:      client,
+		interval:    feature.RelocateInterval,
+		mountHealth: mountHealth,
+		nodeName:    nodeName,
+		instanceIPs: make(map[string]string),
+	}
+	if l.interval <= 0 {
+		l.interval = defaultShareRelocateInterval
+	}
+	// The volumes mounted from the source instance are reported at every relocate interval, which the
+	// default spam filter of the event recorder would drop after a few reports.
+	broadcaster := record.NewBroadcasterWithCorrelatorOptions(record.CorrelatorOptions{QPS: 1, BurstSize: 25})
+	broadcaster.StartRecordingToSink(&typedv1.EventSinkImpl{Interface: kubeClient.CoreV1().Events("")})
+	l.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "filestore-csi-node", Host: nodeName})
+	return l, nil
+}
+
+// stage records the IP address of the instance a multishare volume is staged from. A nil locator does
+// nothing.
+func (l *shareLocator) stage(volumeID, ip string) {
+	if l == nil {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	l.instanceIPs[volumeID] = ip
+}
+
+// unstage forgets an unstaged volume. A nil locator does nothing.
+func (l *shareLocator) unstage(volumeID string) {
+	if l == nil {
+		return
+	}
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	delete(l.instanceIPs, volumeID)
+}
+
+func (l *shareLocator) stagedInstanceIPs() map[string]string {
+	l.mux.Lock()
+	defer l.mux.Unlock()
+	ips := make(map[string]string, len(l.instanceIPs))
+	for volumeID, ip := range l.instanceIPs {
+		ips[volumeID] = ip
+	}
+	return ips
+}
+
+// instanceIP returns the IP address of the instance serving a share, ip if the share has not been
+// migrated or its shareInfo can't be read. A nil locator returns ip.
+func (l *shareLocator) instanceIP(ctx context.Context, shareName, ip string) string {
+	if l == nil {
+		return ip
+	}
+	shareInfo, err := l.client.MultishareV1().ShareInfos(util.ManagedFilestoreCSINamespace).Get(ctx, util.ShareToShareInfoName(shareName), metav1.GetOptions{})
+	if err != nil {
+		if !errors.IsNotFound(err) {
+			klog.Warningf("Failed to get the shareInfo of share %q, using instance IP %s: %v", shareName, ip, err)
+		}
+		return ip
+	}
+	if shareInfo.Status == nil || shareInfo.Status.InstanceIP == "" {
+		return ip
+	}
+	return shareInfo.Status.InstanceIP
+}
+
+// Run relocates the staged volumes whose share has been migrated until stopCh is closed.
+func (l *shareLocator) Run(stopCh <-chan struct{}) {
+	klog.Infof("Starting share locator, relocate interval %v", l.interval)
+	wait.Until(l.relocateAll, l.interval, stopCh)
+}
+
+func (l *shareLocator) relocateAll() {
+	sources := l.mountHealth.stagedSources()
+	for volumeID, ip := range l.stagedInstanceIPs() {
+		_, _, _, _, shareName, err := parseMultishareVolId(volumeID)
+		if err != nil {
+			klog.Errorf("Failed to parse multishare volume %v: %v", volumeID, err)
+			continue
+		}
+		newIP := l.instanceIP(context.TODO(), shareName, ip)
+		if newIP == ip {
+			continue
+		}
+		// Only the volumes mounted directly from the IP address of the instance are remounted.
+		source := sources[volumeID]
+		if sourceIP, path, ok := strings.Cut(source, ":"); ok && sourceIP == ip {
+			newSource := fmt.Sprintf("%s:%s", newIP, path)
+			klog.Infof("Share %q of volume %v has been migrated, relocating from %s to %s", shareName, volumeID, source, newSource)
+			err := l.mountHealth.relocate(volumeID, newSource)
+			if err == nil {
+				l.stage(volumeID, newIP)
+				continue
+			}
+			klog.Errorf("Relocation of volume %v to %s failed: %v", volumeID, newSource, err)
+		}
+		l.event(shareName, corev1.EventTypeWarning, eventReasonShareMountedFromSource, "Filestore volume %s is mounted from %s on node %s, its share has been migrated to %s", volumeID, ip, l.nodeName, newIP)
+	}
+}
+
+// event records an event on the shareInfo of a share.
+func (l *shareLocator) event(shareName, eventtype, reason, messageFmt string, args ...interface{}) {
+	if l.recorder == nil {
+		return
+	}
+	shareInfo := &corev1.ObjectReference{
+		APIVersion: v1.SchemeGroupVersion.String(),
+		Kind:       "ShareInfo",
+		Namespace:  util.ManagedFilestoreCSINamespace,
+		Name:       util.ShareToShareInfoName(shareName),
+	}
+	l.recorder.Eventf(shareInfo, eventtype, reason, messageFmt, args...)
+}
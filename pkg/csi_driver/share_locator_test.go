@@ -0,0 +1,158 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	mount "k8s.io/mount-utils"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	fsfake "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const testMigratedVolumeID = "modeMultishare/test-prefix/test-project/us-central1/test-instance/test_share"
+
+func TestShareLocator(t *testing.T) {
+	migratedShareInfo := &v1.ShareInfo{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-share", Namespace: util.ManagedFilestoreCSINamespace},
+		Status:     &v1.ShareInfoStatus{InstanceIP: "10.0.1.2"},
+	}
+	cases := []struct {
+		name string
+		// migratedBeforeStage is set if the share is migrated before the volume is staged.
+		migratedBeforeStage bool
+		expectedSources     []string
+	}{
+		{
+			name:                "migrated before stage",
+			migratedBeforeStage: true,
+			expectedSources:     []string{"10.0.1.2:/test_share"},
+		},
+		{
+			name:            "migrated after stage",
+			expectedSources: []string{"10.0.0.2:/test_share", "10.0.1.2:/test_share"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{}}
+			metaService, err := metadata.NewFakeService()
+			if err != nil {
+				t.Fatalf("Failed to init metadata service")
+			}
+			features := &GCFSDriverFeatureOptions{
+				FeatureLockRelease:        &FeatureLockRelease{},
+				FeatureMountHealthMonitor: &FeatureMountHealthMonitor{Enabled: true},
+			}
+			server, err := newNodeServer(initTestDriver(t), mounter, metaService, features)
+			if err != nil {
+				t.Fatalf("Failed to create node server: %v", err)
+			}
+			ns := server.(*nodeServer)
+			recorder := record.NewFakeRecorder(10)
+			ns.mountHealth.recorder = recorder
+			ns.mountHealth.lazyUnmount = mounter.Unmount
+			client := fsfake.NewSimpleClientset()
+			ns.shareLocator = &shareLocator{client: client, interval: time.Minute, mountHealth: ns.mountHealth, instanceIPs: map[string]string{}}
+			if tc.migratedBeforeStage {
+				client.MultishareV1().ShareInfos(util.ManagedFilestoreCSINamespace).Create(context.TODO(), migratedShareInfo, metav1.CreateOptions{})
+			}
+
+			stagingPath := filepath.Join(t.TempDir(), "staging")
+			if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          testMigratedVolumeID,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  testVolumeCapability,
+				VolumeContext:     map[string]string{attrIP: "10.0.0.2"},
+			}); err != nil {
+				t.Fatalf("Failed to stage volume: %v", err)
+			}
+			if !tc.migratedBeforeStage {
+				client.MultishareV1().ShareInfos(util.ManagedFilestoreCSINamespace).Create(context.TODO(), migratedShareInfo, metav1.CreateOptions{})
+			}
+			ns.shareLocator.relocateAll()
+			// The volume is not relocated again once it is mounted from the instance of its share.
+			ns.shareLocator.relocateAll()
+
+			var sources []string
+			for _, action := range mounter.GetLog() {
+				if action.Action == mount.FakeActionMount {
+					sources = append(sources, action.Source)
+				}
+			}
+			if len(sources) != len(tc.expectedSources) {
+				t.Fatalf("got mounts of %v, expected %v", sources, tc.expectedSources)
+			}
+			for i := range sources {
+				if sources[i] != tc.expectedSources[i] {
+					t.Errorf("got mounts of %v, expected %v", sources, tc.expectedSources)
+				}
+			}
+			if relocated := len(tc.expectedSources) > 1; relocated != (len(recorder.Events) == 1) {
+				t.Errorf("got %d events, expected relocation event %t", len(recorder.Events), relocated)
+			}
+		})
+	}
+}
+
+func TestShareLocatorMountedFromSource(t *testing.T) {
+	mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{}}
+	mountHealth := newMountHealthMonitor(mounter, util.NewVolumeLocks(), "test-node", &FeatureMountHealthMonitor{Enabled: true})
+	client := fsfake.NewSimpleClientset(&v1.ShareInfo{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-share", Namespace: util.ManagedFilestoreCSINamespace},
+		Status:     &v1.ShareInfoStatus{InstanceIP: "10.0.1.2"},
+	})
+	recorder := record.NewFakeRecorder(10)
+	l := &shareLocator{client: client, interval: time.Minute, mountHealth: mountHealth, nodeName: "test-node", recorder: recorder, instanceIPs: map[string]string{}}
+
+	// The volume is mounted through the local endpoint of its TLS tunnel, it is not remounted.
+	mountHealth.stage(testMigratedVolumeID, filepath.Join(t.TempDir(), "staging"), "127.0.0.1:/test_share", "nfs", nil)
+	l.stage(testMigratedVolumeID, "10.0.0.2")
+	l.relocateAll()
+
+	if len(mounter.GetLog()) != 0 {
+		t.Errorf("got mounts %v, expected none", mounter.GetLog())
+	}
+	if len(recorder.Events) != 1 {
+		t.Fatalf("got %d events, expected 1", len(recorder.Events))
+	}
+	if event := <-recorder.Events; !strings.Contains(event, eventReasonShareMountedFromSource) {
+		t.Errorf("got event %q, expected reason %s", event, eventReasonShareMountedFromSource)
+	}
+
+	// Unstaged volumes are not reported.
+	l.unstage(testMigratedVolumeID)
+	l.relocateAll()
+	if len(recorder.Events) != 0 {
+		t.Errorf("got %d events after the volume is unstaged, expected none", len(recorder.Events))
+	}
+}
+
+func TestNewShareLocatorRequiresMountHealthMonitor(t *testing.T) {
+	if _, err := newShareLocator(&FeatureShareMigration{Enabled: true}, nil, "test-node"); err == nil {
+		t.Errorf("expected an error without the mount health monitor")
+	}
+}
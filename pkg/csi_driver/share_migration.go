@@ -0,0 +1,835 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	defaultCutoverGracePeriod = 5 * time.Minute
+
+	// migrationBackupPrefix is the prefix of the names of the backups taken to migrate shares.
+	migrationBackupPrefix = "share-migration-"
+)
+
+// migrationBackupOps runs the creation and deletion of the backups of the share migrations, which block
+// until the backup operations are done, in the background of the reconciliation rounds.
+type migrationBackupOps struct {
+	mux     sync.Mutex
+	running map[string]bool
+	// errs are the errors of the finished operations by backup URI, until they are read.
+	errs map[string]error
+}
+
+func newMigrationBackupOps() *migrationBackupOps {
+	return &migrationBackupOps{
+		running: make(map[string]bool),
+		errs:    make(map[string]error),
+	}
+}
+
+// start runs the operation of a backup, unless one is already running.
+func (o *migrationBackupOps) start(backupURI string, op func() error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	if o.running[backupURI] {
+		return
+	}
+	o.running[backupURI] = true
+	delete(o.errs, backupURI)
+	go func() {
+		err := op()
+		o.mux.Lock()
+		defer o.mux.Unlock()
+		delete(o.running, backupURI)
+		if err != nil {
+			o.errs[backupURI] = err
+		}
+	}()
+}
+
+// result returns true if an operation of a backup is running, and the error of its last operation, which
+// is only returned once.
+func (o *migrationBackupOps) result(backupURI string) (bool, error) {
+	o.mux.Lock()
+	defer o.mux.Unlock()
+	err := o.errs[backupURI]
+	delete(o.errs, backupURI)
+	return o.running[backupURI], err
+}
+
+// listShareMigrations returns the share migrations, or nil if the share migration feature is disabled.
+func (recon *MultishareReconciler) listShareMigrations() ([]*v1.ShareMigration, error) {
+	if recon.migrationLister == nil {
+		return nil, nil
+	}
+	return recon.migrationLister.ShareMigrations(util.ManagedFilestoreCSINamespace).List(labels.Everything())
+}
+
+// migrationPhase returns the phase of a share migration, Pending if it has not been started.
+func migrationPhase(migration *v1.ShareMigration) v1.ShareMigrationPhase {
+	if migration.Status == nil || migration.Status.Phase == "" {
+		return v1.ShareMigrationPending
+	}
+	return migration.Status.Phase
+}
+
+// migrationCopies returns the instance a started and unfinished share migration serves its share from,
+// and the instance of the other copy of the share. The source instance serves the share until the
+// cutover, the target instance after.
+func migrationCopies(migration *v1.ShareMigration) (string, string, bool) {
+	switch migrationPhase(migration) {
+	case v1.ShareMigrationBackingUp, v1.ShareMigrationFencing, v1.ShareMigrationRestoring, v1.ShareMigrationRollingBack:
+		return migration.Status.SourceInstanceHandle, migration.Status.TargetInstanceHandle, true
+	case v1.ShareMigrationCuttingOver, v1.ShareMigrationCleaningUp:
+		return migration.Status.TargetInstanceHandle, migration.Status.SourceInstanceHandle, true
+	}
+	return "", "", false
+}
+
+// migrationTargets returns the target instances of the migrations that have not cut over, by shareInfo.
+// The share is assigned to the target instance before its shareInfo points to it.
+func migrationTargets(migrations []*v1.ShareMigration) map[string]string {
+	targets := make(map[string]string)
+	for _, migration := range migrations {
+		if serving, other, ok := migrationCopies(migration); ok && serving == migration.Status.SourceInstanceHandle {
+			targets[migration.Spec.ShareInfoName] = other
+		}
+	}
+	return targets
+}
+
+// migrationSources returns the source instances of the migrations that have cut over, which keep the
+// share until it is deleted after the cutover grace period.
+func migrationSources(migrations []*v1.ShareMigration) map[string]bool {
+	sources := make(map[string]bool)
+	for _, migration := range migrations {
+		if _, other, ok := migrationCopies(migration); ok && other == migration.Status.SourceInstanceHandle {
+			sources[other] = true
+		}
+	}
+	return sources
+}
+
+// servingShareCopies filters out the copies of the migrating shares on the instances they are not served
+// from, so that the reconciler only reconciles the shareInfos and instanceInfos with the serving copies.
+// The returned values should be treated as read only.
+func servingShareCopies(migrations []*v1.ShareMigration, shares []*file.Share, instanceShares map[string][]*file.Share) ([]*file.Share, map[string][]*file.Share) {
+	hidden := make(map[string]bool)
+	for _, migration := range migrations {
+		if _, other, ok := migrationCopies(migration); ok {
+			hidden[other+"/shares/"+migration.Spec.ShareInfoName] = true
+		}
+	}
+	if len(hidden) == 0 {
+		return shares, instanceShares
+	}
+
+	hide := func(instanceURI string, share *file.Share) bool {
+		return hidden[instanceURI+"/shares/"+util.ShareToShareInfoName(share.Name)]
+	}
+	var servingShares []*file.Share
+	for _, share := range shares {
+		instanceURI, err := file.GenerateMultishareInstanceURI(share.Parent)
+		if err == nil && hide(instanceURI, share) {
+			klog.V(4).Infof("Share %q on instance %q is the copy of a migration, skipping reconciliation", share.Name, instanceURI)
+			continue
+		}
+		servingShares = append(servingShares, share)
+	}
+	servingInstanceShares := make(map[string][]*file.Share, len(instanceShares))
+	for instanceURI, shares := range instanceShares {
+		servingInstanceShares[instanceURI] = make([]*file.Share, 0, len(shares))
+		for _, share := range shares {
+			if !hide(instanceURI, share) {
+				servingInstanceShares[instanceURI] = append(servingInstanceShares[instanceURI], share)
+			}
+		}
+	}
+	return servingShares, servingInstanceShares
+}
+
+// runShareMigrations moves the migrating shares through the phases of their migration. instanceShares
+// has both copies of the migrating shares.
+func (recon *MultishareReconciler) runShareMigrations(migrations []*v1.ShareMigration, shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, instances []*file.MultishareInstance, instanceShares map[string][]*file.Share, ops []*Op) {
+	instanceIPs := make(map[string]string, len(instances))
+	for _, instance := range instances {
+		if instanceURI, err := file.GenerateMultishareInstanceURI(instance); err == nil {
+			instanceIPs[instanceURI] = instance.Network.Ip
+		}
+	}
+
+	for _, migration := range migrations {
+		// A migration goes through as many phases in a round as it can, up to the completion.
+		for phase := migrationPhase(migration); phase != v1.ShareMigrationCompleted && phase != v1.ShareMigrationFailed; phase = migrationPhase(migration) {
+			var status *v1.ShareMigrationStatus
+			var err error
+			switch phase {
+			case v1.ShareMigrationPending:
+				status, err = recon.startShareMigration(migration, migrations, shareInfos, instanceInfos, instanceShares)
+			case v1.ShareMigrationBackingUp:
+				status, err = recon.backUpMigratingShare(migration, instanceShares)
+			case v1.ShareMigrationFencing:
+				status, err = recon.fenceMigratingShare(migration, instanceShares, ops)
+			case v1.ShareMigrationRestoring:
+				status, err = recon.restoreMigratingShare(migration, shareInfos, instanceInfos, instanceShares, ops)
+			case v1.ShareMigrationCuttingOver:
+				status, err = recon.cutOverMigratingShare(migration, shareInfos, instanceInfos, instanceIPs)
+			case v1.ShareMigrationCleaningUp:
+				status, err = recon.cleanUpShareMigration(migration, instanceShares, ops)
+			case v1.ShareMigrationRollingBack:
+				status, err = recon.rollBackShareMigration(migration, shareInfos, instanceInfos, instanceShares, ops)
+			default:
+				err = fmt.Errorf("unknown phase %q", phase)
+			}
+			if err != nil {
+				klog.Errorf("Share migration %q in phase %s: %v", migration.Name, phase, err)
+				recon.updateShareMigrationErr(migration, err)
+				break
+			}
+			if status == nil {
+				break
+			}
+			updated, err := recon.updateShareMigrationStatus(migration, status)
+			if err != nil {
+				klog.Errorf("Failed to update status of share migration %q: %v", migration.Name, err)
+				break
+			}
+			klog.Infof("Share migration %q moved from phase %s to %s", migration.Name, phase, status.Phase)
+			migration = updated
+		}
+	}
+}
+
+// startShareMigration picks the target instance of a pending migration and assigns the share to it. The
+// export options of the source share are recorded before it is fenced.
+func (recon *MultishareReconciler) startShareMigration(migration *v1.ShareMigration, migrations []*v1.ShareMigration, shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, instanceShares map[string][]*file.Share) (*v1.ShareMigrationStatus, error) {
+	shareInfo, ok := shareInfos[migration.Spec.ShareInfoName]
+	if !ok || shareInfo.DeletionTimestamp != nil {
+		return failedMigrationStatus(migration, recon.now(), fmt.Errorf("shareInfo %q not found or being deleted", migration.Spec.ShareInfoName)), nil
+	}
+	for _, other := range migrations {
+		if _, _, ok := migrationCopies(other); ok && other.Name != migration.Name && other.Spec.ShareInfoName == shareInfo.Name {
+			return failedMigrationStatus(migration, recon.now(), fmt.Errorf("shareInfo %q is already migrated by %q", shareInfo.Name, other.Name)), nil
+		}
+	}
+	if shareInfo.Status == nil || shareInfo.Status.InstanceHandle == "" || shareInfo.Status.ShareStatus != v1.READY {
+		return nil, fmt.Errorf("share %q is not ready", shareInfo.Name)
+	}
+	if shareInfo.Spec.CapacityBytes != shareInfo.Status.CapacityBytes {
+		return nil, fmt.Errorf("share %q is being resized", shareInfo.Name)
+	}
+	source := shareInfo.Status.InstanceHandle
+	sourceShare := findInstanceShare(instanceShares, source, shareInfo.Name)
+	if sourceShare == nil {
+		return nil, fmt.Errorf("share %q not found on instance %q", shareInfo.Name, source)
+	}
+	var exportOptions string
+	if len(sourceShare.NfsExportOptions) > 0 {
+		b, err := json.Marshal(sourceShare.NfsExportOptions)
+		if err != nil {
+			return nil, err
+		}
+		exportOptions = string(b)
+	}
+
+	target := migration.Spec.TargetInstanceHandle
+	if target == source {
+		return failedMigrationStatus(migration, recon.now(), fmt.Errorf("share %q is already on instance %q", shareInfo.Name, target)), nil
+	}
+	var candidates []*PlacementCandidate
+	for instanceURI, instanceInfo := range instanceInfos {
+		if instanceURI == source || (target != "" && instanceURI != target) {
+			continue
+		}
+		if instanceInfo.Status != nil && instanceInfo.Status.InstanceStatus == v1.READY && recon.instanceFitShare(instanceInfo, shareInfo) {
+			candidates = append(candidates, instanceInfoPlacementCandidate(instanceInfo, shareInfos))
+		}
+	}
+	policy, err := placementPolicyFromParams(shareInfo.Spec.Parameters)
+	if err != nil {
+		return nil, err
+	}
+	placed := placeShare(policy, &PlacementShare{
+		Name:          shareInfo.Name,
+		CapacityBytes: shareInfo.Spec.CapacityBytes,
+		Namespace:     shareInfo.Spec.Parameters[ParameterKeyPVCNamespace],
+	}, candidates)
+	if placed == nil {
+		if target != "" {
+			return nil, fmt.Errorf("target instance %q is not a ready instance of the pool of share %q with room for it", target, shareInfo.Name)
+		}
+		return nil, fmt.Errorf("no ready instance of the pool of share %q has room for it", shareInfo.Name)
+	}
+	target = placed.Name
+
+	project, location, _, err := util.ParseInstanceURI(source)
+	if err != nil {
+		return nil, err
+	}
+	backupURI, _, err := file.CreateBackupURI(location, project, migrationBackupPrefix+string(uuid.NewUUID()), "")
+	if err != nil {
+		return nil, err
+	}
+	finalBackupURI, _, err := file.CreateBackupURI(location, project, migrationBackupPrefix+string(uuid.NewUUID()), "")
+	if err != nil {
+		return nil, err
+	}
+
+	// The target instance is grown for the share once it is assigned the share.
+	instanceInfo, err := recon.assignShareToInstanceInfo(instanceInfos[target], shareInfo.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to assign share %q to target instanceInfo %q: %w", shareInfo.Name, instanceInfos[target].Name, err)
+	}
+	instanceInfos[target] = instanceInfo
+
+	now := metav1.NewTime(recon.now())
+	return &v1.ShareMigrationStatus{
+		Phase:                  v1.ShareMigrationBackingUp,
+		SourceInstanceHandle:   source,
+		TargetInstanceHandle:   target,
+		BackupHandle:           backupURI,
+		FinalBackupHandle:      finalBackupURI,
+		SourceNfsExportOptions: exportOptions,
+		StartTime:              &now,
+	}, nil
+}
+
+// backUpMigratingShare takes a first backup of the share on the source instance while it is still
+// written, so that the final backup of the fenced share is incremental.
+func (recon *MultishareReconciler) backUpMigratingShare(migration *v1.ShareMigration, instanceShares map[string][]*file.Share) (*v1.ShareMigrationStatus, error) {
+	ready, rollBack, err := recon.backUpSourceShare(migration, migration.Status.BackupHandle, instanceShares)
+	if !ready || rollBack != nil || err != nil {
+		return rollBack, err
+	}
+	status := migration.Status.DeepCopy()
+	status.Phase = v1.ShareMigrationFencing
+	status.Error = ""
+	return status, nil
+}
+
+// fenceMigratingShare exports the share on the source instance read only, so that no write is made to
+// it after its final backup, then takes the final backup.
+func (recon *MultishareReconciler) fenceMigratingShare(migration *v1.ShareMigration, instanceShares map[string][]*file.Share, ops []*Op) (*v1.ShareMigrationStatus, error) {
+	source := findInstanceShare(instanceShares, migration.Status.SourceInstanceHandle, migration.Spec.ShareInfoName)
+	if source == nil {
+		return rollBackMigrationStatus(migration, fmt.Errorf("share not found on source instance %q", migration.Status.SourceInstanceHandle)), nil
+	}
+	if !exportedReadOnly(source.NfsExportOptions) {
+		exportOptions, err := parseNfsExportOptions(migration.Status.SourceNfsExportOptions)
+		if err != nil {
+			return rollBackMigrationStatus(migration, fmt.Errorf("invalid export options of the source share: %w", err)), nil
+		}
+		done, err := recon.patchMigrationSourceExports(migration, source, readOnlyExportOptions(exportOptions), ops)
+		if err != nil {
+			return rollBackMigrationStatus(migration, fmt.Errorf("failed to fence share: %w", err)), nil
+		}
+		if !done {
+			return nil, nil
+		}
+	}
+
+	ready, rollBack, err := recon.backUpSourceShare(migration, migration.Status.FinalBackupHandle, instanceShares)
+	if !ready || rollBack != nil || err != nil {
+		return rollBack, err
+	}
+	status := migration.Status.DeepCopy()
+	status.Phase = v1.ShareMigrationRestoring
+	status.Error = ""
+	return status, nil
+}
+
+// patchMigrationSourceExports sets the export options of the share on the source instance, and returns
+// true once they are set.
+func (recon *MultishareReconciler) patchMigrationSourceExports(migration *v1.ShareMigration, source *file.Share, exportOptions []*file.NfsExportOptions, ops []*Op) (bool, error) {
+	shareURI, err := file.GenerateShareURI(source)
+	if err != nil {
+		return false, err
+	}
+	op, err := runningOpMaybeErrForTarget(shareURI, ops)
+	if err != nil {
+		return false, err
+	}
+	if op != nil {
+		return false, nil
+	}
+	share := *source
+	share.NfsExportOptions = exportOptions
+	klog.Infof("Share migration %q is setting the export options of share %q to %s", migration.Name, shareURI, exportOptionsString(exportOptions))
+	if _, err := recon.cloud.File.StartPatchShareOp(context.TODO(), &share, []string{file.NfsExportOptionsUpdateMask}); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// exportedReadOnly returns true if a share is only exported read only.
+func exportedReadOnly(exportOptions []*file.NfsExportOptions) bool {
+	if len(exportOptions) == 0 {
+		return false
+	}
+	for _, opt := range exportOptions {
+		if opt.AccessMode != "READ_ONLY" {
+			return false
+		}
+	}
+	return true
+}
+
+// readOnlyExportOptions returns the export options of a fenced share: its export options made read only,
+// or the share exported read only to all clients if it has none.
+func readOnlyExportOptions(exportOptions []*file.NfsExportOptions) []*file.NfsExportOptions {
+	if len(exportOptions) == 0 {
+		return []*file.NfsExportOptions{{AccessMode: "READ_ONLY", SquashMode: "NO_ROOT_SQUASH"}}
+	}
+	readOnly := make([]*file.NfsExportOptions, 0, len(exportOptions))
+	for _, opt := range exportOptions {
+		o := *opt
+		o.AccessMode = "READ_ONLY"
+		readOnly = append(readOnly, &o)
+	}
+	return readOnly
+}
+
+func exportOptionsString(exportOptions []*file.NfsExportOptions) string {
+	b, err := json.Marshal(exportOptions)
+	if err != nil {
+		return fmt.Sprintf("%v", exportOptions)
+	}
+	return string(b)
+}
+
+// backUpSourceShare takes a backup of the share on the source instance. It returns true once the backup
+// is ready, or the status of the rolled back migration if the backup fails.
+func (recon *MultishareReconciler) backUpSourceShare(migration *v1.ShareMigration, backupURI string, instanceShares map[string][]*file.Share) (bool, *v1.ShareMigrationStatus, error) {
+	running, err := recon.migrationBackups.result(backupURI)
+	if err != nil {
+		return false, rollBackMigrationStatus(migration, fmt.Errorf("failed to back up share: %w", err)), nil
+	}
+	if running {
+		return false, nil, nil
+	}
+
+	backup, err := recon.cloud.File.GetBackup(context.TODO(), backupURI)
+	if err == nil {
+		switch backup.Backup.State {
+		case "READY":
+			return true, nil, nil
+		case "CREATING", "FINALIZING":
+			return false, nil, nil
+		default:
+			return false, rollBackMigrationStatus(migration, fmt.Errorf("backup %q is in state %s", backupURI, backup.Backup.State)), nil
+		}
+	}
+	if !file.IsNotFoundErr(err) {
+		return false, nil, err
+	}
+
+	source := findInstanceShare(instanceShares, migration.Status.SourceInstanceHandle, migration.Spec.ShareInfoName)
+	if source == nil {
+		return false, rollBackMigrationStatus(migration, fmt.Errorf("share not found on source instance %q", migration.Status.SourceInstanceHandle)), nil
+	}
+	project, location, backupName, err := parseBackupURI(backupURI)
+	if err != nil {
+		return false, nil, err
+	}
+	backupInfo := &file.BackupInfo{
+		Name:               backupName,
+		SourceVolumeId:     fmt.Sprintf("%s/%s/%s/%s", modeMultishare, source.Parent.Location, source.Parent.Name, source.Name),
+		Project:            project,
+		Location:           location,
+		SourceShare:        source.Name,
+		SourceInstanceName: source.Parent.Name,
+		BackupURI:          backupURI,
+		Labels:             source.Labels,
+	}
+	klog.Infof("Share migration %q is backing up share %q to %q", migration.Name, source.Name, backupURI)
+	recon.migrationBackups.start(backupURI, func() error {
+		_, err := recon.cloud.File.CreateBackup(context.TODO(), backupInfo)
+		return err
+	})
+	return false, nil, nil
+}
+
+// restoreMigratingShare restores the final backup of the share into a new share on the target instance, once
+// the target instance has been grown for it.
+func (recon *MultishareReconciler) restoreMigratingShare(migration *v1.ShareMigration, shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, instanceShares map[string][]*file.Share, ops []*Op) (*v1.ShareMigrationStatus, error) {
+	shareInfo, ok := shareInfos[migration.Spec.ShareInfoName]
+	if !ok || shareInfo.DeletionTimestamp != nil {
+		return rollBackMigrationStatus(migration, fmt.Errorf("shareInfo %q not found or being deleted", migration.Spec.ShareInfoName)), nil
+	}
+	target := migration.Status.TargetInstanceHandle
+	if restored := findInstanceShare(instanceShares, target, migration.Spec.ShareInfoName); restored != nil {
+		if restored.State != "READY" {
+			return nil, nil
+		}
+		now := metav1.NewTime(recon.now())
+		status := migration.Status.DeepCopy()
+		status.Phase = v1.ShareMigrationCuttingOver
+		status.CutoverTime = &now
+		status.Error = ""
+		return status, nil
+	}
+
+	source := findInstanceShare(instanceShares, migration.Status.SourceInstanceHandle, migration.Spec.ShareInfoName)
+	if source == nil {
+		return rollBackMigrationStatus(migration, fmt.Errorf("share not found on source instance %q", migration.Status.SourceInstanceHandle)), nil
+	}
+	instanceInfo, ok := instanceInfos[target]
+	if !ok || instanceInfo.DeletionTimestamp != nil {
+		return rollBackMigrationStatus(migration, fmt.Errorf("target instanceInfo %q not found or being deleted", target)), nil
+	}
+	if instanceInfo.Status == nil || instanceInfo.Status.InstanceStatus != v1.READY || instanceInfo.Status.CapacityBytes < instanceInfo.Spec.CapacityBytes {
+		klog.Infof("Share migration %q is waiting for target instance %q to be ready", migration.Name, target)
+		return nil, nil
+	}
+
+	share, err := shareOnInstance(source.Name, target)
+	if err != nil {
+		return nil, err
+	}
+	// The source share is fenced, the target share is exported with the export options it had before.
+	exportOptions, err := parseNfsExportOptions(migration.Status.SourceNfsExportOptions)
+	if err != nil {
+		return rollBackMigrationStatus(migration, fmt.Errorf("invalid export options of the source share: %w", err)), nil
+	}
+	share.CapacityBytes = util.Max(shareInfo.Spec.CapacityBytes, source.CapacityBytes)
+	share.MountPointName = source.Name
+	share.Labels = source.Labels
+	share.NfsExportOptions = exportOptions
+	share.BackupId = migration.Status.FinalBackupHandle
+	shareURI, err := file.GenerateShareURI(share)
+	if err != nil {
+		return nil, err
+	}
+	op, err := runningOpMaybeErrForTarget(shareURI, ops)
+	if err != nil {
+		return rollBackMigrationStatus(migration, fmt.Errorf("failed to restore share: %w", err)), nil
+	}
+	if op != nil {
+		return nil, nil
+	}
+	klog.Infof("Share migration %q is restoring backup %q into share %q", migration.Name, migration.Status.FinalBackupHandle, shareURI)
+	if _, err := recon.cloud.File.StartCreateShareOp(context.TODO(), share); err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// cutOverMigratingShare points the shareInfo and instanceInfos to the target instance, then waits for the
+// cutover grace period for the nodes to remount the volume from the target instance, and until no node
+// has reported the volume mounted from the source instance for a grace period.
+func (recon *MultishareReconciler) cutOverMigratingShare(migration *v1.ShareMigration, shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, instanceIPs map[string]string) (*v1.ShareMigrationStatus, error) {
+	source, target := migration.Status.SourceInstanceHandle, migration.Status.TargetInstanceHandle
+	if shareInfo, ok := shareInfos[migration.Spec.ShareInfoName]; ok {
+		ip, ok := instanceIPs[target]
+		if !ok || ip == "" {
+			return nil, fmt.Errorf("IP address of target instance %q not found", target)
+		}
+		if shareInfo.Status == nil || shareInfo.Status.InstanceHandle != target || shareInfo.Status.InstanceIP != ip {
+			shareInfoClone := shareInfo.DeepCopy()
+			if shareInfoClone.Status == nil {
+				shareInfoClone.Status = &v1.ShareInfoStatus{}
+			}
+			shareInfoClone.Status.InstanceHandle = target
+			shareInfoClone.Status.InstanceIP = ip
+			klog.Infof("Share migration %q is cutting over shareInfo %q to instance %q at %s", migration.Name, shareInfo.Name, target, ip)
+			updated, err := recon.updateShareInfoStatus(context.TODO(), shareInfoClone)
+			if err != nil {
+				return nil, fmt.Errorf("failed to cut over shareInfo %q: %w", shareInfo.Name, err)
+			}
+			shareInfos[shareInfo.Name] = updated
+		}
+	}
+	if instanceInfo, ok := instanceInfos[target]; ok {
+		updated, err := recon.assignShareToInstanceInfo(instanceInfo, migration.Spec.ShareInfoName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to assign share to target instanceInfo %q: %w", instanceInfo.Name, err)
+		}
+		instanceInfos[target] = updated
+	}
+	if instanceInfo, ok := instanceInfos[source]; ok {
+		if instanceInfoClone, removed := recon.removeShareFromInstanceInfo(instanceInfo.DeepCopy(), migration.Spec.ShareInfoName); removed {
+			updated, err := recon.updateInstanceInfoStatus(context.TODO(), instanceInfoClone)
+			if err != nil {
+				return nil, fmt.Errorf("failed to remove share from source instanceInfo %q: %w", instanceInfo.Name, err)
+			}
+			instanceInfos[source] = updated
+		}
+	}
+
+	gracePeriod := defaultCutoverGracePeriod
+	if migration.Spec.CutoverGracePeriod != nil {
+		gracePeriod = migration.Spec.CutoverGracePeriod.Duration
+	}
+	if recon.now().Sub(migration.Status.CutoverTime.Time) < gracePeriod {
+		return nil, nil
+	}
+	nodes, err := recon.nodesMountingMigrationSource(migration, gracePeriod)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) > 0 {
+		return nil, fmt.Errorf("share is still mounted from source instance %q on nodes %s", source, strings.Join(nodes, ", "))
+	}
+	status := migration.Status.DeepCopy()
+	status.Phase = v1.ShareMigrationCleaningUp
+	status.Error = ""
+	return status, nil
+}
+
+// nodesMountingMigrationSource returns the nodes that reported a volume of the migrated share mounted
+// from the source instance since the cutover, within the last grace period. The node drivers report
+// these volumes with events on the shareInfo at every relocate interval, until they are remounted from
+// the target instance or unstaged.
+func (recon *MultishareReconciler) nodesMountingMigrationSource(migration *v1.ShareMigration, gracePeriod time.Duration) ([]string, error) {
+	if recon.kubeClient == nil {
+		return nil, fmt.Errorf("no Kubernetes client to check the nodes mounting the source share")
+	}
+	selector := fields.Set{
+		"involvedObject.name": migration.Spec.ShareInfoName,
+		"reason":              eventReasonShareMountedFromSource,
+	}.AsSelector().String()
+	events, err := recon.kubeClient.CoreV1().Events(util.ManagedFilestoreCSINamespace).List(context.TODO(), metav1.ListOptions{FieldSelector: selector})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the events of shareInfo %q: %w", migration.Spec.ShareInfoName, err)
+	}
+	since := recon.now().Add(-gracePeriod)
+	if since.Before(migration.Status.CutoverTime.Time) {
+		since = migration.Status.CutoverTime.Time
+	}
+	var nodes []string
+	seen := make(map[string]bool)
+	for _, event := range events.Items {
+		if event.InvolvedObject.Name != migration.Spec.ShareInfoName || event.Reason != eventReasonShareMountedFromSource {
+			continue
+		}
+		if event.LastTimestamp.Time.Before(since) || seen[event.Source.Host] {
+			continue
+		}
+		seen[event.Source.Host] = true
+		nodes = append(nodes, event.Source.Host)
+	}
+	sort.Strings(nodes)
+	return nodes, nil
+}
+
+// cleanUpShareMigration deletes the share on the source instance, then the backups.
+func (recon *MultishareReconciler) cleanUpShareMigration(migration *v1.ShareMigration, instanceShares map[string][]*file.Share, ops []*Op) (*v1.ShareMigrationStatus, error) {
+	done, err := recon.deleteMigrationCopy(migration, migration.Status.SourceInstanceHandle, instanceShares, ops)
+	if err != nil || !done {
+		return nil, err
+	}
+	done, err = recon.deleteMigrationBackups(migration)
+	if err != nil || !done {
+		return nil, err
+	}
+	now := metav1.NewTime(recon.now())
+	status := migration.Status.DeepCopy()
+	status.Phase = v1.ShareMigrationCompleted
+	status.CompletionTime = &now
+	status.Error = ""
+	return status, nil
+}
+
+// rollBackShareMigration deletes the share restored on the target instance and the backups of a migration
+// that failed before the cutover, unassigns the share from the target instance, and restores the export
+// options of the fenced source share.
+func (recon *MultishareReconciler) rollBackShareMigration(migration *v1.ShareMigration, shareInfos map[string]*v1.ShareInfo, instanceInfos map[string]*v1.InstanceInfo, instanceShares map[string][]*file.Share, ops []*Op) (*v1.ShareMigrationStatus, error) {
+	target := migration.Status.TargetInstanceHandle
+	if shareInfo, ok := shareInfos[migration.Spec.ShareInfoName]; ok && shareInfo.Status != nil && shareInfo.Status.InstanceHandle == target {
+		return nil, fmt.Errorf("shareInfo %q points to target instance %q, not rolling back", shareInfo.Name, target)
+	}
+	done, err := recon.deleteMigrationCopy(migration, target, instanceShares, ops)
+	if err != nil || !done {
+		return nil, err
+	}
+	if instanceInfo, ok := instanceInfos[target]; ok {
+		if instanceInfoClone, removed := recon.removeShareFromInstanceInfo(instanceInfo.DeepCopy(), migration.Spec.ShareInfoName); removed {
+			updated, err := recon.updateInstanceInfoStatus(context.TODO(), instanceInfoClone)
+			if err != nil {
+				return nil, fmt.Errorf("failed to remove share from target instanceInfo %q: %w", instanceInfo.Name, err)
+			}
+			instanceInfos[target] = updated
+		}
+	}
+	exportOptions, err := parseNfsExportOptions(migration.Status.SourceNfsExportOptions)
+	if err != nil {
+		return nil, fmt.Errorf("invalid export options of the source share: %w", err)
+	}
+	if source := findInstanceShare(instanceShares, migration.Status.SourceInstanceHandle, migration.Spec.ShareInfoName); source != nil && exportedReadOnly(source.NfsExportOptions) && !exportedReadOnly(exportOptions) {
+		done, err := recon.patchMigrationSourceExports(migration, source, exportOptions, ops)
+		if err != nil || !done {
+			return nil, err
+		}
+	}
+	done, err = recon.deleteMigrationBackups(migration)
+	if err != nil || !done {
+		return nil, err
+	}
+	now := metav1.NewTime(recon.now())
+	status := migration.Status.DeepCopy()
+	status.Phase = v1.ShareMigrationFailed
+	status.CompletionTime = &now
+	return status, nil
+}
+
+// deleteMigrationCopy deletes the copy of a migrating share on an instance, and returns true once it is
+// deleted.
+func (recon *MultishareReconciler) deleteMigrationCopy(migration *v1.ShareMigration, instanceURI string, instanceShares map[string][]*file.Share, ops []*Op) (bool, error) {
+	share := findInstanceShare(instanceShares, instanceURI, migration.Spec.ShareInfoName)
+	if share == nil {
+		return true, nil
+	}
+	shareURI, err := file.GenerateShareURI(share)
+	if err != nil {
+		return false, err
+	}
+	op, err := runningOpMaybeErrForTarget(shareURI, ops)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete share %q: %w", shareURI, err)
+	}
+	if op != nil {
+		return false, nil
+	}
+	klog.Infof("Share migration %q is deleting share %q", migration.Name, shareURI)
+	if _, err := recon.cloud.File.StartDeleteShareOp(context.TODO(), share); err != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// deleteMigrationBackups deletes the backups of a share migration, and returns true once they are deleted.
+func (recon *MultishareReconciler) deleteMigrationBackups(migration *v1.ShareMigration) (bool, error) {
+	deleted := true
+	for _, backupURI := range []string{migration.Status.BackupHandle, migration.Status.FinalBackupHandle} {
+		if backupURI == "" {
+			continue
+		}
+		done, err := recon.deleteMigrationBackup(migration, backupURI)
+		if err != nil {
+			return false, err
+		}
+		deleted = deleted && done
+	}
+	return deleted, nil
+}
+
+// deleteMigrationBackup deletes a backup of a share migration, and returns true once it is deleted.
+func (recon *MultishareReconciler) deleteMigrationBackup(migration *v1.ShareMigration, backupURI string) (bool, error) {
+	running, err := recon.migrationBackups.result(backupURI)
+	if running {
+		return false, nil
+	}
+	if err != nil {
+		// The deletion is retried if the backup still exists.
+		klog.Warningf("Share migration %q failed to delete backup %q: %v", migration.Name, backupURI, err)
+	}
+	if _, err := recon.cloud.File.GetBackup(context.TODO(), backupURI); err != nil {
+		if file.IsNotFoundErr(err) {
+			return true, nil
+		}
+		return false, err
+	}
+	klog.Infof("Share migration %q is deleting backup %q", migration.Name, backupURI)
+	recon.migrationBackups.start(backupURI, func() error {
+		return recon.cloud.File.DeleteBackup(context.TODO(), backupURI)
+	})
+	return false, nil
+}
+
+// findInstanceShare returns the share of a shareInfo on an instance, or nil if the instance doesn't have it.
+func findInstanceShare(instanceShares map[string][]*file.Share, instanceURI, shareInfoName string) *file.Share {
+	for _, share := range instanceShares[instanceURI] {
+		if util.ShareToShareInfoName(share.Name) == shareInfoName {
+			return share
+		}
+	}
+	return nil
+}
+
+// shareOnInstance returns a share of an instance, with its parent instance parsed from the instance URI.
+func shareOnInstance(shareName, instanceURI string) (*file.Share, error) {
+	project, location, name, err := util.ParseInstanceURI(instanceURI)
+	if err != nil {
+		return nil, err
+	}
+	return &file.Share{
+		Name: shareName,
+		Parent: &file.MultishareInstance{
+			Project:  project,
+			Location: location,
+			Name:     name,
+		},
+	}, nil
+}
+
+// failedMigrationStatus returns the status of a migration that failed before it started.
+func failedMigrationStatus(migration *v1.ShareMigration, now time.Time, err error) *v1.ShareMigrationStatus {
+	status := &v1.ShareMigrationStatus{}
+	if migration.Status != nil {
+		status = migration.Status.DeepCopy()
+	}
+	completionTime := metav1.NewTime(now)
+	status.Phase = v1.ShareMigrationFailed
+	status.CompletionTime = &completionTime
+	status.Error = err.Error()
+	return status
+}
+
+// rollBackMigrationStatus returns the status of a started migration that failed before the cutover.
+func rollBackMigrationStatus(migration *v1.ShareMigration, err error) *v1.ShareMigrationStatus {
+	status := migration.Status.DeepCopy()
+	status.Phase = v1.ShareMigrationRollingBack
+	status.Error = err.Error()
+	return status
+}
+
+func (recon *MultishareReconciler) updateShareMigrationErr(migration *v1.ShareMigration, err error) {
+	if migration.Status != nil && strings.EqualFold(migration.Status.Error, err.Error()) {
+		return
+	}
+	status := &v1.ShareMigrationStatus{Phase: v1.ShareMigrationPending}
+	if migration.Status != nil {
+		status = migration.Status.DeepCopy()
+	}
+	status.Error = err.Error()
+	if _, err := recon.updateShareMigrationStatus(migration, status); err != nil {
+		klog.Errorf("Failed to update error of share migration %q: %v", migration.Name, err)
+	}
+}
+
+func (recon *MultishareReconciler) updateShareMigrationStatus(migration *v1.ShareMigration, status *v1.ShareMigrationStatus) (*v1.ShareMigration, error) {
+	migrationClone := migration.DeepCopy()
+	migrationClone.Status = status
+	return recon.clientset.MultishareV1().ShareMigrations(util.ManagedFilestoreCSINamespace).UpdateStatus(context.TODO(), migrationClone, metav1.UpdateOptions{})
+}
@@ -0,0 +1,516 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	fsfake "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	testSourceInstance = "source-instance"
+	testTargetInstance = "target-instance"
+	testMigratedShare  = "pvc-1"
+	testMigration      = "migrate-pvc-1"
+)
+
+func TestServingShareCopies(t *testing.T) {
+	sourceURI := instanceURI(testProject, testRegion, testSourceInstance)
+	targetURI := instanceURI(testProject, testRegion, testTargetInstance)
+	share := func(instance, name string) *file.Share {
+		return &file.Share{
+			Name:   name,
+			Parent: &file.MultishareInstance{Project: testProject, Location: testRegion, Name: instance},
+		}
+	}
+	migration := func(phase v1.ShareMigrationPhase) *v1.ShareMigration {
+		return &v1.ShareMigration{
+			ObjectMeta: metav1.ObjectMeta{Name: testMigration},
+			Spec:       v1.ShareMigrationSpec{ShareInfoName: testMigratedShare},
+			Status: &v1.ShareMigrationStatus{
+				Phase:                phase,
+				SourceInstanceHandle: sourceURI,
+				TargetInstanceHandle: targetURI,
+			},
+		}
+	}
+
+	cases := []struct {
+		name       string
+		migrations []*v1.ShareMigration
+		// expected are the instances of the expected copies of the migrated share.
+		expected []string
+	}{
+		{
+			name:     "no migration",
+			expected: []string{testSourceInstance, testTargetInstance},
+		},
+		{
+			name:       "pending",
+			migrations: []*v1.ShareMigration{{ObjectMeta: metav1.ObjectMeta{Name: testMigration}, Spec: v1.ShareMigrationSpec{ShareInfoName: testMigratedShare}}},
+			expected:   []string{testSourceInstance, testTargetInstance},
+		},
+		{
+			name:       "restoring",
+			migrations: []*v1.ShareMigration{migration(v1.ShareMigrationRestoring)},
+			expected:   []string{testSourceInstance},
+		},
+		{
+			name:       "rolling back",
+			migrations: []*v1.ShareMigration{migration(v1.ShareMigrationRollingBack)},
+			expected:   []string{testSourceInstance},
+		},
+		{
+			name:       "cutting over",
+			migrations: []*v1.ShareMigration{migration(v1.ShareMigrationCuttingOver)},
+			expected:   []string{testTargetInstance},
+		},
+		{
+			name:       "cleaning up",
+			migrations: []*v1.ShareMigration{migration(v1.ShareMigrationCleaningUp)},
+			expected:   []string{testTargetInstance},
+		},
+		{
+			name:       "completed",
+			migrations: []*v1.ShareMigration{migration(v1.ShareMigrationCompleted)},
+			expected:   []string{testSourceInstance, testTargetInstance},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			shares := []*file.Share{
+				share(testSourceInstance, testMigratedShare),
+				share(testTargetInstance, testMigratedShare),
+				share(testSourceInstance, "pvc-2"),
+			}
+			instanceShares := map[string][]*file.Share{
+				sourceURI: {shares[0], shares[2]},
+				targetURI: {shares[1]},
+			}
+
+			servingShares, servingInstanceShares := servingShareCopies(tc.migrations, shares, instanceShares)
+
+			var got, gotByInstance []string
+			for _, s := range servingShares {
+				if s.Name == testMigratedShare {
+					got = append(got, s.Parent.Name)
+				}
+			}
+			for uri, shares := range servingInstanceShares {
+				for _, s := range shares {
+					if s.Name == testMigratedShare {
+						_, _, name, _ := util.ParseInstanceURI(uri)
+						gotByInstance = append(gotByInstance, name)
+					}
+				}
+			}
+			sort.Strings(got)
+			sort.Strings(gotByInstance)
+			if !reflect.DeepEqual(got, tc.expected) || !reflect.DeepEqual(gotByInstance, tc.expected) {
+				t.Errorf("got copies on %v and %v by instance, expected %v", got, gotByInstance, tc.expected)
+			}
+			if len(servingInstanceShares[sourceURI]) == 0 || servingInstanceShares[sourceURI][len(servingInstanceShares[sourceURI])-1].Name != "pvc-2" {
+				t.Errorf("other share of the source instance is missing: %v", servingInstanceShares[sourceURI])
+			}
+		})
+	}
+}
+
+type shareMigrationTestEnv struct {
+	recon      *MultishareReconciler
+	clientset  *fsfake.Clientset
+	kubeClient *k8sfake.Clientset
+	fileSvc    file.Service
+	now        time.Time
+}
+
+// initShareMigrationTestEnv returns a reconciler with a share on the source instance, an empty target
+// instance, and the migration of the share.
+func initShareMigrationTestEnv(t *testing.T, migration *v1.ShareMigration) *shareMigrationTestEnv {
+	instance := func(name, ip string) *file.MultishareInstance {
+		return &file.MultishareInstance{
+			Project:       testProject,
+			Location:      testRegion,
+			Name:          name,
+			CapacityBytes: util.MinMultishareInstanceSizeBytes,
+			Network:       file.Network{Ip: ip},
+			State:         "READY",
+		}
+	}
+	instanceInfo := func(name string, shares ...string) *v1.InstanceInfo {
+		return &v1.InstanceInfo{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      util.InstanceURIToInstanceInfoName(instanceURI(testProject, testRegion, name)),
+				Namespace: util.ManagedFilestoreCSINamespace,
+				Labels:    map[string]string{ParamMultishareInstanceScLabel: testInstanceScPrefix},
+			},
+			Spec:   v1.InstanceInfoSpec{CapacityBytes: util.MinMultishareInstanceSizeBytes},
+			Status: &v1.InstanceInfoStatus{ShareNames: shares, InstanceStatus: v1.READY, CapacityBytes: util.MinMultishareInstanceSizeBytes},
+		}
+	}
+	source := instance(testSourceInstance, "10.0.0.2")
+	share := &file.Share{
+		Name:           testMigratedShare,
+		Parent:         source,
+		CapacityBytes:  100 * util.Gb,
+		MountPointName: testMigratedShare,
+		Labels:         map[string]string{"key": "value"},
+		NfsExportOptions: []*file.NfsExportOptions{
+			{AccessMode: "READ_WRITE", SquashMode: "NO_ROOT_SQUASH", IpRanges: []string{"10.0.0.0/8"}},
+		},
+		State: "READY",
+	}
+	fileSvc, err := file.NewFakeServiceForMultishare([]*file.MultishareInstance{source, instance(testTargetInstance, "10.0.1.2")}, []*file.Share{share}, nil)
+	if err != nil {
+		t.Fatalf("Failed to create fake file service: %v", err)
+	}
+	cloudProvider, err := cloud.NewFakeCloudWithFiler(fileSvc, testProject, testRegion+"-c")
+	if err != nil {
+		t.Fatalf("Failed to get cloud provider: %v", err)
+	}
+
+	shareInfo := &v1.ShareInfo{
+		ObjectMeta: metav1.ObjectMeta{Name: testMigratedShare, Namespace: util.ManagedFilestoreCSINamespace},
+		Spec: v1.ShareInfoSpec{
+			ShareName:       testMigratedShare,
+			CapacityBytes:   100 * util.Gb,
+			Region:          testRegion,
+			InstancePoolTag: testInstanceScPrefix,
+		},
+		Status: &v1.ShareInfoStatus{
+			InstanceHandle: instanceURI(testProject, testRegion, testSourceInstance),
+			CapacityBytes:  100 * util.Gb,
+			ShareStatus:    v1.READY,
+		},
+	}
+	migration.Namespace = util.ManagedFilestoreCSINamespace
+	objects := []runtime.Object{shareInfo, instanceInfo(testSourceInstance, testMigratedShare), instanceInfo(testTargetInstance), migration}
+	clientset := fsfake.NewSimpleClientset(objects...)
+
+	env := &shareMigrationTestEnv{
+		clientset:  clientset,
+		kubeClient: k8sfake.NewSimpleClientset(),
+		fileSvc:    fileSvc,
+		now:        time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	config := &controllerServerConfig{driver: initTestDriver(t)}
+	config.multiShareController = NewMultishareController(config)
+	env.recon = &MultishareReconciler{
+		clientset:        clientset,
+		kubeClient:       env.kubeClient,
+		cloud:            cloudProvider,
+		controllerServer: &controllerServer{config: config},
+		migrationBackups: newMigrationBackupOps(),
+		now:              func() time.Time { return env.now },
+	}
+	return env
+}
+
+// round runs the share migrations with the state of the fake file service and clientset, and waits for
+// the backup operations it started.
+func (env *shareMigrationTestEnv) round(t *testing.T) *v1.ShareMigration {
+	ctx := context.TODO()
+	instances, err := env.fileSvc.ListMultishareInstances(ctx, &file.ListFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list instances: %v", err)
+	}
+	shares, err := env.fileSvc.ListShares(ctx, &file.ListFilter{})
+	if err != nil {
+		t.Fatalf("Failed to list shares: %v", err)
+	}
+	instanceShares := map[string][]*file.Share{}
+	for _, share := range shares {
+		uri, _ := file.GenerateMultishareInstanceURI(share.Parent)
+		instanceShares[uri] = append(instanceShares[uri], share)
+	}
+	shareInfoList, err := env.clientset.MultishareV1().ShareInfos(util.ManagedFilestoreCSINamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list shareInfos: %v", err)
+	}
+	shareInfos := map[string]*v1.ShareInfo{}
+	for i := range shareInfoList.Items {
+		shareInfos[shareInfoList.Items[i].Name] = &shareInfoList.Items[i]
+	}
+	instanceInfoList, err := env.clientset.MultishareV1().InstanceInfos(util.ManagedFilestoreCSINamespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("Failed to list instanceInfos: %v", err)
+	}
+	instanceInfos := map[string]*v1.InstanceInfo{}
+	for i := range instanceInfoList.Items {
+		instanceInfos[util.InstanceInfoNameToInstanceURI(instanceInfoList.Items[i].Name)] = &instanceInfoList.Items[i]
+	}
+	migration := env.migration(t)
+
+	env.recon.runShareMigrations([]*v1.ShareMigration{migration}, shareInfos, instanceInfos, instances, instanceShares, nil)
+
+	if err := pollUntil(func() bool {
+		env.recon.migrationBackups.mux.Lock()
+		defer env.recon.migrationBackups.mux.Unlock()
+		return len(env.recon.migrationBackups.running) == 0
+	}); err != nil {
+		t.Fatalf("Backup operations didn't finish: %v", err)
+	}
+	return env.migration(t)
+}
+
+func (env *shareMigrationTestEnv) migration(t *testing.T) *v1.ShareMigration {
+	migration, err := env.clientset.MultishareV1().ShareMigrations(util.ManagedFilestoreCSINamespace).Get(context.TODO(), testMigration, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get share migration: %v", err)
+	}
+	return migration
+}
+
+// roundsUntil runs rounds until the migration is in a phase, at most n rounds.
+func (env *shareMigrationTestEnv) roundsUntil(t *testing.T, phase v1.ShareMigrationPhase, n int) *v1.ShareMigration {
+	migration := env.migration(t)
+	for i := 0; i < n && migrationPhase(migration) != phase; i++ {
+		migration = env.round(t)
+	}
+	if migrationPhase(migration) != phase {
+		t.Fatalf("got status %+v after %d rounds, expected phase %s", migration.Status, n, phase)
+	}
+	return migration
+}
+
+func (env *shareMigrationTestEnv) share(t *testing.T, instance string) *file.Share {
+	share, err := env.fileSvc.GetShare(context.TODO(), &file.Share{Name: testMigratedShare, Parent: &file.MultishareInstance{Project: testProject, Location: testRegion, Name: instance}})
+	if err != nil {
+		t.Fatalf("Failed to get share of instance %q: %v", instance, err)
+	}
+	return share
+}
+
+// reportMountedFromSource records the event of a node that still mounts the migrated share from the
+// source instance.
+func (env *shareMigrationTestEnv) reportMountedFromSource(t *testing.T, node string, at time.Time) {
+	event := &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: testMigratedShare + "." + node, Namespace: util.ManagedFilestoreCSINamespace},
+		InvolvedObject: corev1.ObjectReference{Kind: "ShareInfo", Namespace: util.ManagedFilestoreCSINamespace, Name: testMigratedShare},
+		Reason:         eventReasonShareMountedFromSource,
+		Source:         corev1.EventSource{Component: "filestore-csi-node", Host: node},
+		LastTimestamp:  metav1.NewTime(at),
+	}
+	if _, err := env.kubeClient.CoreV1().Events(util.ManagedFilestoreCSINamespace).Create(context.TODO(), event, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create event: %v", err)
+	}
+}
+
+func (env *shareMigrationTestEnv) instanceShareNames(t *testing.T, instance string) []string {
+	name := util.InstanceURIToInstanceInfoName(instanceURI(testProject, testRegion, instance))
+	instanceInfo, err := env.clientset.MultishareV1().InstanceInfos(util.ManagedFilestoreCSINamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get instanceInfo %q: %v", name, err)
+	}
+	return instanceInfo.Status.ShareNames
+}
+
+func TestShareMigration(t *testing.T) {
+	env := initShareMigrationTestEnv(t, &v1.ShareMigration{
+		ObjectMeta: metav1.ObjectMeta{Name: testMigration},
+		Spec:       v1.ShareMigrationSpec{ShareInfoName: testMigratedShare, CutoverGracePeriod: &metav1.Duration{Duration: time.Minute}},
+	})
+	sourceURI := instanceURI(testProject, testRegion, testSourceInstance)
+	targetURI := instanceURI(testProject, testRegion, testTargetInstance)
+
+	migration := env.round(t)
+	if migration.Status == nil || migration.Status.Phase != v1.ShareMigrationBackingUp {
+		t.Fatalf("got status %+v, expected phase %s", migration.Status, v1.ShareMigrationBackingUp)
+	}
+	if migration.Status.SourceInstanceHandle != sourceURI || migration.Status.TargetInstanceHandle != targetURI {
+		t.Errorf("got source %q and target %q, expected %q and %q", migration.Status.SourceInstanceHandle, migration.Status.TargetInstanceHandle, sourceURI, targetURI)
+	}
+	if names := env.instanceShareNames(t, testTargetInstance); !reflect.DeepEqual(names, []string{testMigratedShare}) {
+		t.Errorf("got shares %v of the target instanceInfo, expected the migrated share to be assigned", names)
+	}
+	backupURI, finalBackupURI := migration.Status.BackupHandle, migration.Status.FinalBackupHandle
+	if _, err := env.fileSvc.GetBackup(context.TODO(), backupURI); err != nil {
+		t.Fatalf("Failed to get backup %q: %v", backupURI, err)
+	}
+	if _, err := env.fileSvc.GetBackup(context.TODO(), finalBackupURI); !file.IsNotFoundErr(err) {
+		t.Fatalf("final backup %q was taken before the share is fenced: %v", finalBackupURI, err)
+	}
+
+	// The first backup is ready, the source share is exported read only before the final backup.
+	migration = env.round(t)
+	if migration.Status.Phase != v1.ShareMigrationFencing {
+		t.Fatalf("got phase %s, expected %s", migration.Status.Phase, v1.ShareMigrationFencing)
+	}
+	if source := env.share(t, testSourceInstance); !exportedReadOnly(source.NfsExportOptions) || source.NfsExportOptions[0].IpRanges[0] != "10.0.0.0/8" {
+		t.Errorf("got export options %s of the source share, expected its export options made read only", exportOptionsString(source.NfsExportOptions))
+	}
+
+	// The final backup is taken, the share is restored from it on the target instance, then the shareInfo
+	// is cut over.
+	migration = env.roundsUntil(t, v1.ShareMigrationRestoring, 2)
+	if _, err := env.fileSvc.GetBackup(context.TODO(), finalBackupURI); err != nil {
+		t.Fatalf("Failed to get final backup %q: %v", finalBackupURI, err)
+	}
+	restored := env.share(t, testTargetInstance)
+	if restored.BackupId != finalBackupURI || restored.CapacityBytes != 100*util.Gb || restored.Labels["key"] != "value" {
+		t.Errorf("got restored share %+v, expected a share of the size and labels of the source restored from %q", restored, finalBackupURI)
+	}
+	if len(restored.NfsExportOptions) != 1 || restored.NfsExportOptions[0].AccessMode != "READ_WRITE" {
+		t.Errorf("got export options %s of the restored share, expected the export options of the source before it was fenced", exportOptionsString(restored.NfsExportOptions))
+	}
+
+	migration = env.round(t)
+	if migration.Status.Phase != v1.ShareMigrationCuttingOver || migration.Status.CutoverTime == nil {
+		t.Fatalf("got status %+v, expected phase %s", migration.Status, v1.ShareMigrationCuttingOver)
+	}
+	shareInfo, err := env.clientset.MultishareV1().ShareInfos(util.ManagedFilestoreCSINamespace).Get(context.TODO(), testMigratedShare, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get shareInfo: %v", err)
+	}
+	if shareInfo.Status.InstanceHandle != targetURI || shareInfo.Status.InstanceIP != "10.0.1.2" {
+		t.Errorf("got shareInfo status %+v, expected it to point to the target instance", shareInfo.Status)
+	}
+	if names := env.instanceShareNames(t, testSourceInstance); len(names) != 0 {
+		t.Errorf("got shares %v of the source instanceInfo, expected none", names)
+	}
+
+	// The source share is kept for the cutover grace period.
+	migration = env.round(t)
+	if migration.Status.Phase != v1.ShareMigrationCuttingOver {
+		t.Fatalf("got phase %s before the end of the grace period, expected %s", migration.Status.Phase, v1.ShareMigrationCuttingOver)
+	}
+
+	// A node reported the volume mounted from the source instance before the cutover, and another one
+	// after it. The source share is kept until no node reported it for the grace period.
+	env.reportMountedFromSource(t, "node-1", env.now.Add(-time.Minute))
+	env.now = env.now.Add(90 * time.Second)
+	env.reportMountedFromSource(t, "node-2", env.now)
+	env.now = env.now.Add(30 * time.Second)
+	migration = env.round(t)
+	if migration.Status.Phase != v1.ShareMigrationCuttingOver || !strings.Contains(migration.Status.Error, "node-2") || strings.Contains(migration.Status.Error, "node-1") {
+		t.Fatalf("got status %+v, expected phase %s with an error about node-2", migration.Status, v1.ShareMigrationCuttingOver)
+	}
+	env.share(t, testSourceInstance)
+
+	env.now = env.now.Add(time.Minute)
+	migration = env.roundsUntil(t, v1.ShareMigrationCompleted, 3)
+	if migration.Status.CompletionTime == nil || migration.Status.Error != "" {
+		t.Fatalf("got status %+v, expected phase %s", migration.Status, v1.ShareMigrationCompleted)
+	}
+	if _, err := env.fileSvc.GetShare(context.TODO(), &file.Share{Name: testMigratedShare, Parent: &file.MultishareInstance{Project: testProject, Location: testRegion, Name: testSourceInstance}}); err == nil {
+		t.Errorf("source share was not deleted")
+	}
+	for _, uri := range []string{backupURI, finalBackupURI} {
+		if _, err := env.fileSvc.GetBackup(context.TODO(), uri); !file.IsNotFoundErr(err) {
+			t.Errorf("backup %q was not deleted: %v", uri, err)
+		}
+	}
+}
+
+func TestShareMigrationRollBack(t *testing.T) {
+	env := initShareMigrationTestEnv(t, &v1.ShareMigration{
+		ObjectMeta: metav1.ObjectMeta{Name: testMigration},
+		Spec:       v1.ShareMigrationSpec{ShareInfoName: testMigratedShare},
+	})
+	migration := env.roundsUntil(t, v1.ShareMigrationRestoring, 4)
+
+	// The volume is deleted during the migration.
+	shareInfo, err := env.clientset.MultishareV1().ShareInfos(util.ManagedFilestoreCSINamespace).Get(context.TODO(), testMigratedShare, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get shareInfo: %v", err)
+	}
+	shareInfo.DeletionTimestamp = &metav1.Time{Time: env.now}
+	if _, err := env.clientset.MultishareV1().ShareInfos(util.ManagedFilestoreCSINamespace).Update(context.TODO(), shareInfo, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("Failed to update shareInfo: %v", err)
+	}
+	migration = env.roundsUntil(t, v1.ShareMigrationFailed, 5)
+	if migration.Status.Error == "" {
+		t.Fatalf("got status %+v, expected phase %s with an error", migration.Status, v1.ShareMigrationFailed)
+	}
+	if _, err := env.fileSvc.GetShare(context.TODO(), &file.Share{Name: testMigratedShare, Parent: &file.MultishareInstance{Project: testProject, Location: testRegion, Name: testTargetInstance}}); err == nil {
+		t.Errorf("restored share was not deleted")
+	}
+	if source := env.share(t, testSourceInstance); len(source.NfsExportOptions) != 1 || source.NfsExportOptions[0].AccessMode != "READ_WRITE" {
+		t.Errorf("got export options %s of the source share, expected the export options it had before it was fenced", exportOptionsString(source.NfsExportOptions))
+	}
+	if names := env.instanceShareNames(t, testTargetInstance); len(names) != 0 {
+		t.Errorf("got shares %v of the target instanceInfo, expected none", names)
+	}
+	for _, uri := range []string{migration.Status.BackupHandle, migration.Status.FinalBackupHandle} {
+		if _, err := env.fileSvc.GetBackup(context.TODO(), uri); !file.IsNotFoundErr(err) {
+			t.Errorf("backup %q was not deleted: %v", uri, err)
+		}
+	}
+}
+
+func TestStartShareMigration(t *testing.T) {
+	cases := []struct {
+		name          string
+		target        string
+		expectedPhase v1.ShareMigrationPhase
+	}{
+		{
+			name:          "target picked",
+			expectedPhase: v1.ShareMigrationBackingUp,
+		},
+		{
+			name:          "target set",
+			target:        testTargetInstance,
+			expectedPhase: v1.ShareMigrationBackingUp,
+		},
+		{
+			name:          "target is the source",
+			target:        testSourceInstance,
+			expectedPhase: v1.ShareMigrationFailed,
+		},
+		{
+			name:          "unknown target",
+			target:        "other-instance",
+			expectedPhase: v1.ShareMigrationPending,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			migration := &v1.ShareMigration{
+				ObjectMeta: metav1.ObjectMeta{Name: testMigration},
+				Spec:       v1.ShareMigrationSpec{ShareInfoName: testMigratedShare},
+			}
+			if tc.target != "" {
+				migration.Spec.TargetInstanceHandle = instanceURI(testProject, testRegion, tc.target)
+			}
+			env := initShareMigrationTestEnv(t, migration)
+
+			migration = env.round(t)
+			phase := migrationPhase(migration)
+			if phase != tc.expectedPhase {
+				t.Fatalf("got status %+v, expected phase %s", migration.Status, tc.expectedPhase)
+			}
+			if phase != v1.ShareMigrationBackingUp && (migration.Status == nil || migration.Status.Error == "") {
+				t.Errorf("got status %+v, expected an error", migration.Status)
+			}
+		})
+	}
+}
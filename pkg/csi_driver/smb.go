@@ -0,0 +1,285 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// smbMounter maps the SMB shares of the volumes staged on a Windows node. The volumes are staged and
+// published with symlinks to the mapped shares, which are accessible from all the containers of the node.
+type smbMounter interface {
+	// MapShare maps the share at remotePath with the credentials of a user. Mapping a mapped share is a
+	// no-op.
+	MapShare(remotePath, user, password string) error
+	// UnmapShare removes the mapping of the share at remotePath. Unmapping a share that is not mapped is
+	// a no-op.
+	UnmapShare(remotePath string) error
+}
+
+// powershellSMBMounter maps the shares with the SmbGlobalMapping cmdlets. The user input is passed to
+// PowerShell in environment variables, so that it can't inject commands.
+type powershellSMBMounter struct{}
+
+func (m *powershellSMBMounter) MapShare(remotePath, user, password string) error {
+	if m.isMapped(remotePath) {
+		return nil
+	}
+	cmdLine := `$PWord = ConvertTo-SecureString -String $Env:smbpassword -AsPlainText -Force` +
+		`;$Credential = New-Object -TypeName System.Management.Automation.PSCredential -ArgumentList $Env:smbuser, $PWord` +
+		`;New-SmbGlobalMapping -RemotePath $Env:smbremotepath -Credential $Credential -RequirePrivacy $true`
+	output, err := powershell(cmdLine, "smbuser="+user, "smbpassword="+password, "smbremotepath="+remotePath)
+	if err != nil {
+		return fmt.Errorf("New-SmbGlobalMapping %s failed: %w, output: %q", remotePath, err, output)
+	}
+	return nil
+}
+
+func (m *powershellSMBMounter) UnmapShare(remotePath string) error {
+	if !m.isMapped(remotePath) {
+		return nil
+	}
+	output, err := powershell(`Remove-SmbGlobalMapping -RemotePath $Env:smbremotepath -Force`, "smbremotepath="+remotePath)
+	if err != nil {
+		return fmt.Errorf("Remove-SmbGlobalMapping %s failed: %w, output: %q", remotePath, err, output)
+	}
+	return nil
+}
+
+func (m *powershellSMBMounter) isMapped(remotePath string) bool {
+	_, err := powershell(`Get-SmbGlobalMapping -RemotePath $Env:smbremotepath`, "smbremotepath="+remotePath)
+	return err == nil
+}
+
+func powershell(cmdLine string, env ...string) (string, error) {
+	cmd := exec.Command("powershell", "/c", cmdLine)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+	return string(output), err
+}
+
+// smbRemotePath returns the remote path of the SMB share of an instance a volume is staged from, and the
+// remote path of the volume in the share, which differs for sub-directory volumes.
+func smbRemotePath(volumeID string, attr map[string]string) (share, path string, err error) {
+	if isMultishareVolId(volumeID) {
+		if err := validateMultishareVolumeAttributes(attr); err != nil {
+			return "", "", err
+		}
+		_, _, _, _, shareName, err := parseMultishareVolId(volumeID)
+		if err != nil {
+			return "", "", err
+		}
+		share = fmt.Sprintf(`\\%s\%s`, attr[attrIP], shareName)
+		return share, share, nil
+	}
+	if err := validateSMBVolumeAttributes(attr); err != nil {
+		return "", "", err
+	}
+	share = fmt.Sprintf(`\\%s\%s`, attr[attrIP], attr[attrVolume])
+	if isSubDirectoryVolId(volumeID) {
+		_, subDirectory, err := parseSubDirectoryVolumeID(volumeID)
+		if err != nil {
+			return "", "", err
+		}
+		return share, share + `\` + subDirectory, nil
+	}
+	return share, share, nil
+}
+
+// validateSMBVolumeAttributes checks the volume attributes of an SMB share. Unlike NFS, the server may be
+// named by its hostname, which NTLM and Kerberos authenticate.
+func validateSMBVolumeAttributes(attr map[string]string) error {
+	server := attr[attrIP]
+	if server == "" {
+		return fmt.Errorf("volume attribute key %v not set", attrIP)
+	}
+	if net.ParseIP(server) == nil && len(validation.IsDNS1123Subdomain(strings.ToLower(server))) != 0 {
+		return fmt.Errorf("invalid IP address or hostname %v in volume attributes", server)
+	}
+	if attr[attrVolume] == "" {
+		return fmt.Errorf("volume attribute %v not set", attrVolume)
+	}
+	return nil
+}
+
+// smbShareOf returns the remote path of the share of a remote path in it.
+func smbShareOf(remotePath string) string {
+	parts := strings.SplitN(strings.TrimPrefix(remotePath, `\\`), `\`, 3)
+	if len(parts) < 2 {
+		return remotePath
+	}
+	return `\\` + parts[0] + `\` + parts[1]
+}
+
+// smbLinkTarget returns the path a symlink of a volume links to, with a trailing backslash as EvalSymlinks
+// fails to resolve the root of a share without one on Windows.
+func smbLinkTarget(remotePath string) string {
+	return strings.TrimSuffix(remotePath, `\`) + `\`
+}
+
+// nodeStageSMBVolume stages a volume on a Windows node. The share of the instance is mapped with the
+// credentials of the NodeStageVolume secrets, and the staging path is replaced by a symlink to the
+// volume in the share.
+func (s *nodeServer) nodeStageSMBVolume(req *csi.NodeStageVolumeRequest) (*csi.NodeStageVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	stagingTargetPath := req.GetStagingTargetPath()
+	attr := req.GetVolumeContext()
+	share, remotePath, err := smbRemotePath(volumeID, attr)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	for _, key := range []string{attrEncryptionInTransit, attrSecurityFlavor} {
+		if _, ok := attr[key]; ok {
+			return nil, status.Errorf(codes.InvalidArgument, "volume attribute %s is not supported on Windows", key)
+		}
+	}
+	secrets := req.GetSecrets()
+	if err := validateSmbNodeStageSecrets(secrets); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if acquired := s.volumeLocks.TryAcquire(volumeID); !acquired {
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer s.volumeLocks.Release(volumeID)
+
+	target := smbLinkTarget(remotePath)
+	if linked, err := os.Readlink(stagingTargetPath); err == nil {
+		if linked != target {
+			return nil, status.Errorf(codes.AlreadyExists, "staging target path %s of volume %v links to %s", stagingTargetPath, volumeID, linked)
+		}
+		s.stagedVolumes.stage(volumeID, stagingTargetPath)
+		klog.V(4).Infof("NodeStageVolume succeeded on volume %v to staging target path %s on node %s, share already linked.", volumeID, stagingTargetPath, s.driver.config.NodeName)
+		return &csi.NodeStageVolumeResponse{}, nil
+	}
+
+	if err := s.smb.MapShare(share, secrets[optionSmbUser], secrets[optionSmbPassword]); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to map the share of volume %v on node %s: %v", volumeID, s.driver.config.NodeName, err)
+	}
+	if err := linkSMBPath(target, stagingTargetPath); err != nil {
+		klog.Errorf("Linking %q failed on node %s, cleaning up", stagingTargetPath, s.driver.config.NodeName)
+		if unmapErr := s.unmapUnusedShare(share, stagingTargetPath); unmapErr != nil {
+			klog.Errorf("Unmapping share %s failed on node %s: %v", share, s.driver.config.NodeName, unmapErr)
+		}
+		return nil, status.Errorf(codes.Internal, "failed to link staging target path %s of volume %v: %v", stagingTargetPath, volumeID, err)
+	}
+
+	s.stagedVolumes.stage(volumeID, stagingTargetPath)
+	klog.V(4).Infof("NodeStageVolume succeeded on volume %v to path %s on node %s", volumeID, stagingTargetPath, s.driver.config.NodeName)
+	return &csi.NodeStageVolumeResponse{}, nil
+}
+
+// unstageSMBVolume removes the symlink of a volume staged on a Windows node, and the mapping of its share
+// unless another volume staged on the node is in the same share.
+func (s *nodeServer) unstageSMBVolume(stagingTargetPath string) error {
+	linked, linkErr := os.Readlink(stagingTargetPath)
+	if err := removeSMBLink(stagingTargetPath); err != nil {
+		return err
+	}
+	s.stagedVolumes.unstage(stagingTargetPath)
+	if linkErr != nil {
+		// The volume is not staged, or its share was never linked.
+		return nil
+	}
+	return s.unmapUnusedShare(smbShareOf(strings.TrimSuffix(linked, `\`)), stagingTargetPath)
+}
+
+// unmapUnusedShare unmaps a share unless a volume staged next to stagingTargetPath links to it. The volumes
+// staged on a node are looked up from the staging paths rather than kept in memory, so that the shares in
+// use are known after the driver restarts.
+func (s *nodeServer) unmapUnusedShare(share, stagingTargetPath string) error {
+	siblings, err := filepath.Glob(filepath.Join(filepath.Dir(filepath.Dir(stagingTargetPath)), "*", filepath.Base(stagingTargetPath)))
+	if err != nil {
+		return err
+	}
+	for _, sibling := range siblings {
+		if sibling == stagingTargetPath {
+			continue
+		}
+		linked, err := os.Readlink(sibling)
+		if err != nil {
+			continue
+		}
+		if strings.EqualFold(smbShareOf(strings.TrimSuffix(linked, `\`)), share) {
+			klog.V(4).Infof("Share %s is still used by the volume staged at %s, not unmapping it", share, sibling)
+			return nil
+		}
+	}
+	return s.smb.UnmapShare(share)
+}
+
+// nodePublishSMBVolume publishes a volume staged on a Windows node with a symlink to its staging path.
+// The share is mapped read-write, the read-only volumes are not enforced by the node.
+func (s *nodeServer) nodePublishSMBVolume(req *csi.NodePublishVolumeRequest) (*csi.NodePublishVolumeResponse, error) {
+	// The publish path links to the share mapped with the credentials of the volume, which can't be made
+	// read-only for a single pod.
+	if req.GetReadonly() {
+		return nil, status.Errorf(codes.InvalidArgument, "volume %v can't be published read-only on Windows node %s", req.GetVolumeId(), s.driver.config.NodeName)
+	}
+	targetPath := req.GetTargetPath()
+	stagingTargetPath := req.GetStagingTargetPath()
+	if _, err := os.Lstat(stagingTargetPath); err != nil {
+		if os.IsNotExist(err) {
+			return nil, status.Errorf(codes.FailedPrecondition, "volume %v is not staged at %s", req.GetVolumeId(), stagingTargetPath)
+		}
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if linked, err := os.Readlink(targetPath); err == nil {
+		if linked != stagingTargetPath {
+			return nil, status.Errorf(codes.AlreadyExists, "target path %s of volume %v links to %s", targetPath, req.GetVolumeId(), linked)
+		}
+		return &csi.NodePublishVolumeResponse{}, nil
+	}
+	if err := linkSMBPath(stagingTargetPath, targetPath); err != nil {
+		return nil, status.Errorf(codes.Internal, "failed to link target path %s of volume %v: %v", targetPath, req.GetVolumeId(), err)
+	}
+	klog.V(4).Infof("Successfully linked %s on node %s", targetPath, s.driver.config.NodeName)
+	return &csi.NodePublishVolumeResponse{}, nil
+}
+
+// linkSMBPath replaces the path of a volume by a symlink to target. The kubelet may create the path as an
+// empty directory, which is removed (kubernetes/kubernetes#75535).
+func linkSMBPath(target, path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		return err
+	}
+	return os.Symlink(target, path)
+}
+
+// removeSMBLink removes the symlink of a staged or published volume, or the empty directory left by the
+// kubelet if it was never linked.
+func removeSMBLink(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", path, err)
+	}
+	return nil
+}
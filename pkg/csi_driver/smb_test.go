@@ -0,0 +1,357 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testSMBShare              = `\\1.1.1.1\test-volume`
+	testSMBSubDirectoryVolume = "modeSubdirectory/us-central1-c/shared/test-volume/pvc-1"
+)
+
+var testSMBSecrets = map[string]string{
+	optionSmbUser:     "foo",
+	optionSmbPassword: "bar",
+}
+
+// fakeSMBMounter records the shares mapped on a fake Windows node, and the users they are mapped with.
+type fakeSMBMounter struct {
+	mapped map[string]string
+	mapErr error
+}
+
+func (m *fakeSMBMounter) MapShare(remotePath, user, password string) error {
+	if m.mapErr != nil {
+		return m.mapErr
+	}
+	m.mapped[remotePath] = user
+	return nil
+}
+
+func (m *fakeSMBMounter) UnmapShare(remotePath string) error {
+	delete(m.mapped, remotePath)
+	return nil
+}
+
+// initTestSMBNodeServer returns a node server of a Windows node mapping the shares with a fake mounter.
+func initTestSMBNodeServer(t *testing.T) (*nodeServer, *fakeSMBMounter) {
+	defaultOsString := goOs
+	goOs = "windows"
+	t.Cleanup(func() { goOs = defaultOsString })
+
+	ns := initTestNodeServer(t).ns.(*nodeServer)
+	smb := &fakeSMBMounter{mapped: map[string]string{}}
+	ns.smb = smb
+	return ns, smb
+}
+
+// kubeletStagingPath returns a staging path of a volume laid out like the kubelet does, as an empty
+// directory next to the staging paths of the other volumes.
+func kubeletStagingPath(t *testing.T, base, volume string) string {
+	path := filepath.Join(base, volume, "globalmount")
+	if err := os.MkdirAll(path, 0750); err != nil {
+		t.Fatalf("Failed to create staging path: %v", err)
+	}
+	return path
+}
+
+func validateSMBLink(t *testing.T, path, expected string) {
+	t.Helper()
+	linked, err := os.Readlink(path)
+	if err != nil {
+		t.Fatalf("Failed to read the link of %s: %v", path, err)
+	}
+	if linked != expected {
+		t.Errorf("got %s linked to %s, expected %s", path, linked, expected)
+	}
+}
+
+func TestNodeSMBVolume(t *testing.T) {
+	ns, smb := initTestSMBNodeServer(t)
+	base := t.TempDir()
+	stagingPath := kubeletStagingPath(t, base, "pv-instance")
+	subDirectoryStagingPath := kubeletStagingPath(t, base, "pv-subdirectory")
+
+	stageReq := &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     testVolumeAttributes,
+		Secrets:           testSMBSecrets,
+	}
+	if _, err := ns.NodeStageVolume(context.Background(), stageReq); err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	validateSMBLink(t, stagingPath, testSMBShare+`\`)
+	if expected := map[string]string{testSMBShare: "foo"}; !reflect.DeepEqual(smb.mapped, expected) {
+		t.Errorf("got mapped shares %v, expected %v", smb.mapped, expected)
+	}
+
+	// Staging the volume again is a no-op.
+	if _, err := ns.NodeStageVolume(context.Background(), stageReq); err != nil {
+		t.Fatalf("NodeStageVolume failed again: %v", err)
+	}
+	validateSMBLink(t, stagingPath, testSMBShare+`\`)
+
+	// A sub-directory volume links to its directory in the share.
+	if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testSMBSubDirectoryVolume,
+		StagingTargetPath: subDirectoryStagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     testVolumeAttributes,
+		Secrets:           testSMBSecrets,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume of sub-directory volume failed: %v", err)
+	}
+	validateSMBLink(t, subDirectoryStagingPath, testSMBShare+`\pvc-1\`)
+
+	// The volume is published with a link to its staging path, replacing the directory of the kubelet.
+	targetPath := filepath.Join(t.TempDir(), "mount")
+	if err := os.MkdirAll(targetPath, 0750); err != nil {
+		t.Fatalf("Failed to create target path: %v", err)
+	}
+	publishReq := &csi.NodePublishVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     testVolumeAttributes,
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := ns.NodePublishVolume(context.Background(), publishReq); err != nil {
+			t.Fatalf("NodePublishVolume %d failed: %v", i, err)
+		}
+		validateSMBLink(t, targetPath, stagingPath)
+	}
+
+	if _, err := ns.NodeUnpublishVolume(context.Background(), &csi.NodeUnpublishVolumeRequest{VolumeId: testVolumeID, TargetPath: targetPath}); err != nil {
+		t.Fatalf("NodeUnpublishVolume failed: %v", err)
+	}
+	if _, err := os.Lstat(targetPath); !os.IsNotExist(err) {
+		t.Errorf("target path of unpublished volume was not removed: %v", err)
+	}
+
+	// The share is kept mapped while the sub-directory volume is staged.
+	if _, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume failed: %v", err)
+	}
+	if _, err := os.Lstat(stagingPath); !os.IsNotExist(err) {
+		t.Errorf("staging path of unstaged volume was not removed: %v", err)
+	}
+	if _, ok := smb.mapped[testSMBShare]; !ok {
+		t.Errorf("share %s used by the sub-directory volume was unmapped", testSMBShare)
+	}
+
+	if _, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testSMBSubDirectoryVolume, StagingTargetPath: subDirectoryStagingPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume of sub-directory volume failed: %v", err)
+	}
+	if len(smb.mapped) != 0 {
+		t.Errorf("got mapped shares %v after unstaging all the volumes, expected none", smb.mapped)
+	}
+
+	// Unstaging the volume again is a no-op.
+	if _, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume failed again: %v", err)
+	}
+}
+
+func TestNodeStageSMBVolumeErrors(t *testing.T) {
+	cases := []struct {
+		name       string
+		volumeID   string
+		attributes map[string]string
+		secrets    map[string]string
+		mapErr     error
+		expected   codes.Code
+	}{
+		{
+			name:       "no user",
+			attributes: testVolumeAttributes,
+			secrets:    map[string]string{optionSmbPassword: "bar"},
+			expected:   codes.InvalidArgument,
+		},
+		{
+			name:       "no password",
+			attributes: testVolumeAttributes,
+			secrets:    map[string]string{optionSmbUser: "foo"},
+			expected:   codes.InvalidArgument,
+		},
+		{
+			name: "invalid server",
+			attributes: map[string]string{
+				attrIP:     `filer\share`,
+				attrVolume: "test-volume",
+			},
+			secrets:  testSMBSecrets,
+			expected: codes.InvalidArgument,
+		},
+		{
+			name:       "missing share",
+			attributes: map[string]string{attrIP: "1.1.1.1"},
+			secrets:    testSMBSecrets,
+			expected:   codes.InvalidArgument,
+		},
+		{
+			name: "encryption in transit",
+			attributes: map[string]string{
+				attrIP:                  "1.1.1.1",
+				attrVolume:              "test-volume",
+				attrEncryptionInTransit: "true",
+			},
+			secrets:  testSMBSecrets,
+			expected: codes.InvalidArgument,
+		},
+		{
+			name: "kerberos",
+			attributes: map[string]string{
+				attrIP:             "1.1.1.1",
+				attrVolume:         "test-volume",
+				attrSecurityFlavor: "krb5",
+			},
+			secrets:  testSMBSecrets,
+			expected: codes.InvalidArgument,
+		},
+		{
+			name:       "mapping failed",
+			attributes: testVolumeAttributes,
+			secrets:    testSMBSecrets,
+			mapErr:     fmt.Errorf("access denied"),
+			expected:   codes.Internal,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ns, smb := initTestSMBNodeServer(t)
+			smb.mapErr = tc.mapErr
+			stagingPath := kubeletStagingPath(t, t.TempDir(), "pv")
+			_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+				VolumeId:          testVolumeID,
+				StagingTargetPath: stagingPath,
+				VolumeCapability:  testVolumeCapability,
+				VolumeContext:     tc.attributes,
+				Secrets:           tc.secrets,
+			})
+			if status.Code(err) != tc.expected {
+				t.Errorf("got error %v, expected code %v", err, tc.expected)
+			}
+			if len(smb.mapped) != 0 {
+				t.Errorf("got mapped shares %v, expected none", smb.mapped)
+			}
+			if info, err := os.Lstat(stagingPath); err != nil || !info.IsDir() {
+				t.Errorf("staging path was changed: %v", err)
+			}
+		})
+	}
+}
+
+func TestNodePublishSMBVolumeNotStaged(t *testing.T) {
+	ns, _ := initTestSMBNodeServer(t)
+	_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: filepath.Join(t.TempDir(), "globalmount"),
+		TargetPath:        filepath.Join(t.TempDir(), "mount"),
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     testVolumeAttributes,
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v, expected FailedPrecondition", err)
+	}
+}
+
+func TestNodePublishSMBVolumeReadOnly(t *testing.T) {
+	ns, _ := initTestSMBNodeServer(t)
+	stagingPath := filepath.Join(t.TempDir(), "globalmount")
+	if err := os.MkdirAll(stagingPath, 0750); err != nil {
+		t.Fatalf("Failed to create staging path: %v", err)
+	}
+	targetPath := filepath.Join(t.TempDir(), "mount")
+	_, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     testVolumeAttributes,
+		Readonly:          true,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v, expected InvalidArgument", err)
+	}
+	if _, err := os.Lstat(targetPath); !os.IsNotExist(err) {
+		t.Errorf("target path of read-only volume was linked: %v", err)
+	}
+}
+
+func TestSMBRemotePath(t *testing.T) {
+	cases := []struct {
+		volumeID      string
+		attributes    map[string]string
+		expectedShare string
+		expectedPath  string
+	}{
+		{
+			volumeID:      testVolumeID,
+			attributes:    testVolumeAttributes,
+			expectedShare: testSMBShare,
+			expectedPath:  testSMBShare,
+		},
+		{
+			volumeID:      testSMBSubDirectoryVolume,
+			attributes:    testVolumeAttributes,
+			expectedShare: testSMBShare,
+			expectedPath:  testSMBShare + `\pvc-1`,
+		},
+		{
+			volumeID: testVolumeID,
+			attributes: map[string]string{
+				attrIP:     "FILER-1",
+				attrVolume: "SMBShare",
+			},
+			expectedShare: `\\FILER-1\SMBShare`,
+			expectedPath:  `\\FILER-1\SMBShare`,
+		},
+		{
+			volumeID:      "modeMultishare/test-prefix/test-project/us-central1/test-instance/test-share",
+			attributes:    testMultishareVolumeAttributes,
+			expectedShare: `\\1.1.1.1\test-share`,
+			expectedPath:  `\\1.1.1.1\test-share`,
+		},
+	}
+	for _, tc := range cases {
+		share, path, err := smbRemotePath(tc.volumeID, tc.attributes)
+		if err != nil {
+			t.Errorf("volume %s: unexpected error: %v", tc.volumeID, err)
+			continue
+		}
+		if share != tc.expectedShare || path != tc.expectedPath {
+			t.Errorf("volume %s: got share %s and path %s, expected %s and %s", tc.volumeID, share, path, tc.expectedShare, tc.expectedPath)
+		}
+		if got := smbShareOf(path); got != share {
+			t.Errorf("volume %s: got share %s of %s, expected %s", tc.volumeID, got, path, share)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"sync"
+)
+
+// stagedVolumes tracks the staging paths of the volumes staged on the node, to map the NFS client
+// statistics of their mounts back to the volumes. Its methods are no-ops on a nil tracker, when the NFS
+// mount statistics metrics are disabled.
+type stagedVolumes struct {
+	mux sync.Mutex
+	// volumeIDs are the volume IDs by staging path.
+	volumeIDs map[string]string
+}
+
+func newStagedVolumes() *stagedVolumes {
+	return &stagedVolumes{volumeIDs: map[string]string{}}
+}
+
+func (s *stagedVolumes) stage(volumeID, stagingPath string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	s.volumeIDs[stagingPath] = volumeID
+}
+
+func (s *stagedVolumes) unstage(stagingPath string) {
+	if s == nil {
+		return
+	}
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	delete(s.volumeIDs, stagingPath)
+}
+
+// volumes returns a copy of the volume IDs by staging path. It implements metrics.StagedVolumesFunc.
+func (s *stagedVolumes) volumes() map[string]string {
+	s.mux.Lock()
+	defer s.mux.Unlock()
+	volumeIDs := make(map[string]string, len(s.volumeIDs))
+	for path, volumeID := range s.volumeIDs {
+		volumeIDs[path] = volumeID
+	}
+	return volumeIDs
+}
@@ -0,0 +1,75 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/metrics"
+)
+
+func TestStagedVolumes(t *testing.T) {
+	metaService, err := metadata.NewFakeService()
+	if err != nil {
+		t.Fatalf("Failed to init metadata service")
+	}
+	driver := initTestDriver(t)
+	driver.config.Metrics = metrics.NewMetricsManager()
+	server, err := newNodeServer(driver, &mount.FakeMounter{MountPoints: []mount.MountPoint{}}, metaService, &GCFSDriverFeatureOptions{
+		FeatureLockRelease:          &FeatureLockRelease{},
+		FeatureNFSMountStatsMetrics: &FeatureNFSMountStatsMetrics{Enabled: true},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create node server: %v", err)
+	}
+	ns := server.(*nodeServer)
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+
+	// Staging twice, as kubelet does after a restart, keeps a single entry.
+	for i := 0; i < 2; i++ {
+		if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+			VolumeId:          testVolumeID,
+			StagingTargetPath: stagingPath,
+			VolumeCapability:  testVolumeCapability,
+			VolumeContext:     testVolumeAttributes,
+		}); err != nil {
+			t.Fatalf("Failed to stage volume: %v", err)
+		}
+	}
+	expected := map[string]string{stagingPath: testVolumeID}
+	if got := ns.stagedVolumes.volumes(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("got staged volumes %v, expected %v", got, expected)
+	}
+
+	if _, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("Failed to unstage volume: %v", err)
+	}
+	if got := ns.stagedVolumes.volumes(); len(got) != 0 {
+		t.Errorf("got staged volumes %v after unstage", got)
+	}
+
+	// The tracker is disabled without the feature.
+	var disabled *stagedVolumes
+	disabled.stage(testVolumeID, stagingPath)
+	disabled.unstage(stagingPath)
+}
@@ -0,0 +1,258 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	eventReasonShareOperationFailed    = "FilestoreShareOperationFailed"
+	eventReasonInstanceOperationFailed = "FilestoreInstanceOperationFailed"
+)
+
+// Reasons of the conditions of the status of shareInfos and instanceInfos.
+const (
+	conditionReasonReady           = "Ready"
+	conditionReasonPending         = "Pending"
+	conditionReasonCreating        = "Creating"
+	conditionReasonUpdating        = "Updating"
+	conditionReasonDeleting        = "Deleting"
+	conditionReasonDeleted         = "Deleted"
+	conditionReasonProvisioned     = "Provisioned"
+	conditionReasonResizing        = "Resizing"
+	conditionReasonNotResizing     = "NotResizing"
+	conditionReasonOperationFailed = "OperationFailed"
+	conditionReasonNoError         = "NoError"
+)
+
+// setFilestoreConditions sets the conditions of a share or an instance, of the given kind, from its state,
+// the capacity of its spec and status, and the error of its last operation. It returns true if the
+// conditions changed.
+func setFilestoreConditions(conditions *[]metav1.Condition, kind string, generation int64, state v1.FilestoreStatus, deleting bool, specBytes, statusBytes int64, errMsg string, now time.Time) bool {
+	condition := func(conditionType string, status bool, reason, message string) metav1.Condition {
+		c := metav1.Condition{
+			Type:               conditionType,
+			Status:             metav1.ConditionFalse,
+			ObservedGeneration: generation,
+			LastTransitionTime: metav1.NewTime(now),
+			Reason:             reason,
+			Message:            message,
+		}
+		if status {
+			c.Status = metav1.ConditionTrue
+		}
+		return c
+	}
+
+	var stateReason string
+	switch {
+	case state == v1.DELETED:
+		stateReason = conditionReasonDeleted
+	case deleting:
+		stateReason = conditionReasonDeleting
+	case state == v1.READY:
+		stateReason = conditionReasonReady
+	case state == v1.CREATING:
+		stateReason = conditionReasonCreating
+	case state == v1.UPDATING:
+		stateReason = conditionReasonUpdating
+	default:
+		stateReason = conditionReasonPending
+	}
+
+	ready := condition(v1.ConditionReady, state == v1.READY, stateReason, fmt.Sprintf("The %s is %s", kind, state))
+	if state == "" {
+		ready.Message = fmt.Sprintf("The %s is not created yet", kind)
+	}
+
+	provisioning := condition(v1.ConditionProvisioning, false, conditionReasonProvisioned, "")
+	if stateReason == conditionReasonPending || stateReason == conditionReasonCreating {
+		provisioning = condition(v1.ConditionProvisioning, true, stateReason, fmt.Sprintf("The %s is being created", kind))
+	} else if deleting || state == v1.DELETED {
+		provisioning.Reason = stateReason
+	}
+
+	resizing := condition(v1.ConditionResizing, false, conditionReasonNotResizing, "")
+	if !deleting && statusBytes != 0 && specBytes != statusBytes && (state == v1.READY || state == v1.UPDATING) {
+		resizing = condition(v1.ConditionResizing, true, conditionReasonResizing, fmt.Sprintf("The %s is resized from %d to %d bytes", kind, statusBytes, specBytes))
+	}
+
+	degraded := condition(v1.ConditionDegraded, false, conditionReasonNoError, "")
+	if errMsg != "" {
+		degraded = condition(v1.ConditionDegraded, true, conditionReasonOperationFailed, errMsg)
+	}
+
+	changed := false
+	for _, c := range []metav1.Condition{ready, provisioning, resizing, degraded} {
+		changed = meta.SetStatusCondition(conditions, c) || changed
+	}
+	return changed
+}
+
+// completeLastOperation sets the completion time of the last operation of a share or an instance once it
+// is in the state the operation leads to. It returns true if the operation completed.
+func completeLastOperation(op *v1.LastOperation, state v1.FilestoreStatus, atSpecCapacity bool, now time.Time) bool {
+	if op == nil || op.CompletionTime != nil {
+		return false
+	}
+	var completed bool
+	switch op.Type {
+	case util.OpVerbCreate:
+		completed = state == v1.READY
+	case util.OpVerbUpdate:
+		completed = state == v1.READY && atSpecCapacity
+	case util.OpVerbDelete:
+		completed = state == v1.DELETED
+	}
+	if completed {
+		op.CompletionTime = &metav1.Time{Time: now}
+	}
+	return completed
+}
+
+// operationVerb returns the verb of the last operation of an operation type.
+func operationVerb(opType util.OperationType) string {
+	switch opType {
+	case util.InstanceCreate, util.ShareCreate:
+		return util.OpVerbCreate
+	case util.InstanceDelete, util.ShareDelete:
+		return util.OpVerbDelete
+	case util.InstanceUpdate, util.ShareUpdate:
+		return util.OpVerbUpdate
+	default:
+		return ""
+	}
+}
+
+// refreshShareInfoStatus sets the observed generation and the conditions of status, the new status of
+// shareInfo, and completes its last operation. It returns true if status changed.
+func (recon *MultishareReconciler) refreshShareInfoStatus(shareInfo *v1.ShareInfo, status *v1.ShareInfoStatus) bool {
+	changed := status.ObservedGeneration != shareInfo.Generation
+	status.ObservedGeneration = shareInfo.Generation
+	now := recon.now()
+	atSpecCapacity := status.CapacityBytes == shareInfo.Spec.CapacityBytes
+	changed = completeLastOperation(status.LastOperation, status.ShareStatus, atSpecCapacity, now) || changed
+	return setFilestoreConditions(&status.Conditions, "share", shareInfo.Generation, status.ShareStatus, shareInfo.DeletionTimestamp != nil,
+		shareInfo.Spec.CapacityBytes, status.CapacityBytes, status.Error, now) || changed
+}
+
+// refreshInstanceInfoStatus sets the observed generation and the conditions of status, the new status of
+// instanceInfo, and completes its last operation. It returns true if status changed.
+func (recon *MultishareReconciler) refreshInstanceInfoStatus(instanceInfo *v1.InstanceInfo, status *v1.InstanceInfoStatus) bool {
+	changed := status.ObservedGeneration != instanceInfo.Generation
+	status.ObservedGeneration = instanceInfo.Generation
+	now := recon.now()
+	atSpecCapacity := status.CapacityBytes == instanceInfo.Spec.CapacityBytes
+	changed = completeLastOperation(status.LastOperation, status.InstanceStatus, atSpecCapacity, now) || changed
+	return setFilestoreConditions(&status.Conditions, "instance", instanceInfo.Generation, status.InstanceStatus, instanceInfo.DeletionTimestamp != nil,
+		instanceInfo.Spec.CapacityBytes, status.CapacityBytes, status.Error, now) || changed
+}
+
+// updateShareInfoLastOperation records the operation started on the share of shareInfo in its status. It
+// returns the updated shareInfo, or shareInfo if it is unchanged or the update fails.
+func (recon *MultishareReconciler) updateShareInfoLastOperation(shareInfo *v1.ShareInfo, name, verb string) *v1.ShareInfo {
+	if shareInfo.Status != nil && shareInfo.Status.LastOperation != nil && shareInfo.Status.LastOperation.Name == name {
+		return shareInfo
+	}
+	shareInfoClone := shareInfo.DeepCopy()
+	if shareInfoClone.Status == nil {
+		shareInfoClone.Status = &v1.ShareInfoStatus{}
+	}
+	shareInfoClone.Status.LastOperation = &v1.LastOperation{Name: name, Type: verb, StartTime: metav1.NewTime(recon.now())}
+	recon.refreshShareInfoStatus(shareInfoClone, shareInfoClone.Status)
+	updated, err := recon.updateShareInfoStatus(context.TODO(), shareInfoClone)
+	if err != nil {
+		klog.Errorf("failed to record operation %s of shareInfo %s: %v", name, shareInfo.Name, err)
+		return shareInfo
+	}
+	return updated
+}
+
+// updateInstanceInfoLastOperation records the operation started on the instance of instanceInfo in its
+// status. It returns the updated instanceInfo, or instanceInfo if it is unchanged or the update fails.
+func (recon *MultishareReconciler) updateInstanceInfoLastOperation(instanceInfo *v1.InstanceInfo, name, verb string) *v1.InstanceInfo {
+	if instanceInfo.Status != nil && instanceInfo.Status.LastOperation != nil && instanceInfo.Status.LastOperation.Name == name {
+		return instanceInfo
+	}
+	instanceInfoClone := instanceInfo.DeepCopy()
+	if instanceInfoClone.Status == nil {
+		instanceInfoClone.Status = &v1.InstanceInfoStatus{}
+	}
+	instanceInfoClone.Status.LastOperation = &v1.LastOperation{Name: name, Type: verb, StartTime: metav1.NewTime(recon.now())}
+	recon.refreshInstanceInfoStatus(instanceInfoClone, instanceInfoClone.Status)
+	updated, err := recon.updateInstanceInfoStatus(context.TODO(), instanceInfoClone)
+	if err != nil {
+		klog.Errorf("failed to record operation %s of instanceInfo %s: %v", name, instanceInfo.Name, err)
+		return instanceInfo
+	}
+	return updated
+}
+
+// volumeEventObjects returns the PV of the volume of a shareInfo and its PVC, the objects the events of
+// the share are recorded on for kubectl describe. Until the PV is created, the PVC is found from the
+// parameters of the shareInfo. The returned objects are shared with the informer cache.
+func (recon *MultishareReconciler) volumeEventObjects(shareInfo *v1.ShareInfo) []runtime.Object {
+	if recon.pvLister == nil || recon.pvcLister == nil {
+		return nil
+	}
+	pv, err := recon.pvLister.Get(shareInfo.Name)
+	if err == nil {
+		objects := []runtime.Object{pv}
+		if pv.Spec.ClaimRef != nil {
+			objects = append(objects, pv.Spec.ClaimRef)
+		}
+		return objects
+	}
+	if !errors.IsNotFound(err) {
+		klog.Warningf("Failed to get the PV of shareInfo %s: %v", shareInfo.Name, err)
+		return nil
+	}
+	pvcName, pvcNamespace := shareInfo.Spec.Parameters[ParameterKeyPVCName], shareInfo.Spec.Parameters[ParameterKeyPVCNamespace]
+	if pvcName == "" || pvcNamespace == "" {
+		return nil
+	}
+	pvc, err := recon.pvcLister.PersistentVolumeClaims(pvcNamespace).Get(pvcName)
+	if err != nil {
+		klog.Warningf("Failed to get the PVC of shareInfo %s: %v", shareInfo.Name, err)
+		return nil
+	}
+	return []runtime.Object{pvc}
+}
+
+// shareOperationFailedEvent records the error of an operation on the share of shareInfo on the shareInfo,
+// and on the PV and PVC of its volume.
+func (recon *MultishareReconciler) shareOperationFailedEvent(shareInfo *v1.ShareInfo, errMsg string) {
+	if recon.recorder == nil {
+		return
+	}
+	recon.event(shareInfo, corev1.EventTypeWarning, eventReasonShareOperationFailed, "Filestore share %s: %s", shareInfo.Spec.ShareName, errMsg)
+	for _, obj := range recon.volumeEventObjects(shareInfo) {
+		recon.event(obj, corev1.EventTypeWarning, eventReasonShareOperationFailed, "Filestore share %s: %s", shareInfo.Spec.ShareName, errMsg)
+	}
+}
@@ -0,0 +1,421 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	coreListers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	fsfake "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestSetFilestoreConditions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name        string
+		state       v1.FilestoreStatus
+		deleting    bool
+		specBytes   int64
+		statusBytes int64
+		err         string
+		// expected maps the condition types to their expected status and reason.
+		expected map[string][2]string
+	}{
+		{
+			name:      "not created",
+			specBytes: 100 * util.Gb,
+			expected: map[string][2]string{
+				v1.ConditionReady:        {"False", conditionReasonPending},
+				v1.ConditionProvisioning: {"True", conditionReasonPending},
+				v1.ConditionResizing:     {"False", conditionReasonNotResizing},
+				v1.ConditionDegraded:     {"False", conditionReasonNoError},
+			},
+		},
+		{
+			name:      "creation failed",
+			state:     v1.CREATING,
+			specBytes: 100 * util.Gb,
+			err:       "quota exceeded",
+			expected: map[string][2]string{
+				v1.ConditionReady:        {"False", conditionReasonCreating},
+				v1.ConditionProvisioning: {"True", conditionReasonCreating},
+				v1.ConditionResizing:     {"False", conditionReasonNotResizing},
+				v1.ConditionDegraded:     {"True", conditionReasonOperationFailed},
+			},
+		},
+		{
+			name:        "ready",
+			state:       v1.READY,
+			specBytes:   100 * util.Gb,
+			statusBytes: 100 * util.Gb,
+			expected: map[string][2]string{
+				v1.ConditionReady:        {"True", conditionReasonReady},
+				v1.ConditionProvisioning: {"False", conditionReasonProvisioned},
+				v1.ConditionResizing:     {"False", conditionReasonNotResizing},
+				v1.ConditionDegraded:     {"False", conditionReasonNoError},
+			},
+		},
+		{
+			name:        "resizing",
+			state:       v1.READY,
+			specBytes:   200 * util.Gb,
+			statusBytes: 100 * util.Gb,
+			expected: map[string][2]string{
+				v1.ConditionReady:        {"True", conditionReasonReady},
+				v1.ConditionProvisioning: {"False", conditionReasonProvisioned},
+				v1.ConditionResizing:     {"True", conditionReasonResizing},
+				v1.ConditionDegraded:     {"False", conditionReasonNoError},
+			},
+		},
+		{
+			name:        "deleting",
+			state:       v1.READY,
+			deleting:    true,
+			specBytes:   200 * util.Gb,
+			statusBytes: 100 * util.Gb,
+			expected: map[string][2]string{
+				v1.ConditionReady:        {"True", conditionReasonDeleting},
+				v1.ConditionProvisioning: {"False", conditionReasonDeleting},
+				v1.ConditionResizing:     {"False", conditionReasonNotResizing},
+				v1.ConditionDegraded:     {"False", conditionReasonNoError},
+			},
+		},
+		{
+			name:        "deleted",
+			state:       v1.DELETED,
+			deleting:    true,
+			specBytes:   100 * util.Gb,
+			statusBytes: 100 * util.Gb,
+			expected: map[string][2]string{
+				v1.ConditionReady:        {"False", conditionReasonDeleted},
+				v1.ConditionProvisioning: {"False", conditionReasonDeleted},
+				v1.ConditionResizing:     {"False", conditionReasonNotResizing},
+				v1.ConditionDegraded:     {"False", conditionReasonNoError},
+			},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var conditions []metav1.Condition
+			if !setFilestoreConditions(&conditions, "share", 3, tc.state, tc.deleting, tc.specBytes, tc.statusBytes, tc.err, now) {
+				t.Errorf("conditions are not changed")
+			}
+			if len(conditions) != len(tc.expected) {
+				t.Errorf("got %d conditions, expected %d", len(conditions), len(tc.expected))
+			}
+			for conditionType, expected := range tc.expected {
+				c := meta.FindStatusCondition(conditions, conditionType)
+				if c == nil {
+					t.Errorf("condition %s is missing", conditionType)
+					continue
+				}
+				if string(c.Status) != expected[0] || c.Reason != expected[1] || c.ObservedGeneration != 3 || !c.LastTransitionTime.Time.Equal(now) {
+					t.Errorf("got condition %+v, expected status %s and reason %s", c, expected[0], expected[1])
+				}
+			}
+
+			// The conditions are stable, their transition times are kept.
+			if setFilestoreConditions(&conditions, "share", 3, tc.state, tc.deleting, tc.specBytes, tc.statusBytes, tc.err, now.Add(time.Hour)) {
+				t.Errorf("conditions changed without a change of the share")
+			}
+		})
+	}
+}
+
+func TestCompleteLastOperation(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name           string
+		opType         string
+		state          v1.FilestoreStatus
+		atSpecCapacity bool
+		expected       bool
+	}{
+		{name: "create running", opType: util.OpVerbCreate, state: v1.CREATING},
+		{name: "create done", opType: util.OpVerbCreate, state: v1.READY, expected: true},
+		{name: "update running", opType: util.OpVerbUpdate, state: v1.READY},
+		{name: "update done", opType: util.OpVerbUpdate, state: v1.READY, atSpecCapacity: true, expected: true},
+		{name: "delete running", opType: util.OpVerbDelete, state: v1.READY},
+		{name: "delete done", opType: util.OpVerbDelete, state: v1.DELETED, expected: true},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			op := &v1.LastOperation{Name: "operation-1", Type: tc.opType, StartTime: metav1.NewTime(now.Add(-time.Minute))}
+			if completed := completeLastOperation(op, tc.state, tc.atSpecCapacity, now); completed != tc.expected {
+				t.Errorf("got completed %t, expected %t", completed, tc.expected)
+			}
+			if (op.CompletionTime != nil) != tc.expected {
+				t.Errorf("got completion time %v, expected completed %t", op.CompletionTime, tc.expected)
+			}
+			if completeLastOperation(op, tc.state, tc.atSpecCapacity, now) {
+				t.Errorf("operation completed twice")
+			}
+		})
+	}
+}
+
+func TestMaybeUpdateShareInfoStatusConditions(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	instance := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "test-instance"}
+	shareInfo := &v1.ShareInfo{
+		ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: util.ManagedFilestoreCSINamespace, Generation: 2},
+		Spec:       v1.ShareInfoSpec{ShareName: "pvc_1", CapacityBytes: 200 * util.Gb, Region: testRegion, InstancePoolTag: testInstanceScPrefix},
+		Status: &v1.ShareInfoStatus{
+			InstanceHandle:     instanceURI(testProject, testRegion, "test-instance"),
+			CapacityBytes:      100 * util.Gb,
+			ShareStatus:        v1.READY,
+			Error:              "resize failed",
+			ObservedGeneration: 1,
+			LastOperation:      &v1.LastOperation{Name: "operation-1", Type: util.OpVerbUpdate, StartTime: metav1.NewTime(now.Add(-time.Hour))},
+		},
+	}
+	clientset := fsfake.NewSimpleClientset(shareInfo)
+	recon := &MultishareReconciler{
+		clientset: clientset,
+		now:       func() time.Time { return now },
+	}
+
+	// The spec changed, the share is being resized.
+	share := &file.Share{Name: "pvc_1", Parent: instance, CapacityBytes: 100 * util.Gb, State: "READY"}
+	updated, err := recon.maybeUpdateShareInfoStatus(share, shareInfo)
+	if err != nil {
+		t.Fatalf("Failed to update shareInfo status: %v", err)
+	}
+	if updated.Status.ObservedGeneration != 2 || !meta.IsStatusConditionTrue(updated.Status.Conditions, v1.ConditionResizing) ||
+		!meta.IsStatusConditionTrue(updated.Status.Conditions, v1.ConditionDegraded) || updated.Status.LastOperation.CompletionTime != nil {
+		t.Errorf("got status %+v, expected a resizing share with an error", updated.Status)
+	}
+
+	// The share is resized, the error is cleared and the operation completed.
+	share.CapacityBytes = 200 * util.Gb
+	updated, err = recon.maybeUpdateShareInfoStatus(share, updated)
+	if err != nil {
+		t.Fatalf("Failed to update shareInfo status: %v", err)
+	}
+	if updated.Status.Error != "" || !meta.IsStatusConditionTrue(updated.Status.Conditions, v1.ConditionReady) ||
+		meta.IsStatusConditionTrue(updated.Status.Conditions, v1.ConditionResizing) || meta.IsStatusConditionTrue(updated.Status.Conditions, v1.ConditionDegraded) {
+		t.Errorf("got status %+v, expected a ready share", updated.Status)
+	}
+	if op := updated.Status.LastOperation; op == nil || op.CompletionTime == nil || !op.CompletionTime.Time.Equal(now) {
+		t.Errorf("got last operation %+v, expected it to be completed", op)
+	}
+
+	// Nothing changed, the shareInfo is not updated.
+	actions := len(clientset.Actions())
+	if again, err := recon.maybeUpdateShareInfoStatus(share, updated); err != nil || again != updated {
+		t.Errorf("got shareInfo %+v and error %v, expected the unchanged shareInfo", again, err)
+	}
+	if len(clientset.Actions()) != actions {
+		t.Errorf("got actions %v, expected no update", clientset.Actions()[actions:])
+	}
+}
+
+func TestMaybeUpdateStatusClearsError(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name string
+		// state is the state of the share and of the instance, the capacities of their shareInfo and
+		// instanceInfo specs are 200Gb and 1Tb.
+		state              string
+		shareCapacityBytes int64
+		instanceBytes      int64
+		// The previous fields are the status of the shareInfo and instanceInfo before the update.
+		previousState         v1.FilestoreStatus
+		previousShareBytes    int64
+		previousInstanceBytes int64
+		expectedError         string
+	}{
+		{
+			name:                  "ready at the spec capacity",
+			state:                 "READY",
+			shareCapacityBytes:    200 * util.Gb,
+			instanceBytes:         util.Tb,
+			previousState:         v1.UPDATING,
+			previousShareBytes:    100 * util.Gb,
+			previousInstanceBytes: 2 * util.Tb,
+		},
+		{
+			name:                  "ready below the spec capacity",
+			state:                 "READY",
+			shareCapacityBytes:    150 * util.Gb,
+			instanceBytes:         2 * util.Tb,
+			previousState:         v1.UPDATING,
+			previousShareBytes:    100 * util.Gb,
+			previousInstanceBytes: 3 * util.Tb,
+			expectedError:         "resize failed",
+		},
+		{
+			name:               "created at the spec capacity, not ready",
+			state:              "CREATING",
+			shareCapacityBytes: 200 * util.Gb,
+			instanceBytes:      util.Tb,
+			expectedError:      "resize failed",
+		},
+		{
+			name:                  "unchanged",
+			state:                 "READY",
+			shareCapacityBytes:    200 * util.Gb,
+			instanceBytes:         util.Tb,
+			previousState:         v1.READY,
+			previousShareBytes:    200 * util.Gb,
+			previousInstanceBytes: util.Tb,
+			expectedError:         "resize failed",
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			instance := &file.MultishareInstance{Project: testProject, Location: testRegion, Name: "test-instance", CapacityBytes: tc.instanceBytes, State: tc.state}
+			shareInfo := &v1.ShareInfo{
+				ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: util.ManagedFilestoreCSINamespace},
+				Spec:       v1.ShareInfoSpec{ShareName: "pvc_1", CapacityBytes: 200 * util.Gb, Region: testRegion, InstancePoolTag: testInstanceScPrefix},
+				Status: &v1.ShareInfoStatus{
+					InstanceHandle: instanceURI(testProject, testRegion, "test-instance"),
+					CapacityBytes:  tc.previousShareBytes,
+					ShareStatus:    tc.previousState,
+					Error:          "resize failed",
+				},
+			}
+			instanceInfo := &v1.InstanceInfo{
+				ObjectMeta: metav1.ObjectMeta{Name: util.InstanceURIToInstanceInfoName(instanceURI(testProject, testRegion, "test-instance")), Namespace: util.ManagedFilestoreCSINamespace},
+				Spec:       v1.InstanceInfoSpec{CapacityBytes: util.Tb},
+				Status: &v1.InstanceInfoStatus{
+					ShareNames:     []string{"pvc-1"},
+					CapacityBytes:  tc.previousInstanceBytes,
+					InstanceStatus: tc.previousState,
+					Error:          "resize failed",
+				},
+			}
+			recon := &MultishareReconciler{
+				clientset: fsfake.NewSimpleClientset(shareInfo, instanceInfo),
+				now:       func() time.Time { return now },
+			}
+			// The conditions are set, so that the unchanged statuses are not updated.
+			recon.refreshShareInfoStatus(shareInfo, shareInfo.Status)
+			recon.refreshInstanceInfoStatus(instanceInfo, instanceInfo.Status)
+
+			share := &file.Share{Name: "pvc_1", Parent: instance, CapacityBytes: tc.shareCapacityBytes, State: tc.state}
+			updatedShareInfo, err := recon.maybeUpdateShareInfoStatus(share, shareInfo)
+			if err != nil {
+				t.Fatalf("Failed to update shareInfo status: %v", err)
+			}
+			if updatedShareInfo.Status.Error != tc.expectedError {
+				t.Errorf("got shareInfo error %q, expected %q", updatedShareInfo.Status.Error, tc.expectedError)
+			}
+			if degraded := meta.IsStatusConditionTrue(updatedShareInfo.Status.Conditions, v1.ConditionDegraded); degraded != (tc.expectedError != "") {
+				t.Errorf("got shareInfo degraded %t, expected %t", degraded, tc.expectedError != "")
+			}
+
+			updatedInstanceInfo, err := recon.maybeUpdateInstanceInfoStatus(instance, instanceInfo, map[string][]*file.Share{})
+			if err != nil {
+				t.Fatalf("Failed to update instanceInfo status: %v", err)
+			}
+			if updatedInstanceInfo.Status.Error != tc.expectedError {
+				t.Errorf("got instanceInfo error %q, expected %q", updatedInstanceInfo.Status.Error, tc.expectedError)
+			}
+			if degraded := meta.IsStatusConditionTrue(updatedInstanceInfo.Status.Conditions, v1.ConditionDegraded); degraded != (tc.expectedError != "") {
+				t.Errorf("got instanceInfo degraded %t, expected %t", degraded, tc.expectedError != "")
+			}
+		})
+	}
+}
+
+func TestUpdateShareInfoErrEvents(t *testing.T) {
+	const (
+		pvcName      = "test-pvc"
+		pvcNamespace = "test-namespace"
+	)
+	cases := []struct {
+		name        string
+		kubeObjects []runtime.Object
+		// expectedEvents is the number of events, on the shareInfo and the objects of the volume.
+		expectedEvents int
+	}{
+		{
+			name: "provisioning",
+			kubeObjects: []runtime.Object{
+				&corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: pvcName, Namespace: pvcNamespace}},
+			},
+			expectedEvents: 2,
+		},
+		{
+			name: "provisioned",
+			kubeObjects: []runtime.Object{
+				&corev1.PersistentVolume{
+					ObjectMeta: metav1.ObjectMeta{Name: "pvc-1"},
+					Spec: corev1.PersistentVolumeSpec{
+						ClaimRef: &corev1.ObjectReference{Kind: "PersistentVolumeClaim", Name: pvcName, Namespace: pvcNamespace, UID: "pvc-uid"},
+					},
+				},
+			},
+			expectedEvents: 3,
+		},
+		{
+			name:           "PVC not found",
+			expectedEvents: 1,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			shareInfo := &v1.ShareInfo{
+				ObjectMeta: metav1.ObjectMeta{Name: "pvc-1", Namespace: util.ManagedFilestoreCSINamespace},
+				Spec: v1.ShareInfoSpec{
+					ShareName:  "pvc_1",
+					Parameters: map[string]string{ParameterKeyPVCName: pvcName, ParameterKeyPVCNamespace: pvcNamespace},
+				},
+			}
+			pvIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			pvcIndexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+			for _, obj := range tc.kubeObjects {
+				switch obj.(type) {
+				case *corev1.PersistentVolume:
+					pvIndexer.Add(obj)
+				case *corev1.PersistentVolumeClaim:
+					pvcIndexer.Add(obj)
+				}
+			}
+			recorder := record.NewFakeRecorder(10)
+			recon := &MultishareReconciler{
+				clientset: fsfake.NewSimpleClientset(shareInfo),
+				pvLister:  coreListers.NewPersistentVolumeLister(pvIndexer),
+				pvcLister: coreListers.NewPersistentVolumeClaimLister(pvcIndexer),
+				recorder:  recorder,
+				now:       time.Now,
+			}
+
+			updated := recon.updateShareInfoErr(shareInfo, errors.New("quota exceeded"))
+			if updated.Status == nil || updated.Status.Error != "quota exceeded" || !meta.IsStatusConditionTrue(updated.Status.Conditions, v1.ConditionDegraded) {
+				t.Errorf("got status %+v, expected a degraded share", updated.Status)
+			}
+			// The same error is not recorded again.
+			recon.updateShareInfoErr(updated, errors.New("quota exceeded"))
+			if len(recorder.Events) != tc.expectedEvents {
+				t.Errorf("got %d events, expected %d", len(recorder.Events), tc.expectedEvents)
+			}
+		})
+	}
+}
@@ -0,0 +1,752 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+	mount "k8s.io/mount-utils"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	modeSubDirectory = "modeSubdirectory"
+
+	// StorageClass parameters of sub-directory volumes.
+	paramSubDirectory         = "subdirectory"
+	ParamSubDirectoryInstance = "subdirectory-instance"
+	ParamSubDirectoryPool     = "subdirectory-pool"
+	ParamSubDirectoryOnDelete = "subdirectory-on-delete"
+
+	// attrSubDirectory is the volume attribute of the sub-directory of the share mounted by the node.
+	attrSubDirectory = "subDirectory"
+
+	subDirectoryOnDeleteDelete  = "delete"
+	subDirectoryOnDeleteArchive = "archive"
+
+	defaultSubDirectoryPool = "default"
+	// defaultSubDirectorySize is the capacity of a sub-directory volume without a required capacity.
+	defaultSubDirectorySize = 1 * util.Gb
+	// subDirectoryPoolInstanceSize is the minimum size of the instances created for a pool.
+	subDirectoryPoolInstanceSize = 1 * util.Tb
+
+	// subDirectoryMetadataDir is the directory at the root of a share holding the metadata file of each
+	// sub-directory volume, which records its logical capacity. The nodes mount the sub-directories of the
+	// volumes in pods, so the metadata is out of reach of the users of the volumes.
+	subDirectoryMetadataDir = ".filestore-csi-subdirectories"
+	// subDirectoryArchivePrefix is prepended to the name of the archived sub-directories.
+	subDirectoryArchivePrefix = "archived-"
+
+	// tagKeySubDirectoryPool labels the instances created for a sub-directory pool with the pool name.
+	tagKeySubDirectoryPool = "storage_gke_io_subdirectory-pool"
+)
+
+var (
+	// Sub-directories are named after the PV, and are never hidden files.
+	subDirectoryRegex = regexp.MustCompile(`^[a-zA-Z0-9][-_.a-zA-Z0-9]{0,252}$`)
+	// The pool name is part of the names of its instances, which are at most 63 characters.
+	subDirectoryPoolRegex = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,48}[a-z0-9])?$`)
+)
+
+func isValidSubDirectory(name string) bool {
+	return subDirectoryRegex.MatchString(name) && !strings.HasPrefix(name, subDirectoryArchivePrefix)
+}
+
+// subDirectoryMetadata is the content of the metadata file of a sub-directory volume.
+type subDirectoryMetadata struct {
+	CapacityBytes int64  `json:"capacityBytes"`
+	OnDelete      string `json:"onDelete,omitempty"`
+}
+
+func subDirectoryMetadataPath(share, name string) string {
+	return filepath.Join(share, subDirectoryMetadataDir, name+".json")
+}
+
+// readSubDirectoryMetadata reads the metadata file of the sub-directory volume name of the share mounted at
+// share. The error satisfies os.IsNotExist if name is not a sub-directory volume.
+func readSubDirectoryMetadata(share, name string) (*subDirectoryMetadata, error) {
+	data, err := os.ReadFile(subDirectoryMetadataPath(share, name))
+	if err != nil {
+		return nil, err
+	}
+	metadata := &subDirectoryMetadata{}
+	if err := json.Unmarshal(data, metadata); err != nil {
+		return nil, fmt.Errorf("invalid metadata file of sub-directory %s: %w", name, err)
+	}
+	return metadata, nil
+}
+
+// writeSubDirectoryMetadata replaces the metadata file of the sub-directory volume name, so that it is never
+// read half written.
+func writeSubDirectoryMetadata(share, name string, metadata *subDirectoryMetadata) error {
+	data, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(share, subDirectoryMetadataDir), 0700); err != nil {
+		return err
+	}
+	path := subDirectoryMetadataPath(share, name)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// removeSubDirectoryMetadata removes the metadata file of the sub-directory volume name, if it exists.
+func removeSubDirectoryMetadata(share, name string) error {
+	if err := os.Remove(subDirectoryMetadataPath(share, name)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// subDirectoryParams are the parsed StorageClass parameters of a sub-directory volume.
+type subDirectoryParams struct {
+	// instance is the pre-existing instance of the volume, nil if the volume is placed in a pool.
+	instance *file.ServiceInstance
+	pool     string
+	onDelete string
+	// instanceParams are the remaining parameters, used to create the instances of the pool.
+	instanceParams map[string]string
+}
+
+func parseSubDirectoryParams(params map[string]string) (*subDirectoryParams, error) {
+	p := &subDirectoryParams{
+		onDelete:       subDirectoryOnDeleteDelete,
+		instanceParams: map[string]string{},
+	}
+	for k, v := range params {
+		switch strings.ToLower(k) {
+		case paramSubDirectory:
+		case ParamSubDirectoryInstance:
+			tokens := strings.Split(v, "/")
+			if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+				return nil, fmt.Errorf("%s %q must be of form {location}/{instanceName}", ParamSubDirectoryInstance, v)
+			}
+			p.instance = &file.ServiceInstance{Location: tokens[0], Name: tokens[1]}
+		case ParamSubDirectoryPool:
+			if !subDirectoryPoolRegex.MatchString(v) {
+				return nil, fmt.Errorf("invalid %s %q", ParamSubDirectoryPool, v)
+			}
+			p.pool = v
+		case ParamSubDirectoryOnDelete:
+			switch v {
+			case subDirectoryOnDeleteDelete, subDirectoryOnDeleteArchive:
+				p.onDelete = v
+			default:
+				return nil, fmt.Errorf("%s must be one of %q or %q, got %q", ParamSubDirectoryOnDelete, subDirectoryOnDeleteDelete, subDirectoryOnDeleteArchive, v)
+			}
+		case ParamReservedIPV4CIDR, paramEncryptionInTransit, paramSecurityFlavor:
+			return nil, fmt.Errorf("%s is not supported for sub-directory volumes", k)
+		default:
+			p.instanceParams[k] = v
+		}
+	}
+	if p.instance != nil && p.pool != "" {
+		return nil, fmt.Errorf("only one of %s and %s may be set", ParamSubDirectoryInstance, ParamSubDirectoryPool)
+	}
+	if p.instance == nil && p.pool == "" {
+		p.pool = defaultSubDirectoryPool
+	}
+	return p, nil
+}
+
+// SubDirectoryController handles CSI calls for volumes provisioned as sub-directories of the share of a
+// basic instance, either a pre-existing instance or an instance of a pool of instances created by the
+// driver. The capacity of a sub-directory volume is logical: it is accounted against the size of the
+// instance, but it is not enforced.
+type SubDirectoryController struct {
+	driver            *GCFSDriver
+	controllerServer  *controllerServer
+	fileService       file.Service
+	cloud             *cloud.Cloud
+	mounter           mount.Interface
+	volumeLocks       *util.VolumeLocks
+	workingDir        string
+	extraVolumeLabels map[string]string
+}
+
+func NewSubDirectoryController(config *controllerServerConfig) *SubDirectoryController {
+	return &SubDirectoryController{
+		driver:            config.driver,
+		fileService:       config.fileService,
+		cloud:             config.cloud,
+		mounter:           config.mounter,
+		volumeLocks:       config.volumeLocks,
+		workingDir:        config.features.FeatureSubDirectoryProvisioning.WorkingDir,
+		extraVolumeLabels: config.extraVolumeLabels,
+	}
+}
+
+// instanceUsage is the usage of the share of an instance by sub-directory volumes.
+type instanceUsage struct {
+	// allocatedBytes is the sum of the capacities of the sub-directory volumes of the share.
+	allocatedBytes int64
+	// volumes are the metadata of the sub-directory volumes by name.
+	volumes map[string]*subDirectoryMetadata
+}
+
+func (c *SubDirectoryController) CreateVolume(ctx context.Context, req *csi.CreateVolumeRequest) (*csi.CreateVolumeResponse, error) {
+	klog.V(4).Infof("CreateVolume called for sub-directory volume with request %+v", req)
+	name := req.GetName()
+	if len(name) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "CreateVolume name must be provided")
+	}
+	if !isValidSubDirectory(name) {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid sub-directory name %q", name)
+	}
+	if err := c.driver.validateVolumeCapabilities(req.GetVolumeCapabilities()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if req.GetVolumeContentSource() != nil {
+		return nil, status.Error(codes.InvalidArgument, "sub-directory volumes can't be created from a volume content source")
+	}
+	capBytes, err := subDirectoryRequestCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	params, err := parseSubDirectoryParams(req.GetParameters())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if acquired := c.volumeLocks.TryAcquire(name); !acquired {
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, name)
+	}
+	defer c.volumeLocks.Release(name)
+
+	var candidates []*file.ServiceInstance
+	if params.instance != nil {
+		params.instance.Project = c.cloud.Project
+		instance, err := c.fileService.GetInstance(ctx, params.instance)
+		if err != nil {
+			if file.IsNotFoundErr(err) {
+				return nil, status.Errorf(codes.InvalidArgument, "%s %s/%s not found", ParamSubDirectoryInstance, params.instance.Location, params.instance.Name)
+			}
+			return nil, file.StatusError(err)
+		}
+		if !isBasicTier(strings.ToLower(instance.Tier)) {
+			return nil, status.Errorf(codes.InvalidArgument, "%s %s/%s is a %s instance, sub-directory volumes need a basic instance", ParamSubDirectoryInstance, instance.Location, instance.Name, instance.Tier)
+		}
+		candidates = []*file.ServiceInstance{instance}
+	} else {
+		candidates, err = c.poolInstances(ctx, params.pool)
+		if err != nil {
+			return nil, file.StatusError(err)
+		}
+	}
+
+	// The sub-directory may already exist on a retry, in any instance of the pool.
+	var pending *file.ServiceInstance
+	for _, instance := range candidates {
+		if instance.State != "READY" {
+			pending = instance
+			continue
+		}
+		usage, err := c.instanceUsage(ctx, instance)
+		if err != nil {
+			return nil, err
+		}
+		if metadata, ok := usage.volumes[name]; ok {
+			if metadata.CapacityBytes < capBytes {
+				return nil, status.Errorf(codes.AlreadyExists, "sub-directory volume %s exists with capacity %d, smaller than the requested %d", name, metadata.CapacityBytes, capBytes)
+			}
+			klog.V(4).Infof("Found existing sub-directory volume %s in instance %s/%s", name, instance.Location, instance.Name)
+			return c.createVolumeResponse(instance, name, metadata.CapacityBytes), nil
+		}
+	}
+
+	for _, instance := range candidates {
+		if instance.State != "READY" {
+			continue
+		}
+		if c.volumeTopology() && !isTopologyAccessible(instanceAccessibleTopology(instance.Location, instance.Network.ConnectMode), req.GetAccessibilityRequirements()) {
+			klog.V(4).Infof("Instance %s/%s is not accessible from the topology of volume %s", instance.Location, instance.Name, name)
+			continue
+		}
+		created, err := c.createSubDirectory(ctx, instance, name, &subDirectoryMetadata{CapacityBytes: capBytes, OnDelete: params.onDelete})
+		if err != nil {
+			return nil, err
+		}
+		if created {
+			klog.Infof("CreateVolume succeeded for sub-directory volume %s in instance %s/%s", name, instance.Location, instance.Name)
+			return c.createVolumeResponse(instance, name, capBytes), nil
+		}
+	}
+
+	if params.instance != nil {
+		return nil, status.Errorf(codes.ResourceExhausted, "instance %s/%s has no room for %d bytes", params.instance.Location, params.instance.Name, capBytes)
+	}
+	if pending != nil {
+		msg := fmt.Sprintf("Volume %v not ready, instance %s of pool %s is in state %s", name, pending.Name, params.pool, pending.State)
+		klog.V(4).Info(msg)
+		if pending.State == "CREATING" {
+			return nil, status.Error(codes.DeadlineExceeded, msg)
+		}
+		return nil, status.Error(codes.Unavailable, msg)
+	}
+
+	instance, err := c.createPoolInstance(ctx, req, params, capBytes, candidates)
+	if err != nil {
+		return nil, err
+	}
+	created, err := c.createSubDirectory(ctx, instance, name, &subDirectoryMetadata{CapacityBytes: capBytes, OnDelete: params.onDelete})
+	if err != nil {
+		return nil, err
+	}
+	if !created {
+		return nil, status.Errorf(codes.Aborted, "new instance %s of pool %s filled up, retrying", instance.Name, params.pool)
+	}
+	klog.Infof("CreateVolume succeeded for sub-directory volume %s in new instance %s/%s", name, instance.Location, instance.Name)
+	return c.createVolumeResponse(instance, name, capBytes), nil
+}
+
+func (c *SubDirectoryController) DeleteVolume(ctx context.Context, req *csi.DeleteVolumeRequest) (*csi.DeleteVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	instance, subDirectory, err := parseSubDirectoryVolumeID(volumeID)
+	if err != nil {
+		// An invalid ID should be treated as doesn't exist
+		klog.V(5).Infof("failed to parse sub-directory volume %v for deletion: %v", volumeID, err)
+		return &csi.DeleteVolumeResponse{}, nil
+	}
+
+	if acquired := c.volumeLocks.TryAcquire(subDirectory); !acquired {
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer c.volumeLocks.Release(subDirectory)
+
+	instance, err = c.getInstance(ctx, instance)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return &csi.DeleteVolumeResponse{}, nil
+		}
+		return nil, file.StatusError(err)
+	}
+	if instance.State != "READY" {
+		return nil, status.Errorf(codes.Unavailable, "instance of volume %s is in state %s", volumeID, instance.State)
+	}
+
+	err = c.withInstanceShare(ctx, instance, func(share string) error {
+		dir := filepath.Join(share, subDirectory)
+		metadata, err := readSubDirectoryMetadata(share, subDirectory)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				return status.Error(codes.Internal, err.Error())
+			}
+			// The directory of a volume is never left without metadata by the driver, don't delete data
+			// the driver doesn't know about.
+			if _, err := os.Lstat(dir); err == nil {
+				return status.Errorf(codes.FailedPrecondition, "sub-directory %s of volume %s has no metadata, it is not deleted", subDirectory, volumeID)
+			} else if !os.IsNotExist(err) {
+				return status.Error(codes.Internal, err.Error())
+			}
+			return nil
+		}
+		// The metadata is removed last, so that a failed deletion is retried.
+		if metadata.OnDelete == subDirectoryOnDeleteArchive {
+			// Archived directories are not volumes, their capacity is released.
+			archive := filepath.Join(share, subDirectoryArchivePrefix+subDirectory)
+			if err := os.Rename(dir, archive); err != nil && !os.IsNotExist(err) {
+				return status.Errorf(codes.Internal, "failed to archive sub-directory %s: %v", subDirectory, err)
+			}
+		} else if err := os.RemoveAll(dir); err != nil {
+			return status.Errorf(codes.Internal, "failed to delete sub-directory %s: %v", subDirectory, err)
+		}
+		if err := removeSubDirectoryMetadata(share, subDirectory); err != nil {
+			return status.Errorf(codes.Internal, "failed to remove the metadata of sub-directory %s: %v", subDirectory, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("DeleteVolume succeeded for sub-directory volume %v", volumeID)
+	return &csi.DeleteVolumeResponse{}, nil
+}
+
+// ControllerExpandVolume updates the logical capacity of a sub-directory volume, the mounts of the share on
+// the nodes need no expansion.
+func (c *SubDirectoryController) ControllerExpandVolume(ctx context.Context, req *csi.ControllerExpandVolumeRequest) (*csi.ControllerExpandVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	instance, subDirectory, err := parseSubDirectoryVolumeID(volumeID)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	reqBytes, err := subDirectoryRequestCapacity(req.GetCapacityRange())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	if acquired := c.volumeLocks.TryAcquire(subDirectory); !acquired {
+		return nil, status.Errorf(codes.Aborted, util.VolumeOperationAlreadyExistsFmt, volumeID)
+	}
+	defer c.volumeLocks.Release(subDirectory)
+
+	instance, err = c.getInstance(ctx, instance)
+	if err != nil {
+		return nil, file.StatusError(err)
+	}
+	if instance.State != "READY" {
+		return nil, status.Errorf(codes.Unavailable, "instance of volume %s is in state %s", volumeID, instance.State)
+	}
+
+	var capacityBytes int64
+	err = c.withInstanceShare(ctx, instance, func(share string) error {
+		usage, err := shareUsage(share)
+		if err != nil {
+			return err
+		}
+		metadata, ok := usage.volumes[subDirectory]
+		if !ok {
+			return status.Errorf(codes.NotFound, "volume %v doesn't exist", volumeID)
+		}
+		capacityBytes = metadata.CapacityBytes
+		if reqBytes <= metadata.CapacityBytes {
+			return nil
+		}
+		if usage.allocatedBytes-metadata.CapacityBytes+reqBytes > instance.Volume.SizeBytes {
+			return status.Errorf(codes.OutOfRange, "instance %s/%s has no room to expand volume %s to %d bytes", instance.Location, instance.Name, volumeID, reqBytes)
+		}
+		metadata.CapacityBytes = reqBytes
+		if err := writeSubDirectoryMetadata(share, subDirectory, metadata); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		capacityBytes = reqBytes
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Controller expand volume succeeded for sub-directory volume %v, new size(bytes): %v", volumeID, capacityBytes)
+	return &csi.ControllerExpandVolumeResponse{
+		CapacityBytes:         capacityBytes,
+		NodeExpansionRequired: false,
+	}, nil
+}
+
+// ControllerGetVolume returns a sub-directory volume, with the condition of its instance.
+func (c *SubDirectoryController) ControllerGetVolume(ctx context.Context, req *csi.ControllerGetVolumeRequest) (*csi.ControllerGetVolumeResponse, error) {
+	volumeID := req.GetVolumeId()
+	instance, subDirectory, err := parseSubDirectoryVolumeID(volumeID)
+	if err != nil {
+		// An invalid id format is treated as doesn't exist
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	instance, err = c.getInstance(ctx, instance)
+	if err != nil {
+		if file.IsNotFoundErr(err) {
+			return nil, status.Errorf(codes.NotFound, "volume %v doesn't exist", volumeID)
+		}
+		return nil, file.StatusError(err)
+	}
+	if instance.State != "READY" {
+		return &csi.ControllerGetVolumeResponse{
+			Volume: &csi.Volume{VolumeId: volumeID},
+			Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+				VolumeCondition: volumeConditionFromState(instance.State),
+			},
+		}, nil
+	}
+
+	var metadata *subDirectoryMetadata
+	err = c.withInstanceShare(ctx, instance, func(share string) error {
+		var err error
+		metadata, err = readSubDirectoryMetadata(share, subDirectory)
+		if err != nil {
+			if os.IsNotExist(err) {
+				return status.Errorf(codes.NotFound, "volume %v doesn't exist", volumeID)
+			}
+			return status.Error(codes.Internal, err.Error())
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &csi.ControllerGetVolumeResponse{
+		Volume: c.createVolumeResponse(instance, subDirectory, metadata.CapacityBytes).Volume,
+		Status: &csi.ControllerGetVolumeResponse_VolumeStatus{
+			VolumeCondition: volumeConditionFromState(instance.State),
+		},
+	}, nil
+}
+
+func (c *SubDirectoryController) createVolumeResponse(instance *file.ServiceInstance, subDirectory string, capacityBytes int64) *csi.CreateVolumeResponse {
+	resp := &csi.CreateVolumeResponse{
+		Volume: &csi.Volume{
+			VolumeId:      getSubDirectoryVolumeID(instance, subDirectory),
+			CapacityBytes: capacityBytes,
+			VolumeContext: map[string]string{
+				attrIP:           instance.Network.Ip,
+				attrVolume:       instance.Volume.Name,
+				attrSubDirectory: subDirectory,
+				attrFileProtocol: v3FileProtocol,
+			},
+		},
+	}
+	if c.volumeTopology() {
+		resp.Volume.AccessibleTopology = instanceAccessibleTopology(instance.Location, instance.Network.ConnectMode)
+	}
+	if features := c.driver.config.FeatureOptions; features.FeatureMountPolicy != nil && features.FeatureMountPolicy.Enabled {
+		resp.Volume.VolumeContext[attrTier] = strings.ToLower(instance.Tier)
+	}
+	return resp
+}
+
+func (c *SubDirectoryController) volumeTopology() bool {
+	features := c.driver.config.FeatureOptions
+	return features.FeatureVolumeTopology != nil && features.FeatureVolumeTopology.Enabled
+}
+
+func (c *SubDirectoryController) getInstance(ctx context.Context, instance *file.ServiceInstance) (*file.ServiceInstance, error) {
+	instance.Project = c.cloud.Project
+	return c.fileService.GetInstance(ctx, instance)
+}
+
+// poolInstances returns the instances of a pool, sorted by name so that volumes fill the instances in order.
+func (c *SubDirectoryController) poolInstances(ctx context.Context, pool string) ([]*file.ServiceInstance, error) {
+	instances, err := c.fileService.ListInstances(ctx, &file.ServiceInstance{Project: c.cloud.Project})
+	if err != nil {
+		return nil, err
+	}
+	var poolInstances []*file.ServiceInstance
+	for _, instance := range instances {
+		if instance.Labels[tagKeySubDirectoryPool] == pool {
+			poolInstances = append(poolInstances, instance)
+		}
+	}
+	sort.Slice(poolInstances, func(i, j int) bool {
+		return poolInstances[i].Name < poolInstances[j].Name
+	})
+	return poolInstances, nil
+}
+
+// createPoolInstance creates a new basic instance for a pool, large enough for a volume of capBytes.
+func (c *SubDirectoryController) createPoolInstance(ctx context.Context, req *csi.CreateVolumeRequest, params *subDirectoryParams, capBytes int64, poolInstances []*file.ServiceInstance) (*file.ServiceInstance, error) {
+	poolKey := "subdirectory-pool/" + params.pool
+	if acquired := c.volumeLocks.TryAcquire(poolKey); !acquired {
+		return nil, status.Errorf(codes.Aborted, "an instance of pool %s is already being created", params.pool)
+	}
+	defer c.volumeLocks.Release(poolKey)
+
+	names := map[string]bool{}
+	for _, instance := range poolInstances {
+		names[instance.Name] = true
+	}
+	name := ""
+	for i := 1; name == ""; i++ {
+		if candidate := fmt.Sprintf("%s-%d", params.pool, i); !names[candidate] {
+			name = candidate
+		}
+	}
+	sizeBytes := capBytes
+	if sizeBytes < subDirectoryPoolInstanceSize {
+		sizeBytes = subDirectoryPoolInstanceSize
+	}
+
+	instance, err := c.controllerServer.generateNewFileInstance(name, sizeBytes, params.instanceParams, req.GetAccessibilityRequirements())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if !isBasicTier(strings.ToLower(instance.Tier)) {
+		return nil, status.Errorf(codes.InvalidArgument, "sub-directory pools only support basic tiers, got tier %s", instance.Tier)
+	}
+	if reservedIPRange, ok := params.instanceParams[ParamReservedIPRange]; ok && instance.Network.ConnectMode == privateServiceAccess {
+		if IsCIDR(reservedIPRange) {
+			return nil, status.Errorf(codes.InvalidArgument, "When using connect mode PRIVATE_SERVICE_ACCESS, if reserved IP range is specified, it must be a named address range instead of direct CIDR value %v", reservedIPRange)
+		}
+		instance.Network.ReservedIpRange = reservedIPRange
+	}
+	if err := c.controllerServer.config.preflight.validateInstance(ctx, instance); err != nil {
+		return nil, err
+	}
+
+	// The instance is shared by the volumes of the pool, it is not labeled with the claim of the volume.
+	labels, err := extractLabels(map[string]string{ParameterKeyLabels: params.instanceParams[ParameterKeyLabels]}, c.extraVolumeLabels, c.driver.config.Name)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	labels[tagKeySubDirectoryPool] = params.pool
+	instance.Labels = labels
+
+	klog.Infof("Creating instance %s/%s of %d bytes for sub-directory pool %s", instance.Location, name, sizeBytes, params.pool)
+	instance, err = c.fileService.CreateInstance(ctx, instance)
+	if err != nil {
+		klog.Errorf("Create instance %s of sub-directory pool %s failed: %v", name, params.pool, err.Error())
+		return nil, file.StatusError(err)
+	}
+	if err := c.controllerServer.config.tagManager.AttachResourceTags(ctx, cloud.FilestoreInstance, instance.Name, instance.Location, req.GetName(), params.instanceParams); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return instance, nil
+}
+
+// createSubDirectory creates a sub-directory volume in the share of an instance if it has room for it.
+// It returns false if the instance is full.
+func (c *SubDirectoryController) createSubDirectory(ctx context.Context, instance *file.ServiceInstance, name string, metadata *subDirectoryMetadata) (bool, error) {
+	created := false
+	err := c.withInstanceShare(ctx, instance, func(share string) error {
+		usage, err := shareUsage(share)
+		if err != nil {
+			return err
+		}
+		if usage.allocatedBytes+metadata.CapacityBytes > instance.Volume.SizeBytes {
+			return nil
+		}
+		// Like the share of a new instance, the sub-directory is only writable by root. The mode is
+		// set explicitly as it is otherwise masked by the umask of the driver.
+		dir := filepath.Join(share, name)
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return status.Errorf(codes.Internal, "failed to create sub-directory %s: %v", name, err)
+		}
+		if err := os.Chmod(dir, 0755); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		if err := writeSubDirectoryMetadata(share, name, metadata); err != nil {
+			return status.Error(codes.Internal, err.Error())
+		}
+		created = true
+		return nil
+	})
+	return created, err
+}
+
+// instanceUsage returns the usage of the share of an instance by sub-directory volumes.
+func (c *SubDirectoryController) instanceUsage(ctx context.Context, instance *file.ServiceInstance) (*instanceUsage, error) {
+	var usage *instanceUsage
+	err := c.withInstanceShare(ctx, instance, func(share string) error {
+		var err error
+		usage, err = shareUsage(share)
+		return err
+	})
+	return usage, err
+}
+
+// shareUsage reads the metadata of the sub-directory volumes of a mounted share. The files of the share
+// which are not in a volume, such as archived volumes or the data of a pre-existing instance, are not
+// accounted, since measuring them walks all their files while the instance is locked.
+func shareUsage(share string) (*instanceUsage, error) {
+	usage := &instanceUsage{volumes: map[string]*subDirectoryMetadata{}}
+	metadataEntries, err := os.ReadDir(filepath.Join(share, subDirectoryMetadataDir))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, status.Errorf(codes.Internal, "failed to list the sub-directory metadata of share %s: %v", share, err)
+	}
+	for _, entry := range metadataEntries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !isValidSubDirectory(name) {
+			continue
+		}
+		metadata, err := readSubDirectoryMetadata(share, name)
+		if err != nil {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		usage.allocatedBytes += metadata.CapacityBytes
+		usage.volumes[name] = metadata
+	}
+	return usage, nil
+}
+
+// diskUsage returns the apparent size of the regular files under path.
+func diskUsage(path string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		size += info.Size()
+		return nil
+	})
+	return size, err
+}
+
+// withInstanceShare mounts the share of an instance under the working directory of the controller, and
+// calls f with the mount path. Concurrent operations on the same instance are aborted, so that the room
+// for a new volume is never given out twice.
+func (c *SubDirectoryController) withInstanceShare(ctx context.Context, instance *file.ServiceInstance, f func(share string) error) error {
+	instanceKey := fmt.Sprintf("subdirectory-instance/%s/%s", instance.Location, instance.Name)
+	if acquired := c.volumeLocks.TryAcquire(instanceKey); !acquired {
+		return status.Errorf(codes.Aborted, "an operation on instance %s/%s is already in progress", instance.Location, instance.Name)
+	}
+	defer c.volumeLocks.Release(instanceKey)
+
+	share := filepath.Join(c.workingDir, instance.Location, instance.Name)
+	if err := os.MkdirAll(share, 0750); err != nil {
+		return status.Errorf(codes.Internal, "failed to create mount path %s: %v", share, err)
+	}
+	source := fmt.Sprintf("%s:/%s", instance.Network.Ip, instance.Volume.Name)
+	notMnt, err := c.mounter.IsLikelyNotMountPoint(share)
+	if err != nil {
+		return status.Error(codes.Internal, err.Error())
+	}
+	if notMnt {
+		// The controller doesn't lock the files of the share, so it runs no NFS lock service.
+		if err := c.mounter.Mount(source, share, "nfs", []string{"nolock"}); err != nil {
+			return status.Errorf(codes.Internal, "failed to mount share %s at %s: %v", source, share, err)
+		}
+	}
+	defer func() {
+		if err := c.mounter.Unmount(share); err != nil {
+			klog.Errorf("Failed to unmount share %s at %s: %v", source, share, err)
+		}
+	}()
+	return f(share)
+}
+
+// subDirectoryRequestCapacity returns the capacity of a sub-directory volume. Sub-directories have no
+// minimum size, so the required bytes are used as requested.
+func subDirectoryRequestCapacity(capRange *csi.CapacityRange) (int64, error) {
+	if capRange == nil {
+		return defaultSubDirectorySize, nil
+	}
+	requiredBytes := capRange.GetRequiredBytes()
+	limitBytes := capRange.GetLimitBytes()
+	if requiredBytes < 0 || limitBytes < 0 {
+		return 0, fmt.Errorf("negative capacity range %v", capRange)
+	}
+	if limitBytes > 0 && requiredBytes > limitBytes {
+		return 0, fmt.Errorf("required bytes %d exceed limit bytes %d", requiredBytes, limitBytes)
+	}
+	if requiredBytes > 0 {
+		return requiredBytes, nil
+	}
+	if limitBytes > 0 && limitBytes < defaultSubDirectorySize {
+		return limitBytes, nil
+	}
+	return defaultSubDirectorySize, nil
+}
@@ -0,0 +1,393 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+const (
+	testSubDirectoryLocation = "us-central1-c"
+	testSubDirectoryInstance = "shared"
+)
+
+type subDirectoryTestEnv struct {
+	cs          *controllerServer
+	fileService file.Service
+	fm          *mount.FakeMounter
+	workingDir  string
+}
+
+// initSubDirectoryTestEnv returns a controller with sub-directory provisioning enabled. The shares are
+// not mounted by the fake mounter, so the sub-directories are created in the working directory.
+func initSubDirectoryTestEnv(t *testing.T) *subDirectoryTestEnv {
+	fileService, err := file.NewFakeService()
+	if err != nil {
+		t.Fatalf("failed to initialize GCFS service: %v", err)
+	}
+	cloudProvider, err := cloud.NewFakeCloud()
+	if err != nil {
+		t.Fatalf("Failed to get cloud provider: %v", err)
+	}
+	env := &subDirectoryTestEnv{
+		fileService: fileService,
+		fm:          &mount.FakeMounter{MountPoints: []mount.MountPoint{}},
+		workingDir:  t.TempDir(),
+	}
+	env.cs = newControllerServer(&controllerServerConfig{
+		driver:      initTestDriver(t),
+		fileService: fileService,
+		cloud:       cloudProvider,
+		mounter:     env.fm,
+		volumeLocks: util.NewVolumeLocks(),
+		features: &GCFSDriverFeatureOptions{
+			FeatureLockRelease:              &FeatureLockRelease{},
+			FeatureSubDirectoryProvisioning: &FeatureSubDirectoryProvisioning{Enabled: true, WorkingDir: env.workingDir},
+		},
+		tagManager: cloud.NewFakeTagManagerForSanityTests(),
+	}).(*controllerServer)
+	return env
+}
+
+// createInstance creates the pre-existing basic instance of sub-directory volumes.
+func (env *subDirectoryTestEnv) createInstance(t *testing.T, sizeBytes int64) {
+	_, err := env.fileService.CreateInstance(context.Background(), &file.ServiceInstance{
+		Name:     testSubDirectoryInstance,
+		Location: testSubDirectoryLocation,
+		Tier:     "BASIC_HDD",
+		Volume:   file.Volume{Name: newInstanceVolume, SizeBytes: sizeBytes},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create instance: %v", err)
+	}
+}
+
+func (env *subDirectoryTestEnv) share(instance string) string {
+	return filepath.Join(env.workingDir, testSubDirectoryLocation, instance)
+}
+
+func subDirectoryCreateRequest(name string, capBytes int64, params map[string]string) *csi.CreateVolumeRequest {
+	parameters := map[string]string{paramSubDirectory: "true"}
+	for k, v := range params {
+		parameters[k] = v
+	}
+	return &csi.CreateVolumeRequest{
+		Name:               name,
+		CapacityRange:      &csi.CapacityRange{RequiredBytes: capBytes},
+		VolumeCapabilities: []*csi.VolumeCapability{testVolumeCapability},
+		Parameters:         parameters,
+	}
+}
+
+func TestSubDirectoryCreateDeleteVolume(t *testing.T) {
+	env := initSubDirectoryTestEnv(t)
+	ctx := context.Background()
+
+	resp, err := env.cs.CreateVolume(ctx, subDirectoryCreateRequest("pvc-1", 5*util.Gb, nil))
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	expected := &csi.Volume{
+		VolumeId:      "modeSubdirectory/us-central1-c/default-1/vol1/pvc-1",
+		CapacityBytes: 5 * util.Gb,
+		VolumeContext: map[string]string{
+			attrIP:           "1.1.1.1",
+			attrVolume:       newInstanceVolume,
+			attrSubDirectory: "pvc-1",
+			attrFileProtocol: v3FileProtocol,
+		},
+	}
+	if !reflect.DeepEqual(resp.Volume, expected) {
+		t.Errorf("got volume %+v, expected %+v", resp.Volume, expected)
+	}
+	instance, err := env.fileService.GetInstance(ctx, &file.ServiceInstance{Name: "default-1"})
+	if err != nil {
+		t.Fatalf("Pool instance was not created: %v", err)
+	}
+	if instance.Labels[tagKeySubDirectoryPool] != defaultSubDirectoryPool || instance.Volume.SizeBytes != subDirectoryPoolInstanceSize {
+		t.Errorf("got pool instance %+v, expected pool label %s and size %d", instance, defaultSubDirectoryPool, subDirectoryPoolInstanceSize)
+	}
+	if len(env.fm.MountPoints) != 0 {
+		t.Errorf("shares are still mounted: %v", env.fm.MountPoints)
+	}
+
+	// A retry returns the same volume.
+	retry, err := env.cs.CreateVolume(ctx, subDirectoryCreateRequest("pvc-1", 5*util.Gb, nil))
+	if err != nil {
+		t.Fatalf("CreateVolume retry failed: %v", err)
+	}
+	if !reflect.DeepEqual(retry.Volume, expected) {
+		t.Errorf("got volume %+v on retry, expected %+v", retry.Volume, expected)
+	}
+	// A retry with a larger capacity conflicts with the volume.
+	if _, err := env.cs.CreateVolume(ctx, subDirectoryCreateRequest("pvc-1", 10*util.Gb, nil)); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("got error %v on larger retry, expected AlreadyExists", err)
+	}
+
+	if _, err := env.cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: expected.VolumeId}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.share("default-1"), "pvc-1")); !os.IsNotExist(err) {
+		t.Errorf("sub-directory was not deleted: %v", err)
+	}
+	// The pool instance is kept for the next volumes.
+	if _, err := env.fileService.GetInstance(ctx, &file.ServiceInstance{Name: "default-1"}); err != nil {
+		t.Errorf("pool instance was deleted: %v", err)
+	}
+	if _, err := env.cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: expected.VolumeId}); err != nil {
+		t.Errorf("DeleteVolume of deleted volume failed: %v", err)
+	}
+	if _, err := env.cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "modeSubdirectory/us-central1-c/missing/vol1/pvc-1"}); err != nil {
+		t.Errorf("DeleteVolume of volume of missing instance failed: %v", err)
+	}
+}
+
+func TestSubDirectoryPoolPlacement(t *testing.T) {
+	env := initSubDirectoryTestEnv(t)
+	ctx := context.Background()
+
+	// The third volume doesn't fit in the first instance of the pool.
+	volumes := []struct {
+		name     string
+		capBytes int64
+		instance string
+	}{
+		{name: "pvc-1", capBytes: 600 * util.Gb, instance: "small-1"},
+		{name: "pvc-2", capBytes: 400 * util.Gb, instance: "small-1"},
+		{name: "pvc-3", capBytes: 100 * util.Gb, instance: "small-2"},
+		{name: "pvc-4", capBytes: 2 * util.Tb, instance: "small-3"},
+	}
+	for _, v := range volumes {
+		resp, err := env.cs.CreateVolume(ctx, subDirectoryCreateRequest(v.name, v.capBytes, map[string]string{ParamSubDirectoryPool: "small"}))
+		if err != nil {
+			t.Fatalf("CreateVolume %s failed: %v", v.name, err)
+		}
+		instance, subDirectory, err := parseSubDirectoryVolumeID(resp.Volume.VolumeId)
+		if err != nil {
+			t.Fatalf("Invalid volume id %s: %v", resp.Volume.VolumeId, err)
+		}
+		if instance.Name != v.instance || subDirectory != v.name {
+			t.Errorf("volume %s was placed in %s/%s, expected %s", v.name, instance.Name, subDirectory, v.instance)
+		}
+	}
+	instance, err := env.fileService.GetInstance(ctx, &file.ServiceInstance{Name: "small-3"})
+	if err != nil {
+		t.Fatalf("Pool instance was not created: %v", err)
+	}
+	if instance.Volume.SizeBytes != 2*util.Tb {
+		t.Errorf("got pool instance of %d bytes, expected the size of the larger volume", instance.Volume.SizeBytes)
+	}
+
+	// An archived volume releases its capacity.
+	archived, err := env.cs.CreateVolume(ctx, subDirectoryCreateRequest("pvc-5", 100*util.Gb, map[string]string{
+		ParamSubDirectoryPool:     "small",
+		ParamSubDirectoryOnDelete: subDirectoryOnDeleteArchive,
+	}))
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	if _, err := env.cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: archived.Volume.VolumeId}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.share("small-2"), "archived-pvc-5")); err != nil {
+		t.Errorf("sub-directory was not archived: %v", err)
+	}
+	if _, err := readSubDirectoryMetadata(env.share("small-2"), "pvc-5"); !os.IsNotExist(err) {
+		t.Errorf("metadata of the archived sub-directory was not removed: %v", err)
+	}
+	usage, err := shareUsage(env.share("small-2"))
+	if err != nil {
+		t.Fatalf("Failed to get share usage: %v", err)
+	}
+	if usage.allocatedBytes != 100*util.Gb {
+		t.Errorf("got %d allocated bytes after archiving, expected %d", usage.allocatedBytes, 100*util.Gb)
+	}
+}
+
+func TestSubDirectoryInstance(t *testing.T) {
+	env := initSubDirectoryTestEnv(t)
+	env.createInstance(t, 10*util.Gb)
+	ctx := context.Background()
+	params := map[string]string{ParamSubDirectoryInstance: testSubDirectoryLocation + "/" + testSubDirectoryInstance}
+
+	// Data of the instance, without a metadata file, is not a volume and is not accounted.
+	if err := os.MkdirAll(filepath.Join(env.share(testSubDirectoryInstance), "data"), 0755); err != nil {
+		t.Fatalf("Failed to create data directory: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(env.share(testSubDirectoryInstance), "data", "file"), nil, 0644); err != nil {
+		t.Fatalf("Failed to create data file: %v", err)
+	}
+	if err := os.Truncate(filepath.Join(env.share(testSubDirectoryInstance), "data", "file"), 2*util.Gb); err != nil {
+		t.Fatalf("Failed to resize data file: %v", err)
+	}
+
+	resp, err := env.cs.CreateVolume(ctx, subDirectoryCreateRequest("pvc-1", 6*util.Gb, params))
+	if err != nil {
+		t.Fatalf("CreateVolume failed: %v", err)
+	}
+	volumeID := resp.Volume.VolumeId
+	if volumeID != "modeSubdirectory/us-central1-c/shared/vol1/pvc-1" {
+		t.Errorf("got volume id %s", volumeID)
+	}
+	if _, err := os.Stat(filepath.Join(env.share(testSubDirectoryInstance), "pvc-1", subDirectoryMetadataDir)); !os.IsNotExist(err) {
+		t.Errorf("metadata is in the sub-directory of the volume: %v", err)
+	}
+	if _, err := env.cs.CreateVolume(ctx, subDirectoryCreateRequest("pvc-2", 5*util.Gb, params)); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("got error %v for volume larger than the room left, expected ResourceExhausted", err)
+	}
+
+	get, err := env.cs.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: volumeID})
+	if err != nil {
+		t.Fatalf("ControllerGetVolume failed: %v", err)
+	}
+	if get.Volume.CapacityBytes != 6*util.Gb || get.Status.VolumeCondition.Abnormal {
+		t.Errorf("got volume %+v with status %+v", get.Volume, get.Status)
+	}
+	if _, err := env.cs.ControllerGetVolume(ctx, &csi.ControllerGetVolumeRequest{VolumeId: "modeSubdirectory/us-central1-c/shared/vol1/pvc-2"}); status.Code(err) != codes.NotFound {
+		t.Errorf("got error %v for missing volume, expected NotFound", err)
+	}
+	if _, err := env.cs.ValidateVolumeCapabilities(ctx, &csi.ValidateVolumeCapabilitiesRequest{
+		VolumeId:           volumeID,
+		VolumeCapabilities: []*csi.VolumeCapability{testVolumeCapability},
+	}); err != nil {
+		t.Errorf("ValidateVolumeCapabilities failed: %v", err)
+	}
+
+	expand, err := env.cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      volumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 8 * util.Gb},
+	})
+	if err != nil {
+		t.Fatalf("ControllerExpandVolume failed: %v", err)
+	}
+	if expand.CapacityBytes != 8*util.Gb || expand.NodeExpansionRequired {
+		t.Errorf("got expand response %+v", expand)
+	}
+	metadata, err := readSubDirectoryMetadata(env.share(testSubDirectoryInstance), "pvc-1")
+	if err != nil {
+		t.Fatalf("Failed to read metadata: %v", err)
+	}
+	if metadata.CapacityBytes != 8*util.Gb {
+		t.Errorf("got capacity %d in metadata, expected %d", metadata.CapacityBytes, 8*util.Gb)
+	}
+	if _, err := env.cs.ControllerExpandVolume(ctx, &csi.ControllerExpandVolumeRequest{
+		VolumeId:      volumeID,
+		CapacityRange: &csi.CapacityRange{RequiredBytes: 11 * util.Gb},
+	}); status.Code(err) != codes.OutOfRange {
+		t.Errorf("got error %v for expansion beyond the instance, expected OutOfRange", err)
+	}
+
+	if _, err := env.cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: volumeID}); err != nil {
+		t.Fatalf("DeleteVolume failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.share(testSubDirectoryInstance), "data")); err != nil {
+		t.Errorf("data of the instance was deleted: %v", err)
+	}
+	// A directory without metadata is not deleted as a volume.
+	if _, err := env.cs.DeleteVolume(ctx, &csi.DeleteVolumeRequest{VolumeId: "modeSubdirectory/us-central1-c/shared/vol1/data"}); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v deleting a directory without metadata, expected FailedPrecondition", err)
+	}
+	if _, err := os.Stat(filepath.Join(env.share(testSubDirectoryInstance), "data", "file")); err != nil {
+		t.Errorf("data of the instance was deleted: %v", err)
+	}
+}
+
+func TestSubDirectoryCreateVolumeErrors(t *testing.T) {
+	cases := []struct {
+		name         string
+		volumeName   string
+		params       map[string]string
+		disabled     bool
+		expectedCode codes.Code
+	}{
+		{
+			name:         "feature disabled",
+			volumeName:   "pvc-1",
+			disabled:     true,
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "invalid sub-directory name",
+			volumeName:   ".pvc-1",
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "invalid pool",
+			volumeName:   "pvc-1",
+			params:       map[string]string{ParamSubDirectoryPool: "Pool_1"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:       "instance and pool",
+			volumeName: "pvc-1",
+			params: map[string]string{
+				ParamSubDirectoryPool:     "pool",
+				ParamSubDirectoryInstance: testSubDirectoryLocation + "/" + testSubDirectoryInstance,
+			},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "invalid on-delete",
+			volumeName:   "pvc-1",
+			params:       map[string]string{ParamSubDirectoryOnDelete: "retain"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "missing instance",
+			volumeName:   "pvc-1",
+			params:       map[string]string{ParamSubDirectoryInstance: testSubDirectoryLocation + "/missing"},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "enterprise pool",
+			volumeName:   "pvc-1",
+			params:       map[string]string{paramTier: enterpriseTier},
+			expectedCode: codes.InvalidArgument,
+		},
+		{
+			name:         "reserved CIDR",
+			volumeName:   "pvc-1",
+			params:       map[string]string{ParamReservedIPV4CIDR: "192.168.92.0/26"},
+			expectedCode: codes.InvalidArgument,
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			env := initSubDirectoryTestEnv(t)
+			if tc.disabled {
+				env.cs.config.subDirectoryController = nil
+			}
+			_, err := env.cs.CreateVolume(context.Background(), subDirectoryCreateRequest(tc.volumeName, util.Gb, tc.params))
+			if status.Code(err) != tc.expectedCode {
+				t.Errorf("got error %v, expected code %v", err, tc.expectedCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,390 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+const (
+	// tlsTunnelHost is the local address the tunnels listen on. The node driver runs in the host network,
+	// so the NFS client of the host connects to it.
+	tlsTunnelHost = "127.0.0.1"
+	// The local ports of the tunnels.
+	tlsTunnelMinPort = 20049
+	tlsTunnelMaxPort = 21048
+
+	// tlsTunnelStartTimeout is how long a new tunnel has to start listening.
+	tlsTunnelStartTimeout = 10 * time.Second
+	tlsTunnelProbeTimeout = 5 * time.Second
+	// defaultTLSTunnelProbeInterval is the interval the tunnels are probed if not configured.
+	defaultTLSTunnelProbeInterval = 30 * time.Second
+	// The backoff of the restarts of a tunnel.
+	tlsTunnelMinBackoff = 1 * time.Second
+	tlsTunnelMaxBackoff = 1 * time.Minute
+)
+
+// tunnelProcess is a running tunnel process.
+type tunnelProcess interface {
+	Wait() error
+	Kill() error
+}
+
+type cmdTunnelProcess struct {
+	cmd *exec.Cmd
+}
+
+func (p *cmdTunnelProcess) Wait() error {
+	return p.cmd.Wait()
+}
+
+func (p *cmdTunnelProcess) Kill() error {
+	return p.cmd.Process.Kill()
+}
+
+// tlsTunnel is the TLS tunnel of a staged volume to its NFS server. The exported fields are the state
+// saved in the state directory.
+type tlsTunnel struct {
+	VolumeID string `json:"volumeID"`
+	IP       string `json:"ip"`
+	Port     int    `json:"port"`
+
+	stopCh chan struct{}
+	doneCh chan struct{}
+}
+
+// tlsTunnelManager runs an stunnel client for each staged volume encrypted in transit, and restarts it
+// when it exits or stops accepting connections. The tunnels are saved in the state directory and restored
+// when the driver restarts, as the NFS mounts keep using their local ports. restore and stop are no-ops on
+// a nil manager, when encryption in transit is disabled.
+type tlsTunnelManager struct {
+	mux sync.Mutex
+	// tunnels are the tunnels by volume ID.
+	tunnels map[string]*tlsTunnel
+
+	stunnelPath   string
+	stateDir      string
+	caFile        string
+	serverName    string
+	serverPort    int
+	probeInterval time.Duration
+	// insecureSkipVerify starts the tunnels without a CA bundle, without verifying the servers.
+	insecureSkipVerify bool
+
+	// startProcess runs stunnel with a config file, and probe checks that a tunnel accepts connections
+	// on its local port. They are replaced in tests.
+	startProcess func(configPath string) (tunnelProcess, error)
+	probe        func(port int) error
+}
+
+func newTLSTunnelManager(features *FeatureEncryptionInTransit) *tlsTunnelManager {
+	m := &tlsTunnelManager{
+		tunnels:       map[string]*tlsTunnel{},
+		stunnelPath:   features.StunnelPath,
+		stateDir:      features.StateDir,
+		caFile:        features.CAFile,
+		serverName:    features.ServerName,
+		serverPort:    features.ServerPort,
+		probeInterval: features.ProbeInterval,
+		probe:         probeTLSTunnel,
+	}
+	if m.probeInterval <= 0 {
+		m.probeInterval = defaultTLSTunnelProbeInterval
+	}
+	m.startProcess = m.startStunnel
+	if m.caFile == "" {
+		if features.InsecureSkipVerify {
+			m.insecureSkipVerify = true
+			klog.Warningf("WARNING: the certificates of the NFS servers are NOT verified, the volumes encrypted in transit are open to man-in-the-middle attacks. Set --encryption-in-transit-ca-file to verify them.")
+		} else {
+			klog.Warningf("No CA bundle is set with --encryption-in-transit-ca-file, the volumes encrypted in transit can't be staged")
+		}
+	}
+	return m
+}
+
+// verifiesServers returns whether the tunnels can be started, which requires a CA bundle to verify the
+// certificates of the servers unless verification is explicitly skipped.
+func (m *tlsTunnelManager) verifiesServers() bool {
+	return m.caFile != "" || m.insecureSkipVerify
+}
+
+// restore starts the tunnels saved in the state directory.
+func (m *tlsTunnelManager) restore() error {
+	if m == nil {
+		return nil
+	}
+	if err := os.MkdirAll(m.stateDir, 0750); err != nil {
+		return err
+	}
+	paths, err := filepath.Glob(filepath.Join(m.stateDir, "*.json"))
+	if err != nil {
+		return err
+	}
+	m.mux.Lock()
+	defer m.mux.Unlock()
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		t := &tlsTunnel{}
+		if err := json.Unmarshal(data, t); err != nil {
+			klog.Errorf("Ignoring invalid TLS tunnel state %s: %v", path, err)
+			continue
+		}
+		klog.Infof("Restoring TLS tunnel of volume %s to %s on port %d", t.VolumeID, t.IP, t.Port)
+		m.run(t)
+	}
+	return nil
+}
+
+// start starts the tunnel of a volume to its NFS server at ip, unless it is already running, and returns
+// its local port once it accepts connections.
+func (m *tlsTunnelManager) start(volumeID, ip string) (int, error) {
+	m.mux.Lock()
+	if t, ok := m.tunnels[volumeID]; ok {
+		m.mux.Unlock()
+		if t.IP != ip {
+			return 0, fmt.Errorf("TLS tunnel of volume %s already connects to %s", volumeID, t.IP)
+		}
+		return t.Port, nil
+	}
+	port, err := m.freePort()
+	if err != nil {
+		m.mux.Unlock()
+		return 0, err
+	}
+	t := &tlsTunnel{VolumeID: volumeID, IP: ip, Port: port}
+	if err := m.save(t); err != nil {
+		m.mux.Unlock()
+		return 0, err
+	}
+	klog.Infof("Starting TLS tunnel of volume %s to %s on port %d", volumeID, ip, port)
+	m.run(t)
+	m.mux.Unlock()
+
+	deadline := time.Now().Add(tlsTunnelStartTimeout)
+	for {
+		err := m.probe(port)
+		if err == nil {
+			return port, nil
+		}
+		if time.Now().After(deadline) {
+			m.stop(volumeID)
+			return 0, fmt.Errorf("TLS tunnel of volume %s did not start: %w", volumeID, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// stop stops the tunnel of a volume, and removes its state.
+func (m *tlsTunnelManager) stop(volumeID string) error {
+	if m == nil {
+		return nil
+	}
+	m.mux.Lock()
+	t, ok := m.tunnels[volumeID]
+	delete(m.tunnels, volumeID)
+	m.mux.Unlock()
+	if !ok {
+		return nil
+	}
+	close(t.stopCh)
+	<-t.doneCh
+	klog.Infof("Stopped TLS tunnel of volume %s", volumeID)
+	for _, path := range []string{m.statePath(volumeID), m.configPath(volumeID)} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// run registers a tunnel and supervises its process. It must be called with mux held.
+func (m *tlsTunnelManager) run(t *tlsTunnel) {
+	t.stopCh = make(chan struct{})
+	t.doneCh = make(chan struct{})
+	m.tunnels[t.VolumeID] = t
+	go m.supervise(t)
+}
+
+// supervise runs the process of a tunnel until the tunnel is stopped. The process is started again with
+// a backoff when it exits, or killed and started again when it stops accepting connections.
+func (m *tlsTunnelManager) supervise(t *tlsTunnel) {
+	defer close(t.doneCh)
+	backoff := tlsTunnelMinBackoff
+	for {
+		if err := m.writeConfig(t); err != nil {
+			klog.Errorf("Failed to write the config of the TLS tunnel of volume %s: %v", t.VolumeID, err)
+		} else if proc, err := m.startProcess(m.configPath(t.VolumeID)); err != nil {
+			klog.Errorf("Failed to start the TLS tunnel of volume %s: %v", t.VolumeID, err)
+		} else if healthy, stopped := m.watch(t, proc); stopped {
+			return
+		} else if healthy {
+			backoff = tlsTunnelMinBackoff
+		}
+
+		klog.Warningf("Restarting the TLS tunnel of volume %s in %v", t.VolumeID, backoff)
+		select {
+		case <-t.stopCh:
+			return
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+		if backoff > tlsTunnelMaxBackoff {
+			backoff = tlsTunnelMaxBackoff
+		}
+	}
+}
+
+// watch probes the process of a tunnel until it exits, is unhealthy or the tunnel is stopped. It returns
+// whether a probe succeeded, and whether the tunnel was stopped.
+func (m *tlsTunnelManager) watch(t *tlsTunnel, proc tunnelProcess) (bool, bool) {
+	exited := make(chan error, 1)
+	go func() {
+		exited <- proc.Wait()
+	}()
+	kill := func() {
+		if err := proc.Kill(); err != nil {
+			klog.Errorf("Failed to kill the TLS tunnel of volume %s: %v", t.VolumeID, err)
+		}
+		<-exited
+	}
+
+	ticker := time.NewTicker(m.probeInterval)
+	defer ticker.Stop()
+	healthy := false
+	for {
+		select {
+		case <-t.stopCh:
+			kill()
+			return healthy, true
+		case err := <-exited:
+			klog.Warningf("TLS tunnel of volume %s exited: %v", t.VolumeID, err)
+			return healthy, false
+		case <-ticker.C:
+			if err := m.probe(t.Port); err != nil {
+				klog.Warningf("TLS tunnel of volume %s is not accepting connections: %v", t.VolumeID, err)
+				kill()
+				return healthy, false
+			}
+			healthy = true
+		}
+	}
+}
+
+// freePort returns a local port not used by a tunnel or another process. It must be called with mux held.
+func (m *tlsTunnelManager) freePort() (int, error) {
+	used := map[int]bool{}
+	for _, t := range m.tunnels {
+		used[t.Port] = true
+	}
+	for port := tlsTunnelMinPort; port <= tlsTunnelMaxPort; port++ {
+		if used[port] {
+			continue
+		}
+		l, err := net.Listen("tcp", net.JoinHostPort(tlsTunnelHost, strconv.Itoa(port)))
+		if err != nil {
+			continue
+		}
+		l.Close()
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free port for a TLS tunnel in %d-%d", tlsTunnelMinPort, tlsTunnelMaxPort)
+}
+
+func (m *tlsTunnelManager) save(t *tlsTunnel) error {
+	if err := os.MkdirAll(m.stateDir, 0750); err != nil {
+		return err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.statePath(t.VolumeID), data, 0600)
+}
+
+// writeConfig writes the stunnel config of a tunnel. stunnel runs in the foreground, so that it is
+// supervised as a child process of the driver. The certificate of the server must chain to the CA bundle
+// and match the server name, or the IP of the server if no name is set.
+func (m *tlsTunnelManager) writeConfig(t *tlsTunnel) error {
+	if !m.verifiesServers() {
+		return fmt.Errorf("no CA bundle to verify the certificate of %s", t.IP)
+	}
+	var config bytes.Buffer
+	fmt.Fprintf(&config, "foreground = yes\npid =\nsyslog = no\n\n")
+	fmt.Fprintf(&config, "[%s]\n", volumeFileName(t.VolumeID))
+	fmt.Fprintf(&config, "client = yes\n")
+	fmt.Fprintf(&config, "accept = %s\n", net.JoinHostPort(tlsTunnelHost, strconv.Itoa(t.Port)))
+	fmt.Fprintf(&config, "connect = %s\n", net.JoinHostPort(t.IP, strconv.Itoa(m.serverPort)))
+	fmt.Fprintf(&config, "sslVersionMin = TLSv1.2\n")
+	if m.caFile == "" {
+		fmt.Fprintf(&config, "verifyChain = no\nverifyPeer = no\n")
+	} else {
+		fmt.Fprintf(&config, "CAfile = %s\nverifyChain = yes\n", m.caFile)
+		if m.serverName != "" {
+			fmt.Fprintf(&config, "sni = %s\ncheckHost = %s\n", m.serverName, m.serverName)
+		} else {
+			fmt.Fprintf(&config, "checkIP = %s\n", t.IP)
+		}
+	}
+	return os.WriteFile(m.configPath(t.VolumeID), config.Bytes(), 0600)
+}
+
+func (m *tlsTunnelManager) startStunnel(configPath string) (tunnelProcess, error) {
+	cmd := exec.Command(m.stunnelPath, configPath)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return &cmdTunnelProcess{cmd: cmd}, nil
+}
+
+func (m *tlsTunnelManager) statePath(volumeID string) string {
+	return filepath.Join(m.stateDir, volumeFileName(volumeID)+".json")
+}
+
+func (m *tlsTunnelManager) configPath(volumeID string) string {
+	return filepath.Join(m.stateDir, volumeFileName(volumeID)+".conf")
+}
+
+// volumeFileName returns the base name of the node files of a volume, as its tunnel and credentials.
+func volumeFileName(volumeID string) string {
+	return strings.ReplaceAll(volumeID, "/", "_")
+}
+
+func probeTLSTunnel(port int) error {
+	conn, err := net.DialTimeout("tcp", net.JoinHostPort(tlsTunnelHost, strconv.Itoa(port)), tlsTunnelProbeTimeout)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
@@ -0,0 +1,362 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	mount "k8s.io/mount-utils"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/metadata"
+)
+
+type fakeTunnelProcess struct {
+	exit     chan error
+	killed   chan struct{}
+	killOnce sync.Once
+}
+
+func (p *fakeTunnelProcess) Wait() error {
+	select {
+	case err := <-p.exit:
+		return err
+	case <-p.killed:
+		return errors.New("killed")
+	}
+}
+
+func (p *fakeTunnelProcess) Kill() error {
+	p.killOnce.Do(func() { close(p.killed) })
+	return nil
+}
+
+// fakeTunnels runs the tunnels of a manager as fake processes, which accept connections unless unhealthy.
+type fakeTunnels struct {
+	mux       sync.Mutex
+	unhealthy bool
+	// started receives the processes as they are started.
+	started chan *fakeTunnelProcess
+}
+
+func newTestTLSTunnelManager(t *testing.T, stateDir string) (*tlsTunnelManager, *fakeTunnels) {
+	m := newTLSTunnelManager(&FeatureEncryptionInTransit{
+		Enabled:       true,
+		StateDir:      stateDir,
+		CAFile:        "/etc/filestore/ca.pem",
+		ServerPort:    2049,
+		ProbeInterval: 10 * time.Millisecond,
+	})
+	fake := &fakeTunnels{started: make(chan *fakeTunnelProcess, 10)}
+	m.startProcess = func(configPath string) (tunnelProcess, error) {
+		if _, err := os.Stat(configPath); err != nil {
+			t.Errorf("tunnel started without config: %v", err)
+		}
+		p := &fakeTunnelProcess{exit: make(chan error, 1), killed: make(chan struct{})}
+		fake.started <- p
+		return p, nil
+	}
+	m.probe = func(port int) error {
+		fake.mux.Lock()
+		defer fake.mux.Unlock()
+		if fake.unhealthy {
+			return fmt.Errorf("connection refused")
+		}
+		return nil
+	}
+	return m, fake
+}
+
+func (f *fakeTunnels) setUnhealthy(unhealthy bool) {
+	f.mux.Lock()
+	defer f.mux.Unlock()
+	f.unhealthy = unhealthy
+}
+
+func (f *fakeTunnels) nextProcess(t *testing.T) *fakeTunnelProcess {
+	select {
+	case p := <-f.started:
+		return p
+	case <-time.After(5 * time.Second):
+		t.Fatalf("tunnel process was not started")
+		return nil
+	}
+}
+
+func TestTLSTunnelStartStop(t *testing.T) {
+	stateDir := t.TempDir()
+	m, fake := newTestTLSTunnelManager(t, stateDir)
+
+	port, err := m.start(testVolumeID, "1.1.1.1")
+	if err != nil {
+		t.Fatalf("Failed to start tunnel: %v", err)
+	}
+	if port < tlsTunnelMinPort || port > tlsTunnelMaxPort {
+		t.Errorf("got port %d out of the tunnel port range", port)
+	}
+	p := fake.nextProcess(t)
+	config, err := os.ReadFile(m.configPath(testVolumeID))
+	if err != nil {
+		t.Fatalf("Failed to read config: %v", err)
+	}
+	for _, line := range []string{"client = yes", fmt.Sprintf("accept = 127.0.0.1:%d", port), "connect = 1.1.1.1:2049", "CAfile = /etc/filestore/ca.pem", "verifyChain = yes", "checkIP = 1.1.1.1"} {
+		if !strings.Contains(string(config), line+"\n") {
+			t.Errorf("config %q is missing %q", config, line)
+		}
+	}
+
+	// Starting the tunnel again, as a retry of NodeStageVolume, returns the running tunnel.
+	if again, err := m.start(testVolumeID, "1.1.1.1"); err != nil || again != port {
+		t.Errorf("got port %d, error %v starting the tunnel again, expected port %d", again, err, port)
+	}
+	if _, err := m.start(testVolumeID, "1.1.1.2"); err == nil {
+		t.Errorf("started the tunnel of the volume to another server")
+	}
+	other, err := m.start("modeInstance/us-central1-c/other/vol1", "1.1.1.2")
+	if err != nil {
+		t.Fatalf("Failed to start tunnel: %v", err)
+	}
+	if other == port {
+		t.Errorf("tunnels of two volumes got the same port %d", port)
+	}
+	fake.nextProcess(t)
+
+	if err := m.stop(testVolumeID); err != nil {
+		t.Fatalf("Failed to stop tunnel: %v", err)
+	}
+	select {
+	case <-p.killed:
+	default:
+		t.Errorf("tunnel process was not killed")
+	}
+	if _, err := os.Stat(m.statePath(testVolumeID)); !os.IsNotExist(err) {
+		t.Errorf("tunnel state was not removed: %v", err)
+	}
+	if err := m.stop(testVolumeID); err != nil {
+		t.Errorf("Failed to stop stopped tunnel: %v", err)
+	}
+	m.stop("modeInstance/us-central1-c/other/vol1")
+
+	var disabled *tlsTunnelManager
+	if err := disabled.stop(testVolumeID); err != nil {
+		t.Errorf("stop of disabled manager failed: %v", err)
+	}
+}
+
+func TestTLSTunnelConfigVerification(t *testing.T) {
+	cases := []struct {
+		name               string
+		caFile             string
+		serverName         string
+		insecureSkipVerify bool
+		expectedLines      []string
+		expectError        bool
+	}{
+		{
+			name:          "server IP",
+			caFile:        "/etc/filestore/ca.pem",
+			expectedLines: []string{"CAfile = /etc/filestore/ca.pem", "verifyChain = yes", "checkIP = 1.1.1.1"},
+		},
+		{
+			name:          "server name",
+			caFile:        "/etc/filestore/ca.pem",
+			serverName:    "filestore.example.com",
+			expectedLines: []string{"CAfile = /etc/filestore/ca.pem", "verifyChain = yes", "sni = filestore.example.com", "checkHost = filestore.example.com"},
+		},
+		{
+			name:        "no CA bundle",
+			expectError: true,
+		},
+		{
+			name:               "verification skipped",
+			insecureSkipVerify: true,
+			expectedLines:      []string{"verifyChain = no", "verifyPeer = no"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			m := newTLSTunnelManager(&FeatureEncryptionInTransit{
+				Enabled:            true,
+				StateDir:           t.TempDir(),
+				CAFile:             tc.caFile,
+				ServerName:         tc.serverName,
+				InsecureSkipVerify: tc.insecureSkipVerify,
+				ServerPort:         2049,
+			})
+			err := m.writeConfig(&tlsTunnel{VolumeID: testVolumeID, IP: "1.1.1.1", Port: tlsTunnelMinPort})
+			if tc.expectError {
+				if err == nil {
+					t.Errorf("wrote the config of a tunnel without verification of the server")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Failed to write config: %v", err)
+			}
+			config, err := os.ReadFile(m.configPath(testVolumeID))
+			if err != nil {
+				t.Fatalf("Failed to read config: %v", err)
+			}
+			for _, line := range tc.expectedLines {
+				if !strings.Contains(string(config), line+"\n") {
+					t.Errorf("config %q is missing %q", config, line)
+				}
+			}
+		})
+	}
+}
+
+func TestTLSTunnelRestart(t *testing.T) {
+	m, fake := newTestTLSTunnelManager(t, t.TempDir())
+	defer m.stop(testVolumeID)
+	if _, err := m.start(testVolumeID, "1.1.1.1"); err != nil {
+		t.Fatalf("Failed to start tunnel: %v", err)
+	}
+	p := fake.nextProcess(t)
+
+	// An exited tunnel is started again.
+	p.exit <- errors.New("exit status 1")
+	p = fake.nextProcess(t)
+
+	// A tunnel that stops accepting connections is killed and started again.
+	fake.setUnhealthy(true)
+	select {
+	case <-p.killed:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("unhealthy tunnel was not killed")
+	}
+	fake.setUnhealthy(false)
+	fake.nextProcess(t)
+}
+
+func TestTLSTunnelRestore(t *testing.T) {
+	stateDir := t.TempDir()
+	m, _ := newTestTLSTunnelManager(t, stateDir)
+	port, err := m.start(testVolumeID, "1.1.1.1")
+	if err != nil {
+		t.Fatalf("Failed to start tunnel: %v", err)
+	}
+	defer m.stop(testVolumeID)
+
+	// The tunnel of a restarted driver keeps its port, which the mount uses.
+	restored, fake := newTestTLSTunnelManager(t, stateDir)
+	if err := restored.restore(); err != nil {
+		t.Fatalf("Failed to restore tunnels: %v", err)
+	}
+	defer restored.stop(testVolumeID)
+	fake.nextProcess(t)
+	if got, err := restored.start(testVolumeID, "1.1.1.1"); err != nil || got != port {
+		t.Errorf("got port %d, error %v for restored tunnel, expected port %d", got, err, port)
+	}
+}
+
+func TestNodeStageEncryptedVolume(t *testing.T) {
+	mounter := &mount.FakeMounter{MountPoints: []mount.MountPoint{}}
+	metaService, err := metadata.NewFakeService()
+	if err != nil {
+		t.Fatalf("Failed to init metadata service")
+	}
+	server, err := newNodeServer(initTestDriver(t), mounter, metaService, &GCFSDriverFeatureOptions{
+		FeatureLockRelease:         &FeatureLockRelease{},
+		FeatureEncryptionInTransit: &FeatureEncryptionInTransit{Enabled: true, StateDir: t.TempDir()},
+	})
+	if err != nil {
+		t.Fatalf("Failed to create node server: %v", err)
+	}
+	ns := server.(*nodeServer)
+	var fake *fakeTunnels
+	ns.tlsTunnels, fake = newTestTLSTunnelManager(t, ns.tlsTunnels.stateDir)
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+
+	attributes := map[string]string{
+		attrIP:                  "1.1.1.1",
+		attrVolume:              "test-volume",
+		attrFileProtocol:        v4_1FileProtocol,
+		attrEncryptionInTransit: "true",
+	}
+	if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     attributes,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	p := fake.nextProcess(t)
+	port := ns.tlsTunnels.tunnels[testVolumeID].Port
+	validateMountPoint(t, "encrypted volume", mounter, &mount.MountPoint{
+		Device: "127.0.0.1:/test-volume",
+		Path:   stagingPath,
+		Type:   "nfs",
+		Opts:   []string{fmt.Sprintf("port=%d", port), "vers=4.1"},
+	})
+
+	if _, err := ns.NodeUnstageVolume(context.Background(), &csi.NodeUnstageVolumeRequest{VolumeId: testVolumeID, StagingTargetPath: stagingPath}); err != nil {
+		t.Fatalf("NodeUnstageVolume failed: %v", err)
+	}
+	select {
+	case <-p.killed:
+	default:
+		t.Errorf("tunnel of unstaged volume was not stopped")
+	}
+
+	// Only NFSv4.1 volumes can be tunneled.
+	attributes[attrFileProtocol] = v3FileProtocol
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     attributes,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v staging an encrypted NFSv3 volume, expected InvalidArgument", err)
+	}
+
+	// The volume can't be staged without a CA bundle to verify the server.
+	attributes[attrFileProtocol] = v4_1FileProtocol
+	ns.tlsTunnels.caFile = ""
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     attributes,
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v staging an encrypted volume without a CA bundle, expected FailedPrecondition", err)
+	}
+
+	// The volume can't be staged on a node without encryption in transit.
+	ns.tlsTunnels = nil
+	_, err = ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  testVolumeCapability,
+		VolumeContext:     attributes,
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("got error %v staging an encrypted volume without the feature, expected FailedPrecondition", err)
+	}
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// nodeTopology returns the topology segment of a node in a zone. The region is only reported with the
+// volume topology feature, as the topology keys of the nodes of a cluster must not change while the
+// volumes provisioned before are constrained to zones.
+func nodeTopology(zone string, withRegion bool) *csi.Topology {
+	segments := map[string]string{TopologyKeyZone: zone}
+	if withRegion {
+		region, err := util.GetRegionFromZone(zone)
+		if err != nil {
+			klog.Warningf("Failed to get the region of zone %q, the region of the node is not reported: %v", zone, err)
+		} else {
+			segments[TopologyKeyRegion] = region
+		}
+	}
+	return &csi.Topology{Segments: segments}
+}
+
+// instanceAccessibleTopology returns the topology a volume of an instance in a location is accessible from.
+// The instances of regional tiers are accessible from all the zones of their region, and so are the
+// instances of zonal tiers connected with PRIVATE_SERVICE_ACCESS, which are reached through the service
+// networking connection of the network rather than from their zone. The other instances of zonal tiers
+// are accessible from their zone, so that their pods do not depend on the availability of another zone.
+func instanceAccessibleTopology(location, connectMode string) []*csi.Topology {
+	region, err := util.GetRegionFromZone(location)
+	if err != nil {
+		// The location is already a region.
+		return []*csi.Topology{{Segments: map[string]string{TopologyKeyRegion: location}}}
+	}
+	if connectMode == privateServiceAccess {
+		return []*csi.Topology{{Segments: map[string]string{TopologyKeyRegion: region}}}
+	}
+	return []*csi.Topology{{Segments: map[string]string{TopologyKeyZone: location}}}
+}
+
+// isTopologyAccessible returns whether a volume accessible from the accessible topology can be used by a
+// node of the requisite topology of a request. A topology is accessible from a segment if all its keys
+// have the same value in the segment, the region of a segment being the region of its zone if it has none.
+func isTopologyAccessible(accessible []*csi.Topology, top *csi.TopologyRequirement) bool {
+	if len(top.GetRequisite()) == 0 {
+		return true
+	}
+	for _, requisite := range top.GetRequisite() {
+		segments := requisite.GetSegments()
+		if _, ok := segments[TopologyKeyRegion]; !ok {
+			if region, err := getRegionFromSegment(segments); err == nil {
+				segments = map[string]string{TopologyKeyZone: segments[TopologyKeyZone], TopologyKeyRegion: region}
+			}
+		}
+		for _, t := range accessible {
+			matches := true
+			for k, v := range t.GetSegments() {
+				if segments[k] != v {
+					matches = false
+					break
+				}
+			}
+			if matches {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// pickRegionFromTopology picks the region of the first available topology from the preferred list or
+// requisite list in that order, like pickZoneFromTopology. Segments with only a region are accepted, as
+// regional volumes have no zone.
+func pickRegionFromTopology(top *csi.TopologyRequirement) (string, error) {
+	reqRegions, err := getRegionsFromTopology(top.GetRequisite())
+	if err != nil {
+		return "", fmt.Errorf("could not get regions from requisite topology: %w", err)
+	}
+
+	prefRegions, err := getRegionsFromTopology(top.GetPreferred())
+	if err != nil {
+		return "", fmt.Errorf("could not get regions from preferred topology: %w", err)
+	}
+
+	if len(prefRegions) == 0 && len(reqRegions) == 0 {
+		return "", fmt.Errorf("both requisite and preferred topology list empty")
+	}
+
+	if len(prefRegions) != 0 {
+		return prefRegions[0], nil
+	}
+	return reqRegions[0], nil
+}
+
+func getRegionsFromTopology(topList []*csi.Topology) ([]string, error) {
+	regions := []string{}
+	for _, top := range topList {
+		if top.GetSegments() == nil {
+			return nil, fmt.Errorf("topologies specified but no segments")
+		}
+
+		region, err := getRegionFromSegment(top.GetSegments())
+		if err != nil {
+			return nil, fmt.Errorf("could not get region from topology: %w", err)
+		}
+		regions = append(regions, region)
+	}
+	return regions, nil
+}
+
+// getRegionFromSegment returns the region of a topology segment, or the region of its zone.
+func getRegionFromSegment(seg map[string]string) (string, error) {
+	if _, ok := seg[TopologyKeyZone]; ok {
+		zone, err := getZoneFromSegment(seg)
+		if err != nil {
+			return "", err
+		}
+		return util.GetRegionFromZone(zone)
+	}
+	var region string
+	for k, v := range seg {
+		switch k {
+		case TopologyKeyRegion:
+			region = v
+		default:
+			return "", fmt.Errorf("topology segment has unknown key %v", k)
+		}
+	}
+	if len(region) == 0 {
+		return "", fmt.Errorf("topology specified but could not find region in segment: %v", seg)
+	}
+	return region, nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"reflect"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func zoneTopology(zone string) []*csi.Topology {
+	return []*csi.Topology{{Segments: map[string]string{TopologyKeyZone: zone}}}
+}
+
+func regionTopology(region string) []*csi.Topology {
+	return []*csi.Topology{{Segments: map[string]string{TopologyKeyRegion: region}}}
+}
+
+func topologySegments(topologies []*csi.Topology) []map[string]string {
+	var segments []map[string]string
+	for _, t := range topologies {
+		segments = append(segments, t.GetSegments())
+	}
+	return segments
+}
+
+func TestInstanceAccessibleTopology(t *testing.T) {
+	cases := []struct {
+		name        string
+		location    string
+		connectMode string
+		expected    []*csi.Topology
+	}{
+		{
+			name:        "zonal instance",
+			location:    "us-central1-c",
+			connectMode: directPeering,
+			expected:    zoneTopology("us-central1-c"),
+		},
+		{
+			name:        "zonal instance with private service access",
+			location:    "us-central1-c",
+			connectMode: privateServiceAccess,
+			expected:    regionTopology("us-central1"),
+		},
+		{
+			name:        "regional instance",
+			location:    "us-central1",
+			connectMode: directPeering,
+			expected:    regionTopology("us-central1"),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := topologySegments(instanceAccessibleTopology(tc.location, tc.connectMode))
+			if !reflect.DeepEqual(got, topologySegments(tc.expected)) {
+				t.Errorf("got topology %v, expected %v", got, topologySegments(tc.expected))
+			}
+		})
+	}
+}
+
+func TestIsTopologyAccessible(t *testing.T) {
+	nodeSegment := &csi.Topology{Segments: map[string]string{TopologyKeyZone: "us-central1-c"}}
+	cases := []struct {
+		name       string
+		accessible []*csi.Topology
+		top        *csi.TopologyRequirement
+		expected   bool
+	}{
+		{
+			name:       "no requirement",
+			accessible: zoneTopology("us-east1-b"),
+			expected:   true,
+		},
+		{
+			name:       "same zone",
+			accessible: zoneTopology("us-central1-c"),
+			top:        &csi.TopologyRequirement{Requisite: []*csi.Topology{nodeSegment}},
+			expected:   true,
+		},
+		{
+			name:       "other zone",
+			accessible: zoneTopology("us-central1-b"),
+			top:        &csi.TopologyRequirement{Requisite: []*csi.Topology{nodeSegment}},
+		},
+		{
+			name:       "region of the zone",
+			accessible: regionTopology("us-central1"),
+			top:        &csi.TopologyRequirement{Requisite: []*csi.Topology{nodeSegment}},
+			expected:   true,
+		},
+		{
+			name:       "other region",
+			accessible: regionTopology("us-east1"),
+			top: &csi.TopologyRequirement{Requisite: []*csi.Topology{
+				{Segments: map[string]string{TopologyKeyZone: "us-central1-c", TopologyKeyRegion: "us-central1"}},
+			}},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := isTopologyAccessible(tc.accessible, tc.top); got != tc.expected {
+				t.Errorf("got accessible %v, expected %v", got, tc.expected)
+			}
+		})
+	}
+}
+
+func TestNodeTopology(t *testing.T) {
+	got := nodeTopology("us-central1-c", true).GetSegments()
+	expected := map[string]string{TopologyKeyZone: "us-central1-c", TopologyKeyRegion: "us-central1"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got node topology %v, expected %v", got, expected)
+	}
+	// The region is not reported without the volume topology feature.
+	got = nodeTopology("us-central1-c", false).GetSegments()
+	expected = map[string]string{TopologyKeyZone: "us-central1-c"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("got node topology %v, expected %v", got, expected)
+	}
+}
+
+func TestCreateVolumeAccessibleTopology(t *testing.T) {
+	requirement := &csi.TopologyRequirement{
+		Requisite: []*csi.Topology{
+			{Segments: map[string]string{TopologyKeyZone: "us-central1-c", TopologyKeyRegion: "us-central1"}},
+		},
+	}
+	cases := []struct {
+		name     string
+		params   map[string]string
+		expected []*csi.Topology
+	}{
+		{
+			name:     "basic instance",
+			params:   map[string]string{},
+			expected: zoneTopology("us-central1-c"),
+		},
+		{
+			name:     "basic instance with private service access",
+			params:   map[string]string{ParamConnectMode: privateServiceAccess},
+			expected: regionTopology("us-central1"),
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			fileService, err := file.NewFakeService()
+			if err != nil {
+				t.Fatalf("failed to initialize GCFS service: %v", err)
+			}
+			cloudProvider, err := cloud.NewFakeCloud()
+			if err != nil {
+				t.Fatalf("Failed to get cloud provider: %v", err)
+			}
+			cs := newControllerServer(&controllerServerConfig{
+				driver:      initTestDriver(t),
+				fileService: fileService,
+				cloud:       cloudProvider,
+				volumeLocks: util.NewVolumeLocks(),
+				features: &GCFSDriverFeatureOptions{
+					FeatureLockRelease:    &FeatureLockRelease{},
+					FeatureVolumeTopology: &FeatureVolumeTopology{Enabled: true},
+				},
+				tagManager: cloud.NewFakeTagManagerForSanityTests(),
+			})
+			resp, err := cs.CreateVolume(context.Background(), &csi.CreateVolumeRequest{
+				Name:                      testCSIVolume,
+				VolumeCapabilities:        []*csi.VolumeCapability{testVolumeCapability},
+				Parameters:                tc.params,
+				AccessibilityRequirements: requirement,
+			})
+			if err != nil {
+				t.Fatalf("CreateVolume failed: %v", err)
+			}
+			if got := topologySegments(resp.GetVolume().GetAccessibleTopology()); !reflect.DeepEqual(got, topologySegments(tc.expected)) {
+				t.Errorf("got accessible topology %v, expected %v", got, topologySegments(tc.expected))
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"sync"
+	"time"
+)
+
+// subDirectoryUsageCacheTTL is how long the used bytes of a sub-directory volume are reused. The kubelet
+// polls NodeGetVolumeStats every minute, and measuring the usage walks all the files of the volume.
+const subDirectoryUsageCacheTTL = 5 * time.Minute
+
+// usageCache caches the used bytes of the sub-directory volumes computed by NodeGetVolumeStats, by volume
+// ID. Failed computations are not cached, and the entry of a volume is evicted when it is unpublished or
+// unstaged.
+type usageCache struct {
+	ttl time.Duration
+	now func() time.Time
+
+	mux     sync.Mutex
+	entries map[string]*usageCacheEntry
+}
+
+type usageCacheEntry struct {
+	usedBytes int64
+	expiry    time.Time
+}
+
+func newUsageCache(ttl time.Duration) *usageCache {
+	return &usageCache{
+		ttl:     ttl,
+		now:     time.Now,
+		entries: make(map[string]*usageCacheEntry),
+	}
+}
+
+// get returns the unexpired used bytes of the volume, or computes them. A nil cache computes the used
+// bytes on every call.
+func (c *usageCache) get(volumeID string, compute func() (int64, error)) (int64, error) {
+	if c == nil {
+		return compute()
+	}
+	c.mux.Lock()
+	entry, ok := c.entries[volumeID]
+	c.mux.Unlock()
+	if ok && c.now().Before(entry.expiry) {
+		return entry.usedBytes, nil
+	}
+
+	usedBytes, err := compute()
+	if err != nil {
+		return 0, err
+	}
+	c.mux.Lock()
+	c.entries[volumeID] = &usageCacheEntry{usedBytes: usedBytes, expiry: c.now().Add(c.ttl)}
+	c.mux.Unlock()
+	return usedBytes, nil
+}
+
+// evict forgets the used bytes of the volume.
+func (c *usageCache) evict(volumeID string) {
+	if c == nil {
+		return
+	}
+	c.mux.Lock()
+	defer c.mux.Unlock()
+	delete(c.entries, volumeID)
+}
@@ -38,6 +38,13 @@ const (
 	totalIDElements // Always last
 )
 
+// Sub-directory volume id is of form {provisioningMode}/{location}/{instanceName}/{volume}/{subDirectory},
+// the sub-directory is the last element of the instance volume id.
+const (
+	idSubDirectory              = totalIDElements
+	totalSubDirectoryIDElements = totalIDElements + 1
+)
+
 // getVolumeIDFromFileInstance generates an id to uniquely identify the GCFS volume.
 // This id is used for volume deletion.
 func getVolumeIDFromFileInstance(obj *file.ServiceInstance, mode string) string {
@@ -80,6 +87,37 @@ func getFileInstanceFromID(id string) (*file.ServiceInstance, string, error) {
 	}, tokens[idProvisioningMode], nil
 }
 
+// getSubDirectoryVolumeID generates the id of a volume provisioned as a sub-directory of the share of
+// an instance.
+func getSubDirectoryVolumeID(obj *file.ServiceInstance, subDirectory string) string {
+	return getVolumeIDFromFileInstance(obj, modeSubDirectory) + "/" + subDirectory
+}
+
+// parseSubDirectoryVolumeID returns the instance and the sub-directory of a sub-directory volume id.
+func parseSubDirectoryVolumeID(id string) (*file.ServiceInstance, string, error) {
+	tokens := strings.Split(id, "/")
+	if len(tokens) != totalSubDirectoryIDElements || tokens[idProvisioningMode] != modeSubDirectory {
+		return nil, "", fmt.Errorf("volume id %q unexpected format: got %v tokens", id, len(tokens))
+	}
+	for _, token := range tokens {
+		if token == "" {
+			return nil, "", fmt.Errorf("invalid volume id %v", id)
+		}
+	}
+	if !isValidSubDirectory(tokens[idSubDirectory]) {
+		return nil, "", fmt.Errorf("invalid sub-directory %q in volume id %v", tokens[idSubDirectory], id)
+	}
+	return &file.ServiceInstance{
+		Location: tokens[idLocation],
+		Name:     tokens[idInstance],
+		Volume:   file.Volume{Name: tokens[idVolume]},
+	}, tokens[idSubDirectory], nil
+}
+
+func isSubDirectoryVolId(volId string) bool {
+	return strings.HasPrefix(volId, modeSubDirectory+"/")
+}
+
 func generateMultishareVolumeIdFromShare(instancePrefix string, s *file.Share) (string, error) {
 	if instancePrefix == "" {
 		return "", fmt.Errorf("invalid instance prefix")
@@ -0,0 +1,83 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"k8s.io/klog/v2"
+)
+
+// volumeMountGroupMarkerFile records the group the root directory of a volume was set to, so that the
+// group is set once per volume rather than on every stage and publish.
+const volumeMountGroupMarkerFile = ".filestore-csi-volume-mount-group"
+
+// parseVolumeMountGroup returns the gid of the volume mount group of a volume capability, or -1 if it
+// is not set.
+func parseVolumeMountGroup(vc *csi.VolumeCapability) (int, error) {
+	group := vc.GetMount().GetVolumeMountGroup()
+	if group == "" {
+		return -1, nil
+	}
+	gid, err := strconv.Atoi(group)
+	if err != nil || gid < 0 {
+		return 0, fmt.Errorf("volume mount group %q is not a gid", group)
+	}
+	return gid, nil
+}
+
+// isReadOnlyAccessMode returns whether the volume of a capability is mounted read-only, and its group
+// can't be set.
+func isReadOnlyAccessMode(vc *csi.VolumeCapability) bool {
+	switch vc.GetAccessMode().GetMode() {
+	case csi.VolumeCapability_AccessMode_SINGLE_NODE_READER_ONLY, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY:
+		return true
+	}
+	return false
+}
+
+// setVolumeMountGroup makes the root directory of a mounted volume owned by the group gid, writable by
+// the group and setgid, so that the files created in the volume inherit the group. As the node advertises
+// VOLUME_MOUNT_GROUP, the kubelet does this instead of changing the ownership of every file of the volume
+// to the fsGroup of the pod, which takes a long time on large volumes. The existing files keep their group.
+func setVolumeMountGroup(path string, gid int) error {
+	group := strconv.Itoa(gid)
+	marker := filepath.Join(path, volumeMountGroupMarkerFile)
+	if data, err := os.ReadFile(marker); err == nil && strings.TrimSpace(string(data)) == group {
+		return nil
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if err := os.Chown(path, -1, gid); err != nil {
+		return err
+	}
+	if err := os.Chmod(path, info.Mode()&(os.ModePerm|os.ModeSticky)|0070|os.ModeSetgid); err != nil {
+		return err
+	}
+	if err := os.WriteFile(marker, []byte(group), 0644); err != nil {
+		return err
+	}
+	klog.V(4).Infof("Set the group of volume mounted at %s to %d", path, gid)
+	return nil
+}
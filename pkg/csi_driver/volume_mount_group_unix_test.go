@@ -0,0 +1,162 @@
+//go:build !windows
+
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+
+	csi "github.com/container-storage-interface/spec/lib/go/csi"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func volumeMountGroupCapability(group string, mode csi.VolumeCapability_AccessMode_Mode) *csi.VolumeCapability {
+	return &csi.VolumeCapability{
+		AccessType: &csi.VolumeCapability_Mount{
+			Mount: &csi.VolumeCapability_MountVolume{VolumeMountGroup: group},
+		},
+		AccessMode: &csi.VolumeCapability_AccessMode{Mode: mode},
+	}
+}
+
+// validateVolumeMountGroup checks that the root directory of a volume is owned by the group gid, writable by
+// the group and setgid.
+func validateVolumeMountGroup(t *testing.T, path string, gid int) {
+	t.Helper()
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat %s: %v", path, err)
+	}
+	if got := int(info.Sys().(*syscall.Stat_t).Gid); got != gid {
+		t.Errorf("got group %d of %s, expected %d", got, path, gid)
+	}
+	if info.Mode()&os.ModeSetgid == 0 || info.Mode().Perm()&0070 != 0070 {
+		t.Errorf("got mode %v of %s, expected a setgid directory writable by the group", info.Mode(), path)
+	}
+}
+
+func TestSetVolumeMountGroup(t *testing.T) {
+	path := t.TempDir()
+	if err := os.Chmod(path, 0700); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	gid := os.Getegid()
+	if err := setVolumeMountGroup(path, gid); err != nil {
+		t.Fatalf("Failed to set volume mount group: %v", err)
+	}
+	validateVolumeMountGroup(t, path, gid)
+	if marker, err := os.ReadFile(filepath.Join(path, volumeMountGroupMarkerFile)); err != nil || string(marker) != strconv.Itoa(gid) {
+		t.Errorf("got marker %q, error %v, expected %d", marker, err, gid)
+	}
+
+	// The group is set once per volume, the permissions changed since are kept.
+	if err := os.Chmod(path, 0750); err != nil {
+		t.Fatalf("Failed to chmod: %v", err)
+	}
+	if err := setVolumeMountGroup(path, gid); err != nil {
+		t.Fatalf("Failed to set volume mount group again: %v", err)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0750 {
+		t.Errorf("got mode %v, error %v, expected the group to be set once", info.Mode(), err)
+	}
+}
+
+func TestParseVolumeMountGroup(t *testing.T) {
+	cases := []struct {
+		group     string
+		expected  int
+		expectErr bool
+	}{
+		{group: "", expected: -1},
+		{group: "4000", expected: 4000},
+		{group: "0", expected: 0},
+		{group: "users", expectErr: true},
+		{group: "-1", expectErr: true},
+	}
+	for _, tc := range cases {
+		gid, err := parseVolumeMountGroup(volumeMountGroupCapability(tc.group, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER))
+		if tc.expectErr {
+			if err == nil {
+				t.Errorf("group %q: expected error, got gid %d", tc.group, gid)
+			}
+			continue
+		}
+		if err != nil || gid != tc.expected {
+			t.Errorf("group %q: got gid %d, error %v, expected %d", tc.group, gid, err, tc.expected)
+		}
+	}
+}
+
+func TestNodeVolumeMountGroup(t *testing.T) {
+	ns := initTestNodeServer(t).ns
+	gid := os.Getegid()
+	group := strconv.Itoa(gid)
+	stagingPath := filepath.Join(t.TempDir(), "staging")
+
+	if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volumeMountGroupCapability(group, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		VolumeContext:     testVolumeAttributes,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	validateVolumeMountGroup(t, stagingPath, gid)
+
+	targetPath := filepath.Join(t.TempDir(), "target")
+	if _, err := ns.NodePublishVolume(context.Background(), &csi.NodePublishVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		TargetPath:        targetPath,
+		VolumeCapability:  volumeMountGroupCapability(group, csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		VolumeContext:     testVolumeAttributes,
+	}); err != nil {
+		t.Fatalf("NodePublishVolume failed: %v", err)
+	}
+	validateVolumeMountGroup(t, targetPath, gid)
+
+	// The group of a read-only volume is not set.
+	readOnlyPath := filepath.Join(t.TempDir(), "readonly")
+	if _, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          "modeInstance/us-central1-c/other/vol1",
+		StagingTargetPath: readOnlyPath,
+		VolumeCapability:  volumeMountGroupCapability(group, csi.VolumeCapability_AccessMode_MULTI_NODE_READER_ONLY),
+		VolumeContext:     testVolumeAttributes,
+	}); err != nil {
+		t.Fatalf("NodeStageVolume failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(readOnlyPath, volumeMountGroupMarkerFile)); !os.IsNotExist(err) {
+		t.Errorf("group of read-only volume was set: %v", err)
+	}
+
+	_, err := ns.NodeStageVolume(context.Background(), &csi.NodeStageVolumeRequest{
+		VolumeId:          testVolumeID,
+		StagingTargetPath: stagingPath,
+		VolumeCapability:  volumeMountGroupCapability("users", csi.VolumeCapability_AccessMode_MULTI_NODE_MULTI_WRITER),
+		VolumeContext:     testVolumeAttributes,
+	})
+	if status.Code(err) != codes.InvalidArgument {
+		t.Errorf("got error %v staging a volume with an invalid group, expected InvalidArgument", err)
+	}
+}
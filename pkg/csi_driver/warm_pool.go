@@ -0,0 +1,321 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/klog/v2"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider/file"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+// Warm pool StorageClass parameters of the stateful multishare controller.
+const (
+	paramMinSpareInstances       = "min-spare-instances"
+	paramMinFreeShares           = "min-free-shares"
+	paramSpareInstanceIdlePeriod = "spare-instance-idle-period"
+
+	defaultSpareInstanceIdlePeriod = time.Hour
+)
+
+// warmPool is the buffer of empty instances kept ahead of demand for the instances of an
+// instance-storageclass-label, so that the shares overflowing the pool don't wait for an instance to be
+// created.
+type warmPool struct {
+	storageClass *storagev1.StorageClass
+	// region is the region the spare instances are created in.
+	region    string
+	minSpares int
+	// minFreeShares is the number of shares the instances of the pool must have room for. Spare instances
+	// are added beyond minSpares until the instances with shares and the spares have room for them.
+	minFreeShares int
+	// maxShares is the number of shares of an instance of the pool.
+	maxShares int
+	// idlePeriod is how long the empty instances beyond the spares are kept before being deleted.
+	idlePeriod time.Duration
+}
+
+// parseWarmPoolParams returns the number of spare instances, the number of free shares and the idle period
+// of the warm pool set by the parameters of a StorageClass. The pool is disabled if both numbers are 0.
+func parseWarmPoolParams(params map[string]string) (int, int, time.Duration, error) {
+	parseCount := func(key string) (int, error) {
+		v, ok := params[key]
+		if !ok {
+			return 0, nil
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s %q, must be a non negative integer", key, v)
+		}
+		return n, nil
+	}
+	minSpares, err := parseCount(paramMinSpareInstances)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	minFreeShares, err := parseCount(paramMinFreeShares)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	idlePeriod := defaultSpareInstanceIdlePeriod
+	if v, ok := params[paramSpareInstanceIdlePeriod]; ok {
+		var err error
+		idlePeriod, err = time.ParseDuration(v)
+		if err != nil || idlePeriod < 0 {
+			return 0, 0, 0, fmt.Errorf("invalid %s %q, must be a non negative duration", paramSpareInstanceIdlePeriod, v)
+		}
+	}
+	return minSpares, minFreeShares, idlePeriod, nil
+}
+
+// warmPoolMaxShares returns the number of shares of an instance of a StorageClass, set by its max volume
+// size. The parameter is validated when the volumes are created.
+func warmPoolMaxShares(params map[string]string) int {
+	v, ok := params[paramMaxVolumeSize]
+	if !ok {
+		return util.MaxSharesPerInstance
+	}
+	size, err := resource.ParseQuantity(v)
+	if err != nil {
+		return util.MaxSharesPerInstance
+	}
+	maxShares, err := getSharesPerInstance(size.Value())
+	if err != nil {
+		return util.MaxSharesPerInstance
+	}
+	return maxShares
+}
+
+// warmPoolRegion returns the region the spare instances of a StorageClass are created in: the region of the
+// cluster if the allowed topologies of the StorageClass permit it, or else the first region they permit.
+func warmPoolRegion(sc *storagev1.StorageClass, clusterZone string) (string, error) {
+	clusterRegion, err := util.GetRegionFromZone(clusterZone)
+	if err != nil {
+		return "", err
+	}
+	var regions []string
+	for _, term := range sc.AllowedTopologies {
+		for _, expr := range term.MatchLabelExpressions {
+			for _, v := range expr.Values {
+				region, err := getRegionFromSegment(map[string]string{expr.Key: v})
+				if err != nil {
+					return "", fmt.Errorf("invalid allowed topology of storage class %q: %w", sc.Name, err)
+				}
+				regions = append(regions, region)
+			}
+		}
+	}
+	if len(regions) == 0 {
+		return clusterRegion, nil
+	}
+	sort.Strings(regions)
+	for _, region := range regions {
+		if region == clusterRegion {
+			return region, nil
+		}
+	}
+	return regions[0], nil
+}
+
+// warmPools returns the warm pools of the StorageClasses of the driver by instance-storageclass-label. If
+// several StorageClasses have the same label, the pool with the most spare instances, then with the most
+// free shares, is used.
+func (recon *MultishareReconciler) warmPools() map[string]*warmPool {
+	pools := make(map[string]*warmPool)
+	storageClasses, err := recon.scLister.List(labels.Everything())
+	if err != nil {
+		klog.Errorf("Failed to list storage classes for warm pools: %v", err)
+		return pools
+	}
+	for _, sc := range storageClasses {
+		scTag := sc.Parameters[ParamMultishareInstanceScLabel]
+		if sc.Provisioner != recon.config.Name || scTag == "" {
+			continue
+		}
+		minSpares, minFreeShares, idlePeriod, err := parseWarmPoolParams(sc.Parameters)
+		if err != nil {
+			klog.Errorf("Ignoring the warm pool of storage class %q: %v", sc.Name, err)
+			continue
+		}
+		if minSpares == 0 && minFreeShares == 0 {
+			continue
+		}
+		if pool, ok := pools[scTag]; ok && (pool.minSpares > minSpares || pool.minSpares == minSpares && pool.minFreeShares >= minFreeShares) {
+			continue
+		}
+		region, err := warmPoolRegion(sc, recon.cloud.Zone)
+		if err != nil {
+			klog.Errorf("Ignoring the warm pool of storage class %q: %v", sc.Name, err)
+			continue
+		}
+		pools[scTag] = &warmPool{
+			storageClass:  sc,
+			region:        region,
+			minSpares:     minSpares,
+			minFreeShares: minFreeShares,
+			maxShares:     warmPoolMaxShares(sc.Parameters),
+			idlePeriod:    idlePeriod,
+		}
+	}
+	return pools
+}
+
+// maintainWarmPools keeps the spare instances of the warm pools and creates the missing ones. It returns
+// the URIs of the empty instances to keep, the spare instances and the surplus instances that have been
+// empty for less than the idle period of their pool. The other empty instances are deleted by
+// deleteOrResizeInstances. Only the instances in the region of a pool are its spares, and count for its
+// free shares.
+func (recon *MultishareReconciler) maintainWarmPools(instanceInfos map[string]*v1.InstanceInfo) map[string]bool {
+	now := recon.now()
+	pools := recon.warmPools()
+	kept := make(map[string]bool)
+
+	empty := make(map[string][]string)
+	freeShares := make(map[string]int)
+	for instanceURI, instanceInfo := range instanceInfos {
+		if instanceInfo.DeletionTimestamp != nil {
+			continue
+		}
+		scTag := instanceInfo.Labels[ParamMultishareInstanceScLabel]
+		pool, ok := pools[scTag]
+		if instanceInfo.Status != nil && len(instanceInfo.Status.ShareNames) != 0 {
+			instanceInfos[instanceURI] = recon.updateSpareStatus(instanceInfo, false, nil)
+			if ok && instanceRegion(instanceURI) == pool.region {
+				freeShares[scTag] += max(pool.maxShares-len(instanceInfo.Status.ShareNames), 0)
+			}
+			continue
+		}
+		// InstanceInfos without a Status are either spare instances not created yet or reconstructed from an
+		// instance just found, they are counted as empty until their shares are known.
+		if ok {
+			empty[scTag] = append(empty[scTag], instanceURI)
+		}
+	}
+
+	for scTag, pool := range pools {
+		instanceURIs := empty[scTag]
+		spares := pool.minSpares
+		if missing := pool.minFreeShares - freeShares[scTag]; missing > 0 && pool.maxShares > 0 {
+			spares = max(spares, (missing+pool.maxShares-1)/pool.maxShares)
+		}
+		// Keep the instances in the region of the pool first, then the ones that already are spares, then the
+		// ready ones, to avoid churn.
+		sort.Slice(instanceURIs, func(i, j int) bool {
+			if inA, inB := instanceRegion(instanceURIs[i]) == pool.region, instanceRegion(instanceURIs[j]) == pool.region; inA != inB {
+				return inA
+			}
+			a, b := instanceInfos[instanceURIs[i]].Status, instanceInfos[instanceURIs[j]].Status
+			if spareA, spareB := a != nil && a.Spare, b != nil && b.Spare; spareA != spareB {
+				return spareA
+			}
+			if readyA, readyB := a != nil && a.InstanceStatus == v1.READY, b != nil && b.InstanceStatus == v1.READY; readyA != readyB {
+				return readyA
+			}
+			return instanceURIs[i] < instanceURIs[j]
+		})
+
+		spareCount := 0
+		for _, instanceURI := range instanceURIs {
+			instanceInfo := instanceInfos[instanceURI]
+			spare := spareCount < spares && instanceRegion(instanceURI) == pool.region
+			if spare {
+				spareCount++
+			}
+			emptySince := &metav1.Time{Time: now}
+			if instanceInfo.Status != nil && instanceInfo.Status.EmptySince != nil {
+				emptySince = instanceInfo.Status.EmptySince
+			}
+			if spare || now.Sub(emptySince.Time) < pool.idlePeriod {
+				kept[instanceURI] = true
+			} else {
+				klog.Infof("Surplus spare instance %q of warm pool %q has been idle since %v", instanceURI, scTag, emptySince)
+			}
+			instanceInfos[instanceURI] = recon.updateSpareStatus(instanceInfo, spare, emptySince)
+		}
+
+		for ; spareCount < spares; spareCount++ {
+			instanceInfo, err := recon.generateSpareInstanceInfo(scTag, pool)
+			if err != nil {
+				klog.Errorf("Failed to create spare instanceInfo for warm pool %q: %v", scTag, err)
+				break
+			}
+			instanceURI := util.InstanceInfoNameToInstanceURI(instanceInfo.Name)
+			klog.Infof("Created spare instanceInfo %q for warm pool %q", instanceInfo.Name, scTag)
+			instanceInfos[instanceURI] = instanceInfo
+			kept[instanceURI] = true
+		}
+	}
+	return kept
+}
+
+// instanceRegion returns the region of an instance URI, or "" if it is invalid.
+func instanceRegion(instanceURI string) string {
+	_, location, _, err := util.ParseInstanceURI(instanceURI)
+	if err != nil {
+		return ""
+	}
+	return location
+}
+
+// generateSpareInstanceInfo creates the instanceInfo of a new spare instance of a warm pool, in the region
+// of the pool.
+func (recon *MultishareReconciler) generateSpareInstanceInfo(scTag string, pool *warmPool) (*v1.InstanceInfo, error) {
+	instanceURI, err := file.GenerateMultishareInstanceURI(&file.MultishareInstance{
+		Project:  recon.cloud.Project,
+		Location: pool.region,
+		Name:     util.NewMultishareInstancePrefix + string(uuid.NewUUID()),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return recon.generateInstanceInfo(instanceURI, scTag, pool.storageClass.Parameters)
+}
+
+// updateSpareStatus updates the warm pool status of an instanceInfo if it changed. The instanceInfo is
+// returned unchanged if the update fails, it is retried in the next reconciliation.
+func (recon *MultishareReconciler) updateSpareStatus(instanceInfo *v1.InstanceInfo, spare bool, emptySince *metav1.Time) *v1.InstanceInfo {
+	if instanceInfo.Status == nil && !spare && emptySince == nil {
+		return instanceInfo
+	}
+	if instanceInfo.Status != nil && instanceInfo.Status.Spare == spare && instanceInfo.Status.EmptySince.Equal(emptySince) {
+		return instanceInfo
+	}
+	instanceInfoClone := instanceInfo.DeepCopy()
+	if instanceInfoClone.Status == nil {
+		instanceInfoClone.Status = &v1.InstanceInfoStatus{}
+	}
+	instanceInfoClone.Status.Spare = spare
+	instanceInfoClone.Status.EmptySince = emptySince
+	klog.V(4).Infof("Updating warm pool status of instanceInfo %q to spare %t, empty since %v", instanceInfo.Name, spare, emptySince)
+	updated, err := recon.updateInstanceInfoStatus(context.TODO(), instanceInfoClone)
+	if err != nil {
+		klog.Errorf("Failed to update warm pool status of instanceInfo %q: %v", instanceInfo.Name, err)
+		return instanceInfo
+	}
+	return updated
+}
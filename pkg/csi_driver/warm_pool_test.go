@@ -0,0 +1,338 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package driver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	storageListers "k8s.io/client-go/listers/storage/v1"
+	"k8s.io/client-go/tools/cache"
+	v1 "sigs.k8s.io/gcp-filestore-csi-driver/pkg/apis/multishare/v1"
+	fsfake "sigs.k8s.io/gcp-filestore-csi-driver/pkg/client/clientset/versioned/fake"
+	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
+	"sigs.k8s.io/gcp-filestore-csi-driver/pkg/util"
+)
+
+func TestParseWarmPoolParams(t *testing.T) {
+	cases := []struct {
+		name               string
+		params             map[string]string
+		expectedSpares     int
+		expectedFreeShares int
+		expectedIdlePeriod time.Duration
+		expectErr          bool
+	}{
+		{
+			name:               "no warm pool",
+			params:             map[string]string{},
+			expectedIdlePeriod: defaultSpareInstanceIdlePeriod,
+		},
+		{
+			name:               "spares with default idle period",
+			params:             map[string]string{paramMinSpareInstances: "2"},
+			expectedSpares:     2,
+			expectedIdlePeriod: defaultSpareInstanceIdlePeriod,
+		},
+		{
+			name:               "spares with idle period",
+			params:             map[string]string{paramMinSpareInstances: "1", paramSpareInstanceIdlePeriod: "30m"},
+			expectedSpares:     1,
+			expectedIdlePeriod: 30 * time.Minute,
+		},
+		{
+			name:               "free shares",
+			params:             map[string]string{paramMinFreeShares: "15"},
+			expectedFreeShares: 15,
+			expectedIdlePeriod: defaultSpareInstanceIdlePeriod,
+		},
+		{
+			name:      "invalid free shares",
+			params:    map[string]string{paramMinFreeShares: "many"},
+			expectErr: true,
+		},
+		{
+			name:      "negative spares",
+			params:    map[string]string{paramMinSpareInstances: "-1"},
+			expectErr: true,
+		},
+		{
+			name:      "invalid idle period",
+			params:    map[string]string{paramMinSpareInstances: "1", paramSpareInstanceIdlePeriod: "1 day"},
+			expectErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			spares, freeShares, idlePeriod, err := parseWarmPoolParams(tc.params)
+			if tc.expectErr {
+				if err == nil {
+					t.Errorf("expected error, got spares %d and idle period %v", spares, idlePeriod)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if spares != tc.expectedSpares || freeShares != tc.expectedFreeShares || idlePeriod != tc.expectedIdlePeriod {
+				t.Errorf("got spares %d, free shares %d and idle period %v, expected %d, %d and %v", spares, freeShares, idlePeriod, tc.expectedSpares, tc.expectedFreeShares, tc.expectedIdlePeriod)
+			}
+		})
+	}
+}
+
+func TestMaintainWarmPools(t *testing.T) {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	const otherPool = "other-pool"
+	storageClass := func(name, scTag string, params map[string]string) *storagev1.StorageClass {
+		sc := &storagev1.StorageClass{
+			ObjectMeta:  metav1.ObjectMeta{Name: name},
+			Provisioner: "test-driver",
+			Parameters:  map[string]string{paramMultishare: "true", ParamMultishareInstanceScLabel: scTag},
+		}
+		for k, v := range params {
+			sc.Parameters[k] = v
+		}
+		return sc
+	}
+	instanceInfoInRegion := func(region, name, scTag string, spare bool, emptyFor time.Duration, shares ...string) *v1.InstanceInfo {
+		ii := &v1.InstanceInfo{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:       util.InstanceURIToInstanceInfoName(instanceURI(testProject, region, name)),
+				Namespace:  util.ManagedFilestoreCSINamespace,
+				Finalizers: []string{util.FilestoreResourceCleanupFinalizer},
+				Labels:     map[string]string{ParamMultishareInstanceScLabel: scTag},
+			},
+			Spec:   v1.InstanceInfoSpec{CapacityBytes: util.MinMultishareInstanceSizeBytes},
+			Status: &v1.InstanceInfoStatus{ShareNames: shares, InstanceStatus: v1.READY, Spare: spare},
+		}
+		if emptyFor > 0 {
+			ii.Status.EmptySince = &metav1.Time{Time: now.Add(-emptyFor)}
+		}
+		return ii
+	}
+	instanceInfo := func(name, scTag string, spare bool, emptyFor time.Duration, shares ...string) *v1.InstanceInfo {
+		return instanceInfoInRegion(testRegion, name, scTag, spare, emptyFor, shares...)
+	}
+	const otherRegion = "us-east1"
+	allowedRegions := func(sc *storagev1.StorageClass, regions ...string) *storagev1.StorageClass {
+		sc.AllowedTopologies = []corev1.TopologySelectorTerm{{
+			MatchLabelExpressions: []corev1.TopologySelectorLabelRequirement{{Key: TopologyKeyRegion, Values: regions}},
+		}}
+		return sc
+	}
+
+	cases := []struct {
+		name           string
+		storageClasses []*storagev1.StorageClass
+		instanceInfos  []*v1.InstanceInfo
+		expectedKept   []string
+		// expectedSpares are the instances expected to be spares, new spares are counted in expectedNewSpares.
+		expectedSpares    []string
+		expectedNewSpares int
+		// expectedRegion is the region of the new spares, the region of the cluster by default.
+		expectedRegion string
+	}{
+		{
+			name:           "no warm pool",
+			storageClasses: []*storagev1.StorageClass{storageClass("sc", testInstanceScPrefix, nil)},
+			instanceInfos: []*v1.InstanceInfo{
+				instanceInfo("empty", testInstanceScPrefix, false, 0),
+				instanceInfo("used", testInstanceScPrefix, false, 0, "share-1"),
+			},
+		},
+		{
+			name:              "spares created",
+			storageClasses:    []*storagev1.StorageClass{storageClass("sc", testInstanceScPrefix, map[string]string{paramMinSpareInstances: "2"})},
+			instanceInfos:     []*v1.InstanceInfo{instanceInfo("used", testInstanceScPrefix, false, 0, "share-1")},
+			expectedNewSpares: 2,
+		},
+		{
+			name:           "spare taken by a share is replaced",
+			storageClasses: []*storagev1.StorageClass{storageClass("sc", testInstanceScPrefix, map[string]string{paramMinSpareInstances: "1"})},
+			instanceInfos: []*v1.InstanceInfo{
+				instanceInfo("taken", testInstanceScPrefix, true, time.Hour, "share-1"),
+			},
+			expectedNewSpares: 1,
+		},
+		{
+			name: "largest warm pool of a label",
+			storageClasses: []*storagev1.StorageClass{
+				storageClass("sc-1", testInstanceScPrefix, map[string]string{paramMinSpareInstances: "1"}),
+				storageClass("sc-2", testInstanceScPrefix, map[string]string{paramMinSpareInstances: "2"}),
+			},
+			instanceInfos:     []*v1.InstanceInfo{instanceInfo("spare", testInstanceScPrefix, true, time.Hour)},
+			expectedKept:      []string{"spare"},
+			expectedSpares:    []string{"spare"},
+			expectedNewSpares: 1,
+		},
+		{
+			name:           "surplus deleted after idle period",
+			storageClasses: []*storagev1.StorageClass{storageClass("sc", testInstanceScPrefix, map[string]string{paramMinSpareInstances: "1", paramSpareInstanceIdlePeriod: "1h"})},
+			instanceInfos: []*v1.InstanceInfo{
+				instanceInfo("idle", testInstanceScPrefix, false, 2*time.Hour),
+				instanceInfo("recent", testInstanceScPrefix, false, 10*time.Minute),
+				instanceInfo("spare", testInstanceScPrefix, true, 3*time.Hour),
+				instanceInfo("just-emptied", testInstanceScPrefix, false, 0),
+			},
+			expectedKept:   []string{"recent", "spare", "just-emptied"},
+			expectedSpares: []string{"spare"},
+		},
+		{
+			name:           "spares for free shares",
+			storageClasses: []*storagev1.StorageClass{storageClass("sc", testInstanceScPrefix, map[string]string{paramMinFreeShares: "25"})},
+			instanceInfos: []*v1.InstanceInfo{
+				instanceInfo("used", testInstanceScPrefix, false, 0, "share-1", "share-2"),
+			},
+			// The instance with shares has room for 8 shares, 2 spares of 10 shares are needed for 25.
+			expectedNewSpares: 2,
+		},
+		{
+			name:           "free shares of the instances with shares",
+			storageClasses: []*storagev1.StorageClass{storageClass("sc", testInstanceScPrefix, map[string]string{paramMinFreeShares: "5", paramSpareInstanceIdlePeriod: "0s"})},
+			instanceInfos: []*v1.InstanceInfo{
+				instanceInfo("used", testInstanceScPrefix, false, 0, "share-1"),
+				instanceInfo("empty", testInstanceScPrefix, false, time.Hour),
+			},
+		},
+		{
+			name:           "free shares of instances of smaller volumes",
+			storageClasses: []*storagev1.StorageClass{storageClass("sc", testInstanceScPrefix, map[string]string{paramMinFreeShares: "90", paramMaxVolumeSize: "128Gi"})},
+			instanceInfos: []*v1.InstanceInfo{
+				instanceInfo("used", testInstanceScPrefix, false, 0, "share-1"),
+			},
+			// An instance of volumes of 128GiB has room for 80 shares.
+			expectedNewSpares: 1,
+		},
+		{
+			name:              "spares in the allowed region",
+			storageClasses:    []*storagev1.StorageClass{allowedRegions(storageClass("sc", testInstanceScPrefix, map[string]string{paramMinSpareInstances: "1", paramSpareInstanceIdlePeriod: "0s"}), otherRegion)},
+			instanceInfos:     []*v1.InstanceInfo{instanceInfo("cluster-region", testInstanceScPrefix, true, time.Hour)},
+			expectedNewSpares: 1,
+			expectedRegion:    otherRegion,
+		},
+		{
+			name:           "spares in the region of the cluster if allowed",
+			storageClasses: []*storagev1.StorageClass{allowedRegions(storageClass("sc", testInstanceScPrefix, map[string]string{paramMinSpareInstances: "1", paramSpareInstanceIdlePeriod: "0s"}), otherRegion, testRegion)},
+			instanceInfos: []*v1.InstanceInfo{
+				instanceInfoInRegion(otherRegion, "other-region", testInstanceScPrefix, true, time.Hour),
+				instanceInfo("cluster-region", testInstanceScPrefix, false, time.Hour),
+			},
+			expectedKept:   []string{"cluster-region"},
+			expectedSpares: []string{"cluster-region"},
+		},
+		{
+			name: "other pools untouched",
+			storageClasses: []*storagev1.StorageClass{
+				storageClass("sc", testInstanceScPrefix, map[string]string{paramMinSpareInstances: "1"}),
+				storageClass("other", otherPool, nil),
+			},
+			instanceInfos: []*v1.InstanceInfo{
+				instanceInfo("spare", testInstanceScPrefix, false, 0),
+				instanceInfo("other", otherPool, false, 0),
+			},
+			expectedKept:   []string{"spare"},
+			expectedSpares: []string{"spare"},
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+			for _, sc := range tc.storageClasses {
+				indexer.Add(sc)
+			}
+			var objects []runtime.Object
+			instanceInfos := map[string]*v1.InstanceInfo{}
+			for _, ii := range tc.instanceInfos {
+				objects = append(objects, ii)
+				instanceInfos[util.InstanceInfoNameToInstanceURI(ii.Name)] = ii
+			}
+			cloudProvider, err := cloud.NewFakeCloud()
+			if err != nil {
+				t.Fatalf("Failed to get cloud provider: %v", err)
+			}
+			clientset := fsfake.NewSimpleClientset(objects...)
+			recon := &MultishareReconciler{
+				clientset: clientset,
+				config:    &GCFSDriverConfig{Name: "test-driver"},
+				cloud:     cloudProvider,
+				scLister:  storageListers.NewStorageClassLister(indexer),
+				now:       func() time.Time { return now },
+			}
+
+			kept := recon.maintainWarmPools(instanceInfos)
+
+			expectedKept := map[string]bool{}
+			for _, name := range tc.expectedKept {
+				expectedKept[instanceURI(testProject, testRegion, name)] = true
+			}
+			list, err := clientset.MultishareV1().InstanceInfos(util.ManagedFilestoreCSINamespace).List(context.TODO(), metav1.ListOptions{})
+			if err != nil {
+				t.Fatalf("failed to list instanceInfos: %v", err)
+			}
+			expectedSpares := map[string]bool{}
+			for _, name := range tc.expectedSpares {
+				expectedSpares[instanceURI(testProject, testRegion, name)] = true
+			}
+			newSpares := 0
+			for _, ii := range list.Items {
+				uri := util.InstanceInfoNameToInstanceURI(ii.Name)
+				if _, ok := instanceInfos[uri]; !ok {
+					t.Errorf("instanceInfo %q is missing in the instanceInfos", ii.Name)
+				}
+				existing := false
+				for _, orig := range tc.instanceInfos {
+					existing = existing || orig.Name == ii.Name
+				}
+				if !existing {
+					newSpares++
+					if ii.Spec.StorageClassName == "" || ii.Labels[ParamMultishareInstanceScLabel] != testInstanceScPrefix {
+						t.Errorf("new spare instanceInfo %q has storage class %q and labels %v", ii.Name, ii.Spec.StorageClassName, ii.Labels)
+					}
+					expectedRegion := tc.expectedRegion
+					if expectedRegion == "" {
+						expectedRegion = testRegion
+					}
+					if region := instanceRegion(uri); region != expectedRegion {
+						t.Errorf("new spare instanceInfo %q is in region %q, expected %q", ii.Name, region, expectedRegion)
+					}
+					expectedKept[uri] = true
+					continue
+				}
+				spare := ii.Status != nil && ii.Status.Spare
+				if spare != expectedSpares[uri] {
+					t.Errorf("instanceInfo %q has spare %t, expected %t", ii.Name, spare, expectedSpares[uri])
+				}
+				if len(ii.Status.ShareNames) != 0 && ii.Status.EmptySince != nil {
+					t.Errorf("instanceInfo %q with shares has emptySince %v", ii.Name, ii.Status.EmptySince)
+				}
+			}
+			if newSpares != tc.expectedNewSpares {
+				t.Errorf("got %d new spare instanceInfos, expected %d", newSpares, tc.expectedNewSpares)
+			}
+			if !reflect.DeepEqual(kept, expectedKept) {
+				t.Errorf("got kept instances %v, expected %v", kept, expectedKept)
+			}
+		})
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"os"
 	"time"
 
+	"github.com/prometheus/procfs"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
 	"k8s.io/component-base/metrics"
@@ -133,6 +134,12 @@ func (mm *MetricsManager) RegisterKubeAPIDurationMetric() {
 	mm.registry.MustRegister(kubeAPIDurationMilliseconds)
 }
 
+// RegisterNFSMountStatsCollector registers the collector of the NFS client statistics of the volumes
+// staged on the node.
+func (mm *MetricsManager) RegisterNFSMountStatsCollector(stagedVolumes StagedVolumesFunc) {
+	mm.registry.CustomMustRegister(newNFSMountStatsCollector(procfs.DefaultMountPoint, stagedVolumes))
+}
+
 func (mm *MetricsManager) registerComponentVersionMetric() {
 	mm.registry.MustRegister(gkeComponentVersion)
 }
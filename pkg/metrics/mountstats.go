@@ -0,0 +1,147 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"github.com/prometheus/procfs"
+	"k8s.io/component-base/metrics"
+	"k8s.io/klog/v2"
+)
+
+const (
+	labelVolumeID  = "volume_id"
+	labelOperation = "operation"
+)
+
+var (
+	nfsReadBytes = metrics.NewDesc(
+		metrics.BuildFQName("", subSystem, "nfs_read_bytes_total"),
+		"Number of bytes read from the NFS server by the mount of a volume.",
+		[]string{labelVolumeID}, nil, metrics.ALPHA, "")
+	nfsWriteBytes = metrics.NewDesc(
+		metrics.BuildFQName("", subSystem, "nfs_write_bytes_total"),
+		"Number of bytes written to the NFS server by the mount of a volume.",
+		[]string{labelVolumeID}, nil, metrics.ALPHA, "")
+	nfsOperations = metrics.NewDesc(
+		metrics.BuildFQName("", subSystem, "nfs_operations_total"),
+		"Number of NFS operations performed by the mount of a volume.",
+		[]string{labelVolumeID, labelOperation}, nil, metrics.ALPHA, "")
+	nfsOperationRetransmissions = metrics.NewDesc(
+		metrics.BuildFQName("", subSystem, "nfs_operation_retransmissions_total"),
+		"Number of times an NFS operation of the mount of a volume was transmitted again.",
+		[]string{labelVolumeID, labelOperation}, nil, metrics.ALPHA, "")
+	nfsOperationMajorTimeouts = metrics.NewDesc(
+		metrics.BuildFQName("", subSystem, "nfs_operation_major_timeouts_total"),
+		"Number of major timeouts of the NFS operations of the mount of a volume.",
+		[]string{labelVolumeID, labelOperation}, nil, metrics.ALPHA, "")
+	nfsOperationErrors = metrics.NewDesc(
+		metrics.BuildFQName("", subSystem, "nfs_operation_errors_total"),
+		"Number of NFS operations of the mount of a volume that completed with an error.",
+		[]string{labelVolumeID, labelOperation}, nil, metrics.ALPHA, "")
+	nfsOperationRTTSeconds = metrics.NewDesc(
+		metrics.BuildFQName("", subSystem, "nfs_operation_rtt_seconds_total"),
+		"Total time from the transmission of the NFS operations of the mount of a volume until their response was received.",
+		[]string{labelVolumeID, labelOperation}, nil, metrics.ALPHA, "")
+	nfsOperationRequestSeconds = metrics.NewDesc(
+		metrics.BuildFQName("", subSystem, "nfs_operation_request_seconds_total"),
+		"Total time from the queueing of the NFS operations of the mount of a volume until they were handled.",
+		[]string{labelVolumeID, labelOperation}, nil, metrics.ALPHA, "")
+)
+
+// StagedVolumesFunc returns the volume IDs of the volumes staged on the node, by staging path.
+type StagedVolumesFunc func() map[string]string
+
+// nfsMountStatsCollector exports the NFS client statistics of the mounts of the volumes staged on the
+// node. The statistics of an NFS mount are shared by all the bind mounts of its staging path, and are
+// only reported for the staging path.
+type nfsMountStatsCollector struct {
+	metrics.BaseStableCollector
+
+	procPath      string
+	stagedVolumes StagedVolumesFunc
+}
+
+func newNFSMountStatsCollector(procPath string, stagedVolumes StagedVolumesFunc) *nfsMountStatsCollector {
+	return &nfsMountStatsCollector{
+		procPath:      procPath,
+		stagedVolumes: stagedVolumes,
+	}
+}
+
+// DescribeWithStability implements metrics.StableCollector.
+func (c *nfsMountStatsCollector) DescribeWithStability(ch chan<- *metrics.Desc) {
+	ch <- nfsReadBytes
+	ch <- nfsWriteBytes
+	ch <- nfsOperations
+	ch <- nfsOperationRetransmissions
+	ch <- nfsOperationMajorTimeouts
+	ch <- nfsOperationErrors
+	ch <- nfsOperationRTTSeconds
+	ch <- nfsOperationRequestSeconds
+}
+
+// CollectWithStability implements metrics.StableCollector.
+func (c *nfsMountStatsCollector) CollectWithStability(ch chan<- metrics.Metric) {
+	volumes := c.stagedVolumes()
+	if len(volumes) == 0 {
+		return
+	}
+	mounts, err := c.mountStats()
+	if err != nil {
+		klog.Errorf("Failed to read NFS mount statistics: %v", err)
+		return
+	}
+	for _, mount := range mounts {
+		volumeID, ok := volumes[mount.Mount]
+		if !ok {
+			continue
+		}
+		stats, ok := mount.Stats.(*procfs.MountStatsNFS)
+		if !ok {
+			continue
+		}
+		ch <- metrics.NewLazyConstMetric(nfsReadBytes, metrics.CounterValue, float64(stats.Bytes.ReadTotal), volumeID)
+		ch <- metrics.NewLazyConstMetric(nfsWriteBytes, metrics.CounterValue, float64(stats.Bytes.WriteTotal), volumeID)
+		for _, op := range stats.Operations {
+			// Skip the operations the mount never performed, NFSv4 mounts report about 60 of them.
+			if op.Requests == 0 {
+				continue
+			}
+			var retransmissions uint64
+			if op.Transmissions > op.Requests {
+				retransmissions = op.Transmissions - op.Requests
+			}
+			ch <- metrics.NewLazyConstMetric(nfsOperations, metrics.CounterValue, float64(op.Requests), volumeID, op.Operation)
+			ch <- metrics.NewLazyConstMetric(nfsOperationRetransmissions, metrics.CounterValue, float64(retransmissions), volumeID, op.Operation)
+			ch <- metrics.NewLazyConstMetric(nfsOperationMajorTimeouts, metrics.CounterValue, float64(op.MajorTimeouts), volumeID, op.Operation)
+			ch <- metrics.NewLazyConstMetric(nfsOperationErrors, metrics.CounterValue, float64(op.Errors), volumeID, op.Operation)
+			ch <- metrics.NewLazyConstMetric(nfsOperationRTTSeconds, metrics.CounterValue, float64(op.CumulativeTotalResponseMilliseconds)/1000, volumeID, op.Operation)
+			ch <- metrics.NewLazyConstMetric(nfsOperationRequestSeconds, metrics.CounterValue, float64(op.CumulativeTotalRequestMilliseconds)/1000, volumeID, op.Operation)
+		}
+	}
+}
+
+// mountStats parses the mountstats file of the driver process. The driver runs in the mount namespace of
+// the host, so it sees the mounts it staged.
+func (c *nfsMountStatsCollector) mountStats() ([]*procfs.Mount, error) {
+	fs, err := procfs.NewFS(c.procPath)
+	if err != nil {
+		return nil, err
+	}
+	proc, err := fs.Self()
+	if err != nil {
+		return nil, err
+	}
+	return proc.MountStats()
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+    http://www.apache.org/licenses/LICENSE-2.0
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"k8s.io/component-base/metrics"
+)
+
+const (
+	testStagingPath      = "/var/lib/kubelet/plugins/kubernetes.io/csi/filestore.csi.storage.gke.io/abc/globalmount"
+	testOtherStagingPath = "/var/lib/kubelet/plugins/kubernetes.io/csi/filestore.csi.storage.gke.io/def/globalmount"
+	testVolumeID         = "modeInstance/us-central1-c/myinstance/vol1"
+
+	// The mounts of a staged volume, one of its publish paths, a volume staged by another driver and a
+	// local filesystem.
+	testMountStats = `device rootfs mounted on / with fstype rootfs
+device 10.0.0.2:/vol1 mounted on ` + testStagingPath + ` with fstype nfs statvers=1.1
+	opts:	rw,vers=3,rsize=1048576,wsize=1048576,namlen=255,hard,proto=tcp,timeo=600,retrans=2,sec=sys,mountaddr=10.0.0.2,mountvers=3,mountproto=tcp,local_lock=none
+	age:	13968
+	caps:	caps=0x3fc7,wtmult=4096,dtsize=1048576,bsize=0,namlen=255
+	sec:	flavor=1,pseudoflavor=1
+	events:	52 226 0 0 1 13 398 0 0 331 0 47 0 0 77 0 0 77 0 0 0 0 0 0 0 0 0
+	bytes:	1207640230 0 0 0 1210214218 4096 295483 0
+	RPC iostats version: 1.0  p/v: 100003/3 (nfs)
+	xprt:	tcp 832 0 1 0 11 6428 6428 0 12154 0 24 26 5726
+	per-op statistics
+	        NULL: 0 0 0 0 0 0 0 0
+	     GETATTR: 100 103 1 16400 11200 10 2500 3000 2
+	        READ: 1298 1298 0 207680 1210292152 6 79386 79407
+
+device 10.0.0.2:/vol1 mounted on /var/lib/kubelet/pods/uid/volumes/kubernetes.io~csi/pv/mount with fstype nfs statvers=1.1
+	opts:	rw,vers=3
+	age:	13968
+	bytes:	1207640230 0 0 0 1210214218 4096 295483 0
+	RPC iostats version: 1.0  p/v: 100003/3 (nfs)
+	xprt:	tcp 832 0 1 0 11 6428 6428 0 12154 0 24 26 5726
+	per-op statistics
+	     GETATTR: 100 103 1 16400 11200 10 2500 3000 2
+
+device 10.0.0.3:/vol2 mounted on /mnt/other with fstype nfs statvers=1.1
+	opts:	rw,vers=3
+	age:	100
+	bytes:	1 0 0 0 1 1 0 0
+	RPC iostats version: 1.0  p/v: 100003/3 (nfs)
+	xprt:	tcp 832 0 1 0 11 6428 6428 0 12154 0 24 26 5726
+	per-op statistics
+	     GETATTR: 1 1 0 164 112 0 1 1 0
+
+device /dev/sda1 mounted on /var/lib/kubelet with fstype ext4
+`
+)
+
+// newTestProcPath returns a proc filesystem where the mountstats of the current process are testMountStats.
+func newTestProcPath(t *testing.T) string {
+	procPath := t.TempDir()
+	if err := os.Mkdir(filepath.Join(procPath, "1"), 0755); err != nil {
+		t.Fatalf("Failed to create proc dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(procPath, "1", "mountstats"), []byte(testMountStats), 0644); err != nil {
+		t.Fatalf("Failed to write mountstats: %v", err)
+	}
+	if err := os.Symlink("1", filepath.Join(procPath, "self")); err != nil {
+		t.Fatalf("Failed to link self: %v", err)
+	}
+	return procPath
+}
+
+func TestNFSMountStatsCollector(t *testing.T) {
+	cases := []struct {
+		name          string
+		stagedVolumes map[string]string
+		expected      map[string]float64
+	}{
+		{
+			name:          "staged volume",
+			stagedVolumes: map[string]string{testStagingPath: testVolumeID, testOtherStagingPath: "modeInstance/us-central1-c/other/vol1"},
+			expected: map[string]float64{
+				"filestorecsi_nfs_read_bytes_total{volume_id=" + testVolumeID + "}":                                  1210214218,
+				"filestorecsi_nfs_write_bytes_total{volume_id=" + testVolumeID + "}":                                 4096,
+				"filestorecsi_nfs_operations_total{operation=GETATTR,volume_id=" + testVolumeID + "}":                100,
+				"filestorecsi_nfs_operations_total{operation=READ,volume_id=" + testVolumeID + "}":                   1298,
+				"filestorecsi_nfs_operation_retransmissions_total{operation=GETATTR,volume_id=" + testVolumeID + "}": 3,
+				"filestorecsi_nfs_operation_retransmissions_total{operation=READ,volume_id=" + testVolumeID + "}":    0,
+				"filestorecsi_nfs_operation_major_timeouts_total{operation=GETATTR,volume_id=" + testVolumeID + "}":  1,
+				"filestorecsi_nfs_operation_major_timeouts_total{operation=READ,volume_id=" + testVolumeID + "}":     0,
+				"filestorecsi_nfs_operation_errors_total{operation=GETATTR,volume_id=" + testVolumeID + "}":          2,
+				"filestorecsi_nfs_operation_errors_total{operation=READ,volume_id=" + testVolumeID + "}":             0,
+				"filestorecsi_nfs_operation_rtt_seconds_total{operation=GETATTR,volume_id=" + testVolumeID + "}":     2.5,
+				"filestorecsi_nfs_operation_rtt_seconds_total{operation=READ,volume_id=" + testVolumeID + "}":        79.386,
+				"filestorecsi_nfs_operation_request_seconds_total{operation=GETATTR,volume_id=" + testVolumeID + "}": 3,
+				"filestorecsi_nfs_operation_request_seconds_total{operation=READ,volume_id=" + testVolumeID + "}":    79.407,
+			},
+		},
+		{
+			name:          "no staged volumes",
+			stagedVolumes: map[string]string{},
+			expected:      map[string]float64{},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			registry := metrics.NewKubeRegistry()
+			registry.CustomMustRegister(newNFSMountStatsCollector(newTestProcPath(t), func() map[string]string { return tc.stagedVolumes }))
+			families, err := registry.Gather()
+			if err != nil {
+				t.Fatalf("Error fetching metrics: %v", err)
+			}
+
+			got := map[string]float64{}
+			for _, family := range families {
+				for _, m := range family.GetMetric() {
+					name := family.GetName() + "{"
+					for i, label := range m.GetLabel() {
+						if i > 0 {
+							name += ","
+						}
+						name += label.GetName() + "=" + label.GetValue()
+					}
+					got[name+"}"] = m.GetCounter().GetValue()
+				}
+			}
+			if diff := cmp.Diff(tc.expected, got); diff != "" {
+				t.Errorf("unexpected metrics (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestNFSMountStatsCollectorMissingProc(t *testing.T) {
+	registry := metrics.NewKubeRegistry()
+	registry.CustomMustRegister(newNFSMountStatsCollector(filepath.Join(t.TempDir(), "proc"), func() map[string]string {
+		return map[string]string{testStagingPath: testVolumeID}
+	}))
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Error fetching metrics: %v", err)
+	}
+	if len(families) != 0 {
+		t.Errorf("got metrics %v without mountstats", families)
+	}
+}
@@ -152,6 +152,9 @@ type LockReleaseControllerConfig struct {
 	SyncPeriod time.Duration
 	// HTTP endpoint and path to emit NFS lock release metrics.
 	MetricEndpoint, MetricPath string
+	// Metrics manager of the node driver already serving metrics at MetricEndpoint, the NFS lock release
+	// metrics are registered to it when set.
+	MetricsManager *metrics.MetricsManager
 }
 
 func NewLockReleaseController(
@@ -191,9 +194,12 @@ func NewLockReleaseController(
 		lockService:      lockService,
 	}
 
-	if config.MetricEndpoint != "" {
-		mm := metrics.NewMetricsManager()
-		mm.InitializeHttpHandler(config.MetricEndpoint, config.MetricPath)
+	if config.MetricsManager != nil || config.MetricEndpoint != "" {
+		mm := config.MetricsManager
+		if mm == nil {
+			mm = metrics.NewMetricsManager()
+			mm.InitializeHttpHandler(config.MetricEndpoint, config.MetricPath)
+		}
 		mm.RegisterKubeAPIDurationMetric()
 		mm.RegisterLockReleaseCountnMetric()
 		lc.metricsManager = mm
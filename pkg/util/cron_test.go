@@ -0,0 +1,125 @@
+/*
+Copyright 2024 The Kubernetes Authors.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package util
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// A Wednesday.
+	from := time.Date(2024, time.January, 10, 10, 30, 15, 0, time.UTC)
+	cases := []struct {
+		name      string
+		spec      string
+		expected  time.Time
+		expectErr bool
+	}{
+		{
+			name:     "every minute",
+			spec:     "* * * * *",
+			expected: time.Date(2024, time.January, 10, 10, 31, 0, 0, time.UTC),
+		},
+		{
+			name:     "daily at 2am",
+			spec:     "0 2 * * *",
+			expected: time.Date(2024, time.January, 11, 2, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "every 15 minutes",
+			spec:     "*/15 * * * *",
+			expected: time.Date(2024, time.January, 10, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name:     "list of hours",
+			spec:     "0 9,12,18 * * *",
+			expected: time.Date(2024, time.January, 10, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekdays range",
+			spec:     "0 8 * * 1-5",
+			expected: time.Date(2024, time.January, 11, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "sunday as 7",
+			spec:     "0 0 * * 7",
+			expected: time.Date(2024, time.January, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day of month or day of week",
+			spec:     "0 0 1 * 5",
+			expected: time.Date(2024, time.January, 12, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "leap day",
+			spec:     "0 0 29 2 *",
+			expected: time.Date(2024, time.February, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "monthly descriptor",
+			spec:     "@monthly",
+			expected: time.Date(2024, time.February, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "value with step",
+			spec:     "5/20 * * * *",
+			expected: time.Date(2024, time.January, 10, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "never runs",
+			spec: "0 0 30 2 *",
+		},
+		{
+			name:      "too few fields",
+			spec:      "0 2 * *",
+			expectErr: true,
+		},
+		{
+			name:      "out of range",
+			spec:      "60 * * * *",
+			expectErr: true,
+		},
+		{
+			name:      "invalid range",
+			spec:      "0 10-2 * * *",
+			expectErr: true,
+		},
+		{
+			name:      "invalid step",
+			spec:      "*/0 * * * *",
+			expectErr: true,
+		},
+	}
+
+	for _, test := range cases {
+		schedule, err := ParseCronSchedule(test.spec)
+		if test.expectErr {
+			if err == nil {
+				t.Errorf("test %q failed: expected error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("test %q failed: unexpected error %v", test.name, err)
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(test.expected) {
+			t.Errorf("test %q failed: got %v, expected %v", test.name, next, test.expected)
+		}
+	}
+}
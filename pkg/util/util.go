@@ -41,13 +41,18 @@ const (
 	VolumeSnapshotLocationKey  = "location"
 	VolumeSnapshotTypeSnapshot = "snapshot"
 	VolumeSnapshotTypeBackup   = "backup"
+	// VolumeSnapshotCopyLocationsKey is a comma separated list of regions a backup is copied to.
+	VolumeSnapshotCopyLocationsKey = "backup-copy-locations"
 
-	SnapshotHandleBackupKey = "backups"
+	SnapshotHandleBackupKey   = "backups"
+	SnapshotHandleSnapshotKey = "snapshots"
 
-	// number of elements in a snapshot Id.
+	// number of elements in a backup snapshot Id.
 	// For backups: projects/{project name}/locations/{region}/backups/{name}
-	// For snapshot: projects/{project name}/locations/{zone}/snapshots/{name}
 	snapshotTotalElements = 6
+	// number of elements in a Filestore snapshot Id.
+	// For snapshots: projects/{project name}/locations/{location}/instances/{instance}/snapshots/{name}
+	instanceSnapshotTotalElements = 8
 
 	// number of elements in backup Volume sources e.g. projects/{project name}/locations/{zone}/instances/{name}
 	singleShareVolumeTotalElements = 6
@@ -189,12 +194,44 @@ func IsSnapshotTypeSupported(params map[string]string) (bool, error) {
 	if !ok {
 		return false, fmt.Errorf("Volume snapshot type is missing")
 	}
-	if snapType != VolumeSnapshotTypeBackup {
+	if snapType != VolumeSnapshotTypeBackup && snapType != VolumeSnapshotTypeSnapshot {
 		return false, fmt.Errorf("Volume snapshot type %q not supported", snapType)
 	}
 	return true, nil
 }
 
+// GetSnapshotType returns the volume snapshot type of the parameters, defaulting to backup.
+func GetSnapshotType(params map[string]string) string {
+	if snapType, ok := params[VolumeSnapshotTypeKey]; ok {
+		return snapType
+	}
+	return VolumeSnapshotTypeBackup
+}
+
+// IsSnapshotHandle returns true if the handle is the name of a Filestore snapshot of an instance.
+func IsSnapshotHandle(handle string) bool {
+	_, _, _, _, err := ParseSnapshotURI(handle)
+	return err == nil
+}
+
+func ParseSnapshotURI(snapshotURI string) (string, string, string, string, error) {
+	// Expected snapshot URI projects/<project-name>/locations/<location-name>/instances/<instance-name>/snapshots/<snapshot-name>
+	splitStr := strings.Split(snapshotURI, "/")
+	if len(splitStr) != instanceSnapshotTotalElements || splitStr[0] != "projects" || splitStr[2] != "locations" || splitStr[4] != "instances" || splitStr[6] != SnapshotHandleSnapshotKey {
+		return "", "", "", "", fmt.Errorf("Unknown snapshot URI format %q", snapshotURI)
+	}
+
+	project := splitStr[1]
+	location := splitStr[3]
+	instanceName := splitStr[5]
+	snapshotName := splitStr[7]
+	if project == "" || location == "" || instanceName == "" || snapshotName == "" {
+		return "", "", "", "", fmt.Errorf("Unknown snapshot URI format %q", snapshotURI)
+	}
+
+	return project, location, instanceName, snapshotName, nil
+}
+
 func GetBackupLocation(params map[string]string) string {
 	location := ""
 	if params == nil {
@@ -205,6 +242,21 @@ func GetBackupLocation(params map[string]string) string {
 	return location
 }
 
+// GetBackupCopyLocations returns the regions of the backup-copy-locations parameter, without duplicates.
+func GetBackupCopyLocations(params map[string]string) []string {
+	var locations []string
+	seen := make(map[string]bool)
+	for _, location := range strings.Split(params[VolumeSnapshotCopyLocationsKey], ",") {
+		location = strings.TrimSpace(location)
+		if location == "" || seen[location] {
+			continue
+		}
+		seen[location] = true
+		locations = append(locations, location)
+	}
+	return locations
+}
+
 func BackupVolumeSourceToCSIVolumeHandle(mode, sourceInstance, sourceShare string) (string, error) {
 	splitId := strings.Split(sourceInstance, "/")
 	if mode == "modeInstance" {
@@ -408,6 +408,63 @@ func TestParseShareURI(t *testing.T) {
 
 }
 
+func TestParseSnapshotURI(t *testing.T) {
+	tests := []struct {
+		name         string
+		snapshoturi  string
+		expectErr    bool
+		project      string
+		location     string
+		instancename string
+		snapshotname string
+	}{
+		{
+			name:        "invalid uri",
+			snapshoturi: "a/b/c/d/e",
+			expectErr:   true,
+		},
+		{
+			name:      "empty uri",
+			expectErr: true,
+		},
+		{
+			name:        "share uri",
+			snapshoturi: "projects/" + testProject + "/locations/" + testRegion + "/instances/" + testInstanceName + "/shares/" + testShareName,
+			expectErr:   true,
+		},
+		{
+			name:        "backup uri",
+			snapshoturi: "projects/" + testProject + "/locations/" + testRegion + "/backups/" + testShareName,
+			expectErr:   true,
+		},
+		{
+			name:         "valid uri",
+			snapshoturi:  "projects/" + testProject + "/locations/" + testRegion + "/instances/" + testInstanceName + "/snapshots/snap",
+			project:      testProject,
+			location:     testRegion,
+			instancename: testInstanceName,
+			snapshotname: "snap",
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			p, l, n, s, err := ParseSnapshotURI(tc.snapshoturi)
+			if !tc.expectErr && err != nil {
+				t.Error("unexpected error")
+			}
+			if tc.expectErr && err == nil {
+				t.Error("expected error, got none")
+			}
+			if p != tc.project || l != tc.location || n != tc.instancename || s != tc.snapshotname {
+				t.Errorf("mismatch")
+			}
+			if IsSnapshotHandle(tc.snapshoturi) == tc.expectErr {
+				t.Errorf("IsSnapshotHandle(%q) = %v, expected %v", tc.snapshoturi, tc.expectErr, !tc.expectErr)
+			}
+		})
+	}
+}
+
 func TestAlignBytes(t *testing.T) {
 	tests := []struct {
 		name        string
@@ -19,6 +19,7 @@ import (
 	"testing"
 
 	sanity "github.com/kubernetes-csi/csi-test/v3/pkg/sanity"
+	ginkgoconfig "github.com/onsi/ginkgo/config"
 	"google.golang.org/grpc"
 	mount "k8s.io/mount-utils"
 	cloud "sigs.k8s.io/gcp-filestore-csi-driver/pkg/cloud_provider"
@@ -92,5 +93,8 @@ func TestSanity(t *testing.T) {
 		IDGen:          &sanity.DefaultIDGenerator{},
 		TestVolumeSize: int64(1 * Tb),
 	}
+	// csi-test v3 fails on capabilities introduced after it was released, such as GET_VOLUME
+	// and VOLUME_CONDITION, so the capability enumeration specs are skipped.
+	ginkgoconfig.GinkgoConfig.SkipStrings = append(ginkgoconfig.GinkgoConfig.SkipStrings, "ControllerGetCapabilities should return appropriate capabilities")
 	sanity.Test(t, testConfig)
 }